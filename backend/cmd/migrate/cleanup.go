@@ -28,6 +28,13 @@ func CleanupDatabase(config *MigrateConfig) error {
 	}
 
 	// 清理顺序：先清理关联数据，再清理主数据
+	log.Println("清理测试单数据...")
+	for _, table := range []string{"test_case_step_attachments", "test_case_steps", "test_case_bugs", "test_case_requirements", "test_cases"} {
+		if err := db.Exec("DELETE FROM " + table).Error; err != nil {
+			log.Printf("清理%s失败: %v", table, err)
+		}
+	}
+
 	log.Println("清理Bug数据...")
 	if err := db.Exec("DELETE FROM bugs").Error; err != nil {
 		log.Printf("清理Bug失败: %v", err)
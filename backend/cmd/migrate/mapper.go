@@ -44,6 +44,21 @@ func ConvertRequirementStatus(status string) string {
 	return "draft"
 }
 
+// ConvertRequirementLevel 根据禅道需求类型和父子关系转换需求层级（对应默认层级配置 epic -> feature -> story）
+// 业务需求（epic）-> epic，用户需求（requirement）-> feature，有子需求的软件需求（parent=-1）-> feature，其余 -> story
+func ConvertRequirementLevel(storyType string, parent int) string {
+	switch strings.ToLower(storyType) {
+	case "epic":
+		return "epic"
+	case "requirement":
+		return "feature"
+	}
+	if parent == -1 {
+		return "feature"
+	}
+	return "story"
+}
+
 // ConvertTaskStatus 转换任务状态（直接使用禅道状态值）
 func ConvertTaskStatus(status string) string {
 	// 验证状态值是否合法
@@ -96,6 +111,20 @@ func ConvertTestCaseStatus(status string) string {
 	return "wait"
 }
 
+// ConvertTestCaseResult 转换测试单最近一次执行结果（禅道的 n/a 和空值视为未执行）
+func ConvertTestCaseResult(result string) string {
+	switch strings.ToLower(result) {
+	case "pass":
+		return "pass"
+	case "fail":
+		return "fail"
+	case "blocked":
+		return "blocked"
+	default:
+		return ""
+	}
+}
+
 // ConvertVersionStatus 转换版本状态（直接使用禅道状态值）
 func ConvertVersionStatus(status string) string {
 	// 验证状态值是否合法
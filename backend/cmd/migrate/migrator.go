@@ -31,12 +31,15 @@ type Migrator struct {
 	versionIDMap     map[int]uint
 	taskIDMap        map[int]uint // 任务ID映射
 	bugIDMap         map[int]uint // Bug ID映射
+	testCaseIDMap    map[int]uint // 测试单ID映射
 	actionIDMap      map[int]uint // Action ID映射
 
 	// 统计信息
 	stats struct {
 		taskCount          int
 		bugCount           int
+		testCaseCount      int
+		testCaseStepCount  int
 		projectMemberCount int
 		moduleCount        int
 		versionCount       int
@@ -57,6 +60,7 @@ func NewMigrator(config *MigrateConfig) (*Migrator, error) {
 		versionIDMap:     make(map[int]uint),
 		taskIDMap:        make(map[int]uint),
 		bugIDMap:         make(map[int]uint),
+		testCaseIDMap:    make(map[int]uint),
 		actionIDMap:      make(map[int]uint),
 	}
 
@@ -142,17 +146,22 @@ func (m *Migrator) MigrateAll() error {
 		return fmt.Errorf("迁移Bug失败: %w", err)
 	}
 
-	// 10. 迁移项目成员
+	// 10. 迁移测试单及测试步骤（需要在需求和Bug迁移完成后）
+	if err := m.MigrateTestCases(); err != nil {
+		return fmt.Errorf("迁移测试单失败: %w", err)
+	}
+
+	// 11. 迁移项目成员
 	if err := m.MigrateProjectMembers(); err != nil {
 		return fmt.Errorf("迁移项目成员失败: %w", err)
 	}
 
-	// 11. 迁移操作历史记录（需要在所有实体迁移完成后）
+	// 12. 迁移操作历史记录（需要在所有实体迁移完成后）
 	if err := m.MigrateActions(); err != nil {
 		return fmt.Errorf("迁移操作历史记录失败: %w", err)
 	}
 
-	// 12. 迁移字段变更历史记录（需要在操作历史记录迁移完成后）
+	// 13. 迁移字段变更历史记录（需要在操作历史记录迁移完成后）
 	if err := m.MigrateHistories(); err != nil {
 		return fmt.Errorf("迁移字段变更历史记录失败: %w", err)
 	}
@@ -168,6 +177,7 @@ func (m *Migrator) MigrateAll() error {
 	log.Printf("  - 需求: %d 个", len(m.requirementIDMap))
 	log.Printf("  - 任务: %d 个", m.stats.taskCount)
 	log.Printf("  - Bug: %d 个", m.stats.bugCount)
+	log.Printf("  - 测试单: %d 个（测试步骤 %d 条）", m.stats.testCaseCount, m.stats.testCaseStepCount)
 	log.Printf("  - 项目成员: %d 个", m.stats.projectMemberCount)
 	log.Printf("  - 项目模块: %d 个", m.stats.moduleCount)
 	log.Printf("  - 版本: %d 个", m.stats.versionCount)
@@ -846,6 +856,8 @@ func (m *Migrator) MigrateRequirements() error {
 		AssignedTo string  `gorm:"column:assignedTo"`
 		Estimate   float64 `gorm:"column:estimate"`
 		Deleted    string  `gorm:"column:deleted"`
+		Parent     int     `gorm:"column:parent"` // 父需求ID，-1 表示有子需求，0 表示没有父需求
+		Type       string  `gorm:"column:type"`   // 需求类型：story(软件需求), requirement(用户需求), epic(业务需求)
 	}
 
 	type ZenTaoStorySpec struct {
@@ -939,6 +951,7 @@ func (m *Migrator) MigrateRequirements() error {
 			Status:         ConvertRequirementStatus(zs.Status),
 			Priority:       ConvertPriority(zs.Pri),
 			ProjectID:      projectID,
+			Level:          ConvertRequirementLevel(zs.Type, zs.Parent),
 			CreatorID:      creatorID,
 			AssigneeID:     assigneeID,
 			EstimatedHours: DaysToHours(zs.Estimate),
@@ -953,6 +966,43 @@ func (m *Migrator) MigrateRequirements() error {
 		log.Printf("迁移需求: %s (ID: %d -> %d)", requirement.Title, zs.ID, requirement.ID)
 	}
 
+	// 所有需求创建后再建立父子关系：zt_story.parent 为父需求，
+	// 没有父需求的软件需求再按 zt_relation 中用户需求的细分关系（subdivideinto）挂到用户需求下
+	parents := make(map[int]int)
+	for _, zs := range zentaoStories {
+		if zs.Parent > 0 {
+			parents[zs.ID] = zs.Parent
+		}
+	}
+	type ZenTaoRelation struct {
+		AID int `gorm:"column:AID"`
+		BID int `gorm:"column:BID"`
+	}
+	var relations []ZenTaoRelation
+	if err := m.zenTaoDB.Table("zt_relation").
+		Where("AType = ? AND BType = ? AND relation = ?", "requirement", "story", "subdivideinto").
+		Find(&relations).Error; err == nil {
+		for _, relation := range relations {
+			if _, ok := parents[relation.BID]; !ok {
+				parents[relation.BID] = relation.AID
+			}
+		}
+	}
+	linked := 0
+	for storyID, parentStoryID := range parents {
+		childID, ok := m.requirementIDMap[storyID]
+		parentID, parentOK := m.requirementIDMap[parentStoryID]
+		if !ok || !parentOK || childID == parentID {
+			continue
+		}
+		if err := m.prjFlowDB.Model(&model.Requirement{}).Where("id = ?", childID).Update("parent_id", parentID).Error; err != nil {
+			log.Printf("设置需求 %d 的父需求失败: %v", childID, err)
+			continue
+		}
+		linked++
+	}
+	log.Printf("建立需求父子关系 %d 条", linked)
+
 	log.Printf("需求迁移完成，共迁移 %d 个需求", len(m.requirementIDMap))
 	return nil
 }
@@ -1197,6 +1247,189 @@ func (m *Migrator) MigrateBugs() error {
 	return nil
 }
 
+// MigrateTestCases 迁移测试单（zt_case）及其全部版本的测试步骤（zt_casestep）
+func (m *Migrator) MigrateTestCases() error {
+	log.Println("开始迁移测试单...")
+
+	type ZenTaoCase struct {
+		ID            int    `gorm:"column:id"`
+		Project       int    `gorm:"column:project"`
+		Story         int    `gorm:"column:story"`
+		Title         string `gorm:"column:title"`
+		Precondition  string `gorm:"column:precondition"`
+		Type          string `gorm:"column:type"`
+		Status        string `gorm:"column:status"`
+		LastRunResult string `gorm:"column:lastRunResult"`
+		Version       int    `gorm:"column:version"`
+		OpenedBy      string `gorm:"column:openedBy"`
+	}
+
+	var zentaoCases []ZenTaoCase
+	if err := m.zenTaoDB.Table("zt_case").Where("deleted = '0'").Find(&zentaoCases).Error; err != nil {
+		return err
+	}
+
+	log.Printf("找到 %d 个测试单", len(zentaoCases))
+
+	for _, zc := range zentaoCases {
+		// 获取项目ID：优先使用用例自身的项目，否则使用 zt_projectcase 中关联的第一个项目
+		var projectID uint
+		if newID, ok := m.projectIDMap[zc.Project]; ok && zc.Project > 0 {
+			projectID = newID
+		} else {
+			var projectIDs []int
+			m.zenTaoDB.Table("zt_projectcase").Where("`case` = ?", zc.ID).Order("project ASC").Pluck("project", &projectIDs)
+			for _, id := range projectIDs {
+				if newID, ok := m.projectIDMap[id]; ok {
+					projectID = newID
+					break
+				}
+			}
+		}
+
+		if projectID == 0 {
+			log.Printf("测试单 %s 没有项目ID（可能是用例库中的用例），跳过", zc.Title)
+			continue
+		}
+
+		// 获取创建者ID
+		var creatorID uint
+		if zc.OpenedBy != "" {
+			type ZenTaoUserID struct {
+				ID int `gorm:"column:id"`
+			}
+			var userID ZenTaoUserID
+			if err := m.zenTaoDB.Table("zt_user").Where("account = ?", zc.OpenedBy).First(&userID).Error; err == nil {
+				if newID, ok := m.userIDMap[userID.ID]; ok {
+					creatorID = newID
+				}
+			}
+		}
+
+		var types model.StringArray
+		if zc.Type != "" {
+			types = model.StringArray{zc.Type}
+		}
+		stepVersion := zc.Version
+		if stepVersion < 1 {
+			stepVersion = 1
+		}
+
+		testCase := model.TestCase{
+			Name:        zc.Title,
+			Description: zc.Precondition,
+			Types:       types,
+			Status:      ConvertTestCaseStatus(zc.Status),
+			Result:      ConvertTestCaseResult(zc.LastRunResult),
+			ProjectID:   projectID,
+			CreatorID:   creatorID,
+			StepVersion: stepVersion,
+		}
+
+		if err := m.prjFlowDB.Create(&testCase).Error; err != nil {
+			log.Printf("创建测试单失败: %s, 错误: %v", testCase.Name, err)
+			continue
+		}
+
+		m.testCaseIDMap[zc.ID] = testCase.ID
+
+		// 关联需求
+		if newID, ok := m.requirementIDMap[zc.Story]; ok && zc.Story > 0 {
+			if err := m.prjFlowDB.Create(&model.TestCaseRequirement{TestCaseID: testCase.ID, RequirementID: newID}).Error; err != nil {
+				log.Printf("关联测试单需求失败: %s, 错误: %v", testCase.Name, err)
+			}
+		}
+
+		if err := m.migrateTestCaseSteps(zc.ID, testCase.ID); err != nil {
+			log.Printf("迁移测试单步骤失败: %s, 错误: %v", testCase.Name, err)
+		}
+
+		m.stats.testCaseCount++
+		log.Printf("迁移测试单: %s (ID: %d -> %d)", testCase.Name, zc.ID, testCase.ID)
+	}
+
+	// 关联Bug（禅道中Bug通过 case 字段记录来源用例）
+	type ZenTaoCaseBug struct {
+		ID   int `gorm:"column:id"`
+		Case int `gorm:"column:case"`
+	}
+	var caseBugs []ZenTaoCaseBug
+	if err := m.zenTaoDB.Table("zt_bug").Select("id, `case`").Where("deleted = '0' AND `case` > 0").Find(&caseBugs).Error; err != nil {
+		log.Printf("查询测试单关联的Bug失败: %v", err)
+	}
+	for _, cb := range caseBugs {
+		testCaseID, ok := m.testCaseIDMap[cb.Case]
+		if !ok {
+			continue
+		}
+		bugID, ok := m.bugIDMap[cb.ID]
+		if !ok {
+			continue
+		}
+		if err := m.prjFlowDB.Create(&model.TestCaseBug{TestCaseID: testCaseID, BugID: bugID}).Error; err != nil {
+			log.Printf("关联测试单Bug失败: 测试单 %d, Bug %d, 错误: %v", testCaseID, bugID, err)
+		}
+	}
+
+	log.Printf("测试单迁移完成，共迁移 %d 个测试单，%d 条测试步骤", m.stats.testCaseCount, m.stats.testCaseStepCount)
+	return nil
+}
+
+// migrateTestCaseSteps 迁移测试单全部版本的步骤。禅道的分组步骤（group）和分组内的子步骤（item）
+// 按原顺序展开为普通步骤，子步骤的操作前加上分组名称
+func (m *Migrator) migrateTestCaseSteps(zenTaoCaseID int, testCaseID uint) error {
+	type ZenTaoCaseStep struct {
+		ID      int    `gorm:"column:id"`
+		Parent  int    `gorm:"column:parent"`
+		Version int    `gorm:"column:version"`
+		Type    string `gorm:"column:type"`
+		Desc    string `gorm:"column:desc"`
+		Expect  string `gorm:"column:expect"`
+	}
+
+	var zentaoSteps []ZenTaoCaseStep
+	if err := m.zenTaoDB.Table("zt_casestep").Where("`case` = ?", zenTaoCaseID).Order("version ASC, id ASC").Find(&zentaoSteps).Error; err != nil {
+		return err
+	}
+
+	groups := make(map[int]string)
+	sorts := make(map[int]int)
+	for _, zs := range zentaoSteps {
+		action := strings.TrimSpace(zs.Desc)
+		if zs.Type == "group" {
+			groups[zs.ID] = action
+			// 没有预期结果的分组只作为子步骤的标题
+			if strings.TrimSpace(zs.Expect) == "" {
+				continue
+			}
+		}
+		if zs.Type == "item" && groups[zs.Parent] != "" {
+			action = groups[zs.Parent] + " - " + action
+		}
+		if action == "" {
+			continue
+		}
+
+		version := zs.Version
+		if version < 1 {
+			version = 1
+		}
+		sorts[version]++
+		step := model.TestCaseStep{
+			TestCaseID: testCaseID,
+			Version:    version,
+			Sort:       sorts[version],
+			Action:     action,
+			Expected:   zs.Expect,
+		}
+		if err := m.prjFlowDB.Create(&step).Error; err != nil {
+			return err
+		}
+		m.stats.testCaseStepCount++
+	}
+	return nil
+}
+
 // MigrateProjectMembers 迁移项目成员
 func (m *Migrator) MigrateProjectMembers() error {
 	log.Println("开始迁移项目成员...")
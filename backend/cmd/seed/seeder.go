@@ -44,6 +44,18 @@ func (s *Seeder) ResetDatabase() error {
 	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.Resource{}).Error; err != nil {
 		log.Printf("警告: 删除资源失败: %v", err)
 	}
+	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.TestCaseStep{}).Error; err != nil {
+		log.Printf("警告: 删除测试步骤失败: %v", err)
+	}
+	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.SharedStepItem{}).Error; err != nil {
+		log.Printf("警告: 删除共享步骤失败: %v", err)
+	}
+	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.SharedStep{}).Error; err != nil {
+		log.Printf("警告: 删除共享步骤库失败: %v", err)
+	}
+	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.TestCaseRequirement{}).Error; err != nil {
+		log.Printf("警告: 删除测试单需求关联失败: %v", err)
+	}
 	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.TestCaseBug{}).Error; err != nil {
 		log.Printf("警告: 删除测试用例Bug关联失败: %v", err)
 	}
@@ -65,6 +77,12 @@ func (s *Seeder) ResetDatabase() error {
 	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.Task{}).Error; err != nil {
 		log.Printf("警告: 删除任务失败: %v", err)
 	}
+	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.RequirementReviewVote{}).Error; err != nil {
+		log.Printf("警告: 删除需求评审意见失败: %v", err)
+	}
+	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.RequirementReview{}).Error; err != nil {
+		log.Printf("警告: 删除需求评审失败: %v", err)
+	}
 	if err := s.db.Unscoped().Where("1 = 1").Delete(&model.Requirement{}).Error; err != nil {
 		log.Printf("警告: 删除需求失败: %v", err)
 	}
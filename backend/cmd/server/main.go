@@ -26,6 +26,7 @@ import (
 	"prjflow/internal/middleware"
 	"prjflow/internal/utils"
 	"prjflow/internal/websocket"
+	"prjflow/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -685,6 +686,15 @@ func main() {
 		log.Println("Database migrated successfully")
 	}
 
+	// 启用进程内权限缓存（角色权限、项目成员），写入相关表时自动失效
+	if err := permission.EnableCache(db); err != nil {
+		if utils.Logger != nil {
+			utils.Logger.Fatalf("Failed to enable permission cache: %v", err)
+		} else {
+			log.Fatalf("Failed to enable permission cache: %v", err)
+		}
+	}
+
 	// 初始化审计日志数据库（默认使用独立的审计数据库）
 	auditDB, err := utils.InitAuditDB()
 	if err != nil {
@@ -744,6 +754,10 @@ func main() {
 
 	// WebSocket路由
 	r.GET("/ws", websocket.HandleWebSocket)
+	// 项目事件流（需要JWT认证，按项目/对象主题订阅实时事件）
+	r.GET("/ws/events", websocket.HandleEventStream(db))
+	websocket.RegisterActionEvents()
+	websocket.RegisterNotificationPush()
 
 	// 微信验证文件路由（不需要认证，必须放在根路径）
 	// 支持格式：/MP_verify_xxxxx.txt
@@ -770,10 +784,12 @@ func main() {
 	// 认证相关路由
 	authHandler := api.NewAuthHandler(db)
 	userHandler := api.NewUserHandler(db)
+	accessTokenHandler := api.NewAccessTokenHandler(db)
 	authGroup := r.Group("/api/auth")
 	{
 	authGroup.POST("/login", authHandler.Login)                    // 用户名密码登录
 	authGroup.POST("/refresh", authHandler.RefreshToken)          // 刷新Token（不需要认证）
+	authGroup.POST("/ldap/login", authHandler.LDAPLogin)          // LDAP账号登录
 	// GetQRCode 不需要中间件认证：登录场景允许未登录访问，添加用户场景在函数内部检查权限
 	authGroup.GET("/wechat/qrcode", authHandler.GetQRCode)
 		authGroup.GET("/wechat/callback", authHandler.WeChatCallback)                   // 微信登录回调接口（GET请求，微信直接重定向到这里）
@@ -782,10 +798,36 @@ func main() {
 		authGroup.GET("/user/info", middleware.Auth(), authHandler.GetUserInfo)
 		authGroup.POST("/logout", middleware.Auth(), authHandler.Logout)
 		authGroup.POST("/change-password", middleware.Auth(), authHandler.ChangePassword) // 修改密码
+		authGroup.POST("/password-reset/request", authHandler.RequestPasswordReset)     // 申请重置密码（发送重置链接）
+		authGroup.POST("/password-reset/verify", authHandler.VerifyPasswordResetToken)  // 校验重置令牌
+		authGroup.POST("/password-reset/confirm", authHandler.ConfirmPasswordReset)     // 使用重置令牌设置新密码
+		// 登录会话管理
+		authGroup.GET("/sessions", middleware.Auth(), authHandler.GetSessions)            // 我的有效会话
+		authGroup.DELETE("/sessions", middleware.Auth(), authHandler.RevokeOtherSessions) // 注销其他会话
+		authGroup.DELETE("/sessions/:id", middleware.Auth(), authHandler.RevokeSession)   // 远程注销指定会话
+		// 两步验证
+		authGroup.POST("/2fa/verify", authHandler.VerifyTwoFactorLogin)                              // 登录第二步：校验两步验证码
+		authGroup.POST("/2fa/login-setup", authHandler.SetupTwoFactorLogin)                          // 登录时强制绑定两步验证
+		authGroup.GET("/2fa", middleware.Auth(), authHandler.GetTwoFactorStatus)                     // 两步验证状态
+		authGroup.POST("/2fa/setup", middleware.Auth(), authHandler.SetupTwoFactor)                  // 生成TOTP密钥
+		authGroup.POST("/2fa/enable", middleware.Auth(), authHandler.EnableTwoFactor)                // 启用两步验证
+		authGroup.POST("/2fa/disable", middleware.Auth(), authHandler.DisableTwoFactor)              // 关闭两步验证
+		authGroup.POST("/2fa/recovery-codes", middleware.Auth(), authHandler.RegenerateRecoveryCodes) // 重新生成恢复码
+		// 个人访问令牌
+		authGroup.GET("/tokens", middleware.Auth(), accessTokenHandler.GetMyTokens)          // 我的访问令牌
+		authGroup.POST("/tokens", middleware.Auth(), accessTokenHandler.CreateMyToken)       // 创建访问令牌
+		authGroup.DELETE("/tokens/:id", middleware.Auth(), accessTokenHandler.RevokeMyToken) // 吊销访问令牌
 		// 微信绑定相关路由
 		authGroup.GET("/wechat/bind/qrcode", middleware.Auth(), authHandler.GetWeChatBindQRCode) // 获取微信绑定二维码
 		authGroup.GET("/wechat/bind/callback", authHandler.WeChatBindCallback)                   // 微信绑定回调接口（GET请求，微信直接重定向到这里）
 		authGroup.POST("/wechat/unbind", middleware.Auth(), authHandler.UnbindWeChat)            // 解绑微信
+		// 外部身份提供方（OIDC单点登录、微信网页授权）
+		authGroup.GET("/idp", authHandler.GetIdentityProviders)                                    // 已启用的身份提供方
+		authGroup.GET("/idp/:provider/authorize", authHandler.IdentityAuthorize)                    // 发起外部登录
+		authGroup.POST("/idp/:provider/callback", authHandler.IdentityCallback)                     // 授权回调（前端提交code和state）
+		authGroup.POST("/idp/:provider/bind", middleware.Auth(), authHandler.BindIdentity)          // 发起外部账号绑定
+		authGroup.DELETE("/idp/:provider/bind", middleware.Auth(), authHandler.UnbindIdentity)      // 解除外部账号绑定
+		authGroup.GET("/identities", middleware.Auth(), authHandler.GetMyIdentities)                // 我绑定的外部账号
 	}
 
 	// 权限管理路由
@@ -828,6 +870,13 @@ func main() {
 		userGroup.POST("/wechat/add", middleware.RequirePermission(db, "user:create"), userHandler.AddUserByWeChat) // 扫码添加用户需要权限
 		// 注意：绑定微信接口需要在 /:id 之前，避免路由冲突
 		userGroup.GET("/:id/wechat/bind/qrcode", middleware.RequirePermission(db, "user:update"), userHandler.GetUserWeChatBindQRCode) // 获取用户绑定微信二维码（管理员操作）
+		userGroup.GET("/:id/sessions", middleware.RequirePermission(db, "user:read"), userHandler.GetUserSessions)                      // 查看用户登录会话
+		userGroup.POST("/:id/force-logout", middleware.RequirePermission(db, "user:update"), userHandler.ForceLogout)                   // 强制用户下线
+		userGroup.POST("/:id/unlock", middleware.RequirePermission(db, "user:update"), userHandler.UnlockUser)                          // 解除登录锁定
+		userGroup.DELETE("/:id/two-factor", middleware.RequirePermission(db, "user:update"), userHandler.ResetUserTwoFactor)             // 重置两步验证
+		userGroup.GET("/:id/tokens", middleware.RequirePermission(db, "user:read"), accessTokenHandler.GetUserTokens)                      // 查看用户访问令牌
+		userGroup.POST("/:id/tokens", middleware.RequirePermission(db, "user:update"), accessTokenHandler.CreateUserToken)                 // 为服务账号创建访问令牌
+		userGroup.DELETE("/:id/tokens/:token_id", middleware.RequirePermission(db, "user:update"), accessTokenHandler.RevokeUserToken)     // 吊销用户访问令牌
 		userGroup.GET("/:id", middleware.RequirePermission(db, "user:read"), userHandler.GetUser)                                      // 查看用户详情
 		userGroup.PUT("/:id", middleware.RequirePermission(db, "user:update"), userHandler.UpdateUser)                                 // 更新用户需要权限
 		userGroup.DELETE("/:id", middleware.RequirePermission(db, "user:delete"), userHandler.DeleteUser)                              // 删除用户需要权限
@@ -869,11 +918,52 @@ func main() {
 		tagGroup.DELETE("/:id", middleware.RequirePermission(db, "project:delete"), tagHandler.DeleteTag)
 	}
 
+	// 状态工作流路由（全局工作流仅管理员可修改，项目工作流需要项目访问权限）
+	workflowHandler := api.NewWorkflowHandler(db)
+	workflowGroup := r.Group("/api/workflows", middleware.Auth())
+	{
+		workflowGroup.GET("", middleware.RequirePermission(db, "project:read"), workflowHandler.GetWorkflows)
+		workflowGroup.GET("/effective", middleware.RequirePermission(db, "project:read"), workflowHandler.GetEffectiveWorkflow)
+		workflowGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), workflowHandler.GetWorkflow)
+		workflowGroup.POST("", middleware.RequirePermission(db, "project:manage"), workflowHandler.CreateWorkflow)
+		workflowGroup.PUT("/:id", middleware.RequirePermission(db, "project:manage"), workflowHandler.UpdateWorkflow)
+		workflowGroup.DELETE("/:id", middleware.RequirePermission(db, "project:manage"), workflowHandler.DeleteWorkflow)
+	}
+
+	// 通知中心路由（仅操作当前用户自己的通知）
+	notificationHandler := api.NewNotificationHandler(db)
+	notificationGroup := r.Group("/api/notifications", middleware.Auth())
+	{
+		notificationGroup.GET("", notificationHandler.GetNotifications)
+		notificationGroup.GET("/unread-count", notificationHandler.GetUnreadCount)
+		notificationGroup.PUT("/read-all", notificationHandler.MarkAllNotificationsRead)
+		notificationGroup.GET("/preferences", notificationHandler.GetNotificationPreferences)
+		notificationGroup.PUT("/preferences", notificationHandler.UpdateNotificationPreferences)
+		notificationGroup.PUT("/:id/read", notificationHandler.MarkNotificationRead)
+		notificationGroup.DELETE("/:id", notificationHandler.DeleteNotification)
+	}
+
+	// Webhook路由（管理员或项目负责人可管理，由处理函数检查）
+	webhookHandler := api.NewWebhookHandler(db)
+	webhookGroup := r.Group("/api/webhooks", middleware.Auth())
+	{
+		webhookGroup.GET("/events", webhookHandler.GetWebhookEvents)
+		webhookGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), webhookHandler.GetWebhook)
+		webhookGroup.PUT("/:id", middleware.RequirePermission(db, "project:read"), webhookHandler.UpdateWebhook)
+		webhookGroup.DELETE("/:id", middleware.RequirePermission(db, "project:read"), webhookHandler.DeleteWebhook)
+		webhookGroup.POST("/:id/ping", middleware.RequirePermission(db, "project:read"), webhookHandler.PingWebhook)
+		webhookGroup.GET("/:id/deliveries", middleware.RequirePermission(db, "project:read"), webhookHandler.GetWebhookDeliveries)
+		webhookGroup.GET("/:id/deliveries/:delivery_id", middleware.RequirePermission(db, "project:read"), webhookHandler.GetWebhookDelivery)
+		webhookGroup.POST("/:id/deliveries/:delivery_id/redeliver", middleware.RequirePermission(db, "project:read"), webhookHandler.RedeliverWebhook)
+	}
+
 	// 项目管理路由
 	projectHandler := api.NewProjectHandler(db)
 
 	// 看板管理路由（需要在项目路由之前定义，因为项目路由中会用到）
 	boardHandler := api.NewBoardHandler(db)
+	// 迭代管理路由
+	sprintHandler := api.NewSprintHandler(db)
 
 	projectGroup := r.Group("/api/projects", middleware.Auth())
 	{
@@ -881,10 +971,19 @@ func main() {
 		// 注意：统计接口、看板接口和甘特图接口需要在详情接口之前，避免路由冲突
 		projectGroup.GET("/:id/statistics", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectStatistics)
 		projectGroup.GET("/:id/progress", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectProgress)
+		projectGroup.GET("/:id/burndown", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectBurndown)
+		projectGroup.GET("/:id/flow-metrics", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectFlowMetrics)
 		projectGroup.GET("/:id/gantt", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectGantt)
+		projectGroup.GET("/:id/schedule", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectSchedule)
+		projectGroup.GET("/:id/dependencies/check", middleware.RequirePermission(db, "project:read"), projectHandler.CheckProjectDependencies)
+		projectGroup.GET("/:id/traceability", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectTraceability)
+		projectGroup.POST("/:id/schedule/auto", middleware.RequirePermission(db, "task:update"), projectHandler.AutoScheduleProject)
 		// 项目看板路由（需要在详情路由之前）
 		projectGroup.GET("/:id/boards", middleware.RequirePermission(db, "project:read"), boardHandler.GetProjectBoards)
 		projectGroup.POST("/:id/boards", middleware.RequirePermission(db, "project:manage"), boardHandler.CreateBoard)
+		// 项目迭代
+		projectGroup.GET("/:id/sprints", middleware.RequirePermission(db, "project:read"), sprintHandler.GetProjectSprints)
+		projectGroup.POST("/:id/sprints", middleware.RequirePermission(db, "project:update"), sprintHandler.CreateSprint)
 		projectGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), projectHandler.GetProject)
 		projectGroup.POST("", middleware.RequirePermission(db, "project:create"), projectHandler.CreateProject)
 		projectGroup.PUT("/:id", middleware.RequirePermission(db, "project:update"), projectHandler.UpdateProject)
@@ -894,9 +993,14 @@ func main() {
 		projectGroup.POST("/:id/history/note", middleware.RequirePermission(db, "project:update"), projectHandler.AddProjectHistoryNote)
 		// 项目成员管理
 		projectGroup.GET("/:id/members", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectMembers)
+		// 项目访问决策说明，如 ?permission=bug:update&user_id=1
+		projectGroup.GET("/:id/access", middleware.RequirePermission(db, "project:read"), projectHandler.GetProjectAccess)
 		projectGroup.POST("/:id/members", middleware.RequirePermission(db, "project:manage"), projectHandler.AddProjectMembers)
 		projectGroup.PUT("/:id/members/:member_id", middleware.RequirePermission(db, "project:manage"), projectHandler.UpdateProjectMember)
 		projectGroup.DELETE("/:id/members/:member_id", middleware.RequirePermission(db, "project:manage"), projectHandler.RemoveProjectMember)
+		// 项目Webhook（管理员或项目负责人可管理，由处理函数检查）
+		projectGroup.GET("/:id/webhooks", middleware.RequirePermission(db, "project:read"), webhookHandler.GetProjectWebhooks)
+		projectGroup.POST("/:id/webhooks", middleware.RequirePermission(db, "project:read"), webhookHandler.CreateProjectWebhook)
 	}
 
 	// 需求管理路由
@@ -904,6 +1008,8 @@ func main() {
 	requirementGroup := r.Group("/api/requirements", middleware.Auth())
 	{
 		requirementGroup.GET("/statistics", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirementStatistics)
+		requirementGroup.GET("/levels", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirementLevels)
+		requirementGroup.GET("/tree", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirementTree)
 		requirementGroup.GET("", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirements)
 		requirementGroup.GET("/:id", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirement)
 		requirementGroup.POST("", middleware.RequirePermission(db, "requirement:create"), requirementHandler.CreateRequirement)
@@ -911,6 +1017,10 @@ func main() {
 		requirementGroup.DELETE("/:id", middleware.RequirePermission(db, "requirement:delete"), requirementHandler.DeleteRequirement)
 		requirementGroup.PATCH("/:id/status", middleware.RequirePermission(db, "requirement:update"), requirementHandler.UpdateRequirementStatus)
 		requirementGroup.POST("/:id/assign", middleware.RequirePermission(db, "requirement:update"), requirementHandler.AssignRequirement)
+		requirementGroup.GET("/:id/reviews", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirementReviews)
+		requirementGroup.POST("/:id/reviews", middleware.RequirePermission(db, "requirement:update"), requirementHandler.StartRequirementReview)
+		requirementGroup.POST("/:id/reviews/vote", middleware.RequirePermission(db, "requirement:read"), requirementHandler.SubmitRequirementReview)
+		requirementGroup.POST("/:id/reviews/cancel", middleware.RequirePermission(db, "requirement:update"), requirementHandler.CancelRequirementReview)
 		// 需求历史记录
 		requirementGroup.GET("/:id/history", middleware.RequirePermission(db, "requirement:read"), requirementHandler.GetRequirementHistory)
 		requirementGroup.POST("/:id/history/note", middleware.RequirePermission(db, "requirement:update"), requirementHandler.AddRequirementHistoryNote)
@@ -953,6 +1063,7 @@ func main() {
 	taskGroup := r.Group("/api/tasks", middleware.Auth())
 	{
 		taskGroup.GET("", middleware.RequirePermission(db, "task:read"), taskHandler.GetTasks)
+		taskGroup.GET("/tree", middleware.RequirePermission(db, "task:read"), taskHandler.GetTaskTree)
 		taskGroup.GET("/:id", middleware.RequirePermission(db, "task:read"), taskHandler.GetTask)
 		taskGroup.POST("", middleware.RequirePermission(db, "task:create"), taskHandler.CreateTask)
 		taskGroup.PUT("/:id", middleware.RequirePermission(db, "task:update"), taskHandler.UpdateTask)
@@ -963,6 +1074,10 @@ func main() {
 		taskGroup.GET("/:id/history", middleware.RequirePermission(db, "task:read"), taskHandler.GetTaskHistory)
 		taskGroup.POST("/:id/history/note", middleware.RequirePermission(db, "task:update"), taskHandler.AddTaskHistoryNote)
 		taskGroup.PATCH("/:id/progress", middleware.RequirePermission(db, "task:update"), taskHandler.UpdateTaskProgress)
+		// 任务依赖关系
+		taskGroup.GET("/:id/dependencies", middleware.RequirePermission(db, "task:read"), taskHandler.GetTaskDependencies)
+		taskGroup.POST("/:id/dependencies", middleware.RequirePermission(db, "task:update"), taskHandler.AddTaskDependency)
+		taskGroup.DELETE("/:id/dependencies/:dependency_id", middleware.RequirePermission(db, "task:update"), taskHandler.RemoveTaskDependency)
 	}
 
 	// 看板管理路由（看板属于项目的一部分）
@@ -972,18 +1087,35 @@ func main() {
 		boardGroup.PUT("/:id", middleware.RequirePermission(db, "project:manage"), boardHandler.UpdateBoard)
 		boardGroup.DELETE("/:id", middleware.RequirePermission(db, "project:manage"), boardHandler.DeleteBoard)
 		boardGroup.GET("/:id/tasks", middleware.RequirePermission(db, "project:read"), boardHandler.GetBoardTasks)
+		boardGroup.GET("/:id/flow-metrics", middleware.RequirePermission(db, "project:read"), boardHandler.GetBoardFlowMetrics)
 		boardGroup.PATCH("/:id/tasks/:task_id/move", middleware.RequirePermission(db, "project:manage"), boardHandler.MoveTask)
 		boardGroup.POST("/:id/columns", middleware.RequirePermission(db, "project:manage"), boardHandler.CreateBoardColumn)
 		boardGroup.PUT("/:id/columns/:column_id", middleware.RequirePermission(db, "project:manage"), boardHandler.UpdateBoardColumn)
 		boardGroup.DELETE("/:id/columns/:column_id", middleware.RequirePermission(db, "project:manage"), boardHandler.DeleteBoardColumn)
 	}
 
+	// 迭代管理路由（迭代属于项目的一部分）
+	sprintGroup := r.Group("/api/sprints", middleware.Auth())
+	{
+		sprintGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), sprintHandler.GetSprint)
+		sprintGroup.PUT("/:id", middleware.RequirePermission(db, "project:update"), sprintHandler.UpdateSprint)
+		sprintGroup.DELETE("/:id", middleware.RequirePermission(db, "project:delete"), sprintHandler.DeleteSprint)
+		sprintGroup.POST("/:id/start", middleware.RequirePermission(db, "project:update"), sprintHandler.StartSprint)
+		sprintGroup.POST("/:id/close", middleware.RequirePermission(db, "project:update"), sprintHandler.CloseSprint)
+		sprintGroup.GET("/:id/burndown", middleware.RequirePermission(db, "project:read"), sprintHandler.GetSprintBurndown)
+		sprintGroup.GET("/:id/items", middleware.RequirePermission(db, "project:read"), sprintHandler.GetSprintItems)
+		sprintGroup.POST("/:id/items", middleware.RequirePermission(db, "project:update"), sprintHandler.AddSprintItems)
+		sprintGroup.DELETE("/:id/items", middleware.RequirePermission(db, "project:update"), sprintHandler.RemoveSprintItems)
+	}
+
 	// 版本管理路由（版本属于项目的一部分）
 	versionHandler := api.NewVersionHandler(db)
 	versionGroup := r.Group("/api/versions", middleware.Auth())
 	{
 		versionGroup.GET("", middleware.RequirePermission(db, "project:read"), versionHandler.GetVersions)
 		versionGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), versionHandler.GetVersion)
+		versionGroup.GET("/:id/burndown", middleware.RequirePermission(db, "project:read"), versionHandler.GetVersionBurndown)
+		versionGroup.GET("/:id/traceability", middleware.RequirePermission(db, "project:read"), versionHandler.GetVersionTraceability)
 		versionGroup.POST("", middleware.RequirePermission(db, "project:update"), versionHandler.CreateVersion)
 		versionGroup.PUT("/:id", middleware.RequirePermission(db, "project:update"), versionHandler.UpdateVersion)
 		versionGroup.DELETE("/:id", middleware.RequirePermission(db, "project:delete"), versionHandler.DeleteVersion)
@@ -998,12 +1130,24 @@ func main() {
 		testCaseGroup.GET("/statistics", middleware.RequirePermission(db, "project:read"), testCaseHandler.GetTestCaseStatistics)
 		testCaseGroup.GET("", middleware.RequirePermission(db, "project:read"), testCaseHandler.GetTestCases)
 		testCaseGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), testCaseHandler.GetTestCase)
+		testCaseGroup.GET("/:id/steps", middleware.RequirePermission(db, "project:read"), testCaseHandler.GetTestCaseSteps)
 		testCaseGroup.POST("", middleware.RequirePermission(db, "test-case:create"), testCaseHandler.CreateTestCase)
 		testCaseGroup.PUT("/:id", middleware.RequirePermission(db, "test-case:update"), testCaseHandler.UpdateTestCase)
 		testCaseGroup.DELETE("/:id", middleware.RequirePermission(db, "test-case:delete"), testCaseHandler.DeleteTestCase)
 		testCaseGroup.PATCH("/:id/status", middleware.RequirePermission(db, "test-case:update"), testCaseHandler.UpdateTestCaseStatus)
 	}
 
+	// 共享步骤库路由
+	sharedStepHandler := api.NewSharedStepHandler(db)
+	sharedStepGroup := r.Group("/api/shared-steps", middleware.Auth())
+	{
+		sharedStepGroup.GET("", middleware.RequirePermission(db, "project:read"), sharedStepHandler.GetSharedSteps)
+		sharedStepGroup.GET("/:id", middleware.RequirePermission(db, "project:read"), sharedStepHandler.GetSharedStep)
+		sharedStepGroup.POST("", middleware.RequirePermission(db, "test-case:create"), sharedStepHandler.CreateSharedStep)
+		sharedStepGroup.PUT("/:id", middleware.RequirePermission(db, "test-case:update"), sharedStepHandler.UpdateSharedStep)
+		sharedStepGroup.DELETE("/:id", middleware.RequirePermission(db, "test-case:delete"), sharedStepHandler.DeleteSharedStep)
+	}
+
 	// 资源管理路由 (统计、冲突检测、利用率分析)
 	resourceHandler := api.NewResourceHandler(db)
 	resourceGroup := r.Group("/api/resources", middleware.Auth())
@@ -1097,6 +1241,14 @@ func main() {
 		systemGroup.GET("/backup-config", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.GetBackupConfig)
 		systemGroup.POST("/backup-config", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.SaveBackupConfig)
 		systemGroup.POST("/backup/trigger", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.TriggerBackup)
+		systemGroup.POST("/email/test", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.SendTestEmail)
+		systemGroup.GET("/two-factor-policy", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.GetTwoFactorPolicy)
+		systemGroup.PUT("/two-factor-policy", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.SaveTwoFactorPolicy)
+		systemGroup.GET("/project-role-permissions", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.GetProjectRolePermissions)
+		systemGroup.PUT("/project-role-permissions", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.SaveProjectRolePermissions)
+		systemGroup.GET("/permission-cache", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.GetPermissionCacheStats)
+		systemGroup.DELETE("/permission-cache", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.ClearPermissionCache)
+		systemGroup.POST("/ldap/sync", middleware.RequirePermissionOptional(db, "system:settings"), systemHandler.SyncLDAPUsers)
 		// 日志管理路由
 		systemGroup.GET("/log-level", systemHandler.GetLogLevel)
 		systemGroup.POST("/log-level", middleware.RequirePermissionOptional(db, "log:settings"), systemHandler.SetLogLevel)
@@ -1231,6 +1383,26 @@ func main() {
 		log.Println("Backup scheduler started")
 	}
 
+	// 启动Webhook投递队列
+	utils.RegisterWebhookListener()
+	webhookDispatcher := utils.GetWebhookDispatcher(db)
+	webhookDispatcher.Start()
+	defer webhookDispatcher.Stop()
+
+	// 启动通知：状态变更通知、邮件发送队列和每日摘要/到期提醒
+	utils.RegisterNotificationListener()
+	emailDispatcher := utils.GetEmailDispatcher(db)
+	emailDispatcher.Start()
+	defer emailDispatcher.Stop()
+	digestScheduler := utils.GetDigestScheduler(db)
+	digestScheduler.Start()
+	defer digestScheduler.Stop()
+
+	// 启动LDAP目录定时同步（未启用LDAP时不执行）
+	ldapSyncScheduler := utils.GetLDAPSyncScheduler(db)
+	ldapSyncScheduler.Start()
+	defer ldapSyncScheduler.Stop()
+
 	// 启动服务器（异步）
 	go func() {
 		if utils.Logger != nil {
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AccessTokenHandler 个人访问令牌管理
+type AccessTokenHandler struct {
+	db *gorm.DB
+}
+
+func NewAccessTokenHandler(db *gorm.DB) *AccessTokenHandler {
+	return &AccessTokenHandler{db: db}
+}
+
+type createAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required"` // 权限代码列表，如 ["bug:create", "task:update"]
+	ExpiresInDays int      `json:"expires_in_days"`           // 有效天数，默认 90 天，最长 365 天
+}
+
+// GetMyTokens 获取当前用户的访问令牌
+func (h *AccessTokenHandler) GetMyTokens(c *gin.Context) {
+	h.listTokens(c, utils.GetUserID(c))
+}
+
+// CreateMyToken 为当前用户创建访问令牌
+func (h *AccessTokenHandler) CreateMyToken(c *gin.Context) {
+	if !h.requireInteractiveLogin(c) {
+		return
+	}
+	var user model.User
+	if err := h.db.First(&user, utils.GetUserID(c)).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	h.createToken(c, &user)
+}
+
+// RevokeMyToken 吊销当前用户的访问令牌
+func (h *AccessTokenHandler) RevokeMyToken(c *gin.Context) {
+	h.revokeToken(c, utils.GetUserID(c), c.Param("id"))
+}
+
+// GetUserTokens 获取指定用户的访问令牌（管理员操作）
+func (h *AccessTokenHandler) GetUserTokens(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	h.listTokens(c, user.ID)
+}
+
+// CreateUserToken 为服务账号创建访问令牌（管理员操作）
+func (h *AccessTokenHandler) CreateUserToken(c *gin.Context) {
+	if !h.requireInteractiveLogin(c) {
+		return
+	}
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	if !user.IsServiceAccount {
+		utils.Error(c, 400, "只能为服务账号创建访问令牌，普通用户请自行创建")
+		return
+	}
+	h.createToken(c, &user)
+}
+
+// RevokeUserToken 吊销指定用户的访问令牌（管理员操作）
+func (h *AccessTokenHandler) RevokeUserToken(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	h.revokeToken(c, user.ID, c.Param("token_id"))
+}
+
+func (h *AccessTokenHandler) listTokens(c *gin.Context, userID uint) {
+	var tokens []model.PersonalAccessToken
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").Find(&tokens).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询访问令牌失败")
+		return
+	}
+
+	list := make([]gin.H, 0, len(tokens))
+	for i := range tokens {
+		list = append(list, formatAccessToken(&tokens[i]))
+	}
+	utils.Success(c, list)
+}
+
+func (h *AccessTokenHandler) createToken(c *gin.Context, user *model.User) {
+	var req createAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = utils.DefaultAccessTokenDays
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > utils.MaxAccessTokenDays {
+		utils.Error(c, 400, "有效天数必须在1到365之间")
+		return
+	}
+	if user.Status != 1 {
+		utils.Error(c, 400, "用户已被禁用")
+		return
+	}
+
+	expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+	token, plain, err := utils.CreateAccessToken(h.db, user, req.Name, req.Scopes, expiresAt, utils.GetUserID(c))
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "create", "access_token", token.ID, c, true, "", user.Username+": "+token.Name)
+
+	data := formatAccessToken(token)
+	data["token"] = plain // 明文只返回这一次
+	utils.Success(c, data)
+}
+
+func (h *AccessTokenHandler) revokeToken(c *gin.Context, userID uint, tokenID string) {
+	var token model.PersonalAccessToken
+	if err := h.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).First(&token).Error; err != nil {
+		utils.Error(c, 404, "访问令牌不存在")
+		return
+	}
+	if err := utils.RevokeAccessToken(h.db, token.ID); err != nil {
+		utils.Error(c, utils.CodeError, "吊销访问令牌失败")
+		return
+	}
+
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "revoke", "access_token", token.ID, c, true, "", token.Name)
+	utils.Success(c, gin.H{"message": "访问令牌已吊销"})
+}
+
+// requireInteractiveLogin 访问令牌不能用于创建新的访问令牌，避免令牌自我扩散
+func (h *AccessTokenHandler) requireInteractiveLogin(c *gin.Context) bool {
+	if utils.GetAccessTokenID(c) != 0 {
+		utils.Error(c, 403, "不能使用访问令牌创建访问令牌，请登录后操作")
+		return false
+	}
+	return true
+}
+
+func formatAccessToken(token *model.PersonalAccessToken) gin.H {
+	return gin.H{
+		"id":           token.ID,
+		"name":         token.Name,
+		"token_prefix": token.TokenPrefix,
+		"scopes":       utils.AccessTokenScopes(token),
+		"created_by":   token.CreatedBy,
+		"created_at":   token.CreatedAt,
+		"expires_at":   token.ExpiresAt,
+		"expired":      token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt),
+		"last_used_at": token.LastUsedAt,
+		"last_used_ip": token.LastUsedIP,
+	}
+}
@@ -74,6 +74,9 @@ func (h *AttachmentHandler) UploadFile(c *gin.Context) {
 		utils.Error(c, 403, "没有权限访问该项目")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, projectID, "attachment:upload") {
+		return
+	}
 
 	// 获取上传的文件
 	file, err := c.FormFile("file")
@@ -342,7 +345,7 @@ func (h *AttachmentHandler) DeleteAttachment(c *gin.Context) {
 		return
 	}
 
-	// 检查权限：用户必须是附件关联的任意一个项目的成员
+	// 检查权限：用户必须是附件关联的任意一个项目的成员，且项目角色允许删除附件
 	hasAccess := false
 
 	// 管理员可以删除所有附件
@@ -351,7 +354,7 @@ func (h *AttachmentHandler) DeleteAttachment(c *gin.Context) {
 	} else {
 		// 检查用户是否是附件关联的任意一个项目的成员
 		for _, project := range attachment.Projects {
-			if utils.CheckProjectAccess(h.db, c, project.ID) {
+			if utils.CheckProjectAccess(h.db, c, project.ID) && utils.CheckProjectPermission(h.db, c, project.ID, "attachment:delete") {
 				hasAccess = true
 				break
 			}
@@ -1,9 +1,11 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"prjflow/internal/config"
 	"prjflow/internal/model"
@@ -250,24 +252,23 @@ func (h *LoginCallbackHandler) Validate(ctx *WeChatCallbackContext) error {
 
 func (h *LoginCallbackHandler) Process(ctx *WeChatCallbackContext) (interface{}, error) {
 	// 查找用户（不自动创建）
-	var user model.User
-	// 使用Unscoped()查询，包括软删除的用户，但只查询wechat_open_id不为NULL的记录
-	result := ctx.DB.Where("wechat_open_id = ? AND wechat_open_id IS NOT NULL", ctx.UserInfo.OpenID).First(&user)
-	if result.Error == gorm.ErrRecordNotFound {
+	found, err := utils.FindUserByIdentity(ctx.DB, ctx.Identity)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// 用户不存在，返回错误提示
 		// 如果存在ticket，发送错误消息到WebSocket
 		if ctx.Ticket != "" && ctx.Hub != nil {
 			ctx.Hub.SendMessage(ctx.Ticket, "error", nil, "用户不存在，请联系管理员添加用户")
 		}
 		return nil, &CallbackError{Message: "用户不存在，请联系管理员添加用户"}
-	} else if result.Error != nil {
+	} else if err != nil {
 		// 查询出错，发送错误消息到WebSocket
 		if ctx.Ticket != "" && ctx.Hub != nil {
 			ctx.Hub.SendMessage(ctx.Ticket, "error", nil, "查询用户失败")
 		}
-		return nil, &CallbackError{Message: "查询用户失败", Err: result.Error}
+		return nil, &CallbackError{Message: "查询用户失败", Err: err}
 	}
-	
+	user := *found
+
 	// 更新用户信息（如果用户名变化，需要检查是否重复）
 	// 注意：这里不更新用户名，因为用户名可能已被用户修改过
 	user.Avatar = ctx.UserInfo.HeadImgURL
@@ -296,21 +297,14 @@ func (h *LoginCallbackHandler) Process(ctx *WeChatCallbackContext) (interface{},
 	// 只有用户名密码登录的首次登录才需要修改密码
 	isFirstLogin := false
 
-	// 生成JWT Token (Access Token)
-	token, err := auth.GenerateToken(user.ID, user.Username, roleNames)
+	// 创建登录会话并生成 Access Token 和 Refresh Token
+	tokens, err := utils.CreateSession(ctx.DB, &user, roleNames, "wechat", ctx.Context)
 	if err != nil {
 		// 记录登录失败
 		utils.RecordAuditLog(ctx.DB, user.ID, user.Username, "login", "user", user.ID, ctx.Context, false, "生成Token失败", "")
 		return nil, &CallbackError{Message: "生成Token失败", Err: err}
 	}
-
-	// 生成Refresh Token
-	refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username, roleNames)
-	if err != nil {
-		// 记录登录失败
-		utils.RecordAuditLog(ctx.DB, user.ID, user.Username, "login", "user", user.ID, ctx.Context, false, "生成RefreshToken失败", "")
-		return nil, &CallbackError{Message: "生成RefreshToken失败", Err: err}
-	}
+	token, refreshToken := tokens.Token, tokens.RefreshToken
 
 	// 记录登录成功（微信登录）
 	utils.RecordAuditLog(ctx.DB, user.ID, user.Username, "login", "user", user.ID, ctx.Context, true, "微信登录", "")
@@ -489,8 +483,8 @@ func (h *AuthHandler) WeChatLogin(c *gin.Context) {
 	// 只有用户名密码登录的首次登录才需要修改密码
 	isFirstLogin := false
 
-	// 生成JWT Token (Access Token)
-	token, err := auth.GenerateToken(user.ID, user.Username, roleNames)
+	// 创建登录会话并生成 Access Token 和 Refresh Token
+	tokens, err := utils.CreateSession(h.db, &user, roleNames, "wechat", c)
 	if err != nil {
 		// 如果存在ticket，通知错误
 		if ticket != "" {
@@ -499,17 +493,7 @@ func (h *AuthHandler) WeChatLogin(c *gin.Context) {
 		utils.Error(c, utils.CodeError, "生成Token失败")
 		return
 	}
-
-	// 生成Refresh Token
-	refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username, roleNames)
-	if err != nil {
-		// 如果存在ticket，通知错误
-		if ticket != "" {
-			websocket.GetHub().SendMessage(ticket, "error", nil, "生成RefreshToken失败")
-		}
-		utils.Error(c, utils.CodeError, "生成RefreshToken失败")
-		return
-	}
+	token, refreshToken := tokens.Token, tokens.RefreshToken
 
 	// 如果存在ticket，通过WebSocket通知登录页面
 	if ticket != "" {
@@ -591,12 +575,25 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// 检查账号是否被锁定、IP是否受限
+	ip := c.ClientIP()
+	if blocked := utils.CheckLoginAllowed(h.db, req.Username, ip); blocked != nil {
+		utils.RecordAuditLog(h.db, 0, req.Username, "login", "user", 0, c, false, blocked.Message, "")
+		utils.RespondLoginBlocked(c, blocked)
+		return
+	}
+
 	// 查找用户
 	var user model.User
 	if err := h.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// 记录登录失败（用户不存在）
 			utils.RecordAuditLog(h.db, 0, req.Username, "login", "user", 0, c, false, "用户不存在", "")
+			// 不存在的用户名同样计数，避免通过响应差异枚举用户名
+			if blocked := utils.RecordLoginFailure(h.db, req.Username, ip); blocked != nil {
+				utils.RespondLoginBlocked(c, blocked)
+				return
+			}
 			utils.Error(c, 401, "用户名或密码错误")
 		} else {
 			utils.Error(c, utils.CodeError, "查询用户失败")
@@ -616,13 +613,127 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	if user.Password == "" || !utils.CheckPassword(req.Password, user.Password) {
 		// 记录登录失败（密码错误）
 		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "密码错误", "")
+		if blocked := utils.RecordLoginFailure(h.db, user.Username, ip); blocked != nil {
+			utils.RespondLoginBlocked(c, blocked)
+			return
+		}
+		utils.Error(c, 401, "用户名或密码错误")
+		return
+	}
+
+	// 密码验证通过，清除失败记录
+	utils.ClearLoginFailures(h.db, user.Username)
+
+	// 服务账号只能通过访问令牌调用接口
+	if user.IsServiceAccount {
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "服务账号不能登录", "")
+		utils.Error(c, 403, utils.ErrServiceAccountLogin.Error())
+		return
+	}
+
+	h.finishLogin(c, &user, "password")
+}
+
+// LDAPLogin 使用LDAP/Active Directory账号登录，首次登录时自动创建本地用户
+func (h *AuthHandler) LDAPLogin(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "用户名和密码不能为空")
+		return
+	}
+
+	if !utils.LDAPEnabled() {
+		utils.Error(c, 400, utils.ErrLDAPDisabled.Error())
+		return
+	}
+
+	// 与用户名密码登录共用失败计数和锁定策略
+	ip := c.ClientIP()
+	if blocked := utils.CheckLoginAllowed(h.db, req.Username, ip); blocked != nil {
+		utils.RecordAuditLog(h.db, 0, req.Username, "login", "user", 0, c, false, blocked.Message, "")
+		utils.RespondLoginBlocked(c, blocked)
+		return
+	}
+
+	cfg := utils.GetLDAPConfig()
+	entry, err := utils.LDAPAuthenticate(cfg, req.Username, req.Password)
+	if err != nil {
+		if !errors.Is(err, utils.ErrLDAPInvalidCredentials) {
+			if utils.Logger != nil {
+				utils.Logger.Errorf("LDAP认证失败: username=%s, error=%v", req.Username, err)
+			}
+			utils.RecordAuditLog(h.db, 0, req.Username, "login", "user", 0, c, false, "LDAP服务不可用", "")
+			utils.Error(c, utils.CodeError, "LDAP服务不可用，请稍后重试")
+			return
+		}
+		utils.RecordAuditLog(h.db, 0, req.Username, "login", "user", 0, c, false, "LDAP密码错误", "")
+		if blocked := utils.RecordLoginFailure(h.db, req.Username, ip); blocked != nil {
+			utils.RespondLoginBlocked(c, blocked)
+			return
+		}
 		utils.Error(c, 401, "用户名或密码错误")
 		return
 	}
 
+	// 同步目录中的资料、部门和角色
+	user, err := utils.ProvisionLDAPUser(h.db, cfg, entry)
+	if err != nil {
+		if errors.Is(err, utils.ErrLDAPAccountConflict) {
+			utils.RecordAuditLog(h.db, 0, entry.Username, "login", "user", 0, c, false, err.Error(), "")
+			utils.Error(c, 409, err.Error())
+			return
+		}
+		utils.Error(c, utils.CodeError, "同步LDAP用户失败")
+		return
+	}
+
+	if user.Status != 1 {
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "用户已被禁用", "")
+		utils.Error(c, 403, "用户已被禁用")
+		return
+	}
+
+	utils.ClearLoginFailures(h.db, req.Username)
+	h.finishLogin(c, user, "ldap")
+}
+
+// finishLogin 凭据校验通过后完成登录：需要两步验证时返回临时Token，否则直接创建会话
+func (h *AuthHandler) finishLogin(c *gin.Context, user *model.User, loginMethod string) {
+	// 已启用两步验证或角色要求启用时，先返回临时Token，验证码校验通过后再创建会话
+	twoFactorEnabled := utils.IsTwoFactorEnabled(h.db, user.ID)
+	twoFactorRequired, err := utils.IsTwoFactorRequired(h.db, user.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询两步验证策略失败")
+		return
+	}
+	if twoFactorEnabled || twoFactorRequired {
+		twoFactorToken, err := auth.GenerateTwoFactorToken(user.ID, user.Username)
+		if err != nil {
+			utils.Error(c, utils.CodeError, "生成Token失败")
+			return
+		}
+		utils.Success(c, gin.H{
+			"two_factor_required":       true,
+			"two_factor_setup_required": !twoFactorEnabled, // 策略要求但尚未绑定，需要先完成绑定
+			"two_factor_token":          twoFactorToken,
+			"expires_in":                int(auth.TwoFactorTokenExpiration / time.Second),
+		})
+		return
+	}
+
+	h.completeLogin(c, user, loginMethod, nil)
+}
+
+// completeLogin 完成登录：更新登录次数、创建会话并返回Token
+// extra 中的字段会合并到返回数据中
+func (h *AuthHandler) completeLogin(c *gin.Context, user *model.User, loginMethod string, extra gin.H) {
 	// 获取用户角色
 	var roles []model.Role
-	h.db.Model(&user).Association("Roles").Find(&roles)
+	h.db.Model(user).Association("Roles").Find(&roles)
 
 	roleNames := make([]string, 0, len(roles))
 	for _, role := range roles {
@@ -630,13 +741,13 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// 更新登录次数
-	if err := h.db.Model(&user).Update("login_count", gorm.Expr("login_count + 1")).Error; err != nil {
+	if err := h.db.Model(user).Update("login_count", gorm.Expr("login_count + 1")).Error; err != nil {
 		utils.Error(c, utils.CodeError, "更新登录次数失败")
 		return
 	}
 
 	// 重新查询用户获取更新后的登录次数
-	if err := h.db.First(&user, user.ID).Error; err != nil {
+	if err := h.db.First(user, user.ID).Error; err != nil {
 		utils.Error(c, utils.CodeError, "查询用户失败")
 		return
 	}
@@ -644,8 +755,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// 判断是否是首次登录（更新后LoginCount == 1）
 	isFirstLogin := user.LoginCount == 1
 
-	// 生成JWT Token (Access Token)
-	token, err := auth.GenerateToken(user.ID, user.Username, roleNames)
+	// 创建登录会话并生成 Access Token 和 Refresh Token
+	tokens, err := utils.CreateSession(h.db, user, roleNames, loginMethod, c)
 	if err != nil {
 		utils.Error(c, utils.CodeError, "生成Token失败")
 		// 记录登录失败
@@ -653,21 +764,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// 生成Refresh Token
-	refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username, roleNames)
-	if err != nil {
-		utils.Error(c, utils.CodeError, "生成RefreshToken失败")
-		// 记录登录失败
-		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "生成RefreshToken失败", "")
-		return
-	}
-
 	// 记录登录成功
 	utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, true, "", "")
 
-	utils.Success(c, gin.H{
-		"token":         token,
-		"refresh_token": refreshToken,
+	data := gin.H{
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
@@ -676,7 +778,11 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			"roles":    roleNames,
 		},
 		"is_first_login": isFirstLogin,
-	})
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	utils.Success(c, data)
 }
 
 // ChangePassword 修改密码
@@ -704,6 +810,12 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// LDAP账号的密码由目录管理
+	if user.AuthSource == utils.AuthSourceLDAP {
+		utils.Error(c, 400, "LDAP账号请在企业目录中修改密码")
+		return
+	}
+
 	// 检查用户是否已有密码
 	hasPassword := user.Password != ""
 
@@ -714,10 +826,21 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 			utils.Error(c, 400, "请输入旧密码")
 			return
 		}
+		// 旧密码校验与登录共用失败计数，防止通过修改密码接口暴力破解
+		ip := c.ClientIP()
+		if blocked := utils.CheckLoginAllowed(h.db, user.Username, ip); blocked != nil {
+			utils.RespondLoginBlocked(c, blocked)
+			return
+		}
 		if !utils.CheckPassword(req.OldPassword, user.Password) {
+			if blocked := utils.RecordLoginFailure(h.db, user.Username, ip); blocked != nil {
+				utils.RespondLoginBlocked(c, blocked)
+				return
+			}
 			utils.Error(c, 400, "旧密码错误")
 			return
 		}
+		utils.ClearLoginFailures(h.db, user.Username)
 	}
 
 	// 验证新密码强度：必须包含大小写字母和数字
@@ -745,6 +868,11 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// 吊销其他设备上的会话，当前会话保持登录
+	if _, err := utils.RevokeUserSessions(h.db, user.ID, utils.GetSessionID(c), utils.SessionRevokedPasswordChanged); err != nil && utils.Logger != nil {
+		utils.Logger.Errorf("吊销用户会话失败: user_id=%d, error=%v", user.ID, err)
+	}
+
 	// 根据是否有旧密码返回不同的消息
 	message := "密码修改成功"
 	if !hasPassword {
@@ -767,7 +895,12 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		}
 	}
 
-	// JWT是无状态的，客户端删除token即可
+	// 吊销当前会话，该会话签发的 Access Token 和 Refresh Token 立即失效
+	if err := utils.RevokeSession(h.db, utils.GetSessionID(c), utils.SessionRevokedLogout); err != nil {
+		utils.Error(c, utils.CodeError, "登出失败")
+		return
+	}
+
 	utils.Success(c, gin.H{
 		"message": "登出成功",
 	})
@@ -784,16 +917,20 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	// 解析Refresh Token
-	claims, err := auth.ParseToken(req.RefreshToken)
+	// 解析Refresh Token：只能使用refresh token来刷新
+	claims, err := auth.ParseRefreshToken(req.RefreshToken)
 	if err != nil {
+		if errors.Is(err, auth.ErrInvalidTokenType) {
+			utils.Error(c, 401, "只能使用RefreshToken来刷新，不能使用AccessToken")
+			return
+		}
 		utils.Error(c, 401, "无效的RefreshToken")
 		return
 	}
 
-	// 验证Token类型：只能使用refresh token来刷新
-	if claims.TokenType != "refresh" {
-		utils.Error(c, 401, "只能使用RefreshToken来刷新，不能使用AccessToken")
+	// Refresh Token 必须属于某个登录会话，才能保证只能使用一次
+	if claims.SessionID == "" {
+		utils.Error(c, 401, "RefreshToken已失效，请重新登录")
 		return
 	}
 
@@ -804,6 +941,12 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
+	// 禁用用户不能刷新Token
+	if user.Status != 1 {
+		utils.Error(c, 401, "用户已被禁用")
+		return
+	}
+
 	// 获取用户角色
 	var roles []model.Role
 	h.db.Model(&user).Association("Roles").Find(&roles)
@@ -813,23 +956,102 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		roleNames = append(roleNames, role.Code)
 	}
 
-	// 生成新的Access Token
-	newToken, err := auth.GenerateToken(user.ID, user.Username, roleNames)
+	// 轮换 Refresh Token，旧的 Refresh Token 立即失效
+	tokens, err := utils.RotateRefreshToken(h.db, &user, roleNames, claims)
 	if err != nil {
-		utils.Error(c, utils.CodeError, "生成Token失败")
+		switch {
+		case errors.Is(err, utils.ErrRefreshTokenReused):
+			// 已轮换的 Refresh Token 再次出现，可能已泄露，整个会话已被吊销
+			utils.RecordAuditLog(h.db, user.ID, user.Username, "refresh_token_reuse", "session", 0, c, false, "检测到RefreshToken重复使用，已吊销会话", claims.SessionID)
+			utils.Error(c, 401, err.Error())
+		case errors.Is(err, utils.ErrSessionNotFound), errors.Is(err, utils.ErrSessionRevoked):
+			utils.Error(c, 401, "会话已失效，请重新登录")
+		default:
+			utils.Error(c, utils.CodeError, "生成Token失败")
+		}
 		return
 	}
 
-	// 生成新的Refresh Token
-	newRefreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username, roleNames)
+	utils.Success(c, gin.H{
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+	})
+}
+
+// GetSessions 获取当前用户的有效登录会话
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	var sessions []model.UserSession
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_active_at DESC").Find(&sessions).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询会话失败")
+		return
+	}
+
+	currentSessionID := utils.GetSessionID(c)
+	list := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, gin.H{
+			"id":             session.ID,
+			"login_method":   session.LoginMethod,
+			"ip_address":     session.IPAddress,
+			"user_agent":     session.UserAgent,
+			"created_at":     session.CreatedAt,
+			"last_active_at": session.LastActiveAt,
+			"expires_at":     session.ExpiresAt,
+			"current":        currentSessionID != "" && session.SessionID == currentSessionID,
+		})
+	}
+
+	utils.Success(c, list)
+}
+
+// RevokeSession 注销当前用户的指定会话（远程登出）
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	var session model.UserSession
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&session).Error; err != nil {
+		utils.Error(c, 404, "会话不存在")
+		return
+	}
+
+	if err := utils.RevokeSession(h.db, session.SessionID, utils.SessionRevokedRemoteLogout); err != nil {
+		utils.Error(c, utils.CodeError, "注销会话失败")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"message": "会话已注销",
+	})
+}
+
+// RevokeOtherSessions 注销当前用户除当前会话外的所有会话
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	count, err := utils.RevokeUserSessions(h.db, userID, utils.GetSessionID(c), utils.SessionRevokedRemoteLogout)
 	if err != nil {
-		utils.Error(c, utils.CodeError, "生成RefreshToken失败")
+		utils.Error(c, utils.CodeError, "注销会话失败")
 		return
 	}
 
 	utils.Success(c, gin.H{
-		"token":         newToken,
-		"refresh_token": newRefreshToken,
+		"message": "其他会话已注销",
+		"count":   count,
 	})
 }
 
@@ -990,75 +1212,13 @@ func (h *BindCallbackHandler) Process(ctx *WeChatCallbackContext) (interface{},
 		return nil, &CallbackError{Message: "用户不存在", Err: err}
 	}
 
-	// 检查该微信OpenID是否已被其他用户绑定（包括软删除的用户）
-	var existingUser model.User
-	if err := ctx.DB.Unscoped().Where("wechat_open_id = ? AND id != ?", ctx.UserInfo.OpenID, user.ID).First(&existingUser).Error; err == nil {
-		// 如果找到的用户是软删除的，先清理它的 wechat_open_id
-		if existingUser.DeletedAt.Valid {
-			// 软删除的用户，清理其 wechat_open_id
-			if err := ctx.DB.Unscoped().Model(&existingUser).Update("wechat_open_id", nil).Error; err != nil {
-				return nil, &CallbackError{Message: "清理已删除用户的微信绑定失败", Err: err}
-			}
-		} else {
-			// 正常用户，不能重复绑定
-			return nil, &CallbackError{Message: fmt.Sprintf("该微信已被用户 %s 绑定，无法重复绑定", existingUser.Username)}
-		}
-	} else if err != gorm.ErrRecordNotFound {
-		return nil, &CallbackError{Message: "查询用户失败", Err: err}
-	}
-
-	// 再次检查该微信OpenID是否已被其他用户绑定（防止并发问题，包括软删除的用户）
-	var checkUser model.User
-	if err := ctx.DB.Unscoped().Where("wechat_open_id = ? AND id != ?", ctx.UserInfo.OpenID, user.ID).First(&checkUser).Error; err == nil {
-		// 如果找到的用户是软删除的，先清理它的 wechat_open_id
-		if checkUser.DeletedAt.Valid {
-			// 软删除的用户，清理其 wechat_open_id
-			if err := ctx.DB.Unscoped().Model(&checkUser).Update("wechat_open_id", nil).Error; err != nil {
-				return nil, &CallbackError{Message: "清理已删除用户的微信绑定失败", Err: err}
-			}
-		} else {
-			// 正常用户，不能重复绑定
-			return nil, &CallbackError{Message: fmt.Sprintf("该微信已被用户 %s 绑定，无法重复绑定", checkUser.Username)}
-		}
-	} else if err != gorm.ErrRecordNotFound {
-		return nil, &CallbackError{Message: "查询用户失败", Err: err}
+	// 绑定微信（已被其他用户绑定时报错，已删除用户占用的绑定会被释放）
+	identity := ctx.Identity
+	if identity == nil {
+		identity = utils.WeChatIdentity(ctx.UserInfo)
 	}
-
-	// 更新用户的wechat_open_id
-	wechatOpenID := ctx.UserInfo.OpenID
-	user.WeChatOpenID = &wechatOpenID
-	// 可选：更新头像
-	if ctx.UserInfo.HeadImgURL != "" {
-		user.Avatar = ctx.UserInfo.HeadImgURL
-	}
-	if err := ctx.DB.Save(&user).Error; err != nil {
-		// 检查是否是唯一约束错误
-		if utils.IsUniqueConstraintOnField(err, "wechat_open_id") {
-			// 再次查询，找出是哪个用户绑定了这个微信（包括软删除的用户）
-			var conflictUser model.User
-			if queryErr := ctx.DB.Unscoped().Where("wechat_open_id = ?", ctx.UserInfo.OpenID).First(&conflictUser).Error; queryErr == nil {
-				// 如果是软删除的用户，清理它的 wechat_open_id 并重试
-				if conflictUser.DeletedAt.Valid {
-					// 清理软删除用户的 wechat_open_id
-					if updateErr := ctx.DB.Unscoped().Model(&conflictUser).Update("wechat_open_id", nil).Error; updateErr != nil {
-						return nil, &CallbackError{Message: "清理已删除用户的微信绑定失败", Err: updateErr}
-					}
-					// 重试保存
-					if retryErr := ctx.DB.Save(&user).Error; retryErr != nil {
-						return nil, &CallbackError{Message: "绑定失败", Err: retryErr}
-					}
-					// 保存成功，继续后续流程
-				} else {
-					// 正常用户，不能重复绑定
-					return nil, &CallbackError{Message: fmt.Sprintf("该微信已被用户 %s 绑定，无法重复绑定", conflictUser.Username)}
-				}
-			} else {
-				return nil, &CallbackError{Message: "该微信已被其他用户绑定，无法重复绑定"}
-			}
-		} else {
-			// 不是唯一约束错误，返回原始错误
-			return nil, &CallbackError{Message: "绑定失败", Err: err}
-		}
+	if err := utils.LinkIdentity(ctx.DB, &user, identity); err != nil {
+		return nil, &CallbackError{Message: err.Error()}
 	}
 
 	// 通过WebSocket通知PC前端绑定成功
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"strconv"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"prjflow/internal/model"
@@ -54,6 +56,35 @@ func (h *BoardHandler) GetBoard(c *gin.Context) {
 	utils.Success(c, board)
 }
 
+// GetBoardFlowMetrics 获取看板的累积流图（按看板列划分区带）和任务的周期时间/前置时间统计
+func (h *BoardHandler) GetBoardFlowMetrics(c *gin.Context) {
+	var board model.Board
+	if err := h.db.Preload("Columns", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort ASC")
+	}).First(&board, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "看板不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, board.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	bands := make([]utils.FlowBand, 0, len(board.Columns))
+	for _, column := range board.Columns {
+		bands = append(bands, utils.FlowBand{
+			Key:      strconv.FormatUint(uint64(column.ID), 10),
+			Name:     column.Name,
+			Statuses: []string{column.Status},
+		})
+	}
+	respondFlowMetrics(c, h.db, utils.FlowMetricsScope{
+		ObjectType: utils.SprintItemTask,
+		ProjectID:  board.ProjectID,
+		Bands:      bands,
+	})
+}
+
 // CreateBoard 创建看板
 func (h *BoardHandler) CreateBoard(c *gin.Context) {
 	projectID := c.Param("id")
@@ -80,6 +111,9 @@ func (h *BoardHandler) CreateBoard(c *gin.Context) {
 		utils.Error(c, 400, "项目不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:manage") {
+		return
+	}
 
 	board := model.Board{
 		Name:        req.Name,
@@ -128,6 +162,9 @@ func (h *BoardHandler) UpdateBoard(c *gin.Context) {
 		utils.Error(c, 404, "看板不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, board.ProjectID, "project:manage") {
+		return
+	}
 
 	var req struct {
 		Name        *string `json:"name"`
@@ -162,8 +199,16 @@ func (h *BoardHandler) UpdateBoard(c *gin.Context) {
 // DeleteBoard 删除看板
 func (h *BoardHandler) DeleteBoard(c *gin.Context) {
 	id := c.Param("id")
+	var board model.Board
+	if err := h.db.First(&board, id).Error; err != nil {
+		utils.Error(c, 404, "看板不存在")
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, board.ProjectID, "project:manage") {
+		return
+	}
 
-	if err := h.db.Delete(&model.Board{}, id).Error; err != nil {
+	if err := h.db.Delete(&board).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
 	}
@@ -179,6 +224,9 @@ func (h *BoardHandler) CreateBoardColumn(c *gin.Context) {
 		utils.Error(c, 404, "看板不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, board.ProjectID, "project:manage") {
+		return
+	}
 
 	var req struct {
 		Name   string `json:"name" binding:"required"`
@@ -223,6 +271,9 @@ func (h *BoardHandler) UpdateBoardColumn(c *gin.Context) {
 		utils.Error(c, 404, "列不存在")
 		return
 	}
+	if !h.requireColumnPermission(c, &column) {
+		return
+	}
 
 	var req struct {
 		Name   *string `json:"name"`
@@ -260,8 +311,16 @@ func (h *BoardHandler) UpdateBoardColumn(c *gin.Context) {
 // DeleteBoardColumn 删除看板列
 func (h *BoardHandler) DeleteBoardColumn(c *gin.Context) {
 	columnID := c.Param("column_id")
+	var column model.BoardColumn
+	if err := h.db.First(&column, columnID).Error; err != nil {
+		utils.Error(c, 404, "列不存在")
+		return
+	}
+	if !h.requireColumnPermission(c, &column) {
+		return
+	}
 
-	if err := h.db.Delete(&model.BoardColumn{}, columnID).Error; err != nil {
+	if err := h.db.Delete(&column).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
 	}
@@ -269,6 +328,16 @@ func (h *BoardHandler) DeleteBoardColumn(c *gin.Context) {
 	utils.Success(c, gin.H{"message": "删除成功"})
 }
 
+// requireColumnPermission 按看板所属项目检查看板列的管理权限
+func (h *BoardHandler) requireColumnPermission(c *gin.Context, column *model.BoardColumn) bool {
+	var board model.Board
+	if err := h.db.First(&board, column.BoardID).Error; err != nil {
+		utils.Error(c, 404, "看板不存在")
+		return false
+	}
+	return utils.RequireProjectPermission(h.db, c, board.ProjectID, "project:manage")
+}
+
 // MoveTask 移动任务到不同列（拖拽排序）
 func (h *BoardHandler) MoveTask(c *gin.Context) {
 	boardID := c.Param("id")
@@ -277,6 +346,7 @@ func (h *BoardHandler) MoveTask(c *gin.Context) {
 	var req struct {
 		ColumnID string `json:"column_id" binding:"required"`
 		Position int    `json:"position"`
+		Comment  string `json:"comment"` // 状态流转要求填写备注时使用
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -290,6 +360,9 @@ func (h *BoardHandler) MoveTask(c *gin.Context) {
 		utils.Error(c, 404, "看板不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, board.ProjectID, "task:update") {
+		return
+	}
 
 	// 获取列
 	var column model.BoardColumn
@@ -312,7 +385,19 @@ func (h *BoardHandler) MoveTask(c *gin.Context) {
 	}
 
 	// 更新任务状态（根据列的状态）
+	oldStatus := task.Status
 	if column.Status != "" {
+		// 与修改任务状态一样，按项目工作流校验状态转换
+		if err := utils.ValidateWorkflowTransition(h.db, c, "task", task.ProjectID, oldStatus, column.Status, map[string]string{
+			"comment": req.Comment,
+		}); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		if err := utils.CheckTaskCanFinish(h.db, &task, column.Status); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
 		task.Status = column.Status
 		// 如果状态为done，自动设置进度为100
 		if column.Status == "done" {
@@ -324,10 +409,30 @@ func (h *BoardHandler) MoveTask(c *gin.Context) {
 		utils.Error(c, utils.CodeError, "移动失败")
 		return
 	}
+	NewTaskHandler(h.db).rollupParentTasks(task.ParentID)
 
 	// 重新加载任务数据
 	h.db.Preload("Project").Preload("Creator").Preload("Assignee").Preload("Dependencies").First(&task, task.ID)
 
+	// 记录看板移动操作（同时会推送到看板主题）
+	userID, exists := c.Get("user_id")
+	if exists {
+		dbValue, _ := c.Get("db")
+		if db, ok := dbValue.(*gorm.DB); ok {
+			extra := map[string]interface{}{
+				"board_id":  board.ID,
+				"column_id": column.ID,
+				"position":  req.Position,
+			}
+			actionID, _ := utils.RecordAction(db, "task", task.ID, "moved", userID.(uint), "", extra)
+			if oldStatus != task.Status {
+				utils.RecordHistory(db, actionID, []utils.HistoryChange{
+					{Field: "status", Old: oldStatus, New: task.Status},
+				})
+			}
+		}
+	}
+
 	utils.Success(c, task)
 }
 
@@ -342,9 +447,18 @@ func (h *BoardHandler) GetBoardTasks(c *gin.Context) {
 		return
 	}
 
-	// 获取项目的所有任务
+	// 获取项目的所有任务，可按迭代筛选（sprint_id=active 表示项目当前进行中的迭代）
+	sprintID := c.Query("sprint_id")
+	if sprintID == "active" {
+		sprint, err := utils.GetActiveSprint(h.db, board.ProjectID)
+		if err != nil {
+			utils.Error(c, 404, "项目没有进行中的迭代")
+			return
+		}
+		sprintID = strconv.FormatUint(uint64(sprint.ID), 10)
+	}
 	var allTasks []model.Task
-	h.db.Where("project_id = ?", board.ProjectID).
+	utils.FilterBySprint(h.db.Where("project_id = ?", board.ProjectID), sprintID).
 		Preload("Creator").Preload("Assignee").
 		Order("created_at DESC").
 		Find(&allTasks)
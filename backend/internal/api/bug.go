@@ -39,6 +39,9 @@ func (h *BugHandler) GetBugs(c *gin.Context) {
 		query = query.Where("project_id = ?", projectID)
 	}
 
+	// 迭代筛选（sprint_id=0 表示未规划到迭代的待办池）
+	query = utils.FilterBySprint(query, c.Query("sprint_id"))
+
 	// 状态筛选
 	if status := c.Query("status"); status != "" {
 		query = query.Where("status = ?", status)
@@ -100,6 +103,7 @@ func (h *BugHandler) GetBugs(c *gin.Context) {
 		if projectID := c.Query("project_id"); projectID != "" {
 			idQuery = idQuery.Where("project_id = ?", projectID)
 		}
+		idQuery = utils.FilterBySprint(idQuery, c.Query("sprint_id"))
 		if status := c.Query("status"); status != "" {
 			idQuery = idQuery.Where("status = ?", status)
 		}
@@ -173,6 +177,7 @@ func (h *BugHandler) GetBugs(c *gin.Context) {
 		if projectID := c.Query("project_id"); projectID != "" {
 			idQuery = idQuery.Where("project_id = ?", projectID)
 		}
+		idQuery = utils.FilterBySprint(idQuery, c.Query("sprint_id"))
 		if status := c.Query("status"); status != "" {
 			idQuery = idQuery.Where("status = ?", status)
 		}
@@ -278,6 +283,7 @@ func (h *BugHandler) GetBugs(c *gin.Context) {
 	if projectID := c.Query("project_id"); projectID != "" {
 		countQuery = countQuery.Where("project_id = ?", projectID)
 	}
+	countQuery = utils.FilterBySprint(countQuery, c.Query("sprint_id"))
 	if status := c.Query("status"); status != "" {
 		countQuery = countQuery.Where("status = ?", status)
 	}
@@ -379,19 +385,13 @@ func (h *BugHandler) CreateBug(c *gin.Context) {
 		return
 	}
 
-	// 验证状态
-	if req.Status == "" {
-		req.Status = "active"
-	}
-	validStatuses := map[string]bool{
-		"active":   true,
-		"resolved": true,
-		"closed":   true,
-	}
-	if !validStatuses[req.Status] {
-		utils.Error(c, 400, "状态值无效，有效值：active, resolved, closed")
+	// 验证状态：新建的Bug必须处于项目工作流的初始状态（未指定时使用初始状态）
+	initialStatus, err := utils.ValidateWorkflowInitialState(h.db, "bug", req.ProjectID, req.Status)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
 		return
 	}
+	req.Status = initialStatus
 
 	// 验证优先级
 	if req.Priority == "" {
@@ -435,6 +435,9 @@ func (h *BugHandler) CreateBug(c *gin.Context) {
 		utils.Error(c, 403, "没有权限在该项目中创建Bug")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "bug:create") {
+		return
+	}
 
 	// 如果指定了需求，验证需求是否存在
 	if req.RequirementID != nil {
@@ -554,6 +557,9 @@ func (h *BugHandler) UpdateBug(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该Bug")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, bug.ProjectID, "bug:update") {
+		return
+	}
 
 	// 保存旧对象用于比较（深拷贝指针字段，避免修改bug时影响oldBug）
 	oldBug := bug
@@ -601,39 +607,12 @@ func (h *BugHandler) UpdateBug(c *gin.Context) {
 		bug.Description = *req.Description
 	}
 	if req.Status != nil {
-		// 验证状态
-		validStatuses := map[string]bool{
-			"active":   true,
-			"resolved": true,
-			"closed":   true,
-		}
-		if !validStatuses[*req.Status] {
-			utils.Error(c, 400, "状态值无效，有效值：active, resolved, closed")
+		// 按项目工作流校验状态流转，本次请求提供的字段用于校验流转的必填字段
+		if err := utils.ValidateWorkflowTransition(h.db, c, "bug", bug.ProjectID, bug.Status, *req.Status, utils.WorkflowFieldsFromRequest(req)); err != nil {
+			utils.Error(c, 400, err.Error())
 			return
 		}
 
-		// 验证状态流转是否符合禅道规则
-		// 禅道规则：
-		// 1. active -> resolved (只有active状态可以解决)
-		// 2. resolved -> closed (只有resolved状态可以关闭)
-		// 3. resolved/closed -> active (只有非active状态可以激活)
-		currentStatus := bug.Status
-		newStatus := *req.Status
-
-		if currentStatus != newStatus {
-			if currentStatus == "active" && newStatus == "resolved" {
-				// active -> resolved: 允许
-			} else if currentStatus == "resolved" && newStatus == "closed" {
-				// resolved -> closed: 允许
-			} else if (currentStatus == "resolved" || currentStatus == "closed") && newStatus == "active" {
-				// resolved/closed -> active: 允许（激活）
-			} else {
-				// 其他状态转换不允许
-				utils.Error(c, 400, fmt.Sprintf("状态转换无效：不能从 %s 转换到 %s。允许的转换：active->resolved, resolved->closed, resolved/closed->active", currentStatus, newStatus))
-				return
-			}
-		}
-
 		bug.Status = *req.Status
 	}
 	if req.Priority != nil {
@@ -919,6 +898,9 @@ func (h *BugHandler) DeleteBug(c *gin.Context) {
 		utils.Error(c, 403, "没有权限删除该Bug")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, bug.ProjectID, "bug:delete") {
+		return
+	}
 
 	if err := h.db.Delete(&model.Bug{}, id).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
@@ -942,6 +924,9 @@ func (h *BugHandler) UpdateBugStatus(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该Bug")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, bug.ProjectID, "bug:update") {
+		return
+	}
 
 	// 保存旧对象用于比较
 	oldBug := bug
@@ -956,6 +941,7 @@ func (h *BugHandler) UpdateBugStatus(c *gin.Context) {
 		ResolvedVersionID *uint    `json:"resolved_version_id"` // 解决版本ID
 		VersionNumber     *string  `json:"version_number"`      // 版本号（如果创建新版本）
 		CreateVersion     *bool    `json:"create_version"`      // 是否创建新版本
+		Comment           *string  `json:"comment"`             // 备注
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -963,36 +949,28 @@ func (h *BugHandler) UpdateBugStatus(c *gin.Context) {
 		return
 	}
 
-	// 验证状态
-	validStatuses := map[string]bool{
-		"active":   true,
-		"resolved": true,
-		"closed":   true,
+	// 按项目工作流校验状态流转（包括状态有效性、允许的流转、角色限制和必填字段）
+	currentStatus := bug.Status
+	workflowFields := map[string]string{}
+	if req.Solution != nil {
+		workflowFields["solution"] = *req.Solution
 	}
-	if !validStatuses[req.Status] {
-		utils.Error(c, 400, "状态值无效，有效值：active, resolved, closed")
-		return
+	if req.SolutionNote != nil {
+		workflowFields["solution_note"] = *req.SolutionNote
 	}
-
-	// 验证状态流转是否符合禅道规则
-	// 禅道规则：
-	// 1. active -> resolved (只有active状态可以解决)
-	// 2. resolved -> closed (只有resolved状态可以关闭)
-	// 3. resolved/closed -> active (只有非active状态可以激活)
-	currentStatus := bug.Status
-	newStatus := req.Status
-
-	if currentStatus == newStatus {
-		// 状态未改变，允许
-	} else if currentStatus == "active" && newStatus == "resolved" {
-		// active -> resolved: 允许
-	} else if currentStatus == "resolved" && newStatus == "closed" {
-		// resolved -> closed: 允许
-	} else if (currentStatus == "resolved" || currentStatus == "closed") && newStatus == "active" {
-		// resolved/closed -> active: 允许（激活）
-	} else {
-		// 其他状态转换不允许
-		utils.Error(c, 400, fmt.Sprintf("状态转换无效：不能从 %s 转换到 %s。允许的转换：active->resolved, resolved->closed, resolved/closed->active", currentStatus, newStatus))
+	if req.Comment != nil {
+		workflowFields["comment"] = *req.Comment
+	}
+	if req.ResolvedVersionID != nil {
+		workflowFields["resolved_version_id"] = fmt.Sprintf("%d", *req.ResolvedVersionID)
+	} else if req.CreateVersion != nil && *req.CreateVersion && req.VersionNumber != nil {
+		workflowFields["resolved_version_id"] = *req.VersionNumber
+	}
+	if req.ActualHours != nil {
+		workflowFields["actual_hours"] = fmt.Sprintf("%.2f", *req.ActualHours)
+	}
+	if err := utils.ValidateWorkflowTransition(h.db, c, "bug", bug.ProjectID, currentStatus, req.Status, workflowFields); err != nil {
+		utils.Error(c, 400, err.Error())
 		return
 	}
 
@@ -1155,8 +1133,11 @@ func (h *BugHandler) UpdateBugStatus(c *gin.Context) {
 	if exists {
 		dbValue, _ := c.Get("db")
 		if db, ok := dbValue.(*gorm.DB); ok {
-			actionType := "resolved"
-			if req.Status == "closed" {
+			actionType := "status_changed"
+			switch req.Status {
+			case "resolved":
+				actionType = "resolved"
+			case "closed":
 				actionType = "closed"
 			}
 			// 准备extra信息（包含解决方案等）
@@ -1172,6 +1153,9 @@ func (h *BugHandler) UpdateBugStatus(c *gin.Context) {
 			if req.SolutionNote != nil {
 				comment = *req.SolutionNote
 			}
+			if comment == "" && req.Comment != nil {
+				comment = *req.Comment
+			}
 			// 使用CompareAndRecord会自动记录操作和字段变更，但我们需要先记录操作以包含extra信息
 			// 所以先记录操作，然后记录字段变更
 			actionID, _ := utils.RecordAction(db, "bug", bug.ID, actionType, userID.(uint), comment, extra)
@@ -1282,6 +1266,9 @@ func (h *BugHandler) AssignBug(c *gin.Context) {
 		utils.Error(c, 403, "没有权限分配该Bug")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, bug.ProjectID, "bug:assign") {
+		return
+	}
 
 	// 获取旧的分配人ID列表
 	var oldAssigneeIDs []uint
@@ -1313,9 +1300,8 @@ func (h *BugHandler) AssignBug(c *gin.Context) {
 
 	// 如果提供了状态，更新Bug状态
 	if req.Status != nil {
-		validStatuses := map[string]bool{"active": true, "resolved": true, "closed": true}
-		if !validStatuses[*req.Status] {
-			utils.Error(c, 400, "无效的状态值")
+		if err := utils.ValidateWorkflowTransition(h.db, c, "bug", bug.ProjectID, bug.Status, *req.Status, utils.WorkflowFieldsFromRequest(req)); err != nil {
+			utils.Error(c, 400, err.Error())
 			return
 		}
 		bug.Status = *req.Status
@@ -1356,6 +1342,31 @@ func (h *BugHandler) AssignBug(c *gin.Context) {
 		}
 	}
 
+	// 通知新增的指派人
+	oldAssignees := make(map[uint]bool, len(oldAssigneeIDs))
+	for _, id := range oldAssigneeIDs {
+		oldAssignees[id] = true
+	}
+	var newAssigneeIDs []uint
+	for _, id := range req.AssigneeIDs {
+		if !oldAssignees[id] {
+			newAssigneeIDs = append(newAssigneeIDs, id)
+		}
+	}
+	notifyContent := ""
+	if req.Comment != nil {
+		notifyContent = *req.Comment
+	}
+	utils.Notify(h.db, newAssigneeIDs, utils.NotificationInput{
+		Type:       utils.NotificationBugAssigned,
+		Title:      "Bug已指派给你：" + bug.Title,
+		Content:    notifyContent,
+		ObjectType: "bug",
+		ObjectID:   bug.ID,
+		ProjectID:  bug.ProjectID,
+		ActorID:    utils.GetUserID(c),
+	})
+
 	utils.Success(c, bug)
 }
 
@@ -1497,6 +1508,9 @@ func (h *BugHandler) ConfirmBug(c *gin.Context) {
 		utils.Error(c, 403, "没有权限确认该Bug")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, bug.ProjectID, "bug:update") {
+		return
+	}
 
 	// 验证：只有状态为active且未确认的bug才能被确认
 	if bug.Status != "active" {
@@ -1674,6 +1688,9 @@ func (h *BugHandler) AddBugHistoryNote(c *gin.Context) {
 		utils.Error(c, 403, "没有权限为该Bug添加备注")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, bug.ProjectID, "bug:update") {
+		return
+	}
 
 	var req struct {
 		Comment string `json:"comment" binding:"required"`
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// respondBurnChart 生成并返回燃尽图/燃起图
+// 查询参数 start_date、end_date（YYYY-MM-DD）覆盖默认统计区间，holidays 追加逗号分隔的非工作日
+func respondBurnChart(c *gin.Context, db *gorm.DB, scope utils.BurnChartScope) {
+	if value := c.Query("start_date"); value != "" {
+		date, err := utils.ParseSprintDate(value)
+		if err != nil {
+			utils.Error(c, 400, "开始日期格式错误，应为 YYYY-MM-DD")
+			return
+		}
+		scope.StartDate = date
+	}
+	if value := c.Query("end_date"); value != "" {
+		date, err := utils.ParseSprintDate(value)
+		if err != nil {
+			utils.Error(c, 400, "结束日期格式错误，应为 YYYY-MM-DD")
+			return
+		}
+		scope.EndDate = date
+	}
+
+	calendar := utils.DefaultWorkCalendar()
+	if holidays := c.Query("holidays"); holidays != "" {
+		calendar.AddHolidays(strings.Split(holidays, ",")...)
+	}
+
+	chart, err := utils.BuildBurnChart(db, scope, calendar)
+	if err != nil {
+		if errors.Is(err, utils.ErrBurnChartRange) {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "生成燃尽图失败")
+		}
+		return
+	}
+	utils.Success(c, chart)
+}
+
+// burnChartEnd 未指定结束日期时统计到今天
+func burnChartEnd(end *time.Time) time.Time {
+	if end != nil {
+		return *end
+	}
+	return time.Now()
+}
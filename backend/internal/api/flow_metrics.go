@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// respondFlowMetrics 生成并返回累积流图和周期时间/前置时间统计
+// 查询参数 start_date、end_date（YYYY-MM-DD），默认统计最近30天
+func respondFlowMetrics(c *gin.Context, db *gorm.DB, scope utils.FlowMetricsScope) {
+	scope.EndDate = time.Now()
+	scope.StartDate = scope.EndDate.AddDate(0, 0, 1-utils.FlowMetricsDefaultDays)
+	if value := c.Query("start_date"); value != "" {
+		date, err := utils.ParseSprintDate(value)
+		if err != nil {
+			utils.Error(c, 400, "开始日期格式错误，应为 YYYY-MM-DD")
+			return
+		}
+		scope.StartDate = date
+	}
+	if value := c.Query("end_date"); value != "" {
+		date, err := utils.ParseSprintDate(value)
+		if err != nil {
+			utils.Error(c, 400, "结束日期格式错误，应为 YYYY-MM-DD")
+			return
+		}
+		scope.EndDate = date
+	}
+
+	metrics, err := utils.BuildFlowMetrics(db, scope)
+	if err != nil {
+		if errors.Is(err, utils.ErrBurnChartRange) {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "统计流动指标失败")
+		}
+		return
+	}
+	utils.Success(c, metrics)
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// identityProvider 按名称获取已启用的身份提供方，未启用时返回错误信息
+func (h *AuthHandler) identityProvider(c *gin.Context, name string) (utils.IdentityProvider, string) {
+	switch name {
+	case utils.IdentityProviderOIDC:
+		provider, err := utils.GetOIDCProvider()
+		if err != nil {
+			return nil, err.Error()
+		}
+		return provider, ""
+	case utils.IdentityProviderWeChat:
+		if errMsg := h.loadWeChatConfig(); errMsg != "" {
+			return nil, errMsg
+		}
+		return &utils.WeChatIdentityProvider{Client: h.wechatClient, RedirectURI: wechatIdentityRedirectURI(c)}, ""
+	default:
+		return nil, "不支持的身份提供方: " + name
+	}
+}
+
+// wechatIdentityRedirectURI 微信授权回调地址（前端页面），优先使用配置的回调域名
+func wechatIdentityRedirectURI(c *gin.Context) string {
+	if domain := config.AppConfig.WeChat.CallbackDomain; domain != "" {
+		return strings.TrimSuffix(domain, "/") + "/auth/idp/wechat/callback"
+	}
+	if redirectURI := c.Query("redirect_uri"); redirectURI != "" {
+		return redirectURI
+	}
+	if referer := c.GetHeader("Referer"); referer != "" {
+		return strings.TrimSuffix(referer, "/") + "/auth/idp/wechat/callback"
+	}
+	return "http://localhost:8080/auth/idp/wechat/callback"
+}
+
+// identityStateCookie 保存授权请求 state 的 Cookie，回调时要求与提交的 state 一致，防止把他人的授权结果提交到当前浏览器
+const identityStateCookie = "idp_state"
+
+func setIdentityStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(identityStateCookie, state, maxAge, "/api/auth/idp", "", secure, true)
+}
+
+// identityStateMatches 检查提交的 state 是否为当前浏览器发起的授权请求
+func identityStateMatches(c *gin.Context, state string) bool {
+	cookie, err := c.Cookie(identityStateCookie)
+	return err == nil && cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) == 1
+}
+
+// identityRequestUserID 返回回调请求中已登录用户的ID（会话须有效），未登录时返回 0
+func (h *AuthHandler) identityRequestUserID(c *gin.Context) uint {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" || utils.IsPersonalAccessToken(parts[1]) {
+		return 0
+	}
+	claims, err := auth.ParseAccessToken(parts[1])
+	if err != nil || utils.ValidateTokenSession(h.db, claims) != nil {
+		return 0
+	}
+	return claims.UserID
+}
+
+// GetIdentityProviders 获取已启用的身份提供方（登录页展示）
+func (h *AuthHandler) GetIdentityProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, 2)
+	for _, name := range []string{utils.IdentityProviderOIDC, utils.IdentityProviderWeChat} {
+		if provider, errMsg := h.identityProvider(c, name); errMsg == "" {
+			providers = append(providers, gin.H{"name": provider.Name(), "display_name": provider.DisplayName()})
+		}
+	}
+	utils.Success(c, providers)
+}
+
+// IdentityAuthorize 发起外部登录，返回身份提供方的授权地址
+func (h *AuthHandler) IdentityAuthorize(c *gin.Context) {
+	h.startIdentityAuth(c, utils.IdentityPurposeLogin, 0)
+}
+
+// BindIdentity 已登录用户发起外部账号绑定，返回身份提供方的授权地址
+func (h *AuthHandler) BindIdentity(c *gin.Context) {
+	h.startIdentityAuth(c, utils.IdentityPurposeBind, utils.GetUserID(c))
+}
+
+func (h *AuthHandler) startIdentityAuth(c *gin.Context, purpose string, userID uint) {
+	provider, errMsg := h.identityProvider(c, c.Param("provider"))
+	if errMsg != "" {
+		utils.Error(c, 400, errMsg)
+		return
+	}
+
+	req, err := utils.NewIdentityAuthRequest(h.db, provider.Name(), purpose, userID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "创建授权请求失败")
+		return
+	}
+	authURL, err := provider.AuthURL(req)
+	if err != nil {
+		h.db.Delete(req)
+		utils.Error(c, utils.CodeError, "获取授权地址失败: "+err.Error())
+		return
+	}
+
+	setIdentityStateCookie(c, req.State, int(utils.IdentityAuthRequestTTL/time.Second))
+	utils.Success(c, gin.H{
+		"provider":   provider.Name(),
+		"auth_url":   authURL,
+		"state":      req.State,
+		"expires_in": int(utils.IdentityAuthRequestTTL / time.Second),
+	})
+}
+
+// IdentityCallback 处理授权回调：前端将回调地址中的 code 和 state 提交到这里
+// state 必须与发起授权时写入浏览器的 Cookie 一致；登录请求完成登录（可能需要两步验证），
+// 绑定请求必须由发起绑定的用户在登录状态下提交，将外部账号绑定到该用户
+func (h *AuthHandler) IdentityCallback(c *gin.Context) {
+	var req struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	provider, errMsg := h.identityProvider(c, c.Param("provider"))
+	if errMsg != "" {
+		utils.Error(c, 400, errMsg)
+		return
+	}
+
+	if !identityStateMatches(c, req.State) {
+		utils.Error(c, 400, utils.ErrIdentityStateInvalid.Error())
+		return
+	}
+	authRequest, err := utils.ConsumeIdentityAuthRequest(h.db, provider.Name(), req.State)
+	if err != nil {
+		if errors.Is(err, utils.ErrIdentityStateInvalid) {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "查询授权请求失败")
+		}
+		return
+	}
+	setIdentityStateCookie(c, "", -1)
+
+	if authRequest.Purpose == utils.IdentityPurposeBind && h.identityRequestUserID(c) != authRequest.UserID {
+		utils.Error(c, 403, "请使用发起绑定的账号登录后完成绑定")
+		return
+	}
+
+	identity, err := provider.Exchange(req.Code, authRequest)
+	if err != nil {
+		if utils.Logger != nil {
+			utils.Logger.Warnf("外部身份认证失败: provider=%s, error=%v", provider.Name(), err)
+		}
+		utils.RecordAuditLog(h.db, authRequest.UserID, "", "login", "user", 0, c, false, provider.Name()+"认证失败", "")
+		utils.Error(c, 401, "身份认证失败: "+err.Error())
+		return
+	}
+
+	if authRequest.Purpose == utils.IdentityPurposeBind {
+		h.completeIdentityBind(c, authRequest, identity)
+		return
+	}
+
+	user, err := utils.FindUserByIdentity(h.db, identity)
+	if errors.Is(err, gorm.ErrRecordNotFound) && provider.Name() == utils.IdentityProviderOIDC && config.AppConfig.OIDC.AutoCreate {
+		user, err = utils.ProvisionIdentityUser(h.db, config.AppConfig.OIDC, identity)
+		if err != nil {
+			utils.RecordAuditLog(h.db, 0, identity.Username, "login", "user", 0, c, false, err.Error(), "")
+			if errors.Is(err, utils.ErrIdentityUsernameTaken) {
+				utils.Error(c, 409, err.Error())
+			} else {
+				utils.Error(c, utils.CodeError, "创建用户失败: "+err.Error())
+			}
+			return
+		}
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.RecordAuditLog(h.db, 0, identity.Username, "login", "user", 0, c, false, "外部账号未关联用户", "")
+		utils.Error(c, 404, utils.ErrIdentityNotLinked.Error())
+		return
+	} else if err != nil {
+		utils.Error(c, utils.CodeError, "查询用户失败")
+		return
+	}
+
+	if user.Status != 1 {
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "用户已被禁用", "")
+		utils.Error(c, 403, "用户已被禁用")
+		return
+	}
+	if user.IsServiceAccount {
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "服务账号不能登录", "")
+		utils.Error(c, 403, utils.ErrServiceAccountLogin.Error())
+		return
+	}
+
+	h.finishLogin(c, user, provider.Name())
+}
+
+// completeIdentityBind 将外部账号绑定到发起绑定的用户
+func (h *AuthHandler) completeIdentityBind(c *gin.Context, authRequest *model.IdentityAuthRequest, identity *utils.ExternalIdentity) {
+	var user model.User
+	if err := h.db.First(&user, authRequest.UserID).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	if err := utils.LinkIdentity(h.db, &user, identity); err != nil {
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "bind", "user_identity", user.ID, c, false, err.Error(), "")
+		utils.Error(c, 409, err.Error())
+		return
+	}
+	utils.RecordAuditLog(h.db, user.ID, user.Username, "bind", "user_identity", user.ID, c, true, "", identity.Provider)
+	utils.Success(c, gin.H{
+		"message":  "绑定成功",
+		"provider": identity.Provider,
+		"email":    identity.Email,
+	})
+}
+
+// UnbindIdentity 解除外部账号绑定
+func (h *AuthHandler) UnbindIdentity(c *gin.Context) {
+	provider := c.Param("provider")
+	if provider != utils.IdentityProviderOIDC && provider != utils.IdentityProviderWeChat {
+		utils.Error(c, 400, "不支持的身份提供方: "+provider)
+		return
+	}
+
+	userID := utils.GetUserID(c)
+	var user model.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	// 通过外部账号创建、没有本地密码的用户解绑后将无法登录
+	if user.AuthSource == utils.AuthSourceOIDC && provider == utils.IdentityProviderOIDC && user.Password == "" {
+		utils.Error(c, 400, "该账号通过单点登录创建，请先设置密码后再解绑")
+		return
+	}
+
+	unlinked, err := utils.UnlinkIdentity(h.db, userID, provider)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "解绑失败")
+		return
+	}
+	if !unlinked {
+		utils.Error(c, 400, "您尚未绑定该账号")
+		return
+	}
+	utils.RecordAuditLog(h.db, user.ID, user.Username, "unbind", "user_identity", user.ID, c, true, "", provider)
+	utils.Success(c, gin.H{"message": "解绑成功"})
+}
+
+// GetMyIdentities 获取当前用户已绑定的外部账号
+func (h *AuthHandler) GetMyIdentities(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	var identities []model.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询绑定账号失败")
+		return
+	}
+
+	var user model.User
+	if err := h.db.First(&user, userID).Error; err == nil && user.WeChatOpenID != nil && *user.WeChatOpenID != "" {
+		identities = append(identities, model.UserIdentity{UserID: user.ID, Provider: utils.IdentityProviderWeChat, Subject: *user.WeChatOpenID})
+	}
+	utils.Success(c, identities)
+}
@@ -6,7 +6,6 @@ import (
 	"prjflow/internal/config"
 	"prjflow/internal/model"
 	"prjflow/internal/utils"
-	"prjflow/pkg/auth"
 	"prjflow/pkg/wechat"
 
 	"github.com/gin-gonic/gin"
@@ -261,11 +260,15 @@ func (h *InitHandler) InitSystem(c *gin.Context) {
 
 	// 生成管理员Token（可选，用于自动登录）
 	roleNames := []string{"admin"}
-	token, _ := auth.GenerateToken(adminUser.ID, adminUser.Username, roleNames)
+	var token, refreshToken string
+	if tokens, err := utils.CreateSession(h.db, &adminUser, roleNames, "init", c); err == nil {
+		token, refreshToken = tokens.Token, tokens.RefreshToken
+	}
 
 	utils.Success(c, gin.H{
-		"message": "系统初始化成功",
-		"token":   token,
+		"message":       "系统初始化成功",
+		"token":         token,
+		"refresh_token": refreshToken,
 		"user": gin.H{
 			"id":       adminUser.ID,
 			"username": adminUser.Username,
@@ -374,10 +377,18 @@ func (h *InitHandler) GetInitQRCode(c *gin.Context) {
 
 // InitSystemWithPassword 通过密码登录完成初始化（第二步：创建管理员）
 func (h *InitHandler) InitSystemWithPassword(c *gin.Context) {
+	// 该接口无需认证，按IP限制失败次数
+	ip := c.ClientIP()
+	if blocked := utils.CheckLoginAllowed(h.db, "", ip); blocked != nil {
+		utils.RespondLoginBlocked(c, blocked)
+		return
+	}
+
 	// 检查是否已经初始化
 	var existingConfig model.SystemConfig
 	result := h.db.Where("key = ?", "initialized").First(&existingConfig)
 	if result.Error == nil && existingConfig.Value == "true" {
+		utils.RecordLoginFailure(h.db, "", ip)
 		utils.Error(c, 400, "系统已经初始化，无法重复初始化")
 		return
 	}
@@ -396,6 +407,7 @@ func (h *InitHandler) InitSystemWithPassword(c *gin.Context) {
 	// 检查用户名是否已存在
 	var existingUser model.User
 	if err := h.db.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
+		utils.RecordLoginFailure(h.db, "", ip)
 		utils.Error(c, 400, "用户名已存在")
 		return
 	}
@@ -477,15 +489,16 @@ func (h *InitHandler) InitSystemWithPassword(c *gin.Context) {
 
 	// 生成管理员Token
 	roleNames := []string{"admin"}
-	token, err := auth.GenerateToken(adminUser.ID, adminUser.Username, roleNames)
+	tokens, err := utils.CreateSession(h.db, &adminUser, roleNames, "init", c)
 	if err != nil {
 		utils.Error(c, utils.CodeError, "生成Token失败")
 		return
 	}
 
 	utils.Success(c, gin.H{
-		"message": "系统初始化成功",
-		"token":   token,
+		"message":       "系统初始化成功",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
 		"user": gin.H{
 			"id":       adminUser.ID,
 			"username": adminUser.Username,
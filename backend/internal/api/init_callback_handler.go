@@ -3,7 +3,7 @@ package api
 import (
 	"gorm.io/gorm"
 	"prjflow/internal/model"
-	"prjflow/pkg/auth"
+	"prjflow/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -112,10 +112,11 @@ func (h *InitCallbackHandlerImpl) Process(ctx *WeChatCallbackContext) (interface
 
 	// 生成管理员Token
 	roleNames := []string{"admin"}
-	token, err := auth.GenerateToken(adminUser.ID, adminUser.Username, roleNames)
+	tokens, err := utils.CreateSession(ctx.DB, &adminUser, roleNames, "init", ctx.Context)
 	if err != nil {
 		return nil, &CallbackError{Message: "生成Token失败", Err: err}
 	}
+	token := tokens.Token
 
 	// 通过WebSocket通知PC端成功
 	if ctx.Ticket != "" && ctx.Hub != nil {
@@ -110,6 +110,9 @@ func (h *ModuleHandler) UpdateModule(c *gin.Context) {
 		utils.Error(c, 404, "功能模块不存在")
 		return
 	}
+	if !h.requireModulePermission(c, module.ID, "project:update") {
+		return
+	}
 
 	var req struct {
 		Name        string `json:"name"`
@@ -157,6 +160,9 @@ func (h *ModuleHandler) DeleteModule(c *gin.Context) {
 		utils.Error(c, 404, "功能模块不存在")
 		return
 	}
+	if !h.requireModulePermission(c, module.ID, "project:delete") {
+		return
+	}
 
 	// 检查是否有关联的Bug
 	var bugCount int64
@@ -174,3 +180,17 @@ func (h *ModuleHandler) DeleteModule(c *gin.Context) {
 	utils.Success(c, nil)
 }
 
+// requireModulePermission 功能模块由多个项目共用：修改或删除前，需要在每个有Bug引用该模块的项目中都拥有相应的项目角色权限
+func (h *ModuleHandler) requireModulePermission(c *gin.Context, moduleID uint, permCode string) bool {
+	var projectIDs []uint
+	if err := h.db.Model(&model.Bug{}).Where("module_id = ?", moduleID).Distinct().Pluck("project_id", &projectIDs).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return false
+	}
+	for _, projectID := range projectIDs {
+		if !utils.RequireProjectPermission(h.db, c, projectID, permCode) {
+			return false
+		}
+	}
+	return true
+}
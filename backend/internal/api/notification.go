@@ -0,0 +1,246 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+type NotificationHandler struct {
+	db *gorm.DB
+}
+
+func NewNotificationHandler(db *gorm.DB) *NotificationHandler {
+	return &NotificationHandler{db: db}
+}
+
+// GetNotifications 获取当前用户的通知列表
+// 支持 is_read（true/false）和 type 过滤
+func (h *NotificationHandler) GetNotifications(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	page := utils.GetPage(c)
+	pageSize := utils.GetPageSize(c)
+	offset := (page - 1) * pageSize
+
+	query := h.db.Model(&model.Notification{}).Where("user_id = ?", userID)
+	switch c.Query("is_read") {
+	case "true", "1":
+		query = query.Where("is_read = ?", true)
+	case "false", "0":
+		query = query.Where("is_read = ?", false)
+	}
+	if notificationType := c.Query("type"); notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var notifications []model.Notification
+	if err := query.Preload("Actor").Order("id DESC").Offset(offset).Limit(pageSize).Find(&notifications).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":      notifications,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetUnreadCount 获取当前用户的未读通知数
+func (h *NotificationHandler) GetUnreadCount(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	var count int64
+	h.db.Model(&model.Notification{}).Where("user_id = ? AND is_read = ?", userID, false).Count(&count)
+
+	utils.Success(c, gin.H{"count": count})
+}
+
+// MarkNotificationRead 标记单条通知为已读
+func (h *NotificationHandler) MarkNotificationRead(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	var notification model.Notification
+	if err := h.db.Where("user_id = ?", userID).First(&notification, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "通知不存在")
+		return
+	}
+
+	if !notification.IsRead {
+		now := time.Now()
+		notification.IsRead = true
+		notification.ReadAt = &now
+		if err := h.db.Model(&notification).Updates(map[string]interface{}{"is_read": true, "read_at": now}).Error; err != nil {
+			utils.Error(c, utils.CodeError, "更新失败")
+			return
+		}
+	}
+
+	utils.Success(c, notification)
+}
+
+// MarkAllNotificationsRead 标记当前用户的全部通知为已读（可按 type 限定）
+func (h *NotificationHandler) MarkAllNotificationsRead(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	query := h.db.Model(&model.Notification{}).Where("user_id = ? AND is_read = ?", userID, false)
+	if notificationType := c.Query("type"); notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+	result := query.Updates(map[string]interface{}{"is_read": true, "read_at": time.Now()})
+	if result.Error != nil {
+		utils.Error(c, utils.CodeError, "更新失败")
+		return
+	}
+
+	utils.Success(c, gin.H{"updated": result.RowsAffected})
+}
+
+// DeleteNotification 删除通知
+func (h *NotificationHandler) DeleteNotification(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	var notification model.Notification
+	if err := h.db.Where("user_id = ?", userID).First(&notification, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "通知不存在")
+		return
+	}
+
+	if err := h.db.Delete(&notification).Error; err != nil {
+		utils.Error(c, utils.CodeError, "删除失败")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "删除成功"})
+}
+
+// notificationPreferenceItem 通知偏好项
+type notificationPreferenceItem struct {
+	EventType string `json:"event_type"`
+	Channel   string `json:"channel"`
+	Enabled   bool   `json:"enabled"`
+	Mode      string `json:"mode,omitempty"` // 邮件发送方式：immediate(立即), digest(每日摘要)
+}
+
+// GetNotificationPreferences 获取当前用户的通知偏好（返回所有类型和渠道的组合，未设置的默认接收，邮件默认立即发送）
+func (h *NotificationHandler) GetNotificationPreferences(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	var prefs []model.NotificationPreference
+	h.db.Where("user_id = ?", userID).Find(&prefs)
+	saved := make(map[string]model.NotificationPreference, len(prefs))
+	for _, pref := range prefs {
+		saved[pref.EventType+"|"+pref.Channel] = pref
+	}
+
+	items := make([]notificationPreferenceItem, 0, len(utils.NotificationEventTypes)*len(utils.NotificationChannels))
+	for _, eventType := range utils.NotificationEventTypes {
+		for _, channel := range utils.NotificationChannels {
+			item := notificationPreferenceItem{EventType: eventType, Channel: channel, Enabled: true}
+			if pref, ok := saved[eventType+"|"+channel]; ok {
+				item.Enabled = pref.Enabled
+				item.Mode = pref.Mode
+			}
+			if channel == utils.NotificationChannelEmail && item.Mode == "" {
+				item.Mode = utils.EmailModeImmediate
+			}
+			items = append(items, item)
+		}
+	}
+
+	utils.Success(c, gin.H{
+		"event_types":   utils.NotificationEventTypes,
+		"channels":      utils.NotificationChannels,
+		"preferences":   items,
+		"email_enabled": utils.EmailEnabled(),
+	})
+}
+
+// UpdateNotificationPreferences 批量更新当前用户的通知偏好
+func (h *NotificationHandler) UpdateNotificationPreferences(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	var req struct {
+		Preferences []notificationPreferenceItem `json:"preferences" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+
+	for _, item := range req.Preferences {
+		if !utils.IsValidNotificationEventType(item.EventType) {
+			utils.Error(c, 400, "不支持的通知类型："+item.EventType)
+			return
+		}
+		if !utils.IsValidNotificationChannel(item.Channel) {
+			utils.Error(c, 400, "不支持的通知渠道："+item.Channel)
+			return
+		}
+		if item.Mode != "" && item.Mode != utils.EmailModeImmediate && item.Mode != utils.EmailModeDigest {
+			utils.Error(c, 400, "不支持的发送方式："+item.Mode)
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		for _, item := range req.Preferences {
+			// 发送方式仅对邮件渠道有效
+			mode := ""
+			if item.Channel == utils.NotificationChannelEmail {
+				mode = item.Mode
+				if mode == "" {
+					mode = utils.EmailModeImmediate
+				}
+			}
+			var pref model.NotificationPreference
+			err := tx.Where("user_id = ? AND event_type = ? AND channel = ?", userID, item.EventType, item.Channel).First(&pref).Error
+			if err == gorm.ErrRecordNotFound {
+				pref = model.NotificationPreference{UserID: userID, EventType: item.EventType, Channel: item.Channel, Enabled: item.Enabled, Mode: mode}
+				if err := tx.Create(&pref).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&pref).Updates(map[string]interface{}{"enabled": item.Enabled, "mode": mode}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "保存失败")
+		return
+	}
+
+	h.GetNotificationPreferences(c)
+}
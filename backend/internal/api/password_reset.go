@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// passwordResetRequestedMessage 申请重置的统一提示，不透露账号是否存在
+const passwordResetRequestedMessage = "如果账号存在且已绑定邮箱或微信，重置链接已发送，请注意查收"
+
+// RequestPasswordReset 申请重置密码：按用户名或邮箱查找用户，生成一次性重置令牌并通过邮件或微信发送
+// 账号不存在、不允许重置或没有可用渠道时同样返回成功，避免探测账号；不存在的账号计入IP登录失败次数
+func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
+	var req struct {
+		Account string `json:"account" binding:"required"` // 用户名或邮箱
+		Channel string `json:"channel"`                    // 发送渠道：email, wechat，为空时自动选择
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+	if !utils.PasswordResetEnabled() {
+		utils.Error(c, 403, utils.ErrPasswordResetDisabled.Error())
+		return
+	}
+
+	ip := c.ClientIP()
+	if blocked := utils.CheckLoginAllowed(h.db, "", ip); blocked != nil {
+		utils.RespondLoginBlocked(c, blocked)
+		return
+	}
+
+	user, err := utils.FindPasswordResetUser(h.db, req.Account)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.Error(c, utils.CodeError, "查询用户失败")
+			return
+		}
+		utils.RecordLoginFailure(h.db, "", ip)
+		utils.RecordAuditLog(h.db, 0, req.Account, "password_reset_request", "user", 0, c, false, "账号不存在", "")
+		utils.Success(c, gin.H{"message": passwordResetRequestedMessage})
+		return
+	}
+
+	channel, err := utils.RequestPasswordReset(h.db, user, req.Channel, ip)
+	if err != nil {
+		if utils.Logger != nil && !errors.Is(err, utils.ErrPasswordResetNotAllowed) && !errors.Is(err, utils.ErrPasswordResetTooFrequent) {
+			utils.Logger.Warnf("发送重置密码信息失败: user_id=%d, error=%v", user.ID, err)
+		}
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "password_reset_request", "user", user.ID, c, false, err.Error(), channel)
+		utils.Success(c, gin.H{"message": passwordResetRequestedMessage})
+		return
+	}
+
+	utils.RecordAuditLog(h.db, user.ID, user.Username, "password_reset_request", "user", user.ID, c, true, "", channel)
+	utils.Success(c, gin.H{"message": passwordResetRequestedMessage})
+}
+
+// VerifyPasswordResetToken 校验重置令牌（前端打开重置页面时调用）
+func (h *AuthHandler) VerifyPasswordResetToken(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	token, err := utils.ValidatePasswordResetToken(h.db, req.Token)
+	if err != nil {
+		h.respondPasswordResetError(c, err)
+		return
+	}
+	utils.Success(c, gin.H{
+		"username":   token.User.Username,
+		"expires_at": token.ExpiresAt,
+	})
+}
+
+// ConfirmPasswordReset 使用重置令牌设置新密码，成功后用户的全部会话失效，需要重新登录
+func (h *AuthHandler) ConfirmPasswordReset(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	token, err := utils.ResetPasswordWithToken(h.db, req.Token, req.NewPassword)
+	if err != nil {
+		var userID uint
+		var username string
+		if token != nil && token.User != nil {
+			userID, username = token.User.ID, token.User.Username
+		}
+		utils.RecordAuditLog(h.db, userID, username, "password_reset", "user", userID, c, false, err.Error(), "")
+		h.respondPasswordResetError(c, err)
+		return
+	}
+
+	utils.RecordAuditLog(h.db, token.User.ID, token.User.Username, "password_reset", "user", token.User.ID, c, true, "", token.Channel)
+	utils.Success(c, gin.H{"message": "密码已重置，请使用新密码登录"})
+}
+
+func (h *AuthHandler) respondPasswordResetError(c *gin.Context, err error) {
+	var validationErr *utils.PasswordValidationError
+	switch {
+	case errors.Is(err, utils.ErrPasswordResetTokenInvalid), errors.Is(err, utils.ErrPasswordResetNotAllowed), errors.As(err, &validationErr):
+		utils.Error(c, 400, err.Error())
+	default:
+		utils.Error(c, utils.CodeError, "重置密码失败")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"gorm.io/gorm"
 	"prjflow/internal/model"
 	"prjflow/internal/utils"
+	"prjflow/pkg/permission"
 )
 
 type PermissionHandler struct {
@@ -407,6 +408,7 @@ func (h *PermissionHandler) AssignRolePermissions(c *gin.Context) {
 		utils.Error(c, utils.CodeError, "分配权限失败")
 		return
 	}
+	permission.InvalidateCache(h.db)
 
 	utils.Success(c, gin.H{"message": "分配成功"})
 }
@@ -451,6 +453,7 @@ func (h *PermissionHandler) AssignUserRoles(c *gin.Context) {
 		utils.Error(c, utils.CodeError, "分配角色失败")
 		return
 	}
+	permission.InvalidateCache(h.db)
 
 	utils.Success(c, gin.H{"message": "分配成功"})
 }
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"errors"
+	"strconv"
 	"time"
 
 	"prjflow/internal/model"
 	"prjflow/internal/utils"
+	"prjflow/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -257,7 +260,7 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 		return
 	}
 
-	// 自动将创建者添加为项目成员（角色：项目经理）
+	// 自动将创建者添加为项目成员（角色：负责人）
 	userID := utils.GetUserID(c)
 	if userID > 0 {
 		// 检查是否已经是成员（防止重复）
@@ -267,7 +270,7 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 			creatorMember := model.ProjectMember{
 				ProjectID: project.ID,
 				UserID:    userID,
-				Role:      "项目经理", // 创建者默认为项目经理
+				Role:      utils.ProjectRoleOwner, // 创建者默认为项目负责人
 			}
 			if err := h.db.Create(&creatorMember).Error; err != nil {
 				// 如果添加成员失败，记录错误但不影响项目创建
@@ -307,6 +310,9 @@ func (h *ProjectHandler) UpdateProject(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该项目")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:update") {
+		return
+	}
 
 	// 保存旧对象用于比较
 	oldProject := project
@@ -418,6 +424,9 @@ func (h *ProjectHandler) DeleteProject(c *gin.Context) {
 		utils.Error(c, 403, "没有权限删除该项目")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:delete") {
+		return
+	}
 
 	// 检查是否有任务、Bug、需求等关联数据
 	var count int64
@@ -473,6 +482,62 @@ func (h *ProjectHandler) GetProjectMembers(c *gin.Context) {
 	utils.Success(c, members)
 }
 
+// GetProjectAccess 解释用户对项目某个权限的访问决策，如 ?permission=bug:update
+// 默认解释当前用户；管理员和项目负责人可以通过 user_id 查询其他成员
+func (h *ProjectHandler) GetProjectAccess(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+
+	permCode := c.Query("permission")
+	if permCode == "" {
+		utils.Error(c, 400, "请指定权限代码")
+		return
+	}
+	var count int64
+	h.db.Model(&model.Permission{}).Where("code = ?", permCode).Count(&count)
+	if count == 0 {
+		utils.Error(c, 400, "权限不存在: "+permCode)
+		return
+	}
+
+	currentUserID := utils.GetUserID(c)
+	targetUserID := currentUserID
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			utils.Error(c, 400, "无效的用户ID")
+			return
+		}
+		targetUserID = uint(id)
+	}
+
+	var decision *utils.ProjectAccessDecision
+	var err error
+	if targetUserID == currentUserID {
+		decision, err = utils.ExplainProjectAccess(h.db, c, project.ID, permCode)
+	} else {
+		if !utils.IsAdmin(c) && !(utils.CheckProjectAccess(h.db, c, project.ID) && utils.CheckProjectPermission(h.db, c, project.ID, "project:manage")) {
+			utils.Error(c, 403, "只有管理员和项目负责人可以查看其他用户的访问权限")
+			return
+		}
+		var user model.User
+		if err := h.db.First(&user, targetUserID).Error; err != nil {
+			utils.Error(c, 404, "用户不存在")
+			return
+		}
+		decision, err = utils.ExplainUserProjectAccess(h.db, user.ID, project.ID, permCode)
+	}
+	if err != nil {
+		utils.Error(c, utils.CodeError, "权限检查失败")
+		return
+	}
+
+	utils.Success(c, decision)
+}
+
 // GetProjectGantt 获取项目甘特图数据
 func (h *ProjectHandler) GetProjectGantt(c *gin.Context) {
 	projectID := c.Param("id")
@@ -514,10 +579,35 @@ func (h *ProjectHandler) GetProjectGantt(c *gin.Context) {
 		Assignee       string   `json:"assignee,omitempty"`
 		EstimatedHours *float64 `json:"estimated_hours,omitempty"`
 		Dependencies   []uint   `json:"dependencies,omitempty"`
+		// 任务分解结构：汇总任务（有子任务）的日期覆盖所有子孙任务，显示为汇总条
+		ParentID *uint  `json:"parent_id,omitempty"`
+		WBS      string `json:"wbs"`
+		Level    int    `json:"level"`
+		Summary  bool   `json:"summary"`
+		// 排期结果（依赖存在循环时为空）
+		DependencyLinks []utils.ScheduleDependency `json:"dependency_links,omitempty"`
+		EarlyStart      string                     `json:"early_start,omitempty"`
+		EarlyFinish     string                     `json:"early_finish,omitempty"`
+		LateStart       string                     `json:"late_start,omitempty"`
+		LateFinish      string                     `json:"late_finish,omitempty"`
+		Slack           *int                       `json:"slack,omitempty"`
+		Critical        bool                       `json:"critical"`
+		Violation       bool                       `json:"violation"` // 计划日期违反依赖关系
+	}
+
+	// 关键路径和依赖冲突
+	schedule, scheduleErr := utils.BuildProjectSchedule(h.db, &project, nil)
+	violated := make(map[uint]bool)
+	if scheduleErr == nil {
+		for _, violation := range schedule.Violations {
+			violated[violation.TaskID] = true
+		}
 	}
 
+	// 按 WBS 顺序输出，父任务在子任务之前
 	ganttTasks := make([]GanttTask, 0, len(tasks))
-	for _, task := range tasks {
+	for _, node := range utils.FlattenTaskTree(utils.BuildTaskTree(tasks)) {
+		task := node.Task
 		ganttTask := GanttTask{
 			ID:             task.ID,
 			Title:          task.Title,
@@ -525,14 +615,22 @@ func (h *ProjectHandler) GetProjectGantt(c *gin.Context) {
 			Status:         task.Status,
 			Priority:       task.Priority,
 			EstimatedHours: task.EstimatedHours,
+			ParentID:       task.ParentID,
+			WBS:            node.WBS,
+			Level:          node.Level,
+			Summary:        node.Summary,
 		}
 
-		// 格式化日期
-		if task.StartDate != nil {
-			ganttTask.StartDate = task.StartDate.Format("2006-01-02")
+		// 格式化日期（汇总任务取子孙任务中最早的开始日期和最晚的结束日期）
+		startDate, endDate := task.StartDate, task.EndDate
+		if node.Summary {
+			startDate, endDate = node.SummaryDates()
 		}
-		if task.EndDate != nil {
-			ganttTask.EndDate = task.EndDate.Format("2006-01-02")
+		if startDate != nil {
+			ganttTask.StartDate = startDate.Format("2006-01-02")
+		}
+		if endDate != nil {
+			ganttTask.EndDate = endDate.Format("2006-01-02")
 		}
 		if task.DueDate != nil {
 			ganttTask.DueDate = task.DueDate.Format("2006-01-02")
@@ -556,12 +654,105 @@ func (h *ProjectHandler) GetProjectGantt(c *gin.Context) {
 			ganttTask.Dependencies = dependencyIDs
 		}
 
+		if scheduleErr == nil {
+			if scheduled := schedule.Task(task.ID); scheduled != nil {
+				slack := scheduled.Slack
+				ganttTask.DependencyLinks = scheduled.Dependencies
+				ganttTask.EarlyStart, ganttTask.EarlyFinish = scheduled.EarlyStart, scheduled.EarlyFinish
+				ganttTask.LateStart, ganttTask.LateFinish = scheduled.LateStart, scheduled.LateFinish
+				ganttTask.Slack = &slack
+				ganttTask.Critical = scheduled.Critical
+			}
+			ganttTask.Violation = violated[task.ID]
+		}
+
 		ganttTasks = append(ganttTasks, ganttTask)
 	}
 
-	utils.Success(c, gin.H{
+	result := gin.H{
 		"tasks": ganttTasks,
-	})
+	}
+	if scheduleErr != nil {
+		result["schedule_error"] = scheduleErr.Error()
+	} else {
+		result["critical_path"] = schedule.CriticalPath
+		result["violations"] = schedule.Violations
+		result["project_start"] = schedule.StartDate
+		result["project_finish"] = schedule.FinishDate
+	}
+	utils.Success(c, result)
+}
+
+// GetProjectSchedule 获取项目排期：按任务依赖计算最早/最晚开始和完成时间、总时差和关键路径
+func (h *ProjectHandler) GetProjectSchedule(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	schedule, err := utils.BuildProjectSchedule(h.db, &project, nil)
+	if err != nil {
+		if errors.Is(err, utils.ErrDependencyCycle) {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "计算排期失败")
+		}
+		return
+	}
+	utils.Success(c, schedule)
+}
+
+// AutoScheduleProject 自动排期：把开始日期早于依赖约束的后续任务顺延，保持工期不变
+// task_id 不为空时只调整该任务的下游任务；dry_run 为 true 时只返回调整方案
+func (h *ProjectHandler) AutoScheduleProject(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+
+	var req struct {
+		TaskID *uint `json:"task_id"`
+		DryRun bool  `json:"dry_run"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.Error(c, 400, "参数错误")
+			return
+		}
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+	if !req.DryRun && !utils.RequireProjectPermission(h.db, c, project.ID, "task:update") {
+		return
+	}
+
+	schedule, err := utils.BuildProjectSchedule(h.db, &project, nil)
+	if err != nil {
+		if errors.Is(err, utils.ErrDependencyCycle) {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "计算排期失败")
+		}
+		return
+	}
+	shifts, err := utils.AutoSchedule(h.db, schedule, req.TaskID, utils.GetUserID(c), req.DryRun)
+	if err != nil {
+		if req.TaskID != nil && schedule.Task(*req.TaskID) == nil {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "自动排期失败")
+		}
+		return
+	}
+	utils.Success(c, gin.H{"shifts": shifts, "dry_run": req.DryRun})
 }
 
 // GetProjectProgress 获取项目进度跟踪数据
@@ -617,6 +808,50 @@ func (h *ProjectHandler) GetProjectProgress(c *gin.Context) {
 	})
 }
 
+// GetProjectBurndown 获取项目燃尽图/燃起图，默认统计项目开始日期（未设置时为创建日期）到结束日期
+func (h *ProjectHandler) GetProjectBurndown(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	start := project.CreatedAt
+	if project.StartDate != nil {
+		start = *project.StartDate
+	}
+	respondBurnChart(c, h.db, utils.BurnChartScope{
+		Type:      utils.BurnScopeProject,
+		ID:        project.ID,
+		StartDate: start,
+		EndDate:   burnChartEnd(project.EndDate),
+	})
+}
+
+// GetProjectFlowMetrics 获取项目的累积流图和周期时间/前置时间统计，object_type 为 task（默认）或 bug
+func (h *ProjectHandler) GetProjectFlowMetrics(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	objectType := c.DefaultQuery("object_type", utils.SprintItemTask)
+	if objectType != utils.SprintItemTask && objectType != utils.SprintItemBug {
+		utils.Error(c, 400, "对象类型只能是 task 或 bug")
+		return
+	}
+	respondFlowMetrics(c, h.db, utils.FlowMetricsScope{ObjectType: objectType, ProjectID: project.ID})
+}
+
 // getTaskProgressTrend 获取任务进度趋势
 func (h *ProjectHandler) getTaskProgressTrend(projectID uint, days int) []gin.H {
 	var tasks []model.Task
@@ -827,6 +1062,9 @@ func (h *ProjectHandler) AddProjectMembers(c *gin.Context) {
 		utils.Error(c, 403, "没有权限管理该项目成员")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:manage") {
+		return
+	}
 
 	var req struct {
 		UserIDs []uint `json:"user_ids" binding:"required"`
@@ -837,6 +1075,10 @@ func (h *ProjectHandler) AddProjectMembers(c *gin.Context) {
 		utils.Error(c, 400, "参数错误")
 		return
 	}
+	if !utils.IsValidProjectRole(req.Role) {
+		utils.Error(c, 400, "无效的项目角色，有效值：owner, member, viewer")
+		return
+	}
 
 	// 验证用户是否存在
 	var users []model.User
@@ -875,6 +1117,7 @@ func (h *ProjectHandler) AddProjectMembers(c *gin.Context) {
 			return
 		}
 	}
+	permission.InvalidateCache(h.db)
 
 	utils.Success(c, gin.H{"message": "添加成功"})
 }
@@ -895,6 +1138,9 @@ func (h *ProjectHandler) UpdateProjectMember(c *gin.Context) {
 		utils.Error(c, 403, "没有权限管理该项目成员")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, member.ProjectID, "project:manage") {
+		return
+	}
 
 	var req struct {
 		Role string `json:"role" binding:"required"`
@@ -904,12 +1150,17 @@ func (h *ProjectHandler) UpdateProjectMember(c *gin.Context) {
 		utils.Error(c, 400, "参数错误")
 		return
 	}
+	if !utils.IsValidProjectRole(req.Role) {
+		utils.Error(c, 400, "无效的项目角色，有效值：owner, member, viewer")
+		return
+	}
 
 	member.Role = req.Role
 	if err := h.db.Save(&member).Error; err != nil {
 		utils.Error(c, utils.CodeError, "更新失败")
 		return
 	}
+	permission.InvalidateCache(h.db)
 
 	utils.Success(c, member)
 }
@@ -931,11 +1182,15 @@ func (h *ProjectHandler) RemoveProjectMember(c *gin.Context) {
 		utils.Error(c, 403, "没有权限管理该项目成员")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, member.ProjectID, "project:manage") {
+		return
+	}
 
 	if err := h.db.Where("project_id = ? AND id = ?", projectID, memberID).Delete(&model.ProjectMember{}).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
 	}
+	permission.InvalidateCache(h.db)
 
 	utils.Success(c, gin.H{"message": "删除成功"})
 }
@@ -1014,6 +1269,9 @@ func (h *ProjectHandler) AddProjectHistoryNote(c *gin.Context) {
 		utils.Error(c, 403, "没有权限为该项目添加备注")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:update") {
+		return
+	}
 
 	var req struct {
 		Comment string `json:"comment" binding:"required"`
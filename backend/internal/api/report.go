@@ -540,6 +540,7 @@ func (h *ReportHandler) CreateDailyReport(c *gin.Context) {
 				}
 				h.db.Create(&approval)
 			}
+			h.notifyDailyReportApprovers(c, &report, approvers, nil)
 		}
 	}
 
@@ -604,6 +605,8 @@ func (h *ReportHandler) UpdateDailyReport(c *gin.Context) {
 		var approvers []model.User
 		if len(req.ApproverIDs) > 0 {
 			if err := h.db.Where("id IN ?", req.ApproverIDs).Find(&approvers).Error; err == nil {
+				var oldApproverIDs []uint
+				h.db.Model(&model.DailyReportApproval{}).Where("daily_report_id = ?", report.ID).Pluck("approver_id", &oldApproverIDs)
 				h.db.Model(&report).Association("Approvers").Replace(approvers)
 				// 删除旧的审批记录
 				h.db.Where("daily_report_id = ?", report.ID).Delete(&model.DailyReportApproval{})
@@ -616,6 +619,7 @@ func (h *ReportHandler) UpdateDailyReport(c *gin.Context) {
 					}
 					h.db.Create(&approval)
 				}
+				h.notifyDailyReportApprovers(c, &report, approvers, oldApproverIDs)
 			}
 		} else {
 			// 如果传入空数组，清空所有审批人关联和审批记录
@@ -772,6 +776,8 @@ func (h *ReportHandler) ApproveDailyReport(c *gin.Context) {
 		}
 	}
 
+	h.notifyDailyReportReviewed(c, &report, req.Status, req.Comment)
+
 	// 检查是否所有审批人都已审批
 	var pendingCount int64
 	h.db.Model(&model.DailyReportApproval{}).Where("daily_report_id = ? AND status = ?", report.ID, "pending").Count(&pendingCount)
@@ -986,6 +992,7 @@ func (h *ReportHandler) CreateWeeklyReport(c *gin.Context) {
 				}
 				h.db.Create(&approval)
 			}
+			h.notifyWeeklyReportApprovers(c, &report, approvers, nil)
 		}
 	}
 
@@ -1063,6 +1070,8 @@ func (h *ReportHandler) UpdateWeeklyReport(c *gin.Context) {
 		var approvers []model.User
 		if len(req.ApproverIDs) > 0 {
 			if err := h.db.Where("id IN ?", req.ApproverIDs).Find(&approvers).Error; err == nil {
+				var oldApproverIDs []uint
+				h.db.Model(&model.WeeklyReportApproval{}).Where("weekly_report_id = ?", report.ID).Pluck("approver_id", &oldApproverIDs)
 				h.db.Model(&report).Association("Approvers").Replace(approvers)
 				// 删除旧的审批记录
 				h.db.Where("weekly_report_id = ?", report.ID).Delete(&model.WeeklyReportApproval{})
@@ -1075,6 +1084,7 @@ func (h *ReportHandler) UpdateWeeklyReport(c *gin.Context) {
 					}
 					h.db.Create(&approval)
 				}
+				h.notifyWeeklyReportApprovers(c, &report, approvers, oldApproverIDs)
 			}
 		} else {
 			// 如果传入空数组，清空所有审批人关联和审批记录
@@ -1157,6 +1167,8 @@ func (h *ReportHandler) ApproveWeeklyReport(c *gin.Context) {
 		}
 	}
 
+	h.notifyWeeklyReportReviewed(c, &report, req.Status, req.Comment)
+
 	// 检查是否所有审批人都已审批
 	var pendingCount int64
 	h.db.Model(&model.WeeklyReportApproval{}).Where("weekly_report_id = ? AND status = ?", report.ID, "pending").Count(&pendingCount)
@@ -1249,3 +1261,83 @@ func (h *ReportHandler) UpdateWeeklyReportStatus(c *gin.Context) {
 	h.db.Preload("User").First(&report, report.ID)
 	utils.Success(c, report)
 }
+
+// notifyReportApprovers 通知新增的报告审批人（已是审批人的不重复通知）
+func (h *ReportHandler) notifyReportApprovers(c *gin.Context, objectType string, reportID uint, title string, approvers []model.User, oldApproverIDs []uint) {
+	existing := make(map[uint]bool, len(oldApproverIDs))
+	for _, id := range oldApproverIDs {
+		existing[id] = true
+	}
+	var approverIDs []uint
+	for _, approver := range approvers {
+		if !existing[approver.ID] {
+			approverIDs = append(approverIDs, approver.ID)
+		}
+	}
+	if len(approverIDs) == 0 {
+		return
+	}
+
+	content := ""
+	var author model.User
+	if err := h.db.First(&author, utils.GetUserID(c)).Error; err == nil {
+		name := author.Nickname
+		if name == "" {
+			name = author.Username
+		}
+		content = name + " 将你添加为审批人"
+	}
+
+	utils.Notify(h.db, approverIDs, utils.NotificationInput{
+		Type:       utils.NotificationReportApprovalRequested,
+		Title:      title,
+		Content:    content,
+		ObjectType: objectType,
+		ObjectID:   reportID,
+		ActorID:    utils.GetUserID(c),
+	})
+}
+
+// notifyDailyReportApprovers 通知日报的新增审批人
+func (h *ReportHandler) notifyDailyReportApprovers(c *gin.Context, report *model.DailyReport, approvers []model.User, oldApproverIDs []uint) {
+	title := "请审批日报：" + report.Date.Format("2006-01-02")
+	h.notifyReportApprovers(c, "daily_report", report.ID, title, approvers, oldApproverIDs)
+}
+
+// notifyWeeklyReportApprovers 通知周报的新增审批人
+func (h *ReportHandler) notifyWeeklyReportApprovers(c *gin.Context, report *model.WeeklyReport, approvers []model.User, oldApproverIDs []uint) {
+	title := "请审批周报：" + report.WeekStart.Format("2006-01-02") + " ~ " + report.WeekEnd.Format("2006-01-02")
+	h.notifyReportApprovers(c, "weekly_report", report.ID, title, approvers, oldApproverIDs)
+}
+
+// notifyReportReviewed 通知报告提交人审批结果
+func (h *ReportHandler) notifyReportReviewed(c *gin.Context, objectType string, reportID, authorID uint, title, comment string) {
+	utils.Notify(h.db, []uint{authorID}, utils.NotificationInput{
+		Type:       utils.NotificationReportReviewed,
+		Title:      title,
+		Content:    comment,
+		ObjectType: objectType,
+		ObjectID:   reportID,
+		ActorID:    utils.GetUserID(c),
+	})
+}
+
+// notifyDailyReportReviewed 通知日报提交人审批结果
+func (h *ReportHandler) notifyDailyReportReviewed(c *gin.Context, report *model.DailyReport, status, comment string) {
+	title := "日报" + reportReviewStatusText(status) + "：" + report.Date.Format("2006-01-02")
+	h.notifyReportReviewed(c, "daily_report", report.ID, report.UserID, title, comment)
+}
+
+// notifyWeeklyReportReviewed 通知周报提交人审批结果
+func (h *ReportHandler) notifyWeeklyReportReviewed(c *gin.Context, report *model.WeeklyReport, status, comment string) {
+	title := "周报" + reportReviewStatusText(status) + "：" + report.WeekStart.Format("2006-01-02") + " ~ " + report.WeekEnd.Format("2006-01-02")
+	h.notifyReportReviewed(c, "weekly_report", report.ID, report.UserID, title, comment)
+}
+
+// reportReviewStatusText 审批结果显示文本
+func reportReviewStatusText(status string) string {
+	if status == "approved" {
+		return "审批通过"
+	}
+	return "被驳回"
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,6 +24,36 @@ func (h *RequirementHandler) GetRequirements(c *gin.Context) {
 	var requirements []model.Requirement
 	query := h.db.Preload("Project").Preload("Creator").Preload("Assignee")
 
+	// 子孙需求筛选（ancestor_id 的所有子孙需求，不含自身）
+	var descendantIDs []uint
+	if ancestorID := c.Query("ancestor_id"); ancestorID != "" {
+		id, err := strconv.ParseUint(ancestorID, 10, 32)
+		if err != nil {
+			utils.Error(c, 400, "参数错误")
+			return
+		}
+		if descendantIDs, err = utils.RequirementDescendantIDs(h.db, uint(id)); err != nil {
+			utils.Error(c, utils.CodeError, "查询失败")
+			return
+		}
+	}
+	filterHierarchy := func(query *gorm.DB) *gorm.DB {
+		if descendantIDs != nil {
+			query = query.Where("id IN ?", append(descendantIDs, 0))
+		}
+		// 父需求筛选（parent_id=0 表示顶层需求）
+		if parentID := c.Query("parent_id"); parentID == "0" {
+			query = query.Where("parent_id IS NULL")
+		} else if parentID != "" {
+			query = query.Where("parent_id = ?", parentID)
+		}
+		if level := c.Query("level"); level != "" {
+			query = query.Where("level = ?", level)
+		}
+		return query
+	}
+	query = filterHierarchy(query)
+
 	// 权限过滤：普通用户只能看到自己创建或参与的需求
 	query = utils.FilterRequirementsByUser(h.db, c, query)
 
@@ -36,6 +67,9 @@ func (h *RequirementHandler) GetRequirements(c *gin.Context) {
 		query = query.Where("project_id = ?", projectID)
 	}
 
+	// 迭代筛选（sprint_id=0 表示未规划到迭代的待办池）
+	query = utils.FilterBySprint(query, c.Query("sprint_id"))
+
 	// 状态筛选
 	if status := c.Query("status"); status != "" {
 		query = query.Where("status = ?", status)
@@ -64,6 +98,7 @@ func (h *RequirementHandler) GetRequirements(c *gin.Context) {
 	var total int64
 	// 计算总数时需要应用与查询相同的筛选条件
 	countQuery := utils.FilterRequirementsByUser(h.db, c, h.db.Model(&model.Requirement{}))
+	countQuery = filterHierarchy(countQuery)
 
 	// 搜索
 	if keyword := c.Query("keyword"); keyword != "" {
@@ -75,6 +110,9 @@ func (h *RequirementHandler) GetRequirements(c *gin.Context) {
 		countQuery = countQuery.Where("project_id = ?", projectID)
 	}
 
+	// 迭代筛选（sprint_id=0 表示未规划到迭代的待办池）
+	countQuery = utils.FilterBySprint(countQuery, c.Query("sprint_id"))
+
 	// 状态筛选
 	if status := c.Query("status"); status != "" {
 		countQuery = countQuery.Where("status = ?", status)
@@ -101,6 +139,10 @@ func (h *RequirementHandler) GetRequirements(c *gin.Context) {
 		utils.Error(c, utils.CodeError, "查询失败")
 		return
 	}
+	if err := utils.FillRequirementProgress(h.db, requirements); err != nil {
+		utils.Error(c, utils.CodeError, "计算进度失败")
+		return
+	}
 
 	utils.Success(c, gin.H{
 		"list":      requirements,
@@ -127,6 +169,13 @@ func (h *RequirementHandler) GetRequirement(c *gin.Context) {
 		return
 	}
 
+	progress, err := utils.RequirementProgress(h.db, requirement.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "计算进度失败")
+		return
+	}
+	requirement.Progress = progress
+
 	utils.Success(c, requirement)
 }
 
@@ -138,6 +187,8 @@ func (h *RequirementHandler) CreateRequirement(c *gin.Context) {
 		Status         string   `json:"status"`
 		Priority       string   `json:"priority"`
 		ProjectID      uint     `json:"project_id" binding:"required"`
+		ParentID       *uint    `json:"parent_id"`
+		Level          string   `json:"level"`
 		AssigneeID     *uint    `json:"assignee_id"`
 		EstimatedHours *float64 `json:"estimated_hours"`
 	}
@@ -154,21 +205,13 @@ func (h *RequirementHandler) CreateRequirement(c *gin.Context) {
 		return
 	}
 
-	// 验证状态
-	if req.Status == "" {
-		req.Status = "draft"
-	}
-	validStatuses := map[string]bool{
-		"draft":     true,
-		"reviewing": true,
-		"active":    true,
-		"changing":  true,
-		"closed":    true,
-	}
-	if !validStatuses[req.Status] {
-		utils.Error(c, 400, "状态值无效，有效值：draft, reviewing, active, changing, closed")
+	// 验证状态：新建的需求必须处于项目工作流的初始状态（未指定时使用初始状态）
+	initialStatus, err := utils.ValidateWorkflowInitialState(h.db, "requirement", req.ProjectID, req.Status)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
 		return
 	}
+	req.Status = initialStatus
 
 	// 验证优先级
 	if req.Priority == "" {
@@ -197,6 +240,9 @@ func (h *RequirementHandler) CreateRequirement(c *gin.Context) {
 		utils.Error(c, 403, "没有权限在该项目中创建需求")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "requirement:create") {
+		return
+	}
 
 	// 如果指定了负责人，验证用户是否存在
 	if req.AssigneeID != nil {
@@ -213,12 +259,32 @@ func (h *RequirementHandler) CreateRequirement(c *gin.Context) {
 		return
 	}
 
+	// 验证层级和父需求
+	if req.Level == "" {
+		req.Level = utils.DefaultRequirementLevel()
+	}
+	if !utils.IsValidRequirementLevel(req.Level) {
+		utils.Error(c, 400, "需求层级无效")
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == 0 {
+		req.ParentID = nil
+	}
+	if req.ParentID != nil {
+		if err := utils.ValidateRequirementParent(h.db, &model.Requirement{ProjectID: req.ProjectID, Level: req.Level}, *req.ParentID); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+	}
+
 	requirement := model.Requirement{
 		Title:          req.Title,
 		Description:    req.Description,
 		Status:         req.Status,
 		Priority:       req.Priority,
 		ProjectID:      req.ProjectID, // 必填
+		ParentID:       req.ParentID,
+		Level:          req.Level,
 		CreatorID:      userID.(uint),
 		AssigneeID:     req.AssigneeID,
 		EstimatedHours: req.EstimatedHours,
@@ -257,6 +323,9 @@ func (h *RequirementHandler) UpdateRequirement(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该需求")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:update") {
+		return
+	}
 
 	// 保存旧对象用于比较
 	oldRequirement := requirement
@@ -267,6 +336,8 @@ func (h *RequirementHandler) UpdateRequirement(c *gin.Context) {
 		Status         *string  `json:"status"`
 		Priority       *string  `json:"priority"`
 		ProjectID      *uint    `json:"project_id"` // 更新时可选，但如果提供则必须有效
+		ParentID       *uint    `json:"parent_id"`  // 父需求ID，0 表示移为顶层需求
+		Level          *string  `json:"level"`
 		AssigneeID     *uint    `json:"assignee_id"`
 		EstimatedHours *float64 `json:"estimated_hours"`
 		ActualHours    *float64 `json:"actual_hours"` // 实际工时，会自动创建资源分配
@@ -287,16 +358,13 @@ func (h *RequirementHandler) UpdateRequirement(c *gin.Context) {
 		requirement.Description = *req.Description
 	}
 	if req.Status != nil {
-		// 验证状态
-		validStatuses := map[string]bool{
-			"draft":     true,
-			"reviewing": true,
-			"active":    true,
-			"changing":  true,
-			"closed":    true,
-		}
-		if !validStatuses[*req.Status] {
-			utils.Error(c, 400, "状态值无效，有效值：draft, reviewing, active, changing, closed")
+		if err := utils.CheckRequirementReviewLock(h.db, &requirement, *req.Status); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		// 根据工作流验证状态转换，本次请求提供的字段用于校验流转的必填字段
+		if err := utils.ValidateWorkflowTransition(h.db, c, "requirement", requirement.ProjectID, requirement.Status, *req.Status, utils.WorkflowFieldsFromRequest(req)); err != nil {
+			utils.Error(c, 400, err.Error())
 			return
 		}
 		requirement.Status = *req.Status
@@ -328,6 +396,35 @@ func (h *RequirementHandler) UpdateRequirement(c *gin.Context) {
 		}
 		requirement.ProjectID = *req.ProjectID
 	}
+	if req.Level != nil {
+		requirement.Level = *req.Level
+		if err := utils.ValidateRequirementLevel(h.db, &requirement); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+	}
+	if req.ParentID != nil {
+		if *req.ParentID == 0 {
+			requirement.ParentID = nil
+		} else {
+			requirement.ParentID = req.ParentID
+		}
+	}
+	// 父需求、层级或项目变化时重新验证父需求
+	if requirement.ParentID != nil && (req.ParentID != nil || req.Level != nil || requirement.ProjectID != oldRequirement.ProjectID) {
+		if err := utils.ValidateRequirementParent(h.db, &requirement, *requirement.ParentID); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+	}
+	if requirement.ProjectID != oldRequirement.ProjectID {
+		var children int64
+		h.db.Model(&model.Requirement{}).Where("parent_id = ?", requirement.ID).Count(&children)
+		if children > 0 {
+			utils.Error(c, 400, "有子需求的需求不能移动到其他项目")
+			return
+		}
+	}
 	if req.AssigneeID != nil {
 		// 验证负责人是否存在
 		if *req.AssigneeID != 0 {
@@ -473,6 +570,9 @@ func (h *RequirementHandler) DeleteRequirement(c *gin.Context) {
 		utils.Error(c, 403, "没有权限删除该需求")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:delete") {
+		return
+	}
 
 	// 检查是否有Bug关联
 	var count int64
@@ -482,6 +582,13 @@ func (h *RequirementHandler) DeleteRequirement(c *gin.Context) {
 		return
 	}
 
+	// 检查是否有子需求
+	h.db.Model(&model.Requirement{}).Where("parent_id = ?", id).Count(&count)
+	if count > 0 {
+		utils.Error(c, 400, "需求下存在子需求，无法删除")
+		return
+	}
+
 	if err := h.db.Delete(&model.Requirement{}, id).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
@@ -557,9 +664,13 @@ func (h *RequirementHandler) UpdateRequirementStatus(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该需求")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:update") {
+		return
+	}
 
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Status  string `json:"status" binding:"required"`
+		Comment string `json:"comment"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -567,16 +678,18 @@ func (h *RequirementHandler) UpdateRequirementStatus(c *gin.Context) {
 		return
 	}
 
-	// 验证状态
-	validStatuses := map[string]bool{
-		"draft":     true,
-		"reviewing": true,
-		"active":    true,
-		"changing":  true,
-		"closed":    true,
+	// 评审进行中时状态由评审结果决定
+	if err := utils.CheckRequirementReviewLock(h.db, &requirement, req.Status); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
 	}
-	if !validStatuses[req.Status] {
-		utils.Error(c, 400, "状态值无效，有效值：draft, reviewing, active, changing, closed")
+
+	// 根据工作流验证状态转换
+	oldStatus := requirement.Status
+	if err := utils.ValidateWorkflowTransition(h.db, c, "requirement", requirement.ProjectID, oldStatus, req.Status, map[string]string{
+		"comment": req.Comment,
+	}); err != nil {
+		utils.Error(c, 400, err.Error())
 		return
 	}
 
@@ -589,6 +702,20 @@ func (h *RequirementHandler) UpdateRequirementStatus(c *gin.Context) {
 	// 重新加载关联数据
 	h.db.Preload("Project").Preload("Creator").Preload("Assignee").First(&requirement, requirement.ID)
 
+	// 记录状态变更
+	if oldStatus != requirement.Status {
+		userID, exists := c.Get("user_id")
+		if exists {
+			dbValue, _ := c.Get("db")
+			if db, ok := dbValue.(*gorm.DB); ok {
+				actionID, _ := utils.RecordAction(db, "requirement", requirement.ID, "status_changed", userID.(uint), req.Comment, nil)
+				utils.RecordHistory(db, actionID, []utils.HistoryChange{
+					{Field: "status", Old: oldStatus, New: requirement.Status},
+				})
+			}
+		}
+	}
+
 	utils.Success(c, requirement)
 }
 
@@ -776,6 +903,9 @@ func (h *RequirementHandler) AddRequirementHistoryNote(c *gin.Context) {
 		utils.Error(c, 403, "没有权限为该需求添加备注")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:update") {
+		return
+	}
 
 	var req struct {
 		Comment string `json:"comment" binding:"required"`
@@ -820,6 +950,9 @@ func (h *RequirementHandler) AssignRequirement(c *gin.Context) {
 		utils.Error(c, 403, "没有权限分配该需求")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:update") {
+		return
+	}
 
 	// 获取旧的指派人ID
 	oldAssigneeID := requirement.AssigneeID
@@ -848,22 +981,23 @@ func (h *RequirementHandler) AssignRequirement(c *gin.Context) {
 	// 状态处理逻辑
 	oldStatus := requirement.Status
 	if req.Status != nil {
-		// 如果提供了状态，使用提供的状态
-		validStatuses := map[string]bool{
-			"draft":     true,
-			"reviewing": true,
-			"active":    true,
-			"changing":  true,
-			"closed":    true,
-		}
-		if !validStatuses[*req.Status] {
-			utils.Error(c, 400, "无效的状态值，有效值：draft, reviewing, active, changing, closed")
+		if err := utils.CheckRequirementReviewLock(h.db, &requirement, *req.Status); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		// 如果提供了状态，根据工作流验证状态转换
+		workflowFields := map[string]string{}
+		if req.Comment != nil {
+			workflowFields["comment"] = *req.Comment
+		}
+		if err := utils.ValidateWorkflowTransition(h.db, c, "requirement", requirement.ProjectID, requirement.Status, *req.Status, workflowFields); err != nil {
+			utils.Error(c, 400, err.Error())
 			return
 		}
 		requirement.Status = *req.Status
 	} else {
-		// 如果没有提供状态，自动修改：如果当前状态是 "draft" 或 "reviewing"，自动改为 "active"
-		if requirement.Status == "draft" || requirement.Status == "reviewing" {
+		// 如果没有提供状态，自动修改：未开始的需求（没有进行中的评审）在工作流允许时自动改为 "active"
+		if utils.OpenRequirementReview(h.db, requirement.ID) == nil && utils.CanAutoTransition(h.db, c, "requirement", requirement.ProjectID, requirement.Status, "active") {
 			requirement.Status = "active"
 		}
 	}
@@ -926,5 +1060,22 @@ func (h *RequirementHandler) AssignRequirement(c *gin.Context) {
 		}
 	}
 
+	// 指派人变更时通知新指派人
+	if oldAssigneeID == nil || *oldAssigneeID != req.AssigneeID {
+		notifyContent := ""
+		if req.Comment != nil {
+			notifyContent = *req.Comment
+		}
+		utils.Notify(h.db, []uint{req.AssigneeID}, utils.NotificationInput{
+			Type:       utils.NotificationRequirementAssigned,
+			Title:      "需求已指派给你：" + requirement.Title,
+			Content:    notifyContent,
+			ObjectType: "requirement",
+			ObjectID:   requirement.ID,
+			ProjectID:  requirement.ProjectID,
+			ActorID:    utils.GetUserID(c),
+		})
+	}
+
 	utils.Success(c, requirement)
 }
@@ -0,0 +1,338 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// loadRequirementForReview 加载需求并检查访问权限，失败时已写入响应
+func (h *RequirementHandler) loadRequirementForReview(c *gin.Context) (*model.Requirement, bool) {
+	var requirement model.Requirement
+	if err := h.db.First(&requirement, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "需求不存在")
+		return nil, false
+	}
+	if !utils.CheckRequirementAccess(h.db, c, requirement.ID) {
+		utils.Error(c, 403, "没有权限访问该需求")
+		return nil, false
+	}
+	return &requirement, true
+}
+
+// reloadRequirementReview 重新加载评审及评审意见
+func (h *RequirementHandler) reloadRequirementReview(review *model.RequirementReview) {
+	h.db.Preload("Creator").Preload("Votes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("Votes.Reviewer").First(review, review.ID)
+}
+
+// GetRequirementReviews 获取需求的评审记录（按轮次倒序）
+func (h *RequirementHandler) GetRequirementReviews(c *gin.Context) {
+	requirement, ok := h.loadRequirementForReview(c)
+	if !ok {
+		return
+	}
+
+	var reviews []model.RequirementReview
+	if err := h.db.Preload("Creator").Preload("Votes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("Votes.Reviewer").Where("requirement_id = ?", requirement.ID).Order("round DESC").Find(&reviews).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	utils.Success(c, reviews)
+}
+
+// StartRequirementReview 发起需求评审：指定评审人和评审规则，需求进入评审中状态
+func (h *RequirementHandler) StartRequirementReview(c *gin.Context) {
+	requirement, ok := h.loadRequirementForReview(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:update") {
+		return
+	}
+
+	var req struct {
+		ReviewerIDs []uint `json:"reviewer_ids" binding:"required"`
+		Rule        string `json:"rule"` // all(默认) 或 majority
+		Comment     string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+	if req.Rule == "" {
+		req.Rule = utils.ReviewRuleAll
+	}
+	if !utils.IsValidReviewRule(req.Rule) {
+		utils.Error(c, 400, "评审规则必须是 all 或 majority")
+		return
+	}
+
+	reviewerIDs := make([]uint, 0, len(req.ReviewerIDs))
+	seen := make(map[uint]bool)
+	for _, id := range req.ReviewerIDs {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			reviewerIDs = append(reviewerIDs, id)
+		}
+	}
+	if len(reviewerIDs) == 0 {
+		utils.Error(c, 400, "请指定评审人")
+		return
+	}
+	var reviewerCount int64
+	h.db.Model(&model.User{}).Where("id IN ?", reviewerIDs).Count(&reviewerCount)
+	if int(reviewerCount) != len(reviewerIDs) {
+		utils.Error(c, 400, "评审人不存在")
+		return
+	}
+	for _, reviewerID := range reviewerIDs {
+		if !utils.UserCanAccessProject(h.db, reviewerID, requirement.ProjectID) {
+			utils.Error(c, 400, "评审人没有该项目的访问权限")
+			return
+		}
+	}
+
+	if utils.OpenRequirementReview(h.db, requirement.ID) != nil {
+		utils.Error(c, 400, "需求已有进行中的评审")
+		return
+	}
+	if err := utils.ValidateWorkflowTransition(h.db, c, "requirement", requirement.ProjectID, requirement.Status, "reviewing", map[string]string{
+		"comment": req.Comment,
+	}); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	var lastRound int
+	h.db.Model(&model.RequirementReview{}).Where("requirement_id = ?", requirement.ID).Select("COALESCE(MAX(round), 0)").Scan(&lastRound)
+
+	userID := utils.GetUserID(c)
+	review := model.RequirementReview{
+		RequirementID: requirement.ID,
+		Round:         lastRound + 1,
+		Rule:          req.Rule,
+		Status:        utils.ReviewStatusReviewing,
+		Comment:       req.Comment,
+		FromStatus:    requirement.Status,
+		CreatorID:     userID,
+	}
+	oldStatus := requirement.Status
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+		for _, reviewerID := range reviewerIDs {
+			vote := model.RequirementReviewVote{ReviewID: review.ID, ReviewerID: reviewerID, Verdict: utils.ReviewVerdictPending}
+			if err := tx.Create(&vote).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(requirement).Update("status", "reviewing").Error
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "发起评审失败")
+		return
+	}
+
+	actionID, _ := utils.RecordAction(h.db, "requirement", requirement.ID, "review_started", userID, req.Comment, map[string]interface{}{
+		"review_id": review.ID,
+		"round":     review.Round,
+		"rule":      review.Rule,
+	})
+	changes := []utils.HistoryChange{{Field: "reviewer_ids", Old: "", New: formatUintSlice(reviewerIDs)}}
+	if oldStatus != "reviewing" {
+		changes = append(changes, utils.HistoryChange{Field: "status", Old: oldStatus, New: "reviewing"})
+	}
+	utils.RecordHistory(h.db, actionID, changes)
+
+	utils.Notify(h.db, reviewerIDs, utils.NotificationInput{
+		Type:       utils.NotificationRequirementReview,
+		Title:      "请评审需求：" + requirement.Title,
+		Content:    req.Comment,
+		ObjectType: "requirement",
+		ObjectID:   requirement.ID,
+		ProjectID:  requirement.ProjectID,
+		ActorID:    userID,
+	})
+
+	h.reloadRequirementReview(&review)
+	utils.Success(c, review)
+}
+
+// SubmitRequirementReview 评审人提交评审意见（评审结束前可以修改），全部评审人提交后按规则得出结论并自动变更需求状态
+func (h *RequirementHandler) SubmitRequirementReview(c *gin.Context) {
+	requirement, ok := h.loadRequirementForReview(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Verdict string `json:"verdict" binding:"required"` // pass, reject 或 clarify
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+	if !utils.IsValidReviewVerdict(req.Verdict) {
+		utils.Error(c, 400, "评审意见必须是 pass、reject 或 clarify")
+		return
+	}
+	if req.Verdict != utils.ReviewVerdictPass && req.Comment == "" {
+		utils.Error(c, 400, "拒绝或有待明确时请填写评审说明")
+		return
+	}
+
+	review := utils.OpenRequirementReview(h.db, requirement.ID)
+	if review == nil {
+		utils.Error(c, 400, "需求没有进行中的评审")
+		return
+	}
+	userID := utils.GetUserID(c)
+	var vote model.RequirementReviewVote
+	if err := h.db.Where("review_id = ? AND reviewer_id = ?", review.ID, userID).First(&vote).Error; err != nil {
+		utils.Error(c, 403, "您不是该需求的评审人")
+		return
+	}
+
+	oldVerdict := vote.Verdict
+	vote.Verdict = req.Verdict
+	vote.Comment = req.Comment
+	if err := h.db.Save(&vote).Error; err != nil {
+		utils.Error(c, utils.CodeError, "提交评审意见失败")
+		return
+	}
+	actionID, _ := utils.RecordAction(h.db, "requirement", requirement.ID, "reviewed", userID, req.Comment, map[string]interface{}{
+		"review_id": review.ID,
+		"round":     review.Round,
+	})
+	utils.RecordHistory(h.db, actionID, []utils.HistoryChange{{Field: "review_verdict", Old: oldVerdict, New: req.Verdict}})
+
+	// 全部评审人提交后结束本轮评审
+	var verdicts []string
+	h.db.Model(&model.RequirementReviewVote{}).Where("review_id = ?", review.ID).Pluck("verdict", &verdicts)
+	if result, done := utils.ReviewOutcome(review.Rule, verdicts); done {
+		if err := h.finishRequirementReview(c, requirement, review, result); err != nil && !errors.Is(err, utils.ErrRequirementReviewClosed) {
+			utils.Error(c, utils.CodeError, "结束评审失败")
+			return
+		}
+	}
+
+	h.reloadRequirementReview(review)
+	utils.Success(c, review)
+}
+
+// finishRequirementReview 记录评审结论，并把需求状态变更为结论对应的状态
+// 状态变更按工作流校验，不允许时只记录结论、保持需求状态不变；评审已被其他请求结束时返回 ErrRequirementReviewClosed
+func (h *RequirementHandler) finishRequirementReview(c *gin.Context, requirement *model.Requirement, review *model.RequirementReview, result string) error {
+	now := time.Now()
+	actorID := utils.GetUserID(c)
+	oldStatus := requirement.Status
+	newStatus, ok := utils.RequirementReviewResultStatus(h.db, requirement.ProjectID, result)
+	if !ok {
+		newStatus = oldStatus
+	}
+	if err := utils.ValidateWorkflowTransition(h.db, c, "requirement", requirement.ProjectID, oldStatus, newStatus, map[string]string{}); err != nil {
+		if utils.Logger != nil {
+			utils.Logger.Warnf("[RequirementReview] 需求 %d 评审结论 %s 不能变更状态: %v", requirement.ID, result, err)
+		}
+		newStatus = oldStatus
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// 只结束仍在评审中的轮次，避免并发提交的最后两票重复结束评审
+		res := tx.Model(&model.RequirementReview{}).
+			Where("id = ? AND status = ?", review.ID, utils.ReviewStatusReviewing).
+			Updates(map[string]interface{}{
+				"status":      utils.ReviewStatusFinished,
+				"result":      result,
+				"finished_at": now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return utils.ErrRequirementReviewClosed
+		}
+		if newStatus == oldStatus {
+			return nil
+		}
+		return tx.Model(requirement).Update("status", newStatus).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	actionID, _ := utils.RecordAction(h.db, "requirement", requirement.ID, "review_finished", actorID, "第"+strconv.Itoa(review.Round)+"轮评审结束", map[string]interface{}{
+		"review_id": review.ID,
+		"round":     review.Round,
+		"rule":      review.Rule,
+	})
+	changes := []utils.HistoryChange{{Field: "review_result", Old: "", New: result}}
+	if oldStatus != newStatus {
+		changes = append(changes, utils.HistoryChange{Field: "status", Old: oldStatus, New: newStatus})
+	}
+	return utils.RecordHistory(h.db, actionID, changes)
+}
+
+// CancelRequirementReview 取消进行中的评审，需求恢复为发起评审前的状态
+func (h *RequirementHandler) CancelRequirementReview(c *gin.Context) {
+	requirement, ok := h.loadRequirementForReview(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, requirement.ProjectID, "requirement:update") {
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	review := utils.OpenRequirementReview(h.db, requirement.ID)
+	if review == nil {
+		utils.Error(c, 400, "需求没有进行中的评审")
+		return
+	}
+
+	oldStatus := requirement.Status
+	newStatus := review.FromStatus
+	if newStatus == "" || !utils.IsValidWorkflowState(h.db, "requirement", requirement.ProjectID, newStatus) {
+		newStatus = oldStatus
+	}
+	now := time.Now()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(review).Updates(map[string]interface{}{
+			"status":      utils.ReviewStatusCancelled,
+			"finished_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(requirement).Update("status", newStatus).Error
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "取消评审失败")
+		return
+	}
+
+	actionID, _ := utils.RecordAction(h.db, "requirement", requirement.ID, "review_cancelled", utils.GetUserID(c), req.Comment, map[string]interface{}{
+		"review_id": review.ID,
+		"round":     review.Round,
+	})
+	if oldStatus != newStatus {
+		utils.RecordHistory(h.db, actionID, []utils.HistoryChange{{Field: "status", Old: oldStatus, New: newStatus}})
+	}
+
+	h.reloadRequirementReview(review)
+	utils.Success(c, review)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"strconv"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRequirementLevels 获取需求层级配置（从上到下）
+func (h *RequirementHandler) GetRequirementLevels(c *gin.Context) {
+	utils.Success(c, utils.RequirementLevels())
+}
+
+// GetRequirementTree 获取项目的需求层级树（含进度），root_id 不为空时只返回该需求及其子孙需求
+func (h *RequirementHandler) GetRequirementTree(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Query("project_id"), 10, 32)
+	if err != nil {
+		utils.Error(c, 400, "请指定项目")
+		return
+	}
+	var project model.Project
+	if err := h.db.First(&project, projectID).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	var requirements []model.Requirement
+	if err := h.db.Preload("Assignee").Where("project_id = ?", project.ID).Order("id").Find(&requirements).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	if err := utils.FillRequirementProgress(h.db, requirements); err != nil {
+		utils.Error(c, utils.CodeError, "计算进度失败")
+		return
+	}
+	roots := utils.BuildRequirementTree(requirements)
+
+	if rootID := c.Query("root_id"); rootID != "" {
+		stack := append([]*utils.RequirementTreeNode(nil), roots...)
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = append(stack[:len(stack)-1], node.Children...)
+			if strconv.FormatUint(uint64(node.ID), 10) == rootID {
+				utils.Success(c, []*utils.RequirementTreeNode{node})
+				return
+			}
+		}
+		utils.Error(c, 404, "需求不存在")
+		return
+	}
+	utils.Success(c, roots)
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SharedStepHandler struct {
+	db *gorm.DB
+}
+
+func NewSharedStepHandler(db *gorm.DB) *SharedStepHandler {
+	return &SharedStepHandler{db: db}
+}
+
+// sharedStepItemInput 共享步骤库中的一个步骤
+type sharedStepItemInput struct {
+	Action   string `json:"action"`
+	Expected string `json:"expected"`
+}
+
+// buildSharedStepItems 校验并编号共享步骤
+func buildSharedStepItems(inputs []sharedStepItemInput) ([]model.SharedStepItem, bool) {
+	items := make([]model.SharedStepItem, 0, len(inputs))
+	for i, input := range inputs {
+		if strings.TrimSpace(input.Action) == "" {
+			return nil, false
+		}
+		items = append(items, model.SharedStepItem{Sort: i + 1, Action: input.Action, Expected: input.Expected})
+	}
+	return items, len(items) > 0
+}
+
+// preloadSharedStepItems 按顺序预加载共享步骤
+func preloadSharedStepItems(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort ASC, id ASC")
+	})
+}
+
+// requireSharedStepPermission 检查项目共享步骤库的写权限：必须能访问项目且项目角色允许，失败时已写入响应
+// 全局共享的步骤库（projectID 为空）只由全局权限控制
+func (h *SharedStepHandler) requireSharedStepPermission(c *gin.Context, projectID *uint, permCode string) bool {
+	if projectID == nil {
+		return true
+	}
+	if !utils.CheckProjectAccess(h.db, c, *projectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return false
+	}
+	return utils.RequireProjectPermission(h.db, c, *projectID, permCode)
+}
+
+// GetSharedSteps 获取共享步骤库列表，指定 project_id 时返回全局共享的和该项目的
+// 未指定时普通用户只能看到全局共享的和自己参与项目的
+func (h *SharedStepHandler) GetSharedSteps(c *gin.Context) {
+	query := preloadSharedStepItems(h.db).Preload("Creator")
+	if projectID := c.Query("project_id"); projectID != "" {
+		id, err := strconv.ParseUint(projectID, 10, 32)
+		if err != nil {
+			utils.Error(c, 400, "项目ID无效")
+			return
+		}
+		if !utils.CheckProjectAccess(h.db, c, uint(id)) {
+			utils.Error(c, 403, "没有权限访问该项目")
+			return
+		}
+		query = query.Where("project_id IS NULL OR project_id = ?", id)
+	} else if !utils.IsAdmin(c) {
+		projectIDs := utils.GetUserProjectIDs(h.db, utils.GetUserID(c))
+		query = query.Where("project_id IS NULL OR project_id IN ?", append(projectIDs, 0))
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		query = query.Where("name LIKE ?", "%"+keyword+"%")
+	}
+
+	var sharedSteps []model.SharedStep
+	if err := query.Order("id DESC").Find(&sharedSteps).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	utils.Success(c, sharedSteps)
+}
+
+// GetSharedStep 获取共享步骤库详情
+func (h *SharedStepHandler) GetSharedStep(c *gin.Context) {
+	var sharedStep model.SharedStep
+	if err := preloadSharedStepItems(h.db).Preload("Creator").First(&sharedStep, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "共享步骤不存在")
+		return
+	}
+	if sharedStep.ProjectID != nil && !utils.CheckProjectAccess(h.db, c, *sharedStep.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	utils.Success(c, sharedStep)
+}
+
+// CreateSharedStep 创建共享步骤库，project_id 为空时所有项目共享
+func (h *SharedStepHandler) CreateSharedStep(c *gin.Context) {
+	var req struct {
+		Name        string                `json:"name" binding:"required"`
+		Description string                `json:"description"`
+		ProjectID   *uint                 `json:"project_id"`
+		Items       []sharedStepItemInput `json:"items" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+
+	if req.ProjectID != nil && *req.ProjectID == 0 {
+		req.ProjectID = nil
+	}
+	if req.ProjectID != nil {
+		var project model.Project
+		if err := h.db.First(&project, *req.ProjectID).Error; err != nil {
+			utils.Error(c, 404, "项目不存在")
+			return
+		}
+	}
+	if !h.requireSharedStepPermission(c, req.ProjectID, "test-case:create") {
+		return
+	}
+	items, ok := buildSharedStepItems(req.Items)
+	if !ok {
+		utils.Error(c, 400, "共享步骤至少包含一个步骤，且操作步骤不能为空")
+		return
+	}
+
+	sharedStep := model.SharedStep{
+		Name:        req.Name,
+		Description: req.Description,
+		ProjectID:   req.ProjectID,
+		CreatorID:   utils.GetUserID(c),
+		Items:       items,
+	}
+	if err := h.db.Create(&sharedStep).Error; err != nil {
+		utils.Error(c, utils.CodeError, "创建失败")
+		return
+	}
+
+	preloadSharedStepItems(h.db).Preload("Creator").First(&sharedStep, sharedStep.ID)
+	utils.Success(c, sharedStep)
+}
+
+// UpdateSharedStep 更新共享步骤库。已引用该库的测试单保留引用时的步骤，不受影响
+func (h *SharedStepHandler) UpdateSharedStep(c *gin.Context) {
+	var sharedStep model.SharedStep
+	if err := h.db.First(&sharedStep, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "共享步骤不存在")
+		return
+	}
+	if !h.requireSharedStepPermission(c, sharedStep.ProjectID, "test-case:update") {
+		return
+	}
+
+	var req struct {
+		Name        *string                `json:"name"`
+		Description *string                `json:"description"`
+		Items       *[]sharedStepItemInput `json:"items"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			utils.Error(c, 400, "名称不能为空")
+			return
+		}
+		sharedStep.Name = *req.Name
+	}
+	if req.Description != nil {
+		sharedStep.Description = *req.Description
+	}
+	var items []model.SharedStepItem
+	if req.Items != nil {
+		var ok bool
+		if items, ok = buildSharedStepItems(*req.Items); !ok {
+			utils.Error(c, 400, "共享步骤至少包含一个步骤，且操作步骤不能为空")
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&sharedStep).Error; err != nil {
+			return err
+		}
+		if req.Items == nil {
+			return nil
+		}
+		if err := tx.Where("shared_step_id = ?", sharedStep.ID).Delete(&model.SharedStepItem{}).Error; err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].SharedStepID = sharedStep.ID
+		}
+		return tx.Create(&items).Error
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "更新失败")
+		return
+	}
+
+	preloadSharedStepItems(h.db).Preload("Creator").First(&sharedStep, sharedStep.ID)
+	utils.Success(c, sharedStep)
+}
+
+// DeleteSharedStep 删除共享步骤库
+func (h *SharedStepHandler) DeleteSharedStep(c *gin.Context) {
+	var sharedStep model.SharedStep
+	if err := h.db.First(&sharedStep, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "共享步骤不存在")
+		return
+	}
+	if !h.requireSharedStepPermission(c, sharedStep.ProjectID, "test-case:delete") {
+		return
+	}
+
+	if err := h.db.Delete(&sharedStep).Error; err != nil {
+		utils.Error(c, utils.CodeError, "删除失败")
+		return
+	}
+
+	utils.Success(c, nil)
+}
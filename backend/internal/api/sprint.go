@@ -0,0 +1,434 @@
+package api
+
+import (
+	"errors"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SprintHandler struct {
+	db *gorm.DB
+}
+
+func NewSprintHandler(db *gorm.DB) *SprintHandler {
+	return &SprintHandler{db: db}
+}
+
+// sprintItemsRequest 迭代工作项请求（按类型分组的ID列表）
+type sprintItemsRequest struct {
+	TaskIDs        []uint `json:"task_ids"`
+	BugIDs         []uint `json:"bug_ids"`
+	RequirementIDs []uint `json:"requirement_ids"`
+}
+
+func (r *sprintItemsRequest) byType() map[string][]uint {
+	return map[string][]uint{
+		utils.SprintItemTask:        r.TaskIDs,
+		utils.SprintItemBug:         r.BugIDs,
+		utils.SprintItemRequirement: r.RequirementIDs,
+	}
+}
+
+// loadSprint 加载迭代并检查项目访问权限，失败时已写入响应
+func (h *SprintHandler) loadSprint(c *gin.Context) (*model.Sprint, bool) {
+	var sprint model.Sprint
+	if err := h.db.First(&sprint, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "迭代不存在")
+		return nil, false
+	}
+	if !utils.CheckProjectAccess(h.db, c, sprint.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return nil, false
+	}
+	return &sprint, true
+}
+
+// GetProjectSprints 获取项目的迭代列表（含工作项统计）
+func (h *SprintHandler) GetProjectSprints(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	query := h.db.Where("project_id = ?", project.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var sprints []model.Sprint
+	if err := query.Order("start_date DESC, id DESC").Find(&sprints).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	list := make([]gin.H, 0, len(sprints))
+	for i := range sprints {
+		summary, err := utils.GetSprintSummary(h.db, &sprints[i])
+		if err != nil {
+			utils.Error(c, utils.CodeError, "统计迭代失败")
+			return
+		}
+		list = append(list, gin.H{"sprint": sprints[i], "summary": summary})
+	}
+	utils.Success(c, list)
+}
+
+// CreateSprint 创建迭代
+func (h *SprintHandler) CreateSprint(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+
+	var req struct {
+		Name      string  `json:"name" binding:"required"`
+		Goal      string  `json:"goal"`
+		StartDate string  `json:"start_date" binding:"required"`
+		EndDate   string  `json:"end_date" binding:"required"`
+		Capacity  float64 `json:"capacity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:update") {
+		return
+	}
+
+	startDate, err := utils.ParseSprintDate(req.StartDate)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+	endDate, err := utils.ParseSprintDate(req.EndDate)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+	if endDate.Before(startDate) {
+		utils.Error(c, 400, "结束日期不能早于开始日期")
+		return
+	}
+	if req.Capacity < 0 {
+		utils.Error(c, 400, "容量不能为负数")
+		return
+	}
+
+	sprint := model.Sprint{
+		Name:      req.Name,
+		Goal:      req.Goal,
+		Status:    utils.SprintStatusPlanned,
+		ProjectID: project.ID,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Capacity:  req.Capacity,
+		CreatorID: utils.GetUserID(c),
+	}
+	if err := h.db.Create(&sprint).Error; err != nil {
+		utils.Error(c, utils.CodeError, "创建失败")
+		return
+	}
+	utils.RecordAction(h.db, "sprint", sprint.ID, "created", sprint.CreatorID, "", nil)
+
+	utils.Success(c, sprint)
+}
+
+// GetSprint 获取迭代详情（含工作项统计）
+func (h *SprintHandler) GetSprint(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	h.db.Preload("Project").Preload("Creator").First(sprint, sprint.ID)
+
+	summary, err := utils.GetSprintSummary(h.db, sprint)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "统计迭代失败")
+		return
+	}
+	utils.Success(c, gin.H{"sprint": sprint, "summary": summary})
+}
+
+// UpdateSprint 更新迭代（已关闭的迭代不能修改）
+func (h *SprintHandler) UpdateSprint(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, sprint.ProjectID, "project:update") {
+		return
+	}
+	if sprint.Status == utils.SprintStatusClosed {
+		utils.Error(c, 400, utils.ErrSprintClosed.Error())
+		return
+	}
+
+	var req struct {
+		Name      *string  `json:"name"`
+		Goal      *string  `json:"goal"`
+		StartDate *string  `json:"start_date"`
+		EndDate   *string  `json:"end_date"`
+		Capacity  *float64 `json:"capacity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			utils.Error(c, 400, "迭代名称不能为空")
+			return
+		}
+		sprint.Name = *req.Name
+	}
+	if req.Goal != nil {
+		sprint.Goal = *req.Goal
+	}
+	if req.StartDate != nil {
+		startDate, err := utils.ParseSprintDate(*req.StartDate)
+		if err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		sprint.StartDate = startDate
+	}
+	if req.EndDate != nil {
+		endDate, err := utils.ParseSprintDate(*req.EndDate)
+		if err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		sprint.EndDate = endDate
+	}
+	if sprint.EndDate.Before(sprint.StartDate) {
+		utils.Error(c, 400, "结束日期不能早于开始日期")
+		return
+	}
+	if req.Capacity != nil {
+		if *req.Capacity < 0 {
+			utils.Error(c, 400, "容量不能为负数")
+			return
+		}
+		sprint.Capacity = *req.Capacity
+	}
+
+	if err := h.db.Save(sprint).Error; err != nil {
+		utils.Error(c, utils.CodeError, "更新失败")
+		return
+	}
+	utils.Success(c, sprint)
+}
+
+// DeleteSprint 删除迭代，只能删除未开始的迭代，其中的工作项转回待办池
+func (h *SprintHandler) DeleteSprint(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, sprint.ProjectID, "project:delete") {
+		return
+	}
+	if sprint.Status != utils.SprintStatusPlanned {
+		utils.Error(c, 400, "只能删除未开始的迭代，进行中的迭代请先关闭")
+		return
+	}
+
+	items := make(map[string][]uint)
+	for _, itemType := range utils.SprintItemTypes {
+		ids, err := utils.GetSprintItemIDs(h.db, sprint.ID, itemType)
+		if err != nil {
+			utils.Error(c, utils.CodeError, "查询失败")
+			return
+		}
+		items[itemType] = ids
+	}
+	if _, err := utils.SetSprintItems(h.db, sprint.ProjectID, nil, items, utils.GetUserID(c)); err != nil {
+		utils.Error(c, utils.CodeError, "移出迭代工作项失败")
+		return
+	}
+	if err := h.db.Delete(sprint).Error; err != nil {
+		utils.Error(c, utils.CodeError, "删除失败")
+		return
+	}
+	utils.Success(c, gin.H{"message": "删除成功"})
+}
+
+// StartSprint 开始迭代
+func (h *SprintHandler) StartSprint(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, sprint.ProjectID, "project:update") {
+		return
+	}
+
+	if err := utils.StartSprint(h.db, sprint); err != nil {
+		if errors.Is(err, utils.ErrSprintAlreadyActive) {
+			utils.Error(c, 409, err.Error())
+		} else {
+			utils.Error(c, 400, err.Error())
+		}
+		return
+	}
+	utils.RecordAction(h.db, "sprint", sprint.ID, "started", utils.GetUserID(c), "", nil)
+	utils.Success(c, sprint)
+}
+
+// CloseSprint 关闭迭代，未完成的工作项转入下一个迭代（可指定，默认为最早的未开始迭代）
+func (h *SprintHandler) CloseSprint(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, sprint.ProjectID, "project:update") {
+		return
+	}
+
+	var req struct {
+		NextSprintID *uint `json:"next_sprint_id"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.Error(c, 400, "参数错误")
+			return
+		}
+	}
+
+	userID := utils.GetUserID(c)
+	result, err := utils.CloseSprint(h.db, sprint, req.NextSprintID, userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrSprintNotActive) || errors.Is(err, utils.ErrSprintNextInvalid) {
+			utils.Error(c, 400, err.Error())
+		} else {
+			utils.Error(c, utils.CodeError, "关闭迭代失败")
+		}
+		return
+	}
+	utils.RecordAction(h.db, "sprint", sprint.ID, "closed", userID, "", result)
+	utils.Success(c, gin.H{"sprint": sprint, "result": result})
+}
+
+// GetSprintBurndown 获取迭代燃尽图/燃起图
+func (h *SprintHandler) GetSprintBurndown(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	respondBurnChart(c, h.db, utils.BurnChartScope{
+		Type:      utils.BurnScopeSprint,
+		ID:        sprint.ID,
+		StartDate: sprint.StartDate,
+		EndDate:   sprint.EndDate,
+	})
+}
+
+// GetSprintItems 获取迭代中的工作项
+func (h *SprintHandler) GetSprintItems(c *gin.Context) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+
+	var requirements []model.Requirement
+	var tasks []model.Task
+	var bugs []model.Bug
+	if err := h.db.Where("sprint_id = ?", sprint.ID).Preload("Assignee").Order("id").Find(&requirements).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	if err := h.db.Where("sprint_id = ?", sprint.ID).Preload("Assignee").Order("id").Find(&tasks).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	if err := h.db.Where("sprint_id = ?", sprint.ID).Preload("Assignees").Order("id").Find(&bugs).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	utils.Success(c, gin.H{
+		"requirements": requirements,
+		"tasks":        tasks,
+		"bugs":         bugs,
+	})
+}
+
+// AddSprintItems 将工作项加入迭代（工作项原来所在的迭代会被替换）
+func (h *SprintHandler) AddSprintItems(c *gin.Context) {
+	h.changeSprintItems(c, true)
+}
+
+// RemoveSprintItems 将工作项移出迭代，转回待办池
+func (h *SprintHandler) RemoveSprintItems(c *gin.Context) {
+	h.changeSprintItems(c, false)
+}
+
+func (h *SprintHandler) changeSprintItems(c *gin.Context, add bool) {
+	sprint, ok := h.loadSprint(c)
+	if !ok {
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, sprint.ProjectID, "project:update") {
+		return
+	}
+	if sprint.Status == utils.SprintStatusClosed {
+		utils.Error(c, 400, utils.ErrSprintClosed.Error())
+		return
+	}
+
+	var req sprintItemsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	items := req.byType()
+	var target *uint
+	if add {
+		target = &sprint.ID
+	} else {
+		// 只移出当前迭代中的工作项
+		for itemType, ids := range items {
+			current, err := utils.GetSprintItemIDs(h.db, sprint.ID, itemType)
+			if err != nil {
+				utils.Error(c, utils.CodeError, "查询失败")
+				return
+			}
+			items[itemType] = intersectUints(ids, current)
+		}
+	}
+
+	changed, err := utils.SetSprintItems(h.db, sprint.ProjectID, target, items, utils.GetUserID(c))
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+	utils.Success(c, gin.H{"changed": changed})
+}
+
+func intersectUints(values, allowed []uint) []uint {
+	allowedSet := make(map[uint]bool, len(allowed))
+	for _, value := range allowed {
+		allowedSet[value] = true
+	}
+	var result []uint
+	for _, value := range values {
+		if allowedSet[value] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
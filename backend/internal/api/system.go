@@ -2,6 +2,7 @@ package api
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,8 +10,10 @@ import (
 	"strings"
 	"time"
 
+	"prjflow/internal/config"
 	"prjflow/internal/model"
 	"prjflow/internal/utils"
+	"prjflow/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -141,6 +144,159 @@ func (h *SystemHandler) TriggerBackup(c *gin.Context) {
 	})
 }
 
+// SendTestEmail 发送测试邮件（同步发送，用于检查SMTP配置）
+func (h *SystemHandler) SendTestEmail(c *gin.Context) {
+	if !utils.EmailEnabled() {
+		utils.Error(c, 400, "邮件通知未启用，请先在配置文件中配置SMTP服务器")
+		return
+	}
+
+	var req struct {
+		To string `json:"to"`
+	}
+	c.ShouldBindJSON(&req)
+	if req.To == "" {
+		var user model.User
+		if err := h.db.First(&user, utils.GetUserID(c)).Error; err == nil {
+			req.To = user.Email
+		}
+	}
+	if req.To == "" {
+		utils.Error(c, 400, "请填写收件地址")
+		return
+	}
+
+	subject, htmlBody, textBody, err := utils.RenderNotificationEmail("system.test", utils.EmailTemplateData{
+		RecipientName: req.To,
+		Title:         "测试邮件",
+		Content:       "如果你收到这封邮件，说明邮件通知配置正确。",
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "渲染邮件失败: "+err.Error())
+		return
+	}
+	if err := utils.SendEmail(config.AppConfig.Email, req.To, subject, htmlBody, textBody); err != nil {
+		utils.Error(c, utils.CodeError, "发送失败: "+err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"message": "测试邮件已发送",
+	})
+}
+
+// GetTwoFactorPolicy 获取两步验证强制策略
+func (h *SystemHandler) GetTwoFactorPolicy(c *gin.Context) {
+	utils.Success(c, utils.GetTwoFactorPolicy(h.db))
+}
+
+// SaveTwoFactorPolicy 保存两步验证强制策略（拥有指定角色或权限的用户登录时必须通过两步验证）
+func (h *SystemHandler) SaveTwoFactorPolicy(c *gin.Context) {
+	var req utils.TwoFactorPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	if req.RequiredRoles == nil {
+		req.RequiredRoles = []string{}
+	}
+	if req.RequiredPermissions == nil {
+		req.RequiredPermissions = []string{}
+	}
+
+	// 校验角色和权限代码是否存在
+	for _, code := range req.RequiredRoles {
+		var count int64
+		h.db.Model(&model.Role{}).Where("code = ?", code).Count(&count)
+		if count == 0 {
+			utils.Error(c, 400, fmt.Sprintf("角色不存在: %s", code))
+			return
+		}
+	}
+	for _, code := range req.RequiredPermissions {
+		var count int64
+		h.db.Model(&model.Permission{}).Where("code = ?", code).Count(&count)
+		if count == 0 {
+			utils.Error(c, 400, fmt.Sprintf("权限不存在: %s", code))
+			return
+		}
+	}
+
+	if err := utils.SaveTwoFactorPolicy(h.db, req); err != nil {
+		utils.Error(c, utils.CodeError, "保存两步验证策略失败: "+err.Error())
+		return
+	}
+
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "update", "system_config", 0, c, true, "", "两步验证策略")
+	utils.Success(c, req)
+}
+
+// GetProjectRolePermissions 获取项目角色（负责人、成员、查看者）的权限映射
+func (h *SystemHandler) GetProjectRolePermissions(c *gin.Context) {
+	utils.Success(c, gin.H{
+		"roles":    utils.ProjectRoles,
+		"current":  utils.GetProjectRolePermissions(h.db),
+		"defaults": utils.DefaultProjectRolePermissions,
+	})
+}
+
+// SaveProjectRolePermissions 保存项目角色权限映射，权限支持通配符，如 bug:* 或 *:read
+func (h *SystemHandler) SaveProjectRolePermissions(c *gin.Context) {
+	var req map[string][]string
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	if err := utils.ValidateProjectRolePermissions(h.db, req); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	if err := utils.SaveProjectRolePermissions(h.db, req); err != nil {
+		utils.Error(c, utils.CodeError, "保存项目角色权限失败: "+err.Error())
+		return
+	}
+	permission.InvalidateCache(h.db)
+
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "update", "system_config", 0, c, true, "", "项目角色权限")
+	utils.Success(c, utils.GetProjectRolePermissions(h.db))
+}
+
+// GetPermissionCacheStats 获取权限缓存的命中统计
+func (h *SystemHandler) GetPermissionCacheStats(c *gin.Context) {
+	utils.Success(c, permission.GetCacheStats(h.db))
+}
+
+// ClearPermissionCache 手动清空权限缓存（直接修改数据库后使用）
+func (h *SystemHandler) ClearPermissionCache(c *gin.Context) {
+	permission.InvalidateCache(h.db)
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "clear", "permission_cache", 0, c, true, "", "")
+	utils.Success(c, permission.GetCacheStats(h.db))
+}
+
+// SyncLDAPUsers 立即同步LDAP目录，禁用已从目录删除的用户
+// 同步因目录为空或禁用人数过多被中止时，确认无误后可使用 force=true 强制同步
+func (h *SystemHandler) SyncLDAPUsers(c *gin.Context) {
+	if !utils.LDAPEnabled() {
+		utils.Error(c, 400, utils.ErrLDAPDisabled.Error())
+		return
+	}
+	force := c.Query("force") == "true"
+	result, err := utils.SyncLDAPUsers(h.db, utils.GetLDAPConfig(), force)
+	if err != nil {
+		utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "sync", "ldap", 0, c, false, err.Error(), "")
+		if errors.Is(err, utils.ErrLDAPSyncAborted) {
+			utils.Error(c, 409, err.Error())
+			return
+		}
+		utils.Error(c, utils.CodeError, "同步LDAP目录失败: "+err.Error())
+		return
+	}
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "sync", "ldap", 0, c, true, "",
+		fmt.Sprintf("目录用户 %d, 更新 %d, 禁用 %d", result.Total, result.Updated, result.Disabled))
+	utils.Success(c, result)
+}
+
 // GetLogLevel 获取当前日志级别
 func (h *SystemHandler) GetLogLevel(c *gin.Context) {
 	level := utils.GetLogLevel()
@@ -36,6 +36,16 @@ func (h *TaskHandler) GetTasks(c *gin.Context) {
 		query = query.Where("project_id = ?", projectID)
 	}
 
+	// 迭代筛选（sprint_id=0 表示未规划到迭代的待办池）
+	query = utils.FilterBySprint(query, c.Query("sprint_id"))
+
+	// 父任务筛选（parent_id=0 表示顶层任务）
+	if parentID := c.Query("parent_id"); parentID == "0" {
+		query = query.Where("parent_id IS NULL")
+	} else if parentID != "" {
+		query = query.Where("parent_id = ?", parentID)
+	}
+
 	// 状态筛选
 	if status := c.Query("status"); status != "" {
 		query = query.Where("status = ?", status)
@@ -75,6 +85,16 @@ func (h *TaskHandler) GetTasks(c *gin.Context) {
 		countQuery = countQuery.Where("project_id = ?", projectID)
 	}
 
+	// 迭代筛选（sprint_id=0 表示未规划到迭代的待办池）
+	countQuery = utils.FilterBySprint(countQuery, c.Query("sprint_id"))
+
+	// 父任务筛选（parent_id=0 表示顶层任务）
+	if parentID := c.Query("parent_id"); parentID == "0" {
+		countQuery = countQuery.Where("parent_id IS NULL")
+	} else if parentID != "" {
+		countQuery = countQuery.Where("parent_id = ?", parentID)
+	}
+
 	// 状态筛选
 	if status := c.Query("status"); status != "" {
 		countQuery = countQuery.Where("status = ?", status)
@@ -139,6 +159,7 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		Priority       string    `json:"priority"`
 		ProjectID      uint      `json:"project_id" binding:"required"`
 		RequirementID  *uint     `json:"requirement_id"`
+		ParentID       *uint     `json:"parent_id"`
 		AssigneeID     *uint     `json:"assignee_id"`
 		StartDate      *string   `json:"start_date"`
 		EndDate        *string   `json:"end_date"`
@@ -160,22 +181,13 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		return
 	}
 
-	// 验证状态
-	if req.Status == "" {
-		req.Status = "wait"
-	}
-	validStatuses := map[string]bool{
-		"wait":    true,
-		"doing":   true,
-		"done":    true,
-		"pause":   true,
-		"cancel":  true,
-		"closed":  true,
-	}
-	if !validStatuses[req.Status] {
-		utils.Error(c, 400, "状态值无效，有效值：wait, doing, done, pause, cancel, closed")
+	// 验证状态：新建的任务必须处于项目工作流的初始状态（未指定时使用初始状态）
+	initialStatus, err := utils.ValidateWorkflowInitialState(h.db, "task", req.ProjectID, req.Status)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
 		return
 	}
+	req.Status = initialStatus
 
 	// 验证优先级
 	if req.Priority == "" {
@@ -210,6 +222,9 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		utils.Error(c, 403, "没有权限在该项目中创建任务")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "task:create") {
+		return
+	}
 
 	// 如果指定了需求，验证需求是否存在且属于同一项目
 	if req.RequirementID != nil {
@@ -224,6 +239,17 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		}
 	}
 
+	// 如果指定了父任务，验证父任务属于同一项目且未完成
+	if req.ParentID != nil && *req.ParentID == 0 {
+		req.ParentID = nil
+	}
+	if req.ParentID != nil {
+		if err := utils.ValidateTaskParent(h.db, &model.Task{ProjectID: req.ProjectID}, *req.ParentID); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+	}
+
 	// 如果指定了负责人，验证用户是否存在
 	if req.AssigneeID != nil {
 		var user model.User
@@ -251,6 +277,12 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		}
 	}
 
+	// 验证依赖关系：前置任务必须存在且属于同一项目
+	if err := utils.ValidateTaskDependencies(h.db, &model.Task{ProjectID: req.ProjectID}, req.DependencyIDs); err != nil {
+		respondTaskDependencyError(c, err)
+		return
+	}
+
 	task := model.Task{
 		Title:          req.Title,
 		Description:    req.Description,
@@ -258,6 +290,7 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		Priority:       req.Priority,
 		ProjectID:      req.ProjectID,
 		RequirementID:  req.RequirementID,
+		ParentID:       req.ParentID,
 		CreatorID:      userID.(uint),
 		AssigneeID:     req.AssigneeID,
 		StartDate:      startDate,
@@ -279,19 +312,15 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 			utils.Error(c, 400, "依赖任务不存在")
 			return
 		}
-		// 检查循环依赖
-		for _, depID := range req.DependencyIDs {
-			if depID == task.ID {
-				utils.Error(c, 400, "任务不能依赖自己")
-				return
-			}
-		}
 		if err := h.db.Model(&task).Association("Dependencies").Replace(dependencies); err != nil {
 			utils.Error(c, utils.CodeError, "设置依赖失败")
 			return
 		}
 	}
 
+	// 汇总父任务的工时和进度
+	h.rollupParentTasks(task.ParentID)
+
 	// 重新加载关联数据
 	h.db.Preload("Project").Preload("Requirement").Preload("Creator").Preload("Assignee").Preload("Dependencies").First(&task, task.ID)
 
@@ -320,6 +349,9 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该任务")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
 
 	// 保存旧对象用于比较
 	oldTask := task
@@ -331,6 +363,7 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		Priority       *string  `json:"priority"`
 		ProjectID      *uint    `json:"project_id"`
 		RequirementID  *uint    `json:"requirement_id"`
+		ParentID       *uint    `json:"parent_id"` // 父任务ID，0 表示移为顶层任务
 		AssigneeID     *uint    `json:"assignee_id"`
 		StartDate      *string  `json:"start_date"`
 		EndDate        *string  `json:"end_date"`
@@ -356,17 +389,13 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		task.Description = *req.Description
 	}
 	if req.Status != nil {
-		// 验证状态
-		validStatuses := map[string]bool{
-			"wait":    true,
-			"doing":   true,
-			"done":    true,
-			"pause":   true,
-			"cancel":  true,
-			"closed":  true,
+		// 根据工作流验证状态转换，本次请求提供的字段用于校验流转的必填字段
+		if err := utils.ValidateWorkflowTransition(h.db, c, "task", task.ProjectID, task.Status, *req.Status, utils.WorkflowFieldsFromRequest(req)); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
 		}
-		if !validStatuses[*req.Status] {
-			utils.Error(c, 400, "状态值无效，有效值：wait, doing, done, pause, cancel, closed")
+		if err := utils.CheckTaskCanFinish(h.db, &task, *req.Status); err != nil {
+			utils.Error(c, 400, err.Error())
 			return
 		}
 		task.Status = *req.Status
@@ -394,6 +423,30 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		}
 		task.ProjectID = *req.ProjectID
 	}
+	if req.ParentID != nil {
+		if *req.ParentID == 0 {
+			task.ParentID = nil
+		} else {
+			task.ParentID = req.ParentID
+		}
+	}
+	// 父任务变化或移动到其他项目时重新验证父任务
+	parentChanged := (task.ParentID == nil) != (oldTask.ParentID == nil) ||
+		(task.ParentID != nil && *task.ParentID != *oldTask.ParentID)
+	if task.ParentID != nil && (parentChanged || task.ProjectID != oldTask.ProjectID) {
+		if err := utils.ValidateTaskParent(h.db, &task, *task.ParentID); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+	}
+	if task.ProjectID != oldTask.ProjectID {
+		var children int64
+		h.db.Model(&model.Task{}).Where("parent_id = ?", task.ID).Count(&children)
+		if children > 0 {
+			utils.Error(c, 400, "有子任务的任务不能移动到其他项目")
+			return
+		}
+	}
 	if req.RequirementID != nil {
 		// 验证需求是否存在且属于同一项目
 		if *req.RequirementID != 0 {
@@ -465,6 +518,13 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		}
 		task.EstimatedHours = req.EstimatedHours
 	}
+	// 验证依赖关系：前置任务属于同一项目且不形成循环
+	if req.DependencyIDs != nil {
+		if err := utils.ValidateTaskDependencies(h.db, &task, *req.DependencyIDs); err != nil {
+			respondTaskDependencyError(c, err)
+			return
+		}
+	}
 	// 如果更新了实际工时，自动创建或更新资源分配
 	if req.ActualHours != nil {
 		if *req.ActualHours < 0 {
@@ -508,19 +568,15 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 	h.calculateAndUpdateActualHours(&task)
 	
 	// 根据实际工时和预估工时自动计算进度
-	h.calculateProgressFromHours(&task)
+	h.calculateProgressFromHours(c, &task)
+
+	// 汇总本任务（有子任务时）以及新旧父任务的工时和进度
+	h.rollupParentTasks(&task.ID, oldTask.ParentID)
 
 	// 更新任务依赖关系
 	if req.DependencyIDs != nil {
 		var dependencies []model.Task
 		if len(*req.DependencyIDs) > 0 {
-			// 检查循环依赖
-			for _, depID := range *req.DependencyIDs {
-				if depID == task.ID {
-					utils.Error(c, 400, "任务不能依赖自己")
-					return
-				}
-			}
 			if err := h.db.Where("id IN ?", *req.DependencyIDs).Find(&dependencies).Error; err != nil {
 				utils.Error(c, 400, "依赖任务不存在")
 				return
@@ -612,6 +668,9 @@ func (h *TaskHandler) DeleteTask(c *gin.Context) {
 		utils.Error(c, 403, "没有权限删除该任务")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:delete") {
+		return
+	}
 
 	// 检查是否有其他任务依赖此任务
 	var count int64
@@ -621,10 +680,18 @@ func (h *TaskHandler) DeleteTask(c *gin.Context) {
 		return
 	}
 
+	// 检查是否有子任务
+	h.db.Model(&model.Task{}).Where("parent_id = ?", id).Count(&count)
+	if count > 0 {
+		utils.Error(c, 400, "该任务有子任务，无法删除")
+		return
+	}
+
 	if err := h.db.Delete(&model.Task{}, id).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
 	}
+	h.rollupParentTasks(task.ParentID)
 
 	utils.Success(c, gin.H{"message": "删除成功"})
 }
@@ -643,9 +710,13 @@ func (h *TaskHandler) UpdateTaskStatus(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该任务")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
 
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Status  string `json:"status" binding:"required"`
+		Comment string `json:"comment"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -653,17 +724,16 @@ func (h *TaskHandler) UpdateTaskStatus(c *gin.Context) {
 		return
 	}
 
-	// 验证状态
-	validStatuses := map[string]bool{
-		"wait":    true,
-		"doing":   true,
-		"done":    true,
-		"pause":   true,
-		"cancel":  true,
-		"closed":  true,
+	// 根据工作流验证状态转换
+	oldStatus := task.Status
+	if err := utils.ValidateWorkflowTransition(h.db, c, "task", task.ProjectID, oldStatus, req.Status, map[string]string{
+		"comment": req.Comment,
+	}); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
 	}
-	if !validStatuses[req.Status] {
-		utils.Error(c, 400, "状态值无效，有效值：wait, doing, done, pause, cancel, closed")
+	if err := utils.CheckTaskCanFinish(h.db, &task, req.Status); err != nil {
+		utils.Error(c, 400, err.Error())
 		return
 	}
 
@@ -679,10 +749,25 @@ func (h *TaskHandler) UpdateTaskStatus(c *gin.Context) {
 		utils.Error(c, utils.CodeError, "更新失败")
 		return
 	}
+	h.rollupParentTasks(task.ParentID)
 
 	// 重新加载关联数据
 	h.db.Preload("Project").Preload("Requirement").Preload("Creator").Preload("Assignee").Preload("Dependencies").First(&task, task.ID)
 
+	// 记录状态变更
+	if oldStatus != task.Status {
+		userID, exists := c.Get("user_id")
+		if exists {
+			dbValue, _ := c.Get("db")
+			if db, ok := dbValue.(*gorm.DB); ok {
+				actionID, _ := utils.RecordAction(db, "task", task.ID, "status_changed", userID.(uint), req.Comment, nil)
+				utils.RecordHistory(db, actionID, []utils.HistoryChange{
+					{Field: "status", Old: oldStatus, New: task.Status},
+				})
+			}
+		}
+	}
+
 	utils.Success(c, task)
 }
 
@@ -700,6 +785,9 @@ func (h *TaskHandler) UpdateTaskProgress(c *gin.Context) {
 		utils.Error(c, 403, "没有权限更新该任务")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
 
 	var req struct {
 		Progress       *int     `json:"progress"`
@@ -721,12 +809,16 @@ func (h *TaskHandler) UpdateTaskProgress(c *gin.Context) {
 			return
 		}
 		task.Progress = *req.Progress
-		// 如果进度为100，自动设置状态为done
-		if *req.Progress == 100 {
+		// 如果进度为100，工作流允许时自动设置状态为done（已完成或已取消的任务不变）
+		if *req.Progress == 100 && utils.CanAutoTransition(h.db, c, "task", task.ProjectID, task.Status, "done") {
+			if err := utils.CheckTaskCanFinish(h.db, &task, "done"); err != nil {
+				utils.Error(c, 400, err.Error())
+				return
+			}
 			task.Status = "done"
 		}
-		// 如果进度大于0且状态为wait，自动设置为doing
-		if *req.Progress > 0 && task.Status == "wait" {
+		// 如果进度大于0且任务未开始，工作流允许时自动设置为doing
+		if *req.Progress > 0 && utils.CanAutoTransition(h.db, c, "task", task.ProjectID, task.Status, "doing") {
 			task.Status = "doing"
 		}
 	}
@@ -788,14 +880,17 @@ func (h *TaskHandler) UpdateTaskProgress(c *gin.Context) {
 		// 如果更新了实际工时或预估工时，自动根据工时计算进度
 		// 进度 = 实际工时 / 预估工时 * 100，范围0-100%
 		if req.ActualHours != nil || req.EstimatedHours != nil {
-			h.calculateProgressFromHours(&task)
+			h.calculateProgressFromHours(c, &task)
 		} else {
 			// 如果没有更新工时，根据当前工时计算进度
-			h.calculateProgressFromHours(&task)
+			h.calculateProgressFromHours(c, &task)
 		}
 	}
 	// 如果 req.Progress != nil，说明用户手动设置了进度，已经在上面的代码中设置了，不需要再计算
 
+	// 汇总父任务的工时和进度
+	h.rollupParentTasks(task.ParentID)
+
 	// 重新加载关联数据
 	h.db.Preload("Project").Preload("Requirement").Preload("Creator").Preload("Assignee").Preload("Dependencies").First(&task, task.ID)
 
@@ -885,6 +980,17 @@ func (h *TaskHandler) calculateAndUpdateActualHours(task *model.Task) {
 		return // 查询失败时静默返回，避免影响主流程
 	}
 
+	// 父任务的实际工时还包括子任务的实际工时
+	var childHours float64
+	if err := tx.Model(&model.Task{}).
+		Where("parent_id = ?", task.ID).
+		Select("COALESCE(SUM(actual_hours), 0)").
+		Scan(&childHours).Error; err != nil {
+		tx.Rollback()
+		return // 查询失败时静默返回，避免影响主流程
+	}
+	totalHours += childHours
+
 	task.ActualHours = &totalHours
 	if err := tx.Model(task).Update("actual_hours", totalHours).Error; err != nil {
 		tx.Rollback()
@@ -898,11 +1004,10 @@ func (h *TaskHandler) calculateAndUpdateActualHours(task *model.Task) {
 	}
 }
 
-// calculateProgressFromHours 根据实际工时和预估工时自动计算进度
-func (h *TaskHandler) calculateProgressFromHours(task *model.Task) {
-	// 如果预估工时未设置或为0，无法自动计算进度
+// progressFromHours 根据实际工时和预估工时计算进度（0-100），预估工时未设置或为0时返回 false
+func progressFromHours(task *model.Task) (int, bool) {
 	if task.EstimatedHours == nil || *task.EstimatedHours <= 0 {
-		return
+		return 0, false
 	}
 
 	// 如果实际工时未设置，使用0
@@ -913,26 +1018,29 @@ func (h *TaskHandler) calculateProgressFromHours(task *model.Task) {
 
 	// 计算进度：实际工时 / 预估工时 * 100
 	progress := int((actualHours / *task.EstimatedHours) * 100)
-	
-	// 进度不能超过100%
 	if progress > 100 {
 		progress = 100
 	}
-	
-	// 如果进度小于0，设为0
 	if progress < 0 {
 		progress = 0
 	}
+	return progress, true
+}
 
-	// 更新进度
+// calculateProgressFromHours 根据实际工时和预估工时自动计算进度，工作流允许时自动开始或完成任务
+func (h *TaskHandler) calculateProgressFromHours(c *gin.Context, task *model.Task) {
+	progress, ok := progressFromHours(task)
+	if !ok {
+		return
+	}
 	task.Progress = progress
-	
-	// 如果进度为100，自动设置状态为done
-	if progress == 100 && task.Status != "done" {
+
+	// 如果进度为100，自动设置状态为done（父任务还有未完成的子任务时除外）
+	if progress == 100 && utils.CanAutoTransition(h.db, c, "task", task.ProjectID, task.Status, "done") && utils.CheckTaskCanFinish(h.db, task, "done") == nil {
 		task.Status = "done"
 	}
-	// 如果进度大于0且状态为wait，自动设置为doing
-	if progress > 0 && task.Status == "wait" {
+	// 如果进度大于0且任务未开始，自动设置为doing
+	if progress > 0 && utils.CanAutoTransition(h.db, c, "task", task.ProjectID, task.Status, "doing") {
 		task.Status = "doing"
 	}
 
@@ -996,6 +1104,9 @@ func (h *TaskHandler) AddTaskHistoryNote(c *gin.Context) {
 		utils.Error(c, 403, "没有权限为该任务添加备注")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
 
 	var req struct {
 		Comment string `json:"comment" binding:"required"`
@@ -1040,6 +1151,9 @@ func (h *TaskHandler) AssignTask(c *gin.Context) {
 		utils.Error(c, 403, "没有权限分配该任务")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
 
 	// 获取旧的指派人ID
 	oldAssigneeID := task.AssigneeID
@@ -1068,23 +1182,23 @@ func (h *TaskHandler) AssignTask(c *gin.Context) {
 	// 状态处理逻辑
 	oldStatus := task.Status
 	if req.Status != nil {
-		// 如果提供了状态，使用提供的状态
-		validStatuses := map[string]bool{
-			"wait":   true,
-			"doing":  true,
-			"done":   true,
-			"pause":  true,
-			"cancel": true,
-			"closed": true,
+		// 如果提供了状态，根据工作流验证状态转换
+		workflowFields := map[string]string{}
+		if req.Comment != nil {
+			workflowFields["comment"] = *req.Comment
 		}
-		if !validStatuses[*req.Status] {
-			utils.Error(c, 400, "无效的状态值，有效值：wait, doing, done, pause, cancel, closed")
+		if err := utils.ValidateWorkflowTransition(h.db, c, "task", task.ProjectID, task.Status, *req.Status, workflowFields); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		if err := utils.CheckTaskCanFinish(h.db, &task, *req.Status); err != nil {
+			utils.Error(c, 400, err.Error())
 			return
 		}
 		task.Status = *req.Status
 	} else {
-		// 如果没有提供状态，自动修改：如果当前状态是 "wait"，自动改为 "doing"
-		if task.Status == "wait" {
+		// 如果没有提供状态，自动修改：未开始的任务在工作流允许时自动改为 "doing"
+		if utils.CanAutoTransition(h.db, c, "task", task.ProjectID, task.Status, "doing") {
 			task.Status = "doing"
 		}
 	}
@@ -1147,5 +1261,22 @@ func (h *TaskHandler) AssignTask(c *gin.Context) {
 		}
 	}
 
+	// 指派人变更时通知新指派人
+	if oldAssigneeID == nil || *oldAssigneeID != req.AssigneeID {
+		notifyContent := ""
+		if req.Comment != nil {
+			notifyContent = *req.Comment
+		}
+		utils.Notify(h.db, []uint{req.AssigneeID}, utils.NotificationInput{
+			Type:       utils.NotificationTaskAssigned,
+			Title:      "任务已指派给你：" + task.Title,
+			Content:    notifyContent,
+			ObjectType: "task",
+			ObjectID:   task.ID,
+			ProjectID:  task.ProjectID,
+			ActorID:    utils.GetUserID(c),
+		})
+	}
+
 	utils.Success(c, task)
 }
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TaskDependencyItem 任务依赖列表项
+type TaskDependencyItem struct {
+	TaskID uint   `json:"task_id"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Type   string `json:"type"`
+}
+
+// respondTaskDependencyError 依赖校验失败时返回400及循环路径，其他错误返回500
+func respondTaskDependencyError(c *gin.Context, err error) {
+	var dependencyErr *utils.TaskDependencyError
+	if errors.As(err, &dependencyErr) {
+		utils.ErrorWithData(c, 400, dependencyErr.Message, gin.H{"path": dependencyErr.Path})
+		return
+	}
+	utils.Error(c, utils.CodeError, "校验依赖失败")
+}
+
+// GetTaskDependencies 获取任务的前置任务（dependencies）和后续任务（dependents）
+func (h *TaskHandler) GetTaskDependencies(c *gin.Context) {
+	var task model.Task
+	if err := h.db.First(&task, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "任务不存在")
+		return
+	}
+	if !utils.CheckTaskAccess(h.db, c, task.ID) {
+		utils.Error(c, 403, "没有权限访问该任务")
+		return
+	}
+
+	dependencies, err := h.loadDependencyItems("task_dependencies.task_id = ?", "task_dependencies.dependency_id", task.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	dependents, err := h.loadDependencyItems("task_dependencies.dependency_id = ?", "task_dependencies.task_id", task.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	utils.Success(c, gin.H{
+		"dependencies": dependencies,
+		"dependents":   dependents,
+	})
+}
+
+// loadDependencyItems 查询依赖关系另一端的任务
+func (h *TaskHandler) loadDependencyItems(where, joinColumn string, taskID uint) ([]TaskDependencyItem, error) {
+	items := make([]TaskDependencyItem, 0)
+	err := h.db.Table("task_dependencies").
+		Select("tasks.id AS task_id, tasks.title, tasks.status, task_dependencies.type").
+		Joins("JOIN tasks ON tasks.id = "+joinColumn+" AND tasks.deleted_at IS NULL").
+		Where(where, taskID).Order("tasks.id").Scan(&items).Error
+	return items, err
+}
+
+// AddTaskDependency 添加前置任务（已存在时更新依赖类型），校验同一项目且不形成循环
+func (h *TaskHandler) AddTaskDependency(c *gin.Context) {
+	var task model.Task
+	if err := h.db.First(&task, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "任务不存在")
+		return
+	}
+	if !utils.CheckTaskAccess(h.db, c, task.ID) {
+		utils.Error(c, 403, "没有权限修改该任务")
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
+
+	var req struct {
+		DependencyID uint   `json:"dependency_id" binding:"required"`
+		Type         string `json:"type"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+	if req.Type == "" {
+		req.Type = utils.DependencyFinishToStart
+	}
+	if !utils.IsValidDependencyType(req.Type) {
+		utils.Error(c, 400, "依赖类型无效，有效值："+utils.DependencyFinishToStart+", "+utils.DependencyStartToStart+", "+
+			utils.DependencyFinishToFinish+", "+utils.DependencyStartToFinish)
+		return
+	}
+
+	var dependencyIDs []uint
+	if err := h.db.Model(&model.TaskDependency{}).Where("task_id = ?", task.ID).Pluck("dependency_id", &dependencyIDs).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	existing := false
+	for _, id := range dependencyIDs {
+		if id == req.DependencyID {
+			existing = true
+		}
+	}
+	if !existing {
+		dependencyIDs = append(dependencyIDs, req.DependencyID)
+	}
+	if err := utils.ValidateTaskDependencies(h.db, &task, dependencyIDs); err != nil {
+		respondTaskDependencyError(c, err)
+		return
+	}
+
+	if existing {
+		err := h.db.Model(&model.TaskDependency{}).Where("task_id = ? AND dependency_id = ?", task.ID, req.DependencyID).
+			Update("type", req.Type).Error
+		if err != nil {
+			utils.Error(c, utils.CodeError, "更新依赖失败")
+			return
+		}
+	} else if err := h.db.Create(&model.TaskDependency{TaskID: task.ID, DependencyID: req.DependencyID, Type: req.Type}).Error; err != nil {
+		utils.Error(c, utils.CodeError, "添加依赖失败")
+		return
+	}
+
+	var dependency model.Task
+	h.db.Select("id, title").First(&dependency, req.DependencyID)
+	utils.RecordAction(h.db, "task", task.ID, "edited", utils.GetUserID(c),
+		fmt.Sprintf("设置前置任务：#%d %s（%s）", dependency.ID, dependency.Title, req.Type), nil)
+
+	utils.Success(c, model.TaskDependency{TaskID: task.ID, DependencyID: req.DependencyID, Type: req.Type})
+}
+
+// RemoveTaskDependency 移除前置任务
+func (h *TaskHandler) RemoveTaskDependency(c *gin.Context) {
+	var task model.Task
+	if err := h.db.First(&task, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "任务不存在")
+		return
+	}
+	if !utils.CheckTaskAccess(h.db, c, task.ID) {
+		utils.Error(c, 403, "没有权限修改该任务")
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, task.ProjectID, "task:update") {
+		return
+	}
+
+	result := h.db.Where("task_id = ? AND dependency_id = ?", task.ID, c.Param("dependency_id")).Delete(&model.TaskDependency{})
+	if result.Error != nil {
+		utils.Error(c, utils.CodeError, "移除依赖失败")
+		return
+	}
+	if result.RowsAffected == 0 {
+		utils.Error(c, 404, "依赖关系不存在")
+		return
+	}
+
+	var dependency model.Task
+	h.db.Unscoped().Select("id, title").First(&dependency, c.Param("dependency_id"))
+	utils.RecordAction(h.db, "task", task.ID, "edited", utils.GetUserID(c),
+		fmt.Sprintf("移除前置任务：#%s %s", c.Param("dependency_id"), dependency.Title), nil)
+
+	utils.Success(c, gin.H{"message": "移除成功"})
+}
+
+// CheckProjectDependencies 检查项目依赖关系完整性：循环依赖、依赖自己、跨项目依赖和依赖已删除的任务
+func (h *ProjectHandler) CheckProjectDependencies(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	report, err := utils.CheckProjectDependencies(h.db, project.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "检查依赖关系失败")
+		return
+	}
+	utils.Success(c, report)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"strconv"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTaskTree 获取项目的任务分解结构（WBS），root_id 不为空时只返回该任务及其子孙任务
+func (h *TaskHandler) GetTaskTree(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Query("project_id"), 10, 32)
+	if err != nil {
+		utils.Error(c, 400, "请指定项目")
+		return
+	}
+	var project model.Project
+	if err := h.db.First(&project, projectID).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	var tasks []model.Task
+	if err := h.db.Preload("Assignee").Where("project_id = ?", project.ID).Order("id").Find(&tasks).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	roots := utils.BuildTaskTree(tasks)
+
+	if rootID := c.Query("root_id"); rootID != "" {
+		for _, node := range utils.FlattenTaskTree(roots) {
+			if strconv.FormatUint(uint64(node.ID), 10) == rootID {
+				utils.Success(c, []*utils.TaskTreeNode{node})
+				return
+			}
+		}
+		utils.Error(c, 404, "任务不存在")
+		return
+	}
+	utils.Success(c, roots)
+}
+
+// rollupParentTasks 子任务的工时、进度或状态变化后，逐级向上汇总父任务：
+// 预估工时为子任务预估工时之和，实际工时为自身登记工时加子任务实际工时，进度按工时计算，不改变父任务的状态
+func (h *TaskHandler) rollupParentTasks(parentIDs ...*uint) {
+	visited := make(map[uint]bool)
+	for _, parentID := range parentIDs {
+		for parentID != nil && !visited[*parentID] {
+			visited[*parentID] = true
+			var parent model.Task
+			if err := h.db.First(&parent, *parentID).Error; err != nil {
+				break
+			}
+
+			// 最后一个子任务被移走或删除后，保留原预估工时，只重新汇总实际工时
+			var children int64
+			h.db.Model(&model.Task{}).Where("parent_id = ?", parent.ID).Count(&children)
+			if children == 0 {
+				h.calculateAndUpdateActualHours(&parent)
+				parentID = parent.ParentID
+				continue
+			}
+
+			var estimated float64
+			h.db.Model(&model.Task{}).Where("parent_id = ?", parent.ID).Select("COALESCE(SUM(estimated_hours), 0)").Scan(&estimated)
+			parent.EstimatedHours = &estimated
+			h.db.Model(&parent).Update("estimated_hours", estimated)
+
+			h.calculateAndUpdateActualHours(&parent)
+			// 汇总只更新工时和进度，父任务的状态只能通过状态流转修改
+			if progress, ok := progressFromHours(&parent); ok {
+				h.db.Model(&parent).Update("progress", progress)
+			} else {
+				// 子任务都没有预估工时时，进度取子任务进度的平均值
+				var progress float64
+				h.db.Model(&model.Task{}).Where("parent_id = ?", parent.ID).Select("COALESCE(AVG(progress), 0)").Scan(&progress)
+				h.db.Model(&parent).Update("progress", int(progress))
+			}
+			parentID = parent.ParentID
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"strconv"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"prjflow/internal/model"
@@ -18,7 +20,7 @@ func NewTestCaseHandler(db *gorm.DB) *TestCaseHandler {
 // GetTestCases 获取测试单列表
 func (h *TestCaseHandler) GetTestCases(c *gin.Context) {
 	var testCases []model.TestCase
-	query := h.db.Preload("Project").Preload("Creator").Preload("Bugs")
+	query := h.db.Preload("Project").Preload("Creator").Preload("Bugs").Preload("Requirements")
 
 	// 搜索
 	if keyword := c.Query("keyword"); keyword != "" {
@@ -100,14 +102,55 @@ func (h *TestCaseHandler) GetTestCases(c *gin.Context) {
 func (h *TestCaseHandler) GetTestCase(c *gin.Context) {
 	id := c.Param("id")
 	var testCase model.TestCase
-	if err := h.db.Preload("Project").Preload("Creator").Preload("Bugs").First(&testCase, id).Error; err != nil {
+	if err := h.db.Preload("Project").Preload("Creator").Preload("Bugs").Preload("Requirements").First(&testCase, id).Error; err != nil {
 		utils.Error(c, 404, "测试单不存在")
 		return
 	}
+	testCase.Steps, _ = utils.LoadTestCaseSteps(h.db, testCase.ID, testCase.StepVersion)
 
 	utils.Success(c, testCase)
 }
 
+// GetTestCaseSteps 获取测试单的步骤，version 为空时返回当前版本，同时返回所有历史版本号
+func (h *TestCaseHandler) GetTestCaseSteps(c *gin.Context) {
+	var testCase model.TestCase
+	if err := h.db.First(&testCase, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "测试单不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, testCase.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	version := testCase.StepVersion
+	if value := c.Query("version"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			utils.Error(c, 400, "版本号无效")
+			return
+		}
+		version = parsed
+	}
+	versions, err := utils.TestCaseStepVersions(h.db, testCase.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+	steps, err := utils.LoadTestCaseSteps(h.db, testCase.ID, version)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"version":         version,
+		"current_version": testCase.StepVersion,
+		"versions":        versions,
+		"steps":           steps,
+	})
+}
+
 // CreateTestCase 创建测试单
 func (h *TestCaseHandler) CreateTestCase(c *gin.Context) {
 	var req struct {
@@ -120,6 +163,8 @@ func (h *TestCaseHandler) CreateTestCase(c *gin.Context) {
 		Summary     string   `json:"summary"`     // 测试摘要（合并自TestReport）
 		ProjectID   uint     `json:"project_id" binding:"required"`
 		BugIDs      []uint   `json:"bug_ids"` // 关联的Bug ID列表
+		RequirementIDs []uint `json:"requirement_ids"` // 覆盖的需求ID列表（同一项目）
+		Steps       []utils.TestStepInput `json:"steps"` // 结构化测试步骤
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -148,6 +193,15 @@ func (h *TestCaseHandler) CreateTestCase(c *gin.Context) {
 		utils.Error(c, 404, "项目不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "test-case:create") {
+		return
+	}
+
+	steps, err := utils.ExpandTestSteps(h.db, project.ID, utils.GetUserID(c), req.Steps)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
 
 	// 获取当前用户ID
 	userID, exists := c.Get("user_id")
@@ -167,12 +221,19 @@ func (h *TestCaseHandler) CreateTestCase(c *gin.Context) {
 		Summary:     req.Summary,
 		ProjectID:   req.ProjectID,
 		CreatorID:   uid,
+		StepVersion: 1,
 	}
 
 	if err := h.db.Create(&testCase).Error; err != nil {
 		utils.Error(c, utils.CodeError, "创建失败")
 		return
 	}
+	if len(steps) > 0 {
+		if _, err := utils.SaveTestCaseSteps(h.db, &testCase, steps); err != nil {
+			utils.Error(c, utils.CodeError, "保存测试步骤失败")
+			return
+		}
+	}
 
 	// 关联Bug
 	if len(req.BugIDs) > 0 {
@@ -182,8 +243,17 @@ func (h *TestCaseHandler) CreateTestCase(c *gin.Context) {
 		}
 	}
 
+	// 关联需求
+	if len(req.RequirementIDs) > 0 {
+		var requirements []model.Requirement
+		if err := h.db.Where("id IN ? AND project_id = ?", req.RequirementIDs, testCase.ProjectID).Find(&requirements).Error; err == nil {
+			h.db.Model(&testCase).Association("Requirements").Replace(requirements)
+		}
+	}
+
 	// 重新加载关联数据
-	h.db.Preload("Project").Preload("Creator").Preload("Bugs").First(&testCase, testCase.ID)
+	h.db.Preload("Project").Preload("Creator").Preload("Bugs").Preload("Requirements").First(&testCase, testCase.ID)
+	testCase.Steps, _ = utils.LoadTestCaseSteps(h.db, testCase.ID, testCase.StepVersion)
 
 	utils.Success(c, testCase)
 }
@@ -196,6 +266,9 @@ func (h *TestCaseHandler) UpdateTestCase(c *gin.Context) {
 		utils.Error(c, 404, "测试单不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, testCase.ProjectID, "test-case:update") {
+		return
+	}
 
 	var req struct {
 		Name        *string  `json:"name"`
@@ -206,6 +279,8 @@ func (h *TestCaseHandler) UpdateTestCase(c *gin.Context) {
 		Result      *string  `json:"result"`      // 测试结果：passed, failed, blocked（合并自TestReport）
 		Summary     *string  `json:"summary"`     // 测试摘要（合并自TestReport）
 		BugIDs      []uint   `json:"bug_ids"` // 关联的Bug ID列表
+		RequirementIDs []uint `json:"requirement_ids"` // 覆盖的需求ID列表（同一项目）
+		Steps       *[]utils.TestStepInput `json:"steps"` // 结构化测试步骤，有变化时生成新版本
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -213,6 +288,15 @@ func (h *TestCaseHandler) UpdateTestCase(c *gin.Context) {
 		return
 	}
 
+	var steps []model.TestCaseStep
+	if req.Steps != nil {
+		var err error
+		if steps, err = utils.ExpandTestSteps(h.db, testCase.ProjectID, utils.GetUserID(c), *req.Steps); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+	}
+
 	if req.Name != nil {
 		testCase.Name = *req.Name
 	}
@@ -253,8 +337,25 @@ func (h *TestCaseHandler) UpdateTestCase(c *gin.Context) {
 		h.db.Model(&testCase).Association("Bugs").Replace(bugs)
 	}
 
+	if req.Steps != nil {
+		if _, err := utils.SaveTestCaseSteps(h.db, &testCase, steps); err != nil {
+			utils.Error(c, utils.CodeError, "保存测试步骤失败")
+			return
+		}
+	}
+
+	// 更新关联需求
+	if req.RequirementIDs != nil {
+		var requirements []model.Requirement
+		if len(req.RequirementIDs) > 0 {
+			h.db.Where("id IN ? AND project_id = ?", req.RequirementIDs, testCase.ProjectID).Find(&requirements)
+		}
+		h.db.Model(&testCase).Association("Requirements").Replace(requirements)
+	}
+
 	// 重新加载关联数据
-	h.db.Preload("Project").Preload("Creator").Preload("Bugs").First(&testCase, testCase.ID)
+	h.db.Preload("Project").Preload("Creator").Preload("Bugs").Preload("Requirements").First(&testCase, testCase.ID)
+	testCase.Steps, _ = utils.LoadTestCaseSteps(h.db, testCase.ID, testCase.StepVersion)
 
 	utils.Success(c, testCase)
 }
@@ -262,7 +363,16 @@ func (h *TestCaseHandler) UpdateTestCase(c *gin.Context) {
 // DeleteTestCase 删除测试单
 func (h *TestCaseHandler) DeleteTestCase(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.db.Delete(&model.TestCase{}, id).Error; err != nil {
+	var testCase model.TestCase
+	if err := h.db.First(&testCase, id).Error; err != nil {
+		utils.Error(c, 404, "测试单不存在")
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, testCase.ProjectID, "test-case:delete") {
+		return
+	}
+
+	if err := h.db.Delete(&testCase).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
 	}
@@ -278,6 +388,9 @@ func (h *TestCaseHandler) UpdateTestCaseStatus(c *gin.Context) {
 		utils.Error(c, 404, "测试单不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, testCase.ProjectID, "test-case:update") {
+		return
+	}
 
 	var req struct {
 		Status string `json:"status" binding:"required"`
@@ -299,7 +412,8 @@ func (h *TestCaseHandler) UpdateTestCaseStatus(c *gin.Context) {
 	}
 
 	// 重新加载关联数据
-	h.db.Preload("Project").Preload("Creator").Preload("Bugs").First(&testCase, testCase.ID)
+	h.db.Preload("Project").Preload("Creator").Preload("Bugs").Preload("Requirements").First(&testCase, testCase.ID)
+	testCase.Steps, _ = utils.LoadTestCaseSteps(h.db, testCase.ID, testCase.StepVersion)
 
 	utils.Success(c, testCase)
 }
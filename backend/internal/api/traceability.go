@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// GetProjectTraceability 获取项目的需求追溯矩阵，version_id 不为空时只包含该版本；format=csv/xlsx 时导出文件
+func (h *ProjectHandler) GetProjectTraceability(c *gin.Context) {
+	var project model.Project
+	if err := h.db.First(&project, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, project.ID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	var versionID *uint
+	name := fmt.Sprintf("traceability-project-%d", project.ID)
+	if value := c.Query("version_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			utils.Error(c, 400, "参数错误")
+			return
+		}
+		var version model.Version
+		if err := h.db.Where("project_id = ?", project.ID).First(&version, id).Error; err != nil {
+			utils.Error(c, 404, "版本不存在")
+			return
+		}
+		versionID = &version.ID
+		name = fmt.Sprintf("traceability-version-%d", version.ID)
+	}
+	respondTraceMatrix(c, h.db, project.ID, versionID, name)
+}
+
+// GetVersionTraceability 获取版本的需求追溯矩阵；format=csv/xlsx 时导出文件
+func (h *VersionHandler) GetVersionTraceability(c *gin.Context) {
+	var version model.Version
+	if err := h.db.First(&version, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "版本不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, version.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+	respondTraceMatrix(c, h.db, version.ProjectID, &version.ID, fmt.Sprintf("traceability-version-%d", version.ID))
+}
+
+// respondTraceMatrix 按 format 参数返回追溯矩阵：默认 JSON，csv 或 xlsx 时以附件下载
+func respondTraceMatrix(c *gin.Context, db *gorm.DB, projectID uint, versionID *uint, name string) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" && format != "xlsx" {
+		utils.Error(c, 400, "导出格式必须是 csv 或 xlsx")
+		return
+	}
+
+	matrix, err := utils.BuildTraceMatrix(db, projectID, versionID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "生成追溯矩阵失败")
+		return
+	}
+	if format == "json" {
+		utils.Success(c, matrix)
+		return
+	}
+
+	table := utils.TraceMatrixTable(matrix)
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102"), format)
+	var buf bytes.Buffer
+	contentType := "text/csv; charset=utf-8"
+	if format == "csv" {
+		buf.WriteString("\xEF\xBB\xBF") // UTF-8 BOM，Excel 打开时中文不乱码
+		writer := csv.NewWriter(&buf)
+		if err := writer.WriteAll(escapeCSVFormulas(table)); err != nil {
+			utils.Error(c, utils.CodeError, "导出失败")
+			return
+		}
+	} else {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		if err := writeTraceMatrixXLSX(&buf, table); err != nil {
+			utils.Error(c, utils.CodeError, "导出失败")
+			return
+		}
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Data(200, contentType, buf.Bytes())
+}
+
+// escapeCSVFormulas 以 = + - @ 或制表符、回车符开头的单元格前加单引号，避免在表格软件中打开时被当作公式执行
+func escapeCSVFormulas(table [][]string) [][]string {
+	escaped := make([][]string, len(table))
+	for i, row := range table {
+		escaped[i] = make([]string, len(row))
+		for j, value := range row {
+			if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+				value = "'" + value
+			}
+			escaped[i][j] = value
+		}
+	}
+	return escaped
+}
+
+// writeTraceMatrixXLSX 把追溯矩阵表格写为 XLSX 文件（多值单元格自动换行）
+func writeTraceMatrixXLSX(buf *bytes.Buffer, table [][]string) error {
+	file := excelize.NewFile()
+	defer file.Close()
+
+	const sheet = "追溯矩阵"
+	if err := file.SetSheetName(file.GetSheetName(0), sheet); err != nil {
+		return err
+	}
+	for i, row := range table {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, len(row))
+		for j, value := range row {
+			values[j] = value
+		}
+		if err := file.SetSheetRow(sheet, cell, &values); err != nil {
+			return err
+		}
+	}
+
+	lastColumn, err := excelize.ColumnNumberToName(len(table[0]))
+	if err != nil {
+		return err
+	}
+	headerStyle, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	wrapStyle, err := file.NewStyle(&excelize.Style{Alignment: &excelize.Alignment{WrapText: true, Vertical: "top"}})
+	if err != nil {
+		return err
+	}
+	if err := file.SetCellStyle(sheet, "A1", lastColumn+"1", headerStyle); err != nil {
+		return err
+	}
+	if len(table) > 1 {
+		if err := file.SetCellStyle(sheet, "A2", fmt.Sprintf("%s%d", lastColumn, len(table)), wrapStyle); err != nil {
+			return err
+		}
+	}
+	if err := file.SetColWidth(sheet, "B", "B", 30); err != nil {
+		return err
+	}
+	if err := file.SetColWidth(sheet, "E", "H", 36); err != nil {
+		return err
+	}
+	return file.Write(buf)
+}
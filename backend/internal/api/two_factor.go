@@ -0,0 +1,313 @@
+package api
+
+import (
+	"errors"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTwoFactorStatus 获取当前用户的两步验证状态
+func (h *AuthHandler) GetTwoFactorStatus(c *gin.Context) {
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		utils.Error(c, 401, "未授权")
+		return
+	}
+
+	tf, err := utils.GetUserTwoFactor(h.db, userID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询两步验证设置失败")
+		return
+	}
+	required, err := utils.IsTwoFactorRequired(h.db, userID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询两步验证策略失败")
+		return
+	}
+
+	data := gin.H{
+		"enabled":                  false,
+		"required":                 required,
+		"enabled_at":               nil,
+		"recovery_codes_remaining": 0,
+	}
+	if tf != nil && tf.Enabled {
+		data["enabled"] = true
+		data["enabled_at"] = tf.EnabledAt
+		data["recovery_codes_remaining"] = utils.CountRecoveryCodes(h.db, userID)
+	}
+	utils.Success(c, data)
+}
+
+// SetupTwoFactor 生成TOTP密钥，返回密钥和用于生成二维码的绑定地址
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, utils.GetUserID(c)).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	h.respondTwoFactorSetup(c, &user)
+}
+
+// EnableTwoFactor 使用验证器应用生成的验证码确认绑定，启用两步验证并返回恢复码
+func (h *AuthHandler) EnableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "请输入验证码")
+		return
+	}
+
+	userID := utils.GetUserID(c)
+	codes, err := utils.EnableTwoFactor(h.db, userID, req.Code)
+	if err != nil {
+		h.respondTwoFactorError(c, err)
+		return
+	}
+
+	utils.RecordAuditLog(h.db, userID, c.GetString("username"), "enable_two_factor", "user", userID, c, true, "", "")
+	utils.Success(c, gin.H{
+		"message":        "两步验证已启用，请妥善保存恢复码",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor 关闭两步验证（需要验证码或恢复码，设置了密码的用户还需要验证密码）
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	var user model.User
+	if err := h.db.First(&user, utils.GetUserID(c)).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	required, err := utils.IsTwoFactorRequired(h.db, user.ID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "查询两步验证策略失败")
+		return
+	}
+	if required {
+		utils.Error(c, 403, "您的角色要求启用两步验证，无法关闭")
+		return
+	}
+
+	if !h.verifyTwoFactorCredentials(c, &user, true, req.Password, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	if err := utils.DisableTwoFactor(h.db, user.ID); err != nil {
+		utils.Error(c, utils.CodeError, "关闭两步验证失败")
+		return
+	}
+
+	utils.RecordAuditLog(h.db, user.ID, user.Username, "disable_two_factor", "user", user.ID, c, true, "", "")
+	utils.Success(c, gin.H{"message": "两步验证已关闭"})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（需要验证码），之前的恢复码全部失效
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "请输入验证码")
+		return
+	}
+
+	var user model.User
+	if err := h.db.First(&user, utils.GetUserID(c)).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+	if !h.verifyTwoFactorCredentials(c, &user, false, "", req.Code, "") {
+		return
+	}
+
+	codes, err := utils.RegenerateRecoveryCodes(h.db, user.ID)
+	if err != nil {
+		h.respondTwoFactorError(c, err)
+		return
+	}
+
+	utils.RecordAuditLog(h.db, user.ID, user.Username, "regenerate_recovery_codes", "user", user.ID, c, true, "", "")
+	utils.Success(c, gin.H{"recovery_codes": codes})
+}
+
+// SetupTwoFactorLogin 登录时强制绑定：使用两步验证临时Token生成TOTP密钥
+func (h *AuthHandler) SetupTwoFactorLogin(c *gin.Context) {
+	var req struct {
+		TwoFactorToken string `json:"two_factor_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	user, ok := h.loadTwoFactorLoginUser(c, req.TwoFactorToken)
+	if !ok {
+		return
+	}
+	h.respondTwoFactorSetup(c, user)
+}
+
+// VerifyTwoFactorLogin 登录第二步：校验验证码或恢复码，通过后创建会话并返回Token
+// 策略要求但尚未启用两步验证的用户，在此使用验证码完成绑定，同时返回恢复码
+func (h *AuthHandler) VerifyTwoFactorLogin(c *gin.Context) {
+	var req struct {
+		TwoFactorToken string `json:"two_factor_token" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		utils.Error(c, 400, "请输入验证码或恢复码")
+		return
+	}
+
+	user, ok := h.loadTwoFactorLoginUser(c, req.TwoFactorToken)
+	if !ok {
+		return
+	}
+
+	ip := c.ClientIP()
+	if blocked := utils.CheckLoginAllowed(h.db, user.Username, ip); blocked != nil {
+		utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, blocked.Message, "")
+		utils.RespondLoginBlocked(c, blocked)
+		return
+	}
+
+	var extra gin.H
+	var err error
+	usedRecoveryCode := false
+	if utils.IsTwoFactorEnabled(h.db, user.ID) {
+		usedRecoveryCode = req.Code == ""
+		err = utils.VerifyTwoFactor(h.db, user.ID, req.Code, req.RecoveryCode)
+	} else {
+		// 首次绑定只能使用验证码
+		var codes []string
+		if codes, err = utils.EnableTwoFactor(h.db, user.ID, req.Code); err == nil {
+			utils.RecordAuditLog(h.db, user.ID, user.Username, "enable_two_factor", "user", user.ID, c, true, "", "")
+			extra = gin.H{"recovery_codes": codes}
+		}
+	}
+	if err != nil {
+		if errors.Is(err, utils.ErrTwoFactorInvalidCode) {
+			utils.RecordAuditLog(h.db, user.ID, user.Username, "login", "user", user.ID, c, false, "两步验证码错误", "")
+			if blocked := utils.RecordLoginFailure(h.db, user.Username, ip); blocked != nil {
+				utils.RespondLoginBlocked(c, blocked)
+				return
+			}
+		}
+		h.respondTwoFactorError(c, err)
+		return
+	}
+
+	utils.ClearLoginFailures(h.db, user.Username)
+	if usedRecoveryCode {
+		remaining := utils.CountRecoveryCodes(h.db, user.ID)
+		extra = gin.H{"recovery_codes_remaining": remaining}
+	}
+	h.completeLogin(c, user, "password_2fa", extra)
+}
+
+// loadTwoFactorLoginUser 解析两步验证临时Token并加载用户，失败时已写入响应
+func (h *AuthHandler) loadTwoFactorLoginUser(c *gin.Context, tokenString string) (*model.User, bool) {
+	claims, err := auth.ParseTwoFactorToken(tokenString)
+	if err != nil {
+		utils.Error(c, 401, "两步验证已过期，请重新登录")
+		return nil, false
+	}
+
+	var user model.User
+	if err := h.db.First(&user, claims.UserID).Error; err != nil {
+		utils.Error(c, 401, "两步验证已过期，请重新登录")
+		return nil, false
+	}
+	if user.Status != 1 {
+		utils.Error(c, 403, "用户已被禁用")
+		return nil, false
+	}
+	return &user, true
+}
+
+// verifyTwoFactorCredentials 敏感操作前校验两步验证码（或恢复码），checkPassword 为 true 时
+// 设置了密码的用户还需验证密码；失败计入登录失败次数，失败时已写入响应
+func (h *AuthHandler) verifyTwoFactorCredentials(c *gin.Context, user *model.User, checkPassword bool, password, code, recoveryCode string) bool {
+	ip := c.ClientIP()
+	if blocked := utils.CheckLoginAllowed(h.db, user.Username, ip); blocked != nil {
+		utils.RespondLoginBlocked(c, blocked)
+		return false
+	}
+
+	fail := func(message string) bool {
+		if blocked := utils.RecordLoginFailure(h.db, user.Username, ip); blocked != nil {
+			utils.RespondLoginBlocked(c, blocked)
+			return false
+		}
+		utils.Error(c, 400, message)
+		return false
+	}
+
+	if checkPassword && user.Password != "" {
+		if password == "" {
+			utils.Error(c, 400, "请输入密码")
+			return false
+		}
+		if !utils.CheckPassword(password, user.Password) {
+			return fail("密码错误")
+		}
+	}
+
+	if err := utils.VerifyTwoFactor(h.db, user.ID, code, recoveryCode); err != nil {
+		if errors.Is(err, utils.ErrTwoFactorInvalidCode) {
+			return fail("验证码错误")
+		}
+		h.respondTwoFactorError(c, err)
+		return false
+	}
+	return true
+}
+
+func (h *AuthHandler) respondTwoFactorSetup(c *gin.Context, user *model.User) {
+	secret, uri, err := utils.StartTwoFactorSetup(h.db, user)
+	if err != nil {
+		h.respondTwoFactorError(c, err)
+		return
+	}
+	utils.Success(c, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri, // 前端渲染为二维码，供验证器应用扫描
+		"issuer":      utils.TwoFactorIssuer,
+		"account":     user.Username,
+	})
+}
+
+func (h *AuthHandler) respondTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrTwoFactorInvalidCode):
+		utils.Error(c, 400, "验证码错误")
+	case errors.Is(err, utils.ErrTwoFactorNotEnabled),
+		errors.Is(err, utils.ErrTwoFactorAlreadyEnabled),
+		errors.Is(err, utils.ErrTwoFactorNotSetup):
+		utils.Error(c, 400, err.Error())
+	default:
+		utils.Error(c, utils.CodeError, "两步验证操作失败")
+	}
+}
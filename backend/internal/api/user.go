@@ -2,6 +2,7 @@ package api
 
 import (
 	"strings"
+	"time"
 
 	"prjflow/internal/config"
 	"prjflow/internal/model"
@@ -97,12 +98,17 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		Avatar       string `json:"avatar"`
 		Status       int    `json:"status"`
 		DepartmentID *uint  `json:"department_id"`
+		IsServiceAccount bool `json:"is_service_account"` // 服务账号（不能登录，只能使用访问令牌），不能设置密码
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.Error(c, 400, "参数错误: "+err.Error())
 		return
 	}
+	if req.IsServiceAccount && req.Password != "" {
+		utils.Error(c, 400, "服务账号不能设置密码")
+		return
+	}
 
 	// 检查用户名是否已存在（包括软删除的用户）
 	var existingUser model.User
@@ -123,13 +129,14 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 
 	// 创建用户
 	user := model.User{
-		Username:     req.Username,
-		Nickname:     req.Nickname,
-		Email:        req.Email,
-		Phone:        req.Phone,
-		Avatar:       req.Avatar,
-		Status:       req.Status,
-		DepartmentID: req.DepartmentID,
+		Username:         req.Username,
+		Nickname:         req.Nickname,
+		Email:            req.Email,
+		Phone:            req.Phone,
+		Avatar:           req.Avatar,
+		Status:           req.Status,
+		DepartmentID:     req.DepartmentID,
+		IsServiceAccount: req.IsServiceAccount,
 	}
 
 	// 如果提供了密码，则验证密码强度并加密存储
@@ -277,6 +284,19 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// 禁用用户或重置密码后，吊销该用户的所有会话
+	revokeReason := ""
+	if user.Status != 1 {
+		revokeReason = utils.SessionRevokedUserDisabled
+	} else if req.Password != "" {
+		revokeReason = utils.SessionRevokedPasswordChanged
+	}
+	if revokeReason != "" {
+		if _, err := utils.RevokeUserSessions(h.db, user.ID, "", revokeReason); err != nil && utils.Logger != nil {
+			utils.Logger.Errorf("吊销用户会话失败: user_id=%d, error=%v", user.ID, err)
+		}
+	}
+
 	// 重新加载用户（包含关联数据）
 	h.db.Preload("Department").Preload("Roles").First(&user, user.ID)
 
@@ -317,6 +337,24 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		utils.RecordAuditLog(h.db, userID.(uint), username.(string), "delete", "user", user.ID, c, true, "", "")
 	}
 
+	// 删除用户的登录会话
+	if err := h.db.Where("user_id = ?", user.ID).Delete(&model.UserSession{}).Error; err != nil {
+		utils.Error(c, utils.CodeError, "删除用户会话失败")
+		return
+	}
+
+	// 删除用户的访问令牌
+	if err := h.db.Where("user_id = ?", user.ID).Delete(&model.PersonalAccessToken{}).Error; err != nil {
+		utils.Error(c, utils.CodeError, "删除访问令牌失败")
+		return
+	}
+
+	// 删除用户的两步验证设置
+	if err := utils.DisableTwoFactor(h.db, user.ID); err != nil {
+		utils.Error(c, utils.CodeError, "删除两步验证设置失败")
+		return
+	}
+
 	// 硬删除用户
 	if err := h.db.Unscoped().Delete(&model.User{}, id).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
@@ -326,6 +364,94 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 	utils.Success(c, gin.H{"message": "删除成功"})
 }
 
+// GetUserSessions 获取指定用户的有效登录会话（管理员操作）
+func (h *UserHandler) GetUserSessions(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	var sessions []model.UserSession
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_active_at DESC").Find(&sessions).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询会话失败")
+		return
+	}
+
+	utils.Success(c, sessions)
+}
+
+// ForceLogout 强制用户下线，吊销其所有会话（管理员操作）
+func (h *UserHandler) ForceLogout(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	count, err := utils.RevokeUserSessions(h.db, user.ID, "", utils.SessionRevokedForceLogout)
+	if err != nil {
+		utils.Error(c, utils.CodeError, "强制下线失败")
+		return
+	}
+
+	// 记录审计日志
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "force_logout", "user", user.ID, c, true, "", "")
+
+	utils.Success(c, gin.H{
+		"message": "已强制下线",
+		"count":   count,
+	})
+}
+
+// UnlockUser 解除用户因多次登录失败导致的锁定（管理员操作）
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	lockedUntil := utils.GetAccountLock(h.db, user.Username)
+	if err := utils.ClearLoginFailures(h.db, user.Username); err != nil {
+		utils.Error(c, utils.CodeError, "解锁失败")
+		return
+	}
+
+	// 记录审计日志
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "unlock", "user", user.ID, c, true, "", "")
+
+	utils.Success(c, gin.H{
+		"message":    "解锁成功",
+		"was_locked": lockedUntil != nil,
+	})
+}
+
+// ResetUserTwoFactor 重置用户的两步验证（用户丢失验证器设备时由管理员操作）
+// 重置后用户可以仅凭密码登录；如果角色要求两步验证，下次登录时需要重新绑定
+func (h *UserHandler) ResetUserTwoFactor(c *gin.Context) {
+	var user model.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	wasEnabled := utils.IsTwoFactorEnabled(h.db, user.ID)
+	if err := utils.DisableTwoFactor(h.db, user.ID); err != nil {
+		utils.Error(c, utils.CodeError, "重置两步验证失败")
+		return
+	}
+
+	// 记录审计日志
+	utils.RecordAuditLog(h.db, utils.GetUserID(c), c.GetString("username"), "reset_two_factor", "user", user.ID, c, true, "", "")
+
+	utils.Success(c, gin.H{
+		"message":     "两步验证已重置",
+		"was_enabled": wasEnabled,
+	})
+}
+
 // AddUserByWeChatCallback 处理微信授权回调（GET请求，微信直接重定向到这里）
 // 这个接口在微信内打开，处理完添加用户后通过WebSocket通知PC前端
 func (h *UserHandler) AddUserByWeChatCallback(c *gin.Context) {
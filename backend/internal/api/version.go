@@ -92,6 +92,27 @@ func (h *VersionHandler) GetVersion(c *gin.Context) {
 	utils.Success(c, version)
 }
 
+// GetVersionBurndown 获取版本燃尽图/燃起图，统计版本关联的Bug和关联需求下的任务
+// 默认统计版本创建日期到发布日期（未发布时到今天）
+func (h *VersionHandler) GetVersionBurndown(c *gin.Context) {
+	var version model.Version
+	if err := h.db.First(&version, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "版本不存在")
+		return
+	}
+	if !utils.CheckProjectAccess(h.db, c, version.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该项目")
+		return
+	}
+
+	respondBurnChart(c, h.db, utils.BurnChartScope{
+		Type:      utils.BurnScopeVersion,
+		ID:        version.ID,
+		StartDate: version.CreatedAt,
+		EndDate:   burnChartEnd(version.ReleaseDate),
+	})
+}
+
 // CreateVersion 创建版本
 func (h *VersionHandler) CreateVersion(c *gin.Context) {
 	var req struct {
@@ -125,6 +146,9 @@ func (h *VersionHandler) CreateVersion(c *gin.Context) {
 		utils.Error(c, 404, "项目不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, project.ID, "project:update") {
+		return
+	}
 
 	// 解析发布日期
 	var releaseDate *time.Time
@@ -221,6 +245,9 @@ func (h *VersionHandler) UpdateVersion(c *gin.Context) {
 		utils.Error(c, 404, "版本不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, version.ProjectID, "project:update") {
+		return
+	}
 
 	var req struct {
 		VersionNumber  *string `json:"version_number"`
@@ -355,7 +382,16 @@ func (h *VersionHandler) UpdateVersion(c *gin.Context) {
 // DeleteVersion 删除版本
 func (h *VersionHandler) DeleteVersion(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.db.Delete(&model.Version{}, id).Error; err != nil {
+	var version model.Version
+	if err := h.db.First(&version, id).Error; err != nil {
+		utils.Error(c, 404, "版本不存在")
+		return
+	}
+	if !utils.RequireProjectPermission(h.db, c, version.ProjectID, "project:delete") {
+		return
+	}
+
+	if err := h.db.Delete(&version).Error; err != nil {
 		utils.Error(c, utils.CodeError, "删除失败")
 		return
 	}
@@ -371,6 +407,9 @@ func (h *VersionHandler) UpdateVersionStatus(c *gin.Context) {
 		utils.Error(c, 404, "版本不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, version.ProjectID, "project:update") {
+		return
+	}
 
 	var req struct {
 		Status string `json:"status" binding:"required"`
@@ -412,6 +451,9 @@ func (h *VersionHandler) ReleaseVersion(c *gin.Context) {
 		utils.Error(c, 404, "版本不存在")
 		return
 	}
+	if !utils.RequireProjectPermission(h.db, c, version.ProjectID, "project:update") {
+		return
+	}
 
 	version.Status = "normal"
 	if version.ReleaseDate == nil {
@@ -428,6 +470,17 @@ func (h *VersionHandler) ReleaseVersion(c *gin.Context) {
 	h.db.Preload("Project").
 		Preload("Requirements").Preload("Bugs").First(&version, version.ID)
 
+	// 记录发布操作
+	userID, exists := c.Get("user_id")
+	if exists {
+		dbValue, _ := c.Get("db")
+		if db, ok := dbValue.(*gorm.DB); ok {
+			utils.RecordAction(db, "version", version.ID, "released", userID.(uint), "", map[string]interface{}{
+				"version_number": version.VersionNumber,
+			})
+		}
+	}
+
 	utils.Success(c, version)
 }
 
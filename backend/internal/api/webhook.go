@@ -0,0 +1,393 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+type WebhookHandler struct {
+	db *gorm.DB
+}
+
+func NewWebhookHandler(db *gorm.DB) *WebhookHandler {
+	return &WebhookHandler{db: db}
+}
+
+// canManageWebhooks 检查是否可以管理项目Webhook：管理员或项目负责人
+func (h *WebhookHandler) canManageWebhooks(c *gin.Context, projectID uint) bool {
+	if utils.IsAdmin(c) {
+		return true
+	}
+	userID := utils.GetUserID(c)
+	if userID == 0 {
+		return false
+	}
+	role, ok := utils.GetProjectMemberRole(h.db, projectID, userID)
+	return ok && utils.NormalizeProjectRole(role) == utils.ProjectRoleOwner
+}
+
+// validateWebhookEvents 校验订阅的事件类型
+func validateWebhookEvents(events []string) (string, bool) {
+	valid := make(map[string]bool, len(utils.WebhookEventTypes))
+	for _, e := range utils.WebhookEventTypes {
+		valid[e] = true
+	}
+	for _, e := range events {
+		if e != "*" && !valid[e] {
+			return e, false
+		}
+	}
+	return "", true
+}
+
+// loadWebhook 加载Webhook并检查管理权限
+func (h *WebhookHandler) loadWebhook(c *gin.Context) (*model.Webhook, bool) {
+	var webhook model.Webhook
+	if err := h.db.First(&webhook, c.Param("id")).Error; err != nil {
+		utils.Error(c, 404, "Webhook不存在")
+		return nil, false
+	}
+	if !h.canManageWebhooks(c, webhook.ProjectID) {
+		utils.Error(c, 403, "没有权限管理该项目的Webhook")
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// GetWebhookEvents 获取支持订阅的事件类型
+func (h *WebhookHandler) GetWebhookEvents(c *gin.Context) {
+	utils.Success(c, utils.WebhookEventTypes)
+}
+
+// GetProjectWebhooks 获取项目的Webhook列表
+func (h *WebhookHandler) GetProjectWebhooks(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, 400, "项目ID无效")
+		return
+	}
+	if !h.canManageWebhooks(c, uint(projectID)) {
+		utils.Error(c, 403, "没有权限管理该项目的Webhook")
+		return
+	}
+
+	var webhooks []model.Webhook
+	if err := h.db.Where("project_id = ?", projectID).Preload("Creator").Order("created_at DESC").Find(&webhooks).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	utils.Success(c, webhooks)
+}
+
+// CreateProjectWebhook 创建项目Webhook
+// 未提供签名密钥时自动生成，密钥仅在创建和重新生成时返回
+func (h *WebhookHandler) CreateProjectWebhook(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, 400, "项目ID无效")
+		return
+	}
+
+	var project model.Project
+	if err := h.db.First(&project, projectID).Error; err != nil {
+		utils.Error(c, 404, "项目不存在")
+		return
+	}
+	if !h.canManageWebhooks(c, project.ID) {
+		utils.Error(c, 403, "没有权限管理该项目的Webhook")
+		return
+	}
+
+	var req struct {
+		Name        string   `json:"name" binding:"required"`
+		URL         string   `json:"url" binding:"required"`
+		Secret      string   `json:"secret"`
+		Events      []string `json:"events" binding:"required"`
+		Description string   `json:"description"`
+		Status      *int     `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+
+	if err := utils.CheckWebhookTarget(req.URL); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+	if len(req.Events) == 0 {
+		utils.Error(c, 400, "至少需要订阅一个事件")
+		return
+	}
+	if event, ok := validateWebhookEvents(req.Events); !ok {
+		utils.Error(c, 400, "不支持的事件类型："+event)
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		secret = utils.GenerateWebhookSecret()
+	}
+
+	webhook := model.Webhook{
+		ProjectID:   project.ID,
+		Name:        req.Name,
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      model.StringArray(req.Events),
+		Description: req.Description,
+		Status:      1,
+		CreatorID:   utils.GetUserID(c),
+	}
+	if req.Status != nil && *req.Status == 0 {
+		webhook.Status = 0
+	}
+
+	if err := h.db.Create(&webhook).Error; err != nil {
+		utils.Error(c, utils.CodeError, "创建失败")
+		return
+	}
+	// 创建时即使传入状态0，default标签也会写入1，这里显式更新
+	if webhook.Status == 0 {
+		h.db.Model(&webhook).Update("status", 0)
+	}
+
+	utils.Success(c, gin.H{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// GetWebhook 获取Webhook详情
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+	utils.Success(c, webhook)
+}
+
+// UpdateWebhook 更新Webhook
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name             *string  `json:"name"`
+		URL              *string  `json:"url"`
+		Secret           *string  `json:"secret"`
+		RegenerateSecret bool     `json:"regenerate_secret"`
+		Events           []string `json:"events"`
+		Description      *string  `json:"description"`
+		Status           *int     `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+
+	if req.Name != nil {
+		webhook.Name = *req.Name
+	}
+	if req.URL != nil {
+		if err := utils.CheckWebhookTarget(*req.URL); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		webhook.URL = *req.URL
+	}
+	if req.Events != nil {
+		if len(req.Events) == 0 {
+			utils.Error(c, 400, "至少需要订阅一个事件")
+			return
+		}
+		if event, ok := validateWebhookEvents(req.Events); !ok {
+			utils.Error(c, 400, "不支持的事件类型："+event)
+			return
+		}
+		webhook.Events = model.StringArray(req.Events)
+	}
+	if req.Description != nil {
+		webhook.Description = *req.Description
+	}
+	if req.Status != nil {
+		webhook.Status = *req.Status
+	}
+
+	// 新密钥仅在本次响应中返回
+	newSecret := ""
+	if req.RegenerateSecret {
+		newSecret = utils.GenerateWebhookSecret()
+	} else if req.Secret != nil && *req.Secret != "" {
+		newSecret = *req.Secret
+	}
+	if newSecret != "" {
+		webhook.Secret = newSecret
+	}
+
+	if err := h.db.Save(webhook).Error; err != nil {
+		utils.Error(c, utils.CodeError, "更新失败")
+		return
+	}
+
+	result := gin.H{"webhook": webhook}
+	if newSecret != "" {
+		result["secret"] = newSecret
+	}
+	utils.Success(c, result)
+}
+
+// DeleteWebhook 删除Webhook（未完成的投递将不再重试）
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(webhook).Error; err != nil {
+		utils.Error(c, utils.CodeError, "删除失败")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "删除成功"})
+}
+
+// GetWebhookDeliveries 获取Webhook投递记录
+func (h *WebhookHandler) GetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	page := utils.GetPage(c)
+	pageSize := utils.GetPageSize(c)
+	offset := (page - 1) * pageSize
+
+	query := h.db.Model(&model.WebhookDelivery{}).Where("webhook_id = ?", webhook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if eventType := c.Query("event_type"); eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var deliveries []model.WebhookDelivery
+	if err := query.Order("id DESC").Offset(offset).Limit(pageSize).Find(&deliveries).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":      deliveries,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetWebhookDelivery 获取投递详情
+func (h *WebhookHandler) GetWebhookDelivery(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var delivery model.WebhookDelivery
+	if err := h.db.Where("webhook_id = ?", webhook.ID).First(&delivery, c.Param("delivery_id")).Error; err != nil {
+		utils.Error(c, 404, "投递记录不存在")
+		return
+	}
+
+	utils.Success(c, delivery)
+}
+
+// RedeliverWebhook 重新投递（使用原始请求体和事件ID创建新的投递记录并立即发送）
+func (h *WebhookHandler) RedeliverWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var original model.WebhookDelivery
+	if err := h.db.Where("webhook_id = ?", webhook.ID).First(&original, c.Param("delivery_id")).Error; err != nil {
+		utils.Error(c, 404, "投递记录不存在")
+		return
+	}
+
+	delivery := model.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   original.EventID,
+		EventType: original.EventType,
+		Payload:   original.Payload,
+		Redeliver: true,
+		Status:    utils.WebhookDeliveryDelivering,
+	}
+	if err := h.db.Create(&delivery).Error; err != nil {
+		utils.Error(c, utils.CodeError, "重新投递失败")
+		return
+	}
+
+	if err := utils.DeliverWebhook(h.db, &delivery); err != nil {
+		utils.Error(c, utils.CodeError, "重新投递失败")
+		return
+	}
+
+	utils.Success(c, delivery)
+}
+
+// PingWebhook 发送测试事件
+func (h *WebhookHandler) PingWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	payload := utils.WebhookPayload{
+		ID:         uuid.New().String(),
+		Event:      "ping",
+		CreatedAt:  time.Now(),
+		ProjectID:  webhook.ProjectID,
+		ObjectType: "webhook",
+		ObjectID:   webhook.ID,
+		Action:     "ping",
+	}
+	body, _ := json.Marshal(payload)
+
+	delivery := model.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   payload.ID,
+		EventType: "ping",
+		Payload:   string(body),
+		Status:    utils.WebhookDeliveryDelivering,
+	}
+	if err := h.db.Create(&delivery).Error; err != nil {
+		utils.Error(c, utils.CodeError, "发送失败")
+		return
+	}
+
+	if err := utils.DeliverWebhook(h.db, &delivery); err != nil {
+		utils.Error(c, utils.CodeError, "发送失败")
+		return
+	}
+	// 测试事件不进入重试队列
+	if delivery.Status == utils.WebhookDeliveryPending {
+		delivery.Status = utils.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		h.db.Save(&delivery)
+	}
+
+	utils.Success(c, delivery)
+}
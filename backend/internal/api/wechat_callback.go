@@ -7,6 +7,7 @@ import (
 
 	"prjflow/internal/config"
 	"prjflow/internal/model"
+	"prjflow/internal/utils"
 	"prjflow/internal/websocket"
 	"prjflow/pkg/wechat"
 
@@ -24,7 +25,8 @@ type WeChatCallbackContext struct {
 	DB           *gorm.DB
 	AccessToken  *wechat.AccessTokenResponse
 	UserInfo     *wechat.UserInfoResponse
-	Context      *gin.Context // Gin上下文，用于获取IP和请求路径等信息
+	Identity     *utils.ExternalIdentity // 由微信用户信息转换的外部身份
+	Context      *gin.Context            // Gin上下文，用于获取IP和请求路径等信息
 }
 
 // WeChatCallbackHandler 微信回调业务处理接口
@@ -196,6 +198,7 @@ func ProcessWeChatCallback(
 		return ctx, nil, &CallbackError{Message: "获取用户信息失败", Err: err}
 	}
 	ctx.UserInfo = userInfo
+	ctx.Identity = utils.WeChatIdentity(userInfo)
 
 	// 9. 处理业务逻辑
 	result, err := handler.Process(ctx)
@@ -0,0 +1,383 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+type WorkflowHandler struct {
+	db *gorm.DB
+}
+
+func NewWorkflowHandler(db *gorm.DB) *WorkflowHandler {
+	return &WorkflowHandler{db: db}
+}
+
+// workflowRequest 创建/更新工作流请求
+type workflowRequest struct {
+	Name         string `json:"name" binding:"required"`
+	ObjectType   string `json:"object_type" binding:"required"`
+	ProjectID    *uint  `json:"project_id"`
+	InitialState string `json:"initial_state"`
+	Description  string `json:"description"`
+	Status       *int   `json:"status"`
+	States       []struct {
+		Code     string `json:"code" binding:"required"`
+		Name     string `json:"name"`
+		Category string `json:"category"`
+		Sort     int    `json:"sort"`
+	} `json:"states" binding:"required"`
+	Transitions []struct {
+		FromState      string   `json:"from_state" binding:"required"`
+		ToState        string   `json:"to_state" binding:"required"`
+		Name           string   `json:"name"`
+		RequiredFields []string `json:"required_fields"`
+		AllowedRoles   []string `json:"allowed_roles"`
+	} `json:"transitions"`
+}
+
+// buildWorkflow 校验请求并构建状态和流转列表
+func (req *workflowRequest) buildWorkflow() ([]model.WorkflowState, []model.WorkflowTransition, error) {
+	if !utils.IsValidWorkflowObjectType(req.ObjectType) {
+		return nil, nil, fmt.Errorf("对象类型无效，有效值：bug, task, requirement")
+	}
+	if len(req.States) == 0 {
+		return nil, nil, fmt.Errorf("工作流至少需要一个状态")
+	}
+
+	stateSet := make(map[string]bool)
+	states := make([]model.WorkflowState, 0, len(req.States))
+	for i, s := range req.States {
+		if s.Code == "*" {
+			return nil, nil, fmt.Errorf("状态代码不能为 *")
+		}
+		if stateSet[s.Code] {
+			return nil, nil, fmt.Errorf("状态代码重复：%s", s.Code)
+		}
+		stateSet[s.Code] = true
+		category := s.Category
+		if category == "" {
+			category = "open"
+		}
+		if category != "open" && category != "doing" && category != "done" && category != "cancelled" {
+			return nil, nil, fmt.Errorf("状态分类无效：%s，有效值：open, doing, done, cancelled", category)
+		}
+		sort := s.Sort
+		if sort == 0 {
+			sort = i
+		}
+		states = append(states, model.WorkflowState{
+			Code:     s.Code,
+			Name:     s.Name,
+			Category: category,
+			Sort:     sort,
+		})
+	}
+
+	if req.InitialState == "" {
+		req.InitialState = req.States[0].Code
+	}
+	if !stateSet[req.InitialState] {
+		return nil, nil, fmt.Errorf("初始状态不在状态列表中：%s", req.InitialState)
+	}
+
+	transitions := make([]model.WorkflowTransition, 0, len(req.Transitions))
+	for _, t := range req.Transitions {
+		if t.FromState != "*" && !stateSet[t.FromState] {
+			return nil, nil, fmt.Errorf("流转源状态不在状态列表中：%s", t.FromState)
+		}
+		if !stateSet[t.ToState] {
+			return nil, nil, fmt.Errorf("流转目标状态不在状态列表中：%s", t.ToState)
+		}
+		transitions = append(transitions, model.WorkflowTransition{
+			FromState:      t.FromState,
+			ToState:        t.ToState,
+			Name:           t.Name,
+			RequiredFields: model.StringArray(t.RequiredFields),
+			AllowedRoles:   model.StringArray(t.AllowedRoles),
+		})
+	}
+
+	return states, transitions, nil
+}
+
+// requireWorkflowManageAccess 检查是否可以管理工作流，失败时已写入响应：
+// 全局工作流仅管理员；项目工作流需要能访问项目，且项目角色拥有 project:manage 权限（默认只有负责人）
+func (h *WorkflowHandler) requireWorkflowManageAccess(c *gin.Context, projectID *uint) bool {
+	if projectID == nil {
+		if !utils.IsAdmin(c) {
+			utils.Error(c, 403, "没有权限管理该工作流")
+			return false
+		}
+		return true
+	}
+	if !utils.CheckProjectAccess(h.db, c, *projectID) {
+		utils.Error(c, 403, "没有权限管理该工作流")
+		return false
+	}
+	return utils.RequireProjectPermission(h.db, c, *projectID, "project:manage")
+}
+
+// GetWorkflows 获取工作流列表
+func (h *WorkflowHandler) GetWorkflows(c *gin.Context) {
+	query := h.db.Model(&model.Workflow{}).Preload("States", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort ASC, id ASC")
+	}).Preload("Transitions")
+
+	if objectType := c.Query("object_type"); objectType != "" {
+		query = query.Where("object_type = ?", objectType)
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		id, err := strconv.ParseUint(projectID, 10, 32)
+		if err != nil {
+			utils.Error(c, 400, "项目ID无效")
+			return
+		}
+		if !utils.CheckProjectAccess(h.db, c, uint(id)) {
+			utils.Error(c, 403, "没有权限访问该项目")
+			return
+		}
+		query = query.Where("project_id = ?", id)
+	} else if c.Query("global") == "true" {
+		query = query.Where("project_id IS NULL")
+	} else if !utils.IsAdmin(c) {
+		// 普通用户只能看到全局工作流和自己参与项目的工作流
+		projectIDs := utils.GetUserProjectIDs(h.db, utils.GetUserID(c))
+		query = query.Where("project_id IS NULL OR project_id IN ?", append(projectIDs, 0))
+	}
+
+	var workflows []model.Workflow
+	if err := query.Order("object_type ASC, id ASC").Find(&workflows).Error; err != nil {
+		utils.Error(c, utils.CodeError, "查询失败")
+		return
+	}
+
+	utils.Success(c, workflows)
+}
+
+// GetWorkflow 获取工作流详情
+func (h *WorkflowHandler) GetWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	var workflow model.Workflow
+	if err := h.db.Preload("States", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort ASC, id ASC")
+	}).Preload("Transitions").First(&workflow, id).Error; err != nil {
+		utils.Error(c, 404, "工作流不存在")
+		return
+	}
+
+	if workflow.ProjectID != nil && !utils.CheckProjectAccess(h.db, c, *workflow.ProjectID) {
+		utils.Error(c, 403, "没有权限访问该工作流")
+		return
+	}
+
+	utils.Success(c, workflow)
+}
+
+// GetEffectiveWorkflow 获取对象类型在项目中生效的工作流
+// 如果提供了 from 参数，同时返回当前用户从该状态可以执行的流转
+func (h *WorkflowHandler) GetEffectiveWorkflow(c *gin.Context) {
+	objectType := c.Query("object_type")
+	if !utils.IsValidWorkflowObjectType(objectType) {
+		utils.Error(c, 400, "对象类型无效，有效值：bug, task, requirement")
+		return
+	}
+
+	var projectID uint
+	if projectIDStr := c.Query("project_id"); projectIDStr != "" {
+		id, err := strconv.ParseUint(projectIDStr, 10, 32)
+		if err != nil {
+			utils.Error(c, 400, "项目ID无效")
+			return
+		}
+		projectID = uint(id)
+		if !utils.CheckProjectAccess(h.db, c, projectID) {
+			utils.Error(c, 403, "没有权限访问该项目")
+			return
+		}
+	}
+
+	workflow := utils.GetWorkflow(h.db, objectType, projectID)
+	if workflow == nil {
+		utils.Error(c, 404, "工作流不存在")
+		return
+	}
+
+	result := gin.H{"workflow": workflow}
+	if from := c.Query("from"); from != "" {
+		result["transitions"] = utils.GetAvailableTransitions(h.db, c, workflow, projectID, from)
+	}
+
+	utils.Success(c, result)
+}
+
+// CreateWorkflow 创建工作流
+// 每个项目（或全局）每种对象类型只保留一个工作流
+func (h *WorkflowHandler) CreateWorkflow(c *gin.Context) {
+	var req workflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+
+	if req.ProjectID != nil {
+		var project model.Project
+		if err := h.db.First(&project, *req.ProjectID).Error; err != nil {
+			utils.Error(c, 404, "项目不存在")
+			return
+		}
+	}
+
+	if !h.requireWorkflowManageAccess(c, req.ProjectID) {
+		return
+	}
+
+	states, transitions, err := req.buildWorkflow()
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	// 检查是否已存在
+	existsQuery := h.db.Model(&model.Workflow{}).Where("object_type = ?", req.ObjectType)
+	if req.ProjectID != nil {
+		existsQuery = existsQuery.Where("project_id = ?", *req.ProjectID)
+	} else {
+		existsQuery = existsQuery.Where("project_id IS NULL")
+	}
+	var count int64
+	existsQuery.Count(&count)
+	if count > 0 {
+		utils.Error(c, 400, "该对象类型的工作流已存在，请直接编辑")
+		return
+	}
+
+	workflow := model.Workflow{
+		Name:         req.Name,
+		ObjectType:   req.ObjectType,
+		ProjectID:    req.ProjectID,
+		InitialState: req.InitialState,
+		Description:  req.Description,
+		Status:       1,
+		States:       states,
+		Transitions:  transitions,
+	}
+	if req.Status != nil {
+		workflow.Status = *req.Status
+	}
+
+	if err := h.db.Create(&workflow).Error; err != nil {
+		utils.Error(c, utils.CodeError, "创建失败")
+		return
+	}
+
+	utils.Success(c, workflow)
+}
+
+// UpdateWorkflow 更新工作流（整体替换状态和流转）
+func (h *WorkflowHandler) UpdateWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	var workflow model.Workflow
+	if err := h.db.First(&workflow, id).Error; err != nil {
+		utils.Error(c, 404, "工作流不存在")
+		return
+	}
+
+	if !h.requireWorkflowManageAccess(c, workflow.ProjectID) {
+		return
+	}
+
+	var req workflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	// 对象类型和所属项目不允许修改
+	req.ObjectType = workflow.ObjectType
+
+	states, transitions, err := req.buildWorkflow()
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workflow_id = ?", workflow.ID).Delete(&model.WorkflowState{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workflow_id = ?", workflow.ID).Delete(&model.WorkflowTransition{}).Error; err != nil {
+			return err
+		}
+
+		workflow.Name = req.Name
+		workflow.InitialState = req.InitialState
+		workflow.Description = req.Description
+		if req.Status != nil {
+			workflow.Status = *req.Status
+		}
+		if err := tx.Omit("States", "Transitions").Save(&workflow).Error; err != nil {
+			return err
+		}
+
+		for i := range states {
+			states[i].WorkflowID = workflow.ID
+		}
+		if err := tx.Create(&states).Error; err != nil {
+			return err
+		}
+		if len(transitions) > 0 {
+			for i := range transitions {
+				transitions[i].WorkflowID = workflow.ID
+			}
+			if err := tx.Create(&transitions).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "更新失败")
+		return
+	}
+
+	h.db.Preload("States", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort ASC, id ASC")
+	}).Preload("Transitions").First(&workflow, workflow.ID)
+
+	utils.Success(c, workflow)
+}
+
+// DeleteWorkflow 删除工作流（删除后回退到全局工作流或内置默认工作流）
+func (h *WorkflowHandler) DeleteWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	var workflow model.Workflow
+	if err := h.db.First(&workflow, id).Error; err != nil {
+		utils.Error(c, 404, "工作流不存在")
+		return
+	}
+
+	if !h.requireWorkflowManageAccess(c, workflow.ProjectID) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workflow_id = ?", workflow.ID).Delete(&model.WorkflowState{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workflow_id = ?", workflow.ID).Delete(&model.WorkflowTransition{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&workflow).Error
+	})
+	if err != nil {
+		utils.Error(c, utils.CodeError, "删除失败")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "删除成功"})
+}
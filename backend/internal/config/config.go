@@ -8,12 +8,20 @@ import (
 )
 
 type Config struct {
-	Server        ServerConfig   `mapstructure:"server"`
-	Database      DatabaseConfig `mapstructure:"database"`
-	AuditDatabase DatabaseConfig `mapstructure:"audit_database"` // 审计日志数据库（可选，不配置则使用主数据库）
-	JWT           JWTConfig      `mapstructure:"jwt"`
-	WeChat        WeChatConfig   `mapstructure:"wechat"`
-	Upload        UploadConfig   `mapstructure:"upload"`
+	Server        ServerConfig        `mapstructure:"server"`
+	Database      DatabaseConfig      `mapstructure:"database"`
+	AuditDatabase DatabaseConfig      `mapstructure:"audit_database"` // 审计日志数据库（可选，不配置则使用主数据库）
+	JWT           JWTConfig           `mapstructure:"jwt"`
+	WeChat        WeChatConfig        `mapstructure:"wechat"`
+	Upload        UploadConfig        `mapstructure:"upload"`
+	Email         EmailConfig         `mapstructure:"email"`
+	Webhook       WebhookConfig       `mapstructure:"webhook"`
+	LoginSecurity LoginSecurityConfig `mapstructure:"login_security"`
+	LDAP          LDAPConfig          `mapstructure:"ldap"`
+	OIDC          OIDCConfig          `mapstructure:"oidc"`
+	PasswordReset PasswordResetConfig `mapstructure:"password_reset"`
+	WorkCalendar  WorkCalendarConfig  `mapstructure:"work_calendar"`
+	Requirement   RequirementConfig   `mapstructure:"requirement"`
 }
 
 type ServerConfig struct {
@@ -60,6 +68,126 @@ type UploadConfig struct {
 	AllowedTypes []string `mapstructure:"allowed_types"` // 允许的文件类型（MIME类型），空数组表示允许所有类型
 }
 
+type EmailConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`  // 是否启用邮件通知
+	Host     string `mapstructure:"host"`     // SMTP服务器地址
+	Port     int    `mapstructure:"port"`     // SMTP端口，默认 25
+	Username string `mapstructure:"username"` // SMTP用户名（为空则不认证）
+	Password string `mapstructure:"password"` // SMTP密码
+	// Encryption: "none"（明文，服务器支持时自动STARTTLS）、"starttls"（强制STARTTLS）或 "ssl"（隐式TLS，通常为465端口）
+	Encryption string `mapstructure:"encryption"`
+	From       string `mapstructure:"from"`      // 发件人地址
+	FromName   string `mapstructure:"from_name"` // 发件人名称
+	// SiteURL: 系统访问地址（如：https://pm.example.com），用于生成邮件中的链接
+	SiteURL     string `mapstructure:"site_url"`
+	Timeout     int    `mapstructure:"timeout"`      // 发送超时（秒），默认 15
+	MaxAttempts int    `mapstructure:"max_attempts"` // 最大发送次数（包括首次发送），默认 5
+	DigestTime  string `mapstructure:"digest_time"`  // 每日摘要发送时间（HH:MM），默认 08:30
+}
+
+type WebhookConfig struct {
+	MaxAttempts      int `mapstructure:"max_attempts"`       // 最大投递次数（包括首次投递），默认 8
+	Timeout          int `mapstructure:"timeout"`            // 请求超时（秒），默认 10
+	RetryBaseSeconds int `mapstructure:"retry_base_seconds"` // 重试基础间隔（秒），按指数退避，默认 30
+	// 允许推送到本机、内网和链路本地地址，默认 false（防止SSRF），仅在受信任的内网部署中开启
+	AllowPrivateTargets bool `mapstructure:"allow_private_targets"`
+}
+
+// LoginSecurityConfig 登录防暴力破解策略
+type LoginSecurityConfig struct {
+	MaxFailures     int `mapstructure:"max_failures"`      // 时间窗口内允许的最大失败次数，超过后锁定账号，默认 5
+	FailureWindow   int `mapstructure:"failure_window"`    // 失败次数统计窗口（分钟），默认 15
+	LockDuration    int `mapstructure:"lock_duration"`     // 账号锁定时长（分钟），默认 30
+	IPMaxFailures   int `mapstructure:"ip_max_failures"`   // 同一IP在时间窗口内允许的最大失败次数，默认 20
+	IPLockDuration  int `mapstructure:"ip_lock_duration"`  // IP限制时长（分钟），默认 15
+	DelayBaseMillis int `mapstructure:"delay_base_millis"` // 递增延迟基础时长（毫秒），每次失败翻倍，默认 1000
+	MaxDelaySeconds int `mapstructure:"max_delay_seconds"` // 递增延迟上限（秒），默认 30
+}
+
+// LDAPConfig LDAP / Active Directory 登录配置
+type LDAPConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	URL     string `mapstructure:"url"` // 服务器地址，如 ldap://ldap.example.com:389 或 ldaps://ad.example.com:636
+	// StartTLS 使用 ldap:// 连接后升级为TLS
+	StartTLS           bool   `mapstructure:"start_tls"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"` // 跳过证书校验（仅用于测试环境）
+	BindDN             string `mapstructure:"bind_dn"`              // 查询用户使用的服务账号DN，为空则匿名查询
+	BindPassword       string `mapstructure:"bind_password"`
+	BaseDN             string `mapstructure:"base_dn"` // 用户查询的根DN，如 ou=people,dc=example,dc=com
+	// UserFilter 登录时查询用户的过滤器，%s 替换为转义后的用户名
+	// OpenLDAP 默认 (uid=%s)，Active Directory 可使用 (&(objectClass=user)(sAMAccountName=%s))
+	UserFilter string `mapstructure:"user_filter"`
+	// SyncFilter 定时同步时查询全部用户的过滤器，默认将 UserFilter 中的 %s 替换为 *
+	SyncFilter          string `mapstructure:"sync_filter"`
+	UsernameAttribute   string `mapstructure:"username_attribute"`   // 用户名属性，默认 uid（AD 为 sAMAccountName）
+	NicknameAttribute   string `mapstructure:"nickname_attribute"`   // 昵称属性，默认 displayName，为空时使用 cn
+	EmailAttribute      string `mapstructure:"email_attribute"`      // 邮箱属性，默认 mail
+	PhoneAttribute      string `mapstructure:"phone_attribute"`      // 手机号属性，默认 telephoneNumber
+	DepartmentAttribute string `mapstructure:"department_attribute"` // 部门属性（按名称匹配部门，不存在时自动创建），为空不同步部门
+	GroupAttribute      string `mapstructure:"group_attribute"`      // 用户所属组属性，默认 memberOf
+	// GroupRoles LDAP组到角色代码的映射，组可以写完整DN或CN
+	GroupRoles   []LDAPGroupRole `mapstructure:"group_roles"`
+	DefaultRoles []string        `mapstructure:"default_roles"` // 所有LDAP用户都拥有的角色代码
+	SyncInterval int             `mapstructure:"sync_interval"` // 定时同步间隔（分钟），0 表示不同步，默认 60
+	Timeout      int             `mapstructure:"timeout"`       // 连接超时（秒），默认 10
+	// SyncMaxDisable 单次同步最多禁用的用户数，超过时中止同步（防止目录配置错误时误禁用全部用户），默认 10
+	SyncMaxDisable int `mapstructure:"sync_max_disable"`
+}
+
+// LDAPGroupRole LDAP组与角色的映射
+type LDAPGroupRole struct {
+	Group string `mapstructure:"group"` // 组DN或CN，不区分大小写
+	Role  string `mapstructure:"role"`  // 角色代码
+}
+
+// OIDCConfig OpenID Connect 单点登录配置（如 Keycloak）
+type OIDCConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Name         string `mapstructure:"name"`   // 登录页显示的名称，默认 "企业账号"
+	Issuer       string `mapstructure:"issuer"` // 签发者地址，如 https://sso.example.com/realms/prjflow
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"` // 公开客户端可为空（仅使用 PKCE）
+	// RedirectURL 授权回调地址（前端页面），前端收到 code 和 state 后调用 /api/auth/idp/oidc/callback
+	RedirectURL string   `mapstructure:"redirect_url"`
+	Scopes      []string `mapstructure:"scopes"` // 默认 openid profile email
+	// UsernameClaim 自动创建用户时使用的用户名声明，默认 preferred_username
+	UsernameClaim string `mapstructure:"username_claim"`
+	// AutoCreate 未关联的账号首次登录时自动创建用户；关闭时需要用户先在个人设置中绑定
+	AutoCreate   bool     `mapstructure:"auto_create"`
+	DefaultRoles []string `mapstructure:"default_roles"` // 自动创建的用户拥有的角色代码
+}
+
+// PasswordResetConfig 自助重置密码配置
+type PasswordResetConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	TokenTTL        int  `mapstructure:"token_ttl"`        // 重置令牌有效期（分钟），默认 30
+	RequestInterval int  `mapstructure:"request_interval"` // 同一账号两次申请的最小间隔（秒），默认 60
+	// ResetURL 前端重置密码页面地址，令牌作为 token 参数附加在后面，默认 {email.site_url}/reset-password
+	ResetURL string `mapstructure:"reset_url"`
+	// WeChatTemplateID 公众号模板消息ID，为空时不通过微信发送（仅 official_account 可用）
+	// 模板使用 first、keyword1（账号）、keyword2（有效期至）、remark 字段
+	WeChatTemplateID string `mapstructure:"wechat_template_id"`
+}
+
+// WorkCalendarConfig 工作日历配置（燃尽图理想线、排期等按工作日计算）
+type WorkCalendarConfig struct {
+	WeekendDays []int    `mapstructure:"weekend_days"` // 每周休息日（0=周日，6=周六），默认 [0, 6]
+	Holidays    []string `mapstructure:"holidays"`     // 法定节假日（YYYY-MM-DD）
+	Workdays    []string `mapstructure:"workdays"`     // 调休上班日（YYYY-MM-DD），优先于休息日和节假日
+}
+
+// RequirementConfig 需求配置
+type RequirementConfig struct {
+	// Levels 需求层级，从上到下排列（如 史诗 -> 特性 -> 用户故事），子需求的层级必须低于父需求
+	Levels []RequirementLevelConfig `mapstructure:"levels"`
+}
+
+// RequirementLevelConfig 需求层级
+type RequirementLevelConfig struct {
+	Code string `mapstructure:"code" json:"code"`
+	Name string `mapstructure:"name" json:"name"`
+}
+
 var AppConfig *Config
 
 func LoadConfig(configPath string) error {
@@ -119,4 +247,53 @@ func setDefaults() {
 	viper.SetDefault("upload.storage_path", "uploads")      // 默认存储路径
 	viper.SetDefault("upload.max_file_size", 100*1024*1024) // 默认 100MB (104857600 字节)
 	viper.SetDefault("upload.allowed_types", []string{})    // 空数组表示允许所有类型
+
+	// 邮件通知配置
+	viper.SetDefault("email.enabled", false)
+	viper.SetDefault("email.port", 25)
+	viper.SetDefault("email.encryption", "none")
+	viper.SetDefault("email.from_name", "PrjFlow")
+	viper.SetDefault("email.timeout", 15)
+	viper.SetDefault("email.max_attempts", 5)
+	viper.SetDefault("email.digest_time", "08:30")
+
+	// Webhook配置
+	viper.SetDefault("webhook.max_attempts", 8)
+	viper.SetDefault("webhook.timeout", 10)
+	viper.SetDefault("webhook.retry_base_seconds", 30)
+	viper.SetDefault("webhook.allow_private_targets", false)
+
+	// 登录防暴力破解配置
+	viper.SetDefault("login_security.max_failures", 5)
+	viper.SetDefault("login_security.failure_window", 15)
+	viper.SetDefault("login_security.lock_duration", 30)
+	viper.SetDefault("login_security.ip_max_failures", 20)
+	viper.SetDefault("login_security.ip_lock_duration", 15)
+	viper.SetDefault("login_security.delay_base_millis", 1000)
+	viper.SetDefault("login_security.max_delay_seconds", 30)
+
+	// LDAP配置
+	viper.SetDefault("ldap.enabled", false)
+	viper.SetDefault("ldap.user_filter", "(uid=%s)")
+	viper.SetDefault("ldap.username_attribute", "uid")
+	viper.SetDefault("ldap.nickname_attribute", "displayName")
+	viper.SetDefault("ldap.email_attribute", "mail")
+	viper.SetDefault("ldap.phone_attribute", "telephoneNumber")
+	viper.SetDefault("ldap.group_attribute", "memberOf")
+	viper.SetDefault("ldap.sync_interval", 60)
+	viper.SetDefault("ldap.sync_max_disable", 10)
+	viper.SetDefault("ldap.timeout", 10)
+
+	// OIDC配置
+	viper.SetDefault("oidc.enabled", false)
+	viper.SetDefault("oidc.name", "企业账号")
+	viper.SetDefault("oidc.username_claim", "preferred_username")
+
+	// 自助重置密码配置
+	viper.SetDefault("password_reset.enabled", true)
+	viper.SetDefault("password_reset.token_ttl", 30)
+	viper.SetDefault("password_reset.request_interval", 60)
+
+	// 工作日历配置
+	viper.SetDefault("work_calendar.weekend_days", []int{0, 6})
 }
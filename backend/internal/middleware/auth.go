@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
 	"prjflow/internal/model"
@@ -29,7 +30,23 @@ func Auth() gin.HandlerFunc {
 		}
 
 		token := parts[1]
-		claims, err := auth.ParseToken(token)
+
+		// 个人访问令牌（用于脚本和CI）需要从数据库校验
+		if utils.IsPersonalAccessToken(token) {
+			db, _ := c.Get("db")
+			dbConn, ok := db.(*gorm.DB)
+			if !ok {
+				utils.Error(c, 401, "无效的Token")
+				c.Abort()
+				return
+			}
+			if authenticateAccessToken(c, dbConn, token) {
+				c.Next()
+			}
+			return
+		}
+
+		claims, err := auth.ParseAccessToken(token)
 		if err != nil {
 			utils.Error(c, 401, "无效的Token")
 			c.Abort()
@@ -40,10 +57,16 @@ func Auth() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("roles", claims.Roles)
+		c.Set("session_id", claims.SessionID)
 
 		// 从上下文获取数据库连接（如果存在）
 		if db, exists := c.Get("db"); exists {
 			if dbConn, ok := db.(*gorm.DB); ok {
+				// 校验会话是否已被吊销、用户是否已被禁用
+				if !checkTokenSession(c, dbConn, claims) {
+					return
+				}
+
 				// 加载用户权限到上下文（提高性能）
 				// 如果用户有角色，加载角色权限；如果没有角色，设置空权限列表
 				if len(claims.Roles) > 0 {
@@ -83,17 +106,32 @@ func AuthWithDB(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		token := parts[1]
-		claims, err := auth.ParseToken(token)
+
+		// 个人访问令牌（用于脚本和CI）
+		if utils.IsPersonalAccessToken(token) {
+			if authenticateAccessToken(c, db, token) {
+				c.Next()
+			}
+			return
+		}
+
+		claims, err := auth.ParseAccessToken(token)
 		if err != nil {
 			utils.Error(c, 401, "无效的Token")
 			c.Abort()
 			return
 		}
 
+		// 校验会话是否已被吊销、用户是否已被禁用
+		if !checkTokenSession(c, db, claims) {
+			return
+		}
+
 		// 将用户信息存储到上下文
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("roles", claims.Roles)
+		c.Set("session_id", claims.SessionID)
 
 		// 加载用户权限到上下文
 		// 如果用户有角色，加载角色权限；如果没有角色，设置空权限列表
@@ -143,17 +181,32 @@ func AuthOptional(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		token := parts[1]
-		claims, err := auth.ParseToken(token)
+
+		// 个人访问令牌（用于脚本和CI）
+		if utils.IsPersonalAccessToken(token) {
+			if authenticateAccessToken(c, db, token) {
+				c.Next()
+			}
+			return
+		}
+
+		claims, err := auth.ParseAccessToken(token)
 		if err != nil {
 			utils.Error(c, 401, "无效的Token")
 			c.Abort()
 			return
 		}
 
+		// 校验会话是否已被吊销、用户是否已被禁用
+		if !checkTokenSession(c, db, claims) {
+			return
+		}
+
 		// 将用户信息存储到上下文
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("roles", claims.Roles)
+		c.Set("session_id", claims.SessionID)
 
 		// 加载用户权限到上下文
 		if len(claims.Roles) > 0 {
@@ -169,4 +222,47 @@ func AuthOptional(db *gorm.DB) gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// checkTokenSession 校验Token对应的会话，失败时返回401并终止请求
+func checkTokenSession(c *gin.Context, db *gorm.DB, claims *auth.Claims) bool {
+	if err := utils.ValidateTokenSession(db, claims); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrSessionRevoked), errors.Is(err, utils.ErrUserDisabled):
+			utils.Error(c, 401, err.Error())
+		default:
+			utils.Error(c, utils.CodeError, "校验会话失败")
+		}
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// authenticateAccessToken 使用个人访问令牌认证，失败时返回401并终止请求
+// 令牌只拥有其权限范围内（且用户当前仍拥有）的权限，即使所属用户是管理员
+func authenticateAccessToken(c *gin.Context, db *gorm.DB, token string) bool {
+	identity, err := utils.AuthenticateAccessToken(db, token, c)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrAccessTokenInvalid), errors.Is(err, utils.ErrAccessTokenExpired),
+			errors.Is(err, utils.ErrAccessTokenRevoked), errors.Is(err, utils.ErrUserDisabled):
+			utils.Error(c, 401, err.Error())
+		default:
+			utils.Error(c, utils.CodeError, "校验访问令牌失败")
+		}
+		c.Abort()
+		return false
+	}
+
+	c.Set("user_id", identity.User.ID)
+	c.Set("username", identity.User.Username)
+	c.Set("roles", identity.Roles)
+	c.Set("permissions", identity.Permissions)
+	c.Set("token_scopes", identity.Permissions)
+	c.Set("access_token_id", identity.Token.ID)
+
+	// 记录令牌使用
+	utils.RecordAuditLog(db, identity.User.ID, identity.User.Username, "token_access", "access_token", identity.Token.ID, c, true, "", identity.Token.Name)
+	return true
+}
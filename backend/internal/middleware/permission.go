@@ -12,6 +12,13 @@ import (
 // RequirePermission 要求特定权限
 func RequirePermission(db *gorm.DB, permCode string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 使用个人访问令牌时，权限必须在令牌的权限范围内（管理员也不例外）
+		if !utils.AccessTokenAllows(c, permCode) {
+			utils.Error(c, 403, "访问令牌没有该权限")
+			c.Abort()
+			return
+		}
+
 		// 检查是否是管理员角色（管理员自动拥有所有权限）
 		roles, exists := c.Get("roles")
 		if exists {
@@ -129,6 +136,13 @@ func RequirePermissionOptional(db *gorm.DB, permCode string) gin.HandlerFunc {
 			return
 		}
 
+		// 使用个人访问令牌时，权限必须在令牌的权限范围内
+		if !utils.AccessTokenAllows(c, permCode) {
+			utils.Error(c, 403, "访问令牌没有该权限")
+			c.Abort()
+			return
+		}
+
 		// 如果系统已初始化，执行权限检查
 		// 优先从上下文获取权限列表（如果已加载）
 		if perms, exists := c.Get("permissions"); exists {
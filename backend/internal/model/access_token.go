@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// PersonalAccessToken 个人访问令牌（用于脚本、CI 等自动化调用接口）
+// 令牌明文只在创建时返回一次，数据库只保存哈希；令牌的权限范围（Scopes）为权限代码列表，
+// 实际生效的权限为范围与所属用户当前权限的交集
+type PersonalAccessToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID uint  `gorm:"index;not null" json:"user_id"` // 所属用户
+	User   *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+
+	Name        string `gorm:"size:100;not null" json:"name"`         // 令牌名称
+	TokenPrefix string `gorm:"size:20" json:"token_prefix"`           // 令牌前缀（用于识别令牌，不足以使用）
+	TokenHash   string `gorm:"size:64;uniqueIndex;not null" json:"-"` // 令牌的SHA-256哈希
+	Scopes      string `gorm:"type:text" json:"-"`                    // 权限范围（JSON数组，权限代码，如 bug:create）
+	CreatedBy   uint   `gorm:"index" json:"created_by"`               // 创建人（管理员为服务账号创建时与所属用户不同）
+
+	ExpiresAt  *time.Time `gorm:"index" json:"expires_at"` // 过期时间
+	LastUsedAt *time.Time `json:"last_used_at"`            // 最后使用时间
+	LastUsedIP string     `gorm:"size:50" json:"last_used_ip"`
+	RevokedAt  *time.Time `gorm:"index" json:"revoked_at"` // 吊销时间（为空表示有效）
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// UserIdentity 外部身份与本地用户的关联（OIDC等身份提供方）
+// 微信沿用 users.wechat_open_id 字段，不写入此表
+type UserIdentity struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID   uint   `gorm:"index;not null" json:"user_id"`
+	Provider string `gorm:"size:50;not null;uniqueIndex:idx_identity_provider_subject" json:"provider"` // 身份提供方，如 oidc
+	Subject  string `gorm:"size:255;not null;uniqueIndex:idx_identity_provider_subject" json:"subject"` // 身份提供方内的唯一标识（OIDC sub）
+	Email    string `gorm:"size:100" json:"email"`
+	Name     string `gorm:"size:100" json:"name"`
+
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// IdentityAuthRequest 发起中的外部授权请求，回调时按 state 取出并删除（一次性）
+type IdentityAuthRequest struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	State        string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Provider     string    `gorm:"size:50;not null" json:"provider"`
+	Purpose      string    `gorm:"size:20;not null" json:"purpose"` // login 或 bind
+	UserID       uint      `json:"user_id"`                         // 绑定场景发起绑定的用户
+	CodeVerifier string    `gorm:"size:128" json:"-"`               // PKCE 验证码
+	Nonce        string    `gorm:"size:64" json:"-"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification 站内通知表
+type Notification struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	UserID uint `gorm:"index;not null" json:"user_id"` // 接收人ID
+
+	Type    string `gorm:"size:50;index;not null" json:"type"` // 通知类型，如 bug.assigned, report.approval_requested
+	Title   string `gorm:"size:200;not null" json:"title"`     // 标题
+	Content string `gorm:"type:text" json:"content"`           // 内容
+
+	ObjectType string `gorm:"size:50;index" json:"object_type"` // 关联对象类型：bug, task, requirement, daily_report, weekly_report
+	ObjectID   uint   `gorm:"index" json:"object_id"`           // 关联对象ID
+	ProjectID  uint   `gorm:"index" json:"project_id"`          // 所属项目ID（无项目时为0）
+
+	ActorID *uint `gorm:"index" json:"actor_id"` // 触发人ID
+	Actor   *User `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+
+	IsRead bool       `gorm:"default:false;index" json:"is_read"` // 是否已读
+	ReadAt *time.Time `json:"read_at"`                            // 阅读时间
+}
+
+// NotificationPreference 用户通知偏好表（未设置时默认接收）
+type NotificationPreference struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID    uint   `gorm:"uniqueIndex:idx_notification_pref;not null" json:"user_id"`
+	EventType string `gorm:"size:50;uniqueIndex:idx_notification_pref;not null" json:"event_type"` // 通知类型
+	Channel   string `gorm:"size:20;uniqueIndex:idx_notification_pref;not null" json:"channel"`    // 通知渠道：in_app, email
+	Enabled   bool   `json:"enabled"`                                                              // 是否接收
+	Mode      string `gorm:"size:20" json:"mode"`                                                  // 发送方式（仅邮件渠道）：immediate(立即), digest(每日摘要)
+}
+
+// EmailMessage 邮件发送记录表（同时作为持久化重试队列和每日摘要暂存）
+type EmailMessage struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID    uint   `gorm:"index" json:"user_id"`            // 收件用户ID
+	To        string `gorm:"size:200;not null" json:"to"`     // 收件地址
+	EventType string `gorm:"size:50;index" json:"event_type"` // 通知类型（摘要邮件为 digest）
+
+	Subject  string `gorm:"size:300" json:"subject"`    // 主题
+	HTMLBody string `gorm:"type:text" json:"html_body"` // HTML正文
+	TextBody string `gorm:"type:text" json:"text_body"` // 纯文本正文
+	Summary  string `gorm:"type:text" json:"summary"`   // 通知内容摘要（用于每日摘要）
+	Link     string `gorm:"size:500" json:"link"`       // 相关对象链接（用于每日摘要）
+
+	Status        string     `gorm:"size:20;index;default:'pending'" json:"status"` // 状态：pending(待发送), sending(发送中), sent(已发送), failed(失败), digest(等待摘要), digested(已汇总)
+	Attempts      int        `gorm:"default:0" json:"attempts"`                     // 已尝试次数
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`                  // 下次尝试时间
+	LastAttemptAt *time.Time `json:"last_attempt_at"`                               // 最后尝试时间
+	SentAt        *time.Time `json:"sent_at"`                                       // 发送成功时间
+	Error         string     `gorm:"type:text" json:"error"`                        // 错误信息
+}
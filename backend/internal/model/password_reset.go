@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// PasswordResetToken 自助重置密码令牌
+// 令牌明文只通过发送渠道（邮件、微信）交给用户，数据库只保存哈希；令牌一次性使用，过期或再次申请后失效
+type PasswordResetToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID uint  `gorm:"index;not null" json:"user_id"` // 申请重置的用户
+	User   *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+
+	TokenHash string `gorm:"size:64;uniqueIndex;not null" json:"-"` // 令牌的SHA-256哈希
+	Channel   string `gorm:"size:20" json:"channel"`                // 发送渠道：email, wechat
+	RequestIP string `gorm:"size:50" json:"request_ip"`             // 申请重置的IP
+
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"` // 过期时间
+	UsedAt    *time.Time `json:"used_at"`                 // 使用时间（为空表示未使用）
+}
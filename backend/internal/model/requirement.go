@@ -21,6 +21,13 @@ type Requirement struct {
 	ProjectID uint    `gorm:"index;not null" json:"project_id"` // 必填关联项目
 	Project   Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
 
+	ParentID *uint  `gorm:"index" json:"parent_id"`               // 父需求ID（为空表示顶层需求）
+	Level    string `gorm:"size:20;default:'story'" json:"level"` // 层级，取值见需求层级配置（默认 epic、feature、story）
+	Progress int    `gorm:"-" json:"progress"`                    // 进度：由关联任务和子需求计算，不存储
+
+	SprintID *uint   `gorm:"index" json:"sprint_id"` // 所属迭代（为空表示在待办池中）
+	Sprint   *Sprint `gorm:"foreignKey:SprintID" json:"sprint,omitempty"`
+
 	CreatorID uint `gorm:"index" json:"creator_id"`
 	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
 
@@ -34,6 +41,42 @@ type Requirement struct {
 	Attachments []Attachment `gorm:"many2many:requirement_attachments;" json:"attachments"`
 }
 
+// RequirementReview 需求评审表（每发起一次评审为一轮）
+type RequirementReview struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	RequirementID uint `gorm:"index;not null" json:"requirement_id"`
+	Round         int  `gorm:"not null" json:"round"` // 评审轮次，从1开始
+
+	Rule       string     `gorm:"size:20;default:'all'" json:"rule"`         // 评审规则：all(全部通过), majority(多数通过)
+	Status     string     `gorm:"size:20;default:'reviewing'" json:"status"` // 评审状态：reviewing(评审中), finished(已完成), cancelled(已取消)
+	Result     string     `gorm:"size:20" json:"result"`                     // 评审结论：pass(通过), reject(拒绝), clarify(有待明确)
+	Comment    string     `gorm:"type:text" json:"comment"`                  // 发起评审时的说明
+	FromStatus string     `gorm:"size:20" json:"from_status"`                // 发起评审前的需求状态，取消评审时恢复
+	FinishedAt *time.Time `json:"finished_at"`
+
+	CreatorID uint `gorm:"index" json:"creator_id"`
+	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
+
+	Votes []RequirementReviewVote `gorm:"foreignKey:ReviewID" json:"votes,omitempty"`
+}
+
+// RequirementReviewVote 需求评审意见表（每个评审人一条）
+type RequirementReviewVote struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ReviewID   uint `gorm:"index;not null" json:"review_id"`
+	ReviewerID uint `gorm:"index;not null" json:"reviewer_id"`
+	Reviewer   User `gorm:"foreignKey:ReviewerID" json:"reviewer,omitempty"`
+
+	Verdict string `gorm:"size:20;default:'pending'" json:"verdict"` // 评审意见：pending(待评审), pass(通过), reject(拒绝), clarify(有待明确)
+	Comment string `gorm:"type:text" json:"comment"`                 // 评审说明
+}
+
 // Bug Bug表
 type Bug struct {
 	ID        uint           `gorm:"primarykey" json:"id"`
@@ -62,6 +105,9 @@ type Bug struct {
 	ModuleID *uint   `gorm:"index" json:"module_id"` // 关联功能模块
 	Module   *Module `gorm:"foreignKey:ModuleID" json:"module,omitempty"`
 
+	SprintID *uint   `gorm:"index" json:"sprint_id"` // 所属迭代（为空表示在待办池中）
+	Sprint   *Sprint `gorm:"foreignKey:SprintID" json:"sprint,omitempty"`
+
 	EstimatedHours *float64 `gorm:"default:0" json:"estimated_hours"` // 预估工时（小时）
 	ActualHours    *float64 `gorm:"default:0" json:"actual_hours"`    // 实际工时（小时），从资源分配自动计算
 
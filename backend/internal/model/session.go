@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+// UserSession 用户登录会话表
+// 每次登录创建一个会话，该会话签发的 Access Token 和 Refresh Token 通过 sid 声明关联到会话，
+// 吊销会话后其所有 Token 立即失效。
+// 会话同时是一个 Refresh Token 家族：每次刷新都会轮换 Refresh Token，只有最新签发的才有效，
+// 已轮换的 Refresh Token 再次出现时视为泄露，整个会话被吊销
+type UserSession struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	SessionID string `gorm:"size:64;uniqueIndex;not null" json:"-"` // 会话ID（写入Token的sid声明，不返回给前端）
+	UserID    uint   `gorm:"index;not null" json:"user_id"`         // 用户ID
+	User      *User  `gorm:"foreignKey:UserID" json:"user,omitempty"`
+
+	LoginMethod string `gorm:"size:20" json:"login_method"` // 登录方式：password, password_2fa（密码+两步验证）, ldap, oidc, wechat, init
+	IPAddress   string `gorm:"size:50" json:"ip_address"`   // 登录IP
+	UserAgent   string `gorm:"size:500" json:"user_agent"`  // 客户端UA
+
+	RefreshTokenID string     `gorm:"size:64;index" json:"-"`         // 当前有效的 Refresh Token ID（jti）
+	RefreshCount   int        `gorm:"default:0" json:"refresh_count"` // 已轮换次数
+	RefreshedAt    *time.Time `json:"refreshed_at"`                   // 最后刷新时间
+
+	LastActiveAt  time.Time  `json:"last_active_at"`                // 最后活跃时间
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`       // 过期时间（随刷新Token延长）
+	RevokedAt     *time.Time `gorm:"index" json:"revoked_at"`       // 吊销时间（为空表示有效）
+	RevokedReason string     `gorm:"size:50" json:"revoked_reason"` // 吊销原因：logout, password_changed, password_reset, user_disabled, remote_logout, force_logout, refresh_token_reused
+}
+
+// LoginThrottle 登录失败计数表（按账号和IP分别统计，用于锁定账号和限制IP）
+type LoginThrottle struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Scope      string `gorm:"size:10;uniqueIndex:idx_login_throttle;not null" json:"scope"`       // 统计范围：user(账号), ip
+	Identifier string `gorm:"size:100;uniqueIndex:idx_login_throttle;not null" json:"identifier"` // 用户名或IP地址
+
+	Failures      int        `gorm:"default:0" json:"failures"` // 当前窗口内的失败次数
+	WindowStartAt time.Time  `json:"window_start_at"`           // 当前统计窗口开始时间
+	LastFailedAt  time.Time  `json:"last_failed_at"`            // 最后失败时间
+	NextAttemptAt *time.Time `json:"next_attempt_at"`           // 允许下次尝试的时间（递增延迟）
+	LockedUntil   *time.Time `gorm:"index" json:"locked_until"` // 锁定截止时间
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sprint 迭代表
+// 任务、Bug和需求通过 SprintID 规划到迭代中，迭代关闭时未完成的工作项转入下一个迭代
+type Sprint struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name   string `gorm:"size:100;not null" json:"name"`           // 迭代名称
+	Goal   string `gorm:"type:text" json:"goal"`                   // 迭代目标
+	Status string `gorm:"size:20;default:'planned'" json:"status"` // 状态：planned(未开始), active(进行中), closed(已关闭)
+
+	ProjectID uint    `gorm:"index;not null" json:"project_id"`
+	Project   Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+
+	StartDate time.Time `json:"start_date"`                // 开始日期
+	EndDate   time.Time `json:"end_date"`                  // 结束日期
+	Capacity  float64   `gorm:"default:0" json:"capacity"` // 容量（可投入工时，小时）
+
+	CreatorID uint `gorm:"index" json:"creator_id"`
+	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
+
+	StartedAt *time.Time `json:"started_at"` // 实际开始时间
+	ClosedAt  *time.Time `json:"closed_at"`  // 关闭时间
+}
@@ -24,6 +24,12 @@ type Task struct {
 	RequirementID *uint       `gorm:"index" json:"requirement_id"`
 	Requirement   *Requirement `gorm:"foreignKey:RequirementID" json:"requirement,omitempty"`
 
+	ParentID *uint  `gorm:"index" json:"parent_id"` // 父任务ID（为空表示顶层任务）
+	Children []Task `gorm:"foreignKey:ParentID" json:"children,omitempty"`
+
+	SprintID *uint   `gorm:"index" json:"sprint_id"` // 所属迭代（为空表示在待办池中）
+	Sprint   *Sprint `gorm:"foreignKey:SprintID" json:"sprint,omitempty"`
+
 	CreatorID uint `gorm:"index" json:"creator_id"`
 	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
 
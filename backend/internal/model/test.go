@@ -58,7 +58,11 @@ type TestCase struct {
 	CreatorID uint `gorm:"index" json:"creator_id"`
 	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
 
-	Bugs    []Bug        `gorm:"many2many:test_case_bugs;" json:"bugs,omitempty"`
+	StepVersion int            `gorm:"default:1" json:"step_version"` // 当前测试步骤版本，每次修改步骤加1，历史版本的步骤保留
+	Steps       []TestCaseStep `gorm:"-" json:"steps,omitempty"`      // 当前版本的测试步骤
+
+	Bugs         []Bug         `gorm:"many2many:test_case_bugs;" json:"bugs,omitempty"`
+	Requirements []Requirement `gorm:"many2many:test_case_requirements;" json:"requirements,omitempty"` // 覆盖的需求
 }
 
 // TestCaseBug 测试单-Bug关联表
@@ -68,3 +72,58 @@ type TestCaseBug struct {
 	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 }
 
+// TestCaseRequirement 测试单-需求关联表（测试单覆盖的需求）
+type TestCaseRequirement struct {
+	TestCaseID    uint      `gorm:"primaryKey" json:"test_case_id"`
+	RequirementID uint      `gorm:"primaryKey" json:"requirement_id"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// TestCaseStep 测试步骤表（按版本保存，修改步骤时写入新版本，不改动历史版本）
+type TestCaseStep struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	TestCaseID uint `gorm:"index:idx_test_case_step_version;not null" json:"test_case_id"`
+	Version    int  `gorm:"index:idx_test_case_step_version;not null" json:"version"` // 步骤版本
+	Sort       int  `gorm:"default:0" json:"sort"`                                     // 步骤序号，从1开始
+
+	Action   string `gorm:"type:text" json:"action"`   // 操作步骤
+	Expected string `gorm:"type:text" json:"expected"` // 预期结果
+
+	SharedStepID *uint `gorm:"index" json:"shared_step_id"` // 来自共享步骤库时的来源
+
+	Attachments []Attachment `gorm:"many2many:test_case_step_attachments;" json:"attachments"`
+}
+
+// SharedStep 共享步骤库：可被多个测试单引用的一组步骤
+type SharedStep struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name        string `gorm:"size:200;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+
+	ProjectID *uint    `gorm:"index" json:"project_id"` // 所属项目（为空表示所有项目共享）
+	Project   *Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+
+	CreatorID uint `gorm:"index" json:"creator_id"`
+	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
+
+	Items []SharedStepItem `gorm:"foreignKey:SharedStepID" json:"items"`
+}
+
+// SharedStepItem 共享步骤库中的步骤
+type SharedStepItem struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	SharedStepID uint   `gorm:"index;not null" json:"shared_step_id"`
+	Sort         int    `gorm:"default:0" json:"sort"`
+	Action       string `gorm:"type:text" json:"action"`
+	Expected     string `gorm:"type:text" json:"expected"`
+}
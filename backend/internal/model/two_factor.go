@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// UserTwoFactor 用户两步验证（TOTP）设置
+// 开始绑定时生成密钥（Enabled 为 false），用户使用验证器应用验证一次验证码后才正式启用
+type UserTwoFactor struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID uint  `gorm:"uniqueIndex;not null" json:"user_id"` // 用户ID
+	User   *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+
+	Secret    string     `gorm:"size:64;not null" json:"-"`    // TOTP密钥（Base32编码，不返回给前端）
+	Enabled   bool       `gorm:"default:false" json:"enabled"` // 是否已启用
+	EnabledAt *time.Time `json:"enabled_at"`                   // 启用时间
+
+	// LastUsedStep 最后一次验证通过的时间步，同一时间步的验证码不能重复使用
+	LastUsedStep int64      `gorm:"default:0" json:"-"`
+	LastUsedAt   *time.Time `json:"last_used_at"` // 最后一次验证时间
+}
+
+// UserRecoveryCode 两步验证恢复码（一次性使用，只保存哈希）
+type UserRecoveryCode struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   uint       `gorm:"index;not null" json:"user_id"`   // 用户ID
+	CodeHash string     `gorm:"size:64;index;not null" json:"-"` // 恢复码的SHA-256哈希
+	UsedAt   *time.Time `json:"used_at"`                         // 使用时间（为空表示未使用）
+}
@@ -22,6 +22,11 @@ type User struct {
 	Phone        string `gorm:"size:20" json:"phone"`                       // 手机号
 	Status       int    `gorm:"default:1" json:"status"`                      // 状态：1-正常，0-禁用
 	LoginCount   int    `gorm:"default:0" json:"login_count"`                 // 登录次数
+	// IsServiceAccount 服务账号：不能交互式登录，只能通过个人访问令牌调用接口（用于脚本和CI）
+	IsServiceAccount bool `gorm:"default:false" json:"is_service_account"`
+	// AuthSource 账号来源：空表示本地账号，ldap 表示由LDAP目录创建并同步
+	AuthSource string `gorm:"size:20;index" json:"auth_source"`
+	ExternalID string `gorm:"size:255" json:"external_id,omitempty"` // 外部身份标识（如LDAP用户DN）
 
 	DepartmentID *uint      `gorm:"index" json:"department_id"` // 部门ID
 	Department   *Department `gorm:"foreignKey:DepartmentID" json:"department,omitempty"`
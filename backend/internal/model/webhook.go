@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook 项目Webhook配置表
+type Webhook struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	ProjectID uint    `gorm:"index;not null" json:"project_id"`
+	Project   Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+
+	Name        string      `gorm:"size:100;not null" json:"name"` // 名称
+	URL         string      `gorm:"size:500;not null" json:"url"`  // 推送地址
+	Secret      string      `gorm:"size:100" json:"-"`             // 签名密钥（HMAC-SHA256）
+	Events      StringArray `gorm:"type:text" json:"events"`       // 订阅的事件类型（JSON数组），如 bug.created，* 表示全部
+	Description string      `gorm:"type:text" json:"description"`  // 描述
+	Status      int         `gorm:"default:1" json:"status"`       // 状态：1-启用，0-禁用
+
+	CreatorID uint `gorm:"index" json:"creator_id"`
+	Creator   User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
+}
+
+// WebhookDelivery Webhook投递记录表（同时作为持久化重试队列）
+type WebhookDelivery struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	WebhookID uint `gorm:"index;not null" json:"webhook_id"`
+
+	EventID   string `gorm:"size:36;index" json:"event_id"`   // 事件ID（重新投递时保持不变）
+	EventType string `gorm:"size:80;index" json:"event_type"` // 事件类型
+	Payload   string `gorm:"type:text" json:"payload"`        // 请求体（JSON）
+	Redeliver bool   `gorm:"default:false" json:"redeliver"`  // 是否为手动重新投递
+
+	Status        string     `gorm:"size:20;index;default:'pending'" json:"status"` // 状态：pending(待投递), delivering(投递中), success(成功), failed(失败)
+	Attempts      int        `gorm:"default:0" json:"attempts"`                     // 已尝试次数
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`                  // 下次尝试时间
+	LastAttemptAt *time.Time `json:"last_attempt_at"`                               // 最后尝试时间
+
+	ResponseCode int    `json:"response_code"`                  // 响应状态码
+	ResponseBody string `gorm:"type:text" json:"response_body"` // 响应内容（截断）
+	Error        string `gorm:"type:text" json:"error"`         // 错误信息
+	Duration     int64  `json:"duration"`                       // 耗时（毫秒）
+}
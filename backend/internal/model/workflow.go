@@ -0,0 +1,55 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Workflow 状态工作流表（按项目和对象类型配置状态机）
+type Workflow struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name         string `gorm:"size:100;not null" json:"name"`             // 工作流名称
+	ObjectType   string `gorm:"size:30;not null;index" json:"object_type"` // 对象类型：bug, task, requirement
+	ProjectID    *uint  `gorm:"index" json:"project_id"`                   // 所属项目（为空表示全局默认工作流）
+	InitialState string `gorm:"size:20" json:"initial_state"`              // 初始状态
+	Description  string `gorm:"type:text" json:"description"`              // 描述
+	Status       int    `gorm:"default:1" json:"status"`                   // 状态：1-启用，0-禁用
+
+	States      []WorkflowState      `gorm:"foreignKey:WorkflowID" json:"states,omitempty"`
+	Transitions []WorkflowTransition `gorm:"foreignKey:WorkflowID" json:"transitions,omitempty"`
+}
+
+// WorkflowState 工作流状态表
+type WorkflowState struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	WorkflowID uint `gorm:"index;not null" json:"workflow_id"`
+
+	Code     string `gorm:"size:20;not null" json:"code"`           // 状态代码（写入对象的 status 字段）
+	Name     string `gorm:"size:50" json:"name"`                    // 状态显示名称
+	Category string `gorm:"size:20;default:'open'" json:"category"` // 状态分类：open(未开始), doing(进行中), done(已完成), cancelled(已取消)
+	Sort     int    `gorm:"default:0" json:"sort"`                  // 排序
+}
+
+// WorkflowTransition 工作流状态流转表
+type WorkflowTransition struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	WorkflowID uint `gorm:"index;not null" json:"workflow_id"`
+
+	FromState string `gorm:"size:20;not null" json:"from_state"` // 源状态（* 表示任意状态）
+	ToState   string `gorm:"size:20;not null" json:"to_state"`   // 目标状态
+	Name      string `gorm:"size:50" json:"name"`                // 流转名称（如：解决、关闭、激活）
+
+	RequiredFields StringArray `gorm:"type:text" json:"required_fields"` // 流转时必填字段（JSON数组），如 solution, comment
+	AllowedRoles   StringArray `gorm:"type:text" json:"allowed_roles"`   // 允许执行的角色（JSON数组），全局角色代码或 project:owner 形式的项目角色，为空表示不限制
+}
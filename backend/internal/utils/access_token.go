@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/pkg/permission"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AccessTokenPrefix 个人访问令牌前缀，用于和JWT区分
+const AccessTokenPrefix = "pft_"
+
+// 个人访问令牌有效期（天）
+const (
+	DefaultAccessTokenDays = 90
+	MaxAccessTokenDays     = 365
+)
+
+// accessTokenTouchInterval 最后使用时间的更新间隔，避免每个请求都写库
+const accessTokenTouchInterval = time.Minute
+
+var (
+	ErrAccessTokenInvalid = errors.New("无效的访问令牌")
+	ErrAccessTokenExpired = errors.New("访问令牌已过期")
+	ErrAccessTokenRevoked = errors.New("访问令牌已吊销")
+	// ErrServiceAccountLogin 服务账号不能交互式登录
+	ErrServiceAccountLogin = errors.New("服务账号不能登录，请使用访问令牌")
+)
+
+// IsPersonalAccessToken 判断Bearer凭证是否为个人访问令牌
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+// HashAccessToken 计算令牌哈希
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateAccessTokenScopes 校验令牌权限范围：权限代码必须存在，且用户当前拥有该权限
+func ValidateAccessTokenScopes(db *gorm.DB, user *model.User, scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("请至少选择一个权限范围")
+	}
+	roleCodes, err := userRoleCodes(db, user.ID)
+	if err != nil {
+		return err
+	}
+	userPerms, isAdmin, err := rolePermissionSet(db, roleCodes)
+	if err != nil {
+		return err
+	}
+	for _, scope := range scopes {
+		var count int64
+		db.Model(&model.Permission{}).Where("code = ?", scope).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("权限不存在: %s", scope)
+		}
+		if !isAdmin && !userPerms[scope] {
+			return fmt.Errorf("用户没有权限: %s", scope)
+		}
+	}
+	return nil
+}
+
+// CreateAccessToken 为用户创建个人访问令牌，返回令牌记录和令牌明文（明文只返回这一次）
+func CreateAccessToken(db *gorm.DB, user *model.User, name string, scopes []string, expiresAt time.Time, createdBy uint) (*model.PersonalAccessToken, string, error) {
+	if err := ValidateAccessTokenScopes(db, user, scopes); err != nil {
+		return nil, "", err
+	}
+
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	plain := AccessTokenPrefix + hex.EncodeToString(buf)
+
+	scopeJSON, err := json.Marshal(dedupeStrings(scopes))
+	if err != nil {
+		return nil, "", err
+	}
+	token := model.PersonalAccessToken{
+		UserID:      user.ID,
+		Name:        name,
+		TokenPrefix: plain[:len(AccessTokenPrefix)+6],
+		TokenHash:   HashAccessToken(plain),
+		Scopes:      string(scopeJSON),
+		CreatedBy:   createdBy,
+		ExpiresAt:   &expiresAt,
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+	return &token, plain, nil
+}
+
+// AccessTokenScopes 解析令牌的权限范围
+func AccessTokenScopes(token *model.PersonalAccessToken) []string {
+	scopes := []string{}
+	if token.Scopes != "" {
+		_ = json.Unmarshal([]byte(token.Scopes), &scopes)
+	}
+	return scopes
+}
+
+// AccessTokenIdentity 个人访问令牌认证通过后的身份信息
+type AccessTokenIdentity struct {
+	Token       *model.PersonalAccessToken
+	User        *model.User
+	Roles       []string // 用户角色代码（不含管理员角色，见 AuthenticateAccessToken）
+	Scopes      []string // 令牌权限范围
+	Permissions []string // 实际生效的权限（权限范围与用户当前权限的交集）
+}
+
+// AuthenticateAccessToken 校验个人访问令牌并返回身份信息，同时更新最后使用时间
+func AuthenticateAccessToken(db *gorm.DB, plain string, c *gin.Context) (*AccessTokenIdentity, error) {
+	var token model.PersonalAccessToken
+	if err := db.Where("token_hash = ?", HashAccessToken(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccessTokenInvalid
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return nil, ErrAccessTokenRevoked
+	}
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, ErrAccessTokenExpired
+	}
+
+	var user model.User
+	if err := db.Preload("Roles").First(&user, token.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccessTokenInvalid
+		}
+		return nil, err
+	}
+	if user.Status != 1 {
+		return nil, ErrUserDisabled
+	}
+
+	roles := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role.Code)
+	}
+	userPerms, isAdmin, err := rolePermissionSet(db, roles)
+	if err != nil {
+		return nil, err
+	}
+	scopes := AccessTokenScopes(&token)
+	perms := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if isAdmin || userPerms[scope] {
+			perms = append(perms, scope)
+		}
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > accessTokenTouchInterval {
+		updates := map[string]interface{}{"last_used_at": now}
+		if c != nil {
+			updates["last_used_ip"] = c.ClientIP()
+		}
+		db.Model(&model.PersonalAccessToken{}).Where("id = ?", token.ID).UpdateColumns(updates)
+	}
+
+	// 令牌不继承管理员身份：管理员的额外权利（IsAdmin 判断）只对登录会话生效，令牌只能使用权限范围内的权限
+	tokenRoles := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role != AdminRoleCode {
+			tokenRoles = append(tokenRoles, role)
+		}
+	}
+
+	return &AccessTokenIdentity{Token: &token, User: &user, Roles: tokenRoles, Scopes: scopes, Permissions: perms}, nil
+}
+
+// RevokeAccessToken 吊销个人访问令牌
+func RevokeAccessToken(db *gorm.DB, tokenID uint) error {
+	return db.Model(&model.PersonalAccessToken{}).
+		Where("id = ? AND revoked_at IS NULL", tokenID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// GetAccessTokenID 获取当前请求使用的个人访问令牌ID，使用JWT登录时返回 0
+func GetAccessTokenID(c *gin.Context) uint {
+	if id, exists := c.Get("access_token_id"); exists {
+		if tokenID, ok := id.(uint); ok {
+			return tokenID
+		}
+	}
+	return 0
+}
+
+// AccessTokenAllows 使用个人访问令牌时检查权限是否在令牌的权限范围内；使用JWT登录时始终返回 true
+func AccessTokenAllows(c *gin.Context, permCode string) bool {
+	scopes, exists := c.Get("token_scopes")
+	if !exists {
+		return true
+	}
+	scopeList, ok := scopes.([]string)
+	if !ok {
+		return false
+	}
+	for _, scope := range scopeList {
+		if scope == permCode {
+			return true
+		}
+	}
+	return false
+}
+
+// rolePermissionSet 获取角色拥有的权限集合，以及是否为管理员（管理员拥有所有权限）
+func rolePermissionSet(db *gorm.DB, roleCodes []string) (map[string]bool, bool, error) {
+	set := map[string]bool{}
+	for _, code := range roleCodes {
+		if code == "admin" {
+			return set, true, nil
+		}
+	}
+	if len(roleCodes) == 0 {
+		return set, false, nil
+	}
+	perms, err := permission.GetRolePermissions(db, roleCodes)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, perm := range perms {
+		set[perm] = true
+	}
+	return set, false, nil
+}
+
+// userRoleCodes 获取用户的角色代码
+func userRoleCodes(db *gorm.DB, userID uint) ([]string, error) {
+	var roles []model.Role
+	if err := db.Model(&model.User{ID: userID}).Association("Roles").Find(&roles); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, len(roles))
+	for _, role := range roles {
+		codes = append(codes, role.Code)
+	}
+	return codes, nil
+}
+
+func dedupeStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"prjflow/internal/model"
@@ -23,12 +24,35 @@ func RecordAction(db *gorm.DB, objectType string, objectID uint, actionType stri
 		Comment:    comment,
 	}
 
-	// 获取项目ID（如果是Bug，从Bug表获取）
-	if objectType == "bug" {
+	// 获取项目ID（从对象表获取，用于按项目查询和事件推送）
+	switch objectType {
+	case "bug":
 		var bug model.Bug
 		if err := db.First(&bug, objectID).Error; err == nil {
 			action.ProjectID = bug.ProjectID
 		}
+	case "task":
+		var task model.Task
+		if err := db.First(&task, objectID).Error; err == nil {
+			action.ProjectID = task.ProjectID
+		}
+	case "requirement":
+		var requirement model.Requirement
+		if err := db.First(&requirement, objectID).Error; err == nil {
+			action.ProjectID = requirement.ProjectID
+		}
+	case "sprint":
+		var sprint model.Sprint
+		if err := db.First(&sprint, objectID).Error; err == nil {
+			action.ProjectID = sprint.ProjectID
+		}
+	case "version":
+		var version model.Version
+		if err := db.First(&version, objectID).Error; err == nil {
+			action.ProjectID = version.ProjectID
+		}
+	case "project":
+		action.ProjectID = objectID
 	}
 
 	// 处理extra字段（JSON格式）
@@ -43,9 +67,46 @@ func RecordAction(db *gorm.DB, objectType string, objectID uint, actionType stri
 		return 0, err
 	}
 
+	notifyActionListeners(db, &action)
+
 	return action.ID, nil
 }
 
+// ActionListener 操作记录监听器（RecordAction 成功后调用）
+// 用于事件推送、Webhook、通知等扩展，监听器不应阻塞
+type ActionListener func(db *gorm.DB, action *model.Action)
+
+var (
+	actionListeners   []ActionListener
+	actionListenersMu sync.RWMutex
+)
+
+// RegisterActionListener 注册操作记录监听器
+func RegisterActionListener(listener ActionListener) {
+	actionListenersMu.Lock()
+	defer actionListenersMu.Unlock()
+	actionListeners = append(actionListeners, listener)
+}
+
+// notifyActionListeners 通知所有监听器（单个监听器panic不影响主流程）
+func notifyActionListeners(db *gorm.DB, action *model.Action) {
+	actionListenersMu.RLock()
+	listeners := make([]ActionListener, len(actionListeners))
+	copy(listeners, actionListeners)
+	actionListenersMu.RUnlock()
+
+	for _, listener := range listeners {
+		func() {
+			defer func() {
+				if r := recover(); r != nil && Logger != nil {
+					Logger.Errorf("操作记录监听器执行失败: %v", r)
+				}
+			}()
+			listener(db, action)
+		}()
+	}
+}
+
 // RecordHistory 记录字段变更（参考禅道的 logHistory() 方法）
 func RecordHistory(db *gorm.DB, actionID uint, changes []HistoryChange) error {
 	if actionID == 0 || len(changes) == 0 {
@@ -117,6 +178,11 @@ func ProcessHistory(db *gorm.DB, history *model.History) *model.History {
 		history.NewValue = getModuleDisplayName(db, history.New)
 		return history
 	}
+	if history.Field == "sprint_id" {
+		history.OldValue = getSprintDisplayName(db, history.Old)
+		history.NewValue = getSprintDisplayName(db, history.New)
+		return history
+	}
 	if history.Field == "resolved_version_id" {
 		history.OldValue = getVersionDisplayName(db, history.Old)
 		history.NewValue = getVersionDisplayName(db, history.New)
@@ -140,6 +206,9 @@ func ProcessHistory(db *gorm.DB, history *model.History) *model.History {
 	case "confirmed":
 		history.OldValue = getBoolDisplayName(history.Old)
 		history.NewValue = getBoolDisplayName(history.New)
+	case "review_verdict", "review_result":
+		history.OldValue = getReviewVerdictDisplayName(history.Old)
+		history.NewValue = getReviewVerdictDisplayName(history.New)
 	default:
 		// 其他字段直接使用原始值
 		history.OldValue = history.Old
@@ -256,6 +325,8 @@ func GetFieldDisplayName(fieldName string) string {
 		"due_date":          "截止日期",
 		"progress":          "进度",
 		"dependency_ids":    "依赖任务",
+		// 迭代
+		"sprint_id":         "迭代",
 	}
 
 	if name, ok := fieldNames[fieldName]; ok {
@@ -277,7 +348,7 @@ func isUserField(fieldName string) bool {
 }
 
 func isMultipleUserField(fieldName string) bool {
-	return fieldName == "assignee_ids"
+	return fieldName == "assignee_ids" || fieldName == "reviewer_ids"
 }
 
 func getUserDisplayName(db *gorm.DB, userIDStr string) string {
@@ -353,6 +424,19 @@ func getStatusDisplayName(status string) string {
 	return status
 }
 
+func getReviewVerdictDisplayName(verdict string) string {
+	verdictMap := map[string]string{
+		ReviewVerdictPending: "待评审",
+		ReviewVerdictPass:    "通过",
+		ReviewVerdictReject:  "拒绝",
+		ReviewVerdictClarify: "有待明确",
+	}
+	if name, ok := verdictMap[verdict]; ok {
+		return name
+	}
+	return verdict
+}
+
 func getPriorityDisplayName(priority string) string {
 	priorityMap := map[string]string{
 		"low":    "低",
@@ -539,3 +623,23 @@ func getVersionDisplayName(db *gorm.DB, versionIDStr string) string {
 	return version.VersionNumber
 }
 
+
+// getSprintDisplayName 获取迭代显示名称
+func getSprintDisplayName(db *gorm.DB, sprintIDStr string) string {
+	if sprintIDStr == "" || sprintIDStr == "0" {
+		return ""
+	}
+
+	var sprintID uint
+	fmt.Sscanf(sprintIDStr, "%d", &sprintID)
+	if sprintID == 0 {
+		return ""
+	}
+
+	var sprint model.Sprint
+	if err := db.Unscoped().First(&sprint, sprintID).Error; err != nil {
+		return sprintIDStr
+	}
+
+	return sprint.Name
+}
@@ -0,0 +1,364 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 燃尽图统计范围
+const (
+	BurnScopeProject = "project"
+	BurnScopeSprint  = "sprint"
+	BurnScopeVersion = "version"
+)
+
+// 范围变更类型
+const (
+	BurnChangeAdded    = "added"
+	BurnChangeRemoved  = "removed"
+	BurnChangeEstimate = "estimate_changed"
+)
+
+// BurnChartMaxDays 燃尽图最多统计的天数
+const BurnChartMaxDays = 366
+
+var ErrBurnChartRange = errors.New("统计区间无效：结束日期不能早于开始日期，且不能超过366天")
+
+// BurnChartScope 燃尽图统计范围，StartDate 和 EndDate 按天计算（含首尾）
+type BurnChartScope struct {
+	Type      string
+	ID        uint
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// BurnChartPoint 燃尽图/燃起图的一天，实际值按当天结束时的状态计算，未来日期只有理想线
+type BurnChartPoint struct {
+	Date       string   `json:"date"`
+	WorkingDay bool     `json:"working_day"`
+	Ideal      float64  `json:"ideal"`     // 理想剩余工时，只在工作日下降
+	Remaining  *float64 `json:"remaining"` // 剩余工时（燃尽）：未完成工作项的预估工时减去已登记工时
+	Scope      *float64 `json:"scope"`     // 范围总工时（燃起）
+	Completed  *float64 `json:"completed"` // 已完成工时（燃起）：范围总工时减去剩余工时
+	Logged     *float64 `json:"logged"`    // 范围内工作项的累计登记工时
+	Items      *int     `json:"items"`     // 范围内工作项数量
+}
+
+// BurnScopeChange 统计期间的范围变更（从操作历史还原）
+type BurnScopeChange struct {
+	Date       string  `json:"date"`
+	ObjectType string  `json:"object_type"`
+	ObjectID   uint    `json:"object_id"`
+	Title      string  `json:"title"`
+	Change     string  `json:"change"` // added, removed, estimate_changed
+	Hours      float64 `json:"hours"`  // 范围总工时的变化量
+}
+
+// BurnChart 燃尽图/燃起图数据
+type BurnChart struct {
+	ScopeType    string            `json:"scope_type"`
+	ScopeID      uint              `json:"scope_id"`
+	StartDate    string            `json:"start_date"`
+	EndDate      string            `json:"end_date"`
+	WorkingDays  int               `json:"working_days"`
+	Points       []BurnChartPoint  `json:"points"`
+	ScopeChanges []BurnScopeChange `json:"scope_changes"`
+}
+
+// burnItem 参与统计的工作项（任务和Bug；需求的工时由其任务体现，不重复计算）
+type burnItem struct {
+	objectType  string
+	id          uint
+	title       string
+	createdAt   time.Time
+	deletedAt   *time.Time
+	membership  *fieldTimeline // 为空表示范围成员不随时间变化（版本）
+	estimate    fieldTimeline
+	status      fieldTimeline
+	finished    map[string]bool // 项目工作流中视为完成的状态
+	allocations []model.ResourceAllocation
+}
+
+type burnItemState struct {
+	inScope   bool
+	estimate  float64
+	remaining float64
+	logged    float64
+}
+
+// stateAt 计算工作项在某一时刻的状态
+func (item *burnItem) stateAt(t time.Time, member string) burnItemState {
+	var state burnItemState
+	if !item.createdAt.Before(t) || (item.deletedAt != nil && item.deletedAt.Before(t)) {
+		return state
+	}
+	if item.membership != nil && item.membership.valueAt(t) != member {
+		return state
+	}
+	state.inScope = true
+	state.estimate, _ = strconv.ParseFloat(item.estimate.valueAt(t), 64)
+
+	lastDay := t.AddDate(0, 0, -1).Format("2006-01-02")
+	for _, allocation := range item.allocations {
+		if allocation.Date.Format("2006-01-02") > lastDay {
+			break
+		}
+		state.logged += allocation.Hours
+	}
+
+	if !item.finished[item.status.valueAt(t)] {
+		state.remaining = math.Max(state.estimate-state.logged, 0)
+	}
+	return state
+}
+
+// BuildBurnChart 生成燃尽图/燃起图：剩余工时 = 预估工时 - 已登记的资源分配工时（已完成的工作项为0），
+// 范围和预估工时的变化从 Action/History 还原，理想线按工作日历只在工作日下降
+func BuildBurnChart(db *gorm.DB, scope BurnChartScope, calendar *WorkCalendar) (*BurnChart, error) {
+	start, end := startOfDay(scope.StartDate), startOfDay(scope.EndDate)
+	if end.Before(start) || end.Sub(start) > BurnChartMaxDays*24*time.Hour {
+		return nil, ErrBurnChartRange
+	}
+	if calendar == nil {
+		calendar = DefaultWorkCalendar()
+	}
+
+	items, err := loadBurnItems(db, scope)
+	if err != nil {
+		return nil, err
+	}
+	member := strconv.FormatUint(uint64(scope.ID), 10)
+
+	chart := &BurnChart{
+		ScopeType:    scope.Type,
+		ScopeID:      scope.ID,
+		StartDate:    start.Format("2006-01-02"),
+		EndDate:      end.Format("2006-01-02"),
+		WorkingDays:  calendar.WorkingDaysBetween(start, end),
+		Points:       make([]BurnChartPoint, 0),
+		ScopeChanges: make([]BurnScopeChange, 0),
+	}
+
+	// 理想线起点：开始日结束时的剩余工时，迭代尚未开始时取当前剩余工时
+	now := time.Now()
+	baselineAt := start.AddDate(0, 0, 1)
+	if baselineAt.After(now) {
+		baselineAt = now
+	}
+	initial := 0.0
+	for _, item := range items {
+		initial += item.stateAt(baselineAt, member).remaining
+	}
+
+	today := startOfDay(now)
+	var previous map[*burnItem]burnItemState
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		point := BurnChartPoint{
+			Date:       day.Format("2006-01-02"),
+			WorkingDay: calendar.IsWorkingDay(day),
+			Ideal:      burnIdeal(calendar, initial, start, day, chart.WorkingDays),
+		}
+
+		if !day.After(today) {
+			at := day.AddDate(0, 0, 1)
+			states := make(map[*burnItem]burnItemState, len(items))
+			var scopeHours, remaining, logged float64
+			count := 0
+			for _, item := range items {
+				state := item.stateAt(at, member)
+				states[item] = state
+				if !state.inScope {
+					continue
+				}
+				count++
+				scopeHours += state.estimate
+				remaining += state.remaining
+				logged += state.logged
+			}
+			if previous != nil {
+				chart.ScopeChanges = append(chart.ScopeChanges, diffBurnScope(point.Date, items, previous, states)...)
+			}
+			previous = states
+
+			completed := roundTwoDecimals(scopeHours - remaining)
+			scopeHours, remaining, logged = roundTwoDecimals(scopeHours), roundTwoDecimals(remaining), roundTwoDecimals(logged)
+			point.Scope, point.Remaining, point.Completed, point.Logged, point.Items = &scopeHours, &remaining, &completed, &logged, &count
+		}
+		chart.Points = append(chart.Points, point)
+	}
+	return chart, nil
+}
+
+// burnIdeal 理想剩余工时：从开始日的剩余工时按工作日均匀下降，到结束日为0
+func burnIdeal(calendar *WorkCalendar, initial float64, start, day time.Time, workingDays int) float64 {
+	if workingDays == 0 {
+		return initial
+	}
+	elapsed := calendar.WorkingDaysBetween(start, day)
+	return roundTwoDecimals(initial * float64(workingDays-elapsed) / float64(workingDays))
+}
+
+// diffBurnScope 对比相邻两天的状态，得到当天的范围变更
+func diffBurnScope(date string, items []*burnItem, previous, current map[*burnItem]burnItemState) []BurnScopeChange {
+	var changes []BurnScopeChange
+	for _, item := range items {
+		before, after := previous[item], current[item]
+		change := BurnScopeChange{Date: date, ObjectType: item.objectType, ObjectID: item.id, Title: item.title}
+		switch {
+		case !before.inScope && after.inScope:
+			change.Change, change.Hours = BurnChangeAdded, after.estimate
+		case before.inScope && !after.inScope:
+			change.Change, change.Hours = BurnChangeRemoved, -before.estimate
+		case before.inScope && after.inScope && before.estimate != after.estimate:
+			change.Change, change.Hours = BurnChangeEstimate, after.estimate-before.estimate
+		default:
+			continue
+		}
+		change.Hours = roundTwoDecimals(change.Hours)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// loadBurnItems 加载可能在统计范围内的任务和Bug（包括已删除、已移出范围的），以及它们的字段变更和工时登记
+func loadBurnItems(db *gorm.DB, scope BurnChartScope) ([]*burnItem, error) {
+	var membershipField string
+	switch scope.Type {
+	case BurnScopeProject:
+		membershipField = "project_id"
+	case BurnScopeSprint:
+		membershipField = "sprint_id"
+	case BurnScopeVersion:
+	default:
+		return nil, errors.New("不支持的统计范围")
+	}
+	member := strconv.FormatUint(uint64(scope.ID), 10)
+
+	// 当前在范围内的，或历史上曾经在范围内的工作项
+	candidates := func(objectType string) *gorm.DB {
+		query := db.Unscoped()
+		if membershipField == "" {
+			if objectType == SprintItemBug {
+				return query.Where("id IN (?)", db.Table("version_bugs").Select("bug_id").Where("version_id = ?", scope.ID))
+			}
+			return query.Where("requirement_id IN (?)", db.Table("version_requirements").Select("requirement_id").Where("version_id = ?", scope.ID))
+		}
+		historical := db.Table("histories").Select("actions.object_id").
+			Joins("JOIN actions ON actions.id = histories.action_id").
+			Where("actions.object_type = ? AND histories.field = ? AND (histories.old = ? OR histories.new = ?)", objectType, membershipField, member, member)
+		return query.Where(membershipField+" = ?", scope.ID).Or("id IN (?)", historical)
+	}
+
+	var tasks []model.Task
+	if err := candidates(SprintItemTask).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	// 汇总任务的工时由子任务汇总而来，不参与燃尽，避免重复计算
+	candidateIDs := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		candidateIDs = append(candidateIDs, task.ID)
+	}
+	summaryTasks, err := SummaryTaskIDs(db, candidateIDs)
+	if err != nil {
+		return nil, err
+	}
+	var bugs []model.Bug
+	if err := candidates(SprintItemBug).Find(&bugs).Error; err != nil {
+		return nil, err
+	}
+
+	finished := newFinishedStatusCache(db)
+	items := make([]*burnItem, 0, len(tasks)+len(bugs))
+	byKey := make(map[string]*burnItem, len(tasks)+len(bugs))
+	taskIDs := make([]uint, 0, len(tasks))
+	bugIDs := make([]uint, 0, len(bugs))
+	add := func(item *burnItem, deletedAt gorm.DeletedAt, estimate *float64, membership string) {
+		if deletedAt.Valid {
+			item.deletedAt = &deletedAt.Time
+		}
+		if estimate != nil {
+			item.estimate.current = strconv.FormatFloat(*estimate, 'f', -1, 64)
+		}
+		if membershipField != "" {
+			item.membership = &fieldTimeline{current: membership}
+		}
+		items = append(items, item)
+		byKey[objectKey(item.objectType, item.id)] = item
+	}
+	for _, task := range tasks {
+		if summaryTasks[task.ID] {
+			continue
+		}
+		membership := strconv.FormatUint(uint64(task.ProjectID), 10)
+		if membershipField == "sprint_id" {
+			membership = sprintIDString(task.SprintID)
+		}
+		add(&burnItem{objectType: SprintItemTask, id: task.ID, title: task.Title, createdAt: task.CreatedAt, status: fieldTimeline{current: task.Status},
+			finished: finished.get(SprintItemTask, task.ProjectID)},
+			task.DeletedAt, task.EstimatedHours, membership)
+		taskIDs = append(taskIDs, task.ID)
+	}
+	for _, bug := range bugs {
+		membership := strconv.FormatUint(uint64(bug.ProjectID), 10)
+		if membershipField == "sprint_id" {
+			membership = sprintIDString(bug.SprintID)
+		}
+		add(&burnItem{objectType: SprintItemBug, id: bug.ID, title: bug.Title, createdAt: bug.CreatedAt, status: fieldTimeline{current: bug.Status},
+			finished: finished.get(SprintItemBug, bug.ProjectID)},
+			bug.DeletedAt, bug.EstimatedHours, membership)
+		bugIDs = append(bugIDs, bug.ID)
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	// 字段变更历史
+	fields := []string{"status", "estimated_hours"}
+	if membershipField != "" {
+		fields = append(fields, membershipField)
+	}
+	changes, err := loadFieldChanges(db, map[string][]uint{SprintItemTask: taskIDs, SprintItemBug: bugIDs}, fields)
+	if err != nil {
+		return nil, err
+	}
+	for key, item := range byKey {
+		item.status.changes = changes[key]["status"]
+		item.estimate.changes = changes[key]["estimated_hours"]
+		if item.membership != nil {
+			item.membership.changes = changes[key][membershipField]
+		}
+	}
+
+	// 工时登记
+	var allocations []model.ResourceAllocation
+	if err := db.Where("task_id IN ? OR bug_id IN ?", append(taskIDs, 0), append(bugIDs, 0)).
+		Order("date").Find(&allocations).Error; err != nil {
+		return nil, err
+	}
+	for _, allocation := range allocations {
+		var key string
+		if allocation.TaskID != nil {
+			key = objectKey(SprintItemTask, *allocation.TaskID)
+		} else if allocation.BugID != nil {
+			key = objectKey(SprintItemBug, *allocation.BugID)
+		}
+		if item := byKey[key]; item != nil {
+			item.allocations = append(item.allocations, allocation)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].createdAt.Before(items[j].createdAt) })
+	return items, nil
+}
+
+// roundTwoDecimals 保留两位小数
+func roundTwoDecimals(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
@@ -0,0 +1,390 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 邮件状态
+const (
+	EmailStatusPending  = "pending"
+	EmailStatusSending  = "sending"
+	EmailStatusSent     = "sent"
+	EmailStatusFailed   = "failed"
+	EmailStatusDigest   = "digest"   // 等待每日摘要
+	EmailStatusDigested = "digested" // 已汇总到摘要邮件
+)
+
+// 邮件发送方式
+const (
+	EmailModeImmediate = "immediate"
+	EmailModeDigest    = "digest"
+)
+
+const (
+	defaultEmailPort        = 25
+	defaultEmailTimeout     = 15
+	defaultEmailMaxAttempts = 5
+	emailRetryBase          = time.Minute
+	maxEmailRetryInterval   = time.Hour
+	emailBatchSize          = 20
+	emailPollInterval       = 30 * time.Second
+	// 发送中状态超过该时间视为进程中断，重新放回队列
+	emailStaleSending = 10 * time.Minute
+)
+
+// EmailEnabled 检查是否启用了邮件通知
+func EmailEnabled() bool {
+	return config.AppConfig != nil && config.AppConfig.Email.Enabled && config.AppConfig.Email.Host != ""
+}
+
+// EmailBackoff 计算第 attempt 次失败后的重试间隔（指数退避：1m, 2m, 4m ... 最长 1 小时）
+func EmailBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	interval := emailRetryBase
+	for i := 1; i < attempt; i++ {
+		interval *= 2
+		if interval >= maxEmailRetryInterval {
+			return maxEmailRetryInterval
+		}
+	}
+	return interval
+}
+
+func emailMaxAttempts() int {
+	if config.AppConfig != nil && config.AppConfig.Email.MaxAttempts > 0 {
+		return config.AppConfig.Email.MaxAttempts
+	}
+	return defaultEmailMaxAttempts
+}
+
+// BuildEmailMessage 构建 multipart/alternative 格式的邮件内容（同时包含纯文本和HTML正文）
+func BuildEmailMessage(from, fromName, to, subject, htmlBody, textBody string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fromHeader := from
+	if fromName != "" {
+		fromHeader = fmt.Sprintf("%s <%s>", mime.QEncoding.Encode("UTF-8", fromName), from)
+	}
+	domain := "localhost"
+	if at := strings.LastIndexByte(from, '@'); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+
+	var header bytes.Buffer
+	header.WriteString("From: " + fromHeader + "\r\n")
+	header.WriteString("To: " + to + "\r\n")
+	header.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", subject) + "\r\n")
+	header.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	header.WriteString("Message-ID: <" + uuid.New().String() + "@" + domain + ">\r\n")
+	header.WriteString("MIME-Version: 1.0\r\n")
+	header.WriteString("Content-Type: multipart/alternative; boundary=\"" + writer.Boundary() + "\"\r\n")
+	header.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", textBody},
+		{"text/html; charset=UTF-8", htmlBody},
+	}
+	for _, part := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(wrapBase64([]byte(part.body))); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return append(header.Bytes(), buf.Bytes()...), nil
+}
+
+// wrapBase64 base64编码并按76字符换行
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+// SendEmail 通过SMTP发送邮件
+func SendEmail(cfg config.EmailConfig, to, subject, htmlBody, textBody string) error {
+	if cfg.Host == "" {
+		return fmt.Errorf("SMTP服务器未配置")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultEmailPort
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultEmailTimeout * time.Second
+	}
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+
+	msg, err := BuildEmailMessage(from, cfg.FromName, to, subject, htmlBody, textBody)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+	var conn net.Conn
+	if cfg.Encryption == "ssl" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("连接SMTP服务器失败: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP握手失败: %w", err)
+	}
+	defer client.Close()
+
+	if cfg.Encryption != "ssl" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS失败: %w", err)
+			}
+		} else if cfg.Encryption == "starttls" {
+			return fmt.Errorf("SMTP服务器不支持STARTTLS")
+		}
+	}
+
+	if cfg.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+				return fmt.Errorf("SMTP认证失败: %w", err)
+			}
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("发件人被拒绝: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("收件人被拒绝: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("发送邮件内容失败: %w", err)
+	}
+	return client.Quit()
+}
+
+// EnqueueEmail 将邮件加入发送队列
+// mode 为 digest 时暂存，等待每日摘要任务汇总发送
+func EnqueueEmail(db *gorm.DB, message *model.EmailMessage, mode string) error {
+	if mode == EmailModeDigest {
+		message.Status = EmailStatusDigest
+		message.NextAttemptAt = nil
+	} else {
+		now := time.Now()
+		message.Status = EmailStatusPending
+		message.NextAttemptAt = &now
+	}
+	if err := db.Create(message).Error; err != nil {
+		return err
+	}
+
+	if message.Status == EmailStatusPending && emailDispatcher != nil {
+		emailDispatcher.Wake()
+	}
+	return nil
+}
+
+// DeliverEmail 发送一封邮件并更新发送记录
+// 失败时按指数退避安排重试，超过最大次数后标记为 failed
+func DeliverEmail(db *gorm.DB, message *model.EmailMessage) error {
+	var cfg config.EmailConfig
+	if config.AppConfig != nil {
+		cfg = config.AppConfig.Email
+	}
+
+	start := time.Now()
+	sendErr := SendEmail(cfg, message.To, message.Subject, message.HTMLBody, message.TextBody)
+
+	message.Attempts++
+	message.LastAttemptAt = &start
+	message.Error = ""
+
+	if sendErr == nil {
+		message.Status = EmailStatusSent
+		message.SentAt = &start
+		message.NextAttemptAt = nil
+	} else {
+		message.Error = sendErr.Error()
+		if message.Attempts >= emailMaxAttempts() {
+			message.Status = EmailStatusFailed
+			message.NextAttemptAt = nil
+			if Logger != nil {
+				Logger.Errorf("[Email] 邮件发送失败，已达到最大重试次数: id=%d, to=%s, attempts=%d, error=%v", message.ID, message.To, message.Attempts, sendErr)
+			}
+		} else {
+			next := time.Now().Add(EmailBackoff(message.Attempts))
+			message.Status = EmailStatusPending
+			message.NextAttemptAt = &next
+			if Logger != nil {
+				Logger.Warnf("[Email] 邮件发送失败，将于 %s 重试: id=%d, to=%s, attempts=%d, error=%v", next.Format("2006-01-02 15:04:05"), message.ID, message.To, message.Attempts, sendErr)
+			}
+		}
+	}
+
+	return db.Save(message).Error
+}
+
+// ProcessEmailQueue 处理到期的待发送邮件，返回处理的数量
+func ProcessEmailQueue(db *gorm.DB) int {
+	if !EmailEnabled() {
+		return 0
+	}
+
+	var messages []model.EmailMessage
+	if err := db.Where("status = ? AND next_attempt_at <= ?", EmailStatusPending, time.Now()).
+		Order("next_attempt_at ASC").Limit(emailBatchSize).Find(&messages).Error; err != nil {
+		return 0
+	}
+
+	processed := 0
+	for i := range messages {
+		message := &messages[i]
+		// 抢占邮件，避免重复发送
+		result := db.Model(&model.EmailMessage{}).
+			Where("id = ? AND status = ?", message.ID, EmailStatusPending).
+			Update("status", EmailStatusSending)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		if err := DeliverEmail(db, message); err != nil && Logger != nil {
+			Logger.Errorf("[Email] 更新发送记录失败: id=%d, error=%v", message.ID, err)
+		}
+		processed++
+	}
+	return processed
+}
+
+var (
+	emailDispatcher     *EmailDispatcher
+	emailDispatcherOnce sync.Once
+)
+
+// EmailDispatcher 邮件发送队列调度器
+type EmailDispatcher struct {
+	db       *gorm.DB
+	wake     chan struct{}
+	stopChan chan struct{}
+	running  bool
+	mu       sync.Mutex
+}
+
+// GetEmailDispatcher 获取邮件调度器单例
+func GetEmailDispatcher(db *gorm.DB) *EmailDispatcher {
+	emailDispatcherOnce.Do(func() {
+		emailDispatcher = &EmailDispatcher{
+			db:   db,
+			wake: make(chan struct{}, 1),
+		}
+	})
+	return emailDispatcher
+}
+
+// Start 启动发送队列处理
+func (d *EmailDispatcher) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		return
+	}
+	d.running = true
+	d.stopChan = make(chan struct{})
+
+	// 恢复进程中断时未完成的发送
+	d.db.Model(&model.EmailMessage{}).
+		Where("status = ? AND updated_at < ?", EmailStatusSending, time.Now().Add(-emailStaleSending)).
+		Update("status", EmailStatusPending)
+
+	go d.loop(d.stopChan)
+	if Logger != nil {
+		Logger.Info("[Email] Email dispatcher started")
+	}
+}
+
+// Stop 停止发送队列处理
+func (d *EmailDispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.running {
+		return
+	}
+	close(d.stopChan)
+	d.running = false
+}
+
+// Wake 唤醒调度器立即处理队列
+func (d *EmailDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *EmailDispatcher) loop(stop chan struct{}) {
+	ticker := time.NewTicker(emailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		ProcessEmailQueue(d.db)
+	}
+}
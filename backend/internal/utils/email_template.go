@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+)
+
+// 邮件模板类别
+const (
+	EmailTemplateAssignment     = "assignment"      // 指派
+	EmailTemplateStatusChange   = "status_change"   // 状态变更
+	EmailTemplateReportApproval = "report_approval" // 报告审批
+	EmailTemplateDueDate        = "due_date"        // 到期提醒
+	EmailTemplateGeneral        = "general"         // 其他通知
+)
+
+// EmailTemplateData 通知邮件模板数据
+type EmailTemplateData struct {
+	RecipientName string
+	ActorName     string
+	Title         string
+	Content       string
+	Link          string
+	Time          time.Time
+}
+
+// DigestEmailData 每日摘要邮件模板数据
+type DigestEmailData struct {
+	RecipientName string
+	Date          string
+	Items         []DigestEmailItem
+}
+
+// DigestEmailItem 摘要中的一条通知
+type DigestEmailItem struct {
+	Subject string
+	Text    string
+	Link    string
+	Time    string
+}
+
+// emailIntros 各类别邮件的引导语（HTML和纯文本共用）
+var emailIntros = map[string]string{
+	EmailTemplateAssignment:     `{{if .ActorName}}{{.ActorName}} {{end}}将以下工作指派给了你：`,
+	EmailTemplateStatusChange:   `{{if .ActorName}}{{.ActorName}} {{end}}更新了你关注的工作状态：`,
+	EmailTemplateReportApproval: `你有一条报告审批通知：`,
+	EmailTemplateDueDate:        `以下工作即将到期，请及时处理：`,
+	EmailTemplateGeneral:        `你有一条新通知：`,
+}
+
+const emailHTMLLayout = `<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, 'Microsoft YaHei', sans-serif; color: #333; line-height: 1.6;">
+<p>{{.RecipientName}}，你好：</p>
+<p>{{template "intro" .}}</p>
+<h3 style="margin: 12px 0;">{{if .Link}}<a href="{{.Link}}" style="color: #1677ff;">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h3>
+{{if .Content}}<div style="white-space: pre-wrap; padding: 8px 12px; background: #f5f5f5; border-radius: 4px;">{{.Content}}</div>{{end}}
+<p style="color: #999; font-size: 12px;">{{.Time.Format "2006-01-02 15:04"}} · 此邮件由 PrjFlow 自动发送，可在通知设置中调整接收方式。</p>
+</body>
+</html>`
+
+const emailTextLayout = `{{.RecipientName}}，你好：
+
+{{template "intro" .}}
+
+{{.Title}}
+{{if .Content}}
+{{.Content}}
+{{end}}{{if .Link}}
+查看详情：{{.Link}}
+{{end}}
+--
+{{.Time.Format "2006-01-02 15:04"}} · 此邮件由 PrjFlow 自动发送，可在通知设置中调整接收方式。
+`
+
+const digestHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, 'Microsoft YaHei', sans-serif; color: #333; line-height: 1.6;">
+<p>{{.RecipientName}}，你好：</p>
+<p>以下是你在 {{.Date}} 的通知摘要，共 {{len .Items}} 条：</p>
+<ul>
+{{range .Items}}<li style="margin-bottom: 8px;">{{if .Link}}<a href="{{.Link}}" style="color: #1677ff;">{{.Subject}}</a>{{else}}{{.Subject}}{{end}} <span style="color: #999; font-size: 12px;">{{.Time}}</span>{{if .Text}}<br><span style="color: #666;">{{.Text}}</span>{{end}}</li>
+{{end}}</ul>
+<p style="color: #999; font-size: 12px;">此邮件由 PrjFlow 自动发送，可在通知设置中调整接收方式。</p>
+</body>
+</html>`
+
+const digestTextTemplate = `{{.RecipientName}}，你好：
+
+以下是你在 {{.Date}} 的通知摘要，共 {{len .Items}} 条：
+{{range .Items}}
+- {{.Subject}}（{{.Time}}）{{if .Text}}
+  {{.Text}}{{end}}{{if .Link}}
+  {{.Link}}{{end}}
+{{end}}
+--
+此邮件由 PrjFlow 自动发送，可在通知设置中调整接收方式。
+`
+
+var (
+	emailHTMLTemplates = make(map[string]*htmltemplate.Template)
+	emailTextTemplates = make(map[string]*texttemplate.Template)
+	digestHTML         = htmltemplate.Must(htmltemplate.New("digest").Parse(digestHTMLTemplate))
+	digestText         = texttemplate.Must(texttemplate.New("digest").Parse(digestTextTemplate))
+)
+
+func init() {
+	for category, intro := range emailIntros {
+		emailHTMLTemplates[category] = htmltemplate.Must(htmltemplate.Must(htmltemplate.New(category).Parse(emailHTMLLayout)).New("intro").Parse(intro))
+		emailTextTemplates[category] = texttemplate.Must(texttemplate.Must(texttemplate.New(category).Parse(emailTextLayout)).New("intro").Parse(intro))
+	}
+}
+
+// EmailTemplateCategory 根据通知类型获取邮件模板类别
+func EmailTemplateCategory(eventType string) string {
+	switch {
+	case strings.HasSuffix(eventType, ".assigned"):
+		return EmailTemplateAssignment
+	case strings.HasSuffix(eventType, ".status_changed"):
+		return EmailTemplateStatusChange
+	case strings.HasPrefix(eventType, "report."):
+		return EmailTemplateReportApproval
+	case strings.HasSuffix(eventType, ".due_soon"):
+		return EmailTemplateDueDate
+	}
+	return EmailTemplateGeneral
+}
+
+// RenderNotificationEmail 渲染通知邮件，返回主题、HTML正文和纯文本正文
+func RenderNotificationEmail(eventType string, data EmailTemplateData) (string, string, string, error) {
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+	category := EmailTemplateCategory(eventType)
+
+	var htmlBuf, textBuf bytes.Buffer
+	if err := emailHTMLTemplates[category].ExecuteTemplate(&htmlBuf, category, data); err != nil {
+		return "", "", "", err
+	}
+	if err := emailTextTemplates[category].ExecuteTemplate(&textBuf, category, data); err != nil {
+		return "", "", "", err
+	}
+	return "[PrjFlow] " + data.Title, htmlBuf.String(), textBuf.String(), nil
+}
+
+// RenderDigestEmail 渲染每日摘要邮件，返回主题、HTML正文和纯文本正文
+func RenderDigestEmail(recipientName string, date time.Time, messages []model.EmailMessage) (string, string, string, error) {
+	data := DigestEmailData{
+		RecipientName: recipientName,
+		Date:          date.Format("2006-01-02"),
+	}
+	for _, message := range messages {
+		data.Items = append(data.Items, DigestEmailItem{
+			Subject: strings.TrimPrefix(message.Subject, "[PrjFlow] "),
+			Text:    digestSnippet(message.Summary),
+			Link:    message.Link,
+			Time:    message.CreatedAt.Format("01-02 15:04"),
+		})
+	}
+
+	var htmlBuf, textBuf bytes.Buffer
+	if err := digestHTML.Execute(&htmlBuf, data); err != nil {
+		return "", "", "", err
+	}
+	if err := digestText.Execute(&textBuf, data); err != nil {
+		return "", "", "", err
+	}
+	subject := "[PrjFlow] 通知摘要（" + data.Date + "）"
+	return subject, htmlBuf.String(), textBuf.String(), nil
+}
+
+// digestSnippet 截断摘要中的通知内容
+func digestSnippet(text string) string {
+	snippet := strings.Join(strings.Fields(text), " ")
+	if runes := []rune(snippet); len(runes) > 120 {
+		snippet = string(runes[:120]) + "..."
+	}
+	return snippet
+}
+
+// NotificationLink 生成通知关联对象的访问链接（未配置系统访问地址时返回空）
+func NotificationLink(objectType string, objectID uint) string {
+	if config.AppConfig == nil || config.AppConfig.Email.SiteURL == "" || objectID == 0 {
+		return ""
+	}
+	base := strings.TrimRight(config.AppConfig.Email.SiteURL, "/")
+	switch objectType {
+	case "bug", "task", "requirement":
+		return base + "/" + objectType + "/" + strconv.FormatUint(uint64(objectID), 10)
+	case "daily_report":
+		return base + "/reports/daily/" + strconv.FormatUint(uint64(objectID), 10)
+	case "weekly_report":
+		return base + "/reports"
+	}
+	return base
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fieldChange 从 Action/History 还原的一次字段变更
+type fieldChange struct {
+	at  time.Time
+	old string
+	new string
+}
+
+// fieldTimeline 字段的当前值和按时间排序的变更记录，用于还原任意时刻的字段值
+type fieldTimeline struct {
+	current string
+	changes []fieldChange
+}
+
+// valueAt 还原某一时刻之前的字段值：取该时刻之后第一条变更的旧值，没有变更则为当前值
+func (f *fieldTimeline) valueAt(t time.Time) string {
+	for _, change := range f.changes {
+		if !change.at.Before(t) {
+			return change.old
+		}
+	}
+	return f.current
+}
+
+// initial 创建时的字段值
+func (f *fieldTimeline) initial() string {
+	if len(f.changes) > 0 {
+		return f.changes[0].old
+	}
+	return f.current
+}
+
+// objectKey 对象的唯一标识，如 task:1
+func objectKey(objectType string, objectID uint) string {
+	return objectType + ":" + strconv.FormatUint(uint64(objectID), 10)
+}
+
+// loadFieldChanges 加载对象指定字段的变更记录，按操作时间排序
+// objectIDs 为 对象类型 -> ID列表，返回 objectKey -> 字段 -> 变更列表
+func loadFieldChanges(db *gorm.DB, objectIDs map[string][]uint, fields []string) (map[string]map[string][]fieldChange, error) {
+	result := make(map[string]map[string][]fieldChange)
+	for objectType, ids := range objectIDs {
+		if len(ids) == 0 {
+			continue
+		}
+		var rows []struct {
+			ObjectID uint
+			Date     time.Time
+			Field    string
+			Old      string
+			New      string
+		}
+		if err := db.Table("histories").
+			Select("actions.object_id, actions.date, histories.field, histories.old, histories.new").
+			Joins("JOIN actions ON actions.id = histories.action_id").
+			Where("actions.object_type = ? AND actions.object_id IN ? AND histories.field IN ?", objectType, ids, fields).
+			Order("actions.date, histories.id").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			key := objectKey(objectType, row.ObjectID)
+			if result[key] == nil {
+				result[key] = make(map[string][]fieldChange)
+			}
+			result[key][row.Field] = append(result[key][row.Field], fieldChange{at: row.Date, old: row.Old, new: row.New})
+		}
+	}
+	return result, nil
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"sort"
+
 	"prjflow/internal/model"
+	"prjflow/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -45,12 +48,17 @@ func GetUserID(c *gin.Context) uint {
 	return id
 }
 
-// GetUserProjectIDs 获取用户参与的项目ID列表（优化：一次查询，可缓存）
+// GetUserProjectIDs 获取用户参与的项目ID列表（启用权限缓存时从缓存读取）
 func GetUserProjectIDs(db *gorm.DB, userID uint) []uint {
-	var projectIDs []uint
-	db.Model(&model.ProjectMember{}).
-		Where("user_id = ?", userID).
-		Pluck("project_id", &projectIDs)
+	projects, err := permission.GetUserProjectRoles(db, userID)
+	if err != nil {
+		return nil
+	}
+	projectIDs := make([]uint, 0, len(projects))
+	for projectID := range projects {
+		projectIDs = append(projectIDs, projectID)
+	}
+	sort.Slice(projectIDs, func(i, j int) bool { return projectIDs[i] < projectIDs[j] })
 	return projectIDs
 }
 
@@ -193,13 +201,29 @@ func CheckProjectAccess(db *gorm.DB, c *gin.Context, projectID uint) bool {
 		return false
 	}
 
-	// 检查用户是否是项目成员（GORM会自动处理软删除，但为了明确性，显式检查deleted_at）
-	var count int64
-	db.Model(&model.ProjectMember{}).
-		Where("project_id = ? AND user_id = ? AND deleted_at IS NULL", projectID, userID).
-		Count(&count)
+	// 检查用户是否是项目成员（启用权限缓存时从缓存读取）
+	_, isMember := GetProjectMemberRole(db, projectID, userID)
+	return isMember
+}
 
-	return count > 0
+// UserCanAccessProject 检查指定用户（而非当前请求用户）是否可以访问项目：管理员或项目成员
+func UserCanAccessProject(db *gorm.DB, userID, projectID uint) bool {
+	if userID == 0 {
+		return false
+	}
+	if _, isMember := GetProjectMemberRole(db, projectID, userID); isMember {
+		return true
+	}
+	roles, err := userRoleCodes(db, userID)
+	if err != nil {
+		return false
+	}
+	for _, role := range roles {
+		if role == AdminRoleCode {
+			return true
+		}
+	}
+	return false
 }
 
 // CheckRequirementAccess 检查用户是否有权限访问需求
@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 工作流状态分类
+const (
+	WorkflowCategoryOpen  = "open"
+	WorkflowCategoryDoing = "doing"
+	WorkflowCategoryDone  = "done"
+
+	WorkflowCategoryCancelled = "cancelled" // 已取消：工作项已结束但没有交付
+)
+
+// FlowMetricsDefaultDays 未指定统计区间时默认统计最近的天数
+const FlowMetricsDefaultDays = 30
+
+// flowPercentiles 周期时间和前置时间统计的百分位
+var flowPercentiles = []float64{50, 75, 85, 95}
+
+// FlowBand 累积流图的一个区带，可以包含多个状态（如看板的一列）
+type FlowBand struct {
+	Key      string   `json:"key"`
+	Name     string   `json:"name"`
+	Statuses []string `json:"statuses"`
+}
+
+// FlowMetricsScope 流动指标统计范围，Bands 为空时按工作流状态划分区带
+type FlowMetricsScope struct {
+	ObjectType string
+	ProjectID  uint
+	StartDate  time.Time
+	EndDate    time.Time
+	Bands      []FlowBand
+}
+
+// CumulativeFlowPoint 累积流图的一天，Counts 为各区带在当天结束时的工作项数量
+type CumulativeFlowPoint struct {
+	Date   string         `json:"date"`
+	Counts map[string]int `json:"counts"`
+}
+
+// CumulativeFlow 累积流图数据
+type CumulativeFlow struct {
+	Bands  []FlowBand            `json:"bands"`
+	Points []CumulativeFlowPoint `json:"points"`
+}
+
+// DurationBucket 耗时分布（按天取整）
+type DurationBucket struct {
+	Days  int `json:"days"`
+	Count int `json:"count"`
+}
+
+// DurationStats 耗时统计（单位：天）
+type DurationStats struct {
+	Count       int                `json:"count"`
+	Average     float64            `json:"average"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles"` // p50, p75, p85, p95
+	Histogram   []DurationBucket   `json:"histogram"`
+}
+
+// StatusDuration 已完成工作项在各状态停留的平均时间，用于发现瓶颈
+type StatusDuration struct {
+	Status      string  `json:"status"`
+	Category    string  `json:"category"`
+	Items       int     `json:"items"`        // 经过该状态的工作项数量
+	AverageDays float64 `json:"average_days"` // 平均停留天数
+	TotalDays   float64 `json:"total_days"`
+}
+
+// FlowMetrics 流动指标：累积流图、周期时间、前置时间和状态停留时间
+type FlowMetrics struct {
+	ObjectType      string           `json:"object_type"`
+	StartDate       string           `json:"start_date"`
+	EndDate         string           `json:"end_date"`
+	CumulativeFlow  CumulativeFlow   `json:"cumulative_flow"`
+	CycleTime       DurationStats    `json:"cycle_time"` // 首次进入进行中状态 -> 首次进入完成状态
+	LeadTime        DurationStats    `json:"lead_time"`  // 创建 -> 首次进入完成状态
+	StatusDurations []StatusDuration `json:"status_durations"`
+}
+
+// flowItem 参与统计的工作项及其状态变更
+type flowItem struct {
+	createdAt time.Time
+	deletedAt *time.Time
+	status    fieldTimeline
+}
+
+// flowEvent 工作项进入某个状态
+type flowEvent struct {
+	at     time.Time
+	status string
+}
+
+// events 按时间顺序返回工作项进入的各个状态（第一个为创建时的状态）
+func (item *flowItem) events() []flowEvent {
+	events := []flowEvent{{at: item.createdAt, status: item.status.initial()}}
+	for _, change := range item.status.changes {
+		events = append(events, flowEvent{at: change.at, status: change.new})
+	}
+	return events
+}
+
+// BuildFlowMetrics 回放 History 中的状态变更，计算累积流图和周期时间/前置时间分布
+// 状态分类（未开始/进行中/已完成/已取消）取项目生效的工作流；周期时间和前置时间统计在区间内完成的工作项
+func BuildFlowMetrics(db *gorm.DB, scope FlowMetricsScope) (*FlowMetrics, error) {
+	start, end := startOfDay(scope.StartDate), startOfDay(scope.EndDate)
+	if end.Before(start) || end.Sub(start) > BurnChartMaxDays*24*time.Hour {
+		return nil, ErrBurnChartRange
+	}
+	if scope.ObjectType != SprintItemTask && scope.ObjectType != SprintItemBug {
+		return nil, errors.New("只支持统计任务和Bug")
+	}
+
+	categories, bands := flowWorkflowStates(db, scope.ObjectType, scope.ProjectID)
+	if len(scope.Bands) > 0 {
+		bands = scope.Bands
+	}
+	items, err := loadFlowItems(db, scope.ObjectType, scope.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	categoryOf := func(status string) string {
+		if category, ok := categories[status]; ok {
+			return category
+		}
+		return WorkflowCategoryOpen
+	}
+
+	metrics := &FlowMetrics{
+		ObjectType:     scope.ObjectType,
+		StartDate:      start.Format("2006-01-02"),
+		EndDate:        end.Format("2006-01-02"),
+		CumulativeFlow: CumulativeFlow{Bands: bands, Points: make([]CumulativeFlowPoint, 0)},
+	}
+
+	// 累积流图：每天结束时各区带的工作项数量
+	bandOf := make(map[string]string)
+	for _, band := range bands {
+		for _, status := range band.Statuses {
+			bandOf[status] = band.Key
+		}
+	}
+	today := startOfDay(time.Now())
+	for day := start; !day.After(end) && !day.After(today); day = day.AddDate(0, 0, 1) {
+		at := day.AddDate(0, 0, 1)
+		point := CumulativeFlowPoint{Date: day.Format("2006-01-02"), Counts: make(map[string]int, len(bands))}
+		for _, band := range bands {
+			point.Counts[band.Key] = 0
+		}
+		for _, item := range items {
+			if !item.createdAt.Before(at) || (item.deletedAt != nil && item.deletedAt.Before(at)) {
+				continue
+			}
+			if key, ok := bandOf[item.status.valueAt(at)]; ok {
+				point.Counts[key]++
+			}
+		}
+		metrics.CumulativeFlow.Points = append(metrics.CumulativeFlow.Points, point)
+	}
+
+	// 周期时间、前置时间和状态停留时间：只统计在区间内完成的工作项。
+	// 取消（cancelled 分类）不算交付，取消后未重新打开并完成的工作项不参与统计
+	rangeEnd := end.AddDate(0, 0, 1)
+	var cycleTimes, leadTimes []float64
+	statusTotals := make(map[string]*StatusDuration)
+	for _, item := range items {
+		events := item.events()
+		doneIndex := -1
+		var cycleStart *time.Time
+		for i, event := range events {
+			category := categoryOf(event.status)
+			if category == WorkflowCategoryDoing && cycleStart == nil {
+				cycleStart = &events[i].at
+			}
+			if category == WorkflowCategoryDone {
+				doneIndex = i
+				break
+			}
+		}
+		if doneIndex < 0 {
+			continue
+		}
+		doneAt := events[doneIndex].at
+		if doneAt.Before(start) || !doneAt.Before(rangeEnd) {
+			continue
+		}
+
+		leadTimes = append(leadTimes, doneAt.Sub(item.createdAt).Hours()/24)
+		if cycleStart != nil {
+			cycleTimes = append(cycleTimes, doneAt.Sub(*cycleStart).Hours()/24)
+		}
+
+		visited := make(map[string]bool)
+		for i := 0; i < doneIndex; i++ {
+			status := events[i].status
+			total := statusTotals[status]
+			if total == nil {
+				total = &StatusDuration{Status: status, Category: categoryOf(status)}
+				statusTotals[status] = total
+			}
+			total.TotalDays += events[i+1].at.Sub(events[i].at).Hours() / 24
+			if !visited[status] {
+				visited[status] = true
+				total.Items++
+			}
+		}
+	}
+	metrics.CycleTime = NewDurationStats(cycleTimes)
+	metrics.LeadTime = NewDurationStats(leadTimes)
+
+	metrics.StatusDurations = make([]StatusDuration, 0, len(statusTotals))
+	for _, total := range statusTotals {
+		total.AverageDays = roundTwoDecimals(total.TotalDays / float64(total.Items))
+		total.TotalDays = roundTwoDecimals(total.TotalDays)
+		metrics.StatusDurations = append(metrics.StatusDurations, *total)
+	}
+	sort.Slice(metrics.StatusDurations, func(i, j int) bool {
+		a, b := metrics.StatusDurations[i], metrics.StatusDurations[j]
+		if a.AverageDays != b.AverageDays {
+			return a.AverageDays > b.AverageDays
+		}
+		return a.Status < b.Status
+	})
+	return metrics, nil
+}
+
+// NewDurationStats 计算耗时的平均值、最值、百分位和按天分布
+func NewDurationStats(days []float64) DurationStats {
+	stats := DurationStats{
+		Count:       len(days),
+		Percentiles: make(map[string]float64, len(flowPercentiles)),
+		Histogram:   make([]DurationBucket, 0),
+	}
+	if len(days) == 0 {
+		return stats
+	}
+
+	sorted := append([]float64(nil), days...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	buckets := make(map[int]int)
+	for _, value := range sorted {
+		sum += value
+		buckets[int(math.Floor(value))]++
+	}
+	stats.Average = roundTwoDecimals(sum / float64(len(sorted)))
+	stats.Min = roundTwoDecimals(sorted[0])
+	stats.Max = roundTwoDecimals(sorted[len(sorted)-1])
+	for _, p := range flowPercentiles {
+		stats.Percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = roundTwoDecimals(Percentile(sorted, p))
+	}
+	for day, count := range buckets {
+		stats.Histogram = append(stats.Histogram, DurationBucket{Days: day, Count: count})
+	}
+	sort.Slice(stats.Histogram, func(i, j int) bool { return stats.Histogram[i].Days < stats.Histogram[j].Days })
+	return stats
+}
+
+// Percentile 计算已排序数据的百分位（线性插值，与 Excel PERCENTILE.INC 一致）
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if upper >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// flowWorkflowStates 项目生效工作流的状态分类，以及按状态划分的默认区带
+func flowWorkflowStates(db *gorm.DB, objectType string, projectID uint) (map[string]string, []FlowBand) {
+	categories := make(map[string]string)
+	bands := make([]FlowBand, 0)
+	workflow := GetWorkflow(db, objectType, projectID)
+	if workflow == nil {
+		return categories, bands
+	}
+	for _, state := range workflow.States {
+		categories[state.Code] = state.Category
+		bands = append(bands, FlowBand{Key: state.Code, Name: state.Name, Statuses: []string{state.Code}})
+	}
+	return categories, bands
+}
+
+// loadFlowItems 加载项目的任务或Bug（包括已删除的）及其状态变更
+func loadFlowItems(db *gorm.DB, objectType string, projectID uint) ([]*flowItem, error) {
+	type row struct {
+		ID        uint
+		Status    string
+		CreatedAt time.Time
+		DeletedAt gorm.DeletedAt
+	}
+	var rows []row
+	var itemModel interface{} = &model.Task{}
+	if objectType == SprintItemBug {
+		itemModel = &model.Bug{}
+	}
+	if err := db.Unscoped().Model(itemModel).Select("id, status, created_at, deleted_at").
+		Where("project_id = ?", projectID).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+	}
+	changes, err := loadFieldChanges(db, map[string][]uint{objectType: ids}, []string{"status"})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*flowItem, 0, len(rows))
+	for _, r := range rows {
+		item := &flowItem{
+			createdAt: r.CreatedAt,
+			status:    fieldTimeline{current: r.Status, changes: changes[objectKey(objectType, r.ID)]["status"]},
+		}
+		if r.DeletedAt.Valid {
+			item.deletedAt = &r.DeletedAt.Time
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
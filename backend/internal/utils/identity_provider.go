@@ -0,0 +1,391 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/pkg/oidc"
+	"prjflow/pkg/wechat"
+
+	"gorm.io/gorm"
+)
+
+// 身份提供方
+const (
+	IdentityProviderWeChat = "wechat"
+	IdentityProviderOIDC   = "oidc"
+)
+
+// 授权请求用途
+const (
+	IdentityPurposeLogin = "login" // 登录
+	IdentityPurposeBind  = "bind"  // 已登录用户绑定外部账号
+)
+
+// AuthSourceOIDC 通过OIDC自动创建的账号来源
+const AuthSourceOIDC = "oidc"
+
+// IdentityAuthRequestTTL 授权请求有效期
+const IdentityAuthRequestTTL = 10 * time.Minute
+
+var (
+	ErrIdentityProviderDisabled = errors.New("身份提供方未启用")
+	ErrIdentityStateInvalid     = errors.New("授权请求无效或已过期，请重新登录")
+	ErrIdentityNotLinked        = errors.New("用户不存在，请联系管理员添加用户")
+	ErrIdentityUsernameTaken    = errors.New("用户名已被其他账号使用，请联系管理员")
+)
+
+// ExternalIdentity 身份提供方返回的用户身份
+type ExternalIdentity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"` // 身份提供方内的唯一标识：微信OpenID、OIDC sub
+	Username string `json:"username"`
+	Nickname string `json:"nickname"`
+	Email    string `json:"email"`
+	Avatar   string `json:"avatar"`
+}
+
+// IdentityProvider 外部身份提供方（微信、OIDC等），负责生成授权地址和用授权码换取用户身份
+// state、nonce 和 PKCE 验证码由 model.IdentityAuthRequest 保存，回调时原样传回
+type IdentityProvider interface {
+	// Name 身份提供方标识，同时作为路由参数和 UserIdentity.Provider
+	Name() string
+	// DisplayName 登录页显示的名称
+	DisplayName() string
+	// AuthURL 生成跳转到身份提供方的授权地址
+	AuthURL(req *model.IdentityAuthRequest) (string, error)
+	// Exchange 用回调中的授权码换取用户身份
+	Exchange(code string, req *model.IdentityAuthRequest) (*ExternalIdentity, error)
+}
+
+// WeChatIdentityProvider 微信网页授权（不支持 PKCE 和 nonce，依赖一次性 state 防止伪造回调）
+type WeChatIdentityProvider struct {
+	Client      wechat.WeChatClientInterface
+	RedirectURI string
+}
+
+var _ IdentityProvider = (*WeChatIdentityProvider)(nil)
+
+func (p *WeChatIdentityProvider) Name() string        { return IdentityProviderWeChat }
+func (p *WeChatIdentityProvider) DisplayName() string { return "微信" }
+
+func (p *WeChatIdentityProvider) AuthURL(req *model.IdentityAuthRequest) (string, error) {
+	qrCode, err := p.Client.GetQRCode(p.RedirectURI, req.State)
+	if err != nil {
+		return "", err
+	}
+	return qrCode.URL, nil
+}
+
+func (p *WeChatIdentityProvider) Exchange(code string, req *model.IdentityAuthRequest) (*ExternalIdentity, error) {
+	accessToken, err := p.Client.GetAccessToken(code)
+	if err != nil {
+		return nil, fmt.Errorf("获取access_token失败: %w", err)
+	}
+	userInfo, err := p.Client.GetUserInfo(accessToken.AccessToken, accessToken.OpenID)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户信息失败: %w", err)
+	}
+	return WeChatIdentity(userInfo), nil
+}
+
+// WeChatIdentity 将微信用户信息转换为外部身份
+func WeChatIdentity(userInfo *wechat.UserInfoResponse) *ExternalIdentity {
+	return &ExternalIdentity{
+		Provider: IdentityProviderWeChat,
+		Subject:  userInfo.OpenID,
+		Nickname: userInfo.Nickname,
+		Avatar:   userInfo.HeadImgURL,
+	}
+}
+
+// OIDCIdentityProvider OpenID Connect 授权码流程（PKCE + nonce）
+type OIDCIdentityProvider struct {
+	client *oidc.Client
+	cfg    config.OIDCConfig
+}
+
+var _ IdentityProvider = (*OIDCIdentityProvider)(nil)
+
+var (
+	oidcProviderMu sync.Mutex
+	oidcProvider   *OIDCIdentityProvider
+)
+
+// GetOIDCProvider 获取OIDC身份提供方，配置不变时复用客户端（缓存发现结果和签名密钥）
+func GetOIDCProvider() (*OIDCIdentityProvider, error) {
+	var cfg config.OIDCConfig
+	if config.AppConfig != nil {
+		cfg = config.AppConfig.OIDC
+	}
+	if !cfg.Enabled || cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, ErrIdentityProviderDisabled
+	}
+
+	oidcProviderMu.Lock()
+	defer oidcProviderMu.Unlock()
+	if oidcProvider == nil || !reflect.DeepEqual(oidcProvider.cfg, cfg) {
+		oidcProvider = &OIDCIdentityProvider{
+			client: oidc.NewClient(oidc.Config{
+				Issuer:       cfg.Issuer,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				RedirectURL:  cfg.RedirectURL,
+				Scopes:       cfg.Scopes,
+			}, nil),
+			cfg: cfg,
+		}
+	}
+	return oidcProvider, nil
+}
+
+func (p *OIDCIdentityProvider) Name() string { return IdentityProviderOIDC }
+
+func (p *OIDCIdentityProvider) DisplayName() string {
+	if p.cfg.Name != "" {
+		return p.cfg.Name
+	}
+	return "企业账号"
+}
+
+func (p *OIDCIdentityProvider) AuthURL(req *model.IdentityAuthRequest) (string, error) {
+	return p.client.AuthCodeURL(req.State, req.Nonce, oidc.CodeChallengeS256(req.CodeVerifier))
+}
+
+func (p *OIDCIdentityProvider) Exchange(code string, req *model.IdentityAuthRequest) (*ExternalIdentity, error) {
+	token, err := p.client.Exchange(code, req.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := p.client.VerifyIDToken(token.IDToken, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	usernameClaim := p.cfg.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	username, _ := idToken.Claims[usernameClaim].(string)
+	return &ExternalIdentity{
+		Provider: IdentityProviderOIDC,
+		Subject:  idToken.Subject,
+		Username: username,
+		Nickname: idToken.Name,
+		Email:    idToken.Email,
+		Avatar:   idToken.Picture,
+	}, nil
+}
+
+// NewIdentityAuthRequest 创建授权请求，生成一次性的 state、nonce 和 PKCE 验证码
+func NewIdentityAuthRequest(db *gorm.DB, provider, purpose string, userID uint) (*model.IdentityAuthRequest, error) {
+	state, err := oidc.GenerateRandomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := oidc.GenerateRandomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := oidc.GenerateRandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	// 顺带清理过期的请求
+	db.Where("expires_at < ?", time.Now()).Delete(&model.IdentityAuthRequest{})
+
+	req := &model.IdentityAuthRequest{
+		State:        state,
+		Provider:     provider,
+		Purpose:      purpose,
+		UserID:       userID,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(IdentityAuthRequestTTL),
+	}
+	if err := db.Create(req).Error; err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ConsumeIdentityAuthRequest 按 state 取出授权请求并删除，state 不存在、已使用或已过期时返回 ErrIdentityStateInvalid
+func ConsumeIdentityAuthRequest(db *gorm.DB, provider, state string) (*model.IdentityAuthRequest, error) {
+	if state == "" {
+		return nil, ErrIdentityStateInvalid
+	}
+	var req model.IdentityAuthRequest
+	if err := db.Where("state = ? AND provider = ?", state, provider).First(&req).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrIdentityStateInvalid
+		}
+		return nil, err
+	}
+	// 以删除成功作为取得所有权的依据，防止并发回调重复使用同一个 state
+	result := db.Where("id = ?", req.ID).Delete(&model.IdentityAuthRequest{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(req.ExpiresAt) {
+		return nil, ErrIdentityStateInvalid
+	}
+	return &req, nil
+}
+
+// FindUserByIdentity 查找与外部身份关联的用户，未关联时返回 gorm.ErrRecordNotFound
+func FindUserByIdentity(db *gorm.DB, identity *ExternalIdentity) (*model.User, error) {
+	var user model.User
+	if identity.Provider == IdentityProviderWeChat {
+		if err := db.Where("wechat_open_id = ? AND wechat_open_id IS NOT NULL", identity.Subject).First(&user).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+
+	var link model.UserIdentity
+	if err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error; err != nil {
+		return nil, err
+	}
+	if err := db.First(&user, link.UserID).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	db.Model(&link).Updates(map[string]interface{}{"last_login_at": &now, "email": identity.Email, "name": identity.Nickname})
+	return &user, nil
+}
+
+// LinkIdentity 将外部身份绑定到用户，已被其他用户绑定时返回错误
+// 已删除用户占用的身份会被释放，与微信绑定的处理方式一致
+func LinkIdentity(db *gorm.DB, user *model.User, identity *ExternalIdentity) error {
+	if identity.Provider == IdentityProviderWeChat {
+		return linkWeChatIdentity(db, user, identity)
+	}
+
+	var existing model.UserIdentity
+	err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&existing).Error
+	if err == nil {
+		if existing.UserID == user.ID {
+			return nil
+		}
+		var owner model.User
+		if err := db.Unscoped().First(&owner, existing.UserID).Error; err == nil && !owner.DeletedAt.Valid {
+			return fmt.Errorf("该账号已被用户 %s 绑定，无法重复绑定", owner.Username)
+		}
+		if err := db.Delete(&existing).Error; err != nil {
+			return err
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var count int64
+	db.Model(&model.UserIdentity{}).Where("user_id = ? AND provider = ?", user.ID, identity.Provider).Count(&count)
+	if count > 0 {
+		return errors.New("您已绑定该身份提供方的账号，请先解绑后再绑定")
+	}
+
+	return db.Create(&model.UserIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		Name:     identity.Nickname,
+	}).Error
+}
+
+// linkWeChatIdentity 微信绑定写入 users.wechat_open_id
+func linkWeChatIdentity(db *gorm.DB, user *model.User, identity *ExternalIdentity) error {
+	var existing model.User
+	if err := db.Unscoped().Where("wechat_open_id = ? AND id != ?", identity.Subject, user.ID).First(&existing).Error; err == nil {
+		if !existing.DeletedAt.Valid {
+			return fmt.Errorf("该微信已被用户 %s 绑定，无法重复绑定", existing.Username)
+		}
+		// 软删除的用户，清理其 wechat_open_id
+		if err := db.Unscoped().Model(&existing).Update("wechat_open_id", nil).Error; err != nil {
+			return fmt.Errorf("清理已删除用户的微信绑定失败: %w", err)
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	openID := identity.Subject
+	user.WeChatOpenID = &openID
+	if identity.Avatar != "" {
+		user.Avatar = identity.Avatar
+	}
+	if err := db.Save(user).Error; err != nil {
+		if IsUniqueConstraintOnField(err, "wechat_open_id") {
+			return errors.New("该微信已被其他用户绑定，无法重复绑定")
+		}
+		return err
+	}
+	return nil
+}
+
+// UnlinkIdentity 解除用户与身份提供方的绑定
+func UnlinkIdentity(db *gorm.DB, userID uint, provider string) (bool, error) {
+	if provider == IdentityProviderWeChat {
+		result := db.Model(&model.User{}).Where("id = ? AND wechat_open_id IS NOT NULL", userID).Update("wechat_open_id", nil)
+		return result.RowsAffected > 0, result.Error
+	}
+	result := db.Where("user_id = ? AND provider = ?", userID, provider).Delete(&model.UserIdentity{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ProvisionIdentityUser 为未关联的OIDC身份自动创建用户并绑定，角色使用配置的默认角色
+func ProvisionIdentityUser(db *gorm.DB, cfg config.OIDCConfig, identity *ExternalIdentity) (*model.User, error) {
+	username := strings.TrimSpace(identity.Username)
+	if username == "" {
+		username = identity.Email
+	}
+	if username == "" {
+		return nil, errors.New("身份提供方未返回用户名")
+	}
+
+	var count int64
+	db.Unscoped().Model(&model.User{}).Where("username = ?", username).Count(&count)
+	if count > 0 {
+		return nil, ErrIdentityUsernameTaken
+	}
+
+	nickname := identity.Nickname
+	if nickname == "" {
+		nickname = username
+	}
+	user := model.User{
+		Username:   username,
+		Nickname:   nickname,
+		Email:      identity.Email,
+		Avatar:     identity.Avatar,
+		Status:     1,
+		AuthSource: AuthSourceOIDC,
+		ExternalID: identity.Subject,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := LinkIdentity(tx, &user, identity); err != nil {
+			return err
+		}
+		if len(cfg.DefaultRoles) == 0 {
+			return nil
+		}
+		var roles []model.Role
+		if err := tx.Where("code IN ?", cfg.DefaultRoles).Find(&roles).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Association("Roles").Replace(roles)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,471 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// AuthSourceLDAP LDAP账号来源
+const AuthSourceLDAP = "ldap"
+
+// ldapPageSize 同步时分页查询的每页条数（Active Directory 默认单次最多返回1000条）
+const ldapPageSize = 500
+
+var (
+	ErrLDAPDisabled           = errors.New("未启用LDAP登录")
+	ErrLDAPInvalidCredentials = errors.New("用户名或密码错误")
+	ErrLDAPAccountConflict    = errors.New("该用户名已被本地账号使用，请联系管理员")
+	ErrLDAPSyncAborted        = errors.New("LDAP目录同步已中止")
+)
+
+// LDAPEntry 从目录中读取的用户信息
+type LDAPEntry struct {
+	DN         string   `json:"dn"`
+	Username   string   `json:"username"`
+	Nickname   string   `json:"nickname"`
+	Email      string   `json:"email"`
+	Phone      string   `json:"phone"`
+	Department string   `json:"department"`
+	Groups     []string `json:"groups"`
+}
+
+// LDAPSyncResult 目录同步结果
+type LDAPSyncResult struct {
+	Total    int `json:"total"`    // 目录中的用户数
+	Updated  int `json:"updated"`  // 更新资料的本地用户数
+	Disabled int `json:"disabled"` // 因已从目录删除而禁用的用户数
+}
+
+// GetLDAPConfig 获取LDAP配置，未配置的字段使用默认值
+func GetLDAPConfig() config.LDAPConfig {
+	var cfg config.LDAPConfig
+	if config.AppConfig != nil {
+		cfg = config.AppConfig.LDAP
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.SyncFilter == "" {
+		cfg.SyncFilter = strings.ReplaceAll(cfg.UserFilter, "%s", "*")
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = "uid"
+	}
+	if cfg.NicknameAttribute == "" {
+		cfg.NicknameAttribute = "displayName"
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.PhoneAttribute == "" {
+		cfg.PhoneAttribute = "telephoneNumber"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10
+	}
+	if cfg.SyncMaxDisable <= 0 {
+		cfg.SyncMaxDisable = 10
+	}
+	return cfg
+}
+
+// LDAPEnabled 是否启用了LDAP登录
+func LDAPEnabled() bool {
+	cfg := GetLDAPConfig()
+	return cfg.Enabled && cfg.URL != ""
+}
+
+// dialLDAP 连接LDAP服务器，并使用服务账号绑定（未配置服务账号时匿名查询）
+func dialLDAP(cfg config.LDAPConfig) (*ldap.Conn, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	conn, err := ldap.DialURL(cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("连接LDAP服务器失败: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS失败: %w", err)
+		}
+	}
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP服务账号绑定失败: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// ldapAttributes 查询用户时需要返回的属性
+func ldapAttributes(cfg config.LDAPConfig) []string {
+	attrs := []string{cfg.UsernameAttribute, cfg.NicknameAttribute, "cn", cfg.EmailAttribute, cfg.PhoneAttribute, cfg.GroupAttribute}
+	if cfg.DepartmentAttribute != "" {
+		attrs = append(attrs, cfg.DepartmentAttribute)
+	}
+	return attrs
+}
+
+func toLDAPEntry(cfg config.LDAPConfig, entry *ldap.Entry) LDAPEntry {
+	result := LDAPEntry{
+		DN:       entry.DN,
+		Username: entry.GetEqualFoldAttributeValue(cfg.UsernameAttribute),
+		Nickname: entry.GetEqualFoldAttributeValue(cfg.NicknameAttribute),
+		Email:    entry.GetEqualFoldAttributeValue(cfg.EmailAttribute),
+		Phone:    entry.GetEqualFoldAttributeValue(cfg.PhoneAttribute),
+		Groups:   entry.GetEqualFoldAttributeValues(cfg.GroupAttribute),
+	}
+	if result.Nickname == "" {
+		result.Nickname = entry.GetEqualFoldAttributeValue("cn")
+	}
+	if cfg.DepartmentAttribute != "" {
+		result.Department = entry.GetEqualFoldAttributeValue(cfg.DepartmentAttribute)
+	}
+	return result
+}
+
+// LDAPAuthenticate 在目录中查找用户并用其密码绑定，验证通过后返回用户信息
+func LDAPAuthenticate(cfg config.LDAPConfig, username, password string) (*LDAPEntry, error) {
+	if !cfg.Enabled || cfg.URL == "" {
+		return nil, ErrLDAPDisabled
+	}
+	// 空密码会被服务器当作匿名绑定而"成功"，必须在客户端拒绝
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	conn, err := dialLDAP(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := ldap.NewSearchRequest(cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, cfg.Timeout, false,
+		strings.ReplaceAll(cfg.UserFilter, "%s", ldap.EscapeFilter(username)), ldapAttributes(cfg), nil)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("查询LDAP用户失败: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		// 用户不存在或匹配到多个用户
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	entry := toLDAPEntry(cfg, result.Entries[0])
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP用户绑定失败: %w", err)
+	}
+	if entry.Username == "" {
+		entry.Username = username
+	}
+	return &entry, nil
+}
+
+// LDAPSearchUsers 查询目录中的全部用户（用于定时同步）
+func LDAPSearchUsers(cfg config.LDAPConfig) ([]LDAPEntry, error) {
+	if !cfg.Enabled || cfg.URL == "" {
+		return nil, ErrLDAPDisabled
+	}
+	conn, err := dialLDAP(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := ldap.NewSearchRequest(cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cfg.SyncFilter, ldapAttributes(cfg), nil)
+	result, err := conn.SearchWithPaging(request, ldapPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("查询LDAP用户失败: %w", err)
+	}
+	entries := make([]LDAPEntry, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		e := toLDAPEntry(cfg, entry)
+		if e.Username != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// LDAPGroupRoleCodes 根据组映射计算用户应拥有的角色代码（组可以配置为完整DN或CN，不区分大小写）
+func LDAPGroupRoleCodes(cfg config.LDAPConfig, groups []string) []string {
+	roles := append([]string{}, cfg.DefaultRoles...)
+	for _, group := range groups {
+		cn := ldapGroupCN(group)
+		for _, mapping := range cfg.GroupRoles {
+			if strings.EqualFold(mapping.Group, group) || (cn != "" && strings.EqualFold(mapping.Group, cn)) {
+				roles = append(roles, mapping.Role)
+			}
+		}
+	}
+	return dedupeStrings(roles)
+}
+
+// ldapGroupCN 从组DN中取出CN，如 cn=developers,ou=groups,dc=example,dc=com -> developers
+func ldapGroupCN(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// ProvisionLDAPUser 根据目录信息创建或更新本地用户，同步昵称、邮箱、部门和角色
+// 用户名已被本地账号占用时返回 ErrLDAPAccountConflict，避免通过目录接管本地账号
+func ProvisionLDAPUser(db *gorm.DB, cfg config.LDAPConfig, entry *LDAPEntry) (*model.User, error) {
+	var user model.User
+	err := db.Where("username = ?", entry.Username).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+	if !isNew && user.AuthSource != AuthSourceLDAP {
+		return nil, ErrLDAPAccountConflict
+	}
+
+	var departmentID *uint
+	if entry.Department != "" {
+		department, err := findOrCreateLDAPDepartment(db, entry.Department)
+		if err != nil {
+			return nil, err
+		}
+		departmentID = &department.ID
+	}
+
+	nickname := entry.Nickname
+	if nickname == "" {
+		nickname = entry.Username
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if isNew {
+			user = model.User{
+				Username:     entry.Username,
+				Nickname:     nickname,
+				Email:        entry.Email,
+				Phone:        entry.Phone,
+				Status:       1,
+				AuthSource:   AuthSourceLDAP,
+				ExternalID:   entry.DN,
+				DepartmentID: departmentID,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		} else {
+			updates := map[string]interface{}{
+				"nickname":    nickname,
+				"email":       entry.Email,
+				"phone":       entry.Phone,
+				"external_id": entry.DN,
+			}
+			if cfg.DepartmentAttribute != "" {
+				updates["department_id"] = departmentID
+			}
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		// 组映射和默认角色都未匹配到时保留现有角色，便于管理员手动分配
+		roleCodes := LDAPGroupRoleCodes(cfg, entry.Groups)
+		if len(roleCodes) == 0 {
+			return nil
+		}
+		var roles []model.Role
+		if err := tx.Where("code IN ?", roleCodes).Find(&roles).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Association("Roles").Replace(roles)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Preload("Roles").First(&user, user.ID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// findOrCreateLDAPDepartment 按名称查找部门，不存在时创建
+func findOrCreateLDAPDepartment(db *gorm.DB, name string) (*model.Department, error) {
+	var department model.Department
+	err := db.Where("name = ?", name).First(&department).Error
+	if err == nil {
+		return &department, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(name))
+	department = model.Department{
+		Name:   name,
+		Code:   "ldap_" + hex.EncodeToString(sum[:])[:12],
+		Level:  1,
+		Status: 1,
+	}
+	if err := db.Create(&department).Error; err != nil {
+		return nil, err
+	}
+	return &department, nil
+}
+
+// SyncLDAPUsers 同步目录：更新已存在的LDAP用户资料，禁用已从目录删除的用户并吊销其会话
+// 目录返回0个用户或需要禁用的用户数超过 SyncMaxDisable 时中止同步（返回 ErrLDAPSyncAborted），除非 force 为 true
+func SyncLDAPUsers(db *gorm.DB, cfg config.LDAPConfig, force bool) (*LDAPSyncResult, error) {
+	entries, err := LDAPSearchUsers(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LDAPSyncResult{Total: len(entries)}
+	inDirectory := make(map[string]*LDAPEntry, len(entries))
+	for i := range entries {
+		inDirectory[strings.ToLower(entries[i].Username)] = &entries[i]
+	}
+
+	var users []model.User
+	if err := db.Where("auth_source = ?", AuthSourceLDAP).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if !force {
+		toDisable := 0
+		for _, user := range users {
+			if _, ok := inDirectory[strings.ToLower(user.Username)]; !ok && user.Status != 0 {
+				toDisable++
+			}
+		}
+		if len(entries) == 0 && toDisable > 0 {
+			return nil, fmt.Errorf("%w: 目录中没有查询到用户，请检查LDAP配置", ErrLDAPSyncAborted)
+		}
+		if toDisable > cfg.SyncMaxDisable {
+			return nil, fmt.Errorf("%w: 需要禁用 %d 个用户，超过单次上限 %d", ErrLDAPSyncAborted, toDisable, cfg.SyncMaxDisable)
+		}
+	}
+	for _, user := range users {
+		entry, ok := inDirectory[strings.ToLower(user.Username)]
+		if !ok {
+			if user.Status == 0 {
+				continue
+			}
+			if err := db.Model(&model.User{}).Where("id = ?", user.ID).Update("status", 0).Error; err != nil {
+				return nil, err
+			}
+			if _, err := RevokeUserSessions(db, user.ID, "", SessionRevokedUserDisabled); err != nil && Logger != nil {
+				Logger.Errorf("[LDAP] 吊销用户会话失败: user_id=%d, error=%v", user.ID, err)
+			}
+			result.Disabled++
+			if Logger != nil {
+				Logger.Infof("[LDAP] 用户 %s 已从目录删除，已禁用", user.Username)
+			}
+			continue
+		}
+		entry.Username = user.Username
+		if _, err := ProvisionLDAPUser(db, cfg, entry); err != nil {
+			if Logger != nil {
+				Logger.Warnf("[LDAP] 同步用户 %s 失败: %v", user.Username, err)
+			}
+			continue
+		}
+		result.Updated++
+	}
+	return result, nil
+}
+
+var (
+	ldapSyncScheduler     *LDAPSyncScheduler
+	ldapSyncSchedulerOnce sync.Once
+)
+
+// LDAPSyncScheduler LDAP目录定时同步
+type LDAPSyncScheduler struct {
+	db   *gorm.DB
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// GetLDAPSyncScheduler 获取LDAP同步调度器单例
+func GetLDAPSyncScheduler(db *gorm.DB) *LDAPSyncScheduler {
+	ldapSyncSchedulerOnce.Do(func() {
+		ldapSyncScheduler = &LDAPSyncScheduler{db: db}
+	})
+	return ldapSyncScheduler
+}
+
+// Start 按配置的间隔定时同步，未启用LDAP或间隔为0时不启动
+func (s *LDAPSyncScheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := GetLDAPConfig()
+	if !LDAPEnabled() || cfg.SyncInterval <= 0 || s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	go s.loop(s.stop, time.Duration(cfg.SyncInterval)*time.Minute)
+	if Logger != nil {
+		Logger.Infof("[LDAP] 目录同步已启动，间隔 %d 分钟", cfg.SyncInterval)
+	}
+}
+
+// Stop 停止定时同步
+func (s *LDAPSyncScheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *LDAPSyncScheduler) loop(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			result, err := SyncLDAPUsers(s.db, GetLDAPConfig(), false)
+			if Logger == nil {
+				continue
+			}
+			if err != nil {
+				Logger.Warnf("[LDAP] 目录同步失败: %v", err)
+			} else {
+				Logger.Infof("[LDAP] 目录同步完成: 目录用户 %d, 更新 %d, 禁用 %d", result.Total, result.Updated, result.Disabled)
+			}
+		}
+	}
+}
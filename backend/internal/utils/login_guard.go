@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 登录失败统计范围
+const (
+	LoginThrottleScopeUser = "user"
+	LoginThrottleScopeIP   = "ip"
+)
+
+const (
+	defaultLoginMaxFailures     = 5
+	defaultLoginFailureWindow   = 15 // 分钟
+	defaultLoginLockDuration    = 30 // 分钟
+	defaultLoginIPMaxFailures   = 20
+	defaultLoginIPLockDuration  = 15   // 分钟
+	defaultLoginDelayBaseMillis = 1000 // 毫秒
+	defaultLoginMaxDelaySeconds = 30
+)
+
+// LoginBlockedError 登录被拒绝（账号锁定或尝试过于频繁）
+type LoginBlockedError struct {
+	Code        int           // CodeAccountLocked 或 CodeTooManyAttempts
+	Message     string        // 提示信息
+	RetryAfter  time.Duration // 需要等待的时间
+	LockedUntil *time.Time    // 账号锁定截止时间（仅账号锁定时）
+}
+
+func (e *LoginBlockedError) Error() string {
+	return e.Message
+}
+
+type loginPolicy struct {
+	maxFailures    int
+	window         time.Duration
+	lockDuration   time.Duration
+	ipMaxFailures  int
+	ipLockDuration time.Duration
+	delayBase      time.Duration
+	maxDelay       time.Duration
+}
+
+func currentLoginPolicy() loginPolicy {
+	var cfg config.LoginSecurityConfig
+	if config.AppConfig != nil {
+		cfg = config.AppConfig.LoginSecurity
+	}
+	orDefault := func(value, def int) int {
+		if value > 0 {
+			return value
+		}
+		return def
+	}
+	return loginPolicy{
+		maxFailures:    orDefault(cfg.MaxFailures, defaultLoginMaxFailures),
+		window:         time.Duration(orDefault(cfg.FailureWindow, defaultLoginFailureWindow)) * time.Minute,
+		lockDuration:   time.Duration(orDefault(cfg.LockDuration, defaultLoginLockDuration)) * time.Minute,
+		ipMaxFailures:  orDefault(cfg.IPMaxFailures, defaultLoginIPMaxFailures),
+		ipLockDuration: time.Duration(orDefault(cfg.IPLockDuration, defaultLoginIPLockDuration)) * time.Minute,
+		delayBase:      time.Duration(orDefault(cfg.DelayBaseMillis, defaultLoginDelayBaseMillis)) * time.Millisecond,
+		maxDelay:       time.Duration(orDefault(cfg.MaxDelaySeconds, defaultLoginMaxDelaySeconds)) * time.Second,
+	}
+}
+
+// LoginDelay 第 failures 次失败后需要等待的时间，按失败次数翻倍
+func LoginDelay(failures int) time.Duration {
+	policy := currentLoginPolicy()
+	if failures < 1 {
+		return 0
+	}
+	delay := policy.delayBase
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= policy.maxDelay {
+			return policy.maxDelay
+		}
+	}
+	return delay
+}
+
+// CheckLoginAllowed 检查账号和IP当前是否允许尝试登录（或验证密码）
+// username 或 ip 为空时跳过对应的检查
+func CheckLoginAllowed(db *gorm.DB, username, ip string) *LoginBlockedError {
+	now := time.Now()
+
+	if username != "" {
+		var throttle model.LoginThrottle
+		if err := db.Where("scope = ? AND identifier = ?", LoginThrottleScopeUser, username).First(&throttle).Error; err == nil {
+			if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+				return accountLockedError(*throttle.LockedUntil, now)
+			}
+			if throttle.NextAttemptAt != nil && throttle.NextAttemptAt.After(now) {
+				return tooManyAttemptsError(throttle.NextAttemptAt.Sub(now))
+			}
+		}
+	}
+
+	if ip != "" {
+		var throttle model.LoginThrottle
+		if err := db.Where("scope = ? AND identifier = ?", LoginThrottleScopeIP, ip).First(&throttle).Error; err == nil {
+			if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+				return tooManyAttemptsError(throttle.LockedUntil.Sub(now))
+			}
+		}
+	}
+	return nil
+}
+
+// RecordLoginFailure 记录一次登录失败（账号和IP分别计数）
+// 本次失败导致账号锁定或IP受限时返回对应的错误，否则返回 nil
+func RecordLoginFailure(db *gorm.DB, username, ip string) *LoginBlockedError {
+	policy := currentLoginPolicy()
+	now := time.Now()
+	var blocked *LoginBlockedError
+
+	if username != "" {
+		throttle, err := incrementLoginFailures(db, LoginThrottleScopeUser, username, policy.window, now)
+		if err == nil {
+			updates := map[string]interface{}{}
+			delay := LoginDelay(throttle.Failures)
+			nextAttempt := now.Add(delay)
+			updates["next_attempt_at"] = nextAttempt
+			if throttle.Failures >= policy.maxFailures {
+				lockedUntil := now.Add(policy.lockDuration)
+				updates["locked_until"] = lockedUntil
+				blocked = accountLockedError(lockedUntil, now)
+				if Logger != nil {
+					Logger.Warnf("[LoginGuard] 账号因多次登录失败被锁定: username=%s, failures=%d, ip=%s", username, throttle.Failures, ip)
+				}
+			}
+			db.Model(&model.LoginThrottle{}).Where("id = ?", throttle.ID).Updates(updates)
+		} else if Logger != nil {
+			Logger.Errorf("[LoginGuard] 记录登录失败次数失败: username=%s, error=%v", username, err)
+		}
+	}
+
+	if ip != "" {
+		throttle, err := incrementLoginFailures(db, LoginThrottleScopeIP, ip, policy.window, now)
+		if err == nil {
+			if throttle.Failures >= policy.ipMaxFailures {
+				lockedUntil := now.Add(policy.ipLockDuration)
+				db.Model(&model.LoginThrottle{}).Where("id = ?", throttle.ID).Update("locked_until", lockedUntil)
+				if blocked == nil {
+					blocked = tooManyAttemptsError(policy.ipLockDuration)
+				}
+				if Logger != nil {
+					Logger.Warnf("[LoginGuard] IP因多次登录失败被限制: ip=%s, failures=%d", ip, throttle.Failures)
+				}
+			}
+		} else if Logger != nil {
+			Logger.Errorf("[LoginGuard] 记录登录失败次数失败: ip=%s, error=%v", ip, err)
+		}
+	}
+
+	return blocked
+}
+
+// incrementLoginFailures 失败次数加一；统计窗口或锁定已过期时重新开始计数
+func incrementLoginFailures(db *gorm.DB, scope, identifier string, window time.Duration, now time.Time) (*model.LoginThrottle, error) {
+	throttle := model.LoginThrottle{Scope: scope, Identifier: identifier}
+	if err := db.Where("scope = ? AND identifier = ?", scope, identifier).
+		Attrs(model.LoginThrottle{WindowStartAt: now, LastFailedAt: now}).
+		FirstOrCreate(&throttle).Error; err != nil {
+		return nil, err
+	}
+
+	expired := now.Sub(throttle.WindowStartAt) > window ||
+		(throttle.LockedUntil != nil && !throttle.LockedUntil.After(now))
+	if expired {
+		if err := db.Model(&model.LoginThrottle{}).Where("id = ?", throttle.ID).Updates(map[string]interface{}{
+			"failures":        1,
+			"window_start_at": now,
+			"last_failed_at":  now,
+			"locked_until":    nil,
+			"next_attempt_at": nil,
+		}).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		// 使用数据库自增，避免并发请求丢失计数
+		if err := db.Model(&model.LoginThrottle{}).Where("id = ?", throttle.ID).Updates(map[string]interface{}{
+			"failures":       gorm.Expr("failures + 1"),
+			"last_failed_at": now,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.First(&throttle, throttle.ID).Error; err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// ClearLoginFailures 清除账号的登录失败记录（登录成功或管理员解锁时调用）
+func ClearLoginFailures(db *gorm.DB, username string) error {
+	return db.Where("scope = ? AND identifier = ?", LoginThrottleScopeUser, username).Delete(&model.LoginThrottle{}).Error
+}
+
+// GetAccountLock 获取账号当前的锁定截止时间，未锁定时返回 nil
+func GetAccountLock(db *gorm.DB, username string) *time.Time {
+	var throttle model.LoginThrottle
+	if err := db.Where("scope = ? AND identifier = ?", LoginThrottleScopeUser, username).First(&throttle).Error; err != nil {
+		return nil
+	}
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(time.Now()) {
+		return throttle.LockedUntil
+	}
+	return nil
+}
+
+// RespondLoginBlocked 返回登录被拒绝的错误响应（包含需要等待的秒数）
+func RespondLoginBlocked(c *gin.Context, blocked *LoginBlockedError) {
+	data := gin.H{
+		"retry_after": int((blocked.RetryAfter + time.Second - 1) / time.Second),
+	}
+	if blocked.LockedUntil != nil {
+		data["locked_until"] = blocked.LockedUntil
+	}
+	ErrorWithData(c, blocked.Code, blocked.Message, data)
+}
+
+func accountLockedError(lockedUntil, now time.Time) *LoginBlockedError {
+	return &LoginBlockedError{
+		Code:        CodeAccountLocked,
+		Message:     fmt.Sprintf("登录失败次数过多，账号已锁定，请%s后重试或联系管理员解锁", formatRetryAfter(lockedUntil.Sub(now))),
+		RetryAfter:  lockedUntil.Sub(now),
+		LockedUntil: &lockedUntil,
+	}
+}
+
+func tooManyAttemptsError(retryAfter time.Duration) *LoginBlockedError {
+	return &LoginBlockedError{
+		Code:       CodeTooManyAttempts,
+		Message:    fmt.Sprintf("登录尝试过于频繁，请%s后重试", formatRetryAfter(retryAfter)),
+		RetryAfter: retryAfter,
+	}
+}
+
+func formatRetryAfter(d time.Duration) string {
+	if d >= time.Minute {
+		return fmt.Sprintf("%d分钟", int((d+time.Minute-1)/time.Minute))
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("%d秒", seconds)
+}
@@ -26,17 +26,29 @@ func AutoMigrate(db *gorm.DB) error {
 		&model.Department{},
 		&model.Role{},
 		&model.Permission{},
+		&model.UserSession{},
+		&model.LoginThrottle{},
+		&model.UserTwoFactor{},
+		&model.UserRecoveryCode{},
+		&model.PersonalAccessToken{},
+		&model.UserIdentity{},
+		&model.IdentityAuthRequest{},
+		&model.PasswordResetToken{},
 
 		// 标签
 		&model.Tag{},
 		// 项目
 		&model.Project{},
 		&model.ProjectMember{},
+		// 迭代
+		&model.Sprint{},
 		// 功能模块
 		&model.Module{},
 
 		// 需求与Bug
 		&model.Requirement{},
+		&model.RequirementReview{},
+		&model.RequirementReviewVote{},
 		&model.Bug{},
 		&model.BugAssignee{},
 
@@ -56,6 +68,10 @@ func AutoMigrate(db *gorm.DB) error {
 		// 测试
 		&model.TestCase{},
 		&model.TestCaseBug{},
+		&model.TestCaseRequirement{},
+		&model.TestCaseStep{},
+		&model.SharedStep{},
+		&model.SharedStepItem{},
 
 		// 资源管理
 		&model.Resource{},
@@ -91,6 +107,18 @@ func AutoMigrate(db *gorm.DB) error {
 		&model.RequirementAttachment{},
 		&model.TaskAttachment{},
 		&model.BugAttachment{},
+
+		// 状态工作流
+		&model.Workflow{},
+		&model.WorkflowState{},
+		&model.WorkflowTransition{},
+		// Webhook
+		&model.Webhook{},
+		&model.WebhookDelivery{},
+		// 通知
+		&model.Notification{},
+		&model.NotificationPreference{},
+		&model.EmailMessage{},
 		// 注意：审计日志表（AuditLog）不在主数据库中迁移，而是在审计日志数据库中迁移
 	)
 
@@ -104,6 +132,11 @@ func AutoMigrate(db *gorm.DB) error {
 		return err
 	}
 
+	// 初始化默认工作流
+	if err := InitDefaultWorkflows(db); err != nil {
+		return fmt.Errorf("初始化默认工作流失败: %w", err)
+	}
+
 	return err
 }
 
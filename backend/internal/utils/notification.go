@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 通知渠道
+const (
+	NotificationChannelInApp = "in_app" // 站内通知
+	NotificationChannelEmail = "email"  // 邮件通知
+)
+
+// 通知类型
+const (
+	NotificationBugAssigned              = "bug.assigned"
+	NotificationTaskAssigned             = "task.assigned"
+	NotificationRequirementAssigned      = "requirement.assigned"
+	NotificationRequirementReview        = "requirement.review_requested"
+	NotificationReportApprovalRequested  = "report.approval_requested"
+	NotificationReportReviewed           = "report.reviewed"
+	NotificationBugStatusChanged         = "bug.status_changed"
+	NotificationTaskStatusChanged        = "task.status_changed"
+	NotificationRequirementStatusChanged = "requirement.status_changed"
+	NotificationTaskDueSoon              = "task.due_soon"
+)
+
+// NotificationChannels 支持的通知渠道
+var NotificationChannels = []string{NotificationChannelInApp, NotificationChannelEmail}
+
+// NotificationEventTypes 支持的通知类型
+var NotificationEventTypes = []string{
+	NotificationBugAssigned,
+	NotificationTaskAssigned,
+	NotificationRequirementAssigned,
+	NotificationRequirementReview,
+	NotificationReportApprovalRequested,
+	NotificationReportReviewed,
+	NotificationBugStatusChanged,
+	NotificationTaskStatusChanged,
+	NotificationRequirementStatusChanged,
+	NotificationTaskDueSoon,
+}
+
+// NotificationInput 创建通知的参数
+type NotificationInput struct {
+	Type       string
+	Title      string
+	Content    string
+	ObjectType string
+	ObjectID   uint
+	ProjectID  uint
+	ActorID    uint // 触发人ID，触发人不会收到自己操作产生的通知
+}
+
+// NotificationPusher 通知推送器（通知创建后调用，用于在线实时推送）
+type NotificationPusher func(notification *model.Notification)
+
+var (
+	notificationPushers   []NotificationPusher
+	notificationPushersMu sync.RWMutex
+)
+
+// RegisterNotificationPusher 注册通知推送器
+func RegisterNotificationPusher(pusher NotificationPusher) {
+	notificationPushersMu.Lock()
+	defer notificationPushersMu.Unlock()
+	notificationPushers = append(notificationPushers, pusher)
+}
+
+// pushNotification 调用所有推送器（单个推送器panic不影响主流程）
+func pushNotification(notification *model.Notification) {
+	notificationPushersMu.RLock()
+	pushers := make([]NotificationPusher, len(notificationPushers))
+	copy(pushers, notificationPushers)
+	notificationPushersMu.RUnlock()
+
+	for _, pusher := range pushers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil && Logger != nil {
+					Logger.Errorf("通知推送失败: %v", r)
+				}
+			}()
+			pusher(notification)
+		}()
+	}
+}
+
+// IsValidNotificationEventType 检查通知类型是否有效
+func IsValidNotificationEventType(eventType string) bool {
+	for _, t := range NotificationEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidNotificationChannel 检查通知渠道是否有效
+func IsValidNotificationChannel(channel string) bool {
+	for _, ch := range NotificationChannels {
+		if ch == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationEnabled 检查用户是否接收某类型、某渠道的通知（未设置偏好时默认接收）
+func NotificationEnabled(db *gorm.DB, userID uint, eventType, channel string) bool {
+	enabled, _ := GetNotificationPreference(db, userID, eventType, channel)
+	return enabled
+}
+
+// GetNotificationPreference 获取用户某类型、某渠道的通知偏好（未设置时默认接收，邮件默认立即发送）
+func GetNotificationPreference(db *gorm.DB, userID uint, eventType, channel string) (bool, string) {
+	var pref model.NotificationPreference
+	if err := db.Where("user_id = ? AND event_type = ? AND channel = ?", userID, eventType, channel).First(&pref).Error; err != nil {
+		return true, EmailModeImmediate
+	}
+	mode := pref.Mode
+	if mode == "" {
+		mode = EmailModeImmediate
+	}
+	return pref.Enabled, mode
+}
+
+// Notify 向用户发送通知
+// 会跳过触发人本人和重复用户；按用户偏好分别创建站内通知和邮件（立即发送或进入每日摘要），返回创建的站内通知
+func Notify(db *gorm.DB, userIDs []uint, input NotificationInput) ([]model.Notification, error) {
+	var notifications []model.Notification
+	var createErr error
+	emailEnabled := EmailEnabled()
+	actorName := ""
+	if emailEnabled && input.ActorID != 0 {
+		var actor model.User
+		if err := db.First(&actor, input.ActorID).Error; err == nil {
+			actorName = displayName(&actor)
+		}
+	}
+
+	seen := make(map[uint]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == 0 || seen[userID] || userID == input.ActorID {
+			continue
+		}
+		seen[userID] = true
+
+		if emailEnabled {
+			if err := enqueueNotificationEmail(db, userID, actorName, input); err != nil && Logger != nil {
+				Logger.Errorf("写入通知邮件失败: type=%s, user_id=%d, error=%v", input.Type, userID, err)
+			}
+		}
+
+		if !NotificationEnabled(db, userID, input.Type, NotificationChannelInApp) {
+			continue
+		}
+
+		notification := model.Notification{
+			UserID:     userID,
+			Type:       input.Type,
+			Title:      input.Title,
+			Content:    input.Content,
+			ObjectType: input.ObjectType,
+			ObjectID:   input.ObjectID,
+			ProjectID:  input.ProjectID,
+		}
+		if input.ActorID != 0 {
+			actorID := input.ActorID
+			notification.ActorID = &actorID
+		}
+		if err := db.Create(&notification).Error; err != nil {
+			if Logger != nil {
+				Logger.Errorf("创建通知失败: type=%s, user_id=%d, error=%v", input.Type, userID, err)
+			}
+			createErr = err
+			break
+		}
+		notifications = append(notifications, notification)
+	}
+
+	for i := range notifications {
+		pushNotification(&notifications[i])
+	}
+	return notifications, createErr
+}
+
+// enqueueNotificationEmail 按用户的邮件偏好渲染并写入邮件队列
+func enqueueNotificationEmail(db *gorm.DB, userID uint, actorName string, input NotificationInput) error {
+	enabled, mode := GetNotificationPreference(db, userID, input.Type, NotificationChannelEmail)
+	if !enabled {
+		return nil
+	}
+	var user model.User
+	if err := db.First(&user, userID).Error; err != nil || user.Email == "" || user.Status != 1 {
+		return nil
+	}
+
+	link := NotificationLink(input.ObjectType, input.ObjectID)
+	subject, htmlBody, textBody, err := RenderNotificationEmail(input.Type, EmailTemplateData{
+		RecipientName: displayName(&user),
+		ActorName:     actorName,
+		Title:         input.Title,
+		Content:       input.Content,
+		Link:          link,
+	})
+	if err != nil {
+		return err
+	}
+
+	return EnqueueEmail(db, &model.EmailMessage{
+		UserID:    userID,
+		To:        user.Email,
+		EventType: input.Type,
+		Subject:   subject,
+		HTMLBody:  htmlBody,
+		TextBody:  textBody,
+		Summary:   input.Content,
+		Link:      link,
+	}, mode)
+}
+
+// displayName 获取用户显示名称
+func displayName(user *model.User) string {
+	if user.Nickname != "" {
+		return user.Nickname
+	}
+	return user.Username
+}
+
+// statusChangeActions 表示状态变更的操作类型
+var statusChangeActions = map[string]bool{
+	"status_changed":  true,
+	"resolved":        true,
+	"closed":          true,
+	"review_finished": true,
+}
+
+// statusChangeObjectLabels 状态变更通知的对象名称
+var statusChangeObjectLabels = map[string]string{
+	"bug":         "Bug",
+	"task":        "任务",
+	"requirement": "需求",
+}
+
+// BuildStatusChangeNotification 根据状态变更操作构建通知（接收人为创建人和指派人）
+// 不是状态变更操作时返回 false
+func BuildStatusChangeNotification(db *gorm.DB, action *model.Action) ([]uint, NotificationInput, bool) {
+	label, ok := statusChangeObjectLabels[action.ObjectType]
+	if !ok || !statusChangeActions[action.Action] {
+		return nil, NotificationInput{}, false
+	}
+
+	var recipients []uint
+	var title, status string
+	switch action.ObjectType {
+	case "bug":
+		var bug model.Bug
+		if err := db.First(&bug, action.ObjectID).Error; err != nil {
+			return nil, NotificationInput{}, false
+		}
+		title, status = bug.Title, bug.Status
+		recipients = append(recipients, bug.CreatorID)
+		var assigneeIDs []uint
+		db.Model(&model.BugAssignee{}).Where("bug_id = ?", bug.ID).Pluck("user_id", &assigneeIDs)
+		recipients = append(recipients, assigneeIDs...)
+	case "task":
+		var task model.Task
+		if err := db.First(&task, action.ObjectID).Error; err != nil {
+			return nil, NotificationInput{}, false
+		}
+		title, status = task.Title, task.Status
+		recipients = append(recipients, task.CreatorID)
+		if task.AssigneeID != nil {
+			recipients = append(recipients, *task.AssigneeID)
+		}
+	case "requirement":
+		var requirement model.Requirement
+		if err := db.First(&requirement, action.ObjectID).Error; err != nil {
+			return nil, NotificationInput{}, false
+		}
+		title, status = requirement.Title, requirement.Status
+		recipients = append(recipients, requirement.CreatorID)
+		if requirement.AssigneeID != nil {
+			recipients = append(recipients, *requirement.AssigneeID)
+		}
+	}
+
+	// 使用工作流中的状态名称
+	statusName := status
+	if workflow := GetWorkflow(db, action.ObjectType, action.ProjectID); workflow != nil {
+		for _, state := range workflow.States {
+			if state.Code == status && state.Name != "" {
+				statusName = state.Name
+				break
+			}
+		}
+	}
+
+	return recipients, NotificationInput{
+		Type:       action.ObjectType + ".status_changed",
+		Title:      fmt.Sprintf("%s状态变更为「%s」：%s", label, statusName, title),
+		Content:    action.Comment,
+		ObjectType: action.ObjectType,
+		ObjectID:   action.ObjectID,
+		ProjectID:  action.ProjectID,
+		ActorID:    action.ActorID,
+	}, true
+}
+
+var registerNotificationListenerOnce sync.Once
+
+// RegisterNotificationListener 注册操作记录监听器，在Bug、任务、需求状态变更时通知创建人和指派人
+func RegisterNotificationListener() {
+	registerNotificationListenerOnce.Do(func() {
+		RegisterActionListener(func(db *gorm.DB, action *model.Action) {
+			recipients, input, ok := BuildStatusChangeNotification(db, action)
+			if !ok {
+				return
+			}
+			Notify(db, recipients, input)
+		})
+	})
+}
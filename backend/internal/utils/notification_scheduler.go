@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+const defaultDigestTime = "08:30"
+
+var (
+	digestScheduler     *DigestScheduler
+	digestSchedulerOnce sync.Once
+	digestMutex         sync.Mutex
+)
+
+// DigestScheduler 每日通知定时任务调度器（到期提醒和邮件摘要）
+type DigestScheduler struct {
+	db    *gorm.DB
+	timer *time.Timer
+	mu    sync.Mutex
+}
+
+// GetDigestScheduler 获取每日通知调度器单例
+func GetDigestScheduler(db *gorm.DB) *DigestScheduler {
+	digestSchedulerOnce.Do(func() {
+		digestScheduler = &DigestScheduler{db: db}
+	})
+	return digestScheduler
+}
+
+// Start 启动定时任务
+func (s *DigestScheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	nextTime := s.calculateNextRunTime(time.Now())
+	duration := time.Until(nextTime)
+	if Logger != nil {
+		Logger.Infof("[Scheduler] Next notification digest scheduled at: %s (in %v)", nextTime.Format("2006-01-02 15:04:05"), duration)
+	}
+
+	s.timer = time.AfterFunc(duration, func() {
+		RunDailyNotifications(s.db, time.Now())
+		// 递归调度下次执行
+		s.Start()
+	})
+}
+
+// Stop 停止定时任务
+func (s *DigestScheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+		if Logger != nil {
+			Logger.Info("[Scheduler] Notification digest scheduler stopped")
+		}
+	}
+}
+
+// calculateNextRunTime 计算下次执行时间
+func (s *DigestScheduler) calculateNextRunTime(now time.Time) time.Time {
+	digestTime := defaultDigestTime
+	if config.AppConfig != nil && config.AppConfig.Email.DigestTime != "" {
+		digestTime = config.AppConfig.Email.DigestTime
+	}
+	t, err := time.Parse("15:04", digestTime)
+	if err != nil {
+		if Logger != nil {
+			Logger.Warnf("[Scheduler] Invalid digest time format: %s, using %s", digestTime, defaultDigestTime)
+		}
+		t, _ = time.Parse("15:04", defaultDigestTime)
+	}
+
+	todayRun := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+
+	// 今天已经执行过，返回明天的执行时间
+	var lastDateConfig model.SystemConfig
+	if err := s.db.Where("key = ?", "notification_digest_last_date").First(&lastDateConfig).Error; err == nil &&
+		lastDateConfig.Value == now.Format("2006-01-02") {
+		return todayRun.AddDate(0, 0, 1)
+	}
+
+	if now.After(todayRun) {
+		return todayRun.AddDate(0, 0, 1)
+	}
+	return todayRun
+}
+
+// RunDailyNotifications 执行每日通知任务：生成任务到期提醒，然后发送邮件摘要
+// 同一天只执行一次
+func RunDailyNotifications(db *gorm.DB, now time.Time) {
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+
+	today := now.Format("2006-01-02")
+	var lastDateConfig model.SystemConfig
+	if err := db.Where("key = ?", "notification_digest_last_date").First(&lastDateConfig).Error; err == nil && lastDateConfig.Value == today {
+		if Logger != nil {
+			Logger.Info("[Scheduler] Daily notifications already sent today, skipping")
+		}
+		return
+	}
+
+	dueCount := NotifyDueTasks(db, now)
+	digestCount := SendEmailDigests(db, now)
+	if Logger != nil {
+		Logger.Infof("[Scheduler] Daily notifications completed: due_tasks=%d, digests=%d", dueCount, digestCount)
+	}
+
+	lastDateConfig = model.SystemConfig{
+		Key:   "notification_digest_last_date",
+		Value: today,
+		Type:  "string",
+	}
+	if err := db.Where("key = ?", "notification_digest_last_date").
+		Assign(model.SystemConfig{Value: today, Type: "string"}).
+		FirstOrCreate(&lastDateConfig).Error; err != nil && Logger != nil {
+		Logger.Warnf("[Scheduler] Failed to update notification_digest_last_date: %v", err)
+	}
+}
+
+// NotifyDueTasks 提醒指派人处理明天（含今天）到期且未完成的任务，返回提醒的任务数
+func NotifyDueTasks(db *gorm.DB, now time.Time) int {
+	endOfTomorrow := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 2)
+	startOfToday := endOfTomorrow.AddDate(0, 0, -2)
+
+	var tasks []model.Task
+	if err := db.Where("assignee_id IS NOT NULL AND due_date >= ? AND due_date < ?", startOfToday, endOfTomorrow).
+		Where("status NOT IN ?", []string{"done", "cancel", "closed"}).
+		Find(&tasks).Error; err != nil {
+		if Logger != nil {
+			Logger.Errorf("[Scheduler] Failed to query due tasks: %v", err)
+		}
+		return 0
+	}
+
+	for _, task := range tasks {
+		when := "明天"
+		if task.DueDate.Before(startOfToday.AddDate(0, 0, 1)) {
+			when = "今天"
+		}
+		Notify(db, []uint{*task.AssigneeID}, NotificationInput{
+			Type:       NotificationTaskDueSoon,
+			Title:      fmt.Sprintf("任务%s到期：%s", when, task.Title),
+			Content:    "截止日期：" + task.DueDate.Format("2006-01-02"),
+			ObjectType: "task",
+			ObjectID:   task.ID,
+			ProjectID:  task.ProjectID,
+		})
+	}
+	return len(tasks)
+}
+
+// SendEmailDigests 将等待摘要的邮件按用户汇总为一封摘要邮件加入发送队列，返回生成的摘要数
+func SendEmailDigests(db *gorm.DB, now time.Time) int {
+	var userIDs []uint
+	if err := db.Model(&model.EmailMessage{}).Where("status = ?", EmailStatusDigest).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, userID := range userIDs {
+		var messages []model.EmailMessage
+		if err := db.Where("user_id = ? AND status = ?", userID, EmailStatusDigest).Order("id ASC").Find(&messages).Error; err != nil || len(messages) == 0 {
+			continue
+		}
+
+		var user model.User
+		if err := db.First(&user, userID).Error; err != nil {
+			continue
+		}
+		to := user.Email
+		if to == "" {
+			to = messages[len(messages)-1].To
+		}
+
+		subject, htmlBody, textBody, err := RenderDigestEmail(displayName(&user), now, messages)
+		if err != nil {
+			if Logger != nil {
+				Logger.Errorf("[Email] 渲染摘要邮件失败: user_id=%d, error=%v", userID, err)
+			}
+			continue
+		}
+
+		ids := make([]uint, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := EnqueueEmail(tx, &model.EmailMessage{
+				UserID:    userID,
+				To:        to,
+				EventType: "digest",
+				Subject:   subject,
+				HTMLBody:  htmlBody,
+				TextBody:  textBody,
+			}, EmailModeImmediate); err != nil {
+				return err
+			}
+			return tx.Model(&model.EmailMessage{}).Where("id IN ?", ids).Update("status", EmailStatusDigested).Error
+		})
+		if err != nil {
+			if Logger != nil {
+				Logger.Errorf("[Email] 生成摘要邮件失败: user_id=%d, error=%v", userID, err)
+			}
+			continue
+		}
+		count++
+	}
+	return count
+}
@@ -0,0 +1,442 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/pkg/wechat"
+
+	"gorm.io/gorm"
+)
+
+// 密码重置发送渠道
+const (
+	PasswordResetChannelEmail  = "email"
+	PasswordResetChannelWeChat = "wechat"
+)
+
+const (
+	defaultPasswordResetTTL      = 30 // 分钟
+	defaultPasswordResetInterval = 60 // 秒
+)
+
+var (
+	ErrPasswordResetDisabled     = errors.New("系统未开启自助重置密码")
+	ErrPasswordResetTokenInvalid = errors.New("重置链接无效或已过期，请重新申请")
+	ErrPasswordResetNotAllowed   = errors.New("该账号不支持自助重置密码")
+	ErrPasswordResetNoChannel    = errors.New("没有可用的发送渠道，请联系管理员重置密码")
+	ErrPasswordResetTooFrequent  = errors.New("申请过于频繁，请稍后再试")
+)
+
+// PasswordResetMessage 发送给用户的重置信息
+type PasswordResetMessage struct {
+	Token     string    // 令牌明文
+	Link      string    // 重置页面链接（未配置访问地址时为空）
+	ExpiresAt time.Time // 过期时间
+}
+
+// PasswordResetChannel 密码重置令牌的发送渠道
+// 内置邮件和微信两种渠道，可以通过 RegisterPasswordResetChannel 替换或增加渠道
+type PasswordResetChannel interface {
+	// Name 渠道名称，申请重置时按名称指定渠道
+	Name() string
+	// Available 用户是否可以通过该渠道接收（如已填写邮箱、已绑定微信）
+	Available(db *gorm.DB, user *model.User) bool
+	// Send 发送重置信息
+	Send(db *gorm.DB, user *model.User, message PasswordResetMessage) error
+}
+
+var (
+	passwordResetChannelsMu sync.RWMutex
+	passwordResetChannels   []PasswordResetChannel
+)
+
+func init() {
+	RegisterPasswordResetChannel(EmailPasswordResetChannel{})
+	RegisterPasswordResetChannel(WeChatPasswordResetChannel{})
+}
+
+// RegisterPasswordResetChannel 注册发送渠道，同名渠道会被替换
+// 未指定渠道时按注册顺序选择第一个可用的渠道
+func RegisterPasswordResetChannel(channel PasswordResetChannel) {
+	passwordResetChannelsMu.Lock()
+	defer passwordResetChannelsMu.Unlock()
+	for i, existing := range passwordResetChannels {
+		if existing.Name() == channel.Name() {
+			passwordResetChannels[i] = channel
+			return
+		}
+	}
+	passwordResetChannels = append(passwordResetChannels, channel)
+}
+
+// GetPasswordResetChannel 按名称获取发送渠道
+func GetPasswordResetChannel(name string) PasswordResetChannel {
+	passwordResetChannelsMu.RLock()
+	defer passwordResetChannelsMu.RUnlock()
+	for _, channel := range passwordResetChannels {
+		if channel.Name() == name {
+			return channel
+		}
+	}
+	return nil
+}
+
+// AvailablePasswordResetChannels 用户可用的发送渠道
+func AvailablePasswordResetChannels(db *gorm.DB, user *model.User) []PasswordResetChannel {
+	passwordResetChannelsMu.RLock()
+	defer passwordResetChannelsMu.RUnlock()
+	var channels []PasswordResetChannel
+	for _, channel := range passwordResetChannels {
+		if channel.Available(db, user) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// PasswordResetEnabled 检查是否开启了自助重置密码
+func PasswordResetEnabled() bool {
+	return config.AppConfig != nil && config.AppConfig.PasswordReset.Enabled
+}
+
+// PasswordResetTTL 重置令牌有效期
+func PasswordResetTTL() time.Duration {
+	minutes := defaultPasswordResetTTL
+	if config.AppConfig != nil && config.AppConfig.PasswordReset.TokenTTL > 0 {
+		minutes = config.AppConfig.PasswordReset.TokenTTL
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func passwordResetInterval() time.Duration {
+	seconds := defaultPasswordResetInterval
+	if config.AppConfig != nil && config.AppConfig.PasswordReset.RequestInterval > 0 {
+		seconds = config.AppConfig.PasswordReset.RequestInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// PasswordResetLink 生成重置页面链接，未配置重置页面和系统访问地址时返回空
+func PasswordResetLink(token string) string {
+	if config.AppConfig == nil {
+		return ""
+	}
+	base := config.AppConfig.PasswordReset.ResetURL
+	if base == "" && config.AppConfig.Email.SiteURL != "" {
+		base = strings.TrimRight(config.AppConfig.Email.SiteURL, "/") + "/reset-password"
+	}
+	if base == "" {
+		return ""
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "token=" + url.QueryEscape(token)
+}
+
+// FindPasswordResetUser 按用户名或邮箱查找申请重置的用户
+// 邮箱对应多个用户时无法确定账号，视为不存在
+func FindPasswordResetUser(db *gorm.DB, account string) (*model.User, error) {
+	account = strings.TrimSpace(account)
+	if account == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var user model.User
+	err := db.Where("username = ?", account).First(&user).Error
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) || !strings.Contains(account, "@") {
+		return nil, err
+	}
+
+	var users []model.User
+	if err := db.Where("LOWER(email) = ?", strings.ToLower(account)).Limit(2).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &users[0], nil
+}
+
+// CheckPasswordResetAllowed 检查用户是否可以自助重置密码
+// 禁用的用户、服务账号和密码由企业目录管理的LDAP账号不允许重置
+func CheckPasswordResetAllowed(user *model.User) error {
+	if user.Status != 1 || user.IsServiceAccount || user.AuthSource == AuthSourceLDAP {
+		return ErrPasswordResetNotAllowed
+	}
+	return nil
+}
+
+// RequestPasswordReset 为用户生成重置令牌并通过指定渠道发送，channelName 为空时选择第一个可用的渠道
+// 新令牌生成后，该用户之前未使用的令牌全部失效；返回实际使用的渠道名称
+func RequestPasswordReset(db *gorm.DB, user *model.User, channelName, ip string) (string, error) {
+	if !PasswordResetEnabled() {
+		return "", ErrPasswordResetDisabled
+	}
+	if err := CheckPasswordResetAllowed(user); err != nil {
+		return "", err
+	}
+
+	var channel PasswordResetChannel
+	if channelName != "" {
+		if channel = GetPasswordResetChannel(channelName); channel == nil || !channel.Available(db, user) {
+			return "", ErrPasswordResetNoChannel
+		}
+	} else if channels := AvailablePasswordResetChannels(db, user); len(channels) > 0 {
+		channel = channels[0]
+	} else {
+		return "", ErrPasswordResetNoChannel
+	}
+
+	var last model.PasswordResetToken
+	if err := db.Where("user_id = ?", user.ID).Order("created_at DESC").First(&last).Error; err == nil {
+		if time.Since(last.CreatedAt) < passwordResetInterval() {
+			return "", ErrPasswordResetTooFrequent
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	plain := hex.EncodeToString(buf)
+	token := model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: HashAccessToken(plain),
+		Channel:   channel.Name(),
+		RequestIP: ip,
+		ExpiresAt: time.Now().Add(PasswordResetTTL()),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := invalidatePasswordResetTokens(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Create(&token).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	message := PasswordResetMessage{Token: plain, Link: PasswordResetLink(plain), ExpiresAt: token.ExpiresAt}
+	if err := channel.Send(db, user, message); err != nil {
+		// 发送失败的令牌用户拿不到，直接删除，不影响重新申请的间隔限制
+		db.Delete(&token)
+		return channel.Name(), fmt.Errorf("发送重置信息失败: %w", err)
+	}
+	return channel.Name(), nil
+}
+
+// ValidatePasswordResetToken 校验重置令牌，返回令牌记录（含用户）
+func ValidatePasswordResetToken(db *gorm.DB, plain string) (*model.PasswordResetToken, error) {
+	if plain == "" {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+	var token model.PasswordResetToken
+	if err := db.Preload("User").Where("token_hash = ?", HashAccessToken(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPasswordResetTokenInvalid
+		}
+		return nil, err
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) || token.User == nil {
+		return &token, ErrPasswordResetTokenInvalid
+	}
+	if err := CheckPasswordResetAllowed(token.User); err != nil {
+		return &token, err
+	}
+	return &token, nil
+}
+
+// ResetPasswordWithToken 使用重置令牌设置新密码
+// 令牌只能使用一次；重置成功后吊销用户的全部会话，并清除账号的登录失败锁定
+func ResetPasswordWithToken(db *gorm.DB, plain, newPassword string) (*model.PasswordResetToken, error) {
+	token, err := ValidatePasswordResetToken(db, plain)
+	if err != nil {
+		return token, err
+	}
+	if err := ValidatePasswordStrength(newPassword); err != nil {
+		return token, err
+	}
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return token, err
+	}
+
+	user := token.User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// 条件更新保证并发提交时令牌只被使用一次
+		result := tx.Model(&model.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPasswordResetTokenInvalid
+		}
+		if err := invalidatePasswordResetTokens(tx, user.ID); err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"password": hashedPassword}
+		if user.LoginCount == 1 {
+			updates["login_count"] = 2
+		}
+		return tx.Model(&model.User{}).Where("id = ?", user.ID).Updates(updates).Error
+	})
+	if err != nil {
+		return token, err
+	}
+
+	if _, err := RevokeUserSessions(db, user.ID, "", SessionRevokedPasswordReset); err != nil && Logger != nil {
+		Logger.Errorf("吊销用户会话失败: user_id=%d, error=%v", user.ID, err)
+	}
+	if err := ClearLoginFailures(db, user.Username); err != nil && Logger != nil {
+		Logger.Errorf("清除登录失败记录失败: username=%s, error=%v", user.Username, err)
+	}
+	return token, nil
+}
+
+// invalidatePasswordResetTokens 使用户未使用的重置令牌失效
+func invalidatePasswordResetTokens(db *gorm.DB, userID uint) error {
+	return db.Model(&model.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Update("expires_at", time.Now()).Error
+}
+
+// EmailPasswordResetChannel 通过邮件发送重置链接（进入邮件发送队列）
+type EmailPasswordResetChannel struct{}
+
+func (EmailPasswordResetChannel) Name() string { return PasswordResetChannelEmail }
+
+func (EmailPasswordResetChannel) Available(db *gorm.DB, user *model.User) bool {
+	return EmailEnabled() && strings.TrimSpace(user.Email) != ""
+}
+
+func (EmailPasswordResetChannel) Send(db *gorm.DB, user *model.User, message PasswordResetMessage) error {
+	subject, htmlBody, textBody, err := RenderPasswordResetEmail(user, message)
+	if err != nil {
+		return err
+	}
+	return EnqueueEmail(db, &model.EmailMessage{
+		UserID:    user.ID,
+		To:        user.Email,
+		EventType: "password_reset",
+		Subject:   subject,
+		HTMLBody:  htmlBody,
+		TextBody:  textBody,
+	}, EmailModeImmediate)
+}
+
+// WeChatPasswordResetChannel 通过公众号模板消息发送重置链接（需要已绑定微信并配置模板）
+type WeChatPasswordResetChannel struct{}
+
+func (WeChatPasswordResetChannel) Name() string { return PasswordResetChannelWeChat }
+
+func (WeChatPasswordResetChannel) Available(db *gorm.DB, user *model.User) bool {
+	if config.AppConfig == nil || config.AppConfig.PasswordReset.WeChatTemplateID == "" {
+		return false
+	}
+	if user.WeChatOpenID == nil || *user.WeChatOpenID == "" {
+		return false
+	}
+	client := loadWeChatClient(db)
+	return client.AppID != "" && client.AppSecret != "" && client.AccountType == "official_account"
+}
+
+func (WeChatPasswordResetChannel) Send(db *gorm.DB, user *model.User, message PasswordResetMessage) error {
+	if message.Link == "" {
+		return errors.New("未配置重置密码页面地址")
+	}
+	data := map[string]wechat.TemplateMessageData{
+		"first":    {Value: "你正在申请重置 PrjFlow 登录密码"},
+		"keyword1": {Value: user.Username},
+		"keyword2": {Value: message.ExpiresAt.Format("2006-01-02 15:04")},
+		"remark":   {Value: "点击消息设置新密码，链接只能使用一次。如果不是你本人操作，请忽略此消息。"},
+	}
+	return loadWeChatClient(db).SendTemplateMessage(*user.WeChatOpenID, config.AppConfig.PasswordReset.WeChatTemplateID, message.Link, data)
+}
+
+// loadWeChatClient 按系统设置（优先）和配置文件创建微信客户端
+func loadWeChatClient(db *gorm.DB) *wechat.WeChatClient {
+	client := wechat.NewWeChatClient()
+	var configs []model.SystemConfig
+	db.Where("key IN ?", []string{"wechat_app_id", "wechat_app_secret", "wechat_account_type"}).Find(&configs)
+	for _, cfg := range configs {
+		value := strings.TrimSpace(cfg.Value)
+		if value == "" {
+			continue
+		}
+		switch cfg.Key {
+		case "wechat_app_id":
+			client.AppID = value
+		case "wechat_app_secret":
+			client.AppSecret = value
+		case "wechat_account_type":
+			client.AccountType = value
+		}
+	}
+	return client
+}
+
+const passwordResetHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, 'Microsoft YaHei', sans-serif; color: #333; line-height: 1.6;">
+<p>{{.Name}}，你好：</p>
+<p>你正在申请重置账号 <strong>{{.Username}}</strong> 的登录密码。{{if .Link}}请点击下面的链接设置新密码：{{else}}请在重置密码页面输入以下重置码：{{end}}</p>
+{{if .Link}}<p><a href="{{.Link}}" style="color: #1677ff;">{{.Link}}</a></p>{{else}}<p style="padding: 8px 12px; background: #f5f5f5; border-radius: 4px; font-family: monospace;">{{.Token}}</p>{{end}}
+<p>链接在 {{.ExpiresAt.Format "2006-01-02 15:04"}} 前有效，且只能使用一次。如果不是你本人操作，请忽略此邮件，你的密码不会改变。</p>
+<p style="color: #999; font-size: 12px;">此邮件由 PrjFlow 自动发送，请勿回复。</p>
+</body>
+</html>`
+
+const passwordResetTextTemplate = `{{.Name}}，你好：
+
+你正在申请重置账号 {{.Username}} 的登录密码。{{if .Link}}请打开下面的链接设置新密码：
+
+{{.Link}}{{else}}请在重置密码页面输入以下重置码：
+
+{{.Token}}{{end}}
+
+链接在 {{.ExpiresAt.Format "2006-01-02 15:04"}} 前有效，且只能使用一次。如果不是你本人操作，请忽略此邮件，你的密码不会改变。
+--
+此邮件由 PrjFlow 自动发送，请勿回复。
+`
+
+var (
+	passwordResetHTML = htmltemplate.Must(htmltemplate.New("password_reset").Parse(passwordResetHTMLTemplate))
+	passwordResetText = texttemplate.Must(texttemplate.New("password_reset").Parse(passwordResetTextTemplate))
+)
+
+// RenderPasswordResetEmail 渲染重置密码邮件，返回主题、HTML正文和纯文本正文
+func RenderPasswordResetEmail(user *model.User, message PasswordResetMessage) (string, string, string, error) {
+	data := struct {
+		PasswordResetMessage
+		Name     string
+		Username string
+	}{message, displayName(user), user.Username}
+
+	var htmlBuf, textBuf bytes.Buffer
+	if err := passwordResetHTML.Execute(&htmlBuf, data); err != nil {
+		return "", "", "", err
+	}
+	if err := passwordResetText.Execute(&textBuf, data); err != nil {
+		return "", "", "", err
+	}
+	return "[PrjFlow] 重置密码", htmlBuf.String(), textBuf.String(), nil
+}
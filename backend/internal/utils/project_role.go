@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"prjflow/internal/model"
+	"prjflow/pkg/permission"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 项目角色（ProjectMember.Role）
+const (
+	ProjectRoleOwner  = "owner"  // 负责人：项目内全部权限，包括项目设置和成员管理
+	ProjectRoleMember = "member" // 成员：可以维护项目内的需求、任务、Bug等
+	ProjectRoleViewer = "viewer" // 查看者：只读
+)
+
+// projectRolePermissionsKey 项目角色权限映射在系统配置表中的键
+const projectRolePermissionsKey = "project_role_permissions"
+
+// 访问决策原因
+const (
+	ProjectAccessAdmin              = "admin"                // 系统管理员
+	ProjectAccessTokenScope         = "token_scope"          // 访问令牌权限范围不包含该权限
+	ProjectAccessNoGlobalPermission = "no_global_permission" // 全局角色没有该权限
+	ProjectAccessNotMember          = "not_member"           // 不是项目成员，不受项目角色限制
+	ProjectAccessRoleGranted        = "project_role_granted" // 项目角色允许
+	ProjectAccessRoleDenied         = "project_role_denied"  // 项目角色不允许
+)
+
+// ProjectRoles 所有项目角色
+var ProjectRoles = []string{ProjectRoleOwner, ProjectRoleMember, ProjectRoleViewer}
+
+// DefaultProjectRolePermissions 项目角色默认权限，支持通配符，如 bug:* 或 *:read
+var DefaultProjectRolePermissions = map[string][]string{
+	ProjectRoleOwner: {"*:*"},
+	ProjectRoleMember: {
+		"*:read", "project:update",
+		"requirement:*", "task:*", "bug:*", "test-case:*", "attachment:*",
+	},
+	ProjectRoleViewer: {"*:read"},
+}
+
+var projectRoleNames = map[string]string{
+	ProjectRoleOwner:  "负责人",
+	ProjectRoleMember: "成员",
+	ProjectRoleViewer: "查看者",
+}
+
+// IsValidProjectRole 检查项目角色是否合法
+func IsValidProjectRole(role string) bool {
+	_, ok := projectRoleNames[role]
+	return ok
+}
+
+// NormalizeProjectRole 将历史数据中的角色值映射到项目角色，无法识别的角色按成员处理
+func NormalizeProjectRole(role string) string {
+	switch strings.TrimSpace(role) {
+	case ProjectRoleOwner, "项目经理", "负责人":
+		return ProjectRoleOwner
+	case ProjectRoleViewer, "查看者":
+		return ProjectRoleViewer
+	default:
+		return ProjectRoleMember
+	}
+}
+
+// GetProjectRolePermissions 读取项目角色权限映射，未配置的角色使用默认权限（启用权限缓存时从缓存读取）
+// 返回的 map 可能与缓存共享，调用方不能修改
+func GetProjectRolePermissions(db *gorm.DB) map[string][]string {
+	return permission.GetProjectRolePermissions(db, loadProjectRolePermissions)
+}
+
+// loadProjectRolePermissions 从系统配置表读取项目角色权限映射
+func loadProjectRolePermissions(db *gorm.DB) map[string][]string {
+	result := make(map[string][]string, len(DefaultProjectRolePermissions))
+	for role, perms := range DefaultProjectRolePermissions {
+		result[role] = append([]string{}, perms...)
+	}
+
+	var cfg model.SystemConfig
+	if err := db.Where("key = ?", projectRolePermissionsKey).First(&cfg).Error; err != nil {
+		return result
+	}
+	var custom map[string][]string
+	if err := json.Unmarshal([]byte(cfg.Value), &custom); err != nil {
+		if Logger != nil {
+			Logger.Warnf("[ProjectRole] 解析项目角色权限失败: %v", err)
+		}
+		return result
+	}
+	for role, perms := range custom {
+		if IsValidProjectRole(role) && perms != nil {
+			result[role] = perms
+		}
+	}
+	return result
+}
+
+// SaveProjectRolePermissions 保存项目角色权限映射
+func SaveProjectRolePermissions(db *gorm.DB, rolePerms map[string][]string) error {
+	value, err := json.Marshal(rolePerms)
+	if err != nil {
+		return err
+	}
+	cfg := model.SystemConfig{Key: projectRolePermissionsKey, Value: string(value), Type: "json"}
+	return db.Where("key = ?", projectRolePermissionsKey).
+		Assign(model.SystemConfig{Value: string(value), Type: "json"}).
+		FirstOrCreate(&cfg).Error
+}
+
+// ValidateProjectRolePermissions 校验项目角色权限映射：角色必须合法，权限必须存在或为通配符
+func ValidateProjectRolePermissions(db *gorm.DB, rolePerms map[string][]string) error {
+	for role, perms := range rolePerms {
+		if !IsValidProjectRole(role) {
+			return fmt.Errorf("无效的项目角色: %s", role)
+		}
+		for _, pattern := range perms {
+			parts := strings.Split(pattern, ":")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("无效的权限: %s", pattern)
+			}
+			if strings.Contains(pattern, "*") {
+				continue
+			}
+			var count int64
+			db.Model(&model.Permission{}).Where("code = ?", pattern).Count(&count)
+			if count == 0 {
+				return fmt.Errorf("权限不存在: %s", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchProjectPermission 检查权限代码是否匹配权限模式（资源和操作均支持 * 通配）
+func matchProjectPermission(pattern, permCode string) bool {
+	patternParts := strings.SplitN(pattern, ":", 2)
+	codeParts := strings.SplitN(permCode, ":", 2)
+	if len(patternParts) != 2 || len(codeParts) != 2 {
+		return pattern == permCode
+	}
+	return (patternParts[0] == "*" || patternParts[0] == codeParts[0]) &&
+		(patternParts[1] == "*" || patternParts[1] == codeParts[1])
+}
+
+// ProjectRoleAllows 检查项目角色是否拥有权限
+func ProjectRoleAllows(db *gorm.DB, role, permCode string) bool {
+	for _, pattern := range GetProjectRolePermissions(db)[NormalizeProjectRole(role)] {
+		if matchProjectPermission(pattern, permCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProjectMemberRole 获取用户在项目中的角色（成员记录中保存的原始值），不是成员时返回 false
+func GetProjectMemberRole(db *gorm.DB, projectID, userID uint) (string, bool) {
+	projects, err := permission.GetUserProjectRoles(db, userID)
+	if err != nil {
+		return "", false
+	}
+	role, ok := projects[projectID]
+	return role, ok
+}
+
+// CheckProjectPermission 检查项目角色是否允许当前用户执行操作
+// 项目角色只收紧项目成员的权限：管理员和非项目成员（如Bug的创建人、指派人）不受项目角色限制，由原有的访问检查决定
+func CheckProjectPermission(db *gorm.DB, c *gin.Context, projectID uint, permCode string) bool {
+	if IsAdmin(c) {
+		return true
+	}
+	role, ok := GetProjectMemberRole(db, projectID, GetUserID(c))
+	if !ok {
+		return true
+	}
+	return ProjectRoleAllows(db, role, permCode)
+}
+
+// RequireProjectPermission 检查项目角色权限，没有权限时返回 403 并说明原因
+func RequireProjectPermission(db *gorm.DB, c *gin.Context, projectID uint, permCode string) bool {
+	if IsAdmin(c) {
+		return true
+	}
+	role, ok := GetProjectMemberRole(db, projectID, GetUserID(c))
+	if !ok || ProjectRoleAllows(db, role, permCode) {
+		return true
+	}
+	Error(c, 403, fmt.Sprintf("项目角色「%s」没有权限: %s", projectRoleNames[NormalizeProjectRole(role)], permCode))
+	return false
+}
+
+// ProjectAccessDecision 项目访问决策及原因
+type ProjectAccessDecision struct {
+	Allowed          bool     `json:"allowed"`
+	Reason           string   `json:"reason"`
+	Message          string   `json:"message"`
+	UserID           uint     `json:"user_id"`
+	ProjectID        uint     `json:"project_id"`
+	Permission       string   `json:"permission"`
+	GlobalRoles      []string `json:"global_roles"`
+	GlobalPermission bool     `json:"global_permission"`          // 全局角色是否拥有该权限
+	ProjectRole      string   `json:"project_role,omitempty"`     // 规范化后的项目角色
+	ProjectRoleRaw   string   `json:"project_role_raw,omitempty"` // 成员记录中保存的原始角色值
+	RolePermissions  []string `json:"role_permissions,omitempty"` // 项目角色的权限模式
+	TokenScopes      []string `json:"token_scopes,omitempty"`     // 使用访问令牌时的权限范围
+}
+
+// ExplainProjectAccess 解释当前请求用户对项目某个权限的访问决策
+func ExplainProjectAccess(db *gorm.DB, c *gin.Context, projectID uint, permCode string) (*ProjectAccessDecision, error) {
+	var roles []string
+	if value, exists := c.Get("roles"); exists {
+		roles, _ = value.([]string)
+	}
+	var scopes []string
+	if value, exists := c.Get("token_scopes"); exists {
+		scopes, _ = value.([]string)
+		if scopes == nil {
+			scopes = []string{}
+		}
+	}
+	return explainProjectAccess(db, GetUserID(c), roles, scopes, projectID, permCode)
+}
+
+// ExplainUserProjectAccess 解释指定用户对项目某个权限的访问决策（按用户当前的角色计算）
+func ExplainUserProjectAccess(db *gorm.DB, userID, projectID uint, permCode string) (*ProjectAccessDecision, error) {
+	roles, err := userRoleCodes(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return explainProjectAccess(db, userID, roles, nil, projectID, permCode)
+}
+
+// explainProjectAccess 依次检查：管理员、访问令牌范围、全局角色权限、项目成员身份、项目角色权限
+func explainProjectAccess(db *gorm.DB, userID uint, roles, tokenScopes []string, projectID uint, permCode string) (*ProjectAccessDecision, error) {
+	decision := &ProjectAccessDecision{
+		UserID:      userID,
+		ProjectID:   projectID,
+		Permission:  permCode,
+		GlobalRoles: roles,
+		TokenScopes: tokenScopes,
+	}
+	if decision.GlobalRoles == nil {
+		decision.GlobalRoles = []string{}
+	}
+
+	perms, isAdmin, err := rolePermissionSet(db, roles)
+	if err != nil {
+		return nil, err
+	}
+	decision.GlobalPermission = isAdmin || perms[permCode]
+
+	if tokenScopes != nil {
+		inScope := false
+		for _, scope := range tokenScopes {
+			if scope == permCode {
+				inScope = true
+				break
+			}
+		}
+		if !inScope {
+			decision.Reason = ProjectAccessTokenScope
+			decision.Message = "访问令牌的权限范围不包含该权限"
+			return decision, nil
+		}
+		// 令牌实际生效的权限已是权限范围与用户当前权限的交集
+		decision.GlobalPermission = true
+	}
+
+	if isAdmin {
+		decision.Allowed = true
+		decision.Reason = ProjectAccessAdmin
+		decision.Message = "系统管理员拥有所有项目的全部权限"
+		return decision, nil
+	}
+
+	if !decision.GlobalPermission {
+		decision.Reason = ProjectAccessNoGlobalPermission
+		decision.Message = "用户的全局角色没有该权限"
+		return decision, nil
+	}
+
+	rawRole, isMember := GetProjectMemberRole(db, projectID, userID)
+	if !isMember {
+		// 与 CheckProjectPermission 一致：项目角色只收紧项目成员的权限，非成员由资源自身的访问检查决定
+		decision.Allowed = true
+		decision.Reason = ProjectAccessNotMember
+		decision.Message = "用户不是该项目的成员，不受项目角色限制"
+		return decision, nil
+	}
+
+	role := NormalizeProjectRole(rawRole)
+	decision.ProjectRole = role
+	decision.ProjectRoleRaw = rawRole
+	decision.RolePermissions = GetProjectRolePermissions(db)[role]
+	if ProjectRoleAllows(db, rawRole, permCode) {
+		decision.Allowed = true
+		decision.Reason = ProjectAccessRoleGranted
+		decision.Message = fmt.Sprintf("项目角色「%s」拥有该权限", projectRoleNames[role])
+	} else {
+		decision.Reason = ProjectAccessRoleDenied
+		decision.Message = fmt.Sprintf("项目角色「%s」没有该权限", projectRoleNames[role])
+	}
+	return decision, nil
+}
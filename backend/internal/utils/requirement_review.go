@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 评审规则
+const (
+	ReviewRuleAll      = "all"      // 全部评审人通过才算通过
+	ReviewRuleMajority = "majority" // 超过半数评审人通过即算通过
+)
+
+// 评审意见（同时也是评审结论）
+const (
+	ReviewVerdictPending = "pending"
+	ReviewVerdictPass    = "pass"
+	ReviewVerdictReject  = "reject"
+	ReviewVerdictClarify = "clarify"
+)
+
+// 评审轮次状态
+const (
+	ReviewStatusReviewing = "reviewing"
+	ReviewStatusFinished  = "finished"
+	ReviewStatusCancelled = "cancelled"
+)
+
+var ErrRequirementUnderReview = errors.New("需求正在评审中，请先完成或取消评审")
+
+// ErrRequirementReviewClosed 评审已被结束或取消（如最后两名评审人同时提交）
+var ErrRequirementReviewClosed = errors.New("评审已结束")
+
+// requirementReviewResultStatus 评审结论对应的需求状态
+var requirementReviewResultStatus = map[string]string{
+	ReviewVerdictPass:    "active",
+	ReviewVerdictReject:  "closed",
+	ReviewVerdictClarify: "draft",
+}
+
+// IsValidReviewRule 检查评审规则是否有效
+func IsValidReviewRule(rule string) bool {
+	return rule == ReviewRuleAll || rule == ReviewRuleMajority
+}
+
+// IsValidReviewVerdict 检查评审意见是否有效（不含待评审）
+func IsValidReviewVerdict(verdict string) bool {
+	return verdict == ReviewVerdictPass || verdict == ReviewVerdictReject || verdict == ReviewVerdictClarify
+}
+
+// ReviewOutcome 按评审规则汇总评审意见，还有评审人未给出意见时返回 false。
+// all：全部通过为通过，有人拒绝为拒绝，否则为有待明确；
+// majority：超过半数通过为通过，否则拒绝票不少于有待明确票时为拒绝，其余为有待明确
+func ReviewOutcome(rule string, verdicts []string) (string, bool) {
+	counts := make(map[string]int)
+	for _, verdict := range verdicts {
+		if verdict == ReviewVerdictPending || verdict == "" {
+			return "", false
+		}
+		counts[verdict]++
+	}
+	if len(verdicts) == 0 {
+		return "", false
+	}
+
+	if rule == ReviewRuleMajority {
+		if counts[ReviewVerdictPass]*2 > len(verdicts) {
+			return ReviewVerdictPass, true
+		}
+		if counts[ReviewVerdictReject] >= counts[ReviewVerdictClarify] {
+			return ReviewVerdictReject, true
+		}
+		return ReviewVerdictClarify, true
+	}
+
+	if counts[ReviewVerdictPass] == len(verdicts) {
+		return ReviewVerdictPass, true
+	}
+	if counts[ReviewVerdictReject] > 0 {
+		return ReviewVerdictReject, true
+	}
+	return ReviewVerdictClarify, true
+}
+
+// RequirementReviewResultStatus 评审结论对应的需求状态，工作流中没有该状态时返回 false
+func RequirementReviewResultStatus(db *gorm.DB, projectID uint, result string) (string, bool) {
+	status, ok := requirementReviewResultStatus[result]
+	if !ok || !IsValidWorkflowState(db, "requirement", projectID, status) {
+		return "", false
+	}
+	return status, true
+}
+
+// OpenRequirementReview 获取需求进行中的评审，没有时返回 nil
+func OpenRequirementReview(db *gorm.DB, requirementID uint) *model.RequirementReview {
+	var review model.RequirementReview
+	if err := db.Where("requirement_id = ? AND status = ?", requirementID, ReviewStatusReviewing).
+		Order("round DESC").First(&review).Error; err != nil {
+		return nil
+	}
+	return &review
+}
+
+// CheckRequirementReviewLock 评审进行中时，需求状态只能由评审结果变更
+func CheckRequirementReviewLock(db *gorm.DB, requirement *model.Requirement, status string) error {
+	if status == requirement.Status {
+		return nil
+	}
+	if OpenRequirementReview(db, requirement.ID) != nil {
+		return ErrRequirementUnderReview
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"errors"
+	"sort"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// defaultRequirementLevels 未配置需求层级时使用的默认层级
+var defaultRequirementLevels = []config.RequirementLevelConfig{
+	{Code: "epic", Name: "史诗"},
+	{Code: "feature", Name: "特性"},
+	{Code: "story", Name: "用户故事"},
+}
+
+// RequirementLevels 需求层级（从上到下）
+func RequirementLevels() []config.RequirementLevelConfig {
+	if config.AppConfig != nil && len(config.AppConfig.Requirement.Levels) > 0 {
+		return config.AppConfig.Requirement.Levels
+	}
+	return defaultRequirementLevels
+}
+
+// DefaultRequirementLevel 新建需求默认的层级（最低一级）
+func DefaultRequirementLevel() string {
+	levels := RequirementLevels()
+	return levels[len(levels)-1].Code
+}
+
+// requirementLevelRank 层级序号，越小越高，无效层级返回 -1
+func requirementLevelRank(level string) int {
+	for i, item := range RequirementLevels() {
+		if item.Code == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsValidRequirementLevel 检查需求层级是否有效
+func IsValidRequirementLevel(level string) bool {
+	return requirementLevelRank(level) >= 0
+}
+
+// ValidateRequirementParent 校验需求的父需求：父需求必须存在、属于同一项目、层级高于子需求，不能是需求自己或其子孙需求
+func ValidateRequirementParent(db *gorm.DB, requirement *model.Requirement, parentID uint) error {
+	if requirement.ID != 0 && parentID == requirement.ID {
+		return errors.New("需求不能作为自己的父需求")
+	}
+	var parent model.Requirement
+	if err := db.First(&parent, parentID).Error; err != nil {
+		return errors.New("父需求不存在")
+	}
+	if parent.ProjectID != requirement.ProjectID {
+		return errors.New("父需求必须属于同一项目")
+	}
+	parentRank, rank := requirementLevelRank(parent.Level), requirementLevelRank(requirement.Level)
+	if parentRank < 0 || rank <= parentRank {
+		return errors.New("子需求的层级必须低于父需求")
+	}
+	if requirement.ID == 0 {
+		return nil
+	}
+	descendants, err := RequirementDescendantIDs(db, requirement.ID)
+	if err != nil {
+		return err
+	}
+	for _, id := range descendants {
+		if id == parentID {
+			return errors.New("不能把需求移动到自己的子需求下")
+		}
+	}
+	return nil
+}
+
+// ValidateRequirementLevel 修改层级时检查与父需求、子需求的层级关系
+func ValidateRequirementLevel(db *gorm.DB, requirement *model.Requirement) error {
+	rank := requirementLevelRank(requirement.Level)
+	if rank < 0 {
+		return errors.New("需求层级无效")
+	}
+	var levels []string
+	if err := db.Model(&model.Requirement{}).Where("parent_id = ?", requirement.ID).Distinct().Pluck("level", &levels).Error; err != nil {
+		return err
+	}
+	for _, level := range levels {
+		if requirementLevelRank(level) <= rank {
+			return errors.New("需求的层级必须高于其子需求")
+		}
+	}
+	return nil
+}
+
+// RequirementDescendantIDs 返回需求的所有子孙需求ID（不含自身），按层级逐层查询
+func RequirementDescendantIDs(db *gorm.DB, requirementID uint) ([]uint, error) {
+	result := make([]uint, 0)
+	visited := map[uint]bool{requirementID: true}
+	frontier := []uint{requirementID}
+	for len(frontier) > 0 {
+		var children []uint
+		if err := db.Model(&model.Requirement{}).Where("parent_id IN ?", frontier).Pluck("id", &children).Error; err != nil {
+			return nil, err
+		}
+		frontier = frontier[:0]
+		for _, id := range children {
+			if !visited[id] {
+				visited[id] = true
+				result = append(result, id)
+				frontier = append(frontier, id)
+			}
+		}
+	}
+	return result, nil
+}
+
+// ComputeRequirementProgress 计算项目中各需求的进度（0-100）：
+// 关联任务（状态属于工作流取消分类的除外）和子需求各算一项取平均，状态属于工作流完成分类的任务和子需求按100计算；
+// 没有关联任务和子需求的需求，已完成为100，否则为0
+func ComputeRequirementProgress(db *gorm.DB, projectIDs ...uint) (map[uint]int, error) {
+	if len(projectIDs) == 0 {
+		return make(map[uint]int), nil
+	}
+	var requirements []model.Requirement
+	if err := db.Select("id, project_id, parent_id, status").Where("project_id IN ?", projectIDs).Find(&requirements).Error; err != nil {
+		return nil, err
+	}
+	var tasks []model.Task
+	if err := db.Select("id, project_id, requirement_id, status, progress").
+		Where("project_id IN ? AND requirement_id IS NOT NULL", projectIDs).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return computeRequirementProgress(db, requirements, tasks), nil
+}
+
+// RequirementProgress 计算单个需求的进度，只加载该需求及其子孙需求和它们的关联任务
+func RequirementProgress(db *gorm.DB, requirementID uint) (int, error) {
+	ids, err := RequirementDescendantIDs(db, requirementID)
+	if err != nil {
+		return 0, err
+	}
+	ids = append(ids, requirementID)
+	var requirements []model.Requirement
+	if err := db.Select("id, project_id, parent_id, status").Where("id IN ?", ids).Find(&requirements).Error; err != nil {
+		return 0, err
+	}
+	var tasks []model.Task
+	if err := db.Select("id, project_id, requirement_id, status, progress").Where("requirement_id IN ?", ids).Find(&tasks).Error; err != nil {
+		return 0, err
+	}
+	return computeRequirementProgress(db, requirements, tasks)[requirementID], nil
+}
+
+// computeRequirementProgress 按需求层级汇总进度，任务状态属于工作流取消分类时不计入
+func computeRequirementProgress(db *gorm.DB, requirements []model.Requirement, tasks []model.Task) map[uint]int {
+	progress := make(map[uint]int)
+	byID := make(map[uint]*model.Requirement, len(requirements))
+	children := make(map[uint][]uint)
+	for i := range requirements {
+		requirement := &requirements[i]
+		byID[requirement.ID] = requirement
+		if requirement.ParentID != nil {
+			children[*requirement.ParentID] = append(children[*requirement.ParentID], requirement.ID)
+		}
+	}
+	finished := newFinishedStatusCache(db)
+	taskProgress := make(map[uint][]int)
+	for _, task := range tasks {
+		if task.RequirementID == nil || finished.cancelled(SprintItemTask, task.ProjectID)[task.Status] {
+			continue
+		}
+		value := task.Progress
+		if finished.get(SprintItemTask, task.ProjectID)[task.Status] {
+			value = 100
+		}
+		taskProgress[*task.RequirementID] = append(taskProgress[*task.RequirementID], value)
+	}
+
+	computing := make(map[uint]bool)
+	var compute func(id uint) int
+	compute = func(id uint) int {
+		if value, ok := progress[id]; ok {
+			return value
+		}
+		requirement := byID[id]
+		if finished.get(SprintItemRequirement, requirement.ProjectID)[requirement.Status] {
+			progress[id] = 100
+			return 100
+		}
+		if computing[id] {
+			return 0 // 数据中存在循环时中断
+		}
+		computing[id] = true
+		values := append([]int(nil), taskProgress[id]...)
+		for _, childID := range children[id] {
+			values = append(values, compute(childID))
+		}
+		value := 0
+		if len(values) > 0 {
+			sum := 0
+			for _, v := range values {
+				sum += v
+			}
+			value = sum / len(values)
+		}
+		progress[id] = value
+		return value
+	}
+	for id := range byID {
+		compute(id)
+	}
+	return progress
+}
+
+// FillRequirementProgress 填充需求的进度字段
+func FillRequirementProgress(db *gorm.DB, requirements []model.Requirement) error {
+	projectIDs := make([]uint, 0)
+	seen := make(map[uint]bool)
+	for _, requirement := range requirements {
+		if !seen[requirement.ProjectID] {
+			seen[requirement.ProjectID] = true
+			projectIDs = append(projectIDs, requirement.ProjectID)
+		}
+	}
+	progress, err := ComputeRequirementProgress(db, projectIDs...)
+	if err != nil {
+		return err
+	}
+	for i := range requirements {
+		requirements[i].Progress = progress[requirements[i].ID]
+	}
+	return nil
+}
+
+// RequirementTreeNode 需求层级树中的一个节点
+type RequirementTreeNode struct {
+	model.Requirement
+	Children []*RequirementTreeNode `json:"children"`
+}
+
+// BuildRequirementTree 把需求组装为树，同级按ID排序；父需求不在列表中的需求作为顶层需求
+func BuildRequirementTree(requirements []model.Requirement) []*RequirementTreeNode {
+	nodes := make(map[uint]*RequirementTreeNode, len(requirements))
+	ordered := make([]*RequirementTreeNode, 0, len(requirements))
+	for i := range requirements {
+		node := &RequirementTreeNode{Requirement: requirements[i], Children: make([]*RequirementTreeNode, 0)}
+		nodes[node.ID] = node
+		ordered = append(ordered, node)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].ID < ordered[j].ID })
+
+	roots := make([]*RequirementTreeNode, 0)
+	for _, node := range ordered {
+		if node.ParentID != nil {
+			if parent, ok := nodes[*node.ParentID]; ok && parent != node {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
@@ -15,6 +15,9 @@ type Response struct {
 const (
 	CodeSuccess = 200
 	CodeError   = 500
+
+	CodeAccountLocked   = 423 // 账号因多次登录失败被锁定
+	CodeTooManyAttempts = 429 // 登录尝试过于频繁
 )
 
 func Success(c *gin.Context, data interface{}) {
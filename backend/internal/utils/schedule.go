@@ -0,0 +1,485 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 任务依赖类型（依赖方为后续任务，被依赖方为前置任务）
+const (
+	DependencyFinishToStart  = "finish_to_start"  // 前置任务完成后，后续任务才能开始
+	DependencyStartToStart   = "start_to_start"   // 前置任务开始后，后续任务才能开始
+	DependencyFinishToFinish = "finish_to_finish" // 前置任务完成后，后续任务才能完成
+	DependencyStartToFinish  = "start_to_finish"  // 前置任务开始后，后续任务才能完成
+)
+
+// ScheduleHoursPerDay 按预估工时换算工期时每个工作日的小时数
+const ScheduleHoursPerDay = 8
+
+var ErrDependencyCycle = errors.New("任务依赖存在循环，无法排期")
+
+// IsValidDependencyType 检查依赖类型是否有效
+func IsValidDependencyType(dependencyType string) bool {
+	switch dependencyType {
+	case DependencyFinishToStart, DependencyStartToStart, DependencyFinishToFinish, DependencyStartToFinish:
+		return true
+	}
+	return false
+}
+
+// ScheduleDependency 任务的一个前置依赖
+type ScheduleDependency struct {
+	ID   uint   `json:"id"`
+	Type string `json:"type"`
+}
+
+// ScheduledTask 关键路径计算结果，日期均为工作日（含首尾），Slack 为总时差（工作日）
+type ScheduledTask struct {
+	TaskID       uint                 `json:"task_id"`
+	Title        string               `json:"title"`
+	Duration     int                  `json:"duration"` // 工期（工作日）：有开始和结束日期时按日期计算，否则按预估工时换算
+	EarlyStart   string               `json:"early_start"`
+	EarlyFinish  string               `json:"early_finish"`
+	LateStart    string               `json:"late_start"`
+	LateFinish   string               `json:"late_finish"`
+	Slack        int                  `json:"slack"`
+	Critical     bool                 `json:"critical"`
+	Dependencies []ScheduleDependency `json:"dependencies"`
+
+	// 以下为相对项目开始日的工作日序号，结束序号不含
+	plannedStart  *int
+	plannedFinish *int
+	es, ef        int
+	ls, lf        int
+	finished      bool // 状态属于工作流的完成分类
+	task          *model.Task
+	successors    []*ScheduledTask
+}
+
+// DependencyViolation 计划日期不满足依赖关系
+type DependencyViolation struct {
+	TaskID       uint   `json:"task_id"`
+	DependencyID uint   `json:"dependency_id"`
+	Type         string `json:"type"`
+	Message      string `json:"message"`
+}
+
+// ProjectSchedule 项目排期（关键路径法）
+type ProjectSchedule struct {
+	StartDate    string                `json:"start_date"`
+	FinishDate   string                `json:"finish_date"`
+	Duration     int                   `json:"duration"`      // 总工期（工作日）
+	CriticalPath []uint                `json:"critical_path"` // 关键任务，按最早开始时间排序
+	Tasks        []*ScheduledTask      `json:"tasks"`
+	Violations   []DependencyViolation `json:"violations"`
+
+	base     time.Time
+	calendar *WorkCalendar
+	order    []*ScheduledTask // 拓扑顺序
+	byID     map[uint]*ScheduledTask
+}
+
+// Task 按ID获取排期结果
+func (s *ProjectSchedule) Task(taskID uint) *ScheduledTask {
+	return s.byID[taskID]
+}
+
+// date 工作日序号对应的日期
+func (s *ProjectSchedule) date(offset int) time.Time {
+	return s.calendar.AddWorkingDays(s.base, offset)
+}
+
+// scheduleDate 日期统一为本地时区零点，避免 UTC 日期和本地日期混用时跨天
+func scheduleDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// BuildProjectSchedule 按任务依赖计算最早/最晚开始和完成时间、总时差和关键路径，并检查计划日期是否违反依赖
+// 已取消（工作流中状态分类为 cancelled）的任务不参与排期；计划开始日期作为"不早于"约束
+func BuildProjectSchedule(db *gorm.DB, project *model.Project, calendar *WorkCalendar) (*ProjectSchedule, error) {
+	if calendar == nil {
+		calendar = DefaultWorkCalendar()
+	}
+
+	var tasks []model.Task
+	query := db.Where("project_id = ?", project.ID)
+	if cancelled := CancelledStatuses(db, SprintItemTask, project.ID); len(cancelled) > 0 {
+		query = query.Where("status NOT IN ?", cancelled)
+	}
+	if err := query.Order("id").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	schedule := &ProjectSchedule{
+		calendar:     calendar,
+		byID:         make(map[uint]*ScheduledTask, len(tasks)),
+		Tasks:        make([]*ScheduledTask, 0, len(tasks)),
+		CriticalPath: make([]uint, 0),
+		Violations:   make([]DependencyViolation, 0),
+	}
+
+	// 项目开始日：项目开始日期和任务最早计划日期中较早的一个，都没有时为今天
+	var base *time.Time
+	consider := func(t *time.Time) {
+		if t != nil && (base == nil || scheduleDate(*t).Before(*base)) {
+			day := scheduleDate(*t)
+			base = &day
+		}
+	}
+	consider(project.StartDate)
+	for i := range tasks {
+		consider(tasks[i].StartDate)
+		consider(tasks[i].EndDate)
+	}
+	if base == nil {
+		today := scheduleDate(time.Now())
+		base = &today
+	}
+	schedule.base = calendar.NextWorkingDay(*base)
+
+	finished := FinishedStatuses(db, SprintItemTask, project.ID)
+	ids := make([]uint, 0, len(tasks))
+	for i := range tasks {
+		node := schedule.newScheduledTask(&tasks[i])
+		node.finished = finished[tasks[i].Status]
+		schedule.byID[node.TaskID] = node
+		schedule.Tasks = append(schedule.Tasks, node)
+		ids = append(ids, node.TaskID)
+	}
+
+	var dependencies []model.TaskDependency
+	if err := db.Where("task_id IN ?", append(ids, 0)).Order("task_id, dependency_id").Find(&dependencies).Error; err != nil {
+		return nil, err
+	}
+	for _, dependency := range dependencies {
+		node, predecessor := schedule.byID[dependency.TaskID], schedule.byID[dependency.DependencyID]
+		if node == nil || predecessor == nil || node == predecessor {
+			continue
+		}
+		dependencyType := dependency.Type
+		if !IsValidDependencyType(dependencyType) {
+			dependencyType = DependencyFinishToStart
+		}
+		node.Dependencies = append(node.Dependencies, ScheduleDependency{ID: predecessor.TaskID, Type: dependencyType})
+		predecessor.successors = append(predecessor.successors, node)
+	}
+
+	order, err := topologicalOrder(schedule.Tasks)
+	if err != nil {
+		return nil, err
+	}
+	schedule.order = order
+	schedule.computeCriticalPath()
+	schedule.checkViolations()
+	return schedule, nil
+}
+
+func (s *ProjectSchedule) newScheduledTask(task *model.Task) *ScheduledTask {
+	node := &ScheduledTask{TaskID: task.ID, Title: task.Title, task: task, Dependencies: make([]ScheduleDependency, 0)}
+
+	if task.StartDate != nil {
+		start := s.calendar.WorkingDayOffset(s.base, scheduleDate(*task.StartDate))
+		node.plannedStart = &start
+	}
+	if task.EndDate != nil {
+		finish := s.calendar.WorkingDayOffset(s.base, scheduleDate(*task.EndDate).AddDate(0, 0, 1))
+		node.plannedFinish = &finish
+	}
+
+	switch {
+	case node.plannedStart != nil && node.plannedFinish != nil:
+		node.Duration = *node.plannedFinish - *node.plannedStart
+	case task.EstimatedHours != nil:
+		node.Duration = int(math.Ceil(*task.EstimatedHours / ScheduleHoursPerDay))
+	}
+	if node.Duration < 1 {
+		node.Duration = 1
+	}
+	if node.plannedStart != nil && (node.plannedFinish == nil || *node.plannedFinish <= *node.plannedStart) {
+		finish := *node.plannedStart + node.Duration
+		node.plannedFinish = &finish
+	} else if node.plannedStart == nil && node.plannedFinish != nil {
+		start := *node.plannedFinish - node.Duration
+		node.plannedStart = &start
+	}
+	return node
+}
+
+// topologicalOrder 按依赖关系排序（前置任务在前），存在循环时返回 ErrDependencyCycle
+func topologicalOrder(nodes []*ScheduledTask) ([]*ScheduledTask, error) {
+	inDegree := make(map[*ScheduledTask]int, len(nodes))
+	for _, node := range nodes {
+		inDegree[node] += 0
+		for _, successor := range node.successors {
+			inDegree[successor]++
+		}
+	}
+	queue := make([]*ScheduledTask, 0, len(nodes))
+	for _, node := range nodes {
+		if inDegree[node] == 0 {
+			queue = append(queue, node)
+		}
+	}
+	order := make([]*ScheduledTask, 0, len(nodes))
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		order = append(order, node)
+		for _, successor := range node.successors {
+			inDegree[successor]--
+			if inDegree[successor] == 0 {
+				queue = append(queue, successor)
+			}
+		}
+	}
+	if len(order) != len(nodes) {
+		return nil, ErrDependencyCycle
+	}
+	return order, nil
+}
+
+// earliestStart 根据前置任务的开始/完成序号计算任务最早可以开始的序号
+func earliestStart(dependencyType string, predecessorStart, predecessorFinish, duration int) int {
+	switch dependencyType {
+	case DependencyStartToStart:
+		return predecessorStart
+	case DependencyFinishToFinish:
+		return predecessorFinish - duration
+	case DependencyStartToFinish:
+		return predecessorStart - duration
+	default:
+		return predecessorFinish
+	}
+}
+
+// computeCriticalPath 正推计算最早时间，逆推计算最晚时间，总时差为0的任务为关键任务
+func (s *ProjectSchedule) computeCriticalPath() {
+	finish := 0
+	for _, node := range s.order {
+		node.es = 0
+		if node.plannedStart != nil {
+			node.es = *node.plannedStart
+		}
+		for _, dependency := range node.Dependencies {
+			predecessor := s.byID[dependency.ID]
+			if start := earliestStart(dependency.Type, predecessor.es, predecessor.ef, node.Duration); start > node.es {
+				node.es = start
+			}
+		}
+		node.ef = node.es + node.Duration
+		if node.ef > finish {
+			finish = node.ef
+		}
+	}
+
+	for _, node := range s.order {
+		node.lf = finish
+	}
+	for i := len(s.order) - 1; i >= 0; i-- {
+		node := s.order[i]
+		node.ls = node.lf - node.Duration
+		for _, dependency := range node.Dependencies {
+			predecessor := s.byID[dependency.ID]
+			var latestFinish int
+			switch dependency.Type {
+			case DependencyStartToStart:
+				latestFinish = node.ls + predecessor.Duration
+			case DependencyFinishToFinish:
+				latestFinish = node.lf
+			case DependencyStartToFinish:
+				latestFinish = node.lf + predecessor.Duration
+			default:
+				latestFinish = node.ls
+			}
+			if latestFinish < predecessor.lf {
+				predecessor.lf = latestFinish
+			}
+		}
+	}
+
+	for _, node := range s.order {
+		node.Slack = node.ls - node.es
+		node.Critical = node.Slack <= 0
+		node.EarlyStart = s.date(node.es).Format("2006-01-02")
+		node.EarlyFinish = s.date(node.ef - 1).Format("2006-01-02")
+		node.LateStart = s.date(node.ls).Format("2006-01-02")
+		node.LateFinish = s.date(node.lf - 1).Format("2006-01-02")
+	}
+
+	critical := make([]*ScheduledTask, 0)
+	for _, node := range s.order {
+		if node.Critical {
+			critical = append(critical, node)
+		}
+	}
+	sort.SliceStable(critical, func(i, j int) bool { return critical[i].es < critical[j].es })
+	for _, node := range critical {
+		s.CriticalPath = append(s.CriticalPath, node.TaskID)
+	}
+
+	s.Duration = finish
+	s.StartDate = s.base.Format("2006-01-02")
+	if finish > 0 {
+		s.FinishDate = s.date(finish - 1).Format("2006-01-02")
+	} else {
+		s.FinishDate = s.StartDate
+	}
+}
+
+// checkViolations 检查计划日期是否违反依赖关系（两端都有计划日期时才检查）
+func (s *ProjectSchedule) checkViolations() {
+	for _, node := range s.Tasks {
+		if node.task.StartDate == nil && node.task.EndDate == nil {
+			continue
+		}
+		for _, dependency := range node.Dependencies {
+			predecessor := s.byID[dependency.ID]
+			if predecessor.task.StartDate == nil && predecessor.task.EndDate == nil {
+				continue
+			}
+			if *node.plannedStart >= earliestStart(dependency.Type, *predecessor.plannedStart, *predecessor.plannedFinish, node.Duration) {
+				continue
+			}
+			s.Violations = append(s.Violations, DependencyViolation{
+				TaskID:       node.TaskID,
+				DependencyID: predecessor.TaskID,
+				Type:         dependency.Type,
+				Message:      dependencyViolationMessage(dependency.Type, node.Title, predecessor.Title),
+			})
+		}
+	}
+}
+
+func dependencyViolationMessage(dependencyType, title, predecessorTitle string) string {
+	switch dependencyType {
+	case DependencyStartToStart:
+		return fmt.Sprintf("任务「%s」的开始日期早于前置任务「%s」的开始日期", title, predecessorTitle)
+	case DependencyFinishToFinish:
+		return fmt.Sprintf("任务「%s」的结束日期早于前置任务「%s」的结束日期", title, predecessorTitle)
+	case DependencyStartToFinish:
+		return fmt.Sprintf("任务「%s」的结束日期早于前置任务「%s」的开始日期", title, predecessorTitle)
+	default:
+		return fmt.Sprintf("任务「%s」在前置任务「%s」完成前开始", title, predecessorTitle)
+	}
+}
+
+// ScheduleShift 自动排期对一个任务日期的调整
+type ScheduleShift struct {
+	TaskID       uint   `json:"task_id"`
+	Title        string `json:"title"`
+	Days         int    `json:"days"` // 顺延的工作日数
+	OldStartDate string `json:"old_start_date,omitempty"`
+	NewStartDate string `json:"new_start_date,omitempty"`
+	OldEndDate   string `json:"old_end_date,omitempty"`
+	NewEndDate   string `json:"new_end_date,omitempty"`
+}
+
+// AutoSchedule 自动排期：按依赖关系把计划开始过早的后续任务连同结束日期一起顺延（保持工期不变，只往后移）
+// fromTaskID 不为空时只调整该任务的下游任务；已完成/已关闭的任务和没有计划日期的任务不调整
+// dryRun 为 true 时只返回调整方案，不写入数据库
+func AutoSchedule(db *gorm.DB, schedule *ProjectSchedule, fromTaskID *uint, actorID uint, dryRun bool) ([]ScheduleShift, error) {
+	var downstream map[*ScheduledTask]bool
+	if fromTaskID != nil {
+		root := schedule.byID[*fromTaskID]
+		if root == nil {
+			return nil, errors.New("任务不存在或不属于该项目")
+		}
+		downstream = make(map[*ScheduledTask]bool)
+		stack := []*ScheduledTask{root}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, successor := range node.successors {
+				if !downstream[successor] {
+					downstream[successor] = true
+					stack = append(stack, successor)
+				}
+			}
+		}
+	}
+
+	// 没有计划日期的任务按最早时间参与约束传递
+	starts := make(map[*ScheduledTask]int, len(schedule.order))
+	finishes := make(map[*ScheduledTask]int, len(schedule.order))
+	shifts := make([]ScheduleShift, 0)
+	for _, node := range schedule.order {
+		start, finish := node.es, node.ef
+		if node.plannedStart != nil {
+			start, finish = *node.plannedStart, *node.plannedFinish
+		}
+
+		movable := node.plannedStart != nil && !node.finished &&
+			(downstream == nil || downstream[node])
+		if movable {
+			required := start
+			for _, dependency := range node.Dependencies {
+				predecessor := schedule.byID[dependency.ID]
+				if value := earliestStart(dependency.Type, starts[predecessor], finishes[predecessor], node.Duration); value > required {
+					required = value
+				}
+			}
+			if delta := required - start; delta > 0 {
+				shifts = append(shifts, schedule.shiftTask(node, delta))
+				start, finish = start+delta, finish+delta
+			}
+		}
+		starts[node], finishes[node] = start, finish
+	}
+
+	if dryRun || len(shifts) == 0 {
+		return shifts, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, shift := range shifts {
+			task := schedule.byID[shift.TaskID].task
+			if err := tx.Model(&model.Task{}).Where("id = ?", task.ID).
+				Updates(map[string]interface{}{"start_date": task.StartDate, "end_date": task.EndDate}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, shift := range shifts {
+		var changes []HistoryChange
+		if shift.OldStartDate != shift.NewStartDate {
+			changes = append(changes, HistoryChange{Field: "start_date", Old: shift.OldStartDate, New: shift.NewStartDate})
+		}
+		if shift.OldEndDate != shift.NewEndDate {
+			changes = append(changes, HistoryChange{Field: "end_date", Old: shift.OldEndDate, New: shift.NewEndDate})
+		}
+		actionID, err := RecordAction(db, "task", shift.TaskID, "edited", actorID, fmt.Sprintf("自动排期：随前置任务顺延%d个工作日", shift.Days), nil)
+		if err == nil {
+			RecordHistory(db, actionID, changes)
+		}
+	}
+	return shifts, nil
+}
+
+// shiftTask 把任务的计划日期顺延 delta 个工作日（修改内存中的任务，由调用方保存）
+func (s *ProjectSchedule) shiftTask(node *ScheduledTask, delta int) ScheduleShift {
+	task := node.task
+	shift := ScheduleShift{TaskID: node.TaskID, Title: node.Title, Days: delta}
+	if task.StartDate != nil {
+		shift.OldStartDate = task.StartDate.Format("2006-01-02")
+		start := s.date(*node.plannedStart + delta)
+		task.StartDate = &start
+		shift.NewStartDate = start.Format("2006-01-02")
+	}
+	if task.EndDate != nil {
+		shift.OldEndDate = task.EndDate.Format("2006-01-02")
+		end := s.date(*node.plannedFinish + delta - 1)
+		task.EndDate = &end
+		shift.NewEndDate = end.Format("2006-01-02")
+	}
+	return shift
+}
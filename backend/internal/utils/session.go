@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 会话吊销原因
+const (
+	SessionRevokedLogout          = "logout"               // 用户登出
+	SessionRevokedPasswordChanged = "password_changed"     // 修改密码
+	SessionRevokedPasswordReset   = "password_reset"       // 通过重置链接重置密码
+	SessionRevokedUserDisabled    = "user_disabled"        // 用户被禁用或删除
+	SessionRevokedRemoteLogout    = "remote_logout"        // 用户在其他设备上注销
+	SessionRevokedForceLogout     = "force_logout"         // 管理员强制下线
+	SessionRevokedTokenReused     = "refresh_token_reused" // 已轮换的 Refresh Token 被重复使用
+)
+
+// sessionTouchInterval 最后活跃时间的更新间隔，避免每个请求都写库
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrSessionNotFound = errors.New("会话不存在")
+	ErrSessionRevoked  = errors.New("会话已失效，请重新登录")
+	ErrUserDisabled    = errors.New("用户已被禁用")
+	// ErrRefreshTokenReused 已轮换的 Refresh Token 被再次使用，会话已被吊销
+	ErrRefreshTokenReused = errors.New("RefreshToken已失效，检测到重复使用，请重新登录")
+)
+
+// SessionTokens 登录会话签发的Token
+type SessionTokens struct {
+	SessionID      string
+	Token          string
+	RefreshToken   string
+	RefreshTokenID string
+}
+
+// CreateSession 为用户创建登录会话并签发 Access Token 和 Refresh Token
+func CreateSession(db *gorm.DB, user *model.User, roleNames []string, loginMethod string, c *gin.Context) (*SessionTokens, error) {
+	if user.IsServiceAccount {
+		return nil, ErrServiceAccountLogin
+	}
+
+	now := time.Now()
+	session := model.UserSession{
+		SessionID:    uuid.NewString(),
+		UserID:       user.ID,
+		LoginMethod:  loginMethod,
+		LastActiveAt: now,
+		ExpiresAt:    now.Add(auth.RefreshTokenExpiration),
+	}
+	if c != nil && c.Request != nil {
+		session.IPAddress = c.ClientIP()
+		session.UserAgent = truncateString(c.Request.UserAgent(), 500)
+	}
+
+	tokens, err := issueSessionTokens(user, roleNames, session.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenID = tokens.RefreshTokenID
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RotateRefreshToken 使用 Refresh Token 续期会话：签发新的Token对，旧的 Refresh Token 立即失效
+// 如果出示的是已轮换过的 Refresh Token（可能已泄露），吊销整个会话并返回 ErrRefreshTokenReused
+func RotateRefreshToken(db *gorm.DB, user *model.User, roleNames []string, claims *auth.Claims) (*SessionTokens, error) {
+	session, err := GetActiveSession(db, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != user.ID {
+		return nil, ErrSessionRevoked
+	}
+	if claims.ID == "" || claims.ID != session.RefreshTokenID {
+		RevokeSession(db, session.SessionID, SessionRevokedTokenReused)
+		return nil, ErrRefreshTokenReused
+	}
+
+	tokens, err := issueSessionTokens(user, roleNames, session.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 以旧的Token ID为条件更新，并发刷新时只有一个请求能成功，其余视为重复使用
+	now := time.Now()
+	result := db.Model(&model.UserSession{}).
+		Where("id = ? AND refresh_token_id = ? AND revoked_at IS NULL", session.ID, claims.ID).
+		Updates(map[string]interface{}{
+			"refresh_token_id": tokens.RefreshTokenID,
+			"refresh_count":    gorm.Expr("refresh_count + 1"),
+			"refreshed_at":     now,
+			"last_active_at":   now,
+			"expires_at":       now.Add(auth.RefreshTokenExpiration),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		RevokeSession(db, session.SessionID, SessionRevokedTokenReused)
+		return nil, ErrRefreshTokenReused
+	}
+	return tokens, nil
+}
+
+func issueSessionTokens(user *model.User, roleNames []string, sessionID string) (*SessionTokens, error) {
+	token, err := auth.GenerateSessionToken(user.ID, user.Username, roleNames, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshTokenID, err := auth.GenerateSessionRefreshToken(user.ID, user.Username, roleNames, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{SessionID: sessionID, Token: token, RefreshToken: refreshToken, RefreshTokenID: refreshTokenID}, nil
+}
+
+// GetActiveSession 获取未吊销且未过期的会话
+func GetActiveSession(db *gorm.DB, sessionID string) (*model.UserSession, error) {
+	var session model.UserSession
+	if err := db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+	return &session, nil
+}
+
+// ValidateTokenSession 校验Token对应的会话和用户状态
+// 会话被吊销、过期或用户被禁用时返回错误；未携带会话ID的Token（会话功能上线前签发）无法吊销，一律拒绝
+func ValidateTokenSession(db *gorm.DB, claims *auth.Claims) error {
+	if claims.SessionID == "" {
+		return ErrSessionRevoked
+	}
+	session, err := GetActiveSession(db, claims.SessionID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return ErrSessionRevoked
+		}
+		return err
+	}
+	if session.UserID != claims.UserID {
+		return ErrSessionRevoked
+	}
+	if time.Since(session.LastActiveAt) > sessionTouchInterval {
+		db.Model(&model.UserSession{}).Where("id = ?", session.ID).UpdateColumn("last_active_at", time.Now())
+	}
+
+	var user model.User
+	if err := db.Select("id", "status").First(&user, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionRevoked
+		}
+		return err
+	}
+	if user.Status != 1 {
+		return ErrUserDisabled
+	}
+	return nil
+}
+
+// RevokeSession 吊销指定会话
+func RevokeSession(db *gorm.DB, sessionID, reason string) error {
+	if sessionID == "" {
+		return nil
+	}
+	return db.Model(&model.UserSession{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// RevokeUserSessions 吊销用户的所有有效会话，exceptSessionID 不为空时保留该会话，返回吊销的会话数
+func RevokeUserSessions(db *gorm.DB, userID uint, exceptSessionID, reason string) (int64, error) {
+	query := db.Model(&model.UserSession{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptSessionID != "" {
+		query = query.Where("session_id <> ?", exceptSessionID)
+	}
+	result := query.Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason})
+	return result.RowsAffected, result.Error
+}
+
+// GetSessionID 从上下文获取当前请求的会话ID
+func GetSessionID(c *gin.Context) string {
+	if sessionID, exists := c.Get("session_id"); exists {
+		if id, ok := sessionID.(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func truncateString(s string, max int) string {
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max])
+	}
+	return s
+}
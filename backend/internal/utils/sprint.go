@@ -0,0 +1,424 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 迭代状态
+const (
+	SprintStatusPlanned = "planned" // 未开始
+	SprintStatusActive  = "active"  // 进行中
+	SprintStatusClosed  = "closed"  // 已关闭
+)
+
+// 可以规划到迭代中的工作项类型
+const (
+	SprintItemTask        = "task"
+	SprintItemBug         = "bug"
+	SprintItemRequirement = "requirement"
+)
+
+// SprintItemTypes 迭代工作项类型（按展示顺序）
+var SprintItemTypes = []string{SprintItemRequirement, SprintItemTask, SprintItemBug}
+
+var (
+	ErrSprintClosed        = errors.New("迭代已关闭")
+	ErrSprintNotActive     = errors.New("只有进行中的迭代可以关闭")
+	ErrSprintAlreadyActive = errors.New("项目已有进行中的迭代，请先关闭")
+	ErrSprintNextInvalid   = errors.New("下一个迭代必须是同一项目中未关闭的其他迭代")
+)
+
+// FinishedStatuses 工作项在项目生效工作流中视为完成的状态（状态分类为 done 或 cancelled），迭代关闭时其余状态的工作项转入下一个迭代
+func FinishedStatuses(db *gorm.DB, itemType string, projectID uint) map[string]bool {
+	finished := make(map[string]bool)
+	workflow := GetWorkflow(db, itemType, projectID)
+	if workflow == nil {
+		return finished
+	}
+	for _, state := range workflow.States {
+		if state.Category == WorkflowCategoryDone || state.Category == WorkflowCategoryCancelled {
+			finished[state.Code] = true
+		}
+	}
+	return finished
+}
+
+// CancelledStatuses 工作项在项目生效工作流中的取消状态（状态分类为 cancelled），不参与排期和进度计算
+func CancelledStatuses(db *gorm.DB, itemType string, projectID uint) []string {
+	cancelled := make([]string, 0)
+	workflow := GetWorkflow(db, itemType, projectID)
+	if workflow == nil {
+		return cancelled
+	}
+	for _, state := range workflow.States {
+		if state.Category == WorkflowCategoryCancelled {
+			cancelled = append(cancelled, state.Code)
+		}
+	}
+	return cancelled
+}
+
+// IsWorkItemFinished 工作项的状态在项目工作流中是否属于完成分类
+func IsWorkItemFinished(db *gorm.DB, itemType string, projectID uint, status string) bool {
+	return FinishedStatuses(db, itemType, projectID)[status]
+}
+
+// finishedStatusCache 按工作项类型和项目缓存完成状态和取消状态，批量判断多个项目的工作项时避免重复加载工作流
+type finishedStatusCache struct {
+	db                *gorm.DB
+	statuses          map[string]map[string]bool
+	cancelledStatuses map[string]map[string]bool
+}
+
+func newFinishedStatusCache(db *gorm.DB) *finishedStatusCache {
+	return &finishedStatusCache{db: db, statuses: make(map[string]map[string]bool), cancelledStatuses: make(map[string]map[string]bool)}
+}
+
+// get 获取工作项类型在项目中视为完成的状态
+func (c *finishedStatusCache) get(itemType string, projectID uint) map[string]bool {
+	key := itemType + ":" + strconv.FormatUint(uint64(projectID), 10)
+	finished, ok := c.statuses[key]
+	if !ok {
+		finished = FinishedStatuses(c.db, itemType, projectID)
+		c.statuses[key] = finished
+	}
+	return finished
+}
+
+// cancelled 获取工作项类型在项目中的取消状态
+func (c *finishedStatusCache) cancelled(itemType string, projectID uint) map[string]bool {
+	key := itemType + ":" + strconv.FormatUint(uint64(projectID), 10)
+	cancelled, ok := c.cancelledStatuses[key]
+	if !ok {
+		cancelled = make(map[string]bool)
+		for _, status := range CancelledStatuses(c.db, itemType, projectID) {
+			cancelled[status] = true
+		}
+		c.cancelledStatuses[key] = cancelled
+	}
+	return cancelled
+}
+
+// IsValidSprintItemType 检查工作项类型是否可以规划到迭代
+func IsValidSprintItemType(itemType string) bool {
+	for _, t := range SprintItemTypes {
+		if t == itemType {
+			return true
+		}
+	}
+	return false
+}
+
+// sprintItemModel 工作项类型对应的模型
+func sprintItemModel(itemType string) interface{} {
+	switch itemType {
+	case SprintItemTask:
+		return &model.Task{}
+	case SprintItemBug:
+		return &model.Bug{}
+	case SprintItemRequirement:
+		return &model.Requirement{}
+	}
+	return nil
+}
+
+// sprintItemRow 迭代工作项的公共字段
+type sprintItemRow struct {
+	ID             uint
+	ProjectID      uint
+	SprintID       *uint
+	Status         string
+	EstimatedHours *float64
+}
+
+// SprintItemStats 迭代中一类工作项的统计
+type SprintItemStats struct {
+	Total          int     `json:"total"`
+	Finished       int     `json:"finished"`
+	EstimatedHours float64 `json:"estimated_hours"`
+}
+
+// SprintSummary 迭代统计：各类工作项数量、预估工时与容量占用
+type SprintSummary struct {
+	Items          map[string]*SprintItemStats `json:"items"`
+	EstimatedHours float64                     `json:"estimated_hours"` // 已规划的预估工时
+	Capacity       float64                     `json:"capacity"`
+	LoadRate       float64                     `json:"load_rate"` // 预估工时占容量的百分比，容量为0时为0
+}
+
+// SprintCloseResult 关闭迭代的结果
+type SprintCloseResult struct {
+	NextSprintID *uint          `json:"next_sprint_id"` // 接收未完成工作项的迭代，为空表示转回待办池
+	CarriedOver  map[string]int `json:"carried_over"`   // 各类转出的工作项数量
+	Finished     map[string]int `json:"finished"`       // 各类已完成的工作项数量
+}
+
+// ParseSprintDate 解析迭代日期（YYYY-MM-DD）
+func ParseSprintDate(value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("日期格式错误，应为 YYYY-MM-DD: %s", value)
+	}
+	return t, nil
+}
+
+// FilterBySprint 按迭代筛选工作项，sprintID 为 0 时筛选未规划到迭代的工作项（待办池）
+func FilterBySprint(query *gorm.DB, sprintID string) *gorm.DB {
+	if sprintID == "" {
+		return query
+	}
+	if sprintID == "0" {
+		return query.Where("sprint_id IS NULL")
+	}
+	return query.Where("sprint_id = ?", sprintID)
+}
+
+// GetActiveSprint 获取项目进行中的迭代，没有时返回 gorm.ErrRecordNotFound
+func GetActiveSprint(db *gorm.DB, projectID uint) (*model.Sprint, error) {
+	var sprint model.Sprint
+	if err := db.Where("project_id = ? AND status = ?", projectID, SprintStatusActive).First(&sprint).Error; err != nil {
+		return nil, err
+	}
+	return &sprint, nil
+}
+
+// GetSprintSummary 统计迭代中的工作项
+func GetSprintSummary(db *gorm.DB, sprint *model.Sprint) (*SprintSummary, error) {
+	summary := &SprintSummary{Items: make(map[string]*SprintItemStats), Capacity: sprint.Capacity}
+	for _, itemType := range SprintItemTypes {
+		var rows []sprintItemRow
+		if err := db.Model(sprintItemModel(itemType)).Where("sprint_id = ?", sprint.ID).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		// 汇总任务的工时由子任务汇总而来，同样不重复计算
+		summaryTasks := make(map[uint]bool)
+		if itemType == SprintItemTask {
+			ids := make([]uint, 0, len(rows))
+			for _, row := range rows {
+				ids = append(ids, row.ID)
+			}
+			var err error
+			if summaryTasks, err = SummaryTaskIDs(db, ids); err != nil {
+				return nil, err
+			}
+		}
+		finished := FinishedStatuses(db, itemType, sprint.ProjectID)
+		stats := &SprintItemStats{}
+		for _, row := range rows {
+			stats.Total++
+			if finished[row.Status] {
+				stats.Finished++
+			}
+			// 需求的工时由任务分解承担，只统计任务和Bug，避免重复计算
+			if row.EstimatedHours != nil && itemType != SprintItemRequirement && !summaryTasks[row.ID] {
+				stats.EstimatedHours += *row.EstimatedHours
+			}
+		}
+		summary.Items[itemType] = stats
+		summary.EstimatedHours += stats.EstimatedHours
+	}
+	if sprint.Capacity > 0 {
+		summary.LoadRate = float64(int(summary.EstimatedHours/sprint.Capacity*10000+0.5)) / 100
+	}
+	return summary, nil
+}
+
+// GetSprintItemIDs 获取迭代中某类工作项的ID
+func GetSprintItemIDs(db *gorm.DB, sprintID uint, itemType string) ([]uint, error) {
+	var ids []uint
+	err := db.Model(sprintItemModel(itemType)).Where("sprint_id = ?", sprintID).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// SetSprintItems 将工作项加入迭代（sprintID 为 nil 时移出迭代，转回待办池），items 为按类型分组的工作项ID
+// 工作项必须属于迭代所在的项目，全部校验通过后才会修改；每个变更记录一条操作和 sprint_id 字段历史，用于还原迭代范围的变化
+func SetSprintItems(db *gorm.DB, projectID uint, sprintID *uint, items map[string][]uint, actorID uint) (map[string]int, error) {
+	rowsByType := make(map[string][]sprintItemRow)
+	for itemType, ids := range items {
+		itemModel := sprintItemModel(itemType)
+		if itemModel == nil {
+			return nil, fmt.Errorf("不支持的工作项类型: %s", itemType)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		var rows []sprintItemRow
+		if err := db.Model(itemModel).Where("id IN ?", ids).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		if len(rows) != len(dedupeUints(ids)) {
+			return nil, fmt.Errorf("部分%s不存在", sprintItemLabel(itemType))
+		}
+		for _, row := range rows {
+			if row.ProjectID != projectID {
+				return nil, fmt.Errorf("%s %d 不属于迭代所在的项目", sprintItemLabel(itemType), row.ID)
+			}
+		}
+		rowsByType[itemType] = rows
+	}
+
+	changed := make(map[string]int)
+	var moves []sprintItemMove
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for itemType, rows := range rowsByType {
+			for _, row := range rows {
+				if sprintIDString(row.SprintID) == sprintIDString(sprintID) {
+					continue
+				}
+				if err := tx.Model(sprintItemModel(itemType)).Where("id = ?", row.ID).Update("sprint_id", sprintID).Error; err != nil {
+					return err
+				}
+				moves = append(moves, sprintItemMove{itemType: itemType, id: row.ID, from: row.SprintID, to: sprintID})
+				changed[itemType]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordSprintItemMoves(db, moves, actorID, nil)
+	return changed, nil
+}
+
+// StartSprint 开始迭代，同一项目同时只能有一个进行中的迭代
+func StartSprint(db *gorm.DB, sprint *model.Sprint) error {
+	if sprint.Status != SprintStatusPlanned {
+		return errors.New("只有未开始的迭代可以开始")
+	}
+	if active, err := GetActiveSprint(db, sprint.ProjectID); err == nil && active.ID != sprint.ID {
+		return ErrSprintAlreadyActive
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	now := time.Now()
+	sprint.Status = SprintStatusActive
+	sprint.StartedAt = &now
+	return db.Model(sprint).Updates(map[string]interface{}{"status": sprint.Status, "started_at": now}).Error
+}
+
+// CloseSprint 关闭迭代，未完成的工作项转入下一个迭代
+// nextSprintID 为空时选择同一项目中开始日期最早的未开始迭代，没有未开始的迭代时转回待办池
+func CloseSprint(db *gorm.DB, sprint *model.Sprint, nextSprintID *uint, actorID uint) (*SprintCloseResult, error) {
+	if sprint.Status != SprintStatusActive {
+		return nil, ErrSprintNotActive
+	}
+
+	var next *model.Sprint
+	if nextSprintID != nil && *nextSprintID != 0 {
+		var candidate model.Sprint
+		if err := db.First(&candidate, *nextSprintID).Error; err != nil ||
+			candidate.ID == sprint.ID || candidate.ProjectID != sprint.ProjectID || candidate.Status == SprintStatusClosed {
+			return nil, ErrSprintNextInvalid
+		}
+		next = &candidate
+	} else {
+		var candidate model.Sprint
+		err := db.Where("project_id = ? AND status = ? AND id <> ?", sprint.ProjectID, SprintStatusPlanned, sprint.ID).
+			Order("start_date ASC, id ASC").First(&candidate).Error
+		if err == nil {
+			next = &candidate
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	result := &SprintCloseResult{CarriedOver: make(map[string]int), Finished: make(map[string]int)}
+	if next != nil {
+		result.NextSprintID = &next.ID
+	}
+	var moves []sprintItemMove
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, itemType := range SprintItemTypes {
+			var rows []sprintItemRow
+			if err := tx.Model(sprintItemModel(itemType)).Where("sprint_id = ?", sprint.ID).Find(&rows).Error; err != nil {
+				return err
+			}
+			finished := FinishedStatuses(tx, itemType, sprint.ProjectID)
+			var unfinished []uint
+			for _, row := range rows {
+				if finished[row.Status] {
+					result.Finished[itemType]++
+					continue
+				}
+				unfinished = append(unfinished, row.ID)
+				moves = append(moves, sprintItemMove{itemType: itemType, id: row.ID, from: row.SprintID, to: result.NextSprintID})
+			}
+			if len(unfinished) > 0 {
+				if err := tx.Model(sprintItemModel(itemType)).Where("id IN ?", unfinished).Update("sprint_id", result.NextSprintID).Error; err != nil {
+					return err
+				}
+			}
+			result.CarriedOver[itemType] = len(unfinished)
+		}
+		now := time.Now()
+		sprint.Status = SprintStatusClosed
+		sprint.ClosedAt = &now
+		return tx.Model(sprint).Updates(map[string]interface{}{"status": sprint.Status, "closed_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordSprintItemMoves(db, moves, actorID, map[string]interface{}{"carried_over_from": sprint.ID})
+	return result, nil
+}
+
+// sprintItemMove 一个工作项所属迭代的变更
+type sprintItemMove struct {
+	itemType string
+	id       uint
+	from, to *uint
+}
+
+// recordSprintItemMoves 为迭代变更记录操作和 sprint_id 字段历史（事务提交后调用，避免推送未生效的变更）
+func recordSprintItemMoves(db *gorm.DB, moves []sprintItemMove, actorID uint, extra interface{}) {
+	for _, move := range moves {
+		actionID, err := RecordAction(db, move.itemType, move.id, "edited", actorID, "", extra)
+		if err == nil {
+			err = RecordHistory(db, actionID, []HistoryChange{
+				{Field: "sprint_id", Old: sprintIDString(move.from), New: sprintIDString(move.to)},
+			})
+		}
+		if err != nil && Logger != nil {
+			Logger.Errorf("记录迭代变更失败: %s %d, error=%v", move.itemType, move.id, err)
+		}
+	}
+}
+
+func sprintIDString(sprintID *uint) string {
+	if sprintID == nil || *sprintID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*sprintID), 10)
+}
+
+func sprintItemLabel(itemType string) string {
+	switch itemType {
+	case SprintItemTask:
+		return "任务"
+	case SprintItemBug:
+		return "Bug"
+	case SprintItemRequirement:
+		return "需求"
+	}
+	return itemType
+}
+
+func dedupeUints(values []uint) []uint {
+	seen := make(map[uint]bool, len(values))
+	result := make([]uint, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
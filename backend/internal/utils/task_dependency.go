@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 依赖关系完整性问题类型
+const (
+	DependencyIssueCycle        = "cycle"         // 循环依赖
+	DependencyIssueSelf         = "self"          // 依赖自己
+	DependencyIssueCrossProject = "cross_project" // 依赖其他项目的任务
+	DependencyIssueMissing      = "missing"       // 依赖的任务不存在或已删除
+)
+
+// TaskDependencyError 任务依赖校验失败，形成循环时 Path 为循环路径（依赖方向，首尾为同一任务）
+type TaskDependencyError struct {
+	Message string
+	Path    []uint
+}
+
+func (e *TaskDependencyError) Error() string {
+	return e.Message
+}
+
+// dependencyGraph 项目内的依赖关系图，边的方向为任务 -> 前置任务
+type dependencyGraph struct {
+	titles map[uint]string
+	edges  map[uint][]uint
+}
+
+// loadDependencyGraph 加载项目内未删除任务之间的依赖关系
+func loadDependencyGraph(db *gorm.DB, projectID uint) (*dependencyGraph, error) {
+	var tasks []model.Task
+	if err := db.Select("id, title").Where("project_id = ?", projectID).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	graph := &dependencyGraph{titles: make(map[uint]string, len(tasks)), edges: make(map[uint][]uint)}
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		graph.titles[task.ID] = task.Title
+		ids = append(ids, task.ID)
+	}
+
+	var dependencies []model.TaskDependency
+	if err := db.Where("task_id IN ?", append(ids, 0)).Order("task_id, dependency_id").Find(&dependencies).Error; err != nil {
+		return nil, err
+	}
+	for _, dependency := range dependencies {
+		if _, ok := graph.titles[dependency.DependencyID]; ok {
+			graph.edges[dependency.TaskID] = append(graph.edges[dependency.TaskID], dependency.DependencyID)
+		}
+	}
+	return graph, nil
+}
+
+// path 查找 from 沿依赖方向到达 to 的路径（广度优先，返回最短路径），不可达时返回 nil
+func (g *dependencyGraph) path(from, to uint) []uint {
+	previous := map[uint]uint{from: from}
+	queue := []uint{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == to {
+			path := []uint{to}
+			for node != from {
+				node = previous[node]
+				path = append([]uint{node}, path...)
+			}
+			return path
+		}
+		for _, next := range g.edges[node] {
+			if _, seen := previous[next]; !seen {
+				previous[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// describePath 把任务ID路径格式化为 "#1 标题 → #2 标题"
+func (g *dependencyGraph) describePath(path []uint) string {
+	parts := make([]string, 0, len(path))
+	for _, id := range path {
+		parts = append(parts, fmt.Sprintf("#%d %s", id, g.titles[id]))
+	}
+	return strings.Join(parts, " → ")
+}
+
+// ValidateTaskDependencies 校验任务的前置任务集合（整体替换原有依赖）：
+// 不能依赖自己，前置任务必须存在且属于同一项目，依赖关系不能形成循环
+func ValidateTaskDependencies(db *gorm.DB, task *model.Task, dependencyIDs []uint) error {
+	if len(dependencyIDs) == 0 {
+		return nil
+	}
+	for _, id := range dependencyIDs {
+		if id == task.ID {
+			return &TaskDependencyError{Message: "任务不能依赖自己", Path: []uint{task.ID, task.ID}}
+		}
+	}
+
+	var dependencies []model.Task
+	if err := db.Select("id, project_id").Where("id IN ?", dependencyIDs).Find(&dependencies).Error; err != nil {
+		return err
+	}
+	found := make(map[uint]bool, len(dependencies))
+	for _, dependency := range dependencies {
+		if dependency.ProjectID != task.ProjectID {
+			return &TaskDependencyError{Message: fmt.Sprintf("依赖任务 #%d 不属于同一项目", dependency.ID)}
+		}
+		found[dependency.ID] = true
+	}
+	for _, id := range dependencyIDs {
+		if !found[id] {
+			return &TaskDependencyError{Message: fmt.Sprintf("依赖任务 #%d 不存在", id)}
+		}
+	}
+
+	graph, err := loadDependencyGraph(db, task.ProjectID)
+	if err != nil {
+		return err
+	}
+	graph.edges[task.ID] = nil
+	for _, id := range dependencyIDs {
+		if path := graph.path(id, task.ID); path != nil {
+			cycle := append([]uint{task.ID}, path...)
+			return &TaskDependencyError{Message: "依赖关系形成循环：" + graph.describePath(cycle), Path: cycle}
+		}
+	}
+	return nil
+}
+
+// DependencyIssue 依赖关系完整性问题
+type DependencyIssue struct {
+	Type         string `json:"type"`
+	TaskID       uint   `json:"task_id"`
+	DependencyID uint   `json:"dependency_id,omitempty"`
+	Path         []uint `json:"path,omitempty"` // 循环路径（首尾为同一任务）
+	Message      string `json:"message"`
+}
+
+// DependencyIntegrityReport 项目依赖关系完整性检查结果
+type DependencyIntegrityReport struct {
+	ProjectID       uint              `json:"project_id"`
+	TaskCount       int               `json:"task_count"`
+	DependencyCount int               `json:"dependency_count"`
+	Valid           bool              `json:"valid"`
+	Issues          []DependencyIssue `json:"issues"`
+}
+
+// CheckProjectDependencies 检查项目已有的依赖关系（如从禅道迁移的数据）：
+// 循环依赖（每个强连通分量报告一条循环路径）、依赖自己、跨项目依赖以及依赖已删除的任务
+func CheckProjectDependencies(db *gorm.DB, projectID uint) (*DependencyIntegrityReport, error) {
+	graph, err := loadDependencyGraph(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	report := &DependencyIntegrityReport{ProjectID: projectID, TaskCount: len(graph.titles), Issues: make([]DependencyIssue, 0)}
+
+	ids := make([]uint, 0, len(graph.titles))
+	for id := range graph.titles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var dependencies []model.TaskDependency
+	if err := db.Where("task_id IN ?", append(ids, 0)).Order("task_id, dependency_id").Find(&dependencies).Error; err != nil {
+		return nil, err
+	}
+	report.DependencyCount = len(dependencies)
+
+	// 项目外的前置任务：属于其他项目，或不存在/已删除
+	external := make([]uint, 0)
+	for _, dependency := range dependencies {
+		if _, ok := graph.titles[dependency.DependencyID]; !ok {
+			external = append(external, dependency.DependencyID)
+		}
+	}
+	externalProjects := make(map[uint]uint)
+	if len(external) > 0 {
+		var tasks []model.Task
+		if err := db.Select("id, project_id").Where("id IN ?", external).Find(&tasks).Error; err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			externalProjects[task.ID] = task.ProjectID
+		}
+	}
+
+	for _, dependency := range dependencies {
+		issue := DependencyIssue{TaskID: dependency.TaskID, DependencyID: dependency.DependencyID}
+		title := graph.titles[dependency.TaskID]
+		if dependency.TaskID == dependency.DependencyID {
+			issue.Type = DependencyIssueSelf
+			issue.Path = []uint{dependency.TaskID, dependency.TaskID}
+			issue.Message = fmt.Sprintf("任务 #%d %s 依赖自己", dependency.TaskID, title)
+		} else if _, ok := graph.titles[dependency.DependencyID]; ok {
+			continue
+		} else if otherProject, ok := externalProjects[dependency.DependencyID]; ok {
+			issue.Type = DependencyIssueCrossProject
+			issue.Message = fmt.Sprintf("任务 #%d %s 依赖其他项目（#%d）的任务 #%d", dependency.TaskID, title, otherProject, dependency.DependencyID)
+		} else {
+			issue.Type = DependencyIssueMissing
+			issue.Message = fmt.Sprintf("任务 #%d %s 依赖的任务 #%d 不存在或已删除", dependency.TaskID, title, dependency.DependencyID)
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	for _, component := range graph.cyclicComponents(ids) {
+		cycle := graph.cycleIn(component)
+		report.Issues = append(report.Issues, DependencyIssue{
+			Type:    DependencyIssueCycle,
+			TaskID:  cycle[0],
+			Path:    cycle,
+			Message: "依赖关系形成循环：" + graph.describePath(cycle),
+		})
+	}
+
+	report.Valid = len(report.Issues) == 0
+	return report, nil
+}
+
+// cyclicComponents 用 Tarjan 算法找出包含多个任务的强连通分量（即存在循环的任务组）
+func (g *dependencyGraph) cyclicComponents(ids []uint) [][]uint {
+	index := 0
+	indices := make(map[uint]int, len(ids))
+	lowLinks := make(map[uint]int, len(ids))
+	onStack := make(map[uint]bool, len(ids))
+	stack := make([]uint, 0)
+	components := make([][]uint, 0)
+
+	var visit func(node uint)
+	visit = func(node uint) {
+		indices[node], lowLinks[node] = index, index
+		index++
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range g.edges[node] {
+			if next == node {
+				continue // 依赖自己单独报告
+			}
+			if _, visited := indices[next]; !visited {
+				visit(next)
+				if lowLinks[next] < lowLinks[node] {
+					lowLinks[node] = lowLinks[next]
+				}
+			} else if onStack[next] && indices[next] < lowLinks[node] {
+				lowLinks[node] = indices[next]
+			}
+		}
+		if lowLinks[node] != indices[node] {
+			return
+		}
+		component := make([]uint, 0)
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == node {
+				break
+			}
+		}
+		if len(component) > 1 {
+			sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+			components = append(components, component)
+		}
+	}
+
+	for _, id := range ids {
+		if _, visited := indices[id]; !visited {
+			visit(id)
+		}
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i][0] < components[j][0] })
+	return components
+}
+
+// cycleIn 在强连通分量中找一条经过最小ID任务的循环路径
+func (g *dependencyGraph) cycleIn(component []uint) []uint {
+	members := make(map[uint]bool, len(component))
+	for _, id := range component {
+		members[id] = true
+	}
+	sub := &dependencyGraph{titles: g.titles, edges: make(map[uint][]uint, len(component))}
+	for _, id := range component {
+		for _, next := range g.edges[id] {
+			if members[next] && next != id {
+				sub.edges[id] = append(sub.edges[id], next)
+			}
+		}
+	}
+	start := component[0]
+	for _, next := range sub.edges[start] {
+		if path := sub.path(next, start); path != nil {
+			return append([]uint{start}, path...)
+		}
+	}
+	return []uint{start}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+var ErrTaskChildrenOpen = errors.New("还有未完成的子任务，不能完成父任务")
+
+// ValidateTaskParent 校验任务的父任务：父任务必须存在、属于同一项目且未完成，不能是任务自己或其子孙任务
+func ValidateTaskParent(db *gorm.DB, task *model.Task, parentID uint) error {
+	if task.ID != 0 && parentID == task.ID {
+		return errors.New("任务不能作为自己的父任务")
+	}
+	var parent model.Task
+	if err := db.First(&parent, parentID).Error; err != nil {
+		return errors.New("父任务不存在")
+	}
+	if parent.ProjectID != task.ProjectID {
+		return errors.New("父任务必须属于同一项目")
+	}
+	if IsWorkItemFinished(db, SprintItemTask, parent.ProjectID, parent.Status) {
+		return errors.New("父任务已完成，不能添加子任务")
+	}
+	if task.ID == 0 {
+		return nil
+	}
+
+	// 沿父任务链向上查找，出现任务自己说明父任务是它的子孙任务
+	visited := map[uint]bool{parent.ID: true}
+	for ancestorID := parent.ParentID; ancestorID != nil; {
+		if *ancestorID == task.ID {
+			return errors.New("不能把任务移动到自己的子任务下")
+		}
+		if visited[*ancestorID] {
+			break
+		}
+		visited[*ancestorID] = true
+		var ancestor model.Task
+		if err := db.Select("id, parent_id").First(&ancestor, *ancestorID).Error; err != nil {
+			break
+		}
+		ancestorID = ancestor.ParentID
+	}
+	return nil
+}
+
+// CheckTaskCanFinish 任务转为完成类状态（工作流中分类为 done 的状态，如完成、取消、关闭）前检查子任务是否都已完成
+func CheckTaskCanFinish(db *gorm.DB, task *model.Task, status string) error {
+	finished := FinishedStatuses(db, SprintItemTask, task.ProjectID)
+	if !finished[status] {
+		return nil
+	}
+	var statuses []string
+	if err := db.Model(&model.Task{}).Where("parent_id = ?", task.ID).Pluck("status", &statuses).Error; err != nil {
+		return err
+	}
+	for _, childStatus := range statuses {
+		if !finished[childStatus] {
+			return ErrTaskChildrenOpen
+		}
+	}
+	return nil
+}
+
+// SummaryTaskIDs 返回给定任务中有子任务的（汇总任务）。汇总任务的工时由子任务汇总而来，统计时应排除以免重复计算
+func SummaryTaskIDs(db *gorm.DB, taskIDs []uint) (map[uint]bool, error) {
+	summary := make(map[uint]bool)
+	if len(taskIDs) == 0 {
+		return summary, nil
+	}
+	var parentIDs []uint
+	if err := db.Model(&model.Task{}).Where("parent_id IN ?", taskIDs).Distinct().Pluck("parent_id", &parentIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range parentIDs {
+		summary[id] = true
+	}
+	return summary, nil
+}
+
+// TaskTreeNode 任务分解结构（WBS）中的一个节点
+type TaskTreeNode struct {
+	model.Task
+	WBS      string          `json:"wbs"`     // WBS 编号，如 1.2.3
+	Summary  bool            `json:"summary"` // 是否为汇总任务（有子任务）
+	Level    int             `json:"level"`   // 层级，顶层为1
+	Children []*TaskTreeNode `json:"children"`
+}
+
+// BuildTaskTree 把任务组装为树并编号，同级按ID排序；父任务不在列表中的任务作为顶层任务
+func BuildTaskTree(tasks []model.Task) []*TaskTreeNode {
+	nodes := make(map[uint]*TaskTreeNode, len(tasks))
+	ordered := make([]*TaskTreeNode, 0, len(tasks))
+	for i := range tasks {
+		node := &TaskTreeNode{Task: tasks[i], Children: make([]*TaskTreeNode, 0)}
+		node.Task.Children = nil
+		nodes[node.ID] = node
+		ordered = append(ordered, node)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].ID < ordered[j].ID })
+
+	roots := make([]*TaskTreeNode, 0)
+	for _, node := range ordered {
+		if node.ParentID != nil {
+			if parent, ok := nodes[*node.ParentID]; ok && parent != node {
+				parent.Children = append(parent.Children, node)
+				parent.Summary = true
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	var number func(children []*TaskTreeNode, prefix string, level int)
+	number = func(children []*TaskTreeNode, prefix string, level int) {
+		for i, node := range children {
+			node.WBS = prefix + strconv.Itoa(i+1)
+			node.Level = level
+			number(node.Children, node.WBS+".", level+1)
+		}
+	}
+	number(roots, "", 1)
+	return roots
+}
+
+// FlattenTaskTree 按先序遍历（WBS 顺序）展开任务树
+func FlattenTaskTree(roots []*TaskTreeNode) []*TaskTreeNode {
+	result := make([]*TaskTreeNode, 0)
+	var walk func(nodes []*TaskTreeNode)
+	walk = func(nodes []*TaskTreeNode) {
+		for _, node := range nodes {
+			result = append(result, node)
+			walk(node.Children)
+		}
+	}
+	walk(roots)
+	return result
+}
+
+// SummaryDates 汇总任务的计划日期：自身和所有子孙任务中最早的开始日期和最晚的结束日期
+func (n *TaskTreeNode) SummaryDates() (start, end *time.Time) {
+	consider := func(task *model.Task) {
+		if task.StartDate != nil && (start == nil || task.StartDate.Before(*start)) {
+			start = task.StartDate
+		}
+		if task.EndDate != nil && (end == nil || task.EndDate.After(*end)) {
+			end = task.EndDate
+		}
+	}
+	var walk func(node *TaskTreeNode)
+	walk = func(node *TaskTreeNode) {
+		consider(&node.Task)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(n)
+	return start, end
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// TestStepInput 提交的测试步骤；指定 shared_step_id 时展开为共享步骤库中的全部步骤
+type TestStepInput struct {
+	Action        string `json:"action"`
+	Expected      string `json:"expected"`
+	AttachmentIDs []uint `json:"attachment_ids"`
+	SharedStepID  *uint  `json:"shared_step_id"`
+}
+
+// ExpandTestSteps 校验并展开测试步骤（按提交顺序编号）。共享步骤库必须是全局的或属于同一项目，
+// 引用时复制库中当前的步骤，之后修改共享步骤库不会影响已保存的测试单。
+// 附件必须是 uploaderID 上传的，或者已属于该项目（项目附件或项目内测试单步骤的附件）
+func ExpandTestSteps(db *gorm.DB, projectID, uploaderID uint, inputs []TestStepInput) ([]model.TestCaseStep, error) {
+	steps := make([]model.TestCaseStep, 0, len(inputs))
+	for i, input := range inputs {
+		if input.SharedStepID != nil {
+			var shared model.SharedStep
+			if err := db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+				return db.Order("sort ASC, id ASC")
+			}).First(&shared, *input.SharedStepID).Error; err != nil {
+				return nil, fmt.Errorf("第%d步引用的共享步骤不存在", i+1)
+			}
+			if shared.ProjectID != nil && *shared.ProjectID != projectID {
+				return nil, fmt.Errorf("第%d步引用的共享步骤不属于该项目", i+1)
+			}
+			for _, item := range shared.Items {
+				steps = append(steps, model.TestCaseStep{Action: item.Action, Expected: item.Expected, SharedStepID: &shared.ID})
+			}
+			continue
+		}
+
+		if strings.TrimSpace(input.Action) == "" {
+			return nil, fmt.Errorf("第%d步的操作步骤不能为空", i+1)
+		}
+		step := model.TestCaseStep{Action: input.Action, Expected: input.Expected}
+		if len(input.AttachmentIDs) > 0 {
+			if err := db.Where("id IN ?", input.AttachmentIDs).Where(
+				"creator_id = ? OR id IN (SELECT attachment_id FROM project_attachments WHERE project_id = ?) OR id IN ("+
+					"SELECT test_case_step_attachments.attachment_id FROM test_case_step_attachments "+
+					"JOIN test_case_steps ON test_case_steps.id = test_case_step_attachments.test_case_step_id "+
+					"JOIN test_cases ON test_cases.id = test_case_steps.test_case_id WHERE test_cases.project_id = ?)",
+				uploaderID, projectID, projectID,
+			).Find(&step.Attachments).Error; err != nil {
+				return nil, err
+			}
+			if len(step.Attachments) != len(input.AttachmentIDs) {
+				return nil, fmt.Errorf("第%d步的附件不存在或不属于该项目", i+1)
+			}
+		}
+		steps = append(steps, step)
+	}
+	for i := range steps {
+		steps[i].Sort = i + 1
+	}
+	return steps, nil
+}
+
+// LoadTestCaseSteps 获取测试单指定版本的步骤
+func LoadTestCaseSteps(db *gorm.DB, testCaseID uint, version int) ([]model.TestCaseStep, error) {
+	steps := make([]model.TestCaseStep, 0)
+	err := db.Preload("Attachments").Where("test_case_id = ? AND version = ?", testCaseID, version).
+		Order("sort ASC, id ASC").Find(&steps).Error
+	return steps, err
+}
+
+// TestCaseStepVersions 获取测试单已有步骤的全部版本号（升序）
+func TestCaseStepVersions(db *gorm.DB, testCaseID uint) ([]int, error) {
+	versions := make([]int, 0)
+	err := db.Model(&model.TestCaseStep{}).Where("test_case_id = ?", testCaseID).
+		Distinct().Order("version ASC").Pluck("version", &versions).Error
+	return versions, err
+}
+
+// testStepsEqual 比较两组步骤的内容（操作、预期结果、来源和附件）
+func testStepsEqual(a, b []model.TestCaseStep) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	attachmentKey := func(step model.TestCaseStep) string {
+		ids := make([]int, 0, len(step.Attachments))
+		for _, attachment := range step.Attachments {
+			ids = append(ids, int(attachment.ID))
+		}
+		sort.Ints(ids)
+		return fmt.Sprint(ids)
+	}
+	for i := range a {
+		sameShared := (a[i].SharedStepID == nil) == (b[i].SharedStepID == nil) &&
+			(a[i].SharedStepID == nil || *a[i].SharedStepID == *b[i].SharedStepID)
+		if a[i].Action != b[i].Action || a[i].Expected != b[i].Expected || !sameShared || attachmentKey(a[i]) != attachmentKey(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveTestCaseSteps 保存测试单步骤：步骤有变化时写入新版本并更新测试单的当前版本，历史版本保持不变。
+// 当前版本还没有步骤时直接写入当前版本。返回是否产生了新版本
+func SaveTestCaseSteps(db *gorm.DB, testCase *model.TestCase, steps []model.TestCaseStep) (bool, error) {
+	if testCase.ID == 0 {
+		return false, errors.New("测试单不存在")
+	}
+	if testCase.StepVersion == 0 {
+		testCase.StepVersion = 1
+	}
+	current, err := LoadTestCaseSteps(db, testCase.ID, testCase.StepVersion)
+	if err != nil {
+		return false, err
+	}
+	if testStepsEqual(current, steps) {
+		testCase.Steps = current
+		return false, nil
+	}
+
+	version := testCase.StepVersion
+	if len(current) > 0 {
+		var latest int
+		db.Model(&model.TestCaseStep{}).Where("test_case_id = ?", testCase.ID).Select("COALESCE(MAX(version), 0)").Scan(&latest)
+		version = latest + 1
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := range steps {
+			steps[i].ID = 0
+			steps[i].TestCaseID = testCase.ID
+			steps[i].Version = version
+			if err := tx.Create(&steps[i]).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(testCase).Update("step_version", version).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	testCase.StepVersion = version
+	testCase.Steps = steps
+	return len(current) > 0, nil
+}
@@ -0,0 +1,325 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prjflow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 追溯矩阵中的覆盖缺口
+const (
+	TraceGapNoTestCase    = "no_test_case"   // 需求没有测试单覆盖
+	TraceGapNoRequirement = "no_requirement" // Bug 没有关联需求
+)
+
+// 测试单与需求的关联方式
+const (
+	TraceLinkDirect = "direct" // 测试单直接关联需求
+	TraceLinkBug    = "bug"    // 测试单通过需求的 Bug 关联
+)
+
+// TraceTask 追溯矩阵中的任务
+type TraceTask struct {
+	ID       uint   `json:"id"`
+	Title    string `json:"title"`
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+}
+
+// TraceTestCase 追溯矩阵中的测试单
+type TraceTestCase struct {
+	ID     uint   `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Result string `json:"result"`
+	Link   string `json:"link"` // 关联方式：direct, bug
+}
+
+// TraceBug 追溯矩阵中的 Bug
+type TraceBug struct {
+	ID       uint     `json:"id"`
+	Title    string   `json:"title"`
+	Status   string   `json:"status"`
+	Severity string   `json:"severity"`
+	Gaps     []string `json:"gaps"`
+}
+
+// TraceVersion 追溯矩阵中的版本
+type TraceVersion struct {
+	ID            uint   `json:"id"`
+	VersionNumber string `json:"version_number"`
+	Status        string `json:"status"`
+}
+
+// TraceRow 追溯矩阵的一行（一个需求）
+type TraceRow struct {
+	RequirementID uint            `json:"requirement_id"`
+	Title         string          `json:"title"`
+	Status        string          `json:"status"`
+	Level         string          `json:"level"`
+	Tasks         []TraceTask     `json:"tasks"`
+	TestCases     []TraceTestCase `json:"test_cases"`
+	Bugs          []TraceBug      `json:"bugs"`
+	Versions      []TraceVersion  `json:"versions"`
+	Gaps          []string        `json:"gaps"`
+}
+
+// TraceSummary 追溯矩阵汇总
+type TraceSummary struct {
+	RequirementCount     int     `json:"requirement_count"`
+	TestedRequirements   int     `json:"tested_requirements"`   // 有测试单覆盖的需求数
+	UntestedRequirements int     `json:"untested_requirements"` // 没有测试单覆盖的需求数
+	TestCoverage         float64 `json:"test_coverage"`         // 需求测试覆盖率（百分比）
+	BugCount             int     `json:"bug_count"`
+	OrphanBugCount       int     `json:"orphan_bug_count"` // 没有关联需求的 Bug 数
+}
+
+// TraceMatrix 需求追溯矩阵：需求 -> 任务、测试单、Bug、版本
+type TraceMatrix struct {
+	ProjectID  uint         `json:"project_id"`
+	VersionID  *uint        `json:"version_id,omitempty"`
+	Rows       []TraceRow   `json:"rows"`
+	OrphanBugs []TraceBug   `json:"orphan_bugs"` // 没有关联需求的 Bug
+	Summary    TraceSummary `json:"summary"`
+}
+
+// BuildTraceMatrix 构建项目的需求追溯矩阵；versionID 不为空时只包含该版本的需求和 Bug
+func BuildTraceMatrix(db *gorm.DB, projectID uint, versionID *uint) (*TraceMatrix, error) {
+	matrix := &TraceMatrix{ProjectID: projectID, VersionID: versionID, Rows: make([]TraceRow, 0), OrphanBugs: make([]TraceBug, 0)}
+
+	requirementQuery := db.Where("project_id = ?", projectID)
+	bugQuery := db.Where("project_id = ?", projectID)
+	if versionID != nil {
+		requirementQuery = requirementQuery.Where("id IN (?)", db.Table("version_requirements").Select("requirement_id").Where("version_id = ?", *versionID))
+		bugQuery = bugQuery.Where("requirement_id IN (?) OR id IN (?)",
+			db.Table("version_requirements").Select("requirement_id").Where("version_id = ?", *versionID),
+			db.Table("version_bugs").Select("bug_id").Where("version_id = ?", *versionID))
+	}
+	var requirements []model.Requirement
+	if err := requirementQuery.Order("id").Find(&requirements).Error; err != nil {
+		return nil, err
+	}
+	var bugs []model.Bug
+	if err := bugQuery.Order("id").Find(&bugs).Error; err != nil {
+		return nil, err
+	}
+
+	requirementIDs := make([]uint, 0, len(requirements))
+	for _, requirement := range requirements {
+		requirementIDs = append(requirementIDs, requirement.ID)
+	}
+
+	// 任务
+	tasksByRequirement := make(map[uint][]TraceTask)
+	if len(requirementIDs) > 0 {
+		var tasks []model.Task
+		if err := db.Select("id, title, status, progress, requirement_id").Where("requirement_id IN ?", requirementIDs).Order("id").Find(&tasks).Error; err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			tasksByRequirement[*task.RequirementID] = append(tasksByRequirement[*task.RequirementID], TraceTask{
+				ID: task.ID, Title: task.Title, Status: task.Status, Progress: task.Progress,
+			})
+		}
+	}
+
+	// Bug
+	bugsByRequirement := make(map[uint][]TraceBug)
+	bugRequirement := make(map[uint]uint)
+	for _, bug := range bugs {
+		item := TraceBug{ID: bug.ID, Title: bug.Title, Status: bug.Status, Severity: bug.Severity, Gaps: make([]string, 0)}
+		if bug.RequirementID == nil {
+			item.Gaps = append(item.Gaps, TraceGapNoRequirement)
+			matrix.OrphanBugs = append(matrix.OrphanBugs, item)
+			continue
+		}
+		bugsByRequirement[*bug.RequirementID] = append(bugsByRequirement[*bug.RequirementID], item)
+		bugRequirement[bug.ID] = *bug.RequirementID
+	}
+
+	// 测试单：直接关联需求的，以及关联了需求 Bug 的
+	testCasesByRequirement := make(map[uint][]TraceTestCase)
+	if len(requirementIDs) > 0 {
+		type testCaseLink struct {
+			TestCaseID uint
+			TargetID   uint
+		}
+		var direct, viaBug []testCaseLink
+		if err := db.Table("test_case_requirements").Select("test_case_id, requirement_id AS target_id").
+			Where("requirement_id IN ?", requirementIDs).Scan(&direct).Error; err != nil {
+			return nil, err
+		}
+		bugIDs := make([]uint, 0, len(bugRequirement))
+		for bugID := range bugRequirement {
+			bugIDs = append(bugIDs, bugID)
+		}
+		if len(bugIDs) > 0 {
+			if err := db.Table("test_case_bugs").Select("test_case_id, bug_id AS target_id").
+				Where("bug_id IN ?", bugIDs).Scan(&viaBug).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		testCaseIDs := make([]uint, 0, len(direct)+len(viaBug))
+		for _, link := range append(append([]testCaseLink(nil), direct...), viaBug...) {
+			testCaseIDs = append(testCaseIDs, link.TestCaseID)
+		}
+		testCases := make(map[uint]model.TestCase)
+		if len(testCaseIDs) > 0 {
+			var items []model.TestCase
+			if err := db.Select("id, name, status, result").Where("id IN ?", testCaseIDs).Find(&items).Error; err != nil {
+				return nil, err
+			}
+			for _, item := range items {
+				testCases[item.ID] = item
+			}
+		}
+
+		added := make(map[[2]uint]bool)
+		addTestCase := func(requirementID, testCaseID uint, link string) {
+			testCase, ok := testCases[testCaseID]
+			if !ok || added[[2]uint{requirementID, testCaseID}] {
+				return
+			}
+			added[[2]uint{requirementID, testCaseID}] = true
+			testCasesByRequirement[requirementID] = append(testCasesByRequirement[requirementID], TraceTestCase{
+				ID: testCase.ID, Name: testCase.Name, Status: testCase.Status, Result: testCase.Result, Link: link,
+			})
+		}
+		for _, link := range direct {
+			addTestCase(link.TargetID, link.TestCaseID, TraceLinkDirect)
+		}
+		for _, link := range viaBug {
+			addTestCase(bugRequirement[link.TargetID], link.TestCaseID, TraceLinkBug)
+		}
+		for requirementID := range testCasesByRequirement {
+			items := testCasesByRequirement[requirementID]
+			sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+		}
+	}
+
+	// 版本
+	versionsByRequirement := make(map[uint][]TraceVersion)
+	if len(requirementIDs) > 0 {
+		var links []struct {
+			VersionID     uint
+			RequirementID uint
+		}
+		if err := db.Table("version_requirements").Select("version_id, requirement_id").
+			Where("requirement_id IN ?", requirementIDs).Order("version_id").Scan(&links).Error; err != nil {
+			return nil, err
+		}
+		versionIDs := make([]uint, 0, len(links))
+		for _, link := range links {
+			versionIDs = append(versionIDs, link.VersionID)
+		}
+		versions := make(map[uint]model.Version)
+		if len(versionIDs) > 0 {
+			var items []model.Version
+			if err := db.Where("id IN ?", versionIDs).Find(&items).Error; err != nil {
+				return nil, err
+			}
+			for _, item := range items {
+				versions[item.ID] = item
+			}
+		}
+		for _, link := range links {
+			if version, ok := versions[link.VersionID]; ok {
+				versionsByRequirement[link.RequirementID] = append(versionsByRequirement[link.RequirementID], TraceVersion{
+					ID: version.ID, VersionNumber: version.VersionNumber, Status: version.Status,
+				})
+			}
+		}
+	}
+
+	for _, requirement := range requirements {
+		row := TraceRow{
+			RequirementID: requirement.ID,
+			Title:         requirement.Title,
+			Status:        requirement.Status,
+			Level:         requirement.Level,
+			Tasks:         append(make([]TraceTask, 0), tasksByRequirement[requirement.ID]...),
+			TestCases:     append(make([]TraceTestCase, 0), testCasesByRequirement[requirement.ID]...),
+			Bugs:          append(make([]TraceBug, 0), bugsByRequirement[requirement.ID]...),
+			Versions:      append(make([]TraceVersion, 0), versionsByRequirement[requirement.ID]...),
+			Gaps:          make([]string, 0),
+		}
+		if len(row.TestCases) == 0 {
+			row.Gaps = append(row.Gaps, TraceGapNoTestCase)
+			matrix.Summary.UntestedRequirements++
+		} else {
+			matrix.Summary.TestedRequirements++
+		}
+		matrix.Rows = append(matrix.Rows, row)
+	}
+
+	matrix.Summary.RequirementCount = len(requirements)
+	matrix.Summary.BugCount = len(bugs)
+	matrix.Summary.OrphanBugCount = len(matrix.OrphanBugs)
+	if len(requirements) > 0 {
+		matrix.Summary.TestCoverage = roundTwoDecimals(float64(matrix.Summary.TestedRequirements) * 100 / float64(len(requirements)))
+	}
+	return matrix, nil
+}
+
+// traceGapNames 覆盖缺口的显示名称
+var traceGapNames = map[string]string{
+	TraceGapNoTestCase:    "无测试覆盖",
+	TraceGapNoRequirement: "无关联需求",
+}
+
+// TraceMatrixTable 把追溯矩阵展开为表格（用于导出）：每个需求一行，没有关联需求的 Bug 排在最后各占一行
+func TraceMatrixTable(matrix *TraceMatrix) [][]string {
+	gapNames := func(gaps []string) string {
+		names := make([]string, 0, len(gaps))
+		for _, gap := range gaps {
+			if name, ok := traceGapNames[gap]; ok {
+				names = append(names, name)
+			} else {
+				names = append(names, gap)
+			}
+		}
+		return strings.Join(names, "；")
+	}
+	item := func(id uint, title, status string) string {
+		return fmt.Sprintf("#%d %s [%s]", id, title, status)
+	}
+
+	table := [][]string{{"需求ID", "需求标题", "层级", "状态", "任务", "测试单", "Bug", "版本", "覆盖缺口"}}
+	for _, row := range matrix.Rows {
+		tasks := make([]string, 0, len(row.Tasks))
+		for _, task := range row.Tasks {
+			tasks = append(tasks, item(task.ID, task.Title, task.Status))
+		}
+		testCases := make([]string, 0, len(row.TestCases))
+		for _, testCase := range row.TestCases {
+			status := testCase.Status
+			if testCase.Result != "" {
+				status += "/" + testCase.Result
+			}
+			testCases = append(testCases, item(testCase.ID, testCase.Name, status))
+		}
+		bugs := make([]string, 0, len(row.Bugs))
+		for _, bug := range row.Bugs {
+			bugs = append(bugs, item(bug.ID, bug.Title, bug.Status))
+		}
+		versions := make([]string, 0, len(row.Versions))
+		for _, version := range row.Versions {
+			versions = append(versions, version.VersionNumber)
+		}
+		table = append(table, []string{
+			strconv.FormatUint(uint64(row.RequirementID), 10), row.Title, row.Level, row.Status,
+			strings.Join(tasks, "\n"), strings.Join(testCases, "\n"), strings.Join(bugs, "\n"), strings.Join(versions, "\n"),
+			gapNames(row.Gaps),
+		})
+	}
+	for _, bug := range matrix.OrphanBugs {
+		table = append(table, []string{"", "", "", "", "", "", item(bug.ID, bug.Title, bug.Status), "", gapNames(bug.Gaps)})
+	}
+	return table
+}
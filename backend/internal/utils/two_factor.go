@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/pkg/permission"
+
+	"gorm.io/gorm"
+)
+
+// TwoFactorIssuer 验证器应用中显示的发行方名称
+const TwoFactorIssuer = "PrjFlow"
+
+// twoFactorPolicyKey 两步验证策略在系统配置表中的键
+const twoFactorPolicyKey = "two_factor_policy"
+
+// RecoveryCodeCount 每次生成的恢复码数量
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorNotEnabled     = errors.New("未启用两步验证")
+	ErrTwoFactorAlreadyEnabled = errors.New("已启用两步验证")
+	ErrTwoFactorNotSetup       = errors.New("请先生成两步验证密钥")
+	ErrTwoFactorInvalidCode    = errors.New("验证码错误或已使用")
+)
+
+// TwoFactorPolicy 两步验证强制策略：拥有任一角色或任一权限的用户必须启用两步验证
+type TwoFactorPolicy struct {
+	RequiredRoles       []string `json:"required_roles"`       // 角色代码，如 admin
+	RequiredPermissions []string `json:"required_permissions"` // 权限代码，如 permission:manage
+}
+
+// GetTwoFactorPolicy 读取两步验证强制策略，未配置时返回空策略
+func GetTwoFactorPolicy(db *gorm.DB) TwoFactorPolicy {
+	policy := TwoFactorPolicy{RequiredRoles: []string{}, RequiredPermissions: []string{}}
+	var cfg model.SystemConfig
+	if err := db.Where("key = ?", twoFactorPolicyKey).First(&cfg).Error; err != nil {
+		return policy
+	}
+	if err := json.Unmarshal([]byte(cfg.Value), &policy); err != nil && Logger != nil {
+		Logger.Warnf("[TwoFactor] 解析两步验证策略失败: %v", err)
+	}
+	if policy.RequiredRoles == nil {
+		policy.RequiredRoles = []string{}
+	}
+	if policy.RequiredPermissions == nil {
+		policy.RequiredPermissions = []string{}
+	}
+	return policy
+}
+
+// SaveTwoFactorPolicy 保存两步验证强制策略
+func SaveTwoFactorPolicy(db *gorm.DB, policy TwoFactorPolicy) error {
+	value, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	cfg := model.SystemConfig{Key: twoFactorPolicyKey, Value: string(value), Type: "json"}
+	return db.Where("key = ?", twoFactorPolicyKey).
+		Assign(model.SystemConfig{Value: string(value), Type: "json"}).
+		FirstOrCreate(&cfg).Error
+}
+
+// IsTwoFactorRequired 根据强制策略判断用户是否必须启用两步验证
+func IsTwoFactorRequired(db *gorm.DB, userID uint) (bool, error) {
+	policy := GetTwoFactorPolicy(db)
+	if len(policy.RequiredRoles) == 0 && len(policy.RequiredPermissions) == 0 {
+		return false, nil
+	}
+
+	var user model.User
+	if err := db.Preload("Roles").First(&user, userID).Error; err != nil {
+		return false, err
+	}
+	roleCodes := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roleCodes = append(roleCodes, role.Code)
+		for _, required := range policy.RequiredRoles {
+			if role.Code == required {
+				return true, nil
+			}
+		}
+	}
+	if len(roleCodes) == 0 {
+		return false, nil
+	}
+
+	for _, permCode := range policy.RequiredPermissions {
+		hasPerm, err := permission.CheckPermissionWithDB(db, roleCodes, permCode)
+		if err != nil {
+			return false, err
+		}
+		if hasPerm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetUserTwoFactor 获取用户的两步验证设置，未设置时返回 nil
+func GetUserTwoFactor(db *gorm.DB, userID uint) (*model.UserTwoFactor, error) {
+	var tf model.UserTwoFactor
+	if err := db.Where("user_id = ?", userID).First(&tf).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// IsTwoFactorEnabled 用户是否已启用两步验证
+func IsTwoFactorEnabled(db *gorm.DB, userID uint) bool {
+	var count int64
+	db.Model(&model.UserTwoFactor{}).Where("user_id = ? AND enabled = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+// StartTwoFactorSetup 为用户生成新的TOTP密钥（尚未启用），返回密钥和绑定地址
+func StartTwoFactorSetup(db *gorm.DB, user *model.User) (string, string, error) {
+	tf, err := GetUserTwoFactor(db, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if tf != nil && tf.Enabled {
+		return "", "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if tf == nil {
+		err = db.Create(&model.UserTwoFactor{UserID: user.ID, Secret: secret}).Error
+	} else {
+		err = db.Model(tf).Updates(map[string]interface{}{"secret": secret, "last_used_step": 0}).Error
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return secret, TOTPProvisioningURI(TwoFactorIssuer, user.Username, secret), nil
+}
+
+// EnableTwoFactor 校验验证器应用生成的验证码并启用两步验证，返回新生成的恢复码
+func EnableTwoFactor(db *gorm.DB, userID uint, code string) ([]string, error) {
+	tf, err := GetUserTwoFactor(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil {
+		return nil, ErrTwoFactorNotSetup
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if err := verifyTOTP(db, tf, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&model.UserTwoFactor{}).Where("id = ?", tf.ID).
+			Updates(map[string]interface{}{"enabled": true, "enabled_at": now}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor 关闭两步验证，删除密钥和恢复码
+func DisableTwoFactor(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.UserTwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.UserRecoveryCode{}).Error
+	})
+}
+
+// VerifyTwoFactor 校验已启用两步验证用户的验证码或恢复码（二选一，优先使用验证码）
+func VerifyTwoFactor(db *gorm.DB, userID uint, code, recoveryCode string) error {
+	tf, err := GetUserTwoFactor(db, userID)
+	if err != nil {
+		return err
+	}
+	if tf == nil || !tf.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if strings.TrimSpace(code) != "" {
+		return verifyTOTP(db, tf, code)
+	}
+	return useRecoveryCode(db, userID, recoveryCode)
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，之前的恢复码全部失效
+func RegenerateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	if !IsTwoFactorEnabled(db, userID) {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// CountRecoveryCodes 统计用户未使用的恢复码数量
+func CountRecoveryCodes(db *gorm.DB, userID uint) int64 {
+	var count int64
+	db.Model(&model.UserRecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// verifyTOTP 校验验证码并记录已使用的时间步
+func verifyTOTP(db *gorm.DB, tf *model.UserTwoFactor, code string) error {
+	now := time.Now()
+	step, ok := ValidateTOTPCode(tf.Secret, code, now, tf.LastUsedStep)
+	if !ok {
+		return ErrTwoFactorInvalidCode
+	}
+	// 以时间步为条件更新，并发请求中同一验证码只有一个能通过
+	result := db.Model(&model.UserTwoFactor{}).
+		Where("id = ? AND last_used_step < ?", tf.ID, step).
+		Updates(map[string]interface{}{"last_used_step": step, "last_used_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorInvalidCode
+	}
+	return nil
+}
+
+func useRecoveryCode(db *gorm.DB, userID uint, code string) error {
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrTwoFactorInvalidCode
+	}
+	result := db.Model(&model.UserRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorInvalidCode
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, RecoveryCodeCount)
+	records := make([]model.UserRecoveryCode, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(buf)
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		records = append(records, model.UserRecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(raw)})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode 忽略大小写、空格和分隔符
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func hashRecoveryCode(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
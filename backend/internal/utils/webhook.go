@@ -0,0 +1,480 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook请求头
+const (
+	WebhookEventHeader     = "X-Prjflow-Event"
+	WebhookDeliveryHeader  = "X-Prjflow-Delivery"
+	WebhookSignatureHeader = "X-Prjflow-Signature-256"
+)
+
+// Webhook投递状态
+const (
+	WebhookDeliveryPending    = "pending"
+	WebhookDeliveryDelivering = "delivering"
+	WebhookDeliverySuccess    = "success"
+	WebhookDeliveryFailed     = "failed"
+)
+
+const (
+	defaultWebhookMaxAttempts = 8
+	defaultWebhookTimeout     = 10
+	defaultWebhookRetryBase   = 30
+	maxWebhookRetryInterval   = time.Hour
+	maxWebhookResponseBody    = 2048
+	webhookBatchSize          = 20
+	webhookPollInterval       = 5 * time.Second
+	// 投递中状态超过该时间视为进程中断，重新放回队列
+	webhookStaleDelivering = 5 * time.Minute
+)
+
+// WebhookEventTypes 支持订阅的事件类型
+var WebhookEventTypes = []string{
+	"bug.created", "bug.edited", "bug.assigned", "bug.confirmed", "bug.resolved", "bug.closed", "bug.status_changed", "bug.commented",
+	"task.created", "task.edited", "task.assigned", "task.status_changed", "task.progress_updated", "task.moved", "task.commented",
+	"requirement.created", "requirement.edited", "requirement.assigned", "requirement.status_changed", "requirement.commented",
+	"version.released",
+}
+
+// WebhookPayload Webhook请求体
+type WebhookPayload struct {
+	ID         string          `json:"id"`    // 事件ID
+	Event      string          `json:"event"` // 事件类型
+	CreatedAt  time.Time       `json:"created_at"`
+	ProjectID  uint            `json:"project_id"`
+	ObjectType string          `json:"object_type"`
+	ObjectID   uint            `json:"object_id"`
+	Action     string          `json:"action"`
+	Actor      *WebhookActor   `json:"actor,omitempty"`
+	Comment    string          `json:"comment,omitempty"`
+	Extra      json.RawMessage `json:"extra,omitempty"`
+	Object     interface{}     `json:"object,omitempty"` // 对象当前数据
+}
+
+// WebhookActor 操作人信息
+type WebhookActor struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Nickname string `json:"nickname"`
+}
+
+// ErrWebhookTargetForbidden Webhook地址指向回环、内网或链路本地地址
+var ErrWebhookTargetForbidden = errors.New("推送地址不能指向本机、内网或链路本地地址")
+
+// webhookAllowPrivateTargets 是否允许推送到内网地址（仅在受信任的内网部署中开启）
+func webhookAllowPrivateTargets() bool {
+	return config.AppConfig != nil && config.AppConfig.Webhook.AllowPrivateTargets
+}
+
+// isForbiddenWebhookIP 检查IP是否为回环、私有、链路本地、未指定或组播地址
+func isForbiddenWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// CheckWebhookTarget 校验Webhook推送地址：只允许 http/https，解析主机名后拒绝指向本机、内网或链路本地的地址（防止SSRF）
+func CheckWebhookTarget(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("推送地址无效，必须是 http 或 https 地址")
+	}
+	if webhookAllowPrivateTargets() {
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(context.Background(), "ip", u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("无法解析推送地址的主机名：%s", u.Hostname())
+	}
+	for _, ip := range ips {
+		if isForbiddenWebhookIP(ip) {
+			return ErrWebhookTargetForbidden
+		}
+	}
+	return nil
+}
+
+// webhookDialControl 建立连接前检查实际连接的IP，防止保存后通过DNS重新绑定或重定向访问内网
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if webhookAllowPrivateTargets() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isForbiddenWebhookIP(ip) {
+		return ErrWebhookTargetForbidden
+	}
+	return nil
+}
+
+// GenerateWebhookSecret 生成Webhook签名密钥
+func GenerateWebhookSecret() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return uuid.New().String()
+	}
+	return hex.EncodeToString(b)
+}
+
+// SignWebhookPayload 计算请求体签名，格式：sha256=<hex>
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSubscribed 检查Webhook是否订阅了事件类型
+func WebhookSubscribed(webhook *model.Webhook, eventType string) bool {
+	for _, e := range webhook.Events {
+		if e == "*" || e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookBackoff 计算第 attempt 次失败后的重试间隔（指数退避）
+func WebhookBackoff(attempt int) time.Duration {
+	base := defaultWebhookRetryBase
+	if config.AppConfig != nil && config.AppConfig.Webhook.RetryBaseSeconds > 0 {
+		base = config.AppConfig.Webhook.RetryBaseSeconds
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	interval := time.Duration(base) * time.Second
+	for i := 1; i < attempt; i++ {
+		interval *= 2
+		if interval >= maxWebhookRetryInterval {
+			return maxWebhookRetryInterval
+		}
+	}
+	return interval
+}
+
+func webhookMaxAttempts() int {
+	if config.AppConfig != nil && config.AppConfig.Webhook.MaxAttempts > 0 {
+		return config.AppConfig.Webhook.MaxAttempts
+	}
+	return defaultWebhookMaxAttempts
+}
+
+func webhookTimeout() time.Duration {
+	if config.AppConfig != nil && config.AppConfig.Webhook.Timeout > 0 {
+		return time.Duration(config.AppConfig.Webhook.Timeout) * time.Second
+	}
+	return defaultWebhookTimeout * time.Second
+}
+
+// BuildWebhookPayload 根据操作记录构建Webhook请求体
+func BuildWebhookPayload(db *gorm.DB, action *model.Action) *WebhookPayload {
+	payload := &WebhookPayload{
+		ID:         uuid.New().String(),
+		Event:      action.ObjectType + "." + action.Action,
+		CreatedAt:  action.Date,
+		ProjectID:  action.ProjectID,
+		ObjectType: action.ObjectType,
+		ObjectID:   action.ObjectID,
+		Action:     action.Action,
+		Comment:    action.Comment,
+	}
+	if action.Extra != "" {
+		payload.Extra = json.RawMessage(action.Extra)
+	}
+
+	var actor model.User
+	if err := db.First(&actor, action.ActorID).Error; err == nil {
+		payload.Actor = &WebhookActor{ID: actor.ID, Username: actor.Username, Nickname: actor.Nickname}
+	}
+
+	switch action.ObjectType {
+	case "bug":
+		var bug model.Bug
+		if err := db.Preload("Assignees").First(&bug, action.ObjectID).Error; err == nil {
+			payload.Object = bug
+		}
+	case "task":
+		var task model.Task
+		if err := db.First(&task, action.ObjectID).Error; err == nil {
+			payload.Object = task
+		}
+	case "requirement":
+		var requirement model.Requirement
+		if err := db.First(&requirement, action.ObjectID).Error; err == nil {
+			payload.Object = requirement
+		}
+	case "version":
+		var version model.Version
+		if err := db.First(&version, action.ObjectID).Error; err == nil {
+			payload.Object = version
+		}
+	}
+	return payload
+}
+
+// EnqueueWebhookDeliveries 将操作记录对应的事件加入订阅了该事件的Webhook投递队列
+func EnqueueWebhookDeliveries(db *gorm.DB, action *model.Action) (int, error) {
+	if action.ProjectID == 0 {
+		return 0, nil
+	}
+
+	var webhooks []model.Webhook
+	if err := db.Where("project_id = ? AND status = 1", action.ProjectID).Find(&webhooks).Error; err != nil {
+		return 0, err
+	}
+
+	eventType := action.ObjectType + "." + action.Action
+	var targets []model.Webhook
+	for _, webhook := range webhooks {
+		if WebhookSubscribed(&webhook, eventType) {
+			targets = append(targets, webhook)
+		}
+	}
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	payload := BuildWebhookPayload(db, action)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, webhook := range targets {
+		delivery := model.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       payload.ID,
+			EventType:     eventType,
+			Payload:       string(body),
+			Status:        WebhookDeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := db.Create(&delivery).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if webhookDispatcher != nil {
+		webhookDispatcher.Wake()
+	}
+	return len(targets), nil
+}
+
+var registerWebhookListenerOnce sync.Once
+
+// RegisterWebhookListener 注册操作记录监听器，将操作事件写入Webhook投递队列
+func RegisterWebhookListener() {
+	registerWebhookListenerOnce.Do(func() {
+		RegisterActionListener(func(db *gorm.DB, action *model.Action) {
+			if _, err := EnqueueWebhookDeliveries(db, action); err != nil && Logger != nil {
+				Logger.Errorf("[Webhook] 写入投递队列失败: %v", err)
+			}
+		})
+	})
+}
+
+// DeliverWebhook 执行一次投递并更新投递记录
+// 成功（2xx）标记为 success；失败时按指数退避安排重试，超过最大次数后标记为 failed
+func DeliverWebhook(db *gorm.DB, delivery *model.WebhookDelivery) error {
+	var webhook model.Webhook
+	if err := db.Unscoped().First(&webhook, delivery.WebhookID).Error; err != nil || webhook.DeletedAt.Valid {
+		// Webhook已删除，不再投递
+		delivery.Status = WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.Error = "Webhook已删除"
+		return db.Save(delivery).Error
+	}
+
+	start := time.Now()
+	statusCode, respBody, sendErr := sendWebhookRequest(&webhook, delivery)
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &start
+	delivery.Duration = time.Since(start).Milliseconds()
+	delivery.ResponseCode = statusCode
+	delivery.ResponseBody = respBody
+	delivery.Error = ""
+
+	if sendErr == nil && statusCode >= 200 && statusCode < 300 {
+		delivery.Status = WebhookDeliverySuccess
+		delivery.NextAttemptAt = nil
+	} else {
+		if sendErr != nil {
+			delivery.Error = sendErr.Error()
+		} else {
+			delivery.Error = fmt.Sprintf("HTTP %d", statusCode)
+		}
+		if delivery.Attempts >= webhookMaxAttempts() || webhook.Status != 1 {
+			delivery.Status = WebhookDeliveryFailed
+			delivery.NextAttemptAt = nil
+		} else {
+			next := time.Now().Add(WebhookBackoff(delivery.Attempts))
+			delivery.Status = WebhookDeliveryPending
+			delivery.NextAttemptAt = &next
+		}
+	}
+
+	return db.Save(delivery).Error
+}
+
+// sendWebhookRequest 发送Webhook请求
+func sendWebhookRequest(webhook *model.Webhook, delivery *model.WebhookDelivery) (int, string, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "prjflow-webhook")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.EventID)
+	if webhook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, body))
+	}
+
+	// 不使用环境代理，连接时逐个检查目标IP（包括重定向后的地址）
+	dialer := &net.Dialer{Timeout: webhookTimeout(), Control: webhookDialControl}
+	client := &http.Client{
+		Timeout:   webhookTimeout(),
+		Transport: &http.Transport{Proxy: nil, DialContext: dialer.DialContext},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBody))
+	return resp.StatusCode, string(respBody), nil
+}
+
+var (
+	webhookDispatcher     *WebhookDispatcher
+	webhookDispatcherOnce sync.Once
+)
+
+// WebhookDispatcher Webhook投递队列调度器
+type WebhookDispatcher struct {
+	db       *gorm.DB
+	wake     chan struct{}
+	stopChan chan struct{}
+	running  bool
+	mu       sync.Mutex
+}
+
+// GetWebhookDispatcher 获取Webhook调度器单例
+func GetWebhookDispatcher(db *gorm.DB) *WebhookDispatcher {
+	webhookDispatcherOnce.Do(func() {
+		webhookDispatcher = &WebhookDispatcher{
+			db:   db,
+			wake: make(chan struct{}, 1),
+		}
+	})
+	return webhookDispatcher
+}
+
+// Start 启动投递队列处理
+func (d *WebhookDispatcher) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		return
+	}
+	d.running = true
+	d.stopChan = make(chan struct{})
+
+	// 恢复进程中断时未完成的投递
+	d.db.Model(&model.WebhookDelivery{}).
+		Where("status = ? AND updated_at < ?", WebhookDeliveryDelivering, time.Now().Add(-webhookStaleDelivering)).
+		Update("status", WebhookDeliveryPending)
+
+	go d.loop(d.stopChan)
+	if Logger != nil {
+		Logger.Info("[Webhook] Webhook dispatcher started")
+	}
+}
+
+// Stop 停止投递队列处理
+func (d *WebhookDispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.running {
+		return
+	}
+	close(d.stopChan)
+	d.running = false
+}
+
+// Wake 唤醒调度器立即处理队列
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *WebhookDispatcher) loop(stop chan struct{}) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		ProcessWebhookQueue(d.db)
+	}
+}
+
+// ProcessWebhookQueue 处理到期的投递，返回处理的数量
+func ProcessWebhookQueue(db *gorm.DB) int {
+	var deliveries []model.WebhookDelivery
+	if err := db.Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, time.Now()).
+		Order("next_attempt_at ASC").Limit(webhookBatchSize).Find(&deliveries).Error; err != nil {
+		return 0
+	}
+
+	processed := 0
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		// 抢占投递记录，避免重复投递
+		result := db.Model(&model.WebhookDelivery{}).
+			Where("id = ? AND status = ?", delivery.ID, WebhookDeliveryPending).
+			Update("status", WebhookDeliveryDelivering)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		if err := DeliverWebhook(db, delivery); err != nil && Logger != nil {
+			Logger.Errorf("[Webhook] 投递失败: delivery_id=%d, error=%v", delivery.ID, err)
+		}
+		processed++
+	}
+	return processed
+}
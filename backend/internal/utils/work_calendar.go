@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"time"
+
+	"prjflow/internal/config"
+)
+
+// WorkCalendar 工作日历：每周休息日 + 节假日 + 调休上班日
+type WorkCalendar struct {
+	weekends map[time.Weekday]bool
+	holidays map[string]bool
+	workdays map[string]bool
+}
+
+// NewWorkCalendar 创建工作日历，日期格式为 YYYY-MM-DD
+func NewWorkCalendar(weekendDays []int, holidays, workdays []string) *WorkCalendar {
+	calendar := &WorkCalendar{
+		weekends: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+		workdays: make(map[string]bool),
+	}
+	for _, day := range weekendDays {
+		if day >= 0 && day <= 6 {
+			calendar.weekends[time.Weekday(day)] = true
+		}
+	}
+	calendar.AddHolidays(holidays...)
+	for _, date := range workdays {
+		calendar.workdays[date] = true
+	}
+	return calendar
+}
+
+// DefaultWorkCalendar 按配置创建工作日历，未配置休息日时默认周六、周日休息
+func DefaultWorkCalendar() *WorkCalendar {
+	weekendDays := []int{int(time.Sunday), int(time.Saturday)}
+	var holidays, workdays []string
+	if config.AppConfig != nil {
+		cfg := config.AppConfig.WorkCalendar
+		if cfg.WeekendDays != nil {
+			weekendDays = cfg.WeekendDays
+		}
+		holidays, workdays = cfg.Holidays, cfg.Workdays
+	}
+	return NewWorkCalendar(weekendDays, holidays, workdays)
+}
+
+// AddHolidays 追加非工作日（如临时放假）
+func (w *WorkCalendar) AddHolidays(dates ...string) {
+	for _, date := range dates {
+		if date != "" {
+			w.holidays[date] = true
+		}
+	}
+}
+
+// IsWorkingDay 判断某天是否为工作日，调休上班日优先
+func (w *WorkCalendar) IsWorkingDay(day time.Time) bool {
+	date := day.Format("2006-01-02")
+	if w.workdays[date] {
+		return true
+	}
+	return !w.holidays[date] && !w.weekends[day.Weekday()]
+}
+
+// WorkingDaysBetween 统计 (start, end] 之间的工作日数量
+func (w *WorkCalendar) WorkingDaysBetween(start, end time.Time) int {
+	count := 0
+	for day := startOfDay(start).AddDate(0, 0, 1); !day.After(end); day = day.AddDate(0, 0, 1) {
+		if w.IsWorkingDay(day) {
+			count++
+		}
+	}
+	return count
+}
+
+// maxCalendarScanDays 查找工作日时最多向前/向后扫描的天数，避免休息日配置错误时死循环
+const maxCalendarScanDays = 3660
+
+// NextWorkingDay 返回当天或之后的第一个工作日（零点）
+func (w *WorkCalendar) NextWorkingDay(day time.Time) time.Time {
+	day = startOfDay(day)
+	for i := 0; i < maxCalendarScanDays && !w.IsWorkingDay(day); i++ {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+// AddWorkingDays 从工作日 day 起数 n 个工作日（n 为负数时向前），返回对应的工作日
+func (w *WorkCalendar) AddWorkingDays(day time.Time, n int) time.Time {
+	day = startOfDay(day)
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for i := 0; n > 0 && i < maxCalendarScanDays; i++ {
+		day = day.AddDate(0, 0, step)
+		if w.IsWorkingDay(day) {
+			n--
+		}
+	}
+	return day
+}
+
+// WorkingDayOffset 计算 day 相对工作日 base 的工作日序号（day 为休息日时按之后的第一个工作日计算）
+func (w *WorkCalendar) WorkingDayOffset(base, day time.Time) int {
+	base, day = startOfDay(base), w.NextWorkingDay(day)
+	if day.Before(base) {
+		return -w.WorkingDaysBetween(day, base)
+	}
+	return w.WorkingDaysBetween(base, day)
+}
+
+// startOfDay 返回当天零点（本地时区）
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,395 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"prjflow/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// WorkflowError 状态流转校验错误
+type WorkflowError struct {
+	Message string
+}
+
+func (e *WorkflowError) Error() string {
+	return e.Message
+}
+
+// DefaultWorkflows 内置的默认工作流（与原有硬编码规则保持一致）
+// Bug：禅道规则 active->resolved, resolved->closed, resolved/closed->active
+// 任务和需求：任意状态之间都可以流转
+func DefaultWorkflows() []model.Workflow {
+	return []model.Workflow{
+		{
+			Name:         "默认Bug工作流",
+			ObjectType:   "bug",
+			InitialState: "active",
+			Status:       1,
+			States: []model.WorkflowState{
+				{Code: "active", Name: "激活", Category: "open", Sort: 0},
+				{Code: "resolved", Name: "已解决", Category: "doing", Sort: 1},
+				{Code: "closed", Name: "已关闭", Category: "done", Sort: 2},
+			},
+			Transitions: []model.WorkflowTransition{
+				{FromState: "active", ToState: "resolved", Name: "解决"},
+				{FromState: "resolved", ToState: "closed", Name: "关闭"},
+				{FromState: "resolved", ToState: "active", Name: "激活"},
+				{FromState: "closed", ToState: "active", Name: "激活"},
+			},
+		},
+		{
+			Name:         "默认任务工作流",
+			ObjectType:   "task",
+			InitialState: "wait",
+			Status:       1,
+			States: []model.WorkflowState{
+				{Code: "wait", Name: "未开始", Category: "open", Sort: 0},
+				{Code: "doing", Name: "进行中", Category: "doing", Sort: 1},
+				{Code: "pause", Name: "已暂停", Category: "doing", Sort: 2},
+				{Code: "done", Name: "已完成", Category: "done", Sort: 3},
+				{Code: "cancel", Name: "已取消", Category: "cancelled", Sort: 4},
+				{Code: "closed", Name: "已关闭", Category: "done", Sort: 5},
+			},
+			Transitions: []model.WorkflowTransition{
+				{FromState: "*", ToState: "wait"},
+				{FromState: "*", ToState: "doing"},
+				{FromState: "*", ToState: "pause"},
+				{FromState: "*", ToState: "done"},
+				{FromState: "*", ToState: "cancel"},
+				{FromState: "*", ToState: "closed"},
+			},
+		},
+		{
+			Name:         "默认需求工作流",
+			ObjectType:   "requirement",
+			InitialState: "draft",
+			Status:       1,
+			States: []model.WorkflowState{
+				{Code: "draft", Name: "草稿", Category: "open", Sort: 0},
+				{Code: "reviewing", Name: "评审中", Category: "open", Sort: 1},
+				{Code: "active", Name: "激活", Category: "doing", Sort: 2},
+				{Code: "changing", Name: "变更中", Category: "doing", Sort: 3},
+				{Code: "closed", Name: "已关闭", Category: "done", Sort: 4},
+			},
+			Transitions: []model.WorkflowTransition{
+				{FromState: "*", ToState: "draft"},
+				{FromState: "*", ToState: "reviewing"},
+				{FromState: "*", ToState: "active"},
+				{FromState: "*", ToState: "changing"},
+				{FromState: "*", ToState: "closed"},
+			},
+		},
+	}
+}
+
+// IsValidWorkflowObjectType 检查对象类型是否支持工作流
+func IsValidWorkflowObjectType(objectType string) bool {
+	return objectType == "bug" || objectType == "task" || objectType == "requirement"
+}
+
+// InitDefaultWorkflows 初始化全局默认工作流（仅在不存在时创建）
+// 已有的全局任务工作流中，早期版本把取消状态归为 done 分类，这里改为 cancelled 分类
+func InitDefaultWorkflows(db *gorm.DB) error {
+	if err := db.Model(&model.WorkflowState{}).
+		Where("code = ? AND category = ? AND workflow_id IN (?)", "cancel", "done",
+			db.Model(&model.Workflow{}).Select("id").Where("object_type = ? AND project_id IS NULL", "task")).
+		Update("category", "cancelled").Error; err != nil {
+		return err
+	}
+	for _, wf := range DefaultWorkflows() {
+		var count int64
+		db.Model(&model.Workflow{}).Where("object_type = ? AND project_id IS NULL", wf.ObjectType).Count(&count)
+		if count > 0 {
+			continue
+		}
+		workflow := wf
+		if err := db.Create(&workflow).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWorkflow 获取对象类型在项目中生效的工作流
+// 优先使用项目自定义工作流，其次使用全局工作流，最后回退到内置默认工作流
+func GetWorkflow(db *gorm.DB, objectType string, projectID uint) *model.Workflow {
+	var workflow model.Workflow
+	if projectID > 0 {
+		if err := db.Preload("States", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sort ASC, id ASC")
+		}).Preload("Transitions").
+			Where("object_type = ? AND project_id = ? AND status = 1", objectType, projectID).
+			Order("id DESC").First(&workflow).Error; err == nil {
+			return &workflow
+		}
+	}
+
+	if err := db.Preload("States", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort ASC, id ASC")
+	}).Preload("Transitions").
+		Where("object_type = ? AND project_id IS NULL AND status = 1", objectType).
+		Order("id DESC").First(&workflow).Error; err == nil {
+		return &workflow
+	}
+
+	for _, wf := range DefaultWorkflows() {
+		if wf.ObjectType == objectType {
+			return &wf
+		}
+	}
+	return nil
+}
+
+// WorkflowHasState 检查工作流是否包含指定状态
+func WorkflowHasState(workflow *model.Workflow, state string) bool {
+	if workflow == nil {
+		return false
+	}
+	for _, s := range workflow.States {
+		if s.Code == state {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkflowStateCodes 获取工作流的全部状态代码
+func WorkflowStateCodes(workflow *model.Workflow) []string {
+	if workflow == nil {
+		return nil
+	}
+	codes := make([]string, 0, len(workflow.States))
+	for _, s := range workflow.States {
+		codes = append(codes, s.Code)
+	}
+	return codes
+}
+
+// IsValidWorkflowState 检查状态是否属于对象类型在项目中生效的工作流
+func IsValidWorkflowState(db *gorm.DB, objectType string, projectID uint, state string) bool {
+	return WorkflowHasState(GetWorkflow(db, objectType, projectID), state)
+}
+
+// WorkflowInitialState 获取工作流的初始状态（未配置时使用第一个状态）
+func WorkflowInitialState(workflow *model.Workflow) string {
+	if workflow == nil {
+		return ""
+	}
+	if workflow.InitialState != "" {
+		return workflow.InitialState
+	}
+	if len(workflow.States) > 0 {
+		return workflow.States[0].Code
+	}
+	return ""
+}
+
+// ValidateWorkflowInitialState 校验新建对象的状态：为空时使用工作流的初始状态，否则必须等于初始状态
+func ValidateWorkflowInitialState(db *gorm.DB, objectType string, projectID uint, status string) (string, error) {
+	workflow := GetWorkflow(db, objectType, projectID)
+	initial := WorkflowInitialState(workflow)
+	if initial == "" {
+		return "", &WorkflowError{Message: fmt.Sprintf("未找到 %s 的工作流配置", objectType)}
+	}
+	if status == "" {
+		return initial, nil
+	}
+	if !WorkflowHasState(workflow, status) {
+		return "", &WorkflowError{Message: fmt.Sprintf("状态值无效，有效值：%s", strings.Join(WorkflowStateCodes(workflow), ", "))}
+	}
+	if status != initial {
+		return "", &WorkflowError{Message: fmt.Sprintf("新建时状态必须是工作流的初始状态：%s", initial)}
+	}
+	return status, nil
+}
+
+// WorkflowFieldsFromRequest 把请求中提供了值的字段（按 JSON 字段名）转换为流转必填字段的校验输入
+// req 一般是更新接口的请求结构体，未提供的指针字段和空数组视为未填写
+func WorkflowFieldsFromRequest(req interface{}) map[string]string {
+	fields := map[string]string{}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fields
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fields
+	}
+	for key, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			fields[key] = v
+		case []interface{}:
+			if len(v) > 0 {
+				fields[key] = fmt.Sprint(v...)
+			}
+		default:
+			fields[key] = fmt.Sprint(v)
+		}
+	}
+	return fields
+}
+
+// ValidateWorkflowTransition 校验状态流转
+// fields 为本次请求提供的字段（字段名 -> 值），用于校验流转的必填字段
+func ValidateWorkflowTransition(db *gorm.DB, c *gin.Context, objectType string, projectID uint, from, to string, fields map[string]string) error {
+	workflow := GetWorkflow(db, objectType, projectID)
+	if workflow == nil {
+		return &WorkflowError{Message: fmt.Sprintf("未找到 %s 的工作流配置", objectType)}
+	}
+
+	if !WorkflowHasState(workflow, to) {
+		return &WorkflowError{Message: fmt.Sprintf("状态值无效，有效值：%s", strings.Join(WorkflowStateCodes(workflow), ", "))}
+	}
+
+	// 状态未改变，允许
+	if from == to {
+		return nil
+	}
+
+	var candidates []model.WorkflowTransition
+	for _, t := range workflow.Transitions {
+		if t.ToState == to && (t.FromState == from || t.FromState == "*") {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return &WorkflowError{Message: fmt.Sprintf("状态转换无效：不能从 %s 转换到 %s。允许的转换：%s", from, to, describeTransitions(workflow))}
+	}
+
+	// 任意一条流转满足角色和必填字段要求即可
+	var lastErr error
+	for _, t := range candidates {
+		if !workflowRoleAllowed(db, c, projectID, t.AllowedRoles) {
+			lastErr = &WorkflowError{Message: fmt.Sprintf("没有权限执行状态转换：%s -> %s", from, to)}
+			continue
+		}
+		var missing []string
+		for _, field := range t.RequiredFields {
+			if strings.TrimSpace(fields[field]) == "" {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			lastErr = &WorkflowError{Message: fmt.Sprintf("状态转换 %s -> %s 缺少必填字段：%s", from, to, strings.Join(missing, ", "))}
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// workflowCategoryRank 状态分类的先后顺序，完成和取消同为结束状态
+func workflowCategoryRank(category string) int {
+	switch category {
+	case WorkflowCategoryDoing:
+		return 1
+	case WorkflowCategoryDone, WorkflowCategoryCancelled:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// CanAutoTransition 检查系统自动执行的状态流转（如指派后开始、进度达到100%后完成）是否可以执行：
+// 只从未开始（open）或进行中（doing）的状态向后流转，已完成或已取消的工作项不会被自动改变；
+// 流转本身仍需通过工作流校验（目标状态存在、角色限制和必填字段），不满足时跳过自动流转
+func CanAutoTransition(db *gorm.DB, c *gin.Context, objectType string, projectID uint, from, to string) bool {
+	if from == to {
+		return false
+	}
+	workflow := GetWorkflow(db, objectType, projectID)
+	if workflow == nil {
+		return false
+	}
+	categories := make(map[string]string, len(workflow.States))
+	for _, state := range workflow.States {
+		categories[state.Code] = state.Category
+	}
+	fromCategory, ok := categories[from]
+	if !ok || fromCategory == WorkflowCategoryDone || fromCategory == WorkflowCategoryCancelled {
+		return false
+	}
+	if workflowCategoryRank(fromCategory) >= workflowCategoryRank(categories[to]) {
+		return false
+	}
+	return ValidateWorkflowTransition(db, c, objectType, projectID, from, to, map[string]string{}) == nil
+}
+
+// GetAvailableTransitions 获取当前用户从指定状态可以执行的流转
+func GetAvailableTransitions(db *gorm.DB, c *gin.Context, workflow *model.Workflow, projectID uint, from string) []model.WorkflowTransition {
+	var result []model.WorkflowTransition
+	if workflow == nil {
+		return result
+	}
+	for _, t := range workflow.Transitions {
+		if t.FromState != from && t.FromState != "*" {
+			continue
+		}
+		if t.ToState == from {
+			continue
+		}
+		if !workflowRoleAllowed(db, c, projectID, t.AllowedRoles) {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// workflowRoleAllowed 检查当前用户是否满足流转的角色限制
+// 角色可以是全局角色代码（如 tester），也可以是项目角色（如 project:owner）
+func workflowRoleAllowed(db *gorm.DB, c *gin.Context, projectID uint, allowedRoles []string) bool {
+	if len(allowedRoles) == 0 || c == nil {
+		return true
+	}
+	if IsAdmin(c) {
+		return true
+	}
+
+	var userRoles []string
+	if roles, exists := c.Get("roles"); exists {
+		if roleList, ok := roles.([]string); ok {
+			userRoles = roleList
+		}
+	}
+
+	var projectRole string
+	userID := GetUserID(c)
+	if projectID > 0 && userID > 0 {
+		var member model.ProjectMember
+		if err := db.Where("project_id = ? AND user_id = ?", projectID, userID).First(&member).Error; err == nil {
+			projectRole = member.Role
+		}
+	}
+
+	for _, allowed := range allowedRoles {
+		if strings.HasPrefix(allowed, "project:") {
+			if projectRole != "" && strings.TrimPrefix(allowed, "project:") == projectRole {
+				return true
+			}
+			continue
+		}
+		for _, role := range userRoles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describeTransitions 生成工作流流转规则的描述（用于错误提示）
+func describeTransitions(workflow *model.Workflow) string {
+	parts := make([]string, 0, len(workflow.Transitions))
+	for _, t := range workflow.Transitions {
+		parts = append(parts, t.FromState+"->"+t.ToState)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,521 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	// 写超时
+	eventWriteWait = 10 * time.Second
+	// 客户端必须在该时间内回应pong
+	eventPongWait = 60 * time.Second
+	// ping间隔（必须小于pongWait）
+	eventPingPeriod = (eventPongWait * 9) / 10
+	// 客户端消息大小限制
+	eventMaxMessageSize = 1024
+)
+
+// eventObjectTypes 需要推送实时事件的对象类型
+var eventObjectTypes = map[string]bool{
+	"bug":         true,
+	"task":        true,
+	"requirement": true,
+}
+
+// Event 项目实时事件
+type Event struct {
+	Type       string          `json:"type"`        // 事件类型，如 bug.created, task.status_changed, task.moved
+	ProjectID  uint            `json:"project_id"`  // 项目ID
+	ObjectType string          `json:"object_type"` // 对象类型
+	ObjectID   uint            `json:"object_id"`   // 对象ID
+	Action     string          `json:"action"`      // 操作类型
+	ActionID   uint            `json:"action_id"`   // 操作记录ID
+	ActorID    uint            `json:"actor_id"`    // 操作人ID
+	Comment    string          `json:"comment,omitempty"`
+	Extra      json.RawMessage `json:"extra,omitempty"`
+	Date       time.Time       `json:"date"`
+	Topics     []string        `json:"topics"` // 事件所属的主题
+}
+
+// EventMessage 事件流消息
+type EventMessage struct {
+	Type    string      `json:"type"`              // 消息类型：event, notification, subscribed, unsubscribed, pong, error
+	Topic   string      `json:"topic,omitempty"`   // 主题
+	Data    interface{} `json:"data,omitempty"`    // 消息数据
+	Message string      `json:"message,omitempty"` // 消息内容
+}
+
+// clientRequest 客户端发送的订阅请求
+type clientRequest struct {
+	Action string `json:"action"` // subscribe, unsubscribe, ping
+	Topic  string `json:"topic"`
+}
+
+// Client 表示一个已认证用户的事件流连接（同一用户可以有多个连接，如多个标签页）
+type Client struct {
+	ws     *websocket.Conn
+	send   chan []byte
+	userID uint
+	db     *gorm.DB
+	// 已认证的请求上下文副本（包含 user_id、roles），用于权限检查
+	ctx *gin.Context
+	// 连接时使用的Token声明，推送前用于重新校验会话
+	claims *auth.Claims
+
+	mu     sync.RWMutex
+	topics map[string]bool
+}
+
+// UserID 获取连接所属用户ID
+func (c *Client) UserID() uint {
+	return c.userID
+}
+
+// registerClient 注册事件流连接
+func (h *Hub) registerClient(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients == nil {
+		h.clients = make(map[uint]map[*Client]bool)
+	}
+	if h.clients[client.userID] == nil {
+		h.clients[client.userID] = make(map[*Client]bool)
+	}
+	h.clients[client.userID][client] = true
+
+	if utils.Logger != nil {
+		utils.Logger.Infof("事件流连接已注册: user_id=%d, connections=%d", client.userID, len(h.clients[client.userID]))
+	}
+}
+
+// unregisterClient 注销事件流连接
+func (h *Hub) unregisterClient(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns, ok := h.clients[client.userID]
+	if !ok || !conns[client] {
+		return
+	}
+	delete(conns, client)
+	close(client.send)
+	if len(conns) == 0 {
+		delete(h.clients, client.userID)
+	}
+
+	if utils.Logger != nil {
+		utils.Logger.Infof("事件流连接已注销: user_id=%d", client.userID)
+	}
+}
+
+// ClientCount 获取用户当前的事件流连接数
+func (h *Hub) ClientCount(userID uint) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID])
+}
+
+// PublishEvent 向订阅了事件任一主题的连接推送事件
+func (h *Hub) PublishEvent(event *Event) {
+	msgBytes, err := json.Marshal(EventMessage{Type: "event", Data: event})
+	if err != nil {
+		return
+	}
+
+	h.mu.RLock()
+	var targets []*Client
+	for _, conns := range h.clients {
+		for client := range conns {
+			if client.subscribedAny(event.Topics) {
+				targets = append(targets, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range targets {
+		if !h.sessionValid(client) || !client.reauthorize(event.Topics) {
+			continue
+		}
+		h.deliver(client, msgBytes)
+	}
+}
+
+// SendToUser 向用户的所有事件流连接推送消息（不需要订阅主题），返回推送的连接数
+func (h *Hub) SendToUser(userID uint, msgType string, data interface{}) int {
+	msgBytes, err := json.Marshal(EventMessage{Type: msgType, Data: data})
+	if err != nil {
+		return 0
+	}
+
+	h.mu.RLock()
+	targets := make([]*Client, 0, len(h.clients[userID]))
+	for client := range h.clients[userID] {
+		targets = append(targets, client)
+	}
+	h.mu.RUnlock()
+
+	delivered := 0
+	for _, client := range targets {
+		if !h.sessionValid(client) {
+			continue
+		}
+		h.deliver(client, msgBytes)
+		delivered++
+	}
+	return delivered
+}
+
+// sessionValid 推送前重新校验连接的会话：会话被吊销、过期或用户被禁用时断开连接
+func (h *Hub) sessionValid(client *Client) bool {
+	if client.claims == nil {
+		return true
+	}
+	if err := utils.ValidateTokenSession(client.db, client.claims); err != nil {
+		client.reply(EventMessage{Type: "error", Message: "会话已失效，请重新登录"})
+		h.unregisterClient(client)
+		return false
+	}
+	return true
+}
+
+// deliver 发送消息到连接，发送缓冲区已满时断开连接
+func (h *Hub) deliver(client *Client, msgBytes []byte) {
+	h.mu.RLock()
+	alive := h.clients[client.userID][client]
+	if alive {
+		select {
+		case client.send <- msgBytes:
+			h.mu.RUnlock()
+			return
+		default:
+		}
+	}
+	h.mu.RUnlock()
+	if alive {
+		h.unregisterClient(client)
+	}
+}
+
+// subscribedAny 检查连接是否订阅了任一主题
+func (c *Client) subscribedAny(topics []string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, topic := range topics {
+		if c.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// reauthorize 推送前对事件涉及的已订阅主题重新检查权限（如用户已离开项目），
+// 已无权限的主题取消订阅并通知客户端，仍有权限访问任一主题时返回 true
+func (c *Client) reauthorize(topics []string) bool {
+	allowed := false
+	for _, topic := range topics {
+		c.mu.RLock()
+		subscribed := c.topics[topic]
+		c.mu.RUnlock()
+		if !subscribed {
+			continue
+		}
+		if err := c.authorizeTopic(topic); err != nil {
+			c.unsubscribe(topic)
+			c.reply(EventMessage{Type: "unsubscribed", Topic: topic, Message: err.Error()})
+			continue
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// reply 向连接发送控制消息
+func (c *Client) reply(msg EventMessage) {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	GetHub().deliver(c, msgBytes)
+}
+
+// subscribe 订阅主题（会检查用户是否有权限访问主题对应的项目或对象）
+func (c *Client) subscribe(topic string) error {
+	if err := c.authorizeTopic(topic); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.topics[topic] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// unsubscribe 取消订阅主题
+func (c *Client) unsubscribe(topic string) {
+	c.mu.Lock()
+	delete(c.topics, topic)
+	c.mu.Unlock()
+}
+
+// authorizeTopic 检查主题权限
+// 支持的主题：project:{id}, bug:{id}, task:{id}, requirement:{id}, board:{id}
+func (c *Client) authorizeTopic(topic string) error {
+	parts := strings.SplitN(topic, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("无效的主题：%s", topic)
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || id == 0 {
+		return fmt.Errorf("无效的主题：%s", topic)
+	}
+
+	allowed := false
+	switch parts[0] {
+	case "project":
+		allowed = utils.CheckProjectAccess(c.db, c.ctx, uint(id))
+	case "bug":
+		allowed = utils.CheckBugAccess(c.db, c.ctx, uint(id))
+	case "task":
+		allowed = utils.CheckTaskAccess(c.db, c.ctx, uint(id))
+	case "requirement":
+		allowed = utils.CheckRequirementAccess(c.db, c.ctx, uint(id))
+	case "board":
+		var board model.Board
+		if err := c.db.First(&board, id).Error; err == nil {
+			allowed = utils.CheckProjectAccess(c.db, c.ctx, board.ProjectID)
+		}
+	default:
+		return fmt.Errorf("不支持的主题类型：%s", parts[0])
+	}
+	if !allowed {
+		return fmt.Errorf("没有权限订阅主题：%s", topic)
+	}
+	return nil
+}
+
+// readPump 读取客户端的订阅请求
+func (c *Client) readPump() {
+	defer func() {
+		GetHub().unregisterClient(c)
+		c.ws.Close()
+	}()
+
+	c.ws.SetReadLimit(eventMaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(eventPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(eventPongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				if utils.Logger != nil {
+					utils.Logger.Errorf("事件流连接错误: %v", err)
+				}
+			}
+			break
+		}
+
+		var req clientRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.reply(EventMessage{Type: "error", Message: "无效的消息格式"})
+			continue
+		}
+
+		switch req.Action {
+		case "subscribe":
+			if err := c.subscribe(req.Topic); err != nil {
+				c.reply(EventMessage{Type: "error", Topic: req.Topic, Message: err.Error()})
+				continue
+			}
+			c.reply(EventMessage{Type: "subscribed", Topic: req.Topic})
+		case "unsubscribe":
+			c.unsubscribe(req.Topic)
+			c.reply(EventMessage{Type: "unsubscribed", Topic: req.Topic})
+		case "ping":
+			c.reply(EventMessage{Type: "pong"})
+		default:
+			c.reply(EventMessage{Type: "error", Message: "不支持的操作：" + req.Action})
+		}
+	}
+}
+
+// writePump 向客户端写入消息并定期发送ping
+func (c *Client) writePump() {
+	ticker := time.NewTicker(eventPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(eventWriteWait))
+			if !ok {
+				c.ws.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.ws.WriteMessage(websocket.TextMessage, message); err != nil {
+				if utils.Logger != nil {
+					utils.Logger.Errorf("事件流写入错误: %v", err)
+				}
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(eventWriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HandleEventStream 处理项目事件流连接
+// 浏览器无法为WebSocket设置请求头，因此Token可以通过 token 查询参数传递
+// 可以通过 topics 查询参数（逗号分隔）在连接时订阅主题，也可以连接后发送 {"action":"subscribe","topic":"project:1"}
+func HandleEventStream(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				token = parts[1]
+			}
+		}
+		if token == "" {
+			utils.Error(c, 401, "未授权，请先登录")
+			return
+		}
+
+		claims, err := auth.ParseAccessToken(token)
+		if err != nil {
+			utils.Error(c, 401, "无效的Token")
+			return
+		}
+		if err := utils.ValidateTokenSession(db, claims); err != nil {
+			utils.Error(c, 401, "无效的Token")
+			return
+		}
+
+		ctx := c.Copy()
+		ctx.Set("user_id", claims.UserID)
+		ctx.Set("username", claims.Username)
+		ctx.Set("roles", claims.Roles)
+
+		client := &Client{
+			send:   make(chan []byte, 256),
+			userID: claims.UserID,
+			db:     db,
+			ctx:    ctx,
+			claims: claims,
+			topics: make(map[string]bool),
+		}
+
+		// 连接时订阅的主题（无权限的主题直接拒绝连接）
+		var initialTopics []string
+		if topics := c.Query("topics"); topics != "" {
+			for _, topic := range strings.Split(topics, ",") {
+				topic = strings.TrimSpace(topic)
+				if topic == "" {
+					continue
+				}
+				if err := client.subscribe(topic); err != nil {
+					utils.Error(c, 403, err.Error())
+					return
+				}
+				initialTopics = append(initialTopics, topic)
+			}
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			if utils.Logger != nil {
+				utils.Logger.Errorf("WebSocket升级失败: %v", err)
+			}
+			return
+		}
+		client.ws = conn
+
+		hub := GetHub()
+		hub.registerClient(client)
+		go client.writePump()
+		go client.readPump()
+
+		for _, topic := range initialTopics {
+			client.reply(EventMessage{Type: "subscribed", Topic: topic})
+		}
+	}
+}
+
+// BuildActionEvent 根据操作记录构建实时事件
+func BuildActionEvent(action *model.Action) *Event {
+	event := &Event{
+		Type:       action.ObjectType + "." + action.Action,
+		ProjectID:  action.ProjectID,
+		ObjectType: action.ObjectType,
+		ObjectID:   action.ObjectID,
+		Action:     action.Action,
+		ActionID:   action.ID,
+		ActorID:    action.ActorID,
+		Comment:    action.Comment,
+		Date:       action.Date,
+		Topics:     []string{fmt.Sprintf("%s:%d", action.ObjectType, action.ObjectID)},
+	}
+	if action.ProjectID > 0 {
+		event.Topics = append(event.Topics, fmt.Sprintf("project:%d", action.ProjectID))
+	}
+	if action.Extra != "" {
+		event.Extra = json.RawMessage(action.Extra)
+		// 看板移动事件同时推送到看板主题
+		var extra struct {
+			BoardID uint `json:"board_id"`
+		}
+		if err := json.Unmarshal([]byte(action.Extra), &extra); err == nil && extra.BoardID > 0 {
+			event.Topics = append(event.Topics, fmt.Sprintf("board:%d", extra.BoardID))
+		}
+	}
+	return event
+}
+
+var (
+	registerActionEventsOnce  sync.Once
+	registerNotificationsOnce sync.Once
+)
+
+// RegisterActionEvents 注册操作记录监听器，将Bug、任务、需求的操作推送到事件流（重复调用只注册一次）
+func RegisterActionEvents() {
+	registerActionEventsOnce.Do(func() {
+		utils.RegisterActionListener(func(db *gorm.DB, action *model.Action) {
+			if !eventObjectTypes[action.ObjectType] {
+				return
+			}
+			GetHub().PublishEvent(BuildActionEvent(action))
+		})
+	})
+}
+
+// RegisterNotificationPush 注册通知推送器，用户在线时将新通知实时推送到其事件流连接（重复调用只注册一次）
+func RegisterNotificationPush() {
+	registerNotificationsOnce.Do(func() {
+		utils.RegisterNotificationPusher(func(notification *model.Notification) {
+			GetHub().SendToUser(notification.UserID, "notification", notification)
+		})
+	})
+}
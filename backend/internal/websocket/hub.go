@@ -13,6 +13,8 @@ import (
 type Hub struct {
 	// 注册的连接，key是ticket，value是连接
 	connections map[string]*Connection
+	// 项目事件流连接，key是用户ID（同一用户可以有多个连接）
+	clients map[uint]map[*Client]bool
 	// 互斥锁
 	mu sync.RWMutex
 }
@@ -40,6 +42,7 @@ var hub *Hub
 func init() {
 	hub = &Hub{
 		connections: make(map[string]*Connection),
+		clients:     make(map[uint]map[*Client]bool),
 	}
 }
 
@@ -7,13 +7,32 @@ import (
 	"prjflow/internal/config"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// RefreshTokenExpiration Refresh Token 有效期（7 天）
+const RefreshTokenExpiration = 7 * 24 * time.Hour
+
+// TwoFactorTokenExpiration 两步验证临时Token有效期（5 分钟）
+const TwoFactorTokenExpiration = 5 * time.Minute
+
+// Token类型
+const (
+	TokenTypeAccess  = "access"  // 访问接口使用的 Access Token
+	TokenTypeRefresh = "refresh" // 只能用于刷新的 Refresh Token
+	// TokenTypeTwoFactor 密码验证通过、等待两步验证码的临时Token，只能用于完成登录
+	TokenTypeTwoFactor = "two_factor"
+)
+
+// ErrInvalidTokenType Token类型与用途不匹配
+var ErrInvalidTokenType = errors.New("invalid token type")
+
 type Claims struct {
 	UserID    uint     `json:"user_id"`
 	Username  string   `json:"username"`
 	Roles     []string `json:"roles"`
-	TokenType string   `json:"token_type"` // "access" 或 "refresh"
+	TokenType string   `json:"token_type"`    // TokenTypeAccess、TokenTypeRefresh 或 TokenTypeTwoFactor
+	SessionID string   `json:"sid,omitempty"` // 登录会话ID（服务端会话表的键，用于吊销）
 	jwt.RegisteredClaims
 }
 
@@ -27,6 +46,11 @@ func getJWTSecret() []byte {
 
 // GenerateToken 生成JWT Token (Access Token)
 func GenerateToken(userID uint, username string, roles []string) (string, error) {
+	return GenerateSessionToken(userID, username, roles, "")
+}
+
+// GenerateSessionToken 生成属于指定登录会话的 Access Token
+func GenerateSessionToken(userID uint, username string, roles []string, sessionID string) (string, error) {
 	if config.AppConfig == nil {
 		return "", errors.New("config not initialized")
 	}
@@ -38,8 +62,10 @@ func GenerateToken(userID uint, username string, roles []string) (string, error)
 		UserID:    userID,
 		Username:  username,
 		Roles:     roles,
-		TokenType: "access",
+		TokenType: TokenTypeAccess,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
@@ -53,25 +79,60 @@ func GenerateToken(userID uint, username string, roles []string) (string, error)
 // GenerateRefreshToken 生成Refresh Token
 // Refresh Token 的有效期通常比 Access Token 长得多（例如 7 天或 30 天）
 func GenerateRefreshToken(userID uint, username string, roles []string) (string, error) {
+	token, _, err := GenerateSessionRefreshToken(userID, username, roles, "")
+	return token, err
+}
+
+// GenerateSessionRefreshToken 生成属于指定登录会话的 Refresh Token，同时返回Token ID（jti）
+// 会话只接受最新签发的 Refresh Token，用于检测重复使用
+func GenerateSessionRefreshToken(userID uint, username string, roles []string, sessionID string) (string, string, error) {
 	if config.AppConfig == nil {
-		return "", errors.New("config not initialized")
+		return "", "", errors.New("config not initialized")
 	}
 
 	// Refresh Token 有效期设置为 7 天
-	expirationTime := time.Now().Add(7 * 24 * time.Hour)
+	expirationTime := time.Now().Add(RefreshTokenExpiration)
 
 	claims := &Claims{
 		UserID:    userID,
 		Username:  username,
 		Roles:     roles,
-		TokenType: "refresh",
+		TokenType: TokenTypeRefresh,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(getJWTSecret())
+	if err != nil {
+		return "", "", err
+	}
+	return signed, claims.ID, nil
+}
+
+// GenerateTwoFactorToken 生成两步验证临时Token（不属于任何会话，不能访问接口）
+func GenerateTwoFactorToken(userID uint, username string) (string, error) {
+	if config.AppConfig == nil {
+		return "", errors.New("config not initialized")
+	}
+
+	claims := &Claims{
+		UserID:    userID,
+		Username:  username,
+		TokenType: TokenTypeTwoFactor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TwoFactorTokenExpiration)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(getJWTSecret())
 }
@@ -95,3 +156,29 @@ func ParseToken(tokenString string) (*Claims, error) {
 
 	return nil, errors.New("invalid token")
 }
+
+// ParseAccessToken 解析 Access Token，Refresh Token 不能作为访问凭证
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	return parseTokenOfType(tokenString, TokenTypeAccess)
+}
+
+// ParseRefreshToken 解析 Refresh Token，Access Token 不能用于刷新
+func ParseRefreshToken(tokenString string) (*Claims, error) {
+	return parseTokenOfType(tokenString, TokenTypeRefresh)
+}
+
+// ParseTwoFactorToken 解析两步验证临时Token
+func ParseTwoFactorToken(tokenString string) (*Claims, error) {
+	return parseTokenOfType(tokenString, TokenTypeTwoFactor)
+}
+
+func parseTokenOfType(tokenString, tokenType string) (*Claims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != tokenType {
+		return nil, ErrInvalidTokenType
+	}
+	return claims, nil
+}
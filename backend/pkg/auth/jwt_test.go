@@ -177,3 +177,41 @@ func TestParseToken(t *testing.T) {
 	})
 }
 
+
+func TestParseTokenByType(t *testing.T) {
+	// 初始化JWT配置
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{
+			JWT: config.JWTConfig{
+				Secret:     "test-secret-key-for-unit-testing",
+				Expiration: 24,
+			},
+		}
+	}
+
+	accessToken, err := GenerateSessionToken(1, "testuser", []string{"admin"}, "session-1")
+	require.NoError(t, err)
+	refreshToken, refreshTokenID, err := GenerateSessionRefreshToken(1, "testuser", []string{"admin"}, "session-1")
+	require.NoError(t, err)
+
+	t.Run("按类型解析成功", func(t *testing.T) {
+		accessClaims, err := ParseAccessToken(accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "session-1", accessClaims.SessionID)
+
+		refreshClaims, err := ParseRefreshToken(refreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, "session-1", refreshClaims.SessionID)
+		assert.Equal(t, refreshTokenID, refreshClaims.ID)
+		// 每个Token都有唯一的ID
+		assert.NotEqual(t, accessClaims.ID, refreshClaims.ID)
+	})
+
+	t.Run("类型不匹配", func(t *testing.T) {
+		_, err := ParseAccessToken(refreshToken)
+		assert.ErrorIs(t, err, ErrInvalidTokenType)
+
+		_, err = ParseRefreshToken(accessToken)
+		assert.ErrorIs(t, err, ErrInvalidTokenType)
+	})
+}
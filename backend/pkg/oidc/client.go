@@ -0,0 +1,325 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// discoveryPath OpenID Provider 配置发现地址
+const discoveryPath = "/.well-known/openid-configuration"
+
+var (
+	ErrNonceMismatch  = errors.New("ID Token 的 nonce 不匹配")
+	ErrMissingIDToken = errors.New("令牌响应中没有 id_token")
+)
+
+// Config OIDC客户端配置
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // 为空时使用 openid profile email
+}
+
+// Discovery OpenID Provider 元数据
+type Discovery struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserInfoEndpoint              string   `json:"userinfo_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// TokenResponse 令牌端点响应
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// IDToken 校验通过的 ID Token
+type IDToken struct {
+	Subject           string                 `json:"sub"`
+	Email             string                 `json:"email"`
+	EmailVerified     bool                   `json:"email_verified"`
+	Name              string                 `json:"name"`
+	PreferredUsername string                 `json:"preferred_username"`
+	Picture           string                 `json:"picture"`
+	Nonce             string                 `json:"nonce"`
+	Claims            map[string]interface{} `json:"-"` // 全部声明，用于读取自定义的用户名声明
+}
+
+// Client OIDC客户端，实现授权码流程（PKCE）、配置发现和 ID Token 校验
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// NewClient 创建OIDC客户端，httpClient 为空时使用10秒超时的默认客户端
+func NewClient(cfg Config, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	return &Client{cfg: cfg, httpClient: httpClient}
+}
+
+// Discover 获取并缓存 OpenID Provider 元数据
+func (c *Client) Discover() (*Discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	var discovery Discovery
+	if err := c.getJSON(strings.TrimSuffix(c.cfg.Issuer, "/")+discoveryPath, &discovery); err != nil {
+		return nil, fmt.Errorf("获取OIDC配置失败: %w", err)
+	}
+	// 防止配置被替换为其他签发者
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(c.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC签发者不匹配: 期望 %s，实际 %s", c.cfg.Issuer, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC配置缺少必要的端点")
+	}
+	c.discovery = &discovery
+	return c.discovery, nil
+}
+
+// AuthCodeURL 生成授权地址，codeChallenge 为 PKCE 的 S256 挑战值
+func (c *Client) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	discovery, err := c.Discover()
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.cfg.ClientID)
+	params.Set("redirect_uri", c.cfg.RedirectURL)
+	params.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange 用授权码和 PKCE 验证码换取令牌
+func (c *Client) Exchange(code, codeVerifier string) (*TokenResponse, error) {
+	discovery, err := c.Discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", c.cfg.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求令牌失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("读取令牌响应失败: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errorResp struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &errorResp) == nil && errorResp.Error != "" {
+			return nil, fmt.Errorf("令牌端点返回错误: %s %s", errorResp.Error, errorResp.ErrorDescription)
+		}
+		return nil, fmt.Errorf("令牌端点返回状态码 %d", resp.StatusCode)
+	}
+
+	var token TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("解析令牌响应失败: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+	return &token, nil
+}
+
+// VerifyIDToken 校验 ID Token 的签名、签发者、受众、有效期和 nonce
+func (c *Client) VerifyIDToken(rawIDToken, nonce string) (*IDToken, error) {
+	discovery, err := c.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(c.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if _, err := parser.ParseWithClaims(rawIDToken, claims, c.keyFunc); err != nil {
+		return nil, fmt.Errorf("ID Token 校验失败: %w", err)
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var idToken IDToken
+	if err := json.Unmarshal(data, &idToken); err != nil {
+		return nil, fmt.Errorf("解析 ID Token 失败: %w", err)
+	}
+	idToken.Claims = claims
+	if idToken.Subject == "" {
+		return nil, errors.New("ID Token 缺少 sub")
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return &idToken, nil
+}
+
+// keyFunc 按 kid 查找签名公钥，找不到时重新拉取 JWKS（签名密钥轮换）
+func (c *Client) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key := c.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if err := c.refreshKeys(); err != nil {
+		return nil, err
+	}
+	if key := c.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未找到签名密钥: %s", kid)
+}
+
+func (c *Client) lookupKey(kid string) *rsa.PublicKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key
+		}
+	}
+	return c.keys[kid]
+}
+
+func (c *Client) refreshKeys() error {
+	discovery, err := c.Discover()
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("获取签名密钥失败: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) getJSON(endpoint string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回状态码 %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// GenerateRandomString 生成URL安全的随机字符串，用于 state、nonce 和 PKCE 验证码
+func GenerateRandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CodeChallengeS256 计算 PKCE 的 S256 挑战值
+func CodeChallengeS256(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,314 @@
+package permission
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"prjflow/internal/model"
+)
+
+// CacheTTL 缓存条目的最长有效期，作为多实例部署或绕过ORM修改数据时的兜底
+const CacheTTL = 5 * time.Minute
+
+// 写入这些表时清空角色权限缓存
+var rolePermissionTables = []string{"roles", "permissions", "role_permissions", "user_roles"}
+
+// 写入这些表时清空项目成员缓存
+var membershipTables = []string{"project_members"}
+
+// 写入这些表时清空项目角色权限映射缓存（映射保存在系统配置表中）
+var projectRoleTables = []string{"system_configs"}
+
+// caches 每个数据库连接一个缓存，以回调注册表区分（Session、事务等派生出的连接共享同一个注册表，即使复制了 gorm.Config）
+var caches sync.Map
+
+// Cache 进程内的角色权限、项目成员和项目角色权限映射缓存
+type Cache struct {
+	mu               sync.RWMutex
+	rolePerms        map[string]rolePermEntry // 排序后的角色代码 -> 权限代码
+	memberships      map[uint]membershipEntry // 用户ID -> 项目ID -> 项目角色
+	projectRolePerms *projectRoleEntry        // 项目角色 -> 权限模式
+
+	roleHits, roleMisses               atomic.Uint64
+	membershipHits, membershipMisses   atomic.Uint64
+	projectRoleHits, projectRoleMisses atomic.Uint64
+	// invalidations 同时作为缓存版本号：查询数据库期间发生失效时，不写入查询结果
+	invalidations atomic.Uint64
+}
+
+type rolePermEntry struct {
+	perms     []string
+	expiresAt time.Time
+}
+
+type membershipEntry struct {
+	projects  map[uint]string
+	expiresAt time.Time
+}
+
+type projectRoleEntry struct {
+	rolePerms map[string][]string
+	expiresAt time.Time
+}
+
+// CacheCounter 单类缓存的统计信息
+type CacheCounter struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// CacheStats 缓存统计信息
+type CacheStats struct {
+	Enabled         bool         `json:"enabled"`
+	RolePermissions CacheCounter `json:"role_permissions"`
+	Memberships     CacheCounter `json:"memberships"`
+	ProjectRoles    CacheCounter `json:"project_roles"`
+	Invalidations   uint64       `json:"invalidations"`
+}
+
+// EnableCache 为数据库连接启用权限缓存，并注册回调：写入角色、权限、项目成员、系统配置相关的表时自动清空对应缓存
+// 在事务中写入时回调先于提交执行，修改权限的接口在操作完成后还应调用 InvalidateCache
+func EnableCache(db *gorm.DB) error {
+	if _, loaded := caches.LoadOrStore(db.Callback(), newCache()); loaded {
+		return nil
+	}
+
+	invalidate := func(tx *gorm.DB) {
+		cache := getCache(tx)
+		if cache == nil {
+			return
+		}
+		table := tx.Statement.Table
+		if table == "" {
+			// 原生SQL语句无法得知表名，只要涉及相关表就清空
+			sql := strings.ToLower(tx.Statement.SQL.String())
+			for _, name := range append(append(append([]string{}, rolePermissionTables...), membershipTables...), projectRoleTables...) {
+				if strings.Contains(sql, name) {
+					cache.Invalidate()
+					return
+				}
+			}
+			return
+		}
+		if containsString(rolePermissionTables, table) {
+			cache.invalidateRolePermissions()
+		}
+		if containsString(membershipTables, table) {
+			cache.invalidateMemberships()
+		}
+		if containsString(projectRoleTables, table) {
+			cache.invalidateProjectRolePermissions()
+		}
+	}
+
+	callbacks := db.Callback()
+	if err := callbacks.Create().After("gorm:create").Register("permission:invalidate_cache", invalidate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("permission:invalidate_cache", invalidate); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("permission:invalidate_cache", invalidate); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").Register("permission:invalidate_cache", invalidate)
+}
+
+// InvalidateCache 清空数据库连接的权限缓存
+func InvalidateCache(db *gorm.DB) {
+	if cache := getCache(db); cache != nil {
+		cache.Invalidate()
+	}
+}
+
+// GetCacheStats 获取缓存命中统计
+func GetCacheStats(db *gorm.DB) CacheStats {
+	cache := getCache(db)
+	if cache == nil {
+		return CacheStats{}
+	}
+	cache.mu.RLock()
+	roleEntries, membershipEntries := len(cache.rolePerms), len(cache.memberships)
+	projectRoleEntries := 0
+	if cache.projectRolePerms != nil {
+		projectRoleEntries = len(cache.projectRolePerms.rolePerms)
+	}
+	cache.mu.RUnlock()
+	return CacheStats{
+		Enabled: true,
+		RolePermissions: CacheCounter{
+			Hits:    cache.roleHits.Load(),
+			Misses:  cache.roleMisses.Load(),
+			Entries: roleEntries,
+		},
+		Memberships: CacheCounter{
+			Hits:    cache.membershipHits.Load(),
+			Misses:  cache.membershipMisses.Load(),
+			Entries: membershipEntries,
+		},
+		ProjectRoles: CacheCounter{
+			Hits:    cache.projectRoleHits.Load(),
+			Misses:  cache.projectRoleMisses.Load(),
+			Entries: projectRoleEntries,
+		},
+		Invalidations: cache.invalidations.Load(),
+	}
+}
+
+// GetUserProjectRoles 获取用户参与的项目及其项目角色（项目ID -> 角色），启用缓存时优先从缓存读取
+// 返回的 map 可能与缓存共享，调用方不能修改
+func GetUserProjectRoles(db *gorm.DB, userID uint) (map[uint]string, error) {
+	cache := getCache(db)
+	var version uint64
+	if cache != nil {
+		version = cache.invalidations.Load()
+		cache.mu.RLock()
+		entry, ok := cache.memberships[userID]
+		cache.mu.RUnlock()
+		if ok && time.Now().Before(entry.expiresAt) {
+			cache.membershipHits.Add(1)
+			return entry.projects, nil
+		}
+		cache.membershipMisses.Add(1)
+	}
+
+	var members []model.ProjectMember
+	if err := db.Select("project_id", "role").Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	projects := make(map[uint]string, len(members))
+	for _, member := range members {
+		projects[member.ProjectID] = member.Role
+	}
+
+	if cache != nil {
+		cache.mu.Lock()
+		if cache.invalidations.Load() == version {
+			cache.memberships[userID] = membershipEntry{projects: projects, expiresAt: time.Now().Add(CacheTTL)}
+		}
+		cache.mu.Unlock()
+	}
+	return projects, nil
+}
+
+// GetProjectRolePermissions 获取项目角色权限映射（项目角色 -> 权限模式），启用缓存时优先从缓存读取
+// load 从数据库读取映射；返回的 map 可能与缓存共享，调用方不能修改
+func GetProjectRolePermissions(db *gorm.DB, load func(db *gorm.DB) map[string][]string) map[string][]string {
+	cache := getCache(db)
+	if cache == nil {
+		return load(db)
+	}
+
+	version := cache.invalidations.Load()
+	cache.mu.RLock()
+	entry := cache.projectRolePerms
+	cache.mu.RUnlock()
+	if entry != nil && time.Now().Before(entry.expiresAt) {
+		cache.projectRoleHits.Add(1)
+		return entry.rolePerms
+	}
+	cache.projectRoleMisses.Add(1)
+
+	rolePerms := load(db)
+	cache.mu.Lock()
+	if cache.invalidations.Load() == version {
+		cache.projectRolePerms = &projectRoleEntry{rolePerms: rolePerms, expiresAt: time.Now().Add(CacheTTL)}
+	}
+	cache.mu.Unlock()
+	return rolePerms
+}
+
+func newCache() *Cache {
+	return &Cache{
+		rolePerms:   map[string]rolePermEntry{},
+		memberships: map[uint]membershipEntry{},
+	}
+}
+
+func getCache(db *gorm.DB) *Cache {
+	if db == nil || db.Config == nil {
+		return nil
+	}
+	if value, ok := caches.Load(db.Callback()); ok {
+		return value.(*Cache)
+	}
+	return nil
+}
+
+// Invalidate 清空全部缓存
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	c.invalidations.Add(1)
+	c.rolePerms = map[string]rolePermEntry{}
+	c.memberships = map[uint]membershipEntry{}
+	c.projectRolePerms = nil
+	c.mu.Unlock()
+}
+
+func (c *Cache) invalidateRolePermissions() {
+	c.mu.Lock()
+	c.invalidations.Add(1)
+	c.rolePerms = map[string]rolePermEntry{}
+	c.mu.Unlock()
+}
+
+func (c *Cache) invalidateMemberships() {
+	c.mu.Lock()
+	c.invalidations.Add(1)
+	c.memberships = map[uint]membershipEntry{}
+	c.mu.Unlock()
+}
+
+func (c *Cache) invalidateProjectRolePermissions() {
+	c.mu.Lock()
+	c.invalidations.Add(1)
+	c.projectRolePerms = nil
+	c.mu.Unlock()
+}
+
+func (c *Cache) getRolePermissions(key string) ([]string, bool) {
+	c.mu.RLock()
+	entry, ok := c.rolePerms[key]
+	c.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		c.roleHits.Add(1)
+		return entry.perms, true
+	}
+	c.roleMisses.Add(1)
+	return nil, false
+}
+
+func (c *Cache) setRolePermissions(key string, perms []string, version uint64) {
+	c.mu.Lock()
+	if c.invalidations.Load() == version {
+		c.rolePerms[key] = rolePermEntry{perms: perms, expiresAt: time.Now().Add(CacheTTL)}
+	}
+	c.mu.Unlock()
+}
+
+// roleCacheKey 角色代码排序去重后作为缓存键
+func roleCacheKey(roleCodes []string) string {
+	codes := append([]string{}, roleCodes...)
+	sort.Strings(codes)
+	result := codes[:0]
+	for i, code := range codes {
+		if i == 0 || code != codes[i-1] {
+			result = append(result, code)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
@@ -13,8 +13,28 @@ func CheckPermission(roles []string, permCode string) bool {
 	return true
 }
 
-// GetRolePermissions 获取角色的所有权限
+// GetRolePermissions 获取角色的所有权限，启用缓存时优先从缓存读取
 func GetRolePermissions(db *gorm.DB, roleCodes []string) ([]string, error) {
+	cache := getCache(db)
+	if cache == nil {
+		return queryRolePermissions(db, roleCodes)
+	}
+
+	key := roleCacheKey(roleCodes)
+	if perms, ok := cache.getRolePermissions(key); ok {
+		return append([]string{}, perms...), nil
+	}
+	version := cache.invalidations.Load()
+	perms, err := queryRolePermissions(db, roleCodes)
+	if err != nil {
+		return nil, err
+	}
+	cache.setRolePermissions(key, perms, version)
+	return append([]string{}, perms...), nil
+}
+
+// queryRolePermissions 从数据库查询角色的所有权限
+func queryRolePermissions(db *gorm.DB, roleCodes []string) ([]string, error) {
 	var permissions []model.Permission
 
 	err := db.Table("permissions").
@@ -57,4 +77,3 @@ func CheckPermissionWithDB(db *gorm.DB, roleCodes []string, permCode string) (bo
 
 	return false, nil
 }
-
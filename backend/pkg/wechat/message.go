@@ -0,0 +1,126 @@
+package wechat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TemplateMessageData 模板消息的一个字段
+type TemplateMessageData struct {
+	Value string `json:"value"`
+	Color string `json:"color,omitempty"`
+}
+
+// stableAccessToken 公众号接口调用凭证（client_credential），按 AppID 缓存
+type stableAccessToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+var (
+	stableTokenMu    sync.Mutex
+	stableTokenCache = make(map[string]stableAccessToken)
+)
+
+// GetStableAccessToken 获取公众号接口调用凭证（与网页授权的 access_token 不同），有效期内复用
+func (c *WeChatClient) GetStableAccessToken() (string, error) {
+	if c.AppID == "" || c.AppSecret == "" {
+		return "", fmt.Errorf("微信AppID或AppSecret未配置")
+	}
+	stableTokenMu.Lock()
+	defer stableTokenMu.Unlock()
+	if cached, ok := stableTokenCache[c.AppID]; ok && time.Now().Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	endpoint := fmt.Sprintf(
+		"https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s",
+		url.QueryEscape(c.AppID),
+		url.QueryEscape(c.AppSecret),
+	)
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to get stable access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		ErrCode     int    `json:"errcode"`
+		ErrMsg      string `json:"errmsg"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if result.ErrCode != 0 || result.AccessToken == "" {
+		return "", fmt.Errorf("微信接口错误 (errcode: %d): %s", result.ErrCode, result.ErrMsg)
+	}
+
+	// 提前5分钟过期，避免临界时刻使用失效的凭证
+	expiresIn := time.Duration(result.ExpiresIn)*time.Second - 5*time.Minute
+	if expiresIn < time.Minute {
+		expiresIn = time.Minute
+	}
+	stableTokenCache[c.AppID] = stableAccessToken{token: result.AccessToken, expiresAt: time.Now().Add(expiresIn)}
+	return result.AccessToken, nil
+}
+
+// SendTemplateMessage 发送公众号模板消息（仅公众号可用，用户需关注公众号）
+// link 为点击消息后打开的页面，可为空
+func (c *WeChatClient) SendTemplateMessage(openID, templateID, link string, data map[string]TemplateMessageData) error {
+	accessToken, err := c.GetStableAccessToken()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"touser":      openID,
+		"template_id": templateID,
+		"url":         link,
+		"data":        data,
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := "https://api.weixin.qq.com/cgi-bin/message/template/send?access_token=" + url.QueryEscape(accessToken)
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send template message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	switch result.ErrCode {
+	case 0:
+		return nil
+	case 40001, 42001:
+		// 凭证失效，清除缓存以便下次重新获取
+		stableTokenMu.Lock()
+		delete(stableTokenCache, c.AppID)
+		stableTokenMu.Unlock()
+	case 43004:
+		return fmt.Errorf("用户未关注公众号，无法接收模板消息: %s", result.ErrMsg)
+	}
+	return fmt.Errorf("微信接口错误 (errcode: %d): %s", result.ErrCode, result.ErrMsg)
+}
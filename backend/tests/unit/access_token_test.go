@@ -0,0 +1,225 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"prjflow/internal/api"
+	"prjflow/internal/middleware"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+// setupAccessTokenRouter 注册访问令牌管理接口和几个需要权限的测试接口
+func setupAccessTokenRouter(db *gorm.DB) *gin.Engine {
+	r := setupSessionRouter(db)
+	tokenHandler := api.NewAccessTokenHandler(db)
+	userHandler := api.NewUserHandler(db)
+	webhookHandler := api.NewWebhookHandler(db)
+	r.GET("/api/auth/tokens", middleware.Auth(), tokenHandler.GetMyTokens)
+	r.POST("/api/auth/tokens", middleware.Auth(), tokenHandler.CreateMyToken)
+	r.DELETE("/api/auth/tokens/:id", middleware.Auth(), tokenHandler.RevokeMyToken)
+	r.POST("/api/users", middleware.Auth(), userHandler.CreateUser)
+	r.POST("/api/users/:id/tokens", middleware.Auth(), tokenHandler.CreateUserToken)
+	r.POST("/api/projects/:id/webhooks", middleware.Auth(), middleware.RequirePermission(db, "project:read"), webhookHandler.CreateProjectWebhook)
+
+	ok := func(c *gin.Context) { utils.Success(c, gin.H{"user_id": utils.GetUserID(c)}) }
+	r.POST("/api/bugs", middleware.Auth(), middleware.RequirePermission(db, "bug:create"), ok)
+	r.PUT("/api/tasks/1", middleware.Auth(), middleware.RequirePermission(db, "task:update"), ok)
+	return r
+}
+
+// createRoleWithPermissions 创建拥有指定权限的角色
+func createRoleWithPermissions(t *testing.T, db *gorm.DB, code string, permCodes ...string) *model.Role {
+	role := model.Role{Name: code, Code: code, Status: 1}
+	require.NoError(t, db.Create(&role).Error)
+	for _, permCode := range permCodes {
+		var perm model.Permission
+		require.NoError(t, db.Where(model.Permission{Code: permCode}).Attrs(model.Permission{Name: permCode}).FirstOrCreate(&perm).Error)
+		require.NoError(t, db.Model(&role).Association("Permissions").Append(&perm))
+	}
+	return &role
+}
+
+func createAccessToken(t *testing.T, r *gin.Engine, path, token string, scopes ...string) map[string]interface{} {
+	resp := doSessionRequest(t, r, http.MethodPost, path, token, map[string]interface{}{
+		"name":   "ci",
+		"scopes": scopes,
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	return resp["data"].(map[string]interface{})
+}
+
+func TestAccessToken_ScopesAndLifecycle(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	require.NoError(t, utils.MigrateAuditDB(db, nil))
+	r := setupAccessTokenRouter(db)
+
+	user := createSessionTestUser(t, db, "patuser", "PatUser123")
+	role := createRoleWithPermissions(t, db, "pat_developer", "bug:create", "task:update")
+	require.NoError(t, db.Model(user).Association("Roles").Append(role))
+	jwtToken, _ := sessionLogin(t, r, "patuser", "PatUser123")
+
+	// 权限范围必须存在且用户拥有
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/tokens", jwtToken, map[string]interface{}{"name": "bad", "scopes": []string{"no:such"}})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/tokens", jwtToken, map[string]interface{}{"name": "bad", "scopes": []string{"user:delete"}})
+	assert.Equal(t, float64(400), resp["code"])
+
+	created := createAccessToken(t, r, "/api/auth/tokens", jwtToken, "bug:create")
+	pat := created["token"].(string)
+	assert.True(t, utils.IsPersonalAccessToken(pat))
+	assert.Equal(t, []interface{}{"bug:create"}, created["scopes"])
+
+	// 只保存哈希
+	var stored model.PersonalAccessToken
+	require.NoError(t, db.First(&stored, uint(created["id"].(float64))).Error)
+	assert.NotEqual(t, pat, stored.TokenHash)
+	assert.Equal(t, utils.HashAccessToken(pat), stored.TokenHash)
+
+	// 令牌代替JWT访问接口，只能使用权限范围内的权限
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(user.ID), resp["data"].(map[string]interface{})["user_id"])
+	resp = doSessionRequest(t, r, http.MethodPut, "/api/tasks/1", pat, nil)
+	assert.Equal(t, float64(403), resp["code"])
+	resp = doSessionRequest(t, r, http.MethodPut, "/api/tasks/1", jwtToken, nil)
+	assert.Equal(t, float64(200), resp["code"])
+
+	// 记录最后使用时间；每次使用（包括因权限不足被拒绝的请求）都写入审计日志
+	require.NoError(t, db.First(&stored, stored.ID).Error)
+	require.NotNil(t, stored.LastUsedAt)
+	assert.Equal(t, "192.0.2.1", stored.LastUsedIP)
+	var auditCount int64
+	db.Model(&model.AuditLog{}).Where("action_type = ? AND resource_id = ?", "token_access", stored.ID).Count(&auditCount)
+	assert.Equal(t, int64(2), auditCount)
+
+	// 不能用令牌创建令牌
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/tokens", pat, map[string]interface{}{"name": "x", "scopes": []string{"bug:create"}})
+	assert.Equal(t, float64(403), resp["code"])
+
+	// 移除角色后令牌失去对应权限
+	require.NoError(t, db.Model(user).Association("Roles").Clear())
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	assert.Equal(t, float64(403), resp["code"])
+	require.NoError(t, db.Model(user).Association("Roles").Append(role))
+
+	// 过期
+	past := time.Now().Add(-time.Minute)
+	db.Model(&stored).Update("expires_at", past)
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	assert.Equal(t, float64(401), resp["code"])
+	assert.Equal(t, utils.ErrAccessTokenExpired.Error(), resp["message"])
+	db.Model(&stored).Update("expires_at", time.Now().Add(time.Hour))
+
+	// 吊销
+	resp = doSessionRequest(t, r, http.MethodDelete, fmt.Sprintf("/api/auth/tokens/%d", stored.ID), jwtToken, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	assert.Equal(t, float64(401), resp["code"])
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/tokens", jwtToken, nil)
+	require.Equal(t, float64(200), resp["code"])
+	assert.Len(t, resp["data"], 0)
+
+	// 无效令牌
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", utils.AccessTokenPrefix+"invalid", nil)
+	assert.Equal(t, float64(401), resp["code"])
+}
+
+func TestAccessToken_AdminTokenIsScoped(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupAccessTokenRouter(db)
+
+	admin := createSessionTestUser(t, db, "patadmin", "PatAdmin123")
+	require.NoError(t, db.Model(admin).Association("Roles").Append(CreateTestAdminRole(t, db)))
+	adminToken, _ := sessionLogin(t, r, "patadmin", "PatAdmin123")
+
+	pat := createAccessToken(t, r, "/api/auth/tokens", adminToken, "bug:create")["token"].(string)
+
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	assert.Equal(t, float64(200), resp["code"])
+	// 管理员的令牌同样受权限范围限制
+	resp = doSessionRequest(t, r, http.MethodPut, "/api/tasks/1", pat, nil)
+	assert.Equal(t, float64(403), resp["code"])
+
+	// 令牌不带管理员角色，不能借助管理员身份绕过权限范围
+	allowPrivateWebhookTargets(t, true)
+	project := CreateTestProject(t, db, "令牌项目")
+	webhookPath := fmt.Sprintf("/api/projects/%d/webhooks", project.ID)
+	webhook := map[string]interface{}{"name": "hook", "url": "http://127.0.0.1/hook", "events": []string{"bug.created"}}
+	readToken := createAccessToken(t, r, "/api/auth/tokens", adminToken, "project:read")["token"].(string)
+	resp = doSessionRequest(t, r, http.MethodPost, webhookPath, readToken, webhook)
+	assert.Equal(t, float64(403), resp["code"])
+	resp = doSessionRequest(t, r, http.MethodPost, webhookPath, adminToken, webhook)
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+}
+
+func TestAccessToken_ServiceAccount(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupAccessTokenRouter(db)
+
+	admin := createSessionTestUser(t, db, "svcadmin", "SvcAdmin123")
+	require.NoError(t, db.Model(admin).Association("Roles").Append(CreateTestAdminRole(t, db)))
+	regular := createSessionTestUser(t, db, "regular", "Regular123")
+	adminToken, _ := sessionLogin(t, r, "svcadmin", "SvcAdmin123")
+
+	// 服务账号不能设置密码
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/users", adminToken, map[string]interface{}{
+		"username": "ci-bot", "nickname": "CI", "password": "CiBot12345", "is_service_account": true,
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/users", adminToken, map[string]interface{}{
+		"username": "ci-bot", "nickname": "CI", "is_service_account": true,
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	botID := uint(resp["data"].(map[string]interface{})["id"].(float64))
+	var bot model.User
+	require.NoError(t, db.First(&bot, botID).Error)
+	assert.True(t, bot.IsServiceAccount)
+	require.NoError(t, db.Model(&bot).Association("Roles").Append(createRoleWithPermissions(t, db, "pat_ci", "bug:create")))
+
+	// 只能为服务账号代建令牌
+	resp = doSessionRequest(t, r, http.MethodPost, fmt.Sprintf("/api/users/%d/tokens", regular.ID), adminToken, map[string]interface{}{
+		"name": "ci", "scopes": []string{"bug:create"},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 权限范围受服务账号自身权限限制
+	resp = doSessionRequest(t, r, http.MethodPost, fmt.Sprintf("/api/users/%d/tokens", botID), adminToken, map[string]interface{}{
+		"name": "ci", "scopes": []string{"task:update"},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	created := createAccessToken(t, r, fmt.Sprintf("/api/users/%d/tokens", botID), adminToken, "bug:create")
+	assert.Equal(t, float64(admin.ID), created["created_by"])
+	pat := created["token"].(string)
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(botID), resp["data"].(map[string]interface{})["user_id"])
+
+	// 服务账号不能交互式登录，即使设置了密码
+	hashed, err := utils.HashPassword("CiBot12345")
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&bot).Update("password", hashed).Error)
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "ci-bot", "password": "CiBot12345"})
+	assert.Equal(t, float64(403), resp["code"])
+	_, err = utils.CreateSession(db, &bot, nil, "wechat", nil)
+	assert.ErrorIs(t, err, utils.ErrServiceAccountLogin)
+
+	// 禁用服务账号后令牌失效
+	require.NoError(t, db.Model(&bot).Update("status", 0).Error)
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/bugs", pat, nil)
+	assert.Equal(t, float64(401), resp["code"])
+}
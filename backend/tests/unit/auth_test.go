@@ -435,9 +435,10 @@ func TestAuthHandler_RefreshToken(t *testing.T) {
 	handler := api.NewAuthHandler(db)
 
 	t.Run("刷新Token成功", func(t *testing.T) {
-		// 先生成一个refresh token
-		refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username, []string{"admin"})
+		// 先登录创建会话，获得属于该会话的refresh token
+		tokens, err := utils.CreateSession(db, user, []string{"admin"}, "password", nil)
 		require.NoError(t, err)
+		refreshToken := tokens.RefreshToken
 
 		gin.SetMode(gin.TestMode)
 		w := httptest.NewRecorder()
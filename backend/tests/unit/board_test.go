@@ -389,7 +389,7 @@ func TestBoardHandler_MoveTask(t *testing.T) {
 	column1 := &model.BoardColumn{
 		Name:   "待办",
 		BoardID: board.ID,
-		Status: "todo",
+		Status: "wait",
 		Sort:   1,
 	}
 	db.Create(&column1)
@@ -397,7 +397,7 @@ func TestBoardHandler_MoveTask(t *testing.T) {
 	column2 := &model.BoardColumn{
 		Name:   "进行中",
 		BoardID: board.ID,
-		Status: "in_progress",
+		Status: "doing",
 		Sort:   2,
 	}
 	db.Create(&column2)
@@ -406,7 +406,7 @@ func TestBoardHandler_MoveTask(t *testing.T) {
 		Title:     "移动任务",
 		ProjectID: project.ID,
 		CreatorID: user.ID,
-		Status:    "todo",
+		Status:    "wait",
 	}
 	db.Create(&task)
 
@@ -437,7 +437,7 @@ func TestBoardHandler_MoveTask(t *testing.T) {
 		var updatedTask model.Task
 		err := db.First(&updatedTask, task.ID).Error
 		assert.NoError(t, err)
-		assert.Equal(t, "in_progress", updatedTask.Status)
+		assert.Equal(t, "doing", updatedTask.Status)
 	})
 }
 
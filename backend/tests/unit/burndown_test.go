@@ -0,0 +1,145 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func burnDate(value string, hour int) time.Time {
+	day, _ := time.ParseInLocation("2006-01-02", value, time.Local)
+	return day.Add(time.Duration(hour) * time.Hour)
+}
+
+// recordBurnHistory 写入指定时间的字段变更，模拟历史操作
+func recordBurnHistory(t *testing.T, db *gorm.DB, objectType string, objectID uint, at time.Time, field, old, new string) {
+	action := model.Action{ObjectType: objectType, ObjectID: objectID, Action: "edited", Date: at}
+	require.NoError(t, db.Create(&action).Error)
+	require.NoError(t, db.Create(&model.History{ActionID: action.ID, Field: field, Old: old, New: new}).Error)
+}
+
+func burnPointByDate(t *testing.T, points []interface{}, date string) map[string]interface{} {
+	for _, point := range points {
+		p := point.(map[string]interface{})
+		if p["date"] == date {
+			return p
+		}
+	}
+	t.Fatalf("燃尽图缺少日期 %s", date)
+	return nil
+}
+
+func TestBurndown_SprintReconstructsScopeFromHistory(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "burnadmin", "燃尽管理员")
+	project := CreateTestProject(t, db, "燃尽项目")
+	sprint := &model.Sprint{Name: "迭代1", ProjectID: project.ID, Status: utils.SprintStatusClosed, CreatorID: admin.ID,
+		StartDate: burnDate("2026-03-02", 0), EndDate: burnDate("2026-03-13", 0)}
+	require.NoError(t, db.Create(sprint).Error)
+	sprintID := strconv.FormatUint(uint64(sprint.ID), 10)
+	created := burnDate("2026-02-27", 9)
+
+	estimateA, estimateB, estimateC := 10.0, 6.0, 4.0
+	taskA := &model.Task{Title: "任务A", ProjectID: project.ID, CreatorID: admin.ID, Status: "done", EstimatedHours: &estimateA, SprintID: &sprint.ID, CreatedAt: created}
+	taskB := &model.Task{Title: "任务B", ProjectID: project.ID, CreatorID: admin.ID, Status: "doing", EstimatedHours: &estimateB, SprintID: &sprint.ID, CreatedAt: created}
+	bugC := &model.Bug{Title: "BugC", ProjectID: project.ID, CreatorID: admin.ID, Status: "active", EstimatedHours: &estimateC, CreatedAt: created}
+	otherTask := &model.Task{Title: "待办任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "wait", EstimatedHours: &estimateA, CreatedAt: created}
+	for _, item := range []interface{}{taskA, taskB, bugC, otherTask} {
+		require.NoError(t, db.Create(item).Error)
+	}
+
+	// 任务B在3月4日加入，任务A在3月5日调整预估并在3月10日完成，BugC在3月6日移出
+	recordBurnHistory(t, db, "task", taskB.ID, burnDate("2026-03-04", 10), "sprint_id", "", sprintID)
+	recordBurnHistory(t, db, "task", taskA.ID, burnDate("2026-03-05", 10), "estimated_hours", "8.00", "10.00")
+	recordBurnHistory(t, db, "task", taskA.ID, burnDate("2026-03-10", 16), "status", "doing", "done")
+	recordBurnHistory(t, db, "bug", bugC.ID, burnDate("2026-02-28", 10), "sprint_id", "", sprintID)
+	recordBurnHistory(t, db, "bug", bugC.ID, burnDate("2026-03-06", 10), "sprint_id", sprintID, "")
+	require.NoError(t, db.Create(&model.ResourceAllocation{ResourceID: 1, TaskID: &taskB.ID, Date: burnDate("2026-03-05", 0), Hours: 2}).Error)
+
+	handler := api.NewSprintHandler(db)
+	resp := callJSONHandler(t, handler.GetSprintBurndown, admin.ID, []string{"admin"}, http.MethodGet, "/api/sprints/burndown", idParams(sprint.ID), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(9), data["working_days"])
+	points := data["points"].([]interface{})
+	require.Len(t, points, 12)
+
+	expected := map[string][3]float64{ // 范围、剩余、已完成
+		"2026-03-02": {12, 12, 0},
+		"2026-03-04": {18, 18, 0},
+		"2026-03-05": {20, 18, 2},
+		"2026-03-06": {16, 14, 2},
+		"2026-03-10": {16, 4, 12},
+		"2026-03-13": {16, 4, 12},
+	}
+	for date, values := range expected {
+		point := burnPointByDate(t, points, date)
+		assert.Equal(t, values[0], point["scope"], date)
+		assert.Equal(t, values[1], point["remaining"], date)
+		assert.Equal(t, values[2], point["completed"], date)
+	}
+
+	// 理想线从12开始，只在工作日下降，周末保持不变
+	assert.Equal(t, float64(12), burnPointByDate(t, points, "2026-03-02")["ideal"])
+	weekend := burnPointByDate(t, points, "2026-03-07")
+	assert.Equal(t, false, weekend["working_day"])
+	assert.Equal(t, 6.67, weekend["ideal"])
+	assert.Equal(t, 6.67, burnPointByDate(t, points, "2026-03-08")["ideal"])
+	assert.Equal(t, float64(0), burnPointByDate(t, points, "2026-03-13")["ideal"])
+
+	changes := data["scope_changes"].([]interface{})
+	require.Len(t, changes, 3)
+	assert.Equal(t, utils.BurnChangeAdded, changes[0].(map[string]interface{})["change"])
+	assert.Equal(t, float64(6), changes[0].(map[string]interface{})["hours"])
+	assert.Equal(t, utils.BurnChangeEstimate, changes[1].(map[string]interface{})["change"])
+	assert.Equal(t, float64(2), changes[1].(map[string]interface{})["hours"])
+	assert.Equal(t, utils.BurnChangeRemoved, changes[2].(map[string]interface{})["change"])
+	assert.Equal(t, "2026-03-06", changes[2].(map[string]interface{})["date"])
+
+	// 追加节假日后工作日减少
+	resp = callJSONHandler(t, handler.GetSprintBurndown, admin.ID, []string{"admin"}, http.MethodGet, "/api/sprints/burndown?holidays=2026-03-09", idParams(sprint.ID), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(8), resp["data"].(map[string]interface{})["working_days"])
+
+	// 非管理员：项目成员得到同样的燃尽数据，非项目成员不能查看
+	member := CreateTestUser(t, db, "burnmember", "成员")
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleViewer)
+	outsider := CreateTestUser(t, db, "burnoutsider", "外部用户")
+	resp = callJSONHandler(t, handler.GetSprintBurndown, member.ID, []string{"developer"}, http.MethodGet, "/api/sprints/burndown", idParams(sprint.ID), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(4), burnPointByDate(t, resp["data"].(map[string]interface{})["points"].([]interface{}), "2026-03-13")["remaining"])
+	resp = callJSONHandler(t, handler.GetSprintBurndown, outsider.ID, []string{"developer"}, http.MethodGet, "/api/sprints/burndown", idParams(sprint.ID), nil)
+	assert.Equal(t, float64(403), resp["code"])
+}
+
+func TestBurndown_WorkCalendarAndFuturePoints(t *testing.T) {
+	calendar := utils.NewWorkCalendar([]int{0, 6}, []string{"2026-10-01"}, []string{"2026-10-11"})
+	assert.False(t, calendar.IsWorkingDay(burnDate("2026-10-01", 0))) // 节假日
+	assert.True(t, calendar.IsWorkingDay(burnDate("2026-10-11", 0)))  // 周日调休上班
+	assert.False(t, calendar.IsWorkingDay(burnDate("2026-10-10", 0))) // 周六
+	assert.Equal(t, 6, calendar.WorkingDaysBetween(burnDate("2026-10-04", 0), burnDate("2026-10-11", 0)))
+
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	project := CreateTestProject(t, db, "未来项目")
+	start := time.Now().AddDate(0, 0, -1)
+	chart, err := utils.BuildBurnChart(db, utils.BurnChartScope{Type: utils.BurnScopeProject, ID: project.ID, StartDate: start, EndDate: start.AddDate(0, 0, 5)}, calendar)
+	require.NoError(t, err)
+	require.Len(t, chart.Points, 6)
+	assert.NotNil(t, chart.Points[0].Remaining)
+	assert.Nil(t, chart.Points[5].Remaining)
+
+	_, err = utils.BuildBurnChart(db, utils.BurnChartScope{Type: utils.BurnScopeProject, ID: project.ID, StartDate: start, EndDate: start.AddDate(0, 0, -2)}, calendar)
+	assert.ErrorIs(t, err, utils.ErrBurnChartRange)
+}
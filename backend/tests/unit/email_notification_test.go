@@ -0,0 +1,407 @@
+package unit
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+// fakeSMTPServer 本地SMTP替身，记录收到的邮件，可配置前若干次投递失败
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []string
+	failNext int
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeSMTPServer{listener: listener}
+	go server.serve()
+	return server
+}
+
+func (s *fakeSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeSMTPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) FailNext(n int) {
+	s.mu.Lock()
+	s.failNext = n
+	s.mu.Unlock()
+}
+
+func (s *fakeSMTPServer) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 localhost fake smtp")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			s.mu.Lock()
+			fail := s.failNext > 0
+			if fail {
+				s.failNext--
+			}
+			s.mu.Unlock()
+			if fail {
+				reply("451 temporary failure")
+				continue
+			}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// parseTestEmail 解析邮件，返回主题和纯文本、HTML正文
+func parseTestEmail(t *testing.T, raw string) (string, string, string) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var text, html string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		var partReader io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			partReader = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		body, err := io.ReadAll(partReader)
+		require.NoError(t, err)
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			html = string(body)
+		} else {
+			text = string(body)
+		}
+	}
+	return subject, text, html
+}
+
+// useTestEmailConfig 将邮件配置指向本地SMTP替身，返回恢复函数
+func useTestEmailConfig(server *fakeSMTPServer) func() {
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	old := config.AppConfig.Email
+	config.AppConfig.Email = config.EmailConfig{
+		Enabled:     true,
+		Host:        "127.0.0.1",
+		Port:        server.Port(),
+		From:        "noreply@prjflow.test",
+		FromName:    "PrjFlow",
+		SiteURL:     "https://pm.example.com",
+		Timeout:     5,
+		MaxAttempts: 3,
+	}
+	return func() { config.AppConfig.Email = old }
+}
+
+func TestEmailTemplates(t *testing.T) {
+	cases := map[string]string{
+		utils.NotificationBugAssigned:             "指派给了你",
+		utils.NotificationTaskStatusChanged:       "更新了你关注的工作状态",
+		utils.NotificationReportApprovalRequested: "报告审批通知",
+		utils.NotificationTaskDueSoon:             "即将到期",
+	}
+	for eventType, intro := range cases {
+		subject, html, text, err := utils.RenderNotificationEmail(eventType, utils.EmailTemplateData{
+			RecipientName: "张三",
+			ActorName:     "李四",
+			Title:         "登录页<script>崩溃",
+			Content:       "请处理",
+			Link:          "https://pm.example.com/bug/1",
+		})
+		require.NoError(t, err, eventType)
+		assert.Equal(t, "[PrjFlow] 登录页<script>崩溃", subject)
+		assert.Contains(t, text, intro, eventType)
+		assert.Contains(t, text, "登录页<script>崩溃")
+		assert.Contains(t, text, "https://pm.example.com/bug/1")
+		assert.Contains(t, html, intro, eventType)
+		assert.Contains(t, html, "登录页&lt;script&gt;崩溃")
+		assert.Contains(t, html, `href="https://pm.example.com/bug/1"`)
+	}
+
+	assert.Equal(t, 60*time.Second, utils.EmailBackoff(1))
+	assert.Equal(t, 4*time.Minute, utils.EmailBackoff(3))
+	assert.Equal(t, time.Hour, utils.EmailBackoff(10))
+}
+
+func TestEmailNotification(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+	defer useTestEmailConfig(server)()
+
+	admin := CreateTestAdminUser(t, db, "emailadmin", "管理员")
+	user := CreateTestUser(t, db, "emailuser", "邮件用户")
+	require.NoError(t, db.Model(user).Update("email", "emailuser@example.com").Error)
+	noEmail := CreateTestUser(t, db, "noemailuser", "无邮箱用户")
+	require.NoError(t, db.Model(noEmail).Update("email", "").Error)
+
+	t.Run("立即发送", func(t *testing.T) {
+		_, err := utils.Notify(db, []uint{user.ID, noEmail.ID}, utils.NotificationInput{
+			Type:       utils.NotificationBugAssigned,
+			Title:      "Bug已指派给你：登录失败",
+			Content:    "请优先处理",
+			ObjectType: "bug",
+			ObjectID:   42,
+			ActorID:    admin.ID,
+		})
+		require.NoError(t, err)
+
+		var messages []model.EmailMessage
+		db.Find(&messages)
+		require.Len(t, messages, 1)
+		assert.Equal(t, utils.EmailStatusPending, messages[0].Status)
+		assert.Equal(t, "https://pm.example.com/bug/42", messages[0].Link)
+
+		assert.Equal(t, 1, utils.ProcessEmailQueue(db))
+		received := server.Messages()
+		require.Len(t, received, 1)
+		subject, text, html := parseTestEmail(t, received[0])
+		assert.Equal(t, "[PrjFlow] Bug已指派给你：登录失败", subject)
+		assert.Contains(t, text, "请优先处理")
+		assert.Contains(t, text, "管理员 将以下工作指派给了你")
+		assert.Contains(t, html, "https://pm.example.com/bug/42")
+
+		var sent model.EmailMessage
+		db.First(&sent, messages[0].ID)
+		assert.Equal(t, utils.EmailStatusSent, sent.Status)
+		assert.Equal(t, 1, sent.Attempts)
+		assert.NotNil(t, sent.SentAt)
+	})
+
+	t.Run("发送失败后重试", func(t *testing.T) {
+		server.FailNext(1)
+		message := &model.EmailMessage{UserID: user.ID, To: "emailuser@example.com", EventType: "test", Subject: "重试测试", HTMLBody: "<p>retry</p>", TextBody: "retry"}
+		require.NoError(t, utils.EnqueueEmail(db, message, utils.EmailModeImmediate))
+
+		assert.Equal(t, 1, utils.ProcessEmailQueue(db))
+		db.First(message, message.ID)
+		assert.Equal(t, utils.EmailStatusPending, message.Status)
+		assert.Equal(t, 1, message.Attempts)
+		assert.Contains(t, message.Error, "451")
+		require.NotNil(t, message.NextAttemptAt)
+		assert.True(t, message.NextAttemptAt.After(time.Now().Add(50*time.Second)))
+
+		// 未到重试时间不会再次发送
+		assert.Equal(t, 0, utils.ProcessEmailQueue(db))
+
+		db.Model(message).Update("next_attempt_at", time.Now().Add(-time.Second))
+		assert.Equal(t, 1, utils.ProcessEmailQueue(db))
+		db.First(message, message.ID)
+		assert.Equal(t, utils.EmailStatusSent, message.Status)
+		assert.Equal(t, 2, message.Attempts)
+	})
+
+	t.Run("超过最大次数标记失败", func(t *testing.T) {
+		server.FailNext(3)
+		message := &model.EmailMessage{UserID: user.ID, To: "emailuser@example.com", EventType: "test", Subject: "失败测试", TextBody: "fail"}
+		require.NoError(t, utils.EnqueueEmail(db, message, utils.EmailModeImmediate))
+		for i := 0; i < 3; i++ {
+			db.Model(message).Update("next_attempt_at", time.Now().Add(-time.Second))
+			utils.ProcessEmailQueue(db)
+		}
+		var failed model.EmailMessage
+		db.First(&failed, message.ID)
+		assert.Equal(t, utils.EmailStatusFailed, failed.Status)
+		assert.Equal(t, 3, failed.Attempts)
+		assert.Nil(t, failed.NextAttemptAt)
+	})
+
+	t.Run("每日摘要", func(t *testing.T) {
+		require.NoError(t, db.Create(&model.NotificationPreference{UserID: user.ID, EventType: utils.NotificationTaskAssigned, Channel: utils.NotificationChannelEmail, Enabled: true, Mode: utils.EmailModeDigest}).Error)
+		require.NoError(t, db.Create(&model.NotificationPreference{UserID: user.ID, EventType: utils.NotificationTaskStatusChanged, Channel: utils.NotificationChannelEmail, Enabled: true, Mode: utils.EmailModeDigest}).Error)
+		before := len(server.Messages())
+
+		for _, input := range []utils.NotificationInput{
+			{Type: utils.NotificationTaskAssigned, Title: "任务已指派给你：编写文档", ObjectType: "task", ObjectID: 7, ActorID: admin.ID},
+			{Type: utils.NotificationTaskStatusChanged, Title: "任务状态变更为「进行中」：编写文档", Content: "开始处理", ObjectType: "task", ObjectID: 7, ActorID: admin.ID},
+		} {
+			_, err := utils.Notify(db, []uint{user.ID}, input)
+			require.NoError(t, err)
+		}
+
+		// 摘要邮件不会立即发送
+		assert.Equal(t, 0, utils.ProcessEmailQueue(db))
+		var digestCount int64
+		db.Model(&model.EmailMessage{}).Where("status = ?", utils.EmailStatusDigest).Count(&digestCount)
+		assert.Equal(t, int64(2), digestCount)
+
+		assert.Equal(t, 1, utils.SendEmailDigests(db, time.Now()))
+		assert.Equal(t, 1, utils.ProcessEmailQueue(db))
+
+		received := server.Messages()
+		require.Len(t, received, before+1)
+		subject, text, _ := parseTestEmail(t, received[len(received)-1])
+		assert.Contains(t, subject, "通知摘要")
+		assert.Contains(t, text, "共 2 条")
+		assert.Contains(t, text, "任务已指派给你：编写文档")
+		assert.Contains(t, text, "开始处理")
+
+		db.Model(&model.EmailMessage{}).Where("status = ?", utils.EmailStatusDigested).Count(&digestCount)
+		assert.Equal(t, int64(2), digestCount)
+		assert.Equal(t, 0, utils.SendEmailDigests(db, time.Now()))
+	})
+
+	t.Run("关闭邮件渠道", func(t *testing.T) {
+		require.NoError(t, db.Create(&model.NotificationPreference{UserID: user.ID, EventType: utils.NotificationRequirementAssigned, Channel: utils.NotificationChannelEmail, Enabled: false}).Error)
+		var before int64
+		db.Model(&model.EmailMessage{}).Count(&before)
+
+		notifications, err := utils.Notify(db, []uint{user.ID}, utils.NotificationInput{Type: utils.NotificationRequirementAssigned, Title: "需求已指派给你", ActorID: admin.ID})
+		require.NoError(t, err)
+		// 站内通知不受影响
+		assert.Len(t, notifications, 1)
+
+		var after int64
+		db.Model(&model.EmailMessage{}).Count(&after)
+		assert.Equal(t, before, after)
+	})
+}
+
+func TestNotification_StatusChangeAndDueDate(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "状态通知项目")
+	creator := CreateTestUser(t, db, "statuscreator", "创建人")
+	assignee := CreateTestUser(t, db, "statusassignee", "指派人")
+
+	t.Run("状态变更通知创建人和指派人", func(t *testing.T) {
+		bug := &model.Bug{Title: "状态Bug", ProjectID: project.ID, CreatorID: creator.ID, Status: "resolved", Priority: "high", Severity: "normal"}
+		require.NoError(t, db.Create(bug).Error)
+		require.NoError(t, db.Create(&model.BugAssignee{BugID: bug.ID, UserID: assignee.ID}).Error)
+
+		actionID, err := utils.RecordAction(db, "bug", bug.ID, "resolved", assignee.ID, "已修复", nil)
+		require.NoError(t, err)
+		var action model.Action
+		db.First(&action, actionID)
+
+		recipients, input, ok := utils.BuildStatusChangeNotification(db, &action)
+		require.True(t, ok)
+		assert.ElementsMatch(t, []uint{creator.ID, assignee.ID}, recipients)
+		assert.Equal(t, utils.NotificationBugStatusChanged, input.Type)
+		assert.Equal(t, "Bug状态变更为「已解决」：状态Bug", input.Title)
+
+		notifications, err := utils.Notify(db, recipients, input)
+		require.NoError(t, err)
+		// 操作人本人不会收到通知
+		require.Len(t, notifications, 1)
+		assert.Equal(t, creator.ID, notifications[0].UserID)
+
+		commented, err := utils.RecordAction(db, "bug", bug.ID, "commented", assignee.ID, "评论", nil)
+		require.NoError(t, err)
+		var commentAction model.Action
+		db.First(&commentAction, commented)
+		_, _, ok = utils.BuildStatusChangeNotification(db, &commentAction)
+		assert.False(t, ok)
+	})
+
+	t.Run("任务到期提醒", func(t *testing.T) {
+		now := time.Date(2024, 3, 10, 8, 30, 0, 0, time.Local)
+		tomorrow := now.AddDate(0, 0, 1)
+		nextWeek := now.AddDate(0, 0, 7)
+		dueTask := &model.Task{Title: "明天到期", ProjectID: project.ID, CreatorID: creator.ID, AssigneeID: &assignee.ID, Status: "doing", Priority: "medium", DueDate: &tomorrow}
+		laterTask := &model.Task{Title: "下周到期", ProjectID: project.ID, CreatorID: creator.ID, AssigneeID: &assignee.ID, Status: "doing", Priority: "medium", DueDate: &nextWeek}
+		doneTask := &model.Task{Title: "已完成", ProjectID: project.ID, CreatorID: creator.ID, AssigneeID: &assignee.ID, Status: "done", Priority: "medium", DueDate: &tomorrow}
+		for _, task := range []*model.Task{dueTask, laterTask, doneTask} {
+			require.NoError(t, db.Create(task).Error)
+		}
+
+		assert.Equal(t, 1, utils.NotifyDueTasks(db, now))
+		var notification model.Notification
+		require.NoError(t, db.Where("user_id = ? AND type = ?", assignee.ID, utils.NotificationTaskDueSoon).First(&notification).Error)
+		assert.Equal(t, dueTask.ID, notification.ObjectID)
+		assert.Equal(t, "任务明天到期：明天到期", notification.Title)
+
+		// 同一天只执行一次
+		utils.RunDailyNotifications(db, now)
+		utils.RunDailyNotifications(db, now)
+		var count int64
+		db.Model(&model.Notification{}).Where("type = ?", utils.NotificationTaskDueSoon).Count(&count)
+		assert.Equal(t, int64(2), count)
+	})
+}
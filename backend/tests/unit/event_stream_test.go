@@ -0,0 +1,221 @@
+package unit
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/internal/websocket"
+	"prjflow/pkg/auth"
+)
+
+// dialEventStream 连接事件流
+func dialEventStream(t *testing.T, server *httptest.Server, token, topics string) *gorillaws.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/events?token=" + token
+	if topics != "" {
+		url += "&topics=" + topics
+	}
+	conn, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	return conn
+}
+
+// readEventMessage 读取一条事件流消息
+func readEventMessage(t *testing.T, conn *gorillaws.Conn) websocket.EventMessage {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg websocket.EventMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestEventStream(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	if config.AppConfig.JWT.Secret == "" {
+		config.AppConfig.JWT.Secret = "test-secret-key-for-unit-testing"
+	}
+	if config.AppConfig.JWT.Expiration == 0 {
+		config.AppConfig.JWT.Expiration = 24
+	}
+
+	project := CreateTestProject(t, db, "事件流项目")
+	otherProject := CreateTestProject(t, db, "无权限项目")
+	member := CreateTestUser(t, db, "eventmember", "项目成员")
+	outsider := CreateTestUser(t, db, "eventoutsider", "非项目成员")
+	AddUserToProject(t, db, member.ID, project.ID, "member")
+
+	memberSession, err := utils.CreateSession(db, member, []string{"developer"}, "password", nil)
+	require.NoError(t, err)
+	outsiderSession, err := utils.CreateSession(db, outsider, []string{"developer"}, "password", nil)
+	require.NoError(t, err)
+	memberToken, outsiderToken := memberSession.Token, outsiderSession.Token
+
+	websocket.RegisterActionEvents()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws/events", websocket.HandleEventStream(db))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	t.Run("无效Token不能连接", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/events?token=invalid"
+		_, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("不属于登录会话的Token不能连接", func(t *testing.T) {
+		legacyToken, err := auth.GenerateToken(member.ID, member.Username, []string{"developer"})
+		require.NoError(t, err)
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/events?token=" + legacyToken
+		_, _, err = gorillaws.DefaultDialer.Dial(url, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("无权限项目不能订阅", func(t *testing.T) {
+		conn := dialEventStream(t, server, outsiderToken, "")
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteJSON(map[string]string{"action": "subscribe", "topic": fmt.Sprintf("project:%d", project.ID)}))
+		msg := readEventMessage(t, conn)
+		assert.Equal(t, "error", msg.Type)
+	})
+
+	t.Run("多标签页都能收到项目事件", func(t *testing.T) {
+		tab1 := dialEventStream(t, server, memberToken, fmt.Sprintf("project:%d", project.ID))
+		defer tab1.Close()
+		tab2 := dialEventStream(t, server, memberToken, "")
+		defer tab2.Close()
+
+		assert.Equal(t, "subscribed", readEventMessage(t, tab1).Type)
+
+		require.NoError(t, tab2.WriteJSON(map[string]string{"action": "subscribe", "topic": fmt.Sprintf("project:%d", project.ID)}))
+		assert.Equal(t, "subscribed", readEventMessage(t, tab2).Type)
+
+		// 无权限的项目不会推送给该用户
+		require.NoError(t, tab2.WriteJSON(map[string]string{"action": "subscribe", "topic": fmt.Sprintf("project:%d", otherProject.ID)}))
+		assert.Equal(t, "error", readEventMessage(t, tab2).Type)
+
+		otherTask := &model.Task{Title: "其他项目任务", ProjectID: otherProject.ID, CreatorID: outsider.ID, Status: "wait", Priority: "medium"}
+		require.NoError(t, db.Create(otherTask).Error)
+		_, err := utils.RecordAction(db, "task", otherTask.ID, "created", outsider.ID, "", nil)
+		require.NoError(t, err)
+
+		task := &model.Task{Title: "实时任务", ProjectID: project.ID, CreatorID: member.ID, Status: "wait", Priority: "medium"}
+		require.NoError(t, db.Create(task).Error)
+		_, err = utils.RecordAction(db, "task", task.ID, "status_changed", member.ID, "", nil)
+		require.NoError(t, err)
+
+		for _, conn := range []*gorillaws.Conn{tab1, tab2} {
+			msg := readEventMessage(t, conn)
+			require.Equal(t, "event", msg.Type)
+			data := msg.Data.(map[string]interface{})
+			assert.Equal(t, "task.status_changed", data["type"])
+			assert.Equal(t, float64(task.ID), data["object_id"])
+			assert.Equal(t, float64(project.ID), data["project_id"])
+		}
+	})
+
+	t.Run("按对象主题订阅", func(t *testing.T) {
+		bug := &model.Bug{Title: "实时Bug", ProjectID: project.ID, CreatorID: member.ID, Status: "active", Priority: "high", Severity: "normal"}
+		require.NoError(t, db.Create(bug).Error)
+
+		conn := dialEventStream(t, server, memberToken, fmt.Sprintf("bug:%d", bug.ID))
+		defer conn.Close()
+		assert.Equal(t, "subscribed", readEventMessage(t, conn).Type)
+
+		_, err := utils.RecordAction(db, "bug", bug.ID, "resolved", member.ID, "已修复", nil)
+		require.NoError(t, err)
+
+		msg := readEventMessage(t, conn)
+		require.Equal(t, "event", msg.Type)
+		assert.Equal(t, "bug.resolved", msg.Data.(map[string]interface{})["type"])
+	})
+}
+
+func TestBuildActionEvent(t *testing.T) {
+	event := websocket.BuildActionEvent(&model.Action{
+		ID:         1,
+		ObjectType: "task",
+		ObjectID:   5,
+		ProjectID:  2,
+		Action:     "moved",
+		Extra:      `{"board_id":3,"column_id":4}`,
+	})
+
+	assert.Equal(t, "task.moved", event.Type)
+	assert.ElementsMatch(t, []string{"task:5", "project:2", "board:3"}, event.Topics)
+}
+
+func TestEventStream_RevalidatesBeforeDelivery(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	if config.AppConfig.JWT.Secret == "" {
+		config.AppConfig.JWT.Secret = "test-secret-key-for-unit-testing"
+	}
+	if config.AppConfig.JWT.Expiration == 0 {
+		config.AppConfig.JWT.Expiration = 24
+	}
+
+	project := CreateTestProject(t, db, "事件流重新授权项目")
+	member := CreateTestUser(t, db, "eventleaver", "离开项目的成员")
+	membership := AddUserToProject(t, db, member.ID, project.ID, "member")
+	tokens, err := utils.CreateSession(db, member, []string{"developer"}, "password", nil)
+	require.NoError(t, err)
+
+	websocket.RegisterActionEvents()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws/events", websocket.HandleEventStream(db))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	recordTaskEvent := func() {
+		task := &model.Task{Title: "重新授权任务", ProjectID: project.ID, CreatorID: member.ID, Status: "wait", Priority: "medium"}
+		require.NoError(t, db.Create(task).Error)
+		_, err := utils.RecordAction(db, "task", task.ID, "created", member.ID, "", nil)
+		require.NoError(t, err)
+	}
+	projectTopic := fmt.Sprintf("project:%d", project.ID)
+
+	t.Run("离开项目后不再推送并取消订阅", func(t *testing.T) {
+		conn := dialEventStream(t, server, tokens.Token, projectTopic)
+		defer conn.Close()
+		require.Equal(t, "subscribed", readEventMessage(t, conn).Type)
+
+		require.NoError(t, db.Delete(membership).Error)
+		recordTaskEvent()
+
+		msg := readEventMessage(t, conn)
+		assert.Equal(t, "unsubscribed", msg.Type)
+		assert.Equal(t, projectTopic, msg.Topic)
+
+		AddUserToProject(t, db, member.ID, project.ID, "member")
+	})
+
+	t.Run("会话吊销后断开连接", func(t *testing.T) {
+		conn := dialEventStream(t, server, tokens.Token, projectTopic)
+		defer conn.Close()
+		require.Equal(t, "subscribed", readEventMessage(t, conn).Type)
+
+		require.NoError(t, utils.RevokeSession(db, tokens.SessionID, utils.SessionRevokedLogout))
+		recordTaskEvent()
+
+		msg := readEventMessage(t, conn)
+		assert.Equal(t, "error", msg.Type)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		assert.Error(t, err)
+	})
+}
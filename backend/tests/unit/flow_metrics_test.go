@@ -0,0 +1,164 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestFlowMetrics_ProjectCumulativeFlowAndPercentiles(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "flowadmin", "流动管理员")
+	project := CreateTestProject(t, db, "流动项目")
+	newTask := func(title, status, created string) *model.Task {
+		task := &model.Task{Title: title, ProjectID: project.ID, CreatorID: admin.ID, Status: status, CreatedAt: burnDate(created, 9)}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+
+	// 任务1：周期2天，前置3天；任务2：中途暂停，周期4天，前置6天；任务3未开始；任务4未经过进行中直接完成，前置1天
+	task1 := newTask("任务1", "done", "2026-05-01")
+	recordBurnHistory(t, db, "task", task1.ID, burnDate("2026-05-02", 9), "status", "wait", "doing")
+	recordBurnHistory(t, db, "task", task1.ID, burnDate("2026-05-04", 9), "status", "doing", "done")
+	task2 := newTask("任务2", "done", "2026-05-01")
+	recordBurnHistory(t, db, "task", task2.ID, burnDate("2026-05-03", 9), "status", "wait", "doing")
+	recordBurnHistory(t, db, "task", task2.ID, burnDate("2026-05-03", 21), "status", "doing", "pause")
+	recordBurnHistory(t, db, "task", task2.ID, burnDate("2026-05-05", 9), "status", "pause", "doing")
+	recordBurnHistory(t, db, "task", task2.ID, burnDate("2026-05-07", 9), "status", "doing", "done")
+	newTask("任务3", "wait", "2026-05-02")
+	task4 := newTask("任务4", "done", "2026-05-01")
+	recordBurnHistory(t, db, "task", task4.ID, burnDate("2026-05-02", 9), "status", "wait", "done")
+
+	handler := api.NewProjectHandler(db)
+	resp := callJSONHandler(t, handler.GetProjectFlowMetrics, admin.ID, []string{"admin"}, http.MethodGet,
+		"/api/projects/flow-metrics?start_date=2026-05-01&end_date=2026-05-07", gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(project.ID), 10)}}, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+
+	points := data["cumulative_flow"].(map[string]interface{})["points"].([]interface{})
+	require.Len(t, points, 7)
+	first := points[0].(map[string]interface{})["counts"].(map[string]interface{})
+	assert.Equal(t, float64(3), first["wait"])
+	second := points[1].(map[string]interface{})["counts"].(map[string]interface{})
+	assert.Equal(t, float64(2), second["wait"])
+	assert.Equal(t, float64(1), second["doing"])
+	assert.Equal(t, float64(1), second["done"])
+	last := points[6].(map[string]interface{})["counts"].(map[string]interface{})
+	assert.Equal(t, float64(3), last["done"])
+	assert.Equal(t, float64(1), last["wait"])
+
+	lead := data["lead_time"].(map[string]interface{})
+	assert.Equal(t, float64(3), lead["count"])
+	assert.Equal(t, 3.33, lead["average"])
+	assert.Equal(t, float64(1), lead["min"])
+	assert.Equal(t, float64(6), lead["max"])
+	leadPercentiles := lead["percentiles"].(map[string]interface{})
+	assert.Equal(t, float64(3), leadPercentiles["p50"])
+	assert.Equal(t, 4.5, leadPercentiles["p75"])
+	assert.Equal(t, 5.7, leadPercentiles["p95"])
+
+	cycle := data["cycle_time"].(map[string]interface{})
+	assert.Equal(t, float64(2), cycle["count"])
+	assert.Equal(t, 3.7, cycle["percentiles"].(map[string]interface{})["p85"])
+
+	// 平均停留时间最长的状态排在最前面
+	durations := data["status_durations"].([]interface{})
+	require.Len(t, durations, 3)
+	assert.Equal(t, "doing", durations[0].(map[string]interface{})["status"])
+	assert.Equal(t, 2.25, durations[0].(map[string]interface{})["average_days"])
+	assert.Equal(t, "wait", durations[2].(map[string]interface{})["status"])
+	assert.Equal(t, float64(3), durations[2].(map[string]interface{})["items"])
+
+	resp = callJSONHandler(t, handler.GetProjectFlowMetrics, admin.ID, []string{"admin"}, http.MethodGet,
+		"/api/projects/flow-metrics?object_type=requirement", gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(project.ID), 10)}}, nil)
+	assert.Equal(t, float64(400), resp["code"])
+}
+
+func TestFlowMetrics_BoardColumnsAsBands(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "flowboard", "看板管理员")
+	project := CreateTestProject(t, db, "看板流动项目")
+	board := &model.Board{Name: "看板", ProjectID: project.ID, Columns: []model.BoardColumn{
+		{Name: "待办", Status: "wait", Sort: 0},
+		{Name: "进行中", Status: "doing", Sort: 1},
+		{Name: "完成", Status: "done", Sort: 2},
+	}}
+	require.NoError(t, db.Create(board).Error)
+	task := &model.Task{Title: "看板任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "doing", CreatedAt: burnDate("2026-05-01", 9)}
+	require.NoError(t, db.Create(task).Error)
+	recordBurnHistory(t, db, "task", task.ID, burnDate("2026-05-02", 9), "status", "wait", "doing")
+
+	handler := api.NewBoardHandler(db)
+	resp := callJSONHandler(t, handler.GetBoardFlowMetrics, admin.ID, []string{"admin"}, http.MethodGet,
+		"/api/boards/flow-metrics?start_date=2026-05-01&end_date=2026-05-02", gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(board.ID), 10)}}, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	flow := resp["data"].(map[string]interface{})["cumulative_flow"].(map[string]interface{})
+	bands := flow["bands"].([]interface{})
+	require.Len(t, bands, 3)
+	waitKey := bands[0].(map[string]interface{})["key"].(string)
+	doingKey := bands[1].(map[string]interface{})["key"].(string)
+	assert.Equal(t, "进行中", bands[1].(map[string]interface{})["name"])
+
+	points := flow["points"].([]interface{})
+	require.Len(t, points, 2)
+	assert.Equal(t, float64(1), points[0].(map[string]interface{})["counts"].(map[string]interface{})[waitKey])
+	assert.Equal(t, float64(1), points[1].(map[string]interface{})["counts"].(map[string]interface{})[doingKey])
+}
+
+func TestPercentile_LinearInterpolation(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4}
+	assert.Equal(t, 2.5, utils.Percentile(sorted, 50))
+	assert.Equal(t, float64(4), utils.Percentile(sorted, 100))
+	assert.Equal(t, float64(0), utils.Percentile(nil, 50))
+
+	stats := utils.NewDurationStats([]float64{0.5, 1.2, 1.8})
+	require.Len(t, stats.Histogram, 2)
+	assert.Equal(t, 1, stats.Histogram[0].Count)
+	assert.Equal(t, 2, stats.Histogram[1].Count)
+}
+
+func TestFlowMetrics_CancelledWorkExcluded(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "flowadmin3", "流动管理员")
+	project := CreateTestProject(t, db, "流动项目3")
+	newTask := func(title, status string) *model.Task {
+		task := &model.Task{Title: title, ProjectID: project.ID, CreatorID: admin.ID, Status: status, CreatedAt: burnDate("2026-05-01", 9)}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+
+	// 完成：前置2天；取消：不计入；取消后重新打开并完成：前置5天，周期4天
+	delivered := newTask("已完成", "done")
+	recordBurnHistory(t, db, "task", delivered.ID, burnDate("2026-05-03", 9), "status", "wait", "done")
+	cancelled := newTask("已取消", "cancel")
+	recordBurnHistory(t, db, "task", cancelled.ID, burnDate("2026-05-02", 9), "status", "wait", "doing")
+	recordBurnHistory(t, db, "task", cancelled.ID, burnDate("2026-05-02", 21), "status", "doing", "cancel")
+	reopened := newTask("重新打开", "done")
+	recordBurnHistory(t, db, "task", reopened.ID, burnDate("2026-05-02", 9), "status", "wait", "doing")
+	recordBurnHistory(t, db, "task", reopened.ID, burnDate("2026-05-03", 9), "status", "doing", "cancel")
+	recordBurnHistory(t, db, "task", reopened.ID, burnDate("2026-05-04", 9), "status", "cancel", "doing")
+	recordBurnHistory(t, db, "task", reopened.ID, burnDate("2026-05-06", 9), "status", "doing", "done")
+
+	metrics, err := utils.BuildFlowMetrics(db, utils.FlowMetricsScope{ObjectType: utils.SprintItemTask, ProjectID: project.ID,
+		StartDate: burnDate("2026-05-01", 0), EndDate: burnDate("2026-05-07", 0)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, metrics.LeadTime.Count)
+	assert.Equal(t, float64(2), metrics.LeadTime.Min)
+	assert.Equal(t, float64(5), metrics.LeadTime.Max)
+	assert.Equal(t, 1, metrics.CycleTime.Count)
+	assert.Equal(t, float64(4), metrics.CycleTime.Max)
+}
@@ -0,0 +1,421 @@
+package unit
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+const (
+	fakeLDAPBaseDN       = "dc=example,dc=com"
+	fakeLDAPBindDN       = "cn=admin,dc=example,dc=com"
+	fakeLDAPBindPassword = "admin-secret"
+)
+
+// fakeLDAPServer 进程内的LDAP服务器，只实现 Bind、Search、Unbind，足够验证登录和同步流程
+type fakeLDAPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	entries  map[string]map[string][]string // DN -> 属性
+	password map[string]string              // DN -> 密码
+}
+
+func newFakeLDAPServer(t *testing.T) *fakeLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeLDAPServer{
+		listener: listener,
+		entries:  map[string]map[string][]string{},
+		password: map[string]string{fakeLDAPBindDN: fakeLDAPBindPassword},
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) AddUser(uid, password string, attrs map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dn := "uid=" + uid + ",ou=people," + fakeLDAPBaseDN
+	entry := map[string][]string{"objectClass": {"person"}, "uid": {uid}}
+	for k, v := range attrs {
+		entry[k] = v
+	}
+	s.entries[dn] = entry
+	s.password[dn] = password
+}
+
+func (s *fakeLDAPServer) RemoveUser(uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dn := "uid=" + uid + ",ou=people," + fakeLDAPBaseDN
+	delete(s.entries, dn)
+	delete(s.password, dn)
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case 0: // BindRequest
+			name := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			expected, ok := s.password[name]
+			s.mu.Unlock()
+			code := 0
+			if !ok || password == "" || expected != password {
+				code = 49 // invalidCredentials
+			}
+			s.write(conn, messageID, ldapResult(1, code))
+		case 2: // UnbindRequest
+			return
+		case 3: // SearchRequest
+			base := strings.ToLower(op.Children[0].Data.String())
+			filter := op.Children[6]
+			s.mu.Lock()
+			for dn, attrs := range s.entries {
+				if !strings.HasSuffix(strings.ToLower(dn), base) || !matchLDAPFilter(filter, attrs) {
+					continue
+				}
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "SearchResultEntry")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+				attributes := ber.NewSequence("attributes")
+				for name, values := range attrs {
+					attr := ber.NewSequence("attribute")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+					}
+					attr.AppendChild(set)
+					attributes.AppendChild(attr)
+				}
+				entry.AppendChild(attributes)
+				s.write(conn, messageID, entry)
+			}
+			s.mu.Unlock()
+			s.write(conn, messageID, ldapResult(5, 0))
+		default:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAPServer) write(conn net.Conn, messageID interface{}, op *ber.Packet) {
+	message := ber.NewSequence("LDAPMessage")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "messageID"))
+	message.AppendChild(op)
+	conn.Write(message.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "LDAPResult")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return result
+}
+
+// matchLDAPFilter 计算过滤器：支持 and、or、not、等值、存在和子串匹配，均不区分大小写
+func matchLDAPFilter(filter *ber.Packet, attrs map[string][]string) bool {
+	values := func(name string) []string {
+		for k, v := range attrs {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+		return nil
+	}
+	switch filter.Tag {
+	case 0: // and
+		for _, child := range filter.Children {
+			if !matchLDAPFilter(child, attrs) {
+				return false
+			}
+		}
+		return true
+	case 1: // or
+		for _, child := range filter.Children {
+			if matchLDAPFilter(child, attrs) {
+				return true
+			}
+		}
+		return false
+	case 2: // not
+		return !matchLDAPFilter(filter.Children[0], attrs)
+	case 3: // equalityMatch
+		expected := filter.Children[1].Data.String()
+		for _, value := range values(filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, expected) {
+				return true
+			}
+		}
+		return false
+	case 4: // substrings
+		for _, value := range values(filter.Children[0].Data.String()) {
+			rest := strings.ToLower(value)
+			matched := true
+			for _, part := range filter.Children[1].Children {
+				sub := strings.ToLower(part.Data.String())
+				switch part.Tag {
+				case 0: // initial
+					matched = matched && strings.HasPrefix(rest, sub)
+					rest = strings.TrimPrefix(rest, sub)
+				case 1: // any
+					idx := strings.Index(rest, sub)
+					matched = matched && idx >= 0
+					if idx >= 0 {
+						rest = rest[idx+len(sub):]
+					}
+				case 2: // final
+					matched = matched && strings.HasSuffix(rest, sub)
+				}
+			}
+			if matched {
+				return true
+			}
+		}
+		return false
+	case 7: // present
+		return len(values(filter.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+// useTestLDAP 指向假LDAP服务器的配置，返回恢复原配置的函数
+func useTestLDAP(server *fakeLDAPServer) func() {
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	old := config.AppConfig.LDAP
+	config.AppConfig.LDAP = config.LDAPConfig{
+		Enabled:             true,
+		URL:                 server.URL(),
+		BindDN:              fakeLDAPBindDN,
+		BindPassword:        fakeLDAPBindPassword,
+		BaseDN:              fakeLDAPBaseDN,
+		UserFilter:          "(&(objectClass=person)(uid=%s))",
+		DepartmentAttribute: "departmentNumber",
+		GroupRoles: []config.LDAPGroupRole{
+			{Group: "developers", Role: "ldap_dev"},
+			{Group: "cn=QA,ou=groups,dc=example,dc=com", Role: "ldap_qa"},
+		},
+		Timeout: 5,
+	}
+	return func() { config.AppConfig.LDAP = old }
+}
+
+func TestLDAP_LoginProvisionsUser(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	defer useTestLoginSecurity(config.LoginSecurityConfig{MaxFailures: 5, DelayBaseMillis: 1, MaxDelaySeconds: 1, IPMaxFailures: 100})()
+	server := newFakeLDAPServer(t)
+	defer useTestLDAP(server)()
+
+	createRoleWithPermissions(t, db, "ldap_dev", "project:read")
+	createRoleWithPermissions(t, db, "ldap_qa", "bug:read")
+	server.AddUser("alice", "Directory1", map[string][]string{
+		"displayName":      {"Alice Zhang"},
+		"mail":             {"alice@example.com"},
+		"departmentNumber": {"研发部"},
+		"memberOf":         {"cn=developers,ou=groups,dc=example,dc=com", "cn=qa,ou=groups,dc=example,dc=com", "cn=sales,ou=groups,dc=example,dc=com"},
+	})
+
+	r := setupSessionRouter(db)
+	r.POST("/api/auth/ldap/login", api.NewAuthHandler(db).LDAPLogin)
+	login := func(username, password string) map[string]interface{} {
+		return doSessionRequest(t, r, "POST", "/api/auth/ldap/login", "", map[string]string{"username": username, "password": password})
+	}
+
+	// 密码错误、用户不存在都不创建本地用户
+	assert.Equal(t, float64(401), login("alice", "wrong")["code"])
+	assert.Equal(t, float64(401), login("nobody", "Directory1")["code"])
+	var count int64
+	db.Model(&model.User{}).Where("username IN ?", []string{"alice", "nobody"}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// 首次登录自动创建用户，部门和角色来自目录
+	resp := login("alice", "Directory1")
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	token := resp["data"].(map[string]interface{})["token"].(string)
+	assert.ElementsMatch(t, []interface{}{"ldap_dev", "ldap_qa"}, resp["data"].(map[string]interface{})["user"].(map[string]interface{})["roles"])
+	assert.Equal(t, float64(200), doSessionRequest(t, r, "GET", "/api/auth/user/info", token, nil)["code"])
+
+	var user model.User
+	require.NoError(t, db.Preload("Department").Where("username = ?", "alice").First(&user).Error)
+	assert.Equal(t, utils.AuthSourceLDAP, user.AuthSource)
+	assert.Equal(t, "uid=alice,ou=people,"+fakeLDAPBaseDN, user.ExternalID)
+	assert.Equal(t, "Alice Zhang", user.Nickname)
+	assert.Equal(t, "alice@example.com", user.Email)
+	assert.Empty(t, user.Password)
+	require.NotNil(t, user.Department)
+	assert.Equal(t, "研发部", user.Department.Name)
+
+	var session model.UserSession
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&session).Error)
+	assert.Equal(t, "ldap", session.LoginMethod)
+
+	// 再次登录时同步目录中的变更，不重复创建部门
+	server.AddUser("alice", "Directory2", map[string][]string{
+		"displayName":      {"Alice Z."},
+		"departmentNumber": {"研发部"},
+		"memberOf":         {"cn=developers,ou=groups,dc=example,dc=com"},
+	})
+	require.Equal(t, float64(200), login("alice", "Directory2")["code"])
+	require.NoError(t, db.Preload("Roles").First(&user, user.ID).Error)
+	assert.Equal(t, "Alice Z.", user.Nickname)
+	require.Len(t, user.Roles, 1)
+	assert.Equal(t, "ldap_dev", user.Roles[0].Code)
+	db.Model(&model.Department{}).Where("name = ?", "研发部").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// 本地账号不能被同名的目录账号接管，也不能用本地密码走LDAP登录
+	createSessionTestUser(t, db, "bob", "Local1234")
+	server.AddUser("bob", "Directory1", nil)
+	assert.Equal(t, float64(409), login("bob", "Directory1")["code"])
+	assert.Equal(t, float64(401), login("bob", "Local1234")["code"])
+
+	// LDAP账号不能使用本地密码登录，也不能在系统中修改密码
+	assert.Equal(t, float64(401), doSessionRequest(t, r, "POST", "/api/auth/login", "", map[string]string{"username": "alice", "password": "Directory2"})["code"])
+	resp = doSessionRequest(t, r, "POST", "/api/auth/change-password", token, map[string]string{"new_password": "NewPass123"})
+	assert.Equal(t, float64(400), resp["code"])
+}
+
+func TestLDAP_SyncDisablesRemovedUsers(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	server := newFakeLDAPServer(t)
+	defer useTestLDAP(server)()
+
+	cfg := utils.GetLDAPConfig()
+	for _, uid := range []string{"carol", "dave"} {
+		server.AddUser(uid, "Directory1", map[string][]string{"displayName": {uid}})
+		entry, err := utils.LDAPAuthenticate(cfg, uid, "Directory1")
+		require.NoError(t, err)
+		_, err = utils.ProvisionLDAPUser(db, cfg, entry)
+		require.NoError(t, err)
+	}
+	local := CreateTestUser(t, db, "localonly", "本地用户")
+
+	var dave model.User
+	require.NoError(t, db.Where("username = ?", "dave").First(&dave).Error)
+	session := model.UserSession{SessionID: "ldap-sync-session", UserID: dave.ID, LoginMethod: "ldap"}
+	require.NoError(t, db.Create(&session).Error)
+
+	// dave 从目录中删除，carol 修改了昵称
+	server.RemoveUser("dave")
+	server.AddUser("carol", "Directory1", map[string][]string{"displayName": {"Carol Li"}})
+
+	admin := CreateTestAdminUser(t, db, "ldapadmin", "管理员")
+	resp := callJSONHandler(t, api.NewSystemHandler(db).SyncLDAPUsers, admin.ID, []string{"admin"}, "POST", "/api/system/ldap/sync", nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(1), data["total"])
+	assert.Equal(t, float64(1), data["updated"])
+	assert.Equal(t, float64(1), data["disabled"])
+
+	require.NoError(t, db.First(&dave, dave.ID).Error)
+	assert.Equal(t, 0, dave.Status)
+	require.NoError(t, db.First(&session, session.ID).Error)
+	assert.NotNil(t, session.RevokedAt)
+
+	var carol model.User
+	require.NoError(t, db.Where("username = ?", "carol").First(&carol).Error)
+	assert.Equal(t, 1, carol.Status)
+	assert.Equal(t, "Carol Li", carol.Nickname)
+
+	// 本地账号不受目录同步影响；已禁用的用户不会重复计数
+	require.NoError(t, db.First(local, local.ID).Error)
+	assert.Equal(t, 1, local.Status)
+	result, err := utils.SyncLDAPUsers(db, cfg, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Disabled)
+
+	// 目录中恢复后重新登录仍是禁用状态，需要管理员启用
+	server.AddUser("dave", "Directory1", nil)
+	entry, err := utils.LDAPAuthenticate(cfg, "dave", "Directory1")
+	require.NoError(t, err)
+	user, err := utils.ProvisionLDAPUser(db, cfg, entry)
+	require.NoError(t, err)
+	assert.Equal(t, 0, user.Status)
+}
+
+func TestLDAP_SyncAbortsOnMassDisable(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	server := newFakeLDAPServer(t)
+	defer useTestLDAP(server)()
+	config.AppConfig.LDAP.SyncMaxDisable = 2
+
+	cfg := utils.GetLDAPConfig()
+	users := []string{"erin", "frank", "grace", "heidi"}
+	for _, uid := range users {
+		server.AddUser(uid, "Directory1", nil)
+		entry, err := utils.LDAPAuthenticate(cfg, uid, "Directory1")
+		require.NoError(t, err)
+		_, err = utils.ProvisionLDAPUser(db, cfg, entry)
+		require.NoError(t, err)
+	}
+	enabledCount := func() int64 {
+		var count int64
+		db.Model(&model.User{}).Where("auth_source = ? AND status = 1", utils.AuthSourceLDAP).Count(&count)
+		return count
+	}
+
+	// 目录返回0个用户（如过滤器或BaseDN配置错误）时不禁用任何用户
+	for _, uid := range users {
+		server.RemoveUser(uid)
+	}
+	_, err := utils.SyncLDAPUsers(db, cfg, false)
+	assert.ErrorIs(t, err, utils.ErrLDAPSyncAborted)
+	assert.Equal(t, int64(4), enabledCount())
+
+	// 需要禁用的用户数超过上限时中止
+	server.AddUser("erin", "Directory1", nil)
+	admin := CreateTestAdminUser(t, db, "ldapsyncadmin", "管理员")
+	handler := api.NewSystemHandler(db)
+	resp := callJSONHandler(t, handler.SyncLDAPUsers, admin.ID, []string{"admin"}, "POST", "/api/system/ldap/sync", nil, nil)
+	assert.Equal(t, float64(409), resp["code"])
+	assert.Equal(t, int64(4), enabledCount())
+
+	// 管理员确认后可以强制同步
+	resp = callJSONHandler(t, handler.SyncLDAPUsers, admin.ID, []string{"admin"}, "POST", "/api/system/ldap/sync?force=true", nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(3), resp["data"].(map[string]interface{})["disabled"])
+	assert.Equal(t, int64(1), enabledCount())
+}
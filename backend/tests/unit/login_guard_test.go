@@ -0,0 +1,164 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/middleware"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+// useTestLoginSecurity 设置登录防护策略，返回恢复函数
+func useTestLoginSecurity(cfg config.LoginSecurityConfig) func() {
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	old := config.AppConfig.LoginSecurity
+	config.AppConfig.LoginSecurity = cfg
+	return func() { config.AppConfig.LoginSecurity = old }
+}
+
+func TestLoginGuard_AccountLockout(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	defer useTestLoginSecurity(config.LoginSecurityConfig{MaxFailures: 3, DelayBaseMillis: 1, MaxDelaySeconds: 1, IPMaxFailures: 100})()
+
+	r := setupSessionRouter(db)
+	userHandler := api.NewUserHandler(db)
+	r.POST("/api/users/:id/unlock", middleware.Auth(), userHandler.UnlockUser)
+
+	user := createSessionTestUser(t, db, "lockuser", "Lockout123")
+	createSessionTestUser(t, db, "lockadmin", "Admin1234")
+	login := func(password string) map[string]interface{} {
+		return doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "lockuser", "password": password})
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := login("wrong")
+		assert.Equal(t, float64(401), resp["code"])
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 第三次失败锁定账号
+	resp := login("wrong")
+	assert.Equal(t, float64(utils.CodeAccountLocked), resp["code"])
+	assert.Contains(t, resp["message"], "账号已锁定")
+	assert.NotNil(t, resp["data"].(map[string]interface{})["locked_until"])
+
+	// 锁定期间正确密码也无法登录
+	resp = login("Lockout123")
+	assert.Equal(t, float64(utils.CodeAccountLocked), resp["code"])
+
+	// 失败计数保存在数据库中，重启后仍然有效
+	var throttle model.LoginThrottle
+	require.NoError(t, db.Where("scope = ? AND identifier = ?", utils.LoginThrottleScopeUser, "lockuser").First(&throttle).Error)
+	assert.Equal(t, 3, throttle.Failures)
+	require.NotNil(t, throttle.LockedUntil)
+
+	// 管理员解锁
+	adminToken, _ := sessionLogin(t, r, "lockadmin", "Admin1234")
+	resp = doSessionRequest(t, r, http.MethodPost, fmt.Sprintf("/api/users/%d/unlock", user.ID), adminToken, nil)
+	require.Equal(t, float64(200), resp["code"])
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["was_locked"])
+
+	resp = login("Lockout123")
+	assert.Equal(t, float64(200), resp["code"])
+}
+
+func TestLoginGuard_ProgressiveDelayAndWindow(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	defer useTestLoginSecurity(config.LoginSecurityConfig{MaxFailures: 10, DelayBaseMillis: 1000, MaxDelaySeconds: 4, IPMaxFailures: 100})()
+
+	assert.Equal(t, time.Second, utils.LoginDelay(1))
+	assert.Equal(t, 2*time.Second, utils.LoginDelay(2))
+	assert.Equal(t, 4*time.Second, utils.LoginDelay(3))
+	assert.Equal(t, 4*time.Second, utils.LoginDelay(8))
+
+	r := setupSessionRouter(db)
+	createSessionTestUser(t, db, "delayuser", "Delay1234")
+
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "delayuser", "password": "wrong"})
+	assert.Equal(t, float64(401), resp["code"])
+
+	// 等待时间内再次尝试被拒绝
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "delayuser", "password": "Delay1234"})
+	assert.Equal(t, float64(utils.CodeTooManyAttempts), resp["code"])
+	assert.Equal(t, float64(1), resp["data"].(map[string]interface{})["retry_after"])
+
+	// 统计窗口过期后重新计数
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Model(&model.LoginThrottle{}).Where("scope = ?", utils.LoginThrottleScopeUser).
+		Updates(map[string]interface{}{"window_start_at": past, "next_attempt_at": past}).Error)
+	assert.Nil(t, utils.RecordLoginFailure(db, "delayuser", ""))
+	var throttle model.LoginThrottle
+	db.Where("scope = ? AND identifier = ?", utils.LoginThrottleScopeUser, "delayuser").First(&throttle)
+	assert.Equal(t, 1, throttle.Failures)
+
+	// 登录成功清除失败记录
+	db.Model(&model.LoginThrottle{}).Where("id = ?", throttle.ID).Update("next_attempt_at", past)
+	_, _ = sessionLogin(t, r, "delayuser", "Delay1234")
+	var count int64
+	db.Model(&model.LoginThrottle{}).Where("scope = ? AND identifier = ?", utils.LoginThrottleScopeUser, "delayuser").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestLoginGuard_IPThrottle(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	defer useTestLoginSecurity(config.LoginSecurityConfig{MaxFailures: 100, DelayBaseMillis: 1, MaxDelaySeconds: 1, IPMaxFailures: 4})()
+
+	r := setupSessionRouter(db)
+	createSessionTestUser(t, db, "ipuser", "IPuser123")
+
+	t.Run("同一IP尝试多个账号", func(t *testing.T) {
+		var resp map[string]interface{}
+		for i := 0; i < 4; i++ {
+			resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{
+				"username": fmt.Sprintf("guess%d", i),
+				"password": "wrong",
+			})
+		}
+		assert.Equal(t, float64(utils.CodeTooManyAttempts), resp["code"])
+
+		// 受限期间该IP无法登录任何账号
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "ipuser", "password": "IPuser123"})
+		assert.Equal(t, float64(utils.CodeTooManyAttempts), resp["code"])
+
+		// 其他IP不受影响
+		assert.Nil(t, utils.CheckLoginAllowed(db, "ipuser", "198.51.100.7"))
+	})
+
+	t.Run("修改密码与初始化接口", func(t *testing.T) {
+		require.NoError(t, db.Where("1 = 1").Delete(&model.LoginThrottle{}).Error)
+		token, _ := sessionLogin(t, r, "ipuser", "IPuser123")
+
+		// 旧密码错误计入账号失败次数
+		resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/change-password", token, map[string]interface{}{
+			"old_password": "wrong",
+			"new_password": "IPuser456",
+		})
+		assert.Equal(t, float64(400), resp["code"])
+		var throttle model.LoginThrottle
+		require.NoError(t, db.Where("scope = ? AND identifier = ?", utils.LoginThrottleScopeUser, "ipuser").First(&throttle).Error)
+		assert.Equal(t, 1, throttle.Failures)
+
+		// 系统已初始化后反复调用初始化接口会被限制
+		require.NoError(t, db.Create(&model.SystemConfig{Key: "initialized", Value: "true", Type: "boolean"}).Error)
+		initHandler := api.NewInitHandler(db)
+		r.POST("/api/init/password", initHandler.InitSystemWithPassword)
+		for i := 0; i < 3; i++ {
+			resp = doSessionRequest(t, r, http.MethodPost, "/api/init/password", "", map[string]interface{}{"username": "x", "password": "Init12345", "nickname": "x"})
+			assert.Equal(t, float64(400), resp["code"])
+		}
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/init/password", "", map[string]interface{}{"username": "x", "password": "Init12345", "nickname": "x"})
+		assert.Equal(t, float64(utils.CodeTooManyAttempts), resp["code"])
+	})
+}
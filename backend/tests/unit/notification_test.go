@@ -0,0 +1,220 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/internal/websocket"
+)
+
+func TestNotification_Assignment(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "通知项目")
+	admin := CreateTestAdminUser(t, db, "notifyadmin", "管理员")
+	dev1 := CreateTestUser(t, db, "notifydev1", "开发一")
+	dev2 := CreateTestUser(t, db, "notifydev2", "开发二")
+	AddUserToProject(t, db, dev1.ID, project.ID, "member")
+	AddUserToProject(t, db, dev2.ID, project.ID, "member")
+
+	countNotifications := func(userID uint, notificationType string) int64 {
+		var count int64
+		db.Model(&model.Notification{}).Where("user_id = ? AND type = ?", userID, notificationType).Count(&count)
+		return count
+	}
+
+	t.Run("指派任务通知指派人", func(t *testing.T) {
+		task := &model.Task{Title: "通知任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "wait", Priority: "medium"}
+		require.NoError(t, db.Create(task).Error)
+		params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", task.ID)}}
+
+		response := callJSONHandler(t, api.NewTaskHandler(db).AssignTask, admin.ID, []string{"admin"}, http.MethodPost, "/api/tasks/assign", params, map[string]interface{}{
+			"assignee_id": dev1.ID,
+			"comment":     "请尽快处理",
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+
+		var notification model.Notification
+		require.NoError(t, db.Where("user_id = ?", dev1.ID).First(&notification).Error)
+		assert.Equal(t, utils.NotificationTaskAssigned, notification.Type)
+		assert.Equal(t, "task", notification.ObjectType)
+		assert.Equal(t, task.ID, notification.ObjectID)
+		assert.Equal(t, project.ID, notification.ProjectID)
+		assert.Equal(t, "请尽快处理", notification.Content)
+		require.NotNil(t, notification.ActorID)
+		assert.Equal(t, admin.ID, *notification.ActorID)
+		assert.False(t, notification.IsRead)
+
+		// 指派给同一人不重复通知
+		callJSONHandler(t, api.NewTaskHandler(db).AssignTask, admin.ID, []string{"admin"}, http.MethodPost, "/api/tasks/assign", params, map[string]interface{}{
+			"assignee_id": dev1.ID,
+		})
+		assert.Equal(t, int64(1), countNotifications(dev1.ID, utils.NotificationTaskAssigned))
+	})
+
+	t.Run("指派Bug只通知新增指派人且不通知自己", func(t *testing.T) {
+		bug := &model.Bug{Title: "通知Bug", ProjectID: project.ID, CreatorID: admin.ID, Status: "active", Priority: "high", Severity: "normal"}
+		require.NoError(t, db.Create(bug).Error)
+		require.NoError(t, db.Create(&model.BugAssignee{BugID: bug.ID, UserID: dev1.ID}).Error)
+		params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", bug.ID)}}
+
+		response := callJSONHandler(t, api.NewBugHandler(db).AssignBug, dev1.ID, []string{"developer"}, http.MethodPost, "/api/bugs/assign", params, map[string]interface{}{
+			"assignee_ids": []uint{dev1.ID, dev2.ID},
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+
+		assert.Equal(t, int64(0), countNotifications(dev1.ID, utils.NotificationBugAssigned))
+		assert.Equal(t, int64(1), countNotifications(dev2.ID, utils.NotificationBugAssigned))
+	})
+
+	t.Run("关闭偏好后不再接收", func(t *testing.T) {
+		response := callJSONHandler(t, api.NewNotificationHandler(db).UpdateNotificationPreferences, dev2.ID, []string{"developer"}, http.MethodPut, "/api/notifications/preferences", nil, map[string]interface{}{
+			"preferences": []map[string]interface{}{
+				{"event_type": utils.NotificationRequirementAssigned, "channel": utils.NotificationChannelInApp, "enabled": false},
+			},
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+		assert.False(t, utils.NotificationEnabled(db, dev2.ID, utils.NotificationRequirementAssigned, utils.NotificationChannelInApp))
+		assert.True(t, utils.NotificationEnabled(db, dev2.ID, utils.NotificationTaskAssigned, utils.NotificationChannelInApp))
+
+		requirement := &model.Requirement{Title: "通知需求", ProjectID: project.ID, CreatorID: admin.ID, Status: "draft", Priority: "medium"}
+		require.NoError(t, db.Create(requirement).Error)
+		params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", requirement.ID)}}
+		response = callJSONHandler(t, api.NewRequirementHandler(db).AssignRequirement, admin.ID, []string{"admin"}, http.MethodPost, "/api/requirements/assign", params, map[string]interface{}{
+			"assignee_id": dev2.ID,
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+		assert.Equal(t, int64(0), countNotifications(dev2.ID, utils.NotificationRequirementAssigned))
+	})
+
+	t.Run("不支持的偏好类型", func(t *testing.T) {
+		response := callJSONHandler(t, api.NewNotificationHandler(db).UpdateNotificationPreferences, dev2.ID, []string{"developer"}, http.MethodPut, "/api/notifications/preferences", nil, map[string]interface{}{
+			"preferences": []map[string]interface{}{{"event_type": "bug.exploded", "channel": utils.NotificationChannelInApp, "enabled": false}},
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	t.Run("添加日报审批人通知审批人", func(t *testing.T) {
+		response := callJSONHandler(t, api.NewReportHandler(db).CreateDailyReport, dev1.ID, []string{"developer"}, http.MethodPost, "/api/daily-reports", nil, map[string]interface{}{
+			"date":         "2024-01-15",
+			"content":      "今日工作",
+			"approver_ids": []uint{admin.ID},
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+		assert.Equal(t, int64(1), countNotifications(admin.ID, utils.NotificationReportApprovalRequested))
+
+		// 更新时只通知新增的审批人
+		reportID := response["data"].(map[string]interface{})["id"]
+		params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%v", reportID)}}
+		response = callJSONHandler(t, api.NewReportHandler(db).UpdateDailyReport, dev1.ID, []string{"developer"}, http.MethodPut, "/api/daily-reports", params, map[string]interface{}{
+			"approver_ids": []uint{admin.ID, dev2.ID},
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+		assert.Equal(t, int64(1), countNotifications(admin.ID, utils.NotificationReportApprovalRequested))
+		assert.Equal(t, int64(1), countNotifications(dev2.ID, utils.NotificationReportApprovalRequested))
+	})
+}
+
+func TestNotificationHandler_ReadState(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	user := CreateTestUser(t, db, "notifyreader", "读者")
+	other := CreateTestUser(t, db, "notifyother", "其他用户")
+	handler := api.NewNotificationHandler(db)
+
+	for i := 0; i < 3; i++ {
+		_, err := utils.Notify(db, []uint{user.ID}, utils.NotificationInput{Type: utils.NotificationTaskAssigned, Title: fmt.Sprintf("通知%d", i), ObjectType: "task", ObjectID: uint(i + 1)})
+		require.NoError(t, err)
+	}
+	created, err := utils.Notify(db, []uint{other.ID}, utils.NotificationInput{Type: utils.NotificationBugAssigned, Title: "他人通知"})
+	require.NoError(t, err)
+	otherNotificationID := created[0].ID
+
+	unreadCount := func() float64 {
+		response := callJSONHandler(t, handler.GetUnreadCount, user.ID, []string{"developer"}, http.MethodGet, "/api/notifications/unread-count", nil, nil)
+		require.Equal(t, float64(200), response["code"])
+		return response["data"].(map[string]interface{})["count"].(float64)
+	}
+	assert.Equal(t, float64(3), unreadCount())
+
+	response := callJSONHandler(t, handler.GetNotifications, user.ID, []string{"developer"}, http.MethodGet, "/api/notifications?is_read=false", nil, nil)
+	require.Equal(t, float64(200), response["code"])
+	list := response["data"].(map[string]interface{})["list"].([]interface{})
+	require.Len(t, list, 3)
+	firstID := fmt.Sprintf("%v", list[0].(map[string]interface{})["id"])
+
+	t.Run("标记单条已读", func(t *testing.T) {
+		response := callJSONHandler(t, handler.MarkNotificationRead, user.ID, []string{"developer"}, http.MethodPut, "/api/notifications/read", gin.Params{gin.Param{Key: "id", Value: firstID}}, nil)
+		require.Equal(t, float64(200), response["code"])
+		assert.Equal(t, true, response["data"].(map[string]interface{})["is_read"])
+		assert.Equal(t, float64(2), unreadCount())
+	})
+
+	t.Run("不能操作他人的通知", func(t *testing.T) {
+		response := callJSONHandler(t, handler.MarkNotificationRead, user.ID, []string{"developer"}, http.MethodPut, "/api/notifications/read", gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", otherNotificationID)}}, nil)
+		assert.Equal(t, float64(404), response["code"])
+	})
+
+	t.Run("全部标记已读", func(t *testing.T) {
+		response := callJSONHandler(t, handler.MarkAllNotificationsRead, user.ID, []string{"developer"}, http.MethodPut, "/api/notifications/read-all", nil, nil)
+		require.Equal(t, float64(200), response["code"])
+		assert.Equal(t, float64(2), response["data"].(map[string]interface{})["updated"])
+		assert.Equal(t, float64(0), unreadCount())
+
+		// 他人的通知不受影响
+		var notification model.Notification
+		db.First(&notification, otherNotificationID)
+		assert.False(t, notification.IsRead)
+	})
+}
+
+func TestNotification_LivePush(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	if config.AppConfig.JWT.Secret == "" {
+		config.AppConfig.JWT.Secret = "test-secret-key-for-unit-testing"
+	}
+	if config.AppConfig.JWT.Expiration == 0 {
+		config.AppConfig.JWT.Expiration = 24
+	}
+
+	user := CreateTestUser(t, db, "notifyonline", "在线用户")
+	session, err := utils.CreateSession(db, user, []string{"developer"}, "password", nil)
+	require.NoError(t, err)
+	token := session.Token
+
+	websocket.RegisterNotificationPush()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws/events", websocket.HandleEventStream(db))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// 未订阅任何主题也能收到自己的通知
+	conn := dialEventStream(t, server, token, "")
+	defer conn.Close()
+	require.Eventually(t, func() bool { return websocket.GetHub().ClientCount(user.ID) == 1 }, time.Second, 10*time.Millisecond)
+
+	_, err = utils.Notify(db, []uint{user.ID}, utils.NotificationInput{Type: utils.NotificationBugAssigned, Title: "实时通知", ObjectType: "bug", ObjectID: 1})
+	require.NoError(t, err)
+
+	msg := readEventMessage(t, conn)
+	require.Equal(t, "notification", msg.Type)
+	data := msg.Data.(map[string]interface{})
+	assert.Equal(t, "实时通知", data["title"])
+	assert.Equal(t, utils.NotificationBugAssigned, data["type"])
+}
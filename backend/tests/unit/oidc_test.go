@@ -0,0 +1,365 @@
+package unit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/middleware"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/pkg/oidc"
+)
+
+const (
+	mockOIDCClientID     = "prjflow"
+	mockOIDCClientSecret = "oidc-secret"
+	mockOIDCRedirectURL  = "http://localhost:3000/auth/oidc/callback"
+)
+
+// mockOIDCIssuer 本地OIDC签发者：配置发现、JWKS 和校验 PKCE 的令牌端点
+type mockOIDCIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockOIDCGrant
+
+	// 以下字段用于构造异常的 ID Token
+	Audience string
+	Nonce    string
+	TTL      time.Duration
+}
+
+type mockOIDCGrant struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCIssuer(t *testing.T) *mockOIDCIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer := &mockOIDCIssuer{key: key, codes: map[string]mockOIDCGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                           issuer.server.URL,
+			"authorization_endpoint":           issuer.server.URL + "/authorize",
+			"token_endpoint":                   issuer.server.URL + "/token",
+			"jwks_uri":                         issuer.server.URL + "/jwks",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test-key", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockOIDCIssuer) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != mockOIDCClientID || secret != mockOIDCClientSecret {
+		fail("invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != mockOIDCRedirectURL {
+		fail("invalid_request")
+		return
+	}
+	m.mu.Lock()
+	grant, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok || oidc.CodeChallengeS256(r.PostFormValue("code_verifier")) != grant.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   mockOIDCClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": grant.nonce,
+	}
+	if m.Audience != "" {
+		claims["aud"] = m.Audience
+	}
+	if m.Nonce != "" {
+		claims["nonce"] = m.Nonce
+	}
+	if m.TTL != 0 {
+		claims["iat"] = time.Now().Add(m.TTL - time.Hour).Unix()
+		claims["exp"] = time.Now().Add(m.TTL).Unix()
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	idToken, _ := token.SignedString(m.key)
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+// approve 模拟用户在签发者登录页确认授权，返回回调中的 code 和 state
+func (m *mockOIDCIssuer) approve(t *testing.T, authURL string, claims jwt.MapClaims) (string, string) {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.Equal(t, mockOIDCClientID, query.Get("client_id"))
+	require.Equal(t, mockOIDCRedirectURL, query.Get("redirect_uri"))
+
+	code, err := oidc.GenerateRandomString()
+	require.NoError(t, err)
+	m.mu.Lock()
+	m.codes[code] = mockOIDCGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	m.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func useTestOIDC(issuer *mockOIDCIssuer, autoCreate bool, defaultRoles ...string) func() {
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	old := config.AppConfig.OIDC
+	config.AppConfig.OIDC = config.OIDCConfig{
+		Enabled:      true,
+		Name:         "Keycloak",
+		Issuer:       issuer.server.URL,
+		ClientID:     mockOIDCClientID,
+		ClientSecret: mockOIDCClientSecret,
+		RedirectURL:  mockOIDCRedirectURL,
+		AutoCreate:   autoCreate,
+		DefaultRoles: defaultRoles,
+	}
+	return func() { config.AppConfig.OIDC = old }
+}
+
+func setupOIDCRouter(db *gorm.DB) *gin.Engine {
+	r := setupSessionRouter(db)
+	authHandler := api.NewAuthHandler(db)
+	r.GET("/api/auth/idp", authHandler.GetIdentityProviders)
+	r.GET("/api/auth/idp/:provider/authorize", authHandler.IdentityAuthorize)
+	r.POST("/api/auth/idp/:provider/callback", authHandler.IdentityCallback)
+	r.POST("/api/auth/idp/:provider/bind", middleware.Auth(), authHandler.BindIdentity)
+	r.DELETE("/api/auth/idp/:provider/bind", middleware.Auth(), authHandler.UnbindIdentity)
+	r.GET("/api/auth/identities", middleware.Auth(), authHandler.GetMyIdentities)
+	return r
+}
+
+// idpBrowser 模拟浏览器：保存并携带授权流程写入的 Cookie
+type idpBrowser struct {
+	cookies map[string]*http.Cookie
+}
+
+func newIDPBrowser() *idpBrowser {
+	return &idpBrowser{cookies: map[string]*http.Cookie{}}
+}
+
+func (b *idpBrowser) do(t *testing.T, r *gin.Engine, method, path, token string, body interface{}) map[string]interface{} {
+	jsonData, _ := json.Marshal(body)
+	if body == nil {
+		jsonData = nil
+	}
+	req := httptest.NewRequest(method, path, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for _, cookie := range b.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+// oidcLogin 完成一次外部登录：发起授权、签发者确认、提交回调
+func oidcLogin(t *testing.T, r *gin.Engine, issuer *mockOIDCIssuer, claims jwt.MapClaims) map[string]interface{} {
+	browser := newIDPBrowser()
+	resp := browser.do(t, r, "GET", "/api/auth/idp/oidc/authorize", "", nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	code, state := issuer.approve(t, resp["data"].(map[string]interface{})["auth_url"].(string), claims)
+	return browser.do(t, r, "POST", "/api/auth/idp/oidc/callback", "", map[string]string{"code": code, "state": state})
+}
+
+func TestOIDC_LoginWithLinkedAccount(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	issuer := newMockOIDCIssuer(t)
+	defer useTestOIDC(issuer, false)()
+	r := setupOIDCRouter(db)
+
+	resp := doSessionRequest(t, r, "GET", "/api/auth/idp", "", nil)
+	require.Equal(t, float64(200), resp["code"])
+	assert.Contains(t, resp["data"], map[string]interface{}{"name": "oidc", "display_name": "Keycloak"})
+
+	// 未关联的账号不能登录
+	claims := jwt.MapClaims{"sub": "kc-alice", "preferred_username": "alice", "email": "alice@example.com"}
+	assert.Equal(t, float64(404), oidcLogin(t, r, issuer, claims)["code"])
+
+	// 已登录用户绑定外部账号
+	user := createSessionTestUser(t, db, "oidcalice", "Password123")
+	token, _ := sessionLogin(t, r, "oidcalice", "Password123")
+	browser := newIDPBrowser()
+	resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/bind", token, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	code, state := issuer.approve(t, resp["data"].(map[string]interface{})["auth_url"].(string), claims)
+
+	// 其他浏览器（没有发起授权时写入的 Cookie）不能提交回调
+	resp = newIDPBrowser().do(t, r, "POST", "/api/auth/idp/oidc/callback", token, map[string]string{"code": code, "state": state})
+	assert.Equal(t, float64(400), resp["code"])
+
+	resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/callback", token, map[string]string{"code": code, "state": state})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// 回调后 state Cookie 被清除；即使仍带着 Cookie，state 也只能使用一次
+	assert.NotContains(t, browser.cookies, "idp_state")
+	browser.cookies["idp_state"] = &http.Cookie{Name: "idp_state", Value: state}
+	resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/callback", token, map[string]string{"code": code, "state": state})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 绑定后可以使用外部账号登录
+	resp = oidcLogin(t, r, issuer, claims)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	oidcToken := resp["data"].(map[string]interface{})["token"].(string)
+	info := doSessionRequest(t, r, "GET", "/api/auth/user/info", oidcToken, nil)
+	assert.Equal(t, "oidcalice", info["data"].(map[string]interface{})["username"])
+	var session model.UserSession
+	require.NoError(t, db.Where("user_id = ? AND login_method = ?", user.ID, "oidc").First(&session).Error)
+
+	resp = doSessionRequest(t, r, "GET", "/api/auth/identities", oidcToken, nil)
+	subjects := map[string]interface{}{}
+	for _, identity := range resp["data"].([]interface{}) {
+		subjects[identity.(map[string]interface{})["provider"].(string)] = identity.(map[string]interface{})["subject"]
+	}
+	assert.Equal(t, "kc-alice", subjects["oidc"])
+	assert.Equal(t, *user.WeChatOpenID, subjects["wechat"]) // 测试用户默认绑定了微信
+
+	// 绑定回调必须由发起绑定的用户登录后提交
+	createSessionTestUser(t, db, "oidcbob", "Password123")
+	bobToken, _ := sessionLogin(t, r, "oidcbob", "Password123")
+	bobClaims := jwt.MapClaims{"sub": "kc-bob", "preferred_username": "bob"}
+	for _, callbackToken := range []string{"", token} {
+		browser = newIDPBrowser()
+		resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/bind", bobToken, nil)
+		code, state = issuer.approve(t, resp["data"].(map[string]interface{})["auth_url"].(string), bobClaims)
+		resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/callback", callbackToken, map[string]string{"code": code, "state": state})
+		assert.Equal(t, float64(403), resp["code"])
+	}
+	var linked int64
+	db.Model(&model.UserIdentity{}).Where("subject = ?", "kc-bob").Count(&linked)
+	assert.Equal(t, int64(0), linked)
+
+	// 同一个外部账号不能绑定到其他用户
+	browser = newIDPBrowser()
+	resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/bind", bobToken, nil)
+	code, state = issuer.approve(t, resp["data"].(map[string]interface{})["auth_url"].(string), claims)
+	resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/callback", bobToken, map[string]string{"code": code, "state": state})
+	assert.Equal(t, float64(409), resp["code"])
+	assert.Contains(t, resp["message"], "oidcalice")
+
+	// 解绑后不能再用外部账号登录
+	require.Equal(t, float64(200), doSessionRequest(t, r, "DELETE", "/api/auth/idp/oidc/bind", oidcToken, nil)["code"])
+	assert.Equal(t, float64(400), doSessionRequest(t, r, "DELETE", "/api/auth/idp/oidc/bind", oidcToken, nil)["code"])
+	assert.Equal(t, float64(404), oidcLogin(t, r, issuer, claims)["code"])
+}
+
+func TestOIDC_TokenValidationAndAutoCreate(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	issuer := newMockOIDCIssuer(t)
+	defer useTestOIDC(issuer, true, "oidc_member")()
+	createRoleWithPermissions(t, db, "oidc_member", "project:read")
+	r := setupOIDCRouter(db)
+	claims := jwt.MapClaims{"sub": "kc-carol", "preferred_username": "carol", "name": "Carol", "email": "carol@example.com"}
+
+	// nonce、受众不匹配或已过期的 ID Token 都被拒绝
+	issuer.Nonce = "other-nonce"
+	assert.Equal(t, float64(401), oidcLogin(t, r, issuer, claims)["code"])
+	issuer.Nonce = ""
+	issuer.Audience = "other-client"
+	assert.Equal(t, float64(401), oidcLogin(t, r, issuer, claims)["code"])
+	issuer.Audience = ""
+	issuer.TTL = -10 * time.Minute
+	assert.Equal(t, float64(401), oidcLogin(t, r, issuer, claims)["code"])
+	issuer.TTL = 0
+
+	// PKCE 验证码不匹配时签发者拒绝换取令牌
+	browser := newIDPBrowser()
+	resp := browser.do(t, r, "GET", "/api/auth/idp/oidc/authorize", "", nil)
+	code, state := issuer.approve(t, resp["data"].(map[string]interface{})["auth_url"].(string), claims)
+	require.NoError(t, db.Model(&model.IdentityAuthRequest{}).Where("state = ?", state).Update("code_verifier", "tampered").Error)
+	resp = browser.do(t, r, "POST", "/api/auth/idp/oidc/callback", "", map[string]string{"code": code, "state": state})
+	assert.Equal(t, float64(401), resp["code"])
+	assert.Contains(t, resp["message"], "invalid_grant")
+
+	// 开启自动创建时首次登录创建用户并分配默认角色
+	var count int64
+	db.Model(&model.User{}).Where("username = ?", "carol").Count(&count)
+	assert.Equal(t, int64(0), count)
+	resp = oidcLogin(t, r, issuer, claims)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, []interface{}{"oidc_member"}, resp["data"].(map[string]interface{})["user"].(map[string]interface{})["roles"])
+
+	var carol model.User
+	require.NoError(t, db.Where("username = ?", "carol").First(&carol).Error)
+	assert.Equal(t, utils.AuthSourceOIDC, carol.AuthSource)
+	assert.Equal(t, "Carol", carol.Nickname)
+	assert.Equal(t, "carol@example.com", carol.Email)
+
+	// 再次登录使用同一个用户
+	require.Equal(t, float64(200), oidcLogin(t, r, issuer, claims)["code"])
+	db.Model(&model.User{}).Where("username = ?", "carol").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// 用户名已被本地账号占用时不自动创建
+	CreateTestUser(t, db, "dave", "本地用户")
+	resp = oidcLogin(t, r, issuer, jwt.MapClaims{"sub": "kc-dave", "preferred_username": "dave"})
+	assert.Equal(t, float64(409), resp["code"])
+
+	// 禁用的用户不能登录
+	require.NoError(t, db.Model(&carol).Update("status", 0).Error)
+	assert.Equal(t, float64(403), oidcLogin(t, r, issuer, claims)["code"])
+}
@@ -0,0 +1,171 @@
+package unit
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+// capturePasswordResetChannel 记录发送内容的测试渠道
+type capturePasswordResetChannel struct {
+	name     string
+	messages []utils.PasswordResetMessage
+}
+
+func (c *capturePasswordResetChannel) Name() string { return c.name }
+
+func (c *capturePasswordResetChannel) Available(db *gorm.DB, user *model.User) bool {
+	return user.WeChatOpenID != nil && *user.WeChatOpenID != ""
+}
+
+func (c *capturePasswordResetChannel) Send(db *gorm.DB, user *model.User, message utils.PasswordResetMessage) error {
+	c.messages = append(c.messages, message)
+	return nil
+}
+
+// useTestPasswordReset 开启自助重置密码，返回恢复函数
+func useTestPasswordReset(cfg config.PasswordResetConfig, email config.EmailConfig) func() {
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	oldReset, oldEmail := config.AppConfig.PasswordReset, config.AppConfig.Email
+	config.AppConfig.PasswordReset = cfg
+	config.AppConfig.Email = email
+	return func() {
+		config.AppConfig.PasswordReset = oldReset
+		config.AppConfig.Email = oldEmail
+	}
+}
+
+func setupPasswordResetRouter(db *gorm.DB) *gin.Engine {
+	r := setupSessionRouter(db)
+	authHandler := api.NewAuthHandler(db)
+	r.POST("/api/auth/password-reset/request", authHandler.RequestPasswordReset)
+	r.POST("/api/auth/password-reset/verify", authHandler.VerifyPasswordResetToken)
+	r.POST("/api/auth/password-reset/confirm", authHandler.ConfirmPasswordReset)
+	return r
+}
+
+func TestPasswordReset_WeChatChannelFlow(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	require.NoError(t, utils.MigrateAuditDB(db, nil))
+	defer useTestPasswordReset(config.PasswordResetConfig{Enabled: true, ResetURL: "https://pm.example.com/reset"}, config.EmailConfig{})()
+	defer useTestLoginSecurity(config.LoginSecurityConfig{MaxFailures: 5, DelayBaseMillis: 1, MaxDelaySeconds: 1, IPMaxFailures: 100})()
+
+	channel := &capturePasswordResetChannel{name: utils.PasswordResetChannelWeChat}
+	original := utils.GetPasswordResetChannel(utils.PasswordResetChannelWeChat)
+	utils.RegisterPasswordResetChannel(channel)
+	defer utils.RegisterPasswordResetChannel(original)
+
+	r := setupPasswordResetRouter(db)
+	user := createSessionTestUser(t, db, "resetuser", "Before123")
+	oldToken, _ := sessionLogin(t, r, "resetuser", "Before123")
+
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/request", "", map[string]interface{}{"account": "resetuser"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	require.Len(t, channel.messages, 1)
+	message := channel.messages[0]
+	link, err := url.Parse(message.Link)
+	require.NoError(t, err)
+	assert.Equal(t, message.Token, link.Query().Get("token"))
+
+	// 只保存哈希
+	var stored model.PasswordResetToken
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&stored).Error)
+	assert.NotEqual(t, message.Token, stored.TokenHash)
+	assert.Equal(t, utils.PasswordResetChannelWeChat, stored.Channel)
+
+	// 间隔内再次申请不会重复发送
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/request", "", map[string]interface{}{"account": "resetuser"})
+	assert.Equal(t, float64(200), resp["code"])
+	assert.Len(t, channel.messages, 1)
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/verify", "", map[string]interface{}{"token": message.Token})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, "resetuser", resp["data"].(map[string]interface{})["username"])
+
+	// 弱密码被拒绝，令牌仍然可用
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/confirm", "", map[string]interface{}{"token": message.Token, "new_password": "weakpass"})
+	assert.Equal(t, float64(400), resp["code"])
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/confirm", "", map[string]interface{}{"token": message.Token, "new_password": "After1234"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// 原有会话失效，新密码可以登录
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", oldToken, nil)
+	assert.Equal(t, float64(401), resp["code"])
+	var session model.UserSession
+	require.NoError(t, db.Where("user_id = ?", user.ID).Order("id").First(&session).Error)
+	assert.Equal(t, utils.SessionRevokedPasswordReset, session.RevokedReason)
+	sessionLogin(t, r, "resetuser", "After1234")
+
+	// 令牌只能使用一次
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/confirm", "", map[string]interface{}{"token": message.Token, "new_password": "Again1234"})
+	assert.Equal(t, float64(400), resp["code"])
+
+	var audits []model.AuditLog
+	require.NoError(t, db.Where("user_id = ? AND action_type LIKE ?", user.ID, "password_reset%").Order("id").Find(&audits).Error)
+	require.Len(t, audits, 5)
+	// Success 字段受数据库默认值影响，按错误信息区分成功和失败
+	assert.Equal(t, "password_reset_request", audits[0].ActionType)
+	assert.Empty(t, audits[0].ErrorMsg)
+	assert.Equal(t, utils.ErrPasswordResetTooFrequent.Error(), audits[1].ErrorMsg)
+	assert.NotEmpty(t, audits[2].ErrorMsg) // 弱密码
+	assert.Equal(t, "password_reset", audits[3].ActionType)
+	assert.Empty(t, audits[3].ErrorMsg)
+	assert.Equal(t, utils.ErrPasswordResetTokenInvalid.Error(), audits[4].ErrorMsg)
+}
+
+func TestPasswordReset_EmailChannelAndIneligibleAccounts(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	defer useTestPasswordReset(config.PasswordResetConfig{Enabled: true}, config.EmailConfig{Enabled: true, Host: "smtp.example.com", SiteURL: "https://pm.example.com/"})()
+	defer useTestLoginSecurity(config.LoginSecurityConfig{MaxFailures: 5, DelayBaseMillis: 1, MaxDelaySeconds: 1, IPMaxFailures: 100})()
+
+	r := setupPasswordResetRouter(db)
+	user := createSessionTestUser(t, db, "mailreset", "Before123")
+	require.NoError(t, db.Model(user).Update("email", "mailreset@example.com").Error)
+	ldapUser := createSessionTestUser(t, db, "ldapreset", "Before123")
+	require.NoError(t, db.Model(ldapUser).Updates(map[string]interface{}{"email": "ldapreset@example.com", "auth_source": utils.AuthSourceLDAP}).Error)
+
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/request", "", map[string]interface{}{"account": "MailReset@example.com"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	message := resp["data"].(map[string]interface{})["message"]
+
+	var emails []model.EmailMessage
+	require.NoError(t, db.Where("event_type = ?", "password_reset").Find(&emails).Error)
+	require.Len(t, emails, 1)
+	assert.Equal(t, "mailreset@example.com", emails[0].To)
+	assert.Equal(t, utils.EmailStatusPending, emails[0].Status)
+	assert.Contains(t, emails[0].TextBody, "https://pm.example.com/reset-password?token=")
+
+	// 不存在的账号和LDAP账号返回相同的提示，但不会生成令牌
+	for _, account := range []string{"nobody@example.com", "ldapreset"} {
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/request", "", map[string]interface{}{"account": account})
+		assert.Equal(t, float64(200), resp["code"])
+		assert.Equal(t, message, resp["data"].(map[string]interface{})["message"])
+	}
+	var count int64
+	db.Model(&model.PasswordResetToken{}).Where("user_id = ?", ldapUser.ID).Count(&count)
+	assert.Zero(t, count)
+
+	// 过期的令牌无法使用
+	token := emails[0].TextBody[strings.Index(emails[0].TextBody, "token=")+len("token="):]
+	token = strings.Fields(token)[0]
+	require.NoError(t, db.Model(&model.PasswordResetToken{}).Where("user_id = ?", user.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/password-reset/confirm", "", map[string]interface{}{"token": token, "new_password": "After1234"})
+	assert.Equal(t, float64(400), resp["code"])
+}
@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/pkg/permission"
+)
+
+func TestPermissionCache_RolePermissions(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	require.NoError(t, permission.EnableCache(db))
+
+	role := createRoleWithPermissions(t, db, "cache_dev", "bug:read")
+	perms, err := permission.GetRolePermissions(db, []string{"cache_dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug:read"}, perms)
+	perms, err = permission.GetRolePermissions(db, []string{"cache_dev", "cache_dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug:read"}, perms)
+
+	stats := permission.GetCacheStats(db)
+	assert.True(t, stats.Enabled)
+	assert.Equal(t, uint64(1), stats.RolePermissions.Misses)
+	assert.Equal(t, uint64(1), stats.RolePermissions.Hits)
+	assert.Equal(t, 1, stats.RolePermissions.Entries)
+
+	// 分配角色权限后缓存失效
+	var update model.Permission
+	require.NoError(t, db.Where("code = ?", "bug:update").First(&update).Error)
+	handler := api.NewPermissionHandler(db)
+	resp := callJSONHandler(t, handler.AssignRolePermissions, 1, []string{"admin"}, http.MethodPost, "/api/permissions/roles/permissions",
+		gin.Params{{Key: "id", Value: fmt.Sprintf("%d", role.ID)}}, map[string]interface{}{"permission_ids": []uint{update.ID}})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	perms, err = permission.GetRolePermissions(db, []string{"cache_dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug:update"}, perms)
+
+	// 绕过接口直接修改关联表同样会使缓存失效
+	var read model.Permission
+	require.NoError(t, db.Where("code = ?", "bug:read").First(&read).Error)
+	require.NoError(t, db.Model(role).Association("Permissions").Append(&read))
+	perms, err = permission.GetRolePermissions(db, []string{"cache_dev"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bug:read", "bug:update"}, perms)
+
+	// 分配用户角色也会使缓存失效
+	user := CreateTestUser(t, db, "cacheuser", "缓存用户")
+	before := permission.GetCacheStats(db).Invalidations
+	resp = callJSONHandler(t, handler.AssignUserRoles, 1, []string{"admin"}, http.MethodPost, "/api/permissions/users/roles",
+		gin.Params{{Key: "id", Value: fmt.Sprintf("%d", user.ID)}}, map[string]interface{}{"role_ids": []uint{role.ID}})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Greater(t, permission.GetCacheStats(db).Invalidations, before)
+	assert.Equal(t, 0, permission.GetCacheStats(db).RolePermissions.Entries)
+}
+
+func TestPermissionCache_Memberships(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	require.NoError(t, permission.EnableCache(db))
+
+	project := CreateTestProject(t, db, "缓存项目")
+	owner := CreateTestUser(t, db, "cacheowner", "负责人")
+	user := CreateTestUser(t, db, "cachemember", "成员")
+	AddUserToProject(t, db, owner.ID, project.ID, utils.ProjectRoleOwner)
+
+	assert.Empty(t, utils.GetUserProjectIDs(db, user.ID))
+	assert.Empty(t, utils.GetUserProjectIDs(db, user.ID))
+	stats := permission.GetCacheStats(db)
+	assert.Equal(t, uint64(1), stats.Memberships.Misses)
+	assert.Equal(t, uint64(1), stats.Memberships.Hits)
+
+	// 添加成员后立即生效
+	handler := api.NewProjectHandler(db)
+	projectParams := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}
+	resp := callJSONHandler(t, handler.AddProjectMembers, owner.ID, []string{"developer"}, http.MethodPost, "/api/projects/members",
+		projectParams, map[string]interface{}{"user_ids": []uint{user.ID}, "role": utils.ProjectRoleViewer})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, []uint{project.ID}, utils.GetUserProjectIDs(db, user.ID))
+	role, ok := utils.GetProjectMemberRole(db, project.ID, user.ID)
+	assert.True(t, ok)
+	assert.Equal(t, utils.ProjectRoleViewer, role)
+
+	// 移除成员后立即失去访问权限
+	var member model.ProjectMember
+	require.NoError(t, db.Where("project_id = ? AND user_id = ?", project.ID, user.ID).First(&member).Error)
+	resp = callJSONHandler(t, handler.RemoveProjectMember, owner.ID, []string{"developer"}, http.MethodDelete, "/api/projects/members",
+		gin.Params{{Key: "id", Value: fmt.Sprintf("%d", project.ID)}, {Key: "member_id", Value: fmt.Sprintf("%d", member.ID)}}, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Empty(t, utils.GetUserProjectIDs(db, user.ID))
+
+	// 统计接口和手动清空
+	system := api.NewSystemHandler(db)
+	resp = callJSONHandler(t, system.GetPermissionCacheStats, owner.ID, []string{"admin"}, http.MethodGet, "/api/system/permission-cache", nil, nil)
+	require.Equal(t, float64(200), resp["code"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, true, data["enabled"])
+	assert.Greater(t, data["memberships"].(map[string]interface{})["hits"], float64(0))
+	resp = callJSONHandler(t, system.ClearPermissionCache, owner.ID, []string{"admin"}, http.MethodDelete, "/api/system/permission-cache", nil, nil)
+	require.Equal(t, float64(200), resp["code"])
+	assert.Equal(t, float64(0), resp["data"].(map[string]interface{})["memberships"].(map[string]interface{})["entries"])
+}
+
+func TestPermissionCache_ProjectRolePermissions(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	require.NoError(t, permission.EnableCache(db))
+
+	assert.False(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:create"))
+	assert.False(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:create"))
+	stats := permission.GetCacheStats(db)
+	assert.Equal(t, uint64(1), stats.ProjectRoles.Misses)
+	assert.Equal(t, uint64(1), stats.ProjectRoles.Hits)
+	assert.Equal(t, len(utils.ProjectRoles), stats.ProjectRoles.Entries)
+
+	// 保存映射后立即生效
+	system := api.NewSystemHandler(db)
+	resp := callJSONHandler(t, system.SaveProjectRolePermissions, 1, []string{"admin"}, http.MethodPut, "/api/system/project-role-permissions",
+		nil, map[string]interface{}{utils.ProjectRoleViewer: []string{"*:read", "bug:create"}})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.True(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:create"))
+
+	// 绕过接口直接修改系统配置同样会使缓存失效
+	require.NoError(t, utils.SaveProjectRolePermissions(db, map[string][]string{utils.ProjectRoleViewer: {"*:read"}}))
+	assert.False(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:create"))
+}
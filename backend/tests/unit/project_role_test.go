@@ -0,0 +1,213 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestProjectRole_ViewerCannotEditBugs(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "项目角色")
+	creator := CreateTestUser(t, db, "prcreator", "创建人")
+	user := CreateTestUser(t, db, "prviewer", "查看者")
+	member := AddUserToProject(t, db, user.ID, project.ID, utils.ProjectRoleViewer)
+	bug := model.Bug{Title: "登录失败", ProjectID: project.ID, CreatorID: creator.ID}
+	require.NoError(t, db.Create(&bug).Error)
+
+	handler := api.NewBugHandler(db)
+	roles := []string{"developer"}
+	params := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", bug.ID)}}
+	path := fmt.Sprintf("/api/bugs/%d", bug.ID)
+
+	// 查看者可以读取Bug，但即使全局角色有 bug:update 也不能修改
+	resp := callJSONHandler(t, handler.GetBug, user.ID, roles, http.MethodGet, path, params, nil)
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, handler.UpdateBug, user.ID, roles, http.MethodPut, path, params, map[string]interface{}{"title": "已修改"})
+	assert.Equal(t, float64(403), resp["code"])
+	assert.Contains(t, resp["message"], "bug:update")
+	resp = callJSONHandler(t, handler.CreateBug, user.ID, roles, http.MethodPost, "/api/bugs", nil, map[string]interface{}{"title": "新Bug", "project_id": project.ID, "version_ids": []uint{1}})
+	assert.Equal(t, float64(403), resp["code"])
+
+	// 成员可以修改，但不能管理项目成员
+	require.NoError(t, db.Model(member).Update("role", utils.ProjectRoleMember).Error)
+	resp = callJSONHandler(t, handler.UpdateBug, user.ID, roles, http.MethodPut, path, params, map[string]interface{}{"title": "已修改"})
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+	projectHandler := api.NewProjectHandler(db)
+	resp = callJSONHandler(t, projectHandler.AddProjectMembers, user.ID, roles, http.MethodPost, "/api/projects/members",
+		gin.Params{{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}, map[string]interface{}{"user_ids": []uint{creator.ID}, "role": "member"})
+	assert.Equal(t, float64(403), resp["code"])
+
+	// 历史数据中的角色值：项目经理按负责人处理，未知角色按成员处理
+	assert.Equal(t, utils.ProjectRoleOwner, utils.NormalizeProjectRole("项目经理"))
+	assert.Equal(t, utils.ProjectRoleMember, utils.NormalizeProjectRole("developer"))
+
+	// 不接受未知的项目角色
+	owner := CreateTestUser(t, db, "prowner", "负责人")
+	AddUserToProject(t, db, owner.ID, project.ID, utils.ProjectRoleOwner)
+	resp = callJSONHandler(t, projectHandler.AddProjectMembers, owner.ID, roles, http.MethodPost, "/api/projects/members",
+		gin.Params{{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}, map[string]interface{}{"user_ids": []uint{creator.ID}, "role": "manager"})
+	assert.Equal(t, float64(400), resp["code"])
+}
+
+func TestProjectRole_ViewerCannotManageBoardsAndVersions(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "看板版本角色")
+	user := CreateTestUser(t, db, "prboardviewer", "查看者")
+	member := AddUserToProject(t, db, user.ID, project.ID, utils.ProjectRoleViewer)
+	board := model.Board{Name: "看板", ProjectID: project.ID}
+	require.NoError(t, db.Create(&board).Error)
+	column := model.BoardColumn{Name: "进行中", Status: "doing", BoardID: board.ID}
+	require.NoError(t, db.Create(&column).Error)
+	task := model.Task{Title: "任务", Status: "wait", ProjectID: project.ID, CreatorID: user.ID}
+	require.NoError(t, db.Create(&task).Error)
+	version := model.Version{VersionNumber: "v1.0", Status: "wait", ProjectID: project.ID}
+	require.NoError(t, db.Create(&version).Error)
+	module := model.Module{Name: "登录模块", Status: 1}
+	require.NoError(t, db.Create(&module).Error)
+	require.NoError(t, db.Create(&model.Bug{Title: "模块Bug", ProjectID: project.ID, CreatorID: user.ID, ModuleID: &module.ID}).Error)
+
+	// 全局角色拥有全部权限，但项目角色是查看者
+	roles := []string{"developer"}
+	boardHandler := api.NewBoardHandler(db)
+	versionHandler := api.NewVersionHandler(db)
+	moduleHandler := api.NewModuleHandler(db)
+	boardParams := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", board.ID)}}
+	columnParams := append(boardParams, gin.Param{Key: "column_id", Value: fmt.Sprintf("%d", column.ID)})
+	versionParams := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", version.ID)}}
+	moduleParams := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", module.ID)}}
+	projectParams := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}
+
+	denied := []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		params  gin.Params
+		body    interface{}
+	}{
+		{"移动任务", boardHandler.MoveTask, http.MethodPatch, append(boardParams[:1:1], gin.Param{Key: "task_id", Value: fmt.Sprintf("%d", task.ID)}), map[string]interface{}{"column_id": fmt.Sprintf("%d", column.ID)}},
+		{"创建看板", boardHandler.CreateBoard, http.MethodPost, projectParams, map[string]interface{}{"name": "新看板"}},
+		{"修改看板", boardHandler.UpdateBoard, http.MethodPut, boardParams, map[string]interface{}{"name": "改名"}},
+		{"删除看板", boardHandler.DeleteBoard, http.MethodDelete, boardParams, nil},
+		{"创建列", boardHandler.CreateBoardColumn, http.MethodPost, boardParams, map[string]interface{}{"name": "完成", "status": "done"}},
+		{"修改列", boardHandler.UpdateBoardColumn, http.MethodPut, columnParams, map[string]interface{}{"name": "改名"}},
+		{"删除列", boardHandler.DeleteBoardColumn, http.MethodDelete, columnParams, nil},
+		{"创建版本", versionHandler.CreateVersion, http.MethodPost, nil, map[string]interface{}{"version_number": "v2.0", "project_id": project.ID}},
+		{"修改版本", versionHandler.UpdateVersion, http.MethodPut, versionParams, map[string]interface{}{"release_notes": "说明"}},
+		{"修改版本状态", versionHandler.UpdateVersionStatus, http.MethodPatch, versionParams, map[string]interface{}{"status": "normal"}},
+		{"发布版本", versionHandler.ReleaseVersion, http.MethodPost, versionParams, nil},
+		{"删除版本", versionHandler.DeleteVersion, http.MethodDelete, versionParams, nil},
+		{"修改模块", moduleHandler.UpdateModule, http.MethodPut, moduleParams, map[string]interface{}{"description": "说明"}},
+		{"删除模块", moduleHandler.DeleteModule, http.MethodDelete, moduleParams, nil},
+	}
+	for _, tc := range denied {
+		resp := callJSONHandler(t, tc.handler, user.ID, roles, tc.method, "/api/project-role", tc.params, tc.body)
+		assert.Equal(t, float64(403), resp["code"], tc.name)
+	}
+	var reloaded model.Task
+	require.NoError(t, db.First(&reloaded, task.ID).Error)
+	assert.Equal(t, "wait", reloaded.Status)
+
+	// 成员可以移动任务和修改版本，但管理看板需要负责人
+	require.NoError(t, db.Model(member).Update("role", utils.ProjectRoleMember).Error)
+	resp := callJSONHandler(t, boardHandler.MoveTask, user.ID, roles, http.MethodPatch, "/api/project-role", denied[0].params, denied[0].body)
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, versionHandler.UpdateVersion, user.ID, roles, http.MethodPut, "/api/project-role", versionParams, map[string]interface{}{"release_notes": "说明"})
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, boardHandler.UpdateBoard, user.ID, roles, http.MethodPut, "/api/project-role", boardParams, map[string]interface{}{"name": "改名"})
+	assert.Equal(t, float64(403), resp["code"])
+}
+
+func TestProjectRole_ExplainAccess(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "访问说明")
+	role := createRoleWithPermissions(t, db, "pr_dev", "project:read", "bug:read", "bug:update")
+	viewer := CreateTestUser(t, db, "prexplain", "查看者")
+	require.NoError(t, db.Model(viewer).Association("Roles").Append(role))
+	outsider := CreateTestUser(t, db, "proutsider", "外部用户")
+	require.NoError(t, db.Model(outsider).Association("Roles").Append(role))
+	AddUserToProject(t, db, viewer.ID, project.ID, utils.ProjectRoleViewer)
+
+	handler := api.NewProjectHandler(db)
+	params := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}
+	explain := func(userID uint, roles []string, query string) map[string]interface{} {
+		return callJSONHandler(t, handler.GetProjectAccess, userID, roles, http.MethodGet, "/api/projects/access?"+query, params, nil)
+	}
+	reason := func(resp map[string]interface{}) string {
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		return resp["data"].(map[string]interface{})["reason"].(string)
+	}
+
+	resp := explain(viewer.ID, []string{"pr_dev"}, "permission=bug:read")
+	assert.Equal(t, utils.ProjectAccessRoleGranted, reason(resp))
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["allowed"])
+
+	resp = explain(viewer.ID, []string{"pr_dev"}, "permission=bug:update")
+	assert.Equal(t, utils.ProjectAccessRoleDenied, reason(resp))
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, false, data["allowed"])
+	assert.Equal(t, true, data["global_permission"])
+	assert.Equal(t, utils.ProjectRoleViewer, data["project_role"])
+
+	assert.Equal(t, utils.ProjectAccessNoGlobalPermission, reason(explain(viewer.ID, []string{"pr_dev"}, "permission=bug:delete")))
+	// 非项目成员不受项目角色限制，与实际的权限检查一致
+	resp = explain(outsider.ID, []string{"pr_dev"}, "permission=bug:update")
+	assert.Equal(t, utils.ProjectAccessNotMember, reason(resp))
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["allowed"])
+	outsiderCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	outsiderCtx.Set("user_id", outsider.ID)
+	outsiderCtx.Set("roles", []string{"pr_dev"})
+	assert.True(t, utils.CheckProjectPermission(db, outsiderCtx, project.ID, "bug:update"))
+
+	// 普通成员不能查看其他用户的访问决策，管理员可以
+	resp = explain(viewer.ID, []string{"pr_dev"}, fmt.Sprintf("permission=bug:read&user_id=%d", outsider.ID))
+	assert.Equal(t, float64(403), resp["code"])
+	admin := CreateTestAdminUser(t, db, "pradmin", "管理员")
+	assert.Equal(t, utils.ProjectAccessRoleDenied, reason(explain(admin.ID, []string{"admin"}, fmt.Sprintf("permission=bug:update&user_id=%d", viewer.ID))))
+	assert.Equal(t, utils.ProjectAccessAdmin, reason(explain(admin.ID, []string{"admin"}, "permission=bug:update")))
+
+	resp = explain(viewer.ID, []string{"pr_dev"}, "permission=no:such")
+	assert.Equal(t, float64(400), resp["code"])
+}
+
+func TestProjectRole_CustomPermissions(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "prsettings", "管理员")
+	handler := api.NewSystemHandler(db)
+	save := func(body interface{}) map[string]interface{} {
+		return callJSONHandler(t, handler.SaveProjectRolePermissions, admin.ID, []string{"admin"}, http.MethodPut, "/api/system/project-role-permissions", nil, body)
+	}
+
+	assert.Equal(t, float64(400), save(map[string][]string{"manager": {"*:read"}})["code"])
+	assert.Equal(t, float64(400), save(map[string][]string{"viewer": {"bug"}})["code"])
+	assert.Equal(t, float64(400), save(map[string][]string{"viewer": {"no:such"}})["code"])
+
+	assert.False(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:update"))
+	resp := save(map[string][]string{"viewer": {"*:read", "bug:update"}})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.True(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:update"))
+	assert.False(t, utils.ProjectRoleAllows(db, utils.ProjectRoleViewer, "bug:delete"))
+
+	// 未覆盖的角色继续使用默认权限
+	permissions := utils.GetProjectRolePermissions(db)
+	assert.Equal(t, utils.DefaultProjectRolePermissions[utils.ProjectRoleMember], permissions[utils.ProjectRoleMember])
+	assert.True(t, utils.ProjectRoleAllows(db, utils.ProjectRoleOwner, "project:manage"))
+	assert.False(t, utils.ProjectRoleAllows(db, utils.ProjectRoleMember, "project:manage"))
+}
@@ -485,9 +485,9 @@ func TestProjectHandler_DeleteProject(t *testing.T) {
 	handler := api.NewProjectHandler(db)
 
 	t.Run("删除项目成功", func(t *testing.T) {
-		// 创建用户并添加到项目（作为项目成员）
+		// 创建用户并添加到项目（作为项目负责人，只有负责人可以删除项目）
 		user := CreateTestUser(t, db, "deleteproject", "删除项目用户")
-		AddUserToProject(t, db, user.ID, project.ID, "member")
+		AddUserToProject(t, db, user.ID, project.ID, "owner")
 
 		gin.SetMode(gin.TestMode)
 		w := httptest.NewRecorder()
@@ -636,9 +636,9 @@ func TestProjectHandler_AddProjectMembers(t *testing.T) {
 	handler := api.NewProjectHandler(db)
 
 	t.Run("添加项目成员成功", func(t *testing.T) {
-		// 创建用户并添加到项目（作为项目成员，用于添加其他成员）
+		// 创建用户并添加到项目（作为项目负责人，用于添加其他成员）
 		adminUser := CreateTestUser(t, db, "addmemberadmin", "添加成员管理员")
-		AddUserToProject(t, db, adminUser.ID, project.ID, "member")
+		AddUserToProject(t, db, adminUser.ID, project.ID, "owner")
 
 		gin.SetMode(gin.TestMode)
 		w := httptest.NewRecorder()
@@ -648,7 +648,7 @@ func TestProjectHandler_AddProjectMembers(t *testing.T) {
 
 		reqBody := map[string]interface{}{
 			"user_ids": []uint{user1.ID, user2.ID},
-			"role":     "developer",
+			"role":     "member",
 		}
 		jsonData, _ := json.Marshal(reqBody)
 		c.Request = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/projects/%d/members", project.ID), bytes.NewBuffer(jsonData))
@@ -678,16 +678,16 @@ func TestProjectHandler_UpdateProjectMember(t *testing.T) {
 	member := &model.ProjectMember{
 		ProjectID: project.ID,
 		UserID:    user.ID,
-		Role:      "developer",
+		Role:      "member",
 	}
 	db.Create(&member)
 
 	handler := api.NewProjectHandler(db)
 
 	t.Run("更新项目成员成功", func(t *testing.T) {
-		// 创建另一个用户作为项目成员（用于更新操作）
+		// 创建另一个用户作为项目负责人（用于更新操作）
 		updaterUser := CreateTestUser(t, db, "updatemember2", "更新成员用户2")
-		AddUserToProject(t, db, updaterUser.ID, project.ID, "member")
+		AddUserToProject(t, db, updaterUser.ID, project.ID, "owner")
 
 		gin.SetMode(gin.TestMode)
 		w := httptest.NewRecorder()
@@ -696,7 +696,7 @@ func TestProjectHandler_UpdateProjectMember(t *testing.T) {
 		c.Set("roles", []string{"developer"})
 
 		reqBody := map[string]interface{}{
-			"role": "manager",
+			"role": "viewer",
 		}
 		jsonData, _ := json.Marshal(reqBody)
 		c.Request = httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/projects/%d/members/%d", project.ID, member.ID), bytes.NewBuffer(jsonData))
@@ -714,7 +714,7 @@ func TestProjectHandler_UpdateProjectMember(t *testing.T) {
 		var updatedMember model.ProjectMember
 		err := db.First(&updatedMember, member.ID).Error
 		assert.NoError(t, err)
-		assert.Equal(t, "manager", updatedMember.Role)
+		assert.Equal(t, "viewer", updatedMember.Role)
 	})
 }
 
@@ -729,16 +729,16 @@ func TestProjectHandler_RemoveProjectMember(t *testing.T) {
 	member := &model.ProjectMember{
 		ProjectID: project.ID,
 		UserID:    user.ID,
-		Role:      "developer",
+		Role:      "member",
 	}
 	db.Create(&member)
 
 	handler := api.NewProjectHandler(db)
 
 	t.Run("移除项目成员成功", func(t *testing.T) {
-		// 创建另一个用户作为项目成员（用于移除操作）
+		// 创建另一个用户作为项目负责人（用于移除操作）
 		removerUser := CreateTestUser(t, db, "removemember2", "移除成员用户2")
-		AddUserToProject(t, db, removerUser.ID, project.ID, "member")
+		AddUserToProject(t, db, removerUser.ID, project.ID, "owner")
 
 		gin.SetMode(gin.TestMode)
 		w := httptest.NewRecorder()
@@ -0,0 +1,201 @@
+package unit
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestReviewOutcome_Rules(t *testing.T) {
+	pass, reject, clarify := utils.ReviewVerdictPass, utils.ReviewVerdictReject, utils.ReviewVerdictClarify
+
+	_, done := utils.ReviewOutcome(utils.ReviewRuleAll, []string{pass, utils.ReviewVerdictPending})
+	assert.False(t, done)
+
+	result, _ := utils.ReviewOutcome(utils.ReviewRuleAll, []string{pass, pass})
+	assert.Equal(t, pass, result)
+	result, _ = utils.ReviewOutcome(utils.ReviewRuleAll, []string{pass, clarify, reject})
+	assert.Equal(t, reject, result)
+	result, _ = utils.ReviewOutcome(utils.ReviewRuleAll, []string{pass, clarify})
+	assert.Equal(t, clarify, result)
+
+	result, _ = utils.ReviewOutcome(utils.ReviewRuleMajority, []string{pass, pass, reject})
+	assert.Equal(t, pass, result)
+	result, _ = utils.ReviewOutcome(utils.ReviewRuleMajority, []string{pass, reject})
+	assert.Equal(t, reject, result)
+	result, _ = utils.ReviewOutcome(utils.ReviewRuleMajority, []string{pass, clarify, clarify})
+	assert.Equal(t, clarify, result)
+}
+
+func TestRequirementReview_RoundsAndAutoStatus(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "reviewadmin", "评审管理员")
+	reviewer1 := CreateTestAdminUser(t, db, "reviewer1", "评审人1")
+	reviewer2 := CreateTestAdminUser(t, db, "reviewer2", "评审人2")
+	reviewer3 := CreateTestAdminUser(t, db, "reviewer3", "评审人3")
+	project := CreateTestProject(t, db, "评审项目")
+	requirement := &model.Requirement{Title: "登录", ProjectID: project.ID, CreatorID: admin.ID, Status: "draft"}
+	require.NoError(t, db.Create(requirement).Error)
+
+	handler := api.NewRequirementHandler(db)
+	params := idParams(requirement.ID)
+	start := func(body map[string]interface{}) map[string]interface{} {
+		return callJSONHandler(t, handler.StartRequirementReview, admin.ID, []string{"admin"}, http.MethodPost, "/api/requirements/reviews", params, body)
+	}
+	vote := func(userID uint, verdict, comment string) map[string]interface{} {
+		return callJSONHandler(t, handler.SubmitRequirementReview, userID, []string{"admin"}, http.MethodPost, "/api/requirements/reviews/vote", params,
+			map[string]interface{}{"verdict": verdict, "comment": comment})
+	}
+	status := func() string {
+		var current model.Requirement
+		require.NoError(t, db.First(&current, requirement.ID).Error)
+		return current.Status
+	}
+
+	// 第一轮：全部通过规则，有人要求澄清
+	resp := start(map[string]interface{}{"reviewer_ids": []uint{reviewer1.ID, reviewer2.ID}, "rule": "unanimous"})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = start(map[string]interface{}{"reviewer_ids": []uint{reviewer1.ID, reviewer2.ID}, "comment": "请评审"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	round := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(1), round["round"])
+	assert.Equal(t, utils.ReviewRuleAll, round["rule"])
+	assert.Len(t, round["votes"], 2)
+	assert.Equal(t, "reviewing", status())
+
+	resp = start(map[string]interface{}{"reviewer_ids": []uint{reviewer3.ID}})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateRequirementStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/requirements/status", params, map[string]interface{}{"status": "active"})
+	assert.Equal(t, float64(400), resp["code"])
+	assert.Equal(t, float64(403), vote(reviewer3.ID, utils.ReviewVerdictPass, "")["code"])
+	assert.Equal(t, float64(400), vote(reviewer1.ID, utils.ReviewVerdictClarify, "")["code"])
+
+	require.Equal(t, float64(200), vote(reviewer1.ID, utils.ReviewVerdictPass, "")["code"])
+	assert.Equal(t, "reviewing", status())
+	resp = vote(reviewer2.ID, utils.ReviewVerdictClarify, "验收标准不明确")
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	round = resp["data"].(map[string]interface{})
+	assert.Equal(t, utils.ReviewStatusFinished, round["status"])
+	assert.Equal(t, utils.ReviewVerdictClarify, round["result"])
+	assert.Equal(t, "draft", status())
+
+	// 第二轮：多数通过规则
+	resp = start(map[string]interface{}{"reviewer_ids": []uint{reviewer1.ID, reviewer2.ID, reviewer3.ID}, "rule": utils.ReviewRuleMajority})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(2), resp["data"].(map[string]interface{})["round"])
+	require.Equal(t, float64(200), vote(reviewer1.ID, utils.ReviewVerdictReject, "不需要")["code"])
+	require.Equal(t, float64(200), vote(reviewer2.ID, utils.ReviewVerdictPass, "")["code"])
+	require.Equal(t, float64(200), vote(reviewer3.ID, utils.ReviewVerdictPass, "")["code"])
+	assert.Equal(t, "active", status())
+
+	resp = callJSONHandler(t, handler.GetRequirementReviews, admin.ID, []string{"admin"}, http.MethodGet, "/api/requirements/reviews", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	reviews := resp["data"].([]interface{})
+	require.Len(t, reviews, 2)
+	assert.Equal(t, utils.ReviewVerdictPass, reviews[0].(map[string]interface{})["result"])
+
+	// 评审过程记录到操作记录和历史中
+	var actions []model.Action
+	require.NoError(t, db.Preload("Histories").Where("object_type = ? AND object_id = ?", "requirement", requirement.ID).Order("id").Find(&actions).Error)
+	counts := make(map[string]int)
+	for _, action := range actions {
+		counts[action.Action]++
+	}
+	assert.Equal(t, 2, counts["review_started"])
+	assert.Equal(t, 5, counts["reviewed"])
+	assert.Equal(t, 2, counts["review_finished"])
+	last := actions[len(actions)-1]
+	assert.Equal(t, "review_finished", last.Action)
+	require.Len(t, last.Histories, 2)
+	assert.Equal(t, "review_result", last.Histories[0].Field)
+	assert.Equal(t, "status", last.Histories[1].Field)
+	assert.Equal(t, "active", last.Histories[1].New)
+
+	// 取消评审后恢复为发起评审前的状态
+	require.Equal(t, float64(200), start(map[string]interface{}{"reviewer_ids": []uint{reviewer1.ID}})["code"])
+	assert.Equal(t, "reviewing", status())
+	resp = callJSONHandler(t, handler.CancelRequirementReview, admin.ID, []string{"admin"}, http.MethodPost, "/api/requirements/reviews/cancel", params, map[string]interface{}{"comment": "需求调整"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, utils.ReviewStatusCancelled, resp["data"].(map[string]interface{})["status"])
+	assert.Equal(t, "active", status())
+	assert.Equal(t, float64(400), vote(reviewer1.ID, utils.ReviewVerdictPass, "")["code"])
+}
+
+func TestRequirementReview_ReviewerAccessAndWorkflow(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "reviewadmin2", "评审管理员")
+	owner := CreateTestUser(t, db, "reviewowner", "负责人")
+	member := CreateTestUser(t, db, "reviewmember", "成员")
+	outsider := CreateTestUser(t, db, "reviewoutsider", "非成员")
+	project := CreateTestProject(t, db, "评审项目2")
+	AddUserToProject(t, db, owner.ID, project.ID, utils.ProjectRoleOwner)
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleMember)
+
+	// 项目自定义需求工作流：只有项目负责人可以把评审中的需求激活
+	workflow := model.Workflow{Name: "评审流程", ObjectType: "requirement", ProjectID: &project.ID, InitialState: "draft", Status: 1,
+		States: []model.WorkflowState{
+			{Code: "draft", Name: "草稿", Category: "open", Sort: 0},
+			{Code: "reviewing", Name: "评审中", Category: "open", Sort: 1},
+			{Code: "active", Name: "激活", Category: "doing", Sort: 2},
+		},
+		Transitions: []model.WorkflowTransition{
+			{FromState: "draft", ToState: "reviewing"},
+			{FromState: "reviewing", ToState: "draft"},
+			{FromState: "reviewing", ToState: "active", AllowedRoles: model.StringArray{"project:owner"}},
+		}}
+	require.NoError(t, db.Create(&workflow).Error)
+
+	requirement := &model.Requirement{Title: "导出", ProjectID: project.ID, CreatorID: admin.ID, Status: "draft"}
+	require.NoError(t, db.Create(requirement).Error)
+
+	handler := api.NewRequirementHandler(db)
+	params := idParams(requirement.ID)
+	start := func(reviewerIDs ...uint) map[string]interface{} {
+		return callJSONHandler(t, handler.StartRequirementReview, admin.ID, []string{"admin"}, http.MethodPost, "/api/requirements/reviews", params,
+			map[string]interface{}{"reviewer_ids": reviewerIDs})
+	}
+	vote := func(userID uint) map[string]interface{} {
+		return callJSONHandler(t, handler.SubmitRequirementReview, userID, []string{"user"}, http.MethodPost, "/api/requirements/reviews/vote", params,
+			map[string]interface{}{"verdict": utils.ReviewVerdictPass})
+	}
+	status := func() string {
+		var current model.Requirement
+		require.NoError(t, db.First(&current, requirement.ID).Error)
+		return current.Status
+	}
+
+	// 评审人必须能访问需求所在项目（管理员或项目成员）
+	assert.Equal(t, float64(400), start(member.ID, outsider.ID)["code"])
+	assert.Equal(t, "draft", status())
+
+	// 成员不能执行 reviewing -> active：评审结论照常记录，需求状态保持不变
+	resp := start(member.ID, admin.ID)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	require.Equal(t, float64(200), vote(admin.ID)["code"])
+	resp = vote(member.ID)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	round := resp["data"].(map[string]interface{})
+	assert.Equal(t, utils.ReviewStatusFinished, round["status"])
+	assert.Equal(t, utils.ReviewVerdictPass, round["result"])
+	assert.Equal(t, "reviewing", status())
+
+	// 负责人给出最后一票时按工作流激活需求
+	require.Equal(t, float64(200), start(owner.ID)["code"])
+	resp = vote(owner.ID)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, "active", status())
+
+	var finished int64
+	db.Model(&model.RequirementReview{}).Where("requirement_id = ? AND status = ?", requirement.ID, utils.ReviewStatusFinished).Count(&finished)
+	assert.Equal(t, int64(2), finished)
+}
@@ -0,0 +1,198 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestRequirementTree_HierarchyValidation(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "reqtreeadmin", "需求树管理员")
+	project := CreateTestProject(t, db, "需求层级项目")
+	other := CreateTestProject(t, db, "其他需求项目")
+	handler := api.NewRequirementHandler(db)
+
+	create := func(body map[string]interface{}) map[string]interface{} {
+		if _, ok := body["project_id"]; !ok {
+			body["project_id"] = project.ID
+		}
+		return callJSONHandler(t, handler.CreateRequirement, admin.ID, []string{"admin"}, http.MethodPost, "/api/requirements", nil, body)
+	}
+	idOf := func(resp map[string]interface{}) uint {
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		return uint(resp["data"].(map[string]interface{})["id"].(float64))
+	}
+
+	epic := idOf(create(map[string]interface{}{"title": "会员体系", "level": "epic"}))
+	feature := idOf(create(map[string]interface{}{"title": "积分", "level": "feature", "parent_id": epic}))
+	storyResp := create(map[string]interface{}{"title": "积分兑换", "parent_id": feature})
+	story := idOf(storyResp)
+	assert.Equal(t, "story", storyResp["data"].(map[string]interface{})["level"])
+
+	// 层级必须低于父需求、父需求必须属于同一项目、层级必须有效
+	resp := create(map[string]interface{}{"title": "错误层级", "level": "feature", "parent_id": story})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = create(map[string]interface{}{"title": "跨项目", "project_id": other.ID, "parent_id": epic})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = create(map[string]interface{}{"title": "无效层级", "level": "theme"})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 不能移动到自己的子孙需求下；有子需求时层级不能降到子需求之下
+	resp = callJSONHandler(t, handler.UpdateRequirement, admin.ID, []string{"admin"}, http.MethodPut, "/api/requirements", idParams(feature), map[string]interface{}{"parent_id": story})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateRequirement, admin.ID, []string{"admin"}, http.MethodPut, "/api/requirements", idParams(feature), map[string]interface{}{"level": "story"})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 有子需求时不能删除；移为顶层需求后可以
+	resp = callJSONHandler(t, handler.DeleteRequirement, admin.ID, []string{"admin"}, http.MethodDelete, "/api/requirements", idParams(feature), nil)
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateRequirement, admin.ID, []string{"admin"}, http.MethodPut, "/api/requirements", idParams(story), map[string]interface{}{"parent_id": 0})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	var moved model.Requirement
+	require.NoError(t, db.First(&moved, story).Error)
+	assert.Nil(t, moved.ParentID)
+	resp = callJSONHandler(t, handler.DeleteRequirement, admin.ID, []string{"admin"}, http.MethodDelete, "/api/requirements", idParams(feature), nil)
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// 非管理员：项目成员可以调整层级，查看者和非项目成员不能
+	roles := []string{"developer"}
+	member := CreateTestUser(t, db, "reqtreemember", "成员")
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleMember)
+	viewer := CreateTestUser(t, db, "reqtreeviewer", "查看者")
+	AddUserToProject(t, db, viewer.ID, project.ID, utils.ProjectRoleViewer)
+	outsider := CreateTestUser(t, db, "reqtreeoutsider", "外部用户")
+	moveUnderEpic := map[string]interface{}{"parent_id": epic}
+	resp = callJSONHandler(t, handler.UpdateRequirement, viewer.ID, roles, http.MethodPut, "/api/requirements", idParams(story), moveUnderEpic)
+	assert.Equal(t, float64(403), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateRequirement, outsider.ID, roles, http.MethodPut, "/api/requirements", idParams(story), moveUnderEpic)
+	assert.Equal(t, float64(403), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateRequirement, member.ID, roles, http.MethodPut, "/api/requirements", idParams(story), moveUnderEpic)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	require.NoError(t, db.First(&moved, story).Error)
+	require.NotNil(t, moved.ParentID)
+	assert.Equal(t, epic, *moved.ParentID)
+}
+
+func TestRequirementTree_ProgressFiltersAndTree(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "reqprogress", "需求进度管理员")
+	project := CreateTestProject(t, db, "需求进度项目")
+	newRequirement := func(title, level string, parentID *uint) *model.Requirement {
+		requirement := &model.Requirement{Title: title, Level: level, ParentID: parentID, ProjectID: project.ID, CreatorID: admin.ID, Status: "active"}
+		require.NoError(t, db.Create(requirement).Error)
+		return requirement
+	}
+	newTask := func(requirementID uint, status string, progress int) {
+		task := &model.Task{Title: "任务", ProjectID: project.ID, RequirementID: &requirementID, CreatorID: admin.ID, Status: status, Progress: progress}
+		require.NoError(t, db.Create(task).Error)
+	}
+
+	// 史诗 -> 特性A（任务 40%、已完成、已取消）、特性B -> 故事（任务 60%）
+	epic := newRequirement("史诗", "epic", nil)
+	featureA := newRequirement("特性A", "feature", &epic.ID)
+	featureB := newRequirement("特性B", "feature", &epic.ID)
+	story := newRequirement("故事", "story", &featureB.ID)
+	newRequirement("独立故事", "story", nil)
+	newTask(featureA.ID, "doing", 40)
+	newTask(featureA.ID, "done", 0)
+	newTask(featureA.ID, "cancel", 0)
+	newTask(story.ID, "doing", 60)
+
+	handler := api.NewRequirementHandler(db)
+	list := func(query string) map[uint]float64 {
+		resp := callJSONHandler(t, handler.GetRequirements, admin.ID, []string{"admin"}, http.MethodGet, "/api/requirements?"+query, nil, nil)
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		result := make(map[uint]float64)
+		for _, item := range resp["data"].(map[string]interface{})["list"].([]interface{}) {
+			requirement := item.(map[string]interface{})
+			result[uint(requirement["id"].(float64))] = requirement["progress"].(float64)
+		}
+		return result
+	}
+	projectQuery := "project_id=" + strconv.FormatUint(uint64(project.ID), 10)
+
+	progress := list(projectQuery)
+	require.Len(t, progress, 5)
+	assert.Equal(t, float64(70), progress[featureA.ID])
+	assert.Equal(t, float64(60), progress[featureB.ID])
+	assert.Equal(t, float64(65), progress[epic.ID])
+
+	descendants := list(projectQuery + "&ancestor_id=" + strconv.FormatUint(uint64(epic.ID), 10))
+	assert.Len(t, descendants, 3)
+	assert.NotContains(t, descendants, epic.ID)
+	assert.Len(t, list(projectQuery+"&parent_id=0"), 2)
+	assert.Len(t, list(projectQuery+"&level=feature"), 2)
+
+	// 关闭的需求进度为100
+	require.NoError(t, db.Model(story).Update("status", "closed").Error)
+	assert.Equal(t, float64(100), list(projectQuery)[featureB.ID])
+
+	resp := callJSONHandler(t, handler.GetRequirementTree, admin.ID, []string{"admin"}, http.MethodGet,
+		"/api/requirements/tree?"+projectQuery+"&root_id="+strconv.FormatUint(uint64(epic.ID), 10), nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	roots := resp["data"].([]interface{})
+	require.Len(t, roots, 1)
+	root := roots[0].(map[string]interface{})
+	assert.Equal(t, float64(85), root["progress"])
+	children := root["children"].([]interface{})
+	require.Len(t, children, 2)
+	assert.Equal(t, float64(featureA.ID), children[0].(map[string]interface{})["id"])
+	assert.Len(t, children[1].(map[string]interface{})["children"], 1)
+
+	resp = callJSONHandler(t, handler.GetRequirementLevels, admin.ID, []string{"admin"}, http.MethodGet, "/api/requirements/levels", nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"], 3)
+
+	// 非管理员：项目成员看到同样的进度，非项目成员看不到该项目的需求
+	roles := []string{"developer"}
+	member := CreateTestUser(t, db, "reqprogressmember", "成员")
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleViewer)
+	outsider := CreateTestUser(t, db, "reqprogressoutsider", "外部用户")
+	treePath := "/api/requirements/tree?" + projectQuery
+	resp = callJSONHandler(t, handler.GetRequirementTree, member.ID, roles, http.MethodGet, treePath, nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	for _, node := range resp["data"].([]interface{}) {
+		if node.(map[string]interface{})["id"] == float64(epic.ID) {
+			assert.Equal(t, float64(85), node.(map[string]interface{})["progress"])
+		}
+	}
+	resp = callJSONHandler(t, handler.GetRequirementTree, outsider.ID, roles, http.MethodGet, treePath, nil, nil)
+	assert.Equal(t, float64(403), resp["code"])
+	resp = callJSONHandler(t, handler.GetRequirements, outsider.ID, roles, http.MethodGet, "/api/requirements?"+projectQuery, nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Empty(t, resp["data"].(map[string]interface{})["list"])
+
+	// 需求详情只计算该需求及其子孙需求的进度，结果与列表一致
+	detail := func(requirementID uint) float64 {
+		resp := callJSONHandler(t, handler.GetRequirement, admin.ID, []string{"admin"}, http.MethodGet, "/api/requirements", idParams(requirementID), nil)
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		return resp["data"].(map[string]interface{})["progress"].(float64)
+	}
+	assert.Equal(t, float64(85), detail(epic.ID))
+	assert.Equal(t, float64(70), detail(featureA.ID))
+
+	// 取消状态按项目工作流的 cancelled 分类判断：dropped 不计入，该工作流中没有的 cancel 按未完成计算
+	workflow := model.Workflow{Name: "进度流程", ObjectType: utils.SprintItemTask, ProjectID: &project.ID, InitialState: "wait", Status: 1,
+		States: []model.WorkflowState{
+			{Code: "wait", Name: "未开始", Category: utils.WorkflowCategoryOpen, Sort: 0},
+			{Code: "doing", Name: "进行中", Category: utils.WorkflowCategoryDoing, Sort: 1},
+			{Code: "done", Name: "已完成", Category: utils.WorkflowCategoryDone, Sort: 2},
+			{Code: "dropped", Name: "已放弃", Category: utils.WorkflowCategoryCancelled, Sort: 3},
+		}}
+	require.NoError(t, db.Create(&workflow).Error)
+	newTask(featureA.ID, "dropped", 0)
+	assert.Equal(t, float64(46), detail(featureA.ID))
+	assert.Equal(t, float64(46), list(projectQuery)[featureA.ID])
+}
@@ -0,0 +1,181 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestSchedule_CriticalPathViolationsAndAutoSchedule(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "scheduleadmin", "排期管理员")
+	project := CreateTestProject(t, db, "排期项目")
+	params := gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(project.ID), 10)}}
+	date := func(value string) *time.Time {
+		day, _ := time.Parse("2006-01-02", value)
+		return &day
+	}
+	newTask := func(title string, start, end *time.Time, hours float64) *model.Task {
+		task := &model.Task{Title: title, ProjectID: project.ID, CreatorID: admin.ID, Status: "wait", StartDate: start, EndDate: end, EstimatedHours: &hours}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+	depend := func(task, predecessor *model.Task, dependencyType string) {
+		require.NoError(t, db.Create(&model.TaskDependency{TaskID: task.ID, DependencyID: predecessor.ID, Type: dependencyType}).Error)
+	}
+
+	// 2026-06-01 为周一
+	taskA := newTask("设计", date("2026-06-01"), date("2026-06-03"), 0)
+	taskB := newTask("开发", date("2026-06-02"), date("2026-06-03"), 0) // 设计完成前就开始，违反依赖
+	taskC := newTask("编写文档", date("2026-06-01"), date("2026-06-05"), 0)
+	taskD := newTask("测试", nil, nil, 16)
+	taskE := newTask("采购", date("2026-06-01"), date("2026-06-01"), 0)
+	depend(taskB, taskA, utils.DependencyFinishToStart)
+	depend(taskC, taskA, utils.DependencyStartToStart)
+	depend(taskD, taskB, utils.DependencyFinishToStart)
+
+	handler := api.NewProjectHandler(db)
+	resp := callJSONHandler(t, handler.GetProjectSchedule, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/schedule", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, "2026-06-01", data["start_date"])
+	assert.Equal(t, "2026-06-09", data["finish_date"]) // 跨过周末
+	assert.Equal(t, float64(7), data["duration"])
+	assert.Equal(t, []interface{}{float64(taskA.ID), float64(taskB.ID), float64(taskD.ID)}, data["critical_path"])
+
+	scheduled := make(map[float64]map[string]interface{})
+	for _, item := range data["tasks"].([]interface{}) {
+		task := item.(map[string]interface{})
+		scheduled[task["task_id"].(float64)] = task
+	}
+	assert.Equal(t, float64(2), scheduled[float64(taskC.ID)]["slack"])
+	assert.Equal(t, float64(6), scheduled[float64(taskE.ID)]["slack"])
+	testTask := scheduled[float64(taskD.ID)]
+	assert.Equal(t, float64(2), testTask["duration"])
+	assert.Equal(t, "2026-06-08", testTask["early_start"])
+	assert.Equal(t, "2026-06-09", testTask["early_finish"])
+	assert.Equal(t, "2026-06-04", scheduled[float64(taskB.ID)]["early_start"])
+
+	violations := data["violations"].([]interface{})
+	require.Len(t, violations, 1)
+	assert.Equal(t, float64(taskB.ID), violations[0].(map[string]interface{})["task_id"])
+
+	// 甘特图标记关键任务和依赖冲突
+	resp = callJSONHandler(t, handler.GetProjectGantt, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/gantt", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	for _, item := range resp["data"].(map[string]interface{})["tasks"].([]interface{}) {
+		task := item.(map[string]interface{})
+		switch uint(task["id"].(float64)) {
+		case taskB.ID:
+			assert.Equal(t, true, task["critical"])
+			assert.Equal(t, true, task["violation"])
+			links := task["dependency_links"].([]interface{})
+			assert.Equal(t, utils.DependencyFinishToStart, links[0].(map[string]interface{})["type"])
+		case taskE.ID:
+			assert.Equal(t, false, task["critical"])
+			assert.Equal(t, false, task["violation"])
+		}
+	}
+
+	// 预览不修改数据
+	resp = callJSONHandler(t, handler.AutoScheduleProject, admin.ID, []string{"admin"}, http.MethodPost, "/api/projects/schedule/auto", params, map[string]interface{}{"task_id": taskA.ID, "dry_run": true})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	shifts := resp["data"].(map[string]interface{})["shifts"].([]interface{})
+	require.Len(t, shifts, 1)
+	var reloaded model.Task
+	require.NoError(t, db.First(&reloaded, taskB.ID).Error)
+	assert.Equal(t, "2026-06-02", reloaded.StartDate.Format("2006-01-02"))
+
+	resp = callJSONHandler(t, handler.AutoScheduleProject, admin.ID, []string{"admin"}, http.MethodPost, "/api/projects/schedule/auto", params, map[string]interface{}{"task_id": taskA.ID})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	shift := resp["data"].(map[string]interface{})["shifts"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(2), shift["days"])
+	assert.Equal(t, "2026-06-04", shift["new_start_date"])
+	assert.Equal(t, "2026-06-05", shift["new_end_date"])
+
+	var updated model.Task
+	require.NoError(t, db.First(&updated, taskB.ID).Error)
+	assert.Equal(t, "2026-06-04", updated.StartDate.Format("2006-01-02"))
+	assert.Equal(t, "2026-06-05", updated.EndDate.Format("2006-01-02"))
+	var history model.History
+	require.NoError(t, db.Joins("JOIN actions ON actions.id = histories.action_id").
+		Where("actions.object_type = ? AND actions.object_id = ? AND histories.field = ?", "task", taskB.ID, "start_date").First(&history).Error)
+	assert.Equal(t, "2026-06-02", history.Old)
+	assert.Equal(t, "2026-06-04", history.New)
+
+	// 调整后不再有冲突
+	resp = callJSONHandler(t, handler.GetProjectSchedule, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/schedule", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Empty(t, resp["data"].(map[string]interface{})["violations"])
+}
+
+func TestSchedule_DependencyCycle(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "cycleadmin", "循环管理员")
+	project := CreateTestProject(t, db, "循环项目")
+	taskA := &model.Task{Title: "A", ProjectID: project.ID, CreatorID: admin.ID}
+	taskB := &model.Task{Title: "B", ProjectID: project.ID, CreatorID: admin.ID}
+	require.NoError(t, db.Create(taskA).Error)
+	require.NoError(t, db.Create(taskB).Error)
+	require.NoError(t, db.Create(&model.TaskDependency{TaskID: taskA.ID, DependencyID: taskB.ID}).Error)
+	require.NoError(t, db.Create(&model.TaskDependency{TaskID: taskB.ID, DependencyID: taskA.ID}).Error)
+
+	_, err := utils.BuildProjectSchedule(db, project, nil)
+	assert.ErrorIs(t, err, utils.ErrDependencyCycle)
+
+	handler := api.NewProjectHandler(db)
+	params := gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(project.ID), 10)}}
+	resp := callJSONHandler(t, handler.GetProjectGantt, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/gantt", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, utils.ErrDependencyCycle.Error(), resp["data"].(map[string]interface{})["schedule_error"])
+}
+
+func TestSchedule_SkipsWorkflowCancelledTasks(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "scheduleadmin3", "排期管理员")
+	project := CreateTestProject(t, db, "排期项目3")
+	// 项目自定义任务工作流：取消状态的代码是 dropped
+	workflow := model.Workflow{Name: "排期流程", ObjectType: utils.SprintItemTask, ProjectID: &project.ID, InitialState: "wait", Status: 1,
+		States: []model.WorkflowState{
+			{Code: "wait", Name: "未开始", Category: utils.WorkflowCategoryOpen, Sort: 0},
+			{Code: "done", Name: "已完成", Category: utils.WorkflowCategoryDone, Sort: 1},
+			{Code: "dropped", Name: "已放弃", Category: utils.WorkflowCategoryCancelled, Sort: 2},
+		}}
+	require.NoError(t, db.Create(&workflow).Error)
+
+	hours := 8.0
+	longHours := 80.0
+	design := &model.Task{Title: "设计", ProjectID: project.ID, CreatorID: admin.ID, Status: "wait", EstimatedHours: &hours}
+	dropped := &model.Task{Title: "已放弃", ProjectID: project.ID, CreatorID: admin.ID, Status: "dropped", EstimatedHours: &longHours}
+	legacy := &model.Task{Title: "旧取消代码", ProjectID: project.ID, CreatorID: admin.ID, Status: "cancel", EstimatedHours: &hours}
+	for _, task := range []*model.Task{design, dropped, legacy} {
+		require.NoError(t, db.Create(task).Error)
+	}
+	require.NoError(t, db.Create(&model.TaskDependency{TaskID: dropped.ID, DependencyID: design.ID, Type: utils.DependencyFinishToStart}).Error)
+
+	assert.Equal(t, []string{"dropped"}, utils.CancelledStatuses(db, utils.SprintItemTask, project.ID))
+	assert.True(t, utils.IsWorkItemFinished(db, utils.SprintItemTask, project.ID, "dropped"))
+
+	schedule, err := utils.BuildProjectSchedule(db, project, nil)
+	require.NoError(t, err)
+	assert.Nil(t, schedule.Task(dropped.ID))
+	// 该项目工作流中 cancel 不是取消状态，按普通任务排期
+	assert.NotNil(t, schedule.Task(legacy.ID))
+	assert.Contains(t, schedule.CriticalPath, design.ID)
+	assert.NotContains(t, schedule.CriticalPath, dropped.ID)
+}
@@ -0,0 +1,378 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/middleware"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+	"prjflow/pkg/auth"
+)
+
+// setupSessionRouter 创建带数据库上下文和认证中间件的路由
+func setupSessionRouter(db *gorm.DB) *gin.Engine {
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	if config.AppConfig.JWT.Secret == "" {
+		config.AppConfig.JWT.Secret = "test-secret-key-for-unit-testing"
+	}
+	if config.AppConfig.JWT.Expiration == 0 {
+		config.AppConfig.JWT.Expiration = 24
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("db", db)
+		c.Next()
+	})
+
+	authHandler := api.NewAuthHandler(db)
+	userHandler := api.NewUserHandler(db)
+	r.POST("/api/auth/login", authHandler.Login)
+	r.POST("/api/auth/refresh", authHandler.RefreshToken)
+	r.GET("/api/auth/user/info", middleware.Auth(), authHandler.GetUserInfo)
+	r.POST("/api/auth/logout", middleware.Auth(), authHandler.Logout)
+	r.POST("/api/auth/change-password", middleware.Auth(), authHandler.ChangePassword)
+	r.GET("/api/auth/sessions", middleware.Auth(), authHandler.GetSessions)
+	r.DELETE("/api/auth/sessions", middleware.Auth(), authHandler.RevokeOtherSessions)
+	r.DELETE("/api/auth/sessions/:id", middleware.Auth(), authHandler.RevokeSession)
+	r.PUT("/api/users/:id", middleware.Auth(), userHandler.UpdateUser)
+	r.POST("/api/users/:id/force-logout", middleware.Auth(), userHandler.ForceLogout)
+	return r
+}
+
+func doSessionRequest(t *testing.T, r *gin.Engine, method, path, token string, body interface{}) map[string]interface{} {
+	var reader *bytes.Buffer
+	if body != nil {
+		jsonData, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(jsonData)
+	} else {
+		reader = bytes.NewBuffer(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "session-test")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+// sessionLogin 使用用户名密码登录，返回 Access Token 和 Refresh Token
+func sessionLogin(t *testing.T, r *gin.Engine, username, password string) (string, string) {
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{
+		"username": username,
+		"password": password,
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	return data["token"].(string), data["refresh_token"].(string)
+}
+
+func createSessionTestUser(t *testing.T, db *gorm.DB, username, password string) *model.User {
+	user := CreateTestUser(t, db, username, username)
+	hashed, err := utils.HashPassword(password)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(user).Updates(map[string]interface{}{"password": hashed, "login_count": 5}).Error)
+	return user
+}
+
+func TestSession_LoginLogout(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupSessionRouter(db)
+
+	user := createSessionTestUser(t, db, "sessionuser", "Session123")
+
+	token, refreshToken := sessionLogin(t, r, "sessionuser", "Session123")
+	claims, err := auth.ParseToken(token)
+	require.NoError(t, err)
+	assert.NotEmpty(t, claims.SessionID)
+	assert.NotEmpty(t, claims.ID)
+
+	var session model.UserSession
+	require.NoError(t, db.Where("session_id = ?", claims.SessionID).First(&session).Error)
+	assert.Equal(t, user.ID, session.UserID)
+	assert.Equal(t, "password", session.LoginMethod)
+	assert.Equal(t, "session-test", session.UserAgent)
+
+	resp := doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", token, nil)
+	assert.Equal(t, float64(200), resp["code"])
+
+	// Refresh Token 不能用于访问接口
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", refreshToken, nil)
+	assert.Equal(t, float64(401), resp["code"])
+
+	// 刷新后仍属于同一会话
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/refresh", "", map[string]interface{}{"refresh_token": refreshToken})
+	require.Equal(t, float64(200), resp["code"])
+	refreshed := resp["data"].(map[string]interface{})
+	newClaims, err := auth.ParseToken(refreshed["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, claims.SessionID, newClaims.SessionID)
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/logout", token, nil)
+	require.Equal(t, float64(200), resp["code"])
+
+	// 登出后该会话的所有Token失效
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", token, nil)
+	assert.Equal(t, float64(401), resp["code"])
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", refreshed["token"].(string), nil)
+	assert.Equal(t, float64(401), resp["code"])
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/refresh", "", map[string]interface{}{"refresh_token": refreshToken})
+	assert.Equal(t, float64(401), resp["code"])
+
+	db.Where("session_id = ?", claims.SessionID).First(&session)
+	require.NotNil(t, session.RevokedAt)
+	assert.Equal(t, utils.SessionRevokedLogout, session.RevokedReason)
+}
+
+func TestSession_RevokeOnPasswordChangeAndDisable(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupSessionRouter(db)
+
+	user := createSessionTestUser(t, db, "revokeuser", "Revoke123")
+
+	t.Run("修改密码吊销其他会话", func(t *testing.T) {
+		current, _ := sessionLogin(t, r, "revokeuser", "Revoke123")
+		other, _ := sessionLogin(t, r, "revokeuser", "Revoke123")
+
+		resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/change-password", current, map[string]interface{}{
+			"old_password": "Revoke123",
+			"new_password": "Revoke456",
+		})
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", current, nil)
+		assert.Equal(t, float64(200), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", other, nil)
+		assert.Equal(t, float64(401), resp["code"])
+	})
+
+	t.Run("禁用用户后Token失效", func(t *testing.T) {
+		token, refreshToken := sessionLogin(t, r, "revokeuser", "Revoke456")
+		legacyToken, err := auth.GenerateToken(user.ID, user.Username, []string{})
+		require.NoError(t, err)
+
+		// 未携带会话ID的旧Token无法吊销，即使用户状态正常也被拒绝
+		resp := doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", legacyToken, nil)
+		assert.Equal(t, float64(401), resp["code"])
+
+		require.NoError(t, db.Model(&model.User{}).Where("id = ?", user.ID).Update("status", 0).Error)
+
+		// 未携带会话ID的旧Token同样被拒绝
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", legacyToken, nil)
+		assert.Equal(t, float64(401), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", token, nil)
+		assert.Equal(t, float64(401), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/refresh", "", map[string]interface{}{"refresh_token": refreshToken})
+		assert.Equal(t, float64(401), resp["code"])
+
+		require.NoError(t, db.Model(&model.User{}).Where("id = ?", user.ID).Update("status", 1).Error)
+	})
+
+	t.Run("管理员禁用用户时吊销会话", func(t *testing.T) {
+		admin := createSessionTestUser(t, db, "sessionadmin", "Admin1234")
+		adminToken, _ := sessionLogin(t, r, "sessionadmin", "Admin1234")
+		token, _ := sessionLogin(t, r, "revokeuser", "Revoke456")
+
+		resp := doSessionRequest(t, r, http.MethodPut, fmt.Sprintf("/api/users/%d", user.ID), adminToken, map[string]interface{}{
+			"nickname": user.Nickname,
+			"status":   0,
+		})
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+
+		var active int64
+		db.Model(&model.UserSession{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&active)
+		assert.Equal(t, int64(0), active)
+
+		// 重新启用后旧会话仍然无效
+		require.NoError(t, db.Model(&model.User{}).Where("id = ?", user.ID).Update("status", 1).Error)
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", token, nil)
+		assert.Equal(t, float64(401), resp["code"])
+
+		var adminSession int64
+		db.Model(&model.UserSession{}).Where("user_id = ? AND revoked_at IS NULL", admin.ID).Count(&adminSession)
+		assert.Equal(t, int64(1), adminSession)
+	})
+}
+
+func TestSession_ManageSessions(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupSessionRouter(db)
+
+	user := createSessionTestUser(t, db, "manageuser", "Manage123")
+	createSessionTestUser(t, db, "manageadmin", "Admin1234")
+
+	first, _ := sessionLogin(t, r, "manageuser", "Manage123")
+	second, _ := sessionLogin(t, r, "manageuser", "Manage123")
+	third, _ := sessionLogin(t, r, "manageuser", "Manage123")
+
+	resp := doSessionRequest(t, r, http.MethodGet, "/api/auth/sessions", first, nil)
+	require.Equal(t, float64(200), resp["code"])
+	sessions := resp["data"].([]interface{})
+	require.Len(t, sessions, 3)
+
+	var currentCount int
+	var secondID float64
+	secondClaims, _ := auth.ParseToken(second)
+	var secondSession model.UserSession
+	require.NoError(t, db.Where("session_id = ?", secondClaims.SessionID).First(&secondSession).Error)
+	for _, item := range sessions {
+		session := item.(map[string]interface{})
+		assert.Nil(t, session["session_id"])
+		if session["current"] == true {
+			currentCount++
+		}
+		if uint(session["id"].(float64)) == secondSession.ID {
+			secondID = session["id"].(float64)
+		}
+	}
+	assert.Equal(t, 1, currentCount)
+	require.NotZero(t, secondID)
+
+	t.Run("远程注销指定会话", func(t *testing.T) {
+		resp := doSessionRequest(t, r, http.MethodDelete, fmt.Sprintf("/api/auth/sessions/%d", secondSession.ID), first, nil)
+		require.Equal(t, float64(200), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", second, nil)
+		assert.Equal(t, float64(401), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", first, nil)
+		assert.Equal(t, float64(200), resp["code"])
+	})
+
+	t.Run("不能注销他人的会话", func(t *testing.T) {
+		adminToken, _ := sessionLogin(t, r, "manageadmin", "Admin1234")
+		firstClaims, _ := auth.ParseToken(first)
+		var firstSession model.UserSession
+		require.NoError(t, db.Where("session_id = ?", firstClaims.SessionID).First(&firstSession).Error)
+
+		resp := doSessionRequest(t, r, http.MethodDelete, fmt.Sprintf("/api/auth/sessions/%d", firstSession.ID), adminToken, nil)
+		assert.Equal(t, float64(404), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", first, nil)
+		assert.Equal(t, float64(200), resp["code"])
+	})
+
+	t.Run("注销其他会话", func(t *testing.T) {
+		resp := doSessionRequest(t, r, http.MethodDelete, "/api/auth/sessions", first, nil)
+		require.Equal(t, float64(200), resp["code"])
+		assert.Equal(t, float64(1), resp["data"].(map[string]interface{})["count"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", third, nil)
+		assert.Equal(t, float64(401), resp["code"])
+	})
+
+	t.Run("管理员强制下线", func(t *testing.T) {
+		adminToken, _ := sessionLogin(t, r, "manageadmin", "Admin1234")
+		resp := doSessionRequest(t, r, http.MethodPost, fmt.Sprintf("/api/users/%d/force-logout", user.ID), adminToken, nil)
+		require.Equal(t, float64(200), resp["code"])
+		assert.Equal(t, float64(1), resp["data"].(map[string]interface{})["count"])
+
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", first, nil)
+		assert.Equal(t, float64(401), resp["code"])
+
+		var session model.UserSession
+		firstClaims, _ := auth.ParseToken(first)
+		db.Where("session_id = ?", firstClaims.SessionID).First(&session)
+		assert.Equal(t, utils.SessionRevokedForceLogout, session.RevokedReason)
+	})
+}
+
+func TestSession_RefreshTokenRotation(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	require.NoError(t, utils.MigrateAuditDB(db, nil))
+	r := setupSessionRouter(db)
+
+	createSessionTestUser(t, db, "rotateuser", "Rotate123")
+	refresh := func(refreshToken string) map[string]interface{} {
+		return doSessionRequest(t, r, http.MethodPost, "/api/auth/refresh", "", map[string]interface{}{"refresh_token": refreshToken})
+	}
+
+	t.Run("Refresh Token只能使用一次", func(t *testing.T) {
+		_, first := sessionLogin(t, r, "rotateuser", "Rotate123")
+
+		resp := refresh(first)
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		second := resp["data"].(map[string]interface{})["refresh_token"].(string)
+		secondAccess := resp["data"].(map[string]interface{})["token"].(string)
+		assert.NotEqual(t, first, second)
+
+		claims, err := auth.ParseRefreshToken(second)
+		require.NoError(t, err)
+		var session model.UserSession
+		require.NoError(t, db.Where("session_id = ?", claims.SessionID).First(&session).Error)
+		assert.Equal(t, claims.ID, session.RefreshTokenID)
+		assert.Equal(t, 1, session.RefreshCount)
+
+		// 已轮换的Token再次使用：整个会话被吊销
+		resp = refresh(first)
+		assert.Equal(t, float64(401), resp["code"])
+		assert.Contains(t, resp["message"], "重复使用")
+
+		db.First(&session, session.ID)
+		require.NotNil(t, session.RevokedAt)
+		assert.Equal(t, utils.SessionRevokedTokenReused, session.RevokedReason)
+
+		// 同一家族中最新的Token也随之失效
+		resp = refresh(second)
+		assert.Equal(t, float64(401), resp["code"])
+		resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", secondAccess, nil)
+		assert.Equal(t, float64(401), resp["code"])
+
+		var audit model.AuditLog
+		require.NoError(t, db.Where("action_type = ?", "refresh_token_reuse").First(&audit).Error)
+		assert.Contains(t, audit.ErrorMsg, "重复使用")
+	})
+
+	t.Run("其他会话不受影响", func(t *testing.T) {
+		_, stolen := sessionLogin(t, r, "rotateuser", "Rotate123")
+		otherAccess, otherRefresh := sessionLogin(t, r, "rotateuser", "Rotate123")
+
+		require.Equal(t, float64(200), refresh(stolen)["code"])
+		require.Equal(t, float64(401), refresh(stolen)["code"])
+
+		resp := doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", otherAccess, nil)
+		assert.Equal(t, float64(200), resp["code"])
+		assert.Equal(t, float64(200), refresh(otherRefresh)["code"])
+	})
+
+	t.Run("Token类型不能混用", func(t *testing.T) {
+		access, refreshToken := sessionLogin(t, r, "rotateuser", "Rotate123")
+
+		_, err := auth.ParseAccessToken(refreshToken)
+		assert.ErrorIs(t, err, auth.ErrInvalidTokenType)
+		_, err = auth.ParseRefreshToken(access)
+		assert.ErrorIs(t, err, auth.ErrInvalidTokenType)
+
+		resp := refresh(access)
+		assert.Equal(t, float64(401), resp["code"])
+		assert.Contains(t, resp["message"], "只能使用RefreshToken")
+	})
+
+	t.Run("不属于会话的Refresh Token被拒绝", func(t *testing.T) {
+		legacy, err := auth.GenerateRefreshToken(1, "rotateuser", []string{})
+		require.NoError(t, err)
+		resp := refresh(legacy)
+		assert.Equal(t, float64(401), resp["code"])
+	})
+}
@@ -0,0 +1,203 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func sprintParams(id uint) gin.Params {
+	return gin.Params{{Key: "id", Value: fmt.Sprint(id)}}
+}
+
+func TestSprint_PlanStartAndCloseWithCarryOver(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "sprintadmin", "迭代管理员")
+	project := CreateTestProject(t, db, "迭代项目")
+	roles := []string{"admin"}
+	handler := api.NewSprintHandler(db)
+
+	hours := 8.0
+	doneTask := &model.Task{Title: "已完成任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "done", EstimatedHours: &hours}
+	openTask := &model.Task{Title: "未完成任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "doing", EstimatedHours: &hours}
+	backlogTask := &model.Task{Title: "待办任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "wait"}
+	bug := &model.Bug{Title: "未解决Bug", ProjectID: project.ID, CreatorID: admin.ID, Status: "active"}
+	for _, item := range []interface{}{doneTask, openTask, backlogTask, bug} {
+		require.NoError(t, db.Create(item).Error)
+	}
+
+	createSprint := func(name, start, end string) uint {
+		resp := callJSONHandler(t, handler.CreateSprint, admin.ID, roles, http.MethodPost, "/api/projects/sprints", sprintParams(project.ID), map[string]interface{}{
+			"name": name, "goal": "交付核心功能", "start_date": start, "end_date": end, "capacity": 40,
+		})
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		return uint(resp["data"].(map[string]interface{})["id"].(float64))
+	}
+	sprint1 := createSprint("迭代1", "2026-03-02", "2026-03-13")
+	sprint2 := createSprint("迭代2", "2026-03-16", "2026-03-27")
+
+	resp := callJSONHandler(t, handler.CreateSprint, admin.ID, roles, http.MethodPost, "/api/projects/sprints", sprintParams(project.ID), map[string]interface{}{
+		"name": "错误迭代", "start_date": "2026-03-13", "end_date": "2026-03-02",
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	resp = callJSONHandler(t, handler.AddSprintItems, admin.ID, roles, http.MethodPost, "/api/sprints/items", sprintParams(sprint1), map[string]interface{}{
+		"task_ids": []uint{doneTask.ID, openTask.ID}, "bug_ids": []uint{bug.ID},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// 加入迭代记录在历史中
+	var histories []model.History
+	require.NoError(t, db.Where("field = ?", "sprint_id").Find(&histories).Error)
+	assert.Len(t, histories, 3)
+
+	resp = callJSONHandler(t, handler.GetSprint, admin.ID, roles, http.MethodGet, "/api/sprints", sprintParams(sprint1), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	summary := resp["data"].(map[string]interface{})["summary"].(map[string]interface{})
+	assert.Equal(t, float64(16), summary["estimated_hours"])
+
+	resp = callJSONHandler(t, handler.StartSprint, admin.ID, roles, http.MethodPost, "/api/sprints/start", sprintParams(sprint1), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, handler.StartSprint, admin.ID, roles, http.MethodPost, "/api/sprints/start", sprintParams(sprint2), nil)
+	assert.Equal(t, float64(409), resp["code"])
+
+	// 按迭代和待办池筛选任务
+	taskHandler := api.NewTaskHandler(db)
+	resp = callJSONHandler(t, taskHandler.GetTasks, admin.ID, roles, http.MethodGet, fmt.Sprintf("/api/tasks?sprint_id=%d", sprint1), nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(2), resp["data"].(map[string]interface{})["total"])
+	resp = callJSONHandler(t, taskHandler.GetTasks, admin.ID, roles, http.MethodGet, "/api/tasks?sprint_id=0", nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(1), resp["data"].(map[string]interface{})["total"])
+
+	// 关闭迭代：未完成的工作项转入下一个未开始的迭代
+	resp = callJSONHandler(t, handler.CloseSprint, admin.ID, roles, http.MethodPost, "/api/sprints/close", sprintParams(sprint1), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	result := resp["data"].(map[string]interface{})["result"].(map[string]interface{})
+	assert.Equal(t, float64(sprint2), result["next_sprint_id"])
+
+	var reloaded model.Task
+	require.NoError(t, db.First(&reloaded, openTask.ID).Error)
+	require.NotNil(t, reloaded.SprintID)
+	assert.Equal(t, sprint2, *reloaded.SprintID)
+	var finished model.Task
+	require.NoError(t, db.First(&finished, doneTask.ID).Error)
+	require.NotNil(t, finished.SprintID)
+	assert.Equal(t, sprint1, *finished.SprintID)
+	var reloadedBug model.Bug
+	require.NoError(t, db.First(&reloadedBug, bug.ID).Error)
+	require.NotNil(t, reloadedBug.SprintID)
+	assert.Equal(t, sprint2, *reloadedBug.SprintID)
+
+	var closed model.Sprint
+	require.NoError(t, db.First(&closed, sprint1).Error)
+	assert.Equal(t, utils.SprintStatusClosed, closed.Status)
+	assert.NotNil(t, closed.ClosedAt)
+
+	// 已关闭的迭代不能再调整工作项
+	resp = callJSONHandler(t, handler.AddSprintItems, admin.ID, roles, http.MethodPost, "/api/sprints/items", sprintParams(sprint1), map[string]interface{}{
+		"task_ids": []uint{backlogTask.ID},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+}
+
+func TestSprint_CloseWithoutNextSprintReturnsItemsToBacklog(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "sprintadmin2", "迭代管理员")
+	project := CreateTestProject(t, db, "迭代项目2")
+	sprint := &model.Sprint{Name: "唯一迭代", ProjectID: project.ID, Status: utils.SprintStatusPlanned, CreatorID: admin.ID}
+	require.NoError(t, db.Create(sprint).Error)
+	requirement := &model.Requirement{Title: "需求", ProjectID: project.ID, CreatorID: admin.ID, Status: "active"}
+	require.NoError(t, db.Create(requirement).Error)
+
+	_, err := utils.SetSprintItems(db, project.ID, &sprint.ID, map[string][]uint{utils.SprintItemRequirement: {requirement.ID}}, admin.ID)
+	require.NoError(t, err)
+	require.NoError(t, utils.StartSprint(db, sprint))
+
+	// 其他项目的工作项不能加入
+	other := CreateTestProject(t, db, "迭代项目3")
+	otherTask := &model.Task{Title: "其他任务", ProjectID: other.ID, CreatorID: admin.ID}
+	require.NoError(t, db.Create(otherTask).Error)
+	_, err = utils.SetSprintItems(db, project.ID, &sprint.ID, map[string][]uint{utils.SprintItemTask: {otherTask.ID}}, admin.ID)
+	assert.Error(t, err)
+
+	result, err := utils.CloseSprint(db, sprint, nil, admin.ID)
+	require.NoError(t, err)
+	assert.Nil(t, result.NextSprintID)
+
+	var reloaded model.Requirement
+	require.NoError(t, db.First(&reloaded, requirement.ID).Error)
+	assert.Nil(t, reloaded.SprintID)
+}
+
+func TestSprint_FinishedFollowsWorkflowCategory(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "sprintadmin4", "迭代管理员")
+	project := CreateTestProject(t, db, "迭代项目4")
+	// 项目自定义任务工作流：验收通过（verified）属于完成分类，已完成（done）只是待验收
+	workflow := model.Workflow{Name: "验收流程", ObjectType: utils.SprintItemTask, ProjectID: &project.ID, InitialState: "wait", Status: 1,
+		States: []model.WorkflowState{
+			{Code: "wait", Name: "未开始", Category: "open", Sort: 0},
+			{Code: "done", Name: "待验收", Category: "doing", Sort: 1},
+			{Code: "verified", Name: "验收通过", Category: "done", Sort: 2},
+		}}
+	require.NoError(t, db.Create(&workflow).Error)
+
+	sprint := &model.Sprint{Name: "迭代", ProjectID: project.ID, Status: utils.SprintStatusPlanned, CreatorID: admin.ID}
+	require.NoError(t, db.Create(sprint).Error)
+	verified := &model.Task{Title: "已验收", ProjectID: project.ID, CreatorID: admin.ID, Status: "verified"}
+	pending := &model.Task{Title: "待验收", ProjectID: project.ID, CreatorID: admin.ID, Status: "done"}
+	resolved := &model.Bug{Title: "已解决Bug", ProjectID: project.ID, CreatorID: admin.ID, Status: "resolved"}
+	closedBug := &model.Bug{Title: "已关闭Bug", ProjectID: project.ID, CreatorID: admin.ID, Status: "closed"}
+	for _, item := range []interface{}{verified, pending, resolved, closedBug} {
+		require.NoError(t, db.Create(item).Error)
+	}
+
+	assert.True(t, utils.IsWorkItemFinished(db, utils.SprintItemTask, project.ID, "verified"))
+	assert.False(t, utils.IsWorkItemFinished(db, utils.SprintItemTask, project.ID, "done"))
+	// 默认Bug工作流中“已解决”属于进行中分类，还需要验证关闭
+	assert.False(t, utils.IsWorkItemFinished(db, utils.SprintItemBug, project.ID, "resolved"))
+
+	// 子任务未验收时父任务不能验收
+	child := &model.Task{Title: "子任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "done", ParentID: &pending.ID}
+	require.NoError(t, db.Create(child).Error)
+	assert.ErrorIs(t, utils.CheckTaskCanFinish(db, pending, "verified"), utils.ErrTaskChildrenOpen)
+	assert.NoError(t, utils.CheckTaskCanFinish(db, pending, "done"))
+
+	_, err := utils.SetSprintItems(db, project.ID, &sprint.ID, map[string][]uint{
+		utils.SprintItemTask: {verified.ID, pending.ID},
+		utils.SprintItemBug:  {resolved.ID, closedBug.ID},
+	}, admin.ID)
+	require.NoError(t, err)
+	require.NoError(t, utils.StartSprint(db, sprint))
+
+	summary, err := utils.GetSprintSummary(db, sprint)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Items[utils.SprintItemTask].Finished)
+	assert.Equal(t, 1, summary.Items[utils.SprintItemBug].Finished)
+
+	result, err := utils.CloseSprint(db, sprint, nil, admin.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Finished[utils.SprintItemTask])
+	assert.Equal(t, 1, result.CarriedOver[utils.SprintItemTask])
+	assert.Equal(t, 1, result.Finished[utils.SprintItemBug])
+	assert.Equal(t, 1, result.CarriedOver[utils.SprintItemBug])
+
+	var reloaded model.Bug
+	require.NoError(t, db.First(&reloaded, resolved.ID).Error)
+	assert.Nil(t, reloaded.SprintID)
+}
@@ -0,0 +1,148 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func taskParams(id uint) gin.Params {
+	return gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(id), 10)}}
+}
+
+func TestTaskDependency_AddRemoveWithValidation(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "depadmin", "依赖管理员")
+	project := CreateTestProject(t, db, "依赖项目")
+	other := CreateTestProject(t, db, "其他项目")
+	newTask := func(title string, projectID uint) *model.Task {
+		task := &model.Task{Title: title, ProjectID: projectID, CreatorID: admin.ID}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+	taskA := newTask("A", project.ID)
+	taskB := newTask("B", project.ID)
+	taskC := newTask("C", project.ID)
+	foreign := newTask("外部任务", other.ID)
+
+	handler := api.NewTaskHandler(db)
+	add := func(task, dependency *model.Task, dependencyType string) map[string]interface{} {
+		return callJSONHandler(t, handler.AddTaskDependency, admin.ID, []string{"admin"}, http.MethodPost, "/api/tasks/dependencies",
+			taskParams(task.ID), map[string]interface{}{"dependency_id": dependency.ID, "type": dependencyType})
+	}
+
+	// B 依赖 A，C 依赖 B
+	resp := add(taskB, taskA, "")
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, utils.DependencyFinishToStart, resp["data"].(map[string]interface{})["type"])
+	resp = add(taskC, taskB, utils.DependencyStartToStart)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// A 依赖 C 形成循环，返回循环路径
+	resp = add(taskA, taskC, "")
+	assert.Equal(t, float64(400), resp["code"])
+	assert.Contains(t, resp["message"], "循环")
+	path := resp["data"].(map[string]interface{})["path"].([]interface{})
+	assert.Equal(t, []interface{}{float64(taskA.ID), float64(taskC.ID), float64(taskB.ID), float64(taskA.ID)}, path)
+
+	resp = add(taskA, taskA, "")
+	assert.Equal(t, float64(400), resp["code"])
+	resp = add(taskA, foreign, "")
+	assert.Equal(t, float64(400), resp["code"])
+	assert.Contains(t, resp["message"], "同一项目")
+	resp = add(taskA, taskB, "finish_to_later")
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 已存在的依赖更新类型
+	resp = add(taskB, taskA, utils.DependencyFinishToFinish)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	var dependency model.TaskDependency
+	require.NoError(t, db.Where("task_id = ? AND dependency_id = ?", taskB.ID, taskA.ID).First(&dependency).Error)
+	assert.Equal(t, utils.DependencyFinishToFinish, dependency.Type)
+
+	resp = callJSONHandler(t, handler.GetTaskDependencies, admin.ID, []string{"admin"}, http.MethodGet, "/api/tasks/dependencies", taskParams(taskB.ID), nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	require.Len(t, data["dependencies"], 1)
+	require.Len(t, data["dependents"], 1)
+	assert.Equal(t, float64(taskC.ID), data["dependents"].([]interface{})[0].(map[string]interface{})["task_id"])
+
+	// 整体更新依赖时同样校验循环
+	resp = callJSONHandler(t, handler.UpdateTask, admin.ID, []string{"admin"}, http.MethodPut, "/api/tasks", taskParams(taskA.ID),
+		map[string]interface{}{"dependency_ids": []uint{taskC.ID}})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 移除 C -> B 后 A 可以依赖 C
+	removeParams := append(taskParams(taskC.ID), gin.Param{Key: "dependency_id", Value: strconv.FormatUint(uint64(taskB.ID), 10)})
+	resp = callJSONHandler(t, handler.RemoveTaskDependency, admin.ID, []string{"admin"}, http.MethodDelete, "/api/tasks/dependencies", removeParams, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, handler.RemoveTaskDependency, admin.ID, []string{"admin"}, http.MethodDelete, "/api/tasks/dependencies", removeParams, nil)
+	assert.Equal(t, float64(404), resp["code"])
+	resp = add(taskA, taskC, "")
+	assert.Equal(t, float64(200), resp["code"], resp["message"])
+}
+
+func TestTaskDependency_ProjectIntegrityCheck(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "depcheck", "检查管理员")
+	project := CreateTestProject(t, db, "迁移项目")
+	other := CreateTestProject(t, db, "另一个项目")
+	newTask := func(title string, projectID uint) *model.Task {
+		task := &model.Task{Title: title, ProjectID: projectID, CreatorID: admin.ID}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+	depend := func(taskID, dependencyID uint) {
+		require.NoError(t, db.Create(&model.TaskDependency{TaskID: taskID, DependencyID: dependencyID}).Error)
+	}
+
+	handler := api.NewProjectHandler(db)
+	check := func() map[string]interface{} {
+		resp := callJSONHandler(t, handler.CheckProjectDependencies, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/dependencies/check", taskParams(project.ID), nil)
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		return resp["data"].(map[string]interface{})
+	}
+
+	taskA := newTask("A", project.ID)
+	taskB := newTask("B", project.ID)
+	depend(taskB.ID, taskA.ID)
+	assert.Equal(t, true, check()["valid"])
+
+	// 模拟迁移产生的脏数据：A -> C -> B -> A 循环、依赖自己、跨项目依赖、依赖已删除的任务
+	taskC := newTask("C", project.ID)
+	taskD := newTask("D", project.ID)
+	foreign := newTask("外部任务", other.ID)
+	deleted := newTask("已删除", project.ID)
+	depend(taskA.ID, taskC.ID)
+	depend(taskC.ID, taskB.ID)
+	depend(taskD.ID, taskD.ID)
+	depend(taskD.ID, foreign.ID)
+	depend(taskD.ID, deleted.ID)
+	require.NoError(t, db.Delete(deleted).Error)
+
+	data := check()
+	assert.Equal(t, false, data["valid"])
+	assert.Equal(t, float64(6), data["dependency_count"])
+	issues := make(map[string]map[string]interface{})
+	for _, item := range data["issues"].([]interface{}) {
+		issue := item.(map[string]interface{})
+		issues[issue["type"].(string)] = issue
+	}
+	require.Len(t, issues, 4)
+	assert.Equal(t, []interface{}{float64(taskA.ID), float64(taskC.ID), float64(taskB.ID), float64(taskA.ID)}, issues[utils.DependencyIssueCycle]["path"])
+	assert.Equal(t, float64(taskD.ID), issues[utils.DependencyIssueSelf]["task_id"])
+	assert.Equal(t, float64(foreign.ID), issues[utils.DependencyIssueCrossProject]["dependency_id"])
+	assert.Equal(t, float64(deleted.ID), issues[utils.DependencyIssueMissing]["dependency_id"])
+}
@@ -0,0 +1,164 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+)
+
+func TestTaskTree_RollupAndFinishRules(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "wbsadmin", "WBS管理员")
+	project := CreateTestProject(t, db, "WBS项目")
+	other := CreateTestProject(t, db, "其他WBS项目")
+	handler := api.NewTaskHandler(db)
+
+	create := func(body map[string]interface{}) map[string]interface{} {
+		body["project_id"] = project.ID
+		resp := callJSONHandler(t, handler.CreateTask, admin.ID, []string{"admin"}, http.MethodPost, "/api/tasks", nil, body)
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		return resp["data"].(map[string]interface{})
+	}
+	idOf := func(data map[string]interface{}) uint { return uint(data["id"].(float64)) }
+	load := func(id uint) model.Task {
+		var task model.Task
+		require.NoError(t, db.First(&task, id).Error)
+		return task
+	}
+
+	// 开发（父任务） -> 后端（子任务） -> 接口（孙任务）；开发 -> 前端
+	root := idOf(create(map[string]interface{}{"title": "开发", "start_date": "2026-07-01", "end_date": "2026-07-02"}))
+	backend := idOf(create(map[string]interface{}{"title": "后端", "parent_id": root, "estimated_hours": 8}))
+	api1 := idOf(create(map[string]interface{}{"title": "接口", "parent_id": backend, "estimated_hours": 12, "start_date": "2026-07-03", "end_date": "2026-07-08"}))
+	frontend := idOf(create(map[string]interface{}{"title": "前端", "parent_id": root, "estimated_hours": 4, "start_date": "2026-06-29", "end_date": "2026-07-01"}))
+
+	assert.Equal(t, 12.0, *load(backend).EstimatedHours)
+	assert.Equal(t, 16.0, *load(root).EstimatedHours)
+
+	// 子任务登记工时后逐级汇总实际工时和进度
+	require.NoError(t, db.Model(&model.Task{}).Where("id = ?", api1).Update("assignee_id", admin.ID).Error)
+	resp := callJSONHandler(t, handler.UpdateTaskProgress, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/progress", taskParams(api1), map[string]interface{}{"actual_hours": 6, "work_date": "2026-07-03"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, 6.0, *load(backend).ActualHours)
+	assert.Equal(t, 50, load(backend).Progress)
+	rootTask := load(root)
+	assert.Equal(t, 6.0, *rootTask.ActualHours)
+	assert.Equal(t, 37, rootTask.Progress)
+	// 汇总只更新工时和进度，不改变父任务的状态
+	assert.Equal(t, "wait", rootTask.Status)
+	assert.Equal(t, "wait", load(backend).Status)
+
+	// 子任务未完成时父任务不能完成
+	resp = callJSONHandler(t, handler.UpdateTaskStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/status", taskParams(backend), map[string]interface{}{"status": "done"})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateTask, admin.ID, []string{"admin"}, http.MethodPut, "/api/tasks", taskParams(root), map[string]interface{}{"status": "done"})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 父任务不能移动到自己的子孙任务下，也不能跨项目
+	resp = callJSONHandler(t, handler.UpdateTask, admin.ID, []string{"admin"}, http.MethodPut, "/api/tasks", taskParams(root), map[string]interface{}{"parent_id": api1})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateTask, admin.ID, []string{"admin"}, http.MethodPut, "/api/tasks", taskParams(frontend), map[string]interface{}{"project_id": other.ID})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.DeleteTask, admin.ID, []string{"admin"}, http.MethodDelete, "/api/tasks", taskParams(backend), nil)
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 前端移为顶层任务后不再计入父任务
+	resp = callJSONHandler(t, handler.UpdateTask, admin.ID, []string{"admin"}, http.MethodPut, "/api/tasks", taskParams(frontend), map[string]interface{}{"parent_id": 0})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, 12.0, *load(root).EstimatedHours)
+
+	// 子任务工时达到预估工时后，已暂停的父任务不会被自动完成
+	resp = callJSONHandler(t, handler.UpdateTaskStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/status", taskParams(root), map[string]interface{}{"status": "pause"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, handler.UpdateTaskProgress, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/progress", taskParams(api1), map[string]interface{}{"actual_hours": 6, "work_date": "2026-07-04"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, 100, load(backend).Progress)
+	assert.Equal(t, "wait", load(backend).Status)
+	rootTask = load(root)
+	assert.Equal(t, 100, rootTask.Progress)
+	assert.Equal(t, "pause", rootTask.Status)
+
+	// 子孙任务全部完成后父任务可以完成
+	resp = callJSONHandler(t, handler.UpdateTaskStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/status", taskParams(api1), map[string]interface{}{"status": "done"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	resp = callJSONHandler(t, handler.UpdateTaskStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/status", taskParams(backend), map[string]interface{}{"status": "done"})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// 已完成的任务不能再添加子任务
+	body := map[string]interface{}{"title": "补充", "parent_id": backend, "project_id": project.ID}
+	resp = callJSONHandler(t, handler.CreateTask, admin.ID, []string{"admin"}, http.MethodPost, "/api/tasks", nil, body)
+	assert.Equal(t, float64(400), resp["code"])
+}
+
+func TestTaskTree_TreeEndpointAndGanttSummary(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "treeadmin", "树管理员")
+	project := CreateTestProject(t, db, "树项目")
+	newTask := func(title string, parentID *uint, start, end string) *model.Task {
+		task := &model.Task{Title: title, ProjectID: project.ID, CreatorID: admin.ID, Status: "wait", ParentID: parentID}
+		if start != "" {
+			s, e := burnDate(start, 0), burnDate(end, 0)
+			task.StartDate, task.EndDate = &s, &e
+		}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+	design := newTask("设计", nil, "", "")
+	develop := newTask("开发", nil, "2026-07-06", "2026-07-06")
+	backend := newTask("后端", &develop.ID, "2026-07-07", "2026-07-10")
+	newTask("接口", &backend.ID, "2026-07-13", "2026-07-15")
+	newTask("前端", &develop.ID, "2026-07-01", "2026-07-03")
+
+	handler := api.NewTaskHandler(db)
+	resp := callJSONHandler(t, handler.GetTaskTree, admin.ID, []string{"admin"}, http.MethodGet,
+		"/api/tasks/tree?project_id="+strconv.FormatUint(uint64(project.ID), 10), nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	roots := resp["data"].([]interface{})
+	require.Len(t, roots, 2)
+	assert.Equal(t, float64(design.ID), roots[0].(map[string]interface{})["id"])
+	developNode := roots[1].(map[string]interface{})
+	assert.Equal(t, "2", developNode["wbs"])
+	assert.Equal(t, true, developNode["summary"])
+	children := developNode["children"].([]interface{})
+	require.Len(t, children, 2)
+	backendNode := children[0].(map[string]interface{})
+	assert.Equal(t, "2.1", backendNode["wbs"])
+	grandchild := backendNode["children"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "2.1.1", grandchild["wbs"])
+	assert.Equal(t, float64(3), grandchild["level"])
+
+	resp = callJSONHandler(t, handler.GetTaskTree, admin.ID, []string{"admin"}, http.MethodGet,
+		"/api/tasks/tree?project_id="+strconv.FormatUint(uint64(project.ID), 10)+"&root_id="+strconv.FormatUint(uint64(backend.ID), 10), nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	require.Len(t, resp["data"], 1)
+	assert.Equal(t, "2.1", resp["data"].([]interface{})[0].(map[string]interface{})["wbs"])
+
+	// 甘特图按 WBS 顺序输出，汇总任务覆盖子孙任务的日期范围
+	projectHandler := api.NewProjectHandler(db)
+	resp = callJSONHandler(t, projectHandler.GetProjectGantt, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/gantt",
+		gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(project.ID), 10)}}, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	tasks := resp["data"].(map[string]interface{})["tasks"].([]interface{})
+	require.Len(t, tasks, 5)
+	wbs := make([]string, 0, len(tasks))
+	for _, item := range tasks {
+		wbs = append(wbs, item.(map[string]interface{})["wbs"].(string))
+	}
+	assert.Equal(t, []string{"1", "2", "2.1", "2.1.1", "2.2"}, wbs)
+	summary := tasks[1].(map[string]interface{})
+	assert.Equal(t, true, summary["summary"])
+	assert.Equal(t, "2026-07-01", summary["start_date"])
+	assert.Equal(t, "2026-07-15", summary["end_date"])
+	assert.Equal(t, false, tasks[3].(map[string]interface{})["summary"])
+}
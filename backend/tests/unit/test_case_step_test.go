@@ -0,0 +1,204 @@
+package unit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestTestCaseSteps_VersionsAndSharedSteps(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "stepadmin", "步骤管理员")
+	project := CreateTestProject(t, db, "步骤项目")
+	otherProject := CreateTestProject(t, db, "其他项目")
+	roles := []string{"admin"}
+
+	attachment := &model.Attachment{FileName: "login.png", FilePath: "uploads/login.png", FileSize: 10, CreatorID: admin.ID}
+	require.NoError(t, db.Create(attachment).Error)
+
+	// 共享步骤库：一个属于本项目，一个属于其他项目
+	sharedHandler := api.NewSharedStepHandler(db)
+	resp := callJSONHandler(t, sharedHandler.CreateSharedStep, admin.ID, roles, http.MethodPost, "/api/shared-steps", nil, map[string]interface{}{
+		"name":       "登录",
+		"project_id": project.ID,
+		"items": []map[string]interface{}{
+			{"action": "打开登录页", "expected": "显示登录表单"},
+			{"action": "输入账号密码并提交", "expected": "进入首页"},
+		},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	loginSteps := resp["data"].(map[string]interface{})
+	loginStepsID := uint(loginSteps["id"].(float64))
+	assert.Len(t, loginSteps["items"], 2)
+
+	resp = callJSONHandler(t, sharedHandler.CreateSharedStep, admin.ID, roles, http.MethodPost, "/api/shared-steps", nil, map[string]interface{}{
+		"name":       "外部步骤",
+		"project_id": otherProject.ID,
+		"items":      []map[string]interface{}{{"action": "其他操作"}},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	otherStepsID := uint(resp["data"].(map[string]interface{})["id"].(float64))
+
+	resp = callJSONHandler(t, sharedHandler.CreateSharedStep, admin.ID, roles, http.MethodPost, "/api/shared-steps", nil, map[string]interface{}{
+		"name":  "空步骤",
+		"items": []map[string]interface{}{{"action": " "}},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 创建测试单：共享步骤展开为库中的步骤，后面跟普通步骤
+	handler := api.NewTestCaseHandler(db)
+	resp = callJSONHandler(t, handler.CreateTestCase, admin.ID, roles, http.MethodPost, "/api/test-cases", nil, map[string]interface{}{
+		"name":       "登录后查看个人信息",
+		"project_id": project.ID,
+		"steps": []map[string]interface{}{
+			{"shared_step_id": loginStepsID},
+			{"action": "点击头像", "expected": "显示个人信息", "attachment_ids": []uint{attachment.ID}},
+		},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	created := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(1), created["step_version"])
+	steps := created["steps"].([]interface{})
+	require.Len(t, steps, 3)
+	assert.Equal(t, "打开登录页", steps[0].(map[string]interface{})["action"])
+	assert.Equal(t, float64(loginStepsID), steps[1].(map[string]interface{})["shared_step_id"])
+	assert.Equal(t, float64(3), steps[2].(map[string]interface{})["sort"])
+	assert.Len(t, steps[2].(map[string]interface{})["attachments"], 1)
+	params := gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(created["id"].(float64)), 10)}}
+
+	// 引用其他项目的共享步骤、操作为空的步骤都被拒绝
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{
+		"steps": []map[string]interface{}{{"shared_step_id": otherStepsID}},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{
+		"steps": []map[string]interface{}{{"action": "", "expected": "无"}},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 修改共享步骤库不影响已保存的测试单
+	resp = callJSONHandler(t, sharedHandler.UpdateSharedStep, admin.ID, roles, http.MethodPut, "/api/shared-steps",
+		gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(loginStepsID), 10)}},
+		map[string]interface{}{"items": []map[string]interface{}{{"action": "扫码登录", "expected": "进入首页"}}})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"].(map[string]interface{})["items"], 1)
+
+	// 步骤没有变化时不产生新版本
+	unchanged := make([]map[string]interface{}, 0, len(steps))
+	for _, step := range steps {
+		s := step.(map[string]interface{})
+		input := map[string]interface{}{"action": s["action"], "expected": s["expected"], "shared_step_id": nil}
+		if len(s["attachments"].([]interface{})) > 0 {
+			input["attachment_ids"] = []uint{attachment.ID}
+		}
+		unchanged = append(unchanged, input)
+	}
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{"name": "改名", "steps": unchanged})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	// 原步骤前两步来自共享步骤库，作为普通步骤提交时视为变化，生成第 2 版
+	assert.Equal(t, float64(2), resp["data"].(map[string]interface{})["step_version"])
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{"steps": unchanged})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, float64(2), resp["data"].(map[string]interface{})["step_version"])
+
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{
+		"steps": []map[string]interface{}{{"action": "点击头像", "expected": "显示个人信息和积分"}},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	updated := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(3), updated["step_version"])
+	assert.Len(t, updated["steps"], 1)
+
+	// 历史版本保持不变
+	resp = callJSONHandler(t, handler.GetTestCaseSteps, admin.ID, roles, http.MethodGet, "/api/test-cases/steps?version=1", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(1), data["version"])
+	assert.Equal(t, float64(3), data["current_version"])
+	assert.Equal(t, []interface{}{float64(1), float64(2), float64(3)}, data["versions"])
+	oldSteps := data["steps"].([]interface{})
+	require.Len(t, oldSteps, 3)
+	assert.Equal(t, "打开登录页", oldSteps[0].(map[string]interface{})["action"])
+
+	resp = callJSONHandler(t, handler.GetTestCaseSteps, admin.ID, roles, http.MethodGet, "/api/test-cases/steps", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"].(map[string]interface{})["steps"], 1)
+
+	// 项目内只能看到全局共享和本项目的共享步骤库
+	resp = callJSONHandler(t, sharedHandler.GetSharedSteps, admin.ID, roles, http.MethodGet,
+		"/api/shared-steps?project_id="+strconv.FormatUint(uint64(project.ID), 10), nil, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"], 1)
+
+	// 非管理员：只能看到全局共享和自己参与项目的共享步骤库，不能按其他项目查询
+	resp = callJSONHandler(t, sharedHandler.CreateSharedStep, admin.ID, roles, http.MethodPost, "/api/shared-steps", nil, map[string]interface{}{
+		"name":  "通用步骤",
+		"items": []map[string]interface{}{{"action": "清除缓存"}},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	member := CreateTestUser(t, db, "stepmember", "步骤成员")
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleViewer)
+	outsider := CreateTestUser(t, db, "stepoutsider", "外部用户")
+	listSharedSteps := func(userID uint, userRoles []string, query string) map[string]interface{} {
+		return callJSONHandler(t, sharedHandler.GetSharedSteps, userID, userRoles, http.MethodGet, "/api/shared-steps"+query, nil, nil)
+	}
+
+	resp = listSharedSteps(admin.ID, roles, "")
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"], 3)
+	resp = listSharedSteps(member.ID, []string{"tester"}, "")
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"], 2)
+	resp = listSharedSteps(member.ID, []string{"tester"}, "?project_id="+strconv.FormatUint(uint64(project.ID), 10))
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"], 2)
+	assert.Equal(t, float64(403), listSharedSteps(member.ID, []string{"tester"}, "?project_id="+strconv.FormatUint(uint64(otherProject.ID), 10))["code"])
+	assert.Equal(t, float64(400), listSharedSteps(member.ID, []string{"tester"}, "?project_id=abc")["code"])
+	resp = listSharedSteps(outsider.ID, []string{"tester"}, "")
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	require.Len(t, resp["data"], 1)
+	assert.Equal(t, "通用步骤", resp["data"].([]interface{})[0].(map[string]interface{})["name"])
+
+	// 非项目成员即使有全局测试权限，也不能写入或删除其他项目的共享步骤库，不能查看测试单步骤
+	outsiderRoles := []string{"tester"}
+	resp = callJSONHandler(t, sharedHandler.CreateSharedStep, outsider.ID, outsiderRoles, http.MethodPost, "/api/shared-steps", nil, map[string]interface{}{
+		"name":       "越权步骤",
+		"project_id": project.ID,
+		"items":      []map[string]interface{}{{"action": "操作"}},
+	})
+	assert.Equal(t, float64(403), resp["code"])
+	resp = callJSONHandler(t, sharedHandler.UpdateSharedStep, outsider.ID, outsiderRoles, http.MethodPut, "/api/shared-steps", idParams(loginStepsID),
+		map[string]interface{}{"name": "改名"})
+	assert.Equal(t, float64(403), resp["code"])
+	resp = callJSONHandler(t, sharedHandler.DeleteSharedStep, outsider.ID, outsiderRoles, http.MethodDelete, "/api/shared-steps", idParams(loginStepsID), nil)
+	assert.Equal(t, float64(403), resp["code"])
+	var sharedCount int64
+	db.Model(&model.SharedStep{}).Where("id = ?", loginStepsID).Count(&sharedCount)
+	assert.Equal(t, int64(1), sharedCount)
+
+	assert.Equal(t, float64(403), callJSONHandler(t, handler.GetTestCaseSteps, outsider.ID, outsiderRoles, http.MethodGet, "/api/test-cases/steps", params, nil)["code"])
+	resp = callJSONHandler(t, handler.GetTestCaseSteps, member.ID, outsiderRoles, http.MethodGet, "/api/test-cases/steps", params, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+
+	// 步骤只能引用自己上传的或已属于该项目的附件
+	foreign := &model.Attachment{FileName: "secret.pdf", FilePath: "uploads/secret.pdf", FileSize: 10, CreatorID: outsider.ID}
+	require.NoError(t, db.Create(foreign).Error)
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{
+		"steps": []map[string]interface{}{{"action": "查看附件", "attachment_ids": []uint{foreign.ID}}},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+	resp = callJSONHandler(t, handler.UpdateTestCase, admin.ID, roles, http.MethodPut, "/api/test-cases", params, map[string]interface{}{
+		"steps": []map[string]interface{}{{"action": "查看截图", "attachment_ids": []uint{attachment.ID}}},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+}
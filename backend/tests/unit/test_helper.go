@@ -1,14 +1,20 @@
 package unit
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
 
 	"prjflow/internal/config"
 	"prjflow/internal/model"
@@ -130,7 +136,7 @@ func CreateTestUser(t *testing.T, db *gorm.DB, username, nickname string) *model
 func CreateTestTag(t *testing.T, db *gorm.DB, name string) *model.Tag {
 	// 确保标签名称唯一（添加时间戳）
 	uniqueName := name + "_" + time.Now().Format("20060102150405")
-	
+
 	// 检查是否已存在，如果存在则添加纳秒时间戳
 	var existingTag model.Tag
 	if err := db.Where("name = ?", uniqueName).First(&existingTag).Error; err == nil {
@@ -209,3 +215,33 @@ func AddUserToProject(t *testing.T, db *gorm.DB, userID, projectID uint, role st
 	return member
 }
 
+// idParams 构造 /:id 路由参数
+func idParams(id uint) gin.Params {
+	return gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(id), 10)}}
+}
+
+// callJSONHandler 以指定用户身份调用处理函数并返回解析后的JSON响应
+func callJSONHandler(t *testing.T, handler gin.HandlerFunc, userID uint, roles []string, method, path string, params gin.Params, body interface{}) map[string]interface{} {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("user_id", userID)
+	c.Set("roles", roles)
+
+	var reader *bytes.Buffer
+	if body != nil {
+		jsonData, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(jsonData)
+	} else {
+		reader = bytes.NewBuffer(nil)
+	}
+	c.Request = httptest.NewRequest(method, path, reader)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+
+	handler(c)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
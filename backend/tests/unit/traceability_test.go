@@ -0,0 +1,163 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestTraceability_MatrixAndExport(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	admin := CreateTestAdminUser(t, db, "traceadmin", "追溯管理员")
+	project := CreateTestProject(t, db, "追溯项目")
+	newRequirement := func(title string) *model.Requirement {
+		requirement := &model.Requirement{Title: title, ProjectID: project.ID, CreatorID: admin.ID, Status: "active", Level: "story"}
+		require.NoError(t, db.Create(requirement).Error)
+		return requirement
+	}
+	newBug := func(title string, requirementID *uint) *model.Bug {
+		bug := &model.Bug{Title: title, ProjectID: project.ID, CreatorID: admin.ID, Status: "active", RequirementID: requirementID}
+		require.NoError(t, db.Create(bug).Error)
+		return bug
+	}
+
+	// 登录：有任务、直接关联的测试单、Bug（Bug 又关联了测试单）；注册：没有测试；导出：不在版本中
+	login := newRequirement("登录")
+	register := newRequirement("注册")
+	export := newRequirement("导出")
+	require.NoError(t, db.Create(&model.Task{Title: "登录接口", ProjectID: project.ID, RequirementID: &login.ID, CreatorID: admin.ID, Status: "doing", Progress: 50}).Error)
+	loginBug := newBug("密码错误无提示", &login.ID)
+	orphanInVersion := newBug("首页白屏", nil)
+	newBug("旧版本问题", nil)
+	newBug("导出乱码", &export.ID)
+
+	direct := &model.TestCase{Name: "登录用例", ProjectID: project.ID, CreatorID: admin.ID, Status: "normal", Result: "passed", Requirements: []model.Requirement{*login}}
+	require.NoError(t, db.Create(direct).Error)
+	regression := &model.TestCase{Name: "回归用例", ProjectID: project.ID, CreatorID: admin.ID, Status: "normal", Bugs: []model.Bug{*loginBug}}
+	require.NoError(t, db.Create(regression).Error)
+
+	version := &model.Version{VersionNumber: "v1.0", ProjectID: project.ID, Status: "normal",
+		Requirements: []model.Requirement{*login, *register}, Bugs: []model.Bug{*orphanInVersion}}
+	require.NoError(t, db.Create(version).Error)
+
+	projectHandler := api.NewProjectHandler(db)
+	projectParams := idParams(project.ID)
+	resp := callJSONHandler(t, projectHandler.GetProjectTraceability, admin.ID, []string{"admin"}, http.MethodGet, "/api/projects/traceability", projectParams, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data := resp["data"].(map[string]interface{})
+	rows := data["rows"].([]interface{})
+	require.Len(t, rows, 3)
+	assert.Len(t, data["orphan_bugs"], 2)
+	summary := data["summary"].(map[string]interface{})
+	assert.Equal(t, float64(3), summary["requirement_count"])
+	assert.Equal(t, float64(2), summary["untested_requirements"])
+	assert.Equal(t, 33.33, summary["test_coverage"])
+
+	loginRow := rows[0].(map[string]interface{})
+	assert.Len(t, loginRow["tasks"], 1)
+	assert.Len(t, loginRow["bugs"], 1)
+	assert.Empty(t, loginRow["gaps"])
+	testCases := loginRow["test_cases"].([]interface{})
+	require.Len(t, testCases, 2)
+	assert.Equal(t, utils.TraceLinkDirect, testCases[0].(map[string]interface{})["link"])
+	assert.Equal(t, utils.TraceLinkBug, testCases[1].(map[string]interface{})["link"])
+	assert.Equal(t, "v1.0", loginRow["versions"].([]interface{})[0].(map[string]interface{})["version_number"])
+	assert.Equal(t, []interface{}{utils.TraceGapNoTestCase}, rows[1].(map[string]interface{})["gaps"])
+
+	// 非管理员：项目成员可以查看追溯矩阵，非项目成员不能
+	member := CreateTestUser(t, db, "tracemember", "成员")
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleViewer)
+	outsider := CreateTestUser(t, db, "traceoutsider", "外部用户")
+	resp = callJSONHandler(t, projectHandler.GetProjectTraceability, member.ID, []string{"developer"}, http.MethodGet, "/api/projects/traceability", projectParams, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Len(t, resp["data"].(map[string]interface{})["rows"], 3)
+	resp = callJSONHandler(t, projectHandler.GetProjectTraceability, outsider.ID, []string{"developer"}, http.MethodGet, "/api/projects/traceability", projectParams, nil)
+	assert.Equal(t, float64(403), resp["code"])
+
+	// 版本范围：只包含版本的需求、这些需求的 Bug 和版本关联的 Bug
+	versionParams := idParams(version.ID)
+	resp = callJSONHandler(t, api.NewVersionHandler(db).GetVersionTraceability, admin.ID, []string{"admin"}, http.MethodGet, "/api/versions/traceability", versionParams, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data = resp["data"].(map[string]interface{})
+	assert.Len(t, data["rows"], 2)
+	orphans := data["orphan_bugs"].([]interface{})
+	require.Len(t, orphans, 1)
+	assert.Equal(t, float64(orphanInVersion.ID), orphans[0].(map[string]interface{})["id"])
+	assert.Equal(t, float64(2), data["summary"].(map[string]interface{})["bug_count"])
+
+	download := func(format string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("user_id", admin.ID)
+		c.Set("roles", []string{"admin"})
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/projects/traceability?format="+format, nil)
+		c.Params = projectParams
+		projectHandler.GetProjectTraceability(c)
+		return w
+	}
+
+	w := download("csv")
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+	body := strings.TrimPrefix(w.Body.String(), "\xEF\xBB\xBF")
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 6)
+	assert.Equal(t, "需求ID", records[0][0])
+	assert.Equal(t, "登录", records[1][1])
+	assert.Contains(t, records[1][5], "#"+strconv.FormatUint(uint64(regression.ID), 10)+" 回归用例")
+	assert.Equal(t, "无测试覆盖", records[2][8])
+	assert.Equal(t, "无关联需求", records[5][8])
+
+	w = download("xlsx")
+	file, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	defer file.Close()
+	xlsxRows, err := file.GetRows("追溯矩阵")
+	require.NoError(t, err)
+	require.Len(t, xlsxRows, 6)
+	assert.Equal(t, records[1][5], xlsxRows[1][5])
+
+	// CSV 中以公式字符开头的单元格加单引号转义，XLSX 按文本写入不转义
+	formula := `=HYPERLINK("http://evil.example","注册")`
+	require.NoError(t, db.Model(register).Update("title", formula).Error)
+	w = download("csv")
+	records, err = csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\xEF\xBB\xBF"))).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "'"+formula, records[2][1])
+	assert.Equal(t, "登录", records[1][1])
+	w = download("xlsx")
+	formulaFile, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	defer formulaFile.Close()
+	xlsxRows, err = formulaFile.GetRows("追溯矩阵")
+	require.NoError(t, err)
+	assert.Equal(t, formula, xlsxRows[2][1])
+
+	// 以制表符、回车符开头的单元格同样转义
+	for _, title := range []string{"\t=1+1", "\r=1+1"} {
+		require.NoError(t, db.Model(register).Update("title", title).Error)
+		w = download("csv")
+		records, err = csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\xEF\xBB\xBF"))).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "'"+title, records[2][1])
+	}
+
+	w = download("pdf")
+	assert.Contains(t, w.Body.String(), "导出格式")
+}
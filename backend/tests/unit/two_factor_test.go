@@ -0,0 +1,303 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"prjflow/internal/api"
+	"prjflow/internal/middleware"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+// setupTwoFactorRouter 在会话测试路由的基础上注册两步验证相关接口
+func setupTwoFactorRouter(db *gorm.DB) *gin.Engine {
+	r := setupSessionRouter(db)
+	authHandler := api.NewAuthHandler(db)
+	userHandler := api.NewUserHandler(db)
+	systemHandler := api.NewSystemHandler(db)
+	r.POST("/api/auth/2fa/verify", authHandler.VerifyTwoFactorLogin)
+	r.POST("/api/auth/2fa/login-setup", authHandler.SetupTwoFactorLogin)
+	r.GET("/api/auth/2fa", middleware.Auth(), authHandler.GetTwoFactorStatus)
+	r.POST("/api/auth/2fa/setup", middleware.Auth(), authHandler.SetupTwoFactor)
+	r.POST("/api/auth/2fa/enable", middleware.Auth(), authHandler.EnableTwoFactor)
+	r.POST("/api/auth/2fa/disable", middleware.Auth(), authHandler.DisableTwoFactor)
+	r.POST("/api/auth/2fa/recovery-codes", middleware.Auth(), authHandler.RegenerateRecoveryCodes)
+	r.DELETE("/api/users/:id/two-factor", middleware.Auth(), userHandler.ResetUserTwoFactor)
+	r.PUT("/api/system/two-factor-policy", middleware.Auth(), systemHandler.SaveTwoFactorPolicy)
+	return r
+}
+
+// totpCodeAt 生成指定时间步的验证码
+func totpCodeAt(t *testing.T, secret string, step int64) string {
+	code, err := utils.GenerateTOTPCode(secret, step)
+	require.NoError(t, err)
+	return code
+}
+
+func TestTOTP_RFC6238(t *testing.T) {
+	// RFC 6238 附录B的测试密钥 "12345678901234567890"（取8位验证码的后6位）
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := utils.GenerateTOTPCode(secret, utils.TOTPStep(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "time=%d", unix)
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := utils.ValidateTOTPCode(secret, "005924", now, 0)
+	require.True(t, ok)
+	assert.Equal(t, utils.TOTPStep(now), step)
+
+	// 容忍前后一个时间步的时钟误差
+	_, ok = utils.ValidateTOTPCode(secret, "005924", now.Add(30*time.Second), 0)
+	assert.True(t, ok)
+	_, ok = utils.ValidateTOTPCode(secret, "005924", now.Add(90*time.Second), 0)
+	assert.False(t, ok)
+
+	// 已使用的时间步不能重放
+	_, ok = utils.ValidateTOTPCode(secret, "005924", now, step)
+	assert.False(t, ok)
+	_, ok = utils.ValidateTOTPCode(secret, "12345", now, 0)
+	assert.False(t, ok)
+
+	generated, err := utils.GenerateTOTPSecret()
+	require.NoError(t, err)
+	assert.Len(t, generated, 32)
+
+	uri := utils.TOTPProvisioningURI("PrjFlow", "alice", generated)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/PrjFlow:alice?"), uri)
+	assert.Contains(t, uri, "secret="+generated)
+	assert.Contains(t, uri, "issuer=PrjFlow")
+}
+
+func TestTwoFactor_EnrollAndLogin(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupTwoFactorRouter(db)
+
+	user := createSessionTestUser(t, db, "tfuser", "TwoFactor123")
+	token, _ := sessionLogin(t, r, "tfuser", "TwoFactor123")
+
+	// 生成密钥
+	resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/setup", token, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	setup := resp["data"].(map[string]interface{})
+	secret := setup["secret"].(string)
+	// 固定起始时间步，测试跨越时间步边界时仍在允许的误差范围内
+	step := utils.TOTPStep(time.Now())
+	assert.Contains(t, setup["otpauth_uri"], "otpauth://totp/")
+
+	// 未启用前登录不需要验证码
+	_, _ = sessionLogin(t, r, "tfuser", "TwoFactor123")
+
+	// 验证码错误无法启用
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/enable", token, map[string]interface{}{"code": "000000"})
+	assert.Equal(t, float64(400), resp["code"])
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/enable", token, map[string]interface{}{"code": totpCodeAt(t, secret, step)})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	recoveryCodes := resp["data"].(map[string]interface{})["recovery_codes"].([]interface{})
+	require.Len(t, recoveryCodes, utils.RecoveryCodeCount)
+
+	// 已启用后不能重新生成密钥
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/setup", token, nil)
+	assert.Equal(t, float64(400), resp["code"])
+
+	// 密码正确后返回临时Token，不创建会话
+	var sessionsBefore int64
+	db.Model(&model.UserSession{}).Where("user_id = ?", user.ID).Count(&sessionsBefore)
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "tfuser", "password": "TwoFactor123"})
+	require.Equal(t, float64(200), resp["code"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, true, data["two_factor_required"])
+	assert.Equal(t, false, data["two_factor_setup_required"])
+	assert.Nil(t, data["token"])
+	pendingToken := data["two_factor_token"].(string)
+	var sessionsAfter int64
+	db.Model(&model.UserSession{}).Where("user_id = ?", user.ID).Count(&sessionsAfter)
+	assert.Equal(t, sessionsBefore, sessionsAfter)
+
+	// 临时Token不能访问接口
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/user/info", pendingToken, nil)
+	assert.Equal(t, float64(401), resp["code"])
+
+	t.Run("验证码", func(t *testing.T) {
+		// 启用时使用过的验证码不能重放
+		resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/verify", "", map[string]interface{}{
+			"two_factor_token": pendingToken,
+			"code":             totpCodeAt(t, secret, step),
+		})
+		assert.Equal(t, float64(400), resp["code"])
+
+		// 验证码错误计入失败次数
+		var throttle model.LoginThrottle
+		require.NoError(t, db.Where("scope = ? AND identifier = ?", utils.LoginThrottleScopeUser, "tfuser").First(&throttle).Error)
+		assert.Equal(t, 1, throttle.Failures)
+		db.Model(&throttle).Update("next_attempt_at", nil)
+
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/verify", "", map[string]interface{}{
+			"two_factor_token": pendingToken,
+			"code":             totpCodeAt(t, secret, step+1),
+		})
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		data := resp["data"].(map[string]interface{})
+		assert.NotEmpty(t, data["token"])
+
+		var session model.UserSession
+		require.NoError(t, db.Where("user_id = ?", user.ID).Order("id DESC").First(&session).Error)
+		assert.Equal(t, "password_2fa", session.LoginMethod)
+	})
+
+	t.Run("恢复码", func(t *testing.T) {
+		recoveryCode := strings.ToUpper(recoveryCodes[0].(string))
+		resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/verify", "", map[string]interface{}{
+			"two_factor_token": pendingToken,
+			"recovery_code":    recoveryCode,
+		})
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		assert.Equal(t, float64(utils.RecoveryCodeCount-1), resp["data"].(map[string]interface{})["recovery_codes_remaining"])
+
+		// 恢复码只能使用一次
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/verify", "", map[string]interface{}{
+			"two_factor_token": pendingToken,
+			"recovery_code":    recoveryCode,
+		})
+		assert.Equal(t, float64(400), resp["code"])
+	})
+
+	t.Run("关闭两步验证", func(t *testing.T) {
+		require.NoError(t, utils.ClearLoginFailures(db, "tfuser"))
+
+		// 需要密码
+		resp := doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/disable", token, map[string]interface{}{
+			"recovery_code": recoveryCodes[1],
+		})
+		assert.Equal(t, float64(400), resp["code"])
+		assert.True(t, utils.IsTwoFactorEnabled(db, user.ID))
+
+		resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/disable", token, map[string]interface{}{
+			"password":      "TwoFactor123",
+			"recovery_code": recoveryCodes[1],
+		})
+		require.Equal(t, float64(200), resp["code"], resp["message"])
+		assert.False(t, utils.IsTwoFactorEnabled(db, user.ID))
+		assert.Equal(t, int64(0), utils.CountRecoveryCodes(db, user.ID))
+
+		_, _ = sessionLogin(t, r, "tfuser", "TwoFactor123")
+	})
+}
+
+func TestTwoFactor_RequiredPolicy(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	r := setupTwoFactorRouter(db)
+
+	var perm model.Permission
+	require.NoError(t, db.Where(model.Permission{Code: "permission:manage"}).Attrs(model.Permission{Name: "权限管理"}).FirstOrCreate(&perm).Error)
+	role := model.Role{Name: "安全管理员", Code: "security", Status: 1}
+	require.NoError(t, db.Create(&role).Error)
+	require.NoError(t, db.Model(&role).Association("Permissions").Append(&perm))
+
+	admin := createSessionTestUser(t, db, "policyadmin", "Policy1234")
+	manager := createSessionTestUser(t, db, "permmanager", "Manager123")
+	require.NoError(t, db.Model(manager).Association("Roles").Append(&role))
+	createSessionTestUser(t, db, "plainuser", "Plain12345")
+
+	adminToken, _ := sessionLogin(t, r, "policyadmin", "Policy1234")
+
+	// 不存在的权限代码被拒绝
+	resp := doSessionRequest(t, r, http.MethodPut, "/api/system/two-factor-policy", adminToken, map[string]interface{}{
+		"required_permissions": []string{"no:such"},
+	})
+	assert.Equal(t, float64(400), resp["code"])
+
+	resp = doSessionRequest(t, r, http.MethodPut, "/api/system/two-factor-policy", adminToken, map[string]interface{}{
+		"required_permissions": []string{"permission:manage"},
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, []string{"permission:manage"}, utils.GetTwoFactorPolicy(db).RequiredPermissions)
+
+	required, err := utils.IsTwoFactorRequired(db, manager.ID)
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	// 不受策略约束的用户正常登录
+	_, _ = sessionLogin(t, r, "plainuser", "Plain12345")
+
+	// 拥有权限的用户必须先绑定
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "permmanager", "password": "Manager123"})
+	require.Equal(t, float64(200), resp["code"])
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, true, data["two_factor_setup_required"])
+	pendingToken := data["two_factor_token"].(string)
+
+	// 尚未绑定时不能使用恢复码
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/verify", "", map[string]interface{}{
+		"two_factor_token": pendingToken,
+		"recovery_code":    "abcde-12345",
+	})
+	assert.NotEqual(t, float64(200), resp["code"])
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/login-setup", "", map[string]interface{}{"two_factor_token": pendingToken})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	secret := resp["data"].(map[string]interface{})["secret"].(string)
+	step := utils.TOTPStep(time.Now())
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/verify", "", map[string]interface{}{
+		"two_factor_token": pendingToken,
+		"code":             totpCodeAt(t, secret, step),
+	})
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	data = resp["data"].(map[string]interface{})
+	managerToken := data["token"].(string)
+	assert.Len(t, data["recovery_codes"], utils.RecoveryCodeCount)
+	assert.True(t, utils.IsTwoFactorEnabled(db, manager.ID))
+
+	resp = doSessionRequest(t, r, http.MethodGet, "/api/auth/2fa", managerToken, nil)
+	require.Equal(t, float64(200), resp["code"])
+	status := resp["data"].(map[string]interface{})
+	assert.Equal(t, true, status["enabled"])
+	assert.Equal(t, true, status["required"])
+
+	// 策略要求时不能自行关闭
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/2fa/disable", managerToken, map[string]interface{}{
+		"password": "Manager123",
+		"code":     totpCodeAt(t, secret, step+1),
+	})
+	assert.Equal(t, float64(403), resp["code"])
+
+	// 管理员重置后需要在下次登录时重新绑定
+	resp = doSessionRequest(t, r, http.MethodDelete, fmt.Sprintf("/api/users/%d/two-factor", manager.ID), adminToken, nil)
+	require.Equal(t, float64(200), resp["code"], resp["message"])
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["was_enabled"])
+	assert.False(t, utils.IsTwoFactorEnabled(db, manager.ID))
+
+	resp = doSessionRequest(t, r, http.MethodPost, "/api/auth/login", "", map[string]interface{}{"username": "permmanager", "password": "Manager123"})
+	require.Equal(t, float64(200), resp["code"])
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["two_factor_setup_required"])
+
+	// 按角色要求：管理员拥有所有权限，同样需要两步验证
+	require.NoError(t, utils.SaveTwoFactorPolicy(db, utils.TwoFactorPolicy{RequiredRoles: []string{"admin"}}))
+	require.NoError(t, db.Model(admin).Association("Roles").Append(CreateTestAdminRole(t, db)))
+	required, err = utils.IsTwoFactorRequired(db, admin.ID)
+	require.NoError(t, err)
+	assert.True(t, required)
+	required, err = utils.IsTwoFactorRequired(db, manager.ID)
+	require.NoError(t, err)
+	assert.False(t, required)
+}
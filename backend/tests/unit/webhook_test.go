@@ -0,0 +1,265 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/config"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+// webhookReceiver 记录收到的Webhook请求
+type webhookReceiver struct {
+	mu         sync.Mutex
+	statusCode int
+	requests   []*http.Request
+	bodies     [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	code := r.statusCode
+	r.mu.Unlock()
+	w.WriteHeader(code)
+}
+
+func TestWebhookSignatureAndBackoff(t *testing.T) {
+	sig := utils.SignWebhookPayload("secret", []byte(`{"a":1}`))
+	assert.Equal(t, "sha256=", sig[:7])
+	assert.Equal(t, sig, utils.SignWebhookPayload("secret", []byte(`{"a":1}`)))
+	assert.NotEqual(t, sig, utils.SignWebhookPayload("other", []byte(`{"a":1}`)))
+
+	assert.Equal(t, 30*time.Second, utils.WebhookBackoff(1))
+	assert.Equal(t, 60*time.Second, utils.WebhookBackoff(2))
+	assert.Equal(t, 240*time.Second, utils.WebhookBackoff(4))
+	assert.Equal(t, time.Hour, utils.WebhookBackoff(20))
+}
+
+// allowPrivateWebhookTargets 允许推送到本机地址（测试接收端运行在 127.0.0.1）
+func allowPrivateWebhookTargets(t *testing.T, allow bool) {
+	previous := config.AppConfig.Webhook.AllowPrivateTargets
+	config.AppConfig.Webhook.AllowPrivateTargets = allow
+	t.Cleanup(func() { config.AppConfig.Webhook.AllowPrivateTargets = previous })
+}
+
+func TestWebhookTargetValidation(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	allowPrivateWebhookTargets(t, false)
+
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		assert.ErrorIs(t, utils.CheckWebhookTarget(target), utils.ErrWebhookTargetForbidden, target)
+	}
+	assert.Error(t, utils.CheckWebhookTarget("ftp://93.184.216.34/hook"))
+	assert.NoError(t, utils.CheckWebhookTarget("https://93.184.216.34/hook"))
+
+	receiver := &webhookReceiver{statusCode: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	project := CreateTestProject(t, db, "Webhook SSRF项目")
+	owner := CreateTestUser(t, db, "webhookssrfowner", "项目负责人")
+	AddUserToProject(t, db, owner.ID, project.ID, "owner")
+	projectParams := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}
+
+	t.Run("不能保存指向本机的地址", func(t *testing.T) {
+		response := callJSONHandler(t, api.NewWebhookHandler(db).CreateProjectWebhook, owner.ID, []string{"developer"}, http.MethodPost, "/api/projects/webhooks", projectParams, map[string]interface{}{
+			"name":   "内网探测",
+			"url":    server.URL,
+			"events": []string{"bug.created"},
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	t.Run("投递时再次检查实际连接的地址", func(t *testing.T) {
+		webhook := &model.Webhook{ProjectID: project.ID, Name: "已保存的内网地址", URL: server.URL, Events: model.StringArray{"*"}, Status: 1}
+		require.NoError(t, db.Create(webhook).Error)
+		delivery := &model.WebhookDelivery{WebhookID: webhook.ID, EventType: "bug.created", EventID: "ssrf", Payload: "{}", Status: utils.WebhookDeliveryPending}
+		require.NoError(t, db.Create(delivery).Error)
+
+		require.NoError(t, utils.DeliverWebhook(db, delivery))
+		assert.Contains(t, delivery.Error, utils.ErrWebhookTargetForbidden.Error())
+		assert.Empty(t, receiver.requests)
+	})
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+	allowPrivateWebhookTargets(t, true)
+
+	receiver := &webhookReceiver{statusCode: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	project := CreateTestProject(t, db, "Webhook项目")
+	admin := CreateTestAdminUser(t, db, "webhookadmin", "Webhook管理员")
+	owner := CreateTestUser(t, db, "webhookowner", "项目负责人")
+	member := CreateTestUser(t, db, "webhookmember", "项目成员")
+	AddUserToProject(t, db, owner.ID, project.ID, "owner")
+	AddUserToProject(t, db, member.ID, project.ID, "member")
+
+	handler := api.NewWebhookHandler(db)
+	projectParams := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", project.ID)}}
+
+	t.Run("普通成员不能创建Webhook", func(t *testing.T) {
+		response := callJSONHandler(t, handler.CreateProjectWebhook, member.ID, []string{"developer"}, http.MethodPost, "/api/projects/webhooks", projectParams, map[string]interface{}{
+			"name":   "CI",
+			"url":    server.URL,
+			"events": []string{"bug.created"},
+		})
+		assert.Equal(t, float64(403), response["code"])
+	})
+
+	t.Run("历史数据中的项目经理按负责人处理", func(t *testing.T) {
+		manager := CreateTestUser(t, db, "webhookmanager", "项目经理")
+		AddUserToProject(t, db, manager.ID, project.ID, "项目经理")
+		response := callJSONHandler(t, handler.GetProjectWebhooks, manager.ID, []string{"developer"}, http.MethodGet, "/api/projects/webhooks", projectParams, nil)
+		assert.Equal(t, float64(200), response["code"], response["message"])
+	})
+
+	t.Run("不支持的事件类型", func(t *testing.T) {
+		response := callJSONHandler(t, handler.CreateProjectWebhook, owner.ID, []string{"developer"}, http.MethodPost, "/api/projects/webhooks", projectParams, map[string]interface{}{
+			"name":   "CI",
+			"url":    server.URL,
+			"events": []string{"bug.exploded"},
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	// 项目负责人创建Webhook
+	response := callJSONHandler(t, handler.CreateProjectWebhook, owner.ID, []string{"developer"}, http.MethodPost, "/api/projects/webhooks", projectParams, map[string]interface{}{
+		"name":   "聊天机器人",
+		"url":    server.URL,
+		"secret": "s3cret",
+		"events": []string{"bug.created", "version.released"},
+	})
+	require.Equal(t, float64(200), response["code"], response["message"])
+	data := response["data"].(map[string]interface{})
+	assert.Equal(t, "s3cret", data["secret"])
+	webhookID := uint(data["webhook"].(map[string]interface{})["id"].(float64))
+
+	bug := &model.Bug{Title: "Webhook Bug", ProjectID: project.ID, CreatorID: admin.ID, Status: "active", Priority: "high", Severity: "normal"}
+	require.NoError(t, db.Create(bug).Error)
+
+	t.Run("未订阅的事件不投递", func(t *testing.T) {
+		actionID, err := utils.RecordAction(db, "bug", bug.ID, "commented", admin.ID, "评论", nil)
+		require.NoError(t, err)
+		var action model.Action
+		db.First(&action, actionID)
+		count, err := utils.EnqueueWebhookDeliveries(db, &action)
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("订阅的事件签名投递成功", func(t *testing.T) {
+		actionID, err := utils.RecordAction(db, "bug", bug.ID, "created", admin.ID, "", nil)
+		require.NoError(t, err)
+		var action model.Action
+		db.First(&action, actionID)
+		count, err := utils.EnqueueWebhookDeliveries(db, &action)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		assert.Equal(t, 1, utils.ProcessWebhookQueue(db))
+
+		receiver.mu.Lock()
+		require.Len(t, receiver.requests, 1)
+		req := receiver.requests[0]
+		body := receiver.bodies[0]
+		receiver.mu.Unlock()
+
+		assert.Equal(t, "bug.created", req.Header.Get(utils.WebhookEventHeader))
+		assert.Equal(t, utils.SignWebhookPayload("s3cret", body), req.Header.Get(utils.WebhookSignatureHeader))
+
+		var payload utils.WebhookPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, "bug.created", payload.Event)
+		assert.Equal(t, bug.ID, payload.ObjectID)
+		assert.Equal(t, project.ID, payload.ProjectID)
+		assert.Equal(t, req.Header.Get(utils.WebhookDeliveryHeader), payload.ID)
+
+		var delivery model.WebhookDelivery
+		require.NoError(t, db.Where("webhook_id = ?", webhookID).Order("id DESC").First(&delivery).Error)
+		assert.Equal(t, utils.WebhookDeliverySuccess, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusOK, delivery.ResponseCode)
+	})
+
+	t.Run("失败后按退避重试并可手动重新投递", func(t *testing.T) {
+		receiver.mu.Lock()
+		receiver.statusCode = http.StatusInternalServerError
+		receiver.mu.Unlock()
+
+		version := &model.Version{VersionNumber: "v1.0.0", ProjectID: project.ID, Status: "normal"}
+		require.NoError(t, db.Create(version).Error)
+		actionID, err := utils.RecordAction(db, "version", version.ID, "released", admin.ID, "", nil)
+		require.NoError(t, err)
+		var action model.Action
+		db.First(&action, actionID)
+		assert.Equal(t, project.ID, action.ProjectID)
+		_, err = utils.EnqueueWebhookDeliveries(db, &action)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, utils.ProcessWebhookQueue(db))
+
+		var delivery model.WebhookDelivery
+		require.NoError(t, db.Where("webhook_id = ? AND event_type = ?", webhookID, "version.released").First(&delivery).Error)
+		assert.Equal(t, utils.WebhookDeliveryPending, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusInternalServerError, delivery.ResponseCode)
+		require.NotNil(t, delivery.NextAttemptAt)
+		assert.True(t, delivery.NextAttemptAt.After(time.Now().Add(20*time.Second)))
+
+		// 未到重试时间不会再次投递
+		assert.Equal(t, 0, utils.ProcessWebhookQueue(db))
+
+		receiver.mu.Lock()
+		receiver.statusCode = http.StatusOK
+		receiver.mu.Unlock()
+
+		params := gin.Params{
+			gin.Param{Key: "id", Value: fmt.Sprintf("%d", webhookID)},
+			gin.Param{Key: "delivery_id", Value: fmt.Sprintf("%d", delivery.ID)},
+		}
+		response := callJSONHandler(t, handler.RedeliverWebhook, owner.ID, []string{"developer"}, http.MethodPost, "/api/webhooks/deliveries/redeliver", params, nil)
+		require.Equal(t, float64(200), response["code"], response["message"])
+		redelivery := response["data"].(map[string]interface{})
+		assert.Equal(t, utils.WebhookDeliverySuccess, redelivery["status"])
+		assert.Equal(t, delivery.EventID, redelivery["event_id"])
+		assert.Equal(t, true, redelivery["redeliver"])
+	})
+
+	t.Run("投递记录列表", func(t *testing.T) {
+		params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", webhookID)}}
+		response := callJSONHandler(t, handler.GetWebhookDeliveries, owner.ID, []string{"developer"}, http.MethodGet, "/api/webhooks/deliveries", params, nil)
+		require.Equal(t, float64(200), response["code"])
+		assert.Equal(t, float64(3), response["data"].(map[string]interface{})["total"])
+
+		response = callJSONHandler(t, handler.GetWebhookDeliveries, member.ID, []string{"developer"}, http.MethodGet, "/api/webhooks/deliveries", params, nil)
+		assert.Equal(t, float64(403), response["code"])
+	})
+}
@@ -0,0 +1,385 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"prjflow/internal/api"
+	"prjflow/internal/model"
+	"prjflow/internal/utils"
+)
+
+func TestWorkflow_DefaultBugRules(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "默认工作流项目")
+
+	// 默认工作流已初始化
+	var count int64
+	db.Model(&model.Workflow{}).Where("project_id IS NULL").Count(&count)
+	assert.Equal(t, int64(3), count)
+
+	assert.NoError(t, utils.ValidateWorkflowTransition(db, nil, "bug", project.ID, "active", "resolved", nil))
+	assert.NoError(t, utils.ValidateWorkflowTransition(db, nil, "bug", project.ID, "resolved", "closed", nil))
+	assert.NoError(t, utils.ValidateWorkflowTransition(db, nil, "bug", project.ID, "closed", "active", nil))
+	assert.Error(t, utils.ValidateWorkflowTransition(db, nil, "bug", project.ID, "active", "closed", nil))
+	assert.Error(t, utils.ValidateWorkflowTransition(db, nil, "bug", project.ID, "active", "verifying", nil))
+
+	// 任务和需求默认允许任意流转
+	assert.NoError(t, utils.ValidateWorkflowTransition(db, nil, "task", project.ID, "done", "wait", nil))
+	assert.NoError(t, utils.ValidateWorkflowTransition(db, nil, "requirement", project.ID, "closed", "draft", nil))
+	assert.Error(t, utils.ValidateWorkflowTransition(db, nil, "requirement", project.ID, "draft", "invalid", nil))
+
+	// 任务的取消状态属于 cancelled 分类：视为已结束，但不算完成交付
+	assert.Equal(t, []string{"cancel"}, utils.CancelledStatuses(db, "task", project.ID))
+	assert.True(t, utils.IsWorkItemFinished(db, "task", project.ID, "cancel"))
+}
+
+func TestWorkflow_InitMigratesCancelCategory(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	// 早期版本初始化的全局任务工作流把取消状态归为 done 分类
+	var workflow model.Workflow
+	require.NoError(t, db.Where("object_type = ? AND project_id IS NULL", "task").First(&workflow).Error)
+	require.NoError(t, db.Model(&model.WorkflowState{}).Where("workflow_id = ? AND code = ?", workflow.ID, "cancel").Update("category", "done").Error)
+	assert.Empty(t, utils.CancelledStatuses(db, "task", 0))
+
+	require.NoError(t, utils.InitDefaultWorkflows(db))
+	assert.Equal(t, []string{"cancel"}, utils.CancelledStatuses(db, "task", 0))
+	var count int64
+	db.Model(&model.Workflow{}).Where("project_id IS NULL").Count(&count)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestWorkflow_ProjectVerifyingState(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "QA工作流项目")
+	otherProject := CreateTestProject(t, db, "其他项目")
+	admin := CreateTestAdminUser(t, db, "workflowadmin", "工作流管理员")
+	tester := CreateTestUser(t, db, "workflowtester", "测试人员")
+	developer := CreateTestUser(t, db, "workflowdev", "开发人员")
+	AddUserToProject(t, db, tester.ID, project.ID, "member")
+	AddUserToProject(t, db, developer.ID, project.ID, "member")
+
+	workflowHandler := api.NewWorkflowHandler(db)
+	bugHandler := api.NewBugHandler(db)
+
+	// 为项目创建带 verifying 状态的Bug工作流：resolved -> verifying -> closed，验证只能由测试角色执行且需要备注
+	response := callJSONHandler(t, workflowHandler.CreateWorkflow, admin.ID, []string{"admin"}, http.MethodPost, "/api/workflows", nil, map[string]interface{}{
+		"name":        "QA验证工作流",
+		"object_type": "bug",
+		"project_id":  project.ID,
+		"states": []map[string]interface{}{
+			{"code": "active", "name": "激活", "category": "open"},
+			{"code": "resolved", "name": "已解决", "category": "doing"},
+			{"code": "verifying", "name": "验证中", "category": "doing"},
+			{"code": "closed", "name": "已关闭", "category": "done"},
+		},
+		"transitions": []map[string]interface{}{
+			{"from_state": "active", "to_state": "resolved"},
+			{"from_state": "resolved", "to_state": "verifying"},
+			{"from_state": "verifying", "to_state": "closed", "allowed_roles": []string{"tester"}, "required_fields": []string{"comment"}},
+			{"from_state": "verifying", "to_state": "active"},
+			{"from_state": "closed", "to_state": "active"},
+		},
+	})
+	require.Equal(t, float64(200), response["code"], response["message"])
+
+	bug := &model.Bug{
+		Title:     "需要验证的Bug",
+		ProjectID: project.ID,
+		CreatorID: developer.ID,
+		Status:    "resolved",
+		Priority:  "high",
+		Severity:  "normal",
+	}
+	require.NoError(t, db.Create(bug).Error)
+	params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", bug.ID)}}
+	statusPath := fmt.Sprintf("/api/bugs/%d/status", bug.ID)
+
+	t.Run("不允许跳过验证直接关闭", func(t *testing.T) {
+		response := callJSONHandler(t, bugHandler.UpdateBugStatus, developer.ID, []string{"developer"}, http.MethodPut, statusPath, params, map[string]interface{}{
+			"status": "closed",
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	t.Run("resolved流转到verifying", func(t *testing.T) {
+		response := callJSONHandler(t, bugHandler.UpdateBugStatus, developer.ID, []string{"developer"}, http.MethodPut, statusPath, params, map[string]interface{}{
+			"status": "verifying",
+		})
+		assert.Equal(t, float64(200), response["code"], response["message"])
+
+		var updated model.Bug
+		db.First(&updated, bug.ID)
+		assert.Equal(t, "verifying", updated.Status)
+	})
+
+	t.Run("非测试角色不能关闭", func(t *testing.T) {
+		response := callJSONHandler(t, bugHandler.UpdateBugStatus, developer.ID, []string{"developer"}, http.MethodPut, statusPath, params, map[string]interface{}{
+			"status":  "closed",
+			"comment": "验证通过",
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	t.Run("缺少必填字段不能关闭", func(t *testing.T) {
+		response := callJSONHandler(t, bugHandler.UpdateBugStatus, tester.ID, []string{"tester"}, http.MethodPut, statusPath, params, map[string]interface{}{
+			"status": "closed",
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	t.Run("测试角色填写备注后关闭", func(t *testing.T) {
+		response := callJSONHandler(t, bugHandler.UpdateBugStatus, tester.ID, []string{"tester"}, http.MethodPut, statusPath, params, map[string]interface{}{
+			"status":  "closed",
+			"comment": "验证通过",
+		})
+		assert.Equal(t, float64(200), response["code"], response["message"])
+
+		var updated model.Bug
+		db.First(&updated, bug.ID)
+		assert.Equal(t, "closed", updated.Status)
+	})
+
+	t.Run("其他项目仍使用默认工作流", func(t *testing.T) {
+		assert.Error(t, utils.ValidateWorkflowTransition(db, nil, "bug", otherProject.ID, "resolved", "verifying", nil))
+		assert.NoError(t, utils.ValidateWorkflowTransition(db, nil, "bug", otherProject.ID, "resolved", "closed", nil))
+	})
+
+	t.Run("获取可用流转", func(t *testing.T) {
+		response := callJSONHandler(t, workflowHandler.GetEffectiveWorkflow, developer.ID, []string{"developer"}, http.MethodGet,
+			fmt.Sprintf("/api/workflows/effective?object_type=bug&project_id=%d&from=verifying", project.ID), nil, nil)
+		require.Equal(t, float64(200), response["code"])
+		data := response["data"].(map[string]interface{})
+		transitions := data["transitions"].([]interface{})
+		// 开发人员只能重新激活，不能关闭
+		require.Len(t, transitions, 1)
+		assert.Equal(t, "active", transitions[0].(map[string]interface{})["to_state"])
+	})
+}
+
+func TestWorkflowHandler_Validation(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "工作流校验项目")
+	admin := CreateTestAdminUser(t, db, "wfvalidadmin", "管理员")
+	user := CreateTestUser(t, db, "wfvaliduser", "普通用户")
+	handler := api.NewWorkflowHandler(db)
+
+	t.Run("流转引用不存在的状态", func(t *testing.T) {
+		response := callJSONHandler(t, handler.CreateWorkflow, admin.ID, []string{"admin"}, http.MethodPost, "/api/workflows", nil, map[string]interface{}{
+			"name":        "错误工作流",
+			"object_type": "task",
+			"project_id":  project.ID,
+			"states":      []map[string]interface{}{{"code": "wait"}, {"code": "done"}},
+			"transitions": []map[string]interface{}{{"from_state": "wait", "to_state": "doing"}},
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	t.Run("普通用户不能修改全局工作流", func(t *testing.T) {
+		var global model.Workflow
+		require.NoError(t, db.Where("object_type = ? AND project_id IS NULL", "task").First(&global).Error)
+		response := callJSONHandler(t, handler.UpdateWorkflow, user.ID, []string{"developer"}, http.MethodPut,
+			fmt.Sprintf("/api/workflows/%d", global.ID), gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", global.ID)}}, map[string]interface{}{
+				"name":        "修改",
+				"object_type": "task",
+				"states":      []map[string]interface{}{{"code": "wait"}},
+			})
+		assert.Equal(t, float64(403), response["code"])
+	})
+
+	t.Run("任务状态接口使用工作流", func(t *testing.T) {
+		response := callJSONHandler(t, handler.CreateWorkflow, admin.ID, []string{"admin"}, http.MethodPost, "/api/workflows", nil, map[string]interface{}{
+			"name":        "简单任务工作流",
+			"object_type": "task",
+			"project_id":  project.ID,
+			"states":      []map[string]interface{}{{"code": "wait"}, {"code": "doing"}, {"code": "done", "category": "done"}},
+			"transitions": []map[string]interface{}{{"from_state": "wait", "to_state": "doing"}, {"from_state": "doing", "to_state": "done"}},
+		})
+		require.Equal(t, float64(200), response["code"], response["message"])
+
+		task := &model.Task{Title: "工作流任务", ProjectID: project.ID, CreatorID: admin.ID, Status: "wait", Priority: "medium"}
+		require.NoError(t, db.Create(task).Error)
+		taskHandler := api.NewTaskHandler(db)
+		params := gin.Params{gin.Param{Key: "id", Value: fmt.Sprintf("%d", task.ID)}}
+
+		response = callJSONHandler(t, taskHandler.UpdateTaskStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/status", params, map[string]interface{}{"status": "done"})
+		assert.Equal(t, float64(400), response["code"])
+
+		response = callJSONHandler(t, taskHandler.UpdateTaskStatus, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/status", params, map[string]interface{}{"status": "doing"})
+		assert.Equal(t, float64(200), response["code"], response["message"])
+	})
+}
+
+func TestWorkflow_InitialStateAndBoardMove(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "工作流看板项目")
+	admin := CreateTestAdminUser(t, db, "wfboardadmin", "管理员")
+	roles := []string{"admin"}
+
+	// 任务工作流：wait -> doing -> done，完成时必须填写描述
+	response := callJSONHandler(t, api.NewWorkflowHandler(db).CreateWorkflow, admin.ID, roles, http.MethodPost, "/api/workflows", nil, map[string]interface{}{
+		"name":          "看板任务工作流",
+		"object_type":   "task",
+		"project_id":    project.ID,
+		"initial_state": "wait",
+		"states":        []map[string]interface{}{{"code": "wait"}, {"code": "doing"}, {"code": "done", "category": "done"}},
+		"transitions": []map[string]interface{}{
+			{"from_state": "wait", "to_state": "doing"},
+			{"from_state": "doing", "to_state": "done", "required_fields": []string{"description"}},
+		},
+	})
+	require.Equal(t, float64(200), response["code"], response["message"])
+
+	taskHandler := api.NewTaskHandler(db)
+	t.Run("新建任务必须是初始状态", func(t *testing.T) {
+		response := callJSONHandler(t, taskHandler.CreateTask, admin.ID, roles, http.MethodPost, "/api/tasks", nil, map[string]interface{}{
+			"title": "跳过初始状态", "project_id": project.ID, "status": "doing",
+		})
+		assert.Equal(t, float64(400), response["code"])
+	})
+
+	response = callJSONHandler(t, taskHandler.CreateTask, admin.ID, roles, http.MethodPost, "/api/tasks", nil, map[string]interface{}{
+		"title": "看板任务", "project_id": project.ID,
+	})
+	require.Equal(t, float64(200), response["code"], response["message"])
+	task := response["data"].(map[string]interface{})
+	assert.Equal(t, "wait", task["status"])
+	taskID := fmt.Sprintf("%d", uint(task["id"].(float64)))
+
+	board := &model.Board{Name: "工作流看板", ProjectID: project.ID}
+	require.NoError(t, db.Create(board).Error)
+	doing := &model.BoardColumn{Name: "进行中", BoardID: board.ID, Status: "doing", Sort: 1}
+	done := &model.BoardColumn{Name: "已完成", BoardID: board.ID, Status: "done", Sort: 2}
+	require.NoError(t, db.Create(doing).Error)
+	require.NoError(t, db.Create(done).Error)
+	moveParams := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", board.ID)}, {Key: "task_id", Value: taskID}}
+	boardHandler := api.NewBoardHandler(db)
+
+	t.Run("看板拖动不能跳过工作流", func(t *testing.T) {
+		response := callJSONHandler(t, boardHandler.MoveTask, admin.ID, roles, http.MethodPut, "/api/boards/move", moveParams, map[string]interface{}{
+			"column_id": fmt.Sprintf("%d", done.ID),
+		})
+		assert.Equal(t, float64(400), response["code"])
+
+		response = callJSONHandler(t, boardHandler.MoveTask, admin.ID, roles, http.MethodPut, "/api/boards/move", moveParams, map[string]interface{}{
+			"column_id": fmt.Sprintf("%d", doing.ID),
+		})
+		assert.Equal(t, float64(200), response["code"], response["message"])
+	})
+
+	t.Run("更新接口使用请求字段校验必填字段", func(t *testing.T) {
+		params := gin.Params{{Key: "id", Value: taskID}}
+		response := callJSONHandler(t, taskHandler.UpdateTask, admin.ID, roles, http.MethodPut, "/api/tasks", params, map[string]interface{}{"status": "done"})
+		assert.Equal(t, float64(400), response["code"])
+
+		response = callJSONHandler(t, taskHandler.UpdateTask, admin.ID, roles, http.MethodPut, "/api/tasks", params, map[string]interface{}{
+			"status": "done", "description": "已完成联调",
+		})
+		assert.Equal(t, float64(200), response["code"], response["message"])
+	})
+}
+
+func TestWorkflow_ManageRoleAndAutoTransitions(t *testing.T) {
+	db := SetupTestDB(t)
+	defer TeardownTestDB(t, db)
+
+	project := CreateTestProject(t, db, "自动流转项目")
+	admin := CreateTestAdminUser(t, db, "autoflowadmin", "管理员")
+	owner := CreateTestUser(t, db, "autoflowowner", "负责人")
+	member := CreateTestUser(t, db, "autoflowmember", "成员")
+	AddUserToProject(t, db, owner.ID, project.ID, utils.ProjectRoleOwner)
+	AddUserToProject(t, db, member.ID, project.ID, utils.ProjectRoleMember)
+	roles := []string{"project_manager"}
+
+	// 项目工作流只能由项目负责人（project:manage）维护
+	body := map[string]interface{}{
+		"name":          "负责人开始的任务工作流",
+		"object_type":   "task",
+		"project_id":    project.ID,
+		"initial_state": "wait",
+		"states": []map[string]interface{}{
+			{"code": "wait"}, {"code": "doing", "category": "doing"},
+			{"code": "done", "category": "done"}, {"code": "cancel", "category": "cancelled"},
+		},
+		"transitions": []map[string]interface{}{
+			{"from_state": "wait", "to_state": "doing", "allowed_roles": []string{"project:owner"}},
+			{"from_state": "doing", "to_state": "done", "required_fields": []string{"description"}},
+			{"from_state": "*", "to_state": "cancel"},
+		},
+	}
+	workflowHandler := api.NewWorkflowHandler(db)
+	response := callJSONHandler(t, workflowHandler.CreateWorkflow, member.ID, roles, http.MethodPost, "/api/workflows", nil, body)
+	assert.Equal(t, float64(403), response["code"])
+	response = callJSONHandler(t, workflowHandler.CreateWorkflow, owner.ID, roles, http.MethodPost, "/api/workflows", nil, body)
+	require.Equal(t, float64(200), response["code"], response["message"])
+	workflowParams := idParams(uint(response["data"].(map[string]interface{})["id"].(float64)))
+	response = callJSONHandler(t, workflowHandler.UpdateWorkflow, member.ID, roles, http.MethodPut, "/api/workflows", workflowParams, map[string]interface{}{"name": "改名"})
+	assert.Equal(t, float64(403), response["code"])
+
+	newTask := func(status string) *model.Task {
+		task := &model.Task{Title: "自动流转任务", ProjectID: project.ID, CreatorID: owner.ID, Status: status}
+		require.NoError(t, db.Create(task).Error)
+		return task
+	}
+	status := func(task *model.Task) string {
+		var current model.Task
+		require.NoError(t, db.First(&current, task.ID).Error)
+		return current.Status
+	}
+	taskHandler := api.NewTaskHandler(db)
+	assign := func(userID uint, task *model.Task) {
+		response := callJSONHandler(t, taskHandler.AssignTask, userID, roles, http.MethodPost, "/api/tasks/assign", idParams(task.ID), map[string]interface{}{"assignee_id": member.ID})
+		require.Equal(t, float64(200), response["code"], response["message"])
+	}
+	progress := func(task *model.Task, value int) {
+		response := callJSONHandler(t, taskHandler.UpdateTaskProgress, admin.ID, []string{"admin"}, http.MethodPatch, "/api/tasks/progress", idParams(task.ID), map[string]interface{}{"progress": value})
+		require.Equal(t, float64(200), response["code"], response["message"])
+	}
+
+	// 指派后自动开始也受流转的角色限制
+	task := newTask("wait")
+	assign(member.ID, task)
+	assert.Equal(t, "wait", status(task))
+	assign(owner.ID, task)
+	assert.Equal(t, "doing", status(task))
+
+	// 进度达到100%时，完成流转缺少必填字段就不自动完成
+	progress(task, 100)
+	assert.Equal(t, "doing", status(task))
+
+	// 已取消的任务不会被进度自动改回完成或进行中
+	cancelled := newTask("cancel")
+	progress(cancelled, 100)
+	assert.Equal(t, "cancel", status(cancelled))
+
+	// 需求工作流中没有 active 状态时，指派不自动激活
+	requirementWorkflow := model.Workflow{Name: "无激活的需求工作流", ObjectType: "requirement", ProjectID: &project.ID, InitialState: "draft", Status: 1,
+		States: []model.WorkflowState{
+			{Code: "draft", Name: "草稿", Category: utils.WorkflowCategoryOpen, Sort: 0},
+			{Code: "approved", Name: "已批准", Category: utils.WorkflowCategoryDoing, Sort: 1},
+		},
+		Transitions: []model.WorkflowTransition{{FromState: "draft", ToState: "approved"}}}
+	require.NoError(t, db.Create(&requirementWorkflow).Error)
+	requirement := &model.Requirement{Title: "自动激活", ProjectID: project.ID, CreatorID: owner.ID, Status: "draft"}
+	require.NoError(t, db.Create(requirement).Error)
+	response = callJSONHandler(t, api.NewRequirementHandler(db).AssignRequirement, owner.ID, roles, http.MethodPost, "/api/requirements/assign", idParams(requirement.ID), map[string]interface{}{"assignee_id": member.ID})
+	require.Equal(t, float64(200), response["code"], response["message"])
+	var current model.Requirement
+	require.NoError(t, db.First(&current, requirement.ID).Error)
+	assert.Equal(t, "draft", current.Status)
+}
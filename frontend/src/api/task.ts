@@ -125,7 +125,7 @@ export interface Action {
     username: string
     nickname?: string
   }
-  action: 'created' | 'edited' | 'assigned' | 'resolved' | 'closed' | 'confirmed' | 'commented' | 'status_changed' | 'progress_updated'
+  action: 'created' | 'edited' | 'assigned' | 'resolved' | 'closed' | 'confirmed' | 'commented' | 'status_changed' | 'progress_updated' | 'moved'
   date: string
   comment?: string
   extra?: string